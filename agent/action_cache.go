@@ -1,12 +1,20 @@
 package agent
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	"stats-agent/metrics"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
 )
 
 // ActionSignature uniquely identifies a statistical operation
@@ -84,32 +92,156 @@ type ActionResult struct {
     // CodeNormHash stores a whitespace-insensitive hash of the executed code
     // so we can enforce exact-phrase hysteresis before skipping repeats.
     CodeNormHash string
+    // Diagnostics holds compact model diagnostics (e.g., "aic=812.4,bic=825.1")
+    // surfaced in the done ledger for time-series actions.
+    Diagnostics string
+    // UserEdited marks actions run (or superseded) by a user edit of an assistant code block.
+    UserEdited bool
+    // SupersededCodeHash is the normalized hash of the original code a user edit replaced;
+    // proposals matching it are treated as repeats.
+    SupersededCodeHash string
+}
+
+// ActionStore persists executed actions so repeat detection and done-ledgers survive
+// restarts and are shared between replicas.
+type ActionStore interface {
+	SaveActionResult(ctx context.Context, record types.ActionRecord) error
+	GetActionResults(ctx context.Context, sessionID string) ([]types.ActionRecord, error)
+	DeleteActionResults(ctx context.Context, sessionID string) error
 }
 
+// actionStoreTimeout bounds each action store call; the cache falls back to memory on failure.
+const actionStoreTimeout = 5 * time.Second
+
 // ActionCache tracks executed actions to prevent repeats
 type ActionCache struct {
+	mu sync.Mutex
+
 	// Key: signature hash → result
 	completed map[string]*ActionResult
 
 	// Track last N actions (sliding window for repeat detection)
 	recentActions []ActionSignature
 	windowSize    int
+
+	// A test run on at least this many variable sets is one ledger entry (0 disables)
+	rollupMinTests int
+
+	// Optional persistence; sessions are loaded from it on first use
+	store  ActionStore
+	logger *zap.Logger
+	loaded map[string]bool
 }
 
-// NewActionCache creates a new action cache with specified window size
-func NewActionCache(windowSize int) *ActionCache {
+// NewActionCache creates a new action cache with specified window size. Tests run across
+// rollupMinTests or more variable sets are rolled up into a single done-ledger entry.
+func NewActionCache(windowSize, rollupMinTests int) *ActionCache {
 	return &ActionCache{
-		completed:     make(map[string]*ActionResult),
-		recentActions: make([]ActionSignature, 0, windowSize),
-		windowSize:    windowSize,
+		completed:      make(map[string]*ActionResult),
+		recentActions:  make([]ActionSignature, 0, windowSize),
+		windowSize:     windowSize,
+		rollupMinTests: rollupMinTests,
+		loaded:         make(map[string]bool),
+	}
+}
+
+// SetStore backs the cache with store: executed actions are written through to it, a
+// session's actions are loaded from it on first use, and BuildDoneLedger re-reads them,
+// so actions recorded by another replica or before a restart count as done.
+func (c *ActionCache) SetStore(store ActionStore, logger *zap.Logger) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store = store
+	c.logger = logger
+	c.loaded = make(map[string]bool)
+}
+
+// load replaces the session's cached actions with the stored ones. On a store error the
+// in-memory actions are kept.
+func (c *ActionCache) load(sessionID string) {
+	c.mu.Lock()
+	store := c.store
+	c.mu.Unlock()
+	if store == nil || sessionID == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), actionStoreTimeout)
+	defer cancel()
+	records, err := store.GetActionResults(ctx, sessionID)
+	if err != nil {
+		c.logger.Warn("Failed to load action results; using in-memory cache",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purgeMemory(sessionID)
+	var recent []ActionSignature
+	for _, record := range records {
+		var sig ActionSignature
+		if err := json.Unmarshal([]byte(record.Signature), &sig); err != nil {
+			continue
+		}
+		c.completed[sig.ComputeHash()] = &ActionResult{
+			Signature:          sig,
+			Output:             record.Output,
+			Success:            record.Success,
+			Turn:               record.Turn,
+			Attempt:            record.Attempt,
+			CodeNormHash:       record.CodeNormHash,
+			Diagnostics:        record.Diagnostics,
+			UserEdited:         record.UserEdited,
+			SupersededCodeHash: record.SupersededCodeHash,
+		}
+		recent = append(recent, sig)
+	}
+	if len(recent) > c.windowSize {
+		recent = recent[len(recent)-c.windowSize:]
+	}
+	c.recentActions = append(c.recentActions, recent...)
+	if len(c.recentActions) > c.windowSize {
+		c.recentActions = c.recentActions[len(c.recentActions)-c.windowSize:]
+	}
+	c.loaded[sessionID] = true
+}
+
+// ensureLoaded loads the session from the store the first time it is used.
+func (c *ActionCache) ensureLoaded(sessionID string) {
+	c.mu.Lock()
+	pending := c.store != nil && sessionID != "" && !c.loaded[sessionID]
+	c.mu.Unlock()
+	if pending {
+		c.load(sessionID)
 	}
 }
 
-// PurgeSession removes cached actions belonging to a specific session.
+// PurgeSession removes cached actions belonging to a specific session, from the store too.
 func (c *ActionCache) PurgeSession(sessionID string) {
     if sessionID == "" {
         return
     }
+    c.mu.Lock()
+    c.purgeMemory(sessionID)
+    store := c.store
+    c.mu.Unlock()
+
+    if store != nil {
+        ctx, cancel := context.WithTimeout(context.Background(), actionStoreTimeout)
+        defer cancel()
+        if err := store.DeleteActionResults(ctx, sessionID); err != nil {
+            c.logger.Warn("Failed to delete stored action results",
+                zap.Error(err),
+                zap.String("session_id", sessionID))
+        }
+    }
+}
+
+// purgeMemory drops the session's actions from memory. Callers hold c.mu.
+func (c *ActionCache) purgeMemory(sessionID string) {
+    delete(c.loaded, sessionID)
     // Remove from completed map
     for hash, res := range c.completed {
         if res != nil && res.Signature.SessionID == sessionID {
@@ -126,9 +258,12 @@ func (c *ActionCache) PurgeSession(sessionID string) {
     c.recentActions = filtered
 }
 
-// Add records a completed action
+// Add records a completed action and writes it through to the store.
 func (c *ActionCache) Add(sig ActionSignature, result ActionResult) {
+	c.ensureLoaded(sig.SessionID)
+
 	hash := sig.ComputeHash()
+	c.mu.Lock()
 	c.completed[hash] = &result
 
 	// Add to sliding window
@@ -136,18 +271,73 @@ func (c *ActionCache) Add(sig ActionSignature, result ActionResult) {
 	if len(c.recentActions) > c.windowSize {
 		c.recentActions = c.recentActions[1:]
 	}
+	store := c.store
+	c.mu.Unlock()
+
+	if store == nil || sig.SessionID == "" {
+		return
+	}
+	signature, err := json.Marshal(sig)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), actionStoreTimeout)
+	defer cancel()
+	if err := store.SaveActionResult(ctx, types.ActionRecord{
+		SessionID:          sig.SessionID,
+		SignatureHash:      hash,
+		Signature:          string(signature),
+		Output:             result.Output,
+		Success:            result.Success,
+		Turn:               result.Turn,
+		Attempt:            result.Attempt,
+		CodeNormHash:       result.CodeNormHash,
+		Diagnostics:        result.Diagnostics,
+		UserEdited:         result.UserEdited,
+		SupersededCodeHash: result.SupersededCodeHash,
+	}); err != nil {
+		c.logger.Warn("Failed to persist action result",
+			zap.Error(err),
+			zap.String("session_id", sig.SessionID),
+			zap.String("action", sig.String()))
+	}
 }
 
 // Get retrieves cached result if exists
 func (c *ActionCache) Get(sig ActionSignature) (*ActionResult, bool) {
+	c.ensureLoaded(sig.SessionID)
 	hash := sig.ComputeHash()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	result, exists := c.completed[hash]
+	if exists {
+		metrics.ActionCacheLookups.Inc("hit")
+	} else {
+		metrics.ActionCacheLookups.Inc("miss")
+	}
 	return result, exists
 }
 
+// SuccessfulSignatures returns the signatures of the session's successful actions.
+func (c *ActionCache) SuccessfulSignatures(sessionID string) []ActionSignature {
+	c.ensureLoaded(sessionID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sigs []ActionSignature
+	for _, result := range c.completed {
+		if result.Success && result.Signature.SessionID == sessionID {
+			sigs = append(sigs, result.Signature)
+		}
+	}
+	return sigs
+}
+
 // CountRecentRepeats counts how many times sig appears in last N actions
 func (c *ActionCache) CountRecentRepeats(sig ActionSignature) int {
+	c.ensureLoaded(sig.SessionID)
 	hash := sig.ComputeHash()
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	count := 0
 	for _, recent := range c.recentActions {
 		if recent.ComputeHash() == hash {
@@ -175,6 +365,14 @@ func ExtractActionSignature(code, dataset string, n int, schemaHash string) *Act
         name    string
         pattern string
     }{
+        // Time-series toolkit (checked first: model code often mentions generic terms)
+        {"prophet", `prophet\(`},
+        {"arima", `auto_arima|sarimax\(|arima\(`},
+        {"ljungbox", `acorr_ljungbox|ljung`},
+        {"decompose", `seasonal_decompose|\bstl\(`},
+        {"adf", `adfuller`},
+        {"kpss", `kpss\(`},
+        {"acf_pacf", `plot_acf|plot_pacf|\bacf\(|\bpacf\(`},
         {"chi2", `chi2_contingency|chisq\.test`},
         {"fisher", `fisher_exact|fisher\.test`},
         // Classification / diagnostics
@@ -283,13 +481,17 @@ func isPandasMethod(name string) bool {
 	return methods[name]
 }
 
-// BuildDoneLedger creates compact "done=" string for memory/prompt
+// BuildDoneLedger creates compact "done=" string for memory/prompt. With a store the
+// session's actions are re-read first, picking up those recorded by other replicas.
 func (c *ActionCache) BuildDoneLedger(sessionID string) string {
+    c.load(sessionID)
+    c.mu.Lock()
+    defer c.mu.Unlock()
     if len(c.completed) == 0 {
         return ""
     }
 
-    var entries []string
+    var results []*ActionResult
     for _, result := range c.completed {
         if !result.Success {
             continue // Only show successful actions
@@ -298,10 +500,37 @@ func (c *ActionCache) BuildDoneLedger(sessionID string) string {
         if sessionID != "" && result.Signature.SessionID != sessionID {
             continue
         }
+        results = append(results, result)
+    }
+
+    // Screening loops (one test over many variables) collapse into one rollup entry
+    perTest := make(map[string]int)
+    for _, result := range results {
+        if result.Signature.Test != "" && len(result.Signature.Variables) > 0 {
+            perTest[result.Signature.Test]++
+        }
+    }
+    rolledUp := make(map[string]bool)
+
+    var entries []string
+    for _, result := range results {
+        if test := result.Signature.Test; c.rollupMinTests > 0 && perTest[test] >= c.rollupMinTests && len(result.Signature.Variables) > 0 {
+            if !rolledUp[test] {
+                rolledUp[test] = true
+                entries = append(entries, fmt.Sprintf("%s(%d variables)[rollup]", test, perTest[test]))
+            }
+            continue
+        }
         s := result.Signature.String()
         if s == "" {
             continue
         }
+        if result.Diagnostics != "" {
+            s += "[" + result.Diagnostics + "]"
+        }
+        if result.UserEdited {
+            s += "[user]"
+        }
         entries = append(entries, s)
     }
 
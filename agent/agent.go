@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"stats-agent/config"
 	"stats-agent/llmclient"
@@ -19,41 +21,111 @@ type Agent struct {
 	cfg                  *config.Config
 	pythonTool           *tools.StatefulPythonTool
 	rag                  *rag.RAG
+	llm                  llmclient.LLM
 	logger               *zap.Logger
 	memoryManager        *MemoryManager
 	executionCoordinator *ExecutionCoordinator
 	responseHandler      *ResponseHandler
+	contextBudgeter      *ContextBudgeter
 	queryBuilder         *QueryBuilder
 	actionCache          *ActionCache
+	planner              *Planner // nil unless PLANNER_ENABLED
+
+	// Per-session effect size check mode (off/note/auto); unset means note
+	effectSizeMu    sync.RWMutex
+	effectSizeCheck map[string]string
+
+	// Per-session data transformation log (filters, drops, imputation, recodes)
+	lineageMu sync.RWMutex
+	lineage   map[string][]types.TransformationStep
+
+	// Per-session user column type overrides (dataset -> column -> type)
+	columnTypesMu sync.RWMutex
+	columnTypes   map[string]map[string]map[string]string
+
+	// Per-session level dictionary: profiled columns with categorical levels, by dataset
+	columnLevelsMu sync.RWMutex
+	columnLevels   map[string]map[string][]types.ColumnSchema
+
+	// Per-session tabular files with their schemas, referenced by name in the prompt
+	datasets *DatasetRegistry
+
+	// Per-session execution environment descriptors (Python and package versions), and
+	// when a session whose probe failed may be probed again
+	environmentMu         sync.RWMutex
+	environments          map[string]string
+	environmentRetryAfter map[string]time.Time
+
+	// Per-session LLM_MODELS endpoint name; unset means MAIN_LLM_HOST
+	llmModelMu sync.RWMutex
+	llmModels  map[string]string
+
+	// Optional per-turn run recording for offline replay
+	runRecorder RunRecorder
+
+	// Optional usage telemetry (counts only)
+	usageRecorder UsageRecorder
 }
 
 // Tokenize request/response types have been centralized in llmclient.
 
-func NewAgent(cfg *config.Config, pythonTool *tools.StatefulPythonTool, rag *rag.RAG, logger *zap.Logger) *Agent {
+// NewAgent creates the agent. llm serves every chat and tokenize call the agent makes;
+// pass llmclient.New for the configured hosts or a fake in tests.
+func NewAgent(cfg *config.Config, pythonTool *tools.StatefulPythonTool, rag *rag.RAG, llm llmclient.LLM, logger *zap.Logger) *Agent {
 	logger.Info("Agent initialized", zap.Int("context_window_size", cfg.ContextLength))
 
 	// Initialize specialized components
-	memoryManager := NewMemoryManager(cfg, logger)
-	executionCoordinator := NewExecutionCoordinator(pythonTool, logger)
+	memoryManager := NewMemoryManager(cfg, llm, logger)
+	var sqlTool *tools.SQLTool
+	if cfg.SQLToolEnabled {
+		sqlTool = tools.NewSQLTool(pythonTool, cfg.SQLToolMaxRows)
+	}
+	executionCoordinator := NewExecutionCoordinator(pythonTool, sqlTool, logger)
 	responseHandler := NewResponseHandler(cfg, logger)
 	queryBuilder := NewQueryBuilder(cfg, rag, logger)
-	actionCache := NewActionCache(5) // Track last 5 actions for repeat detection
+	actionCache := NewActionCache(5, cfg.ScreeningRollupMinTests) // Track last 5 actions for repeat detection
+	var summarizer stateSummarizer
+	if rag != nil {
+		summarizer = rag
+	}
+	contextBudgeter := NewContextBudgeter(cfg, memoryManager, summarizer, responseHandler, logger)
 
-	return &Agent{
+	a := &Agent{
 		cfg:                  cfg,
 		pythonTool:           pythonTool,
 		rag:                  rag,
+		llm:                  llm,
 		logger:               logger,
 		memoryManager:        memoryManager,
 		executionCoordinator: executionCoordinator,
 		responseHandler:      responseHandler,
+		contextBudgeter:      contextBudgeter,
 		queryBuilder:         queryBuilder,
 		actionCache:          actionCache,
+		effectSizeCheck:      make(map[string]string),
+		lineage:              make(map[string][]types.TransformationStep),
+		columnTypes:          make(map[string]map[string]map[string]string),
+		columnLevels:         make(map[string]map[string][]types.ColumnSchema),
+		datasets:             NewDatasetRegistry(),
+		environments:         make(map[string]string),
+		environmentRetryAfter: make(map[string]time.Time),
+		llmModels:            make(map[string]string),
 	}
+	if cfg.PlannerEnabled {
+		a.planner = NewPlanner(cfg, llm, rag, logger)
+	}
+	responseHandler.SetOverrunCallback(OverrunSummarize, a.condenseOverrun)
+	responseHandler.SetOverrunCallback(OverrunContinue, a.continueOverrun)
+	return a
 }
 
 func (a *Agent) InitializeSession(ctx context.Context, sessionID string, uploadedFiles []string) (string, error) {
-	return a.pythonTool.InitializeSession(ctx, sessionID, uploadedFiles)
+	result, err := a.pythonTool.InitializeSession(ctx, sessionID, uploadedFiles)
+	if err != nil {
+		return result, err
+	}
+	a.DescribeSessionEnvironment(ctx, sessionID)
+	return result, nil
 }
 
 func (a *Agent) CleanupSession(sessionID string) {
@@ -65,12 +137,66 @@ func (a *Agent) CleanupSession(sessionID string) {
                 zap.Error(err))
         }
     }
+    a.responseHandler.ClearVerbosity(sessionID)
+    a.SetSessionEffectSizeCheck(sessionID, "")
+    a.SetSessionLLMModel(sessionID, "")
+    a.clearSessionLineage(sessionID)
+    a.clearSessionColumnTypes(sessionID)
+    a.clearSessionColumnLevels(sessionID)
+    a.datasets.Clear(sessionID)
+    a.clearSessionEnvironment(sessionID)
+    if a.planner != nil {
+        a.planner.Clear(sessionID)
+    }
     if a.actionCache != nil {
         a.actionCache.PurgeSession(sessionID)
         a.logger.Info("Purged action cache for session", zap.String("session_id", sessionID))
     }
 }
 
+// SetActionStore persists the action cache in store, so done-ledgers and repeat detection
+// survive restarts and are shared between replicas. A nil store keeps it in memory only.
+func (a *Agent) SetActionStore(store ActionStore) {
+	a.actionCache.SetStore(store, a.logger)
+}
+
+// SetSessionVerbosity applies a session's response verbosity (terse/standard/teaching)
+// to response budgeting, prompt instructions, and streaming.
+func (a *Agent) SetSessionVerbosity(sessionID, verbosity string) {
+	a.responseHandler.SetVerbosity(sessionID, verbosity)
+}
+
+// SetSessionRandomSeed pins the random seed set before each cell the session executes,
+// or clears it when seed is nil.
+func (a *Agent) SetSessionRandomSeed(sessionID string, seed *int64) {
+	a.pythonTool.SetSessionSeed(sessionID, seed)
+}
+
+// SetSessionRetrievalPolicy selects the named retrieval policy for the session's memory
+// queries ("" uses the policy of the session mode).
+func (a *Agent) SetSessionRetrievalPolicy(sessionID, name string) {
+	if a.rag != nil {
+		a.rag.SetSessionRetrievalPolicy(sessionID, name)
+	}
+}
+
+// PackageVersions returns the package versions installed in the session's Python executor.
+func (a *Agent) PackageVersions(ctx context.Context, sessionID string) (string, error) {
+	return a.pythonTool.PackageVersions(ctx, sessionID)
+}
+
+// RecordRetrievalFeedback records a user's rating of an answer as a retrieval experiment outcome.
+func (a *Agent) RecordRetrievalFeedback(sessionID string, helpful bool) {
+	if a.rag == nil {
+		return
+	}
+	value := 0.0
+	if helpful {
+		value = 1
+	}
+	a.rag.RecordRetrievalOutcome(sessionID, rag.SignalFeedback, value)
+}
+
 // GetMemoryManager returns the agent's memory manager for token counting
 func (a *Agent) GetMemoryManager() *MemoryManager {
 	return a.memoryManager
@@ -81,21 +207,28 @@ func (a *Agent) GetRAG() *rag.RAG {
 	return a.rag
 }
 
-func (a *Agent) GenerateTitle(ctx context.Context, content string) (string, error) {
+// GenerateTitle writes a short session title from the user's message. abstract, once the
+// session has one, describes the whole conversation and takes precedence over the message.
+func (a *Agent) GenerateTitle(ctx context.Context, content string, abstract string) (string, error) {
 	systemPrompt := prompts.TitleGenerator()
 
 	userPrompt := fmt.Sprintf(`User message:
 %s
 
 Respond with only the title.`, content)
+	if abstract != "" {
+		userPrompt = fmt.Sprintf(`Session abstract:
+%s
+
+`, abstract) + userPrompt
+	}
 
 	messages := []types.AgentMessage{
 		{Role: "system", Content: systemPrompt},
 		{Role: "user", Content: userPrompt},
 	}
 
-	client := llmclient.New(a.cfg, a.logger)
-	title, err := client.Chat(ctx, a.cfg.SummarizationLLMHost, messages, nil) // nil = use server default temp
+	title, err := a.llm.Chat(ctx, a.cfg.SummarizationLLMHost, messages, nil) // nil = use server default temp
 	if err != nil {
 		return "", fmt.Errorf("llm chat call failed for title generation: %w", err)
 	}
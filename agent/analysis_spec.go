@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"context"
+	"strings"
+
+	"stats-agent/tools"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+	"go.yaml.in/yaml/v3"
+)
+
+// AnalysisSpecPrefix starts every user message recording an analysis spec run.
+const AnalysisSpecPrefix = "I ran an analysis spec:"
+
+// RunAnalysisSpec compiles a declarative analysis spec to its templated Python and
+// executes it without an LLM call. The run is recorded like a user re-run: in the action
+// cache, so the agent does not repeat it, and in RAG with the spec and code as the user
+// message.
+func (a *Agent) RunAnalysisSpec(ctx context.Context, sessionID string, spec types.AnalysisSpec, history []types.AgentMessage) (*ExecutionResult, error) {
+	code, err := tools.AnalysisSpecCode(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	a.logger.Info("Executing analysis spec",
+		zap.String("session_id", sessionID),
+		zap.String("test", spec.Test),
+		zap.String("dataset", spec.Dataset))
+	return a.runUserCode(ctx, sessionID, code, "", AnalysisSpecMessage(spec, code), history)
+}
+
+// AnalysisSpecMessage formats the user message that records a spec run: the spec as YAML
+// followed by the code it compiled to.
+func AnalysisSpecMessage(spec types.AnalysisSpec, code string) string {
+	specYAML, err := yaml.Marshal(spec)
+	if err != nil {
+		specYAML = []byte(spec.Test + "\n")
+	}
+	return AnalysisSpecPrefix + "\n```yaml\n" + string(specYAML) + "```\n```python\n" + strings.TrimSpace(code) + "\n```"
+}
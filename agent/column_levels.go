@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"stats-agent/web/types"
+)
+
+// maxLevelsShown caps how many of a column's levels are listed in the mapping block.
+const maxLevelsShown = 6
+
+// RegisterColumnLevels records the profiled levels of a dataset's categorical columns in
+// the session's level dictionary, replacing earlier ones. Columns without levels are
+// dropped; a dataset with none is still recorded so it isn't profiled again.
+func (a *Agent) RegisterColumnLevels(sessionID, dataset string, columns []types.ColumnSchema) {
+	if sessionID == "" || dataset == "" {
+		return
+	}
+	var kept []types.ColumnSchema
+	for _, col := range columns {
+		if len(col.Levels) > 0 {
+			kept = append(kept, col)
+		}
+	}
+	a.columnLevelsMu.Lock()
+	defer a.columnLevelsMu.Unlock()
+	if a.columnLevels[sessionID] == nil {
+		a.columnLevels[sessionID] = make(map[string][]types.ColumnSchema)
+	}
+	a.columnLevels[sessionID][dataset] = kept
+}
+
+// HasColumnLevels reports whether the dataset's levels are in the session's dictionary.
+func (a *Agent) HasColumnLevels(sessionID, dataset string) bool {
+	a.columnLevelsMu.RLock()
+	defer a.columnLevelsMu.RUnlock()
+	_, ok := a.columnLevels[sessionID][dataset]
+	return ok
+}
+
+// sessionColumnLevels returns a snapshot of the session's level dictionary
+// (dataset → columns with levels).
+func (a *Agent) sessionColumnLevels(sessionID string) map[string][]types.ColumnSchema {
+	a.columnLevelsMu.RLock()
+	defer a.columnLevelsMu.RUnlock()
+	datasets := a.columnLevels[sessionID]
+	if len(datasets) == 0 {
+		return nil
+	}
+	snapshot := make(map[string][]types.ColumnSchema, len(datasets))
+	for dataset, cols := range datasets {
+		snapshot[dataset] = cols
+	}
+	return snapshot
+}
+
+// clearSessionColumnLevels drops the session's level dictionary.
+func (a *Agent) clearSessionColumnLevels(sessionID string) {
+	a.columnLevelsMu.Lock()
+	defer a.columnLevelsMu.Unlock()
+	delete(a.columnLevels, sessionID)
+}
+
+// levelMappingBlock tells the model which column holds each level the user mentioned, so
+// it filters or groups by that column instead of a similarly named one.
+func levelMappingBlock(matches []LevelMatch) string {
+	if len(matches) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("<level_mapping>\n")
+	b.WriteString("The user's message mentions values found in these categorical columns. Filter or group by the column shown; do not guess another column:\n")
+	for _, m := range matches {
+		levels := m.Column.Levels
+		more := ""
+		if len(levels) > maxLevelsShown {
+			more = fmt.Sprintf(", … %d more", len(levels)-maxLevelsShown)
+			levels = levels[:maxLevelsShown]
+		}
+		parts := make([]string, len(levels))
+		for i, l := range levels {
+			parts[i] = fmt.Sprintf("%s %d", l.Value, l.Count)
+		}
+		fmt.Fprintf(&b, "- %q is a level of `%s` in %s (%d rows; levels: %s%s)\n",
+			m.Level, m.Column.Name, m.Dataset, m.Count, strings.Join(parts, ", "), more)
+	}
+	b.WriteString("</level_mapping>")
+	return b.String()
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// DatasetColumns infers the column types of a dataset in the session workspace, records
+// its categorical levels in the session's level dictionary and its schema in the
+// session's dataset registry.
+func (a *Agent) DatasetColumns(ctx context.Context, sessionID, dataset string) ([]types.ColumnSchema, error) {
+	columns, err := a.pythonTool.InferColumnTypes(ctx, sessionID, dataset)
+	if err != nil {
+		return nil, err
+	}
+	a.RegisterColumnLevels(sessionID, dataset, columns)
+	a.RegisterDataset(sessionID, dataset, columns)
+	return columns, nil
+}
+
+// SetSessionColumnTypes replaces a session's column type overrides (dataset → column → type).
+func (a *Agent) SetSessionColumnTypes(sessionID string, overrides map[string]map[string]string) {
+	if sessionID == "" {
+		return
+	}
+	copied := make(map[string]map[string]string, len(overrides))
+	for dataset, cols := range overrides {
+		if len(cols) == 0 {
+			continue
+		}
+		copied[dataset] = make(map[string]string, len(cols))
+		for col, t := range cols {
+			copied[dataset][col] = t
+		}
+	}
+	a.columnTypesMu.Lock()
+	defer a.columnTypesMu.Unlock()
+	a.columnTypes[sessionID] = copied
+}
+
+// HasSessionColumnTypes reports whether the session's column type overrides were loaded.
+func (a *Agent) HasSessionColumnTypes(sessionID string) bool {
+	a.columnTypesMu.RLock()
+	defer a.columnTypesMu.RUnlock()
+	_, ok := a.columnTypes[sessionID]
+	return ok
+}
+
+// clearSessionColumnTypes drops the session's column type overrides.
+func (a *Agent) clearSessionColumnTypes(sessionID string) {
+	a.columnTypesMu.Lock()
+	defer a.columnTypesMu.Unlock()
+	delete(a.columnTypes, sessionID)
+}
+
+// RefreshColumnProfile regenerates the dataset's profiling state card from its inferred
+// columns with the user's overrides applied.
+func (a *Agent) RefreshColumnProfile(ctx context.Context, sessionID, dataset string, columns []types.ColumnSchema, overrides map[string]string) {
+	if a.rag == nil {
+		return
+	}
+	if err := a.rag.StoreColumnProfile(ctx, sessionID, dataset, FormatColumnProfile(columns, overrides)); err != nil {
+		a.logger.Warn("Failed to store column profile state",
+			zap.Error(err),
+			zap.String("session_id", sessionID),
+			zap.String("dataset", dataset))
+	}
+}
+
+// FormatColumnProfile lists each column's effective type, marking user overrides.
+func FormatColumnProfile(columns []types.ColumnSchema, overrides map[string]string) string {
+	var b strings.Builder
+	for _, col := range columns {
+		if t, ok := overrides[col.Name]; ok && t != col.Inferred {
+			fmt.Fprintf(&b, "%s: %s (user override; stored as %s)\n", col.Name, t, col.Dtype)
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s (%s, %d distinct)\n", col.Name, col.Inferred, col.Dtype, col.Unique)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// columnTypesBlock returns the session's <column_types> block for the prompt, or "" when
+// the user has not overridden any column types.
+func (a *Agent) columnTypesBlock(sessionID string) string {
+	a.columnTypesMu.RLock()
+	defer a.columnTypesMu.RUnlock()
+	overrides := a.columnTypes[sessionID]
+	if len(overrides) == 0 {
+		return ""
+	}
+
+	datasets := make([]string, 0, len(overrides))
+	for dataset := range overrides {
+		datasets = append(datasets, dataset)
+	}
+	sort.Strings(datasets)
+
+	var b strings.Builder
+	b.WriteString("<column_types>\nThe user set these column types. Treat each column as its stated type when choosing tests and models (convert with astype/pd.to_datetime first if needed), even if pandas infers otherwise.\n")
+	for _, dataset := range datasets {
+		cols := make([]string, 0, len(overrides[dataset]))
+		for col := range overrides[dataset] {
+			cols = append(cols, col)
+		}
+		sort.Strings(cols)
+		for _, col := range cols {
+			fmt.Fprintf(&b, "- %s: %s → %s\n", dataset, col, overrides[dataset][col])
+		}
+	}
+	b.WriteString("</column_types>")
+	return b.String()
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"stats-agent/prompts"
+
+	"go.uber.org/zap"
+)
+
+var datasetFilenameRegex = regexp.MustCompile(`(?i)([\w\-.]+\.(?:csv\.gz|csv|xlsx|xls|parquet))\b`)
+
+var (
+	// comparisonPhraseRegex matches explicit requests to compare the uploaded files as a
+	// whole ("compare the two datasets", "what changed between the extracts").
+	comparisonPhraseRegex = regexp.MustCompile(`(?i)\b(?:compare|comparing|diff|what changed between|differences? between)\s+(?:the\s+)?(?:two\s+|both\s+)?(?:datasets|extracts|files|versions)\b`)
+	// comparisonWordRegex matches a comparison verb anywhere in the request.
+	comparisonWordRegex = regexp.MustCompile(`(?i)\b(?:compare|comparing|comparison|diff|what changed|differences? between|before and after|drift|distribution shift)\b`)
+)
+
+// isComparisonRequest detects requests to compare two datasets: an explicit phrase about
+// the files as a whole, or a comparison that names two distinct dataset files ("compare
+// before.csv and after.csv"). Comparisons within one dataset ("compare mean age between
+// groups") do not match.
+func isComparisonRequest(input string) bool {
+	if comparisonPhraseRegex.MatchString(input) {
+		return true
+	}
+	fileA, fileB := extractComparisonFiles(input)
+	return fileB != "" && !strings.EqualFold(fileA, fileB) && comparisonWordRegex.MatchString(input)
+}
+
+// extractComparisonFiles returns up to two distinct dataset filenames mentioned in the input.
+// Missing names are left empty so the profiler falls back to the most recent uploads.
+func extractComparisonFiles(input string) (string, string) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, m := range datasetFilenameRegex.FindAllStringSubmatch(input, -1) {
+		name := strings.TrimSpace(m[1])
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		files = append(files, name)
+		if len(files) == 2 {
+			break
+		}
+	}
+	switch len(files) {
+	case 0:
+		return "", ""
+	case 1:
+		return files[0], ""
+	default:
+		return files[0], files[1]
+	}
+}
+
+// buildComparisonEvidence profiles two datasets and returns the comparison prompt together
+// with the profile as an ephemeral evidence block. Returns "" when profiling fails.
+func (a *Agent) buildComparisonEvidence(ctx context.Context, sessionID, input string, stream *Stream) string {
+	_ = stream.Status("Profiling datasets for comparison...")
+
+	fileA, fileB := extractComparisonFiles(input)
+	cmpCtx, cancel := context.WithTimeout(ctx, a.cfg.LLMRequestTimeout)
+	defer cancel()
+
+	profile, err := a.pythonTool.CompareDatasets(cmpCtx, sessionID, fileA, fileB)
+	if err != nil {
+		a.logger.Warn("Dataset comparison profiling failed, continuing without it",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+		return ""
+	}
+	profile = strings.TrimSpace(profile)
+	if profile == "" || strings.HasPrefix(profile, "Error:") {
+		a.logger.Info("Dataset comparison skipped",
+			zap.String("session_id", sessionID),
+			zap.String("result", truncateString(profile, 200)))
+		return ""
+	}
+
+	return prompts.DatasetComparison() + "\n<comparison>\n" + profile + "\n</comparison>"
+}
@@ -0,0 +1,326 @@
+package agent
+
+import (
+	"context"
+	"strings"
+
+	"stats-agent/config"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// BudgetStrategy names a step the ContextBudgeter took to fit a prompt into the window.
+type BudgetStrategy string
+
+const (
+	// StrategyCompressState replaces the retrieved state with an LLM summary focused on the query.
+	StrategyCompressState BudgetStrategy = "compress_state"
+	// StrategyDropEvidence drops the turn-only evidence block.
+	StrategyDropEvidence BudgetStrategy = "drop_evidence"
+	// StrategyTrimHistory drops the lowest-scoring history messages, keeping assistant/tool pairs together.
+	StrategyTrimHistory BudgetStrategy = "trim_history"
+	// StrategySummarizeHistory folds a summary of the trimmed messages into the state block.
+	StrategySummarizeHistory BudgetStrategy = "summarize_history"
+)
+
+// tokenCounter is the part of MemoryManager the budgeter needs.
+type tokenCounter interface {
+	CountTokens(ctx context.Context, text string) (int, error)
+	CalculateHistorySize(ctx context.Context, history []types.AgentMessage) (int, error)
+}
+
+// stateSummarizer condenses retrieved state (or trimmed history) for the current question.
+type stateSummarizer interface {
+	SummarizeState(ctx context.Context, state, latestUserMessage string) (string, error)
+}
+
+// ContextRequest is one turn's prompt before budgeting.
+type ContextRequest struct {
+	SessionID string
+	// Query is the latest user message; state summaries are focused on it
+	Query string
+	// SystemPrompt is sent alongside the messages and counted as fixed overhead
+	SystemPrompt string
+	State        string
+	Evidence     string
+	History      []types.AgentMessage
+	// ResponseTokens is reserved for the response; 0 uses the session's response budget
+	ResponseTokens int
+}
+
+// ContextFit is the budgeted prompt. Messages is what goes to the LLM; State, Evidence and
+// History are the adjusted parts so callers can carry a trimmed history into later turns.
+type ContextFit struct {
+	Messages []types.AgentMessage
+	State    string
+	Evidence string
+	History  []types.AgentMessage
+	Report   ContextBudgetReport
+}
+
+// ContextBudgetReport records the budget and the strategies applied, in order.
+type ContextBudgetReport struct {
+	// Counted is false when token counting failed and the prompt was passed through unchanged
+	Counted         bool
+	ResponseTokens  int
+	MaxPromptTokens int
+	OverheadCap     int
+	SystemTokens    int
+	StateTokens     int
+	EvidenceTokens  int
+	// InitialTokens and FinalTokens measure the messages, excluding the system prompt;
+	// AllowedTokens is what the messages may use
+	InitialTokens   int
+	FinalTokens     int
+	AllowedTokens   int
+	Applied         []BudgetStrategy
+	MessagesTrimmed int
+	TokensTrimmed   int
+}
+
+func (r *ContextBudgetReport) applied(strategy BudgetStrategy) {
+	r.Applied = append(r.Applied, strategy)
+}
+
+// Overflow returns how many tokens the budgeted prompt still exceeds the window by,
+// 0 when it fits or could not be counted.
+func (r ContextBudgetReport) Overflow() int {
+	if !r.Counted {
+		return 0
+	}
+	return max(r.FinalTokens-r.AllowedTokens, 0)
+}
+
+// ContextBudgeter fits a turn's state, evidence and history into the model's context window.
+// The prompt budget is CONTEXT_LENGTH minus the turn's response budget. System prompt,
+// state and evidence are overhead capped so CONTEXT_SOFT_LIMIT_RATIO of the budget stays
+// available for recent history; over the cap the state is compressed and then the evidence
+// dropped. If the messages still do not fit, the history is packed into the remaining budget
+// by a ContextPacker (and what it drops optionally summarized back into the state).
+type ContextBudgeter struct {
+	cfg        *config.Config
+	tokens     tokenCounter
+	summarizer stateSummarizer
+	responses  *ResponseHandler
+	packer     ContextPacker
+	logger     *zap.Logger
+}
+
+// NewContextBudgeter creates a budgeter. summarizer may be nil, which disables the state
+// compression and history summary strategies.
+func NewContextBudgeter(cfg *config.Config, tokens tokenCounter, summarizer stateSummarizer, responses *ResponseHandler, logger *zap.Logger) *ContextBudgeter {
+	return &ContextBudgeter{
+		cfg:        cfg,
+		tokens:     tokens,
+		summarizer: summarizer,
+		responses:  responses,
+		logger:     logger,
+	}
+}
+
+// Fit applies the budgeting strategies to req and returns the adjusted prompt with a report.
+// Token counts are cached on req.History's messages in place.
+func (b *ContextBudgeter) Fit(ctx context.Context, req ContextRequest) ContextFit {
+	fit := ContextFit{State: req.State, Evidence: req.Evidence, History: req.History}
+	fit.Messages = b.build(fit)
+	report := &fit.Report
+
+	totalTokens, err := b.tokens.CalculateHistorySize(ctx, fit.Messages)
+	if err != nil {
+		b.logger.Warn("Failed to count tokens for combined context; proceeding optimistically",
+			zap.String("session_id", req.SessionID), zap.Error(err))
+		return fit
+	}
+	report.Counted = true
+	report.InitialTokens = totalTokens
+
+	systemTokens, err := b.tokens.CountTokens(ctx, req.SystemPrompt)
+	if err != nil {
+		b.logger.Warn("Failed to count tokens for system prompt; using 0 overhead", zap.Error(err))
+		systemTokens = 0
+	}
+	report.SystemTokens = systemTokens
+	report.StateTokens = b.count(ctx, fit.State)
+	report.EvidenceTokens = b.count(ctx, fit.Evidence)
+
+	report.ResponseTokens = req.ResponseTokens
+	if report.ResponseTokens <= 0 {
+		report.ResponseTokens = b.responses.ResponseTokenBudget(req.SessionID)
+	}
+	report.MaxPromptTokens = max(b.cfg.ContextLength-report.ResponseTokens, 0)
+	recencyMin := max(int(float64(report.MaxPromptTokens)*b.cfg.ContextSoftLimitRatio), 0)
+	report.OverheadCap = max(report.MaxPromptTokens-recencyMin, 0)
+	overhead := func() int { return report.SystemTokens + report.StateTokens + report.EvidenceTokens }
+
+	// Overhead over its cap: compress the state first, then drop the turn-only evidence
+	if overhead() > report.OverheadCap && strings.TrimSpace(fit.State) != "" && b.summarizer != nil {
+		if summary := b.summarize(ctx, fit.State, req.Query); summary != "" {
+			fit.State = summary
+			report.StateTokens = b.count(ctx, fit.State)
+			report.applied(StrategyCompressState)
+		}
+	}
+	if overhead() > report.OverheadCap && report.EvidenceTokens > 0 {
+		fit.Evidence = ""
+		report.EvidenceTokens = 0
+		report.applied(StrategyDropEvidence)
+	}
+
+	// Allowed tokens for all messages (state + evidence + history), excluding the system prompt
+	allowed := max(report.MaxPromptTokens-systemTokens, 0)
+	report.AllowedTokens = allowed
+	if len(report.Applied) > 0 {
+		fit.Messages = b.build(fit)
+		if recount, err := b.tokens.CalculateHistorySize(ctx, fit.Messages); err == nil {
+			totalTokens = recount
+		} else {
+			b.logger.Warn("Token recount after overhead adjustment failed", zap.Error(err))
+		}
+	}
+
+	if totalTokens > allowed {
+		removed := b.trimHistory(ctx, &fit, totalTokens-allowed)
+		if len(removed) > 0 {
+			totalTokens -= report.TokensTrimmed
+			report.applied(StrategyTrimHistory)
+			if b.cfg.ContextSummarizeTrimmed && b.summarizer != nil {
+				totalTokens = b.summarizeTrimmed(ctx, &fit, removed, req.Query, totalTokens, allowed)
+			}
+			fit.Messages = b.build(fit)
+		}
+	}
+	report.FinalTokens = totalTokens
+
+	b.log(req.SessionID, report)
+	return fit
+}
+
+func (b *ContextBudgeter) build(fit ContextFit) []types.AgentMessage {
+	return b.responses.BuildMessagesForLLMWithEvidence(fit.State, fit.Evidence, fit.History)
+}
+
+// count returns the token count of text, or 0 when it is blank or counting fails.
+func (b *ContextBudgeter) count(ctx context.Context, text string) int {
+	if strings.TrimSpace(text) == "" {
+		return 0
+	}
+	tokens, err := b.tokens.CountTokens(ctx, text)
+	if err != nil {
+		return 0
+	}
+	return tokens
+}
+
+func (b *ContextBudgeter) summarize(ctx context.Context, text, query string) string {
+	sumCtx, cancel := context.WithTimeout(ctx, b.cfg.LLMRequestTimeout)
+	defer cancel()
+	summary, err := b.summarizer.SummarizeState(sumCtx, text, query)
+	if err != nil {
+		b.logger.Warn("Failed to summarize for context budget", zap.Error(err))
+		return ""
+	}
+	return strings.TrimSpace(summary)
+}
+
+// trimHistory packs the history into what is left of the budget once tokensToRemove are
+// freed, dropping the lowest-scoring messages rather than simply the oldest (see
+// ContextPacker). Returns the removed messages, nil when none were removed or token counting
+// failed.
+func (b *ContextBudgeter) trimHistory(ctx context.Context, fit *ContextFit, tokensToRemove int) []types.AgentMessage {
+	history := fit.History
+	historyTokens := 0
+	for i := range history {
+		if !b.ensureTokenCount(ctx, history, i) {
+			return nil
+		}
+		historyTokens += history[i].TokenCount
+	}
+
+	kept, removed := b.packer.Pack(history, historyTokens-tokensToRemove)
+	if len(removed) == 0 {
+		return nil
+	}
+	tokensTrimmed := 0
+	for _, msg := range removed {
+		tokensTrimmed += msg.TokenCount
+	}
+	fit.History = kept
+	fit.Report.MessagesTrimmed = len(removed)
+	fit.Report.TokensTrimmed = tokensTrimmed
+	return removed
+}
+
+// ensureTokenCount caches the token count on history[i]; false when counting fails.
+func (b *ContextBudgeter) ensureTokenCount(ctx context.Context, history []types.AgentMessage, i int) bool {
+	if history[i].TokenCountComputed {
+		return true
+	}
+	tokens, err := b.tokens.CountTokens(ctx, history[i].Content)
+	if err != nil {
+		b.logger.Warn("Failed to count tokens for message during trimming",
+			zap.Error(err),
+			zap.Int("index", i))
+		return false
+	}
+	history[i].TokenCount = tokens
+	history[i].TokenCountComputed = true
+	return true
+}
+
+// summarizeTrimmed appends a summary of the removed messages to the state when it fits in
+// the remaining budget. Returns the updated message token total.
+func (b *ContextBudgeter) summarizeTrimmed(ctx context.Context, fit *ContextFit, removed []types.AgentMessage, query string, totalTokens, allowed int) int {
+	var transcript strings.Builder
+	for _, msg := range removed {
+		transcript.WriteString(msg.Role)
+		transcript.WriteString(": ")
+		transcript.WriteString(msg.Content)
+		transcript.WriteString("\n\n")
+	}
+	summary := b.summarize(ctx, transcript.String(), query)
+	if summary == "" {
+		return totalTokens
+	}
+	summaryTokens := b.count(ctx, summary)
+	if totalTokens+summaryTokens > allowed {
+		b.logger.Debug("Trimmed history summary does not fit; discarding it",
+			zap.Int("summary_tokens", summaryTokens),
+			zap.Int("headroom", allowed-totalTokens))
+		return totalTokens
+	}
+	fit.State = strings.TrimSpace(fit.State + "\n" + summary)
+	fit.Report.StateTokens += summaryTokens
+	fit.Report.applied(StrategySummarizeHistory)
+	return totalTokens + summaryTokens
+}
+
+func (b *ContextBudgeter) log(sessionID string, report *ContextBudgetReport) {
+	strategies := make([]string, len(report.Applied))
+	for i, s := range report.Applied {
+		strategies[i] = string(s)
+	}
+	fields := []zap.Field{
+		zap.String("session_id", sessionID),
+		zap.Strings("strategies", strategies),
+		zap.Int("response_tokens", report.ResponseTokens),
+		zap.Int("max_prompt_tokens", report.MaxPromptTokens),
+		zap.Int("overhead_cap", report.OverheadCap),
+		zap.Int("system_tokens", report.SystemTokens),
+		zap.Int("state_tokens", report.StateTokens),
+		zap.Int("evidence_tokens", report.EvidenceTokens),
+		zap.Int("initial_tokens", report.InitialTokens),
+		zap.Int("final_tokens", report.FinalTokens),
+		zap.Int("messages_trimmed", report.MessagesTrimmed),
+		zap.Int("tokens_trimmed", report.TokensTrimmed),
+	}
+	if report.Overflow() > 0 {
+		b.logger.Warn("Context exceeds budget after all strategies", append(fields, zap.Int("overflow_tokens", report.Overflow()))...)
+		return
+	}
+	if len(report.Applied) == 0 {
+		b.logger.Debug("Context fits budget", fields...)
+		return
+	}
+	b.logger.Info("Adjusted context to fit budget", fields...)
+}
@@ -0,0 +1,283 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"stats-agent/config"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// wordCounter counts one token per whitespace-separated word.
+type wordCounter struct {
+	fail bool
+}
+
+func (w wordCounter) CountTokens(_ context.Context, text string) (int, error) {
+	if w.fail {
+		return 0, errors.New("tokenizer unavailable")
+	}
+	return len(strings.Fields(text)), nil
+}
+
+func (w wordCounter) CalculateHistorySize(ctx context.Context, history []types.AgentMessage) (int, error) {
+	total := 0
+	for _, msg := range history {
+		tokens, err := w.CountTokens(ctx, msg.Content)
+		if err != nil {
+			return 0, err
+		}
+		total += tokens
+	}
+	return total, nil
+}
+
+// fixedSummarizer returns the same summary for every call.
+type fixedSummarizer string
+
+func (s fixedSummarizer) SummarizeState(context.Context, string, string) (string, error) {
+	return string(s), nil
+}
+
+// words returns n space-separated words, n tokens for wordCounter.
+func words(n int) string {
+	return strings.TrimSpace(strings.Repeat("w ", n))
+}
+
+func alternatingHistory(n, wordsEach int) []types.AgentMessage {
+	history := make([]types.AgentMessage, n)
+	for i := range history {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		history[i] = types.AgentMessage{Role: role, Content: words(wordsEach)}
+	}
+	return history
+}
+
+func TestContextBudgeterFit(t *testing.T) {
+	// Prompt budget 100-20 = 80 tokens; half of it (40) is the overhead cap, and the
+	// 10-token system prompt leaves 70 for the messages
+	tests := []struct {
+		name            string
+		state           string
+		evidence        string
+		history         []types.AgentMessage
+		summarizer      stateSummarizer
+		summarizeTrim   bool
+		failCounting    bool
+		wantCounted     bool
+		wantApplied     []BudgetStrategy
+		wantState       string
+		wantEvidence    string
+		wantHistory     int
+		wantFinalTokens int
+	}{
+		{
+			name:            "fits unchanged",
+			state:           words(5),
+			evidence:        words(5),
+			history:         alternatingHistory(3, 5),
+			wantCounted:     true,
+			wantState:       words(5),
+			wantEvidence:    words(5),
+			wantHistory:     3,
+			wantFinalTokens: 25,
+		},
+		{
+			name:            "state over the overhead cap is compressed",
+			state:           words(40),
+			history:         alternatingHistory(2, 5),
+			summarizer:      fixedSummarizer("short state"),
+			wantCounted:     true,
+			wantApplied:     []BudgetStrategy{StrategyCompressState},
+			wantState:       "short state",
+			wantHistory:     2,
+			wantFinalTokens: 12,
+		},
+		{
+			name:            "evidence is dropped without a summarizer",
+			state:           words(10),
+			evidence:        words(30),
+			history:         alternatingHistory(2, 5),
+			wantCounted:     true,
+			wantApplied:     []BudgetStrategy{StrategyDropEvidence},
+			wantState:       words(10),
+			wantHistory:     2,
+			wantFinalTokens: 20,
+		},
+		{
+			name:            "history over the budget is trimmed",
+			history:         alternatingHistory(4, 25),
+			wantCounted:     true,
+			wantApplied:     []BudgetStrategy{StrategyTrimHistory},
+			wantHistory:     2,
+			wantFinalTokens: 50,
+		},
+		{
+			name:            "trimmed history is summarized into the state",
+			history:         alternatingHistory(4, 25),
+			summarizer:      fixedSummarizer("earlier results"),
+			summarizeTrim:   true,
+			wantCounted:     true,
+			wantApplied:     []BudgetStrategy{StrategyTrimHistory, StrategySummarizeHistory},
+			wantState:       "earlier results",
+			wantHistory:     2,
+			wantFinalTokens: 52,
+		},
+		{
+			name:         "failed counting passes the prompt through",
+			state:        words(200),
+			history:      alternatingHistory(4, 25),
+			failCounting: true,
+			wantState:    words(200),
+			wantHistory:  4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				ContextLength:           100,
+				ContextSoftLimitRatio:   0.5,
+				ContextSummarizeTrimmed: tt.summarizeTrim,
+				LLMRequestTimeout:       time.Second,
+			}
+			budgeter := NewContextBudgeter(cfg, wordCounter{fail: tt.failCounting}, tt.summarizer, NewResponseHandler(cfg, zap.NewNop()), zap.NewNop())
+
+			fit := budgeter.Fit(context.Background(), ContextRequest{
+				SessionID:      "session",
+				Query:          "question",
+				SystemPrompt:   words(10),
+				State:          tt.state,
+				Evidence:       tt.evidence,
+				History:        tt.history,
+				ResponseTokens: 20,
+			})
+
+			if fit.Report.Counted != tt.wantCounted {
+				t.Errorf("Counted = %v, want %v", fit.Report.Counted, tt.wantCounted)
+			}
+			if !slices.Equal(fit.Report.Applied, tt.wantApplied) {
+				t.Errorf("Applied = %v, want %v", fit.Report.Applied, tt.wantApplied)
+			}
+			if fit.State != tt.wantState {
+				t.Errorf("State = %q, want %q", fit.State, tt.wantState)
+			}
+			if fit.Evidence != tt.wantEvidence {
+				t.Errorf("Evidence = %q, want %q", fit.Evidence, tt.wantEvidence)
+			}
+			if len(fit.History) != tt.wantHistory {
+				t.Errorf("len(History) = %d, want %d", len(fit.History), tt.wantHistory)
+			}
+			if fit.Report.FinalTokens != tt.wantFinalTokens {
+				t.Errorf("FinalTokens = %d, want %d", fit.Report.FinalTokens, tt.wantFinalTokens)
+			}
+			if overflow := fit.Report.Overflow(); overflow != 0 {
+				t.Errorf("Overflow() = %d, want 0", overflow)
+			}
+			if n := len(tt.history); n > 0 && fit.History[len(fit.History)-1].Content != tt.history[n-1].Content {
+				t.Error("newest history message was not kept")
+			}
+		})
+	}
+}
+
+func TestContextPackerPack(t *testing.T) {
+	message := func(role, content string, tokens int, pinned bool) types.AgentMessage {
+		msg := types.AgentMessage{Role: role, Content: content, TokenCount: tokens, TokenCountComputed: true}
+		if pinned {
+			msg.Metadata = map[string]string{PinnedMetadataKey: "true"}
+		}
+		return msg
+	}
+
+	tests := []struct {
+		name        string
+		history     []types.AgentMessage
+		budget      int
+		wantKept    []string
+		wantRemoved []string
+	}{
+		{
+			name: "everything fits",
+			history: []types.AgentMessage{
+				message("user", "q1", 10, false),
+				message("assistant", "a1", 10, false),
+			},
+			budget:   20,
+			wantKept: []string{"q1", "a1"},
+		},
+		{
+			name: "oldest messages are dropped first",
+			history: []types.AgentMessage{
+				message("user", "q1", 10, false),
+				message("assistant", "a1", 10, false),
+				message("user", "q2", 10, false),
+				message("assistant", "a2", 10, false),
+			},
+			budget:      20,
+			wantKept:    []string{"q2", "a2"},
+			wantRemoved: []string{"q1", "a1"},
+		},
+		{
+			name: "a pinned message outlives newer ones",
+			history: []types.AgentMessage{
+				message("tool", "pinned output", 10, true),
+				message("user", "q1", 10, false),
+				message("assistant", "a1", 10, false),
+			},
+			budget:      20,
+			wantKept:    []string{"pinned output", "a1"},
+			wantRemoved: []string{"q1"},
+		},
+		{
+			name: "code and its output are kept or dropped together",
+			history: []types.AgentMessage{
+				message("user", "q1", 5, false),
+				message("assistant", "```python\nprint(1)\n```", 10, false),
+				message("tool", "1", 10, false),
+				message("user", "q2", 5, false),
+			},
+			budget:      20,
+			wantKept:    []string{"q1", "q2"},
+			wantRemoved: []string{"```python\nprint(1)\n```", "1"},
+		},
+		{
+			name: "the newest message is kept even over the budget",
+			history: []types.AgentMessage{
+				message("user", "q1", 10, false),
+				message("assistant", "long answer", 50, false),
+			},
+			budget:      20,
+			wantKept:    []string{"long answer"},
+			wantRemoved: []string{"q1"},
+		},
+	}
+
+	contents := func(messages []types.AgentMessage) []string {
+		var out []string
+		for _, msg := range messages {
+			out = append(out, msg.Content)
+		}
+		return out
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, removed := ContextPacker{}.Pack(tt.history, tt.budget)
+			if got := contents(kept); !slices.Equal(got, tt.wantKept) {
+				t.Errorf("kept = %q, want %q", got, tt.wantKept)
+			}
+			if got := contents(removed); !slices.Equal(got, tt.wantRemoved) {
+				t.Errorf("removed = %q, want %q", got, tt.wantRemoved)
+			}
+		})
+	}
+}
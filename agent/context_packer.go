@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"math"
+
+	"stats-agent/web/format"
+	"stats-agent/web/types"
+)
+
+// PinnedMetadataKey marks a history message the packer should keep when it can; the chat
+// handler sets it to "true" on the tool output of bookmarked steps.
+const PinnedMetadataKey = "pinned"
+
+const (
+	// packRecencyHalfLife is how many units back a unit's recency weight halves.
+	packRecencyHalfLife = 4.0
+	// packFactPairBonus is added for an assistant code message kept with its tool output,
+	// the pairs RAG stores as facts.
+	packFactPairBonus = 0.5
+	// packPinnedBonus is added for pinned units regardless of age, so a pinned step
+	// outscores any unpinned unit.
+	packPinnedBonus = 2.0
+	// maxPackBuckets caps the knapsack's capacity; larger budgets are scaled down, rounding
+	// weights up so the selection never exceeds the budget.
+	maxPackBuckets = 2048
+)
+
+// packRoleWeights weight single messages by role. User messages carry the questions the
+// analysis answers; a tool output without its code (rare) is the least useful on its own.
+var packRoleWeights = map[string]float64{
+	"user":      1.0,
+	"assistant": 0.7,
+	"tool":      0.5,
+}
+
+// packUnit is a run of history messages kept or dropped together.
+type packUnit struct {
+	start, end int // history[start:end]
+	tokens     int
+	weight     float64
+	pinned     bool
+	value      float64
+}
+
+// ContextPacker selects which history messages fit a token budget. History is split into
+// units (an assistant code message with its tool output, otherwise a single message), each
+// scored by recency, role, whether it is a fact pair and whether it is pinned, and the
+// highest-scoring subset under the budget is chosen with a 0/1 knapsack. The most recent
+// unit is always kept so the history never empties.
+type ContextPacker struct{}
+
+// Pack returns the kept messages and the dropped ones, both in their original order.
+// Token counts must already be set on history. removed is nil when everything fits.
+func (ContextPacker) Pack(history []types.AgentMessage, budget int) (kept, removed []types.AgentMessage) {
+	units := packUnits(history)
+	if len(units) == 0 {
+		return history, nil
+	}
+	total := 0
+	for _, u := range units {
+		total += u.tokens
+	}
+	if total <= budget {
+		return history, nil
+	}
+
+	last := len(units) - 1
+	selected := knapsack(units[:last], budget-units[last].tokens)
+	selected = append(selected, last)
+
+	keep := make([]bool, len(units))
+	for _, i := range selected {
+		keep[i] = true
+	}
+	for i, u := range units {
+		if keep[i] {
+			kept = append(kept, history[u.start:u.end]...)
+		} else {
+			removed = append(removed, history[u.start:u.end]...)
+		}
+	}
+	return kept, removed
+}
+
+// packUnits groups history into units and scores them.
+func packUnits(history []types.AgentMessage) []packUnit {
+	var units []packUnit
+	for i := 0; i < len(history); {
+		msg := history[i]
+		u := packUnit{start: i, end: i + 1, tokens: msg.TokenCount}
+		pinned := msg.Metadata[PinnedMetadataKey] == "true"
+		weight := packRoleWeights[msg.Role]
+		if msg.Role == "assistant" && format.HasCodeBlock(msg.Content) &&
+			i+1 < len(history) && history[i+1].Role == "tool" {
+			u.end = i + 2
+			u.tokens += history[i+1].TokenCount
+			pinned = pinned || history[i+1].Metadata[PinnedMetadataKey] == "true"
+			weight = 1.0 + packFactPairBonus
+		}
+		u.weight, u.pinned = weight, pinned
+		units = append(units, u)
+		i = u.end
+	}
+
+	// Recency decays from the newest unit; the pinned bonus is not decayed
+	for k := range units {
+		age := float64(len(units) - 1 - k)
+		units[k].value = units[k].weight * math.Pow(0.5, age/packRecencyHalfLife)
+		if units[k].pinned {
+			units[k].value += packPinnedBonus
+		}
+	}
+	return units
+}
+
+// knapsack returns the indexes of the units with the highest total value whose tokens fit
+// in capacity.
+func knapsack(units []packUnit, capacity int) []int {
+	if capacity <= 0 || len(units) == 0 {
+		return nil
+	}
+	scale := 1
+	if capacity > maxPackBuckets {
+		scale = (capacity + maxPackBuckets - 1) / maxPackBuckets
+	}
+	buckets := capacity / scale
+	weights := make([]int, len(units))
+	for i, u := range units {
+		weights[i] = (u.tokens + scale - 1) / scale
+	}
+
+	best := make([]float64, buckets+1)
+	take := make([][]bool, len(units))
+	for i, u := range units {
+		take[i] = make([]bool, buckets+1)
+		for c := buckets; c >= weights[i]; c-- {
+			if v := best[c-weights[i]] + u.value; v > best[c] {
+				best[c] = v
+				take[i][c] = true
+			}
+		}
+	}
+
+	var selected []int
+	c := buckets
+	for i := len(units) - 1; i >= 0; i-- {
+		if take[i][c] {
+			selected = append(selected, i)
+			c -= weights[i]
+		}
+	}
+	return selected
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// ContextOverflowEvent is the client event sent when a turn's prompt still exceeds the
+// context window after budgeting.
+const ContextOverflowEvent = "context_overflow"
+
+// ContextOverflow explains a rejected turn: how far over the window the prompt is and
+// what the user can do about it.
+type ContextOverflow struct {
+	PromptTokens    int      `json:"prompt_tokens"`
+	MaxPromptTokens int      `json:"max_prompt_tokens"`
+	ResponseTokens  int      `json:"response_tokens"`
+	Message         string   `json:"message"`
+	Suggestions     []string `json:"suggestions"`
+}
+
+// preflightContext checks a budgeted prompt before it is dispatched. When compression and
+// trimming could not bring it under the window, the turn is rejected with a
+// context_overflow event instead of being sent: the server would return an empty
+// response and trigger the blind recovery path. Returns true when the turn may proceed.
+func (a *Agent) preflightContext(ctx context.Context, sessionID, input string, fit ContextFit, stream *Stream) bool {
+	report := fit.Report
+	overflow := report.Overflow()
+	if overflow == 0 {
+		return true
+	}
+
+	rejection := ContextOverflow{
+		PromptTokens:    report.SystemTokens + report.FinalTokens,
+		MaxPromptTokens: report.MaxPromptTokens,
+		ResponseTokens:  report.ResponseTokens,
+		Message: fmt.Sprintf("This request needs about %d prompt tokens, %d more than the model's context allows even after compressing memory and trimming history, so it was not sent.",
+			report.SystemTokens+report.FinalTokens, overflow),
+		Suggestions: a.overflowSuggestions(ctx, sessionID, input, fit),
+	}
+	a.logger.Warn("Rejected turn that exceeds the context window",
+		zap.String("session_id", sessionID),
+		zap.Int("prompt_tokens", rejection.PromptTokens),
+		zap.Int("max_prompt_tokens", rejection.MaxPromptTokens),
+		zap.Int("overflow_tokens", overflow))
+
+	if stream == nil {
+		return false
+	}
+	payload, err := json.Marshal(rejection)
+	if err != nil || !stream.Event(ContextOverflowEvent, string(payload)) {
+		_ = stream.Status(rejection.Message)
+	}
+	return false
+}
+
+// overflowSuggestions picks the remedies that apply to this prompt, most specific first.
+// Starting a new session always applies.
+func (a *Agent) overflowSuggestions(ctx context.Context, sessionID, input string, fit ContextFit) []string {
+	report := fit.Report
+	var suggestions []string
+
+	if inputTokens, err := a.memoryManager.CountTokens(ctx, input); err == nil && inputTokens > report.MaxPromptTokens/4 {
+		suggestions = append(suggestions, "Shorten your message, or upload long text as a file instead of pasting it.")
+	}
+	if last := len(fit.History) - 1; last >= 0 && fit.History[last].Role == "tool" &&
+		fit.History[last].TokenCount > report.MaxPromptTokens/4 {
+		suggestions = append(suggestions, "The last code output is very large; ask for a summary of it (for example head() or describe()) instead of the full printout.")
+	}
+	if a.responseHandler.Verbosity(sessionID) == types.VerbosityTeaching {
+		suggestions = append(suggestions, "Switch verbosity to standard or terse; teaching reserves half again as much room for the answer.")
+	}
+	if report.StateTokens > report.MaxPromptTokens/4 {
+		suggestions = append(suggestions, "Ask a narrower question so less session memory is retrieved.")
+	}
+	return append(suggestions, "Start a new session for the next analysis; this session's results stay available.")
+}
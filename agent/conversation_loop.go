@@ -1,6 +1,9 @@
 package agent
 
 import (
+	"fmt"
+	"time"
+
 	"stats-agent/config"
 
 	"go.uber.org/zap"
@@ -14,6 +17,11 @@ type ConversationLoop struct {
 	logger               *zap.Logger
 	actionRetries        map[string]int // Track retries per action signature hash
 	maxRetriesPerAction  int            // Maximum retries allowed per unique action
+
+	// Run budget usage
+	startedAt     time.Time
+	llmCalls      int
+	executedCells int
 }
 
 // NewConversationLoop creates a new conversation loop instance.
@@ -25,11 +33,13 @@ func NewConversationLoop(cfg *config.Config, logger *zap.Logger) *ConversationLo
 		logger:              logger,
 		actionRetries:       make(map[string]int),
 		maxRetriesPerAction: 1, // Allow 1 retry per unique action
+		startedAt:           time.Now(),
 	}
 }
 
-// ShouldContinue checks if the loop should continue based on turn count and consecutive errors.
+// ShouldContinue checks if the loop should continue based on consecutive errors.
 // Returns (shouldContinue, reason). If shouldContinue is false, reason contains the break message.
+// Turn and run budgets are checked separately by BudgetExhausted.
 func (c *ConversationLoop) ShouldContinue(turn int) (bool, string) {
 	// Check if we've hit the error limit
 	if c.consecutiveErrors >= c.cfg.ConsecutiveErrors {
@@ -38,14 +48,34 @@ func (c *ConversationLoop) ShouldContinue(turn int) (bool, string) {
 		return false, "Consecutive errors, user feedback needed."
 	}
 
-	// Check if we've hit max turns
-	if turn >= c.cfg.MaxTurns {
-		c.logger.Info("Reached maximum turns limit",
-			zap.Int("max_turns", c.cfg.MaxTurns))
-		return false, "Maximum turns reached."
+	return true, ""
+}
+
+// BudgetExhausted returns which run budget leaves no room for another analysis turn, or ""
+// if none. MaxTurns counts analysis turns only; the summary turn comes on top, so even
+// MAX_TURNS=1 ends with one.
+func (c *ConversationLoop) BudgetExhausted(turn int) string {
+	switch {
+	case turn >= c.cfg.MaxTurns:
+		return fmt.Sprintf("the limit of %d turns was reached", c.cfg.MaxTurns)
+	case c.cfg.RunMaxDuration > 0 && time.Since(c.startedAt) >= c.cfg.RunMaxDuration:
+		return fmt.Sprintf("the time budget of %s was used up", c.cfg.RunMaxDuration)
+	case c.cfg.RunMaxLLMCalls > 0 && c.llmCalls >= c.cfg.RunMaxLLMCalls:
+		return fmt.Sprintf("the budget of %d LLM calls was used up", c.cfg.RunMaxLLMCalls)
+	case c.cfg.RunMaxExecutedCells > 0 && c.executedCells >= c.cfg.RunMaxExecutedCells:
+		return fmt.Sprintf("the budget of %d executed code cells was used up", c.cfg.RunMaxExecutedCells)
 	}
+	return ""
+}
 
-	return true, ""
+// RecordLLMCall counts an analysis LLM call against the run budget.
+func (c *ConversationLoop) RecordLLMCall() {
+	c.llmCalls++
+}
+
+// RecordExecution counts an executed code cell against the run budget.
+func (c *ConversationLoop) RecordExecution() {
+	c.executedCells++
 }
 
 // GetCurrentTemperature returns the current temperature based on consecutive errors.
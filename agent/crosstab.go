@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"stats-agent/tools"
+
+	"go.uber.org/zap"
+)
+
+// crosstabMaxLevels is the most distinct values a column may have to be tabulated.
+const crosstabMaxLevels = 20
+
+// crosstabRequestRegex detects questions about the association of two categorical variables.
+var crosstabRequestRegex = regexp.MustCompile(`(?i)\b(cross[\s-]?tab(ulat(e|ion))?s?|contingency\s+tables?|chi[\s-]?(square[d]?|sq|2)|fisher'?s?\s+exact|two[\s-]way\s+table)\b`)
+
+// isCrosstabRequest heuristically detects crosstab questions
+// (e.g., "crosstab smoker by gender", "is there a chi-square association between ...").
+func isCrosstabRequest(input string) bool {
+	return crosstabRequestRegex.MatchString(input)
+}
+
+// matchCrosstabColumns returns the first two tabulable columns named in the input, in the
+// order they are mentioned. Longer names win where names overlap ("age_group" over "age").
+func matchCrosstabColumns(input string, columns []tools.CrosstabColumn) (string, string, bool) {
+	lower := strings.ToLower(input)
+	isWordChar := func(i int) bool {
+		if i < 0 || i >= len(lower) {
+			return false
+		}
+		c := lower[i]
+		return c == '_' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+	}
+
+	type mention struct {
+		name       string
+		start, end int
+	}
+	var mentions []mention
+	for _, col := range columns {
+		name := strings.ToLower(strings.TrimSpace(col.Name))
+		if name == "" || col.Levels < 2 || col.Levels > crosstabMaxLevels {
+			continue
+		}
+		for from := 0; from < len(lower); {
+			idx := strings.Index(lower[from:], name)
+			if idx < 0 {
+				break
+			}
+			start := from + idx
+			end := start + len(name)
+			if !isWordChar(start-1) && !isWordChar(end) {
+				mentions = append(mentions, mention{name: col.Name, start: start, end: end})
+				break
+			}
+			from = start + 1
+		}
+	}
+
+	// Longest mentions first so they claim their span before the names they contain
+	sort.SliceStable(mentions, func(i, j int) bool {
+		return mentions[i].end-mentions[i].start > mentions[j].end-mentions[j].start
+	})
+	var kept []mention
+	for _, m := range mentions {
+		overlaps := false
+		for _, k := range kept {
+			if m.start < k.end && k.start < m.end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, m)
+		}
+	}
+	if len(kept) < 2 {
+		return "", "", false
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].start < kept[j].start })
+	return kept[0].name, kept[1].name, true
+}
+
+// crosstabHelperResponse builds a first-turn assistant response that runs the templated
+// contingency analysis for the two columns the question names. Returns "" when the
+// question is not a crosstab question or its columns can't be identified, leaving the
+// turn to the LLM.
+func (a *Agent) crosstabHelperResponse(ctx context.Context, sessionID, input string) string {
+	if !isCrosstabRequest(input) {
+		return ""
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, a.cfg.LLMRequestTimeout)
+	defer cancel()
+	frame, err := a.pythonTool.CrosstabFrame(probeCtx, sessionID)
+	if err != nil {
+		a.logger.Warn("Crosstab helper could not read the dataset columns, leaving the turn to the LLM",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+		return ""
+	}
+	rowColumn, colColumn, ok := matchCrosstabColumns(input, frame.Columns)
+	if !ok {
+		a.logger.Debug("Crosstab helper found fewer than two categorical columns in the question",
+			zap.String("session_id", sessionID))
+		return ""
+	}
+
+	a.logger.Info("Running crosstab helper",
+		zap.String("session_id", sessionID),
+		zap.String("rows", rowColumn),
+		zap.String("columns", colColumn))
+	return fmt.Sprintf("Tabulating `%s` by `%s`, checking expected counts, and running the test they call for (chi-square, or Fisher's exact test for a sparse 2x2 table).\n\n```python\n%s```",
+		rowColumn, colColumn, tools.CrosstabCode(frame.Dataset, rowColumn, colColumn))
+}
@@ -7,17 +7,28 @@ import (
 	"regexp"
 	"strings"
 
+	"stats-agent/llmclient"
 	"stats-agent/prompts"
 	"stats-agent/rag"
+	"stats-agent/tracing"
 	"stats-agent/web/format"
 	"stats-agent/web/types"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
 // Run executes the agent's conversation loop with the given user input.
 // It orchestrates memory management, LLM interaction, and Python code execution.
 func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID string, history []types.AgentMessage, stream *Stream) {
+	ctx, span := tracing.Start(ctx, "agent.run", tracing.Session(sessionID), tracing.AttrMode.String(types.ModeDataset))
+	defer span.End()
+
+	// Memory entries are numbered [mN] per run for cited footnotes
+	if a.rag != nil {
+		a.rag.ResetMemoryCitations(sessionID)
+	}
+
 	// 1. Create user message but DON'T add to history or RAG yet
 	// It will be added at the end of the turn along with the assistant response
 	userMsg := types.AgentMessage{
@@ -26,12 +37,78 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 		ContentHash: rag.ComputeMessageContentHash("user", input),
 	}
 
+	// Explicit finish: one summarization turn instead of the analysis loop
+	if IsFinishCommand(input) {
+		a.runFinishSummary(ctx, input, sessionID, history, stream)
+		return
+	}
+
+	// Sessions initialized before a restart have no cached environment descriptor yet
+	a.ensureSessionEnvironment(ctx, sessionID)
+
 	// 2. Initialize conversation loop controller
 	loop := NewConversationLoop(a.cfg, a.logger)
+	runID := uuid.NewString()
+	span.SetAttributes(tracing.AttrRunID.String(runID))
 
 	// 3. Main conversation loop
 	var ephemeralEvidence string
-	for turn := 0; turn < a.cfg.MaxTurns; turn++ {
+
+	// Effect size enforcement: the note for the latest test reported without an effect size,
+	// and whether the one allowed auto follow-up turn was spent
+	var pendingEffectSize string
+	effectSizeAutoTurnUsed := false
+
+	// Comparison workflow: profile both datasets up front and attach the report as turn-0 evidence
+	if isComparisonRequest(input) {
+		ephemeralEvidence = a.buildComparisonEvidence(ctx, sessionID, input, stream)
+	}
+
+	// Concluded session: answer without new code unless the user asks for more analysis
+	if a.rag != nil && a.rag.IsSessionComplete(ctx, sessionID) {
+		if userRequestsNewAnalysis(input) {
+			a.rag.ReopenSession(ctx, sessionID)
+		} else {
+			// Kept beside the comparison report, which would otherwise invite new code
+			ephemeralEvidence = strings.TrimSpace(ephemeralEvidence + "\n<evidence>\n" + completedSessionNote + "\n</evidence>")
+		}
+	}
+
+	// Crosstab questions: run the table, expected counts and the test they call for as the first turn
+	var crosstabTurn string
+	if ephemeralEvidence == "" {
+		crosstabTurn = a.crosstabHelperResponse(ctx, sessionID, input)
+	}
+
+	// Analysis plan: asked for once per request, then followed and ticked off turn by turn
+	if a.planner != nil && crosstabTurn == "" && ephemeralEvidence == "" {
+		a.planRun(ctx, sessionID, input, stream)
+	}
+
+	// Categorical levels the user mentions, resolved to the columns that hold them
+	levelMatches := a.queryBuilder.ResolveLevels(input, a.sessionColumnLevels(sessionID))
+	levelMapping := levelMappingBlock(levelMatches)
+	levelTokens := a.queryBuilder.LevelQueryTokens(levelMatches)
+
+	// Retrieval experiment outcome: how many turns the run needed
+	turnsUsed := 0
+	if a.rag != nil {
+		defer func() { a.rag.RecordRetrievalOutcome(sessionID, rag.SignalTurns, float64(turnsUsed)) }()
+	}
+
+	// Usage telemetry: turns, executed cells, errors and test types of the run
+	var usage types.RunUsage
+	if a.usageRecorder != nil {
+		defer func() {
+			usage.Turns = turnsUsed
+			a.usageRecorder.RecordRun(usage)
+		}()
+	}
+
+	// One iteration past MaxTurns for the summary turn BudgetExhausted triggers there
+	for turn := 0; turn <= a.cfg.MaxTurns; turn++ {
+		turnsUsed = turn + 1
+		tracing.MarkTurn(ctx, turn)
 		// Manage memory before each turn - non-critical, log warning if fails
 		if err := a.memoryManager.ManageHistory(ctx, sessionID, &history, stream); err != nil {
 			a.logger.Warn("Failed to manage memory, continuing with current history",
@@ -43,13 +120,23 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 		// Check loop conditions (error limit, max turns)
 		if shouldContinue, reason := loop.ShouldContinue(turn); !shouldContinue {
 			_ = stream.Status(reason)
+			stream.Fail(reason)
 			break
 		}
 
+		// Out of turns or budget: end with a summary of the findings instead of stopping abruptly
+		if reason := loop.BudgetExhausted(turn); reason != "" {
+			a.runBudgetSummary(ctx, reason, sessionID, history, stream)
+			return
+		}
+
 		// Query RAG for state before each turn
 		// This ensures newly added content (PDFs, facts) is available
 		// Build structured query using QueryBuilder (combines fact summaries, metadata, values, synonyms)
 		queryText := a.queryBuilder.BuildRAGQuery(ctx, input, sessionID, history, turn)
+		if levelTokens != "" {
+			queryText += " " + levelTokens
+		}
 
 		// Build sets for post-query pruning (O(1) lookups)
 		excludeHashSet := make(map[string]bool)
@@ -114,7 +201,7 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 		// Add timeout to RAG query to avoid hangs
 		ragCtx, ragCancel := context.WithTimeout(ctx, a.cfg.LLMRequestTimeout)
 		defer ragCancel()
-		state, err := a.rag.Query(ragCtx, sessionID, queryText, a.cfg.RAGResults, excludeHashes, historyDocIDs, doneLedger, "dataset")
+		state, err := a.rag.Query(ragCtx, sessionID, queryText, a.cfg.RetrievalBudget(types.ModeDataset), excludeHashes, historyDocIDs, doneLedger, types.ModeDataset)
 		if err != nil {
 			a.logger.Warn("Failed to query RAG for state, continuing without it",
 				zap.Error(err),
@@ -128,182 +215,155 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 		if turn == 0 {
 			history = append(history, userMsg)
 		}
+		// The cohort definition rides along every turn so the agent knows the current analytic sample
 		evidenceForThisTurn := ephemeralEvidence
-		messagesForLLM := a.responseHandler.BuildMessagesForLLMWithEvidence(state, evidenceForThisTurn, history)
-		// Evidence is ephemeral: clear after attaching once
-		ephemeralEvidence = ""
-
-		// Ensure entire payload fits within configured budgets
-		{
-			// Measure current messages (memory + evidence + history)
-			totalTokens, err := a.memoryManager.CalculateHistorySize(ctx, messagesForLLM)
-			if err != nil {
-				a.logger.Warn("Failed to count tokens for combined context; proceeding optimistically", zap.Error(err))
-			} else {
-				// Account for fixed overhead (system prompt) and reserve a recency budget
-				systemTokens, sysErr := a.memoryManager.CountTokens(ctx, prompts.AgentSystem())
-				if sysErr != nil {
-					a.logger.Warn("Failed to count tokens for system prompt; using 0 overhead", zap.Error(sysErr))
-					systemTokens = 0
-				}
-
-				// Measure state and evidence separately to enforce an overhead cap
-				stateTokens := 0
-				if strings.TrimSpace(state) != "" {
-					if tok, err2 := a.memoryManager.CountTokens(ctx, state); err2 == nil {
-						stateTokens = tok
-					}
-				}
-				evidenceTokens := 0
-				if strings.TrimSpace(evidenceForThisTurn) != "" {
-					if tok, err2 := a.memoryManager.CountTokens(ctx, evidenceForThisTurn); err2 == nil {
-						evidenceTokens = tok
-					}
-				}
-
-				maxPrompt := a.cfg.ContextLength - a.cfg.ResponseTokenBudget
-				if maxPrompt < 0 {
-					maxPrompt = 0
-				}
-				recencyMin := int(float64(maxPrompt) * a.cfg.ContextSoftLimitRatio)
-				if recencyMin < 0 {
-					recencyMin = 0
-				}
-				overheadCap := maxPrompt - recencyMin // budget for system + state + evidence
-				if overheadCap < 0 {
-					overheadCap = 0
-				}
-
-				overheadTokens := systemTokens + stateTokens + evidenceTokens
-
-				// If overhead exceeds its cap, first try to compress state
-				if overheadTokens > overheadCap && strings.TrimSpace(state) != "" {
-					sumCtx, sumCancel := context.WithTimeout(ctx, a.cfg.LLMRequestTimeout)
-					summarized, sErr := a.rag.SummarizeState(sumCtx, state, input)
-					sumCancel()
-					if sErr == nil && strings.TrimSpace(summarized) != "" {
-						state = summarized
-						messagesForLLM = a.responseHandler.BuildMessagesForLLMWithEvidence(state, evidenceForThisTurn, history)
-						// Recompute token counts
-						stateTokens = 0
-						if tok, err2 := a.memoryManager.CountTokens(ctx, state); err2 == nil {
-							stateTokens = tok
-						}
-						if tok, err2 := a.memoryManager.CountTokens(ctx, messagesForLLM[0].Content); err2 == nil {
-							_ = tok
-						} // noop to avoid lint
-						overheadTokens = systemTokens + stateTokens + evidenceTokens
-					}
-				}
-
-				// If still above cap, drop ephemeral evidence (turn-only)
-				if overheadTokens > overheadCap && evidenceTokens > 0 {
-					evidenceForThisTurn = ""
-					messagesForLLM = a.responseHandler.BuildMessagesForLLMWithEvidence(state, evidenceForThisTurn, history)
-					evidenceTokens = 0
-					overheadTokens = systemTokens + stateTokens
-				}
-
-				// Allowed tokens for all messages (state + evidence + history), excluding system prompt
-				allowed := maxPrompt - systemTokens
-				if allowed < 0 {
-					allowed = 0
-				}
-
-				if totalTokens > allowed {
-					a.logger.Warn("Compressing payload to fit context window", zap.Int("totalTokens", totalTokens))
-					// Recalculate after overhead adjustments
-					totalTokens, err = a.memoryManager.CalculateHistorySize(ctx, messagesForLLM)
-					if err != nil {
-						a.logger.Warn("Token recount after overhead adjustment failed", zap.Error(err))
-					}
-
-					// If still over, trim history tokens in one pass
-					if totalTokens > allowed {
-						tokensToRemove := totalTokens - allowed
-						tokensAccumulated := 0
-						cutoffIndex := 0
-
-						// Find cutoff point by accumulating token counts from oldest messages
-						for cutoffIndex < len(history) && tokensAccumulated < tokensToRemove {
-							msg := history[cutoffIndex]
-
-							// Ensure message has token count computed
-							if !msg.TokenCountComputed {
-								tokens, err := a.memoryManager.CountTokens(ctx, msg.Content)
-								if err != nil {
-									a.logger.Warn("Failed to count tokens for message during trimming",
-										zap.Error(err),
-										zap.Int("cutoff_index", cutoffIndex))
-									break
-								}
-								history[cutoffIndex].TokenCount = tokens
-								history[cutoffIndex].TokenCountComputed = true
-							}
-
-							// If this is an assistant message with code, check for tool pair
-							if msg.Role == "assistant" && format.HasCodeBlock(msg.Content) &&
-								cutoffIndex+1 < len(history) && history[cutoffIndex+1].Role == "tool" {
-								// Remove both messages together
-								toolMsg := history[cutoffIndex+1]
-								if !toolMsg.TokenCountComputed {
-									tokens, err := a.memoryManager.CountTokens(ctx, toolMsg.Content)
-									if err != nil {
-										a.logger.Warn("Failed to count tokens for tool message during trimming",
-											zap.Error(err))
-										break
-									}
-									history[cutoffIndex+1].TokenCount = tokens
-									history[cutoffIndex+1].TokenCountComputed = true
-								}
-								tokensAccumulated += msg.TokenCount + toolMsg.TokenCount
-								cutoffIndex += 2
-							} else {
-								tokensAccumulated += msg.TokenCount
-								cutoffIndex++
-							}
-						}
-
-						// Slice once at the cutoff point
-						if cutoffIndex > 0 && cutoffIndex < len(history) {
-							history = history[cutoffIndex:]
-
-							messagesForLLM = a.responseHandler.BuildMessagesForLLMWithEvidence(state, evidenceForThisTurn, history)
-
-							a.logger.Info("Trimmed history to fit context window",
-								zap.Int("messages_removed", cutoffIndex),
-								zap.Int("tokens_removed", tokensAccumulated),
-								zap.Int("remaining_messages", len(history)))
-						}
-					}
-				}
+		if cohort := a.cohortDefinition(sessionID); cohort != "" {
+			evidenceForThisTurn = strings.TrimSpace(cohort + "\n" + evidenceForThisTurn)
+		}
+		// User column type overrides ride along too so tests are chosen on the corrected types
+		if columnTypes := a.columnTypesBlock(sessionID); columnTypes != "" {
+			evidenceForThisTurn = strings.TrimSpace(columnTypes + "\n" + evidenceForThisTurn)
+		}
+		// The level mapping keeps filters and group-bys on the column that holds the mentioned values
+		if levelMapping != "" {
+			evidenceForThisTurn = strings.TrimSpace(levelMapping + "\n" + evidenceForThisTurn)
+		}
+		// The plan's remaining steps keep each turn on course
+		if a.planner != nil {
+			if plan := a.planner.Block(ctx, sessionID); plan != "" {
+				evidenceForThisTurn = strings.TrimSpace(plan + "\n" + evidenceForThisTurn)
 			}
 		}
+		// Evidence is ephemeral: clear after attaching once
+		ephemeralEvidence = ""
 
-		// Get LLM response with dynamic temperature - critical operation, break loop on failure
-		currentTemp := loop.GetCurrentTemperature()
-		responseChan, err := getLLMResponse(ctx, a.cfg.MainLLMHost, messagesForLLM, a.cfg, a.logger, &currentTemp)
-		if err != nil {
-			a.logger.Error("Failed to get LLM response, aborting turn",
-				zap.Error(err),
-				zap.Int("turn", turn),
-				zap.String("session_id", sessionID))
-			_ = stream.Status("LLM communication error")
+		// Ensure entire payload fits within configured budgets. The verbosity, environment, datasets and plotting
+		// blocks are sent alongside the system prompt, so they count as overhead
+		// The turn is classified first so a code step's smaller response budget frees prompt room
+		// and a write-up gets the room it needs to finish
+		turnType := a.classifyTurn(input, history)
+		responseTokens := a.responseHandler.NegotiatedResponseBudget(sessionID, turnType)
+		fit := a.contextBudgeter.Fit(ctx, ContextRequest{
+			SessionID:      sessionID,
+			Query:          input,
+			SystemPrompt:   prompts.AgentSystem() + a.responseHandler.VerbosityInstruction(sessionID) + a.environmentBlock(sessionID) + a.datasetsBlock(sessionID) + a.plotInstruction() + a.sqlInstruction() + a.toolCallInstruction(),
+			State:          state,
+			Evidence:       evidenceForThisTurn,
+			History:        history,
+			ResponseTokens: responseTokens,
+		})
+		state = fit.State
+		history = fit.History
+		messagesForLLM := fit.Messages
+		// A prompt that still overflows would come back empty; tell the user what to do instead
+		if !a.preflightContext(ctx, sessionID, input, fit, stream) {
+			stream.Fail("context window exceeded")
 			break
 		}
 
-		// Collect streamed response
-		llmResponse := a.responseHandler.CollectStreamedResponse(responseChan, stream)
+		// Verbosity instruction goes in after budgeting so rebuilt message lists keep it
+		messagesForLLM = a.responseHandler.ApplyVerbosity(sessionID, messagesForLLM)
+		messagesForLLM = a.applyEnvironment(sessionID, messagesForLLM)
+		messagesForLLM = a.applyDatasets(sessionID, messagesForLLM)
+		messagesForLLM = a.applyPlotInstruction(messagesForLLM)
+		messagesForLLM = a.applySQLInstruction(messagesForLLM)
+		messagesForLLM = a.applyToolCallInstruction(messagesForLLM)
+
+		var llmResponse string
+		var toolCall *llmclient.ToolCall
+		if turn == 0 && crosstabTurn != "" {
+			// The crosstab helper's templated analysis stands in for the first LLM call
+			llmResponse = crosstabTurn
+			_, _ = stream.WriteString(llmResponse + "\n")
+		} else {
+			// Get LLM response with dynamic temperature - critical operation, break loop on failure
+			currentTemp := loop.GetCurrentTemperature()
+			llmHost := a.sessionLLMHost(sessionID)
+			llmCtx := ctx
+			if a.cfg.ResponseBudgetNegotiation {
+				a.logger.Debug("Negotiated response budget",
+					zap.String("session_id", sessionID),
+					zap.Int("turn", turn),
+					zap.String("turn_type", turnType),
+					zap.Int("max_tokens", responseTokens))
+				llmCtx = llmclient.WithMaxTokens(ctx, responseTokens)
+			}
+			var toolCalls *llmclient.ToolCalls
+			if a.structuredToolCalls() {
+				toolCalls = &llmclient.ToolCalls{}
+				llmCtx = llmclient.WithTools(llmCtx, llmclient.ToolOptions{
+					Mode:  a.cfg.ToolCallMode,
+					Tools: a.executionCoordinator.ToolSpecs(),
+					Calls: toolCalls,
+				})
+			}
+			llmCtx, cancelLLM := context.WithCancel(llmCtx)
+			responseChan, err := getLLMResponse(llmCtx, a.llm, llmHost, messagesForLLM, &currentTemp)
+			if err != nil {
+				cancelLLM()
+				a.logger.Error("Failed to get LLM response, aborting turn",
+					zap.Error(err),
+					zap.Int("turn", turn),
+					zap.String("session_id", sessionID))
+				_ = stream.Status("LLM communication error")
+				stream.Fail("LLM communication error")
+				break
+			}
+			loop.RecordLLMCall()
+
+			// Collect streamed response
+			llmResponse = a.responseHandler.CollectStreamedResponse(ctx, responseChan, stream, sessionID, &ResponseLimit{
+				Budget:      responseTokens,
+				Cancel:      cancelLLM,
+				Host:        llmHost,
+				Messages:    withSystemPrompt(messagesForLLM),
+				Temperature: &currentTemp,
+			})
+			cancelLLM()
+			if toolCalls != nil {
+				toolCall, llmResponse = a.takeToolCall(sessionID, toolCalls.Calls(), llmResponse, stream)
+			}
+			a.recordTurn(ctx, types.RunTurn{
+				SessionID:   sessionID,
+				RunID:       runID,
+				Turn:        turn,
+				Query:       queryText,
+				Retrieval:   state,
+				Messages:    messagesForLLM,
+				Host:        llmHost,
+				Temperature: currentTemp,
+				Response:    llmResponse,
+			})
+		}
 
 		// Handle empty response (usually context window error)
 		if a.responseHandler.IsEmpty(llmResponse) {
 			state = a.handleEmptyResponse(ctx, state, input, stream)
 			if state == "" {
+				stream.Fail("empty LLM response")
 				break // Recovery failed
 			}
 			continue
 		}
 
+		// Clarification question: pause the run; the user's answer arrives as the next message
+		if q, ok := format.ParseAskUser(llmResponse); ok {
+			assistantMsg := types.AgentMessage{
+				Role:        "assistant",
+				Content:     llmResponse,
+				ContentHash: rag.ComputeMessageContentHash("assistant", llmResponse),
+			}
+			history = append(history, assistantMsg)
+			if a.rag != nil {
+				a.rag.AddMessagesAsync(sessionID, []types.AgentMessage{assistantMsg})
+			}
+			a.logger.Info("Agent asked a clarification question; waiting for the user",
+				zap.String("session_id", sessionID),
+				zap.String("question", q.Question),
+				zap.Int("options", len(q.Options)))
+			return
+		}
+
 		// === ACTION CACHE: Check if code about to be executed is already done ===
 		var execResult *ExecutionResult
 		var actionSig *ActionSignature
@@ -328,7 +388,9 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 				if cached, exists := a.actionCache.Get(*actionSig); exists && cached.Success {
 					// Hysteresis: require exact-phrase match before skipping
 					currentHash := a.normalizeCodeHash(code)
-					if cached.CodeNormHash != "" && cached.CodeNormHash == currentHash && !a.userRequestsRerun(input) {
+					matchesCached := cached.CodeNormHash != "" && cached.CodeNormHash == currentHash
+					matchesSuperseded := cached.SupersededCodeHash != "" && cached.SupersededCodeHash == currentHash
+					if (matchesCached || matchesSuperseded) && !a.userRequestsRerun(input) {
 						a.logger.Info("Action already completed; skipping repeat and prompting for next step",
 							zap.String("action", actionSig.String()),
 							zap.Int("cached_turn", cached.Turn),
@@ -337,6 +399,10 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 						// Inject a one-turn evidence note to steer the LLM away from repeats
 						note := fmt.Sprintf("Action %s already completed successfully in turn %d. Do not repeat; choose a different next step (e.g., effect size, post-hoc, multivariable model, or finalize).",
 							actionSig.String(), cached.Turn)
+						if cached.UserEdited {
+							note = fmt.Sprintf("Action %s was already run by the user with edited code (see their message). Do not repeat the original version; build on the user's result.",
+								actionSig.String())
+						}
 						if ephemeralEvidence == "" {
 							ephemeralEvidence = "<evidence>\n" + note + "\n</evidence>"
 						} else {
@@ -359,16 +425,28 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 			}
 		}
 
+		// Snapshot columns the code transforms for the lineage's before/after comparison
+		diffTargets := a.snapshotDistributions(ctx, sessionID, proposedCode)
+
 		// Process response for code execution - critical operation
-		execResult, err = a.executionCoordinator.ProcessResponse(ctx, llmResponse, sessionID, stream)
+		if toolCall != nil {
+			execResult, err = a.executionCoordinator.ProcessToolCall(ctx, *toolCall, sessionID, stream)
+		} else {
+			execResult, err = a.executionCoordinator.ProcessResponse(ctx, llmResponse, sessionID, stream)
+		}
 		if err != nil {
 			a.logger.Error("Failed to process LLM response, aborting turn",
 				zap.Error(err),
 				zap.Int("turn", turn),
 				zap.String("session_id", sessionID))
 			_ = stream.Status("Response processing error")
+			stream.Fail("response processing error")
 			break
 		}
+		if execResult.WasCodeExecuted {
+			loop.RecordExecution()
+			countExecution(&usage, actionSig, execResult.HasError)
+		}
 
 		// Record action in cache if code was executed
 		if execResult.WasCodeExecuted && actionSig != nil {
@@ -379,8 +457,12 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 				Turn:         turn,
 				Attempt:      1, // TODO: Track retry attempts
 				CodeNormHash: a.normalizeCodeHash(proposedCode),
+				Diagnostics:  extractModelDiagnostics(actionSig.Test, execResult.Result),
 			}
 			a.actionCache.Add(*actionSig, result)
+			if a.planner != nil && result.Success {
+				a.planner.RecordAction(ctx, sessionID, *actionSig)
+			}
 
 			a.logger.Debug("Recorded action in cache",
 				zap.String("action", actionSig.String()),
@@ -388,6 +470,12 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 				zap.Int("turn", turn))
 		}
 
+		// Log data transformations for the lineage panel and cohort definition
+		if execResult.WasCodeExecuted && !execResult.HasError {
+			a.recordTransformations(sessionID, execResult.Code, execResult.Result, false)
+			a.recordDistributionDiffs(ctx, sessionID, diffTargets)
+		}
+
 		// Update history based on execution result
 		if execResult.WasCodeExecuted {
 			assistantMsg := types.AgentMessage{
@@ -395,10 +483,11 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 				Content:     llmResponse,
 				ContentHash: rag.ComputeMessageContentHash("assistant", llmResponse),
 			}
+			toolContent := execResult.ToolContent()
 			toolMsg := types.AgentMessage{
 				Role:        "tool",
-				Content:     execResult.Result,
-				ContentHash: rag.ComputeMessageContentHash("tool", execResult.Result),
+				Content:     toolContent,
+				ContentHash: rag.ComputeMessageContentHash("tool", toolContent),
 			}
 
 			// Add assistant response and tool result to history
@@ -430,6 +519,52 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 			if snippet := a.buildEvidenceSnippet(ctx, execResult.Result); snippet != "" {
 				ephemeralEvidence = "<evidence>\n" + snippet + "\n</evidence>"
 			}
+
+			// Blocked change to an uploaded file: tell the model how to continue without it
+			if v := execResult.ProtectedFileViolation; v != nil {
+				_ = stream.Status("Blocked " + v.Operation + " of uploaded file " + v.File)
+				if ephemeralEvidence == "" {
+					ephemeralEvidence = "<evidence>\n" + v.RecoveryNote() + "\n</evidence>"
+				} else {
+					ephemeralEvidence = strings.TrimSuffix(ephemeralEvidence, "</evidence>") + v.RecoveryNote() + "\n</evidence>"
+				}
+			}
+
+			// Warnings that can invalidate the result (non-convergence, numeric trouble) are potential
+			// blockers: the model must resolve them or report the limitation
+			if note := warningBlockersNote(execResult.Warnings); note != "" {
+				_ = stream.Status("Execution raised warnings: " + strings.Join(format.WarningCategories(execResult.Warnings), ", "))
+				if ephemeralEvidence == "" {
+					ephemeralEvidence = "<evidence>\n" + note + "\n</evidence>"
+				} else {
+					ephemeralEvidence = strings.TrimSuffix(ephemeralEvidence, "</evidence>") + note + "\n</evidence>"
+				}
+			}
+
+			// Time-series workflow rules: suggest the next step (difference, ACF/PACF, residual checks)
+			if actionSig != nil && !execResult.HasError {
+				if rec := recommendTimeSeriesStep(actionSig.Test, execResult.Result); rec != "" {
+					if ephemeralEvidence == "" {
+						ephemeralEvidence = "<evidence>\n" + rec + "\n</evidence>"
+					} else {
+						ephemeralEvidence = strings.TrimSuffix(ephemeralEvidence, "</evidence>") + rec + "\n</evidence>"
+					}
+				}
+			}
+
+			// Effect size enforcement: a hypothesis test reported without an effect size gets a follow-up note
+			if !execResult.HasError && a.sessionEffectSizeCheck(sessionID) != types.EffectSizeCheckOff {
+				if note := missingEffectSizeNote(execResult.Code, execResult.Result); note != "" {
+					pendingEffectSize = note
+					if ephemeralEvidence == "" {
+						ephemeralEvidence = "<evidence>\n" + note + "\n</evidence>"
+					} else {
+						ephemeralEvidence = strings.TrimSuffix(ephemeralEvidence, "</evidence>") + note + "\n</evidence>"
+					}
+				} else if effectSizeReportedRegex.MatchString(execResult.Result) {
+					pendingEffectSize = ""
+				}
+			}
 		} else {
 			// No code to execute - conversation complete
 			assistantMsg := types.AgentMessage{
@@ -446,6 +581,16 @@ func (a *Agent) RunDatasetMode(ctx context.Context, input string, sessionID stri
 				a.rag.AddMessagesAsync(sessionID, []types.AgentMessage{assistantMsg})
 			}
 
+			// Auto mode: one follow-up turn when the model stops with an effect size still missing
+			if pendingEffectSize != "" && !effectSizeAutoTurnUsed && a.sessionEffectSizeCheck(sessionID) == types.EffectSizeCheckAuto {
+				effectSizeAutoTurnUsed = true
+				ephemeralEvidence = "<evidence>\n" + pendingEffectSize + "\n</evidence>"
+				pendingEffectSize = ""
+				_ = stream.Status("Computing missing effect size")
+				a.logger.Info("Running follow-up turn for missing effect size", zap.String("session_id", sessionID))
+				continue
+			}
+
 			return
 		}
 	}
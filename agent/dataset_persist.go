@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// PersistDatasetCode is the code that writes the dataframe in variable frame to filename
+// in the workspace. It reads as an ordinary step in the methods pack.
+func PersistDatasetCode(frame, filename string) string {
+	return fmt.Sprintf("%s.to_csv('%s', index=False)\nprint(f\"Saved cleaned dataset to %s: {%s.shape}\")",
+		frame, filename, filename, frame)
+}
+
+// PersistDataset saves the session's in-memory dataframe frame to filename so its
+// transformations survive a restart, and records the save in the lineage. It returns the
+// executed code and its output.
+func (a *Agent) PersistDataset(ctx context.Context, sessionID, frame, filename string) (string, string, error) {
+	code := PersistDatasetCode(frame, filename)
+	output, err := a.pythonTool.ExecuteCell(ctx, code, sessionID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to save dataset: %w", err)
+	}
+	if a.executionCoordinator.DetectError(output) {
+		return "", "", fmt.Errorf("failed to save dataset: %s", truncateString(strings.TrimSpace(output), 200))
+	}
+	a.recordTransformations(sessionID, code, output, false)
+
+	a.logger.Info("Persisted cleaned dataset",
+		zap.String("session_id", sessionID),
+		zap.String("frame", frame),
+		zap.String("filename", filename))
+	return code, output, nil
+}
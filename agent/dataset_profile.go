@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// maxProfileColumnsShown caps how many columns FormatDatasetProfile lists.
+const maxProfileColumnsShown = 40
+
+// maxProfileLevelsShown caps how many levels of a categorical column FormatDatasetProfile lists.
+const maxProfileLevelsShown = 5
+
+// ProfileDataset profiles a dataset in the session workspace, records its columns like
+// DatasetColumns does and stores the profile as a state card in session memory.
+func (a *Agent) ProfileDataset(ctx context.Context, sessionID, dataset string) (*types.DatasetProfile, error) {
+	profile, err := a.pythonTool.ProfileDataset(ctx, sessionID, dataset)
+	if err != nil {
+		return nil, err
+	}
+	profile.Dataset = dataset
+
+	columns := make([]types.ColumnSchema, len(profile.Columns))
+	for i, col := range profile.Columns {
+		columns[i] = col.ColumnSchema
+	}
+	a.RegisterColumnLevels(sessionID, dataset, columns)
+	a.RegisterDataset(sessionID, dataset, columns)
+
+	if a.rag != nil {
+		if err := a.rag.StoreDatasetProfile(ctx, sessionID, profile, FormatDatasetProfile(profile)); err != nil {
+			a.logger.Warn("Failed to store dataset profile state",
+				zap.Error(err),
+				zap.String("session_id", sessionID),
+				zap.String("dataset", dataset))
+		}
+	}
+	return profile, nil
+}
+
+// FormatDatasetProfile renders a profile as plain text: a size line, then one line per
+// column with its type, missing values, distinct count and distribution.
+func FormatDatasetProfile(profile *types.DatasetProfile) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d rows × %d columns", profile.Dataset, profile.Rows, len(profile.Columns))
+	if profile.DuplicateRows > 0 {
+		fmt.Fprintf(&b, ", %d duplicate rows", profile.DuplicateRows)
+	}
+	b.WriteString("\n")
+
+	cols := profile.Columns
+	if len(cols) > maxProfileColumnsShown {
+		cols = cols[:maxProfileColumnsShown]
+	}
+	for _, col := range cols {
+		fmt.Fprintf(&b, "- %s: %s (%s), ", col.Name, col.Inferred, col.Dtype)
+		if col.Missing > 0 {
+			fmt.Fprintf(&b, "%d missing (%.1f%%), ", col.Missing, col.MissingPct)
+		} else {
+			b.WriteString("no missing, ")
+		}
+		fmt.Fprintf(&b, "%d distinct", col.Unique)
+		if s := col.Summary; s != nil {
+			fmt.Fprintf(&b, "; mean %s", formatProfileNumber(s.Mean))
+			if s.Std != nil {
+				fmt.Fprintf(&b, ", sd %s", formatProfileNumber(*s.Std))
+			}
+			fmt.Fprintf(&b, ", min %s, Q1 %s, median %s, Q3 %s, max %s",
+				formatProfileNumber(s.Min), formatProfileNumber(s.Q1), formatProfileNumber(s.Median),
+				formatProfileNumber(s.Q3), formatProfileNumber(s.Max))
+			if s.Skew != nil {
+				fmt.Fprintf(&b, ", skew %.2f", *s.Skew)
+			}
+		}
+		if len(col.Levels) > 0 {
+			levels := col.Levels
+			if len(levels) > maxProfileLevelsShown {
+				levels = levels[:maxProfileLevelsShown]
+			}
+			parts := make([]string, len(levels))
+			for i, l := range levels {
+				parts[i] = fmt.Sprintf("%s %d", l.Value, l.Count)
+			}
+			fmt.Fprintf(&b, "; top: %s", strings.Join(parts, ", "))
+		}
+		if col.Hint != "" {
+			fmt.Fprintf(&b, " [%s]", col.Hint)
+		}
+		b.WriteString("\n")
+	}
+	if more := len(profile.Columns) - len(cols); more > 0 {
+		fmt.Fprintf(&b, "… %d more columns\n", more)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// formatProfileNumber prints a summary statistic with four significant digits.
+func formatProfileNumber(x float64) string {
+	return fmt.Sprintf("%.4g", x)
+}
@@ -0,0 +1,234 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"stats-agent/tools"
+	"stats-agent/web/types"
+)
+
+// maxRegistryColumnsShown caps how many columns of each dataset the <datasets> block lists.
+const maxRegistryColumnsShown = 25
+
+// datasetNamePattern matches the runs of characters replaced by "_" in dataset names.
+var datasetNamePattern = regexp.MustCompile(`[^0-9a-zA-Z]+`)
+
+// DatasetRegistry tracks the tabular files of each session with their profiled schemas,
+// so the agent can tell them apart and join them by name.
+type DatasetRegistry struct {
+	mu       sync.RWMutex
+	sessions map[string]map[string][]types.ColumnSchema // session → filename → columns
+}
+
+// NewDatasetRegistry creates an empty registry.
+func NewDatasetRegistry() *DatasetRegistry {
+	return &DatasetRegistry{sessions: make(map[string]map[string][]types.ColumnSchema)}
+}
+
+// Register records a session's dataset file and its columns, replacing earlier ones.
+// Columns may be nil for a file that could not be profiled.
+func (r *DatasetRegistry) Register(sessionID, filename string, columns []types.ColumnSchema) {
+	if sessionID == "" || filename == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions[sessionID] == nil {
+		r.sessions[sessionID] = make(map[string][]types.ColumnSchema)
+	}
+	r.sessions[sessionID][filename] = columns
+}
+
+// Datasets returns the session's datasets sorted by name.
+func (r *DatasetRegistry) Datasets(sessionID string) []types.SessionDataset {
+	r.mu.RLock()
+	files := r.sessions[sessionID]
+	filenames := make([]string, 0, len(files))
+	for f := range files {
+		filenames = append(filenames, f)
+	}
+	names := DatasetNames(filenames)
+	datasets := make([]types.SessionDataset, 0, len(files))
+	for name, filename := range names {
+		datasets = append(datasets, types.SessionDataset{Name: name, Filename: filename, Columns: files[filename]})
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(datasets, func(i, j int) bool { return datasets[i].Name < datasets[j].Name })
+	return datasets
+}
+
+// Rename moves a dataset's columns to the file's new name. Unregistered files are ignored.
+func (r *DatasetRegistry) Rename(sessionID, filename, newFilename string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files := r.sessions[sessionID]
+	columns, ok := files[filename]
+	if !ok {
+		return
+	}
+	delete(files, filename)
+	files[newFilename] = columns
+}
+
+// Remove drops one of the session's datasets.
+func (r *DatasetRegistry) Remove(sessionID, filename string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions[sessionID], filename)
+}
+
+// Clear drops the session's datasets.
+func (r *DatasetRegistry) Clear(sessionID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, sessionID)
+}
+
+// DatasetNames maps dataset names to filenames. A name is the file's stem (".csv.gz" is
+// one suffix) lowercased with runs of other characters than letters and digits replaced
+// by "_" ("Sales 2024.csv" → sales_2024), prefixed with "d_" when it starts with a digit. Files whose stems collide
+// are named after the whole filename instead (sales_csv, sales_xlsx). The executor's
+// dataset_files() applies the same rule.
+func DatasetNames(filenames []string) map[string]string {
+	byStem := make(map[string][]string)
+	for _, f := range filenames {
+		stem := datasetName(tools.DatasetStem(f))
+		byStem[stem] = append(byStem[stem], f)
+	}
+	names := make(map[string]string, len(filenames))
+	for stem, files := range byStem {
+		if len(files) == 1 {
+			names[stem] = files[0]
+			continue
+		}
+		for _, f := range files {
+			names[datasetName(f)] = f
+		}
+	}
+	return names
+}
+
+func datasetName(s string) string {
+	name := strings.ToLower(strings.Trim(datasetNamePattern.ReplaceAllString(s, "_"), "_"))
+	if name == "" {
+		return "dataset"
+	}
+	if name[0] >= '0' && name[0] <= '9' {
+		return "d_" + name
+	}
+	return name
+}
+
+// DatasetJoinKeys returns the columns shared by each pair of datasets (compared without
+// case) whose inferred types are compatible, as candidate join keys. Columns with more
+// distinct values come first within a pair, since identifiers make better keys.
+func DatasetJoinKeys(datasets []types.SessionDataset) []types.DatasetJoinKey {
+	var keys []types.DatasetJoinKey
+	for i := range datasets {
+		for j := i + 1; j < len(datasets); j++ {
+			right := make(map[string]types.ColumnSchema, len(datasets[j].Columns))
+			for _, col := range datasets[j].Columns {
+				right[strings.ToLower(col.Name)] = col
+			}
+			var shared []types.ColumnSchema
+			for _, col := range datasets[i].Columns {
+				other, ok := right[strings.ToLower(col.Name)]
+				if ok && joinCompatible(col.Inferred, other.Inferred) {
+					shared = append(shared, col)
+				}
+			}
+			sort.SliceStable(shared, func(a, b int) bool { return shared[a].Unique > shared[b].Unique })
+			for _, col := range shared {
+				keys = append(keys, types.DatasetJoinKey{Left: datasets[i].Name, Right: datasets[j].Name, Column: col.Name})
+			}
+		}
+	}
+	return keys
+}
+
+// joinCompatible reports whether columns of the two inferred types can hold the same keys.
+// Codes are often numeric in one file and text in another, so only datetimes and
+// booleans must match exactly.
+func joinCompatible(a, b string) bool {
+	switch {
+	case a == b:
+		return true
+	case a == "datetime" || b == "datetime" || a == "boolean" || b == "boolean":
+		return false
+	}
+	return true
+}
+
+// RegisterDataset records one of the session's dataset files in the registry.
+func (a *Agent) RegisterDataset(sessionID, filename string, columns []types.ColumnSchema) {
+	a.datasets.Register(sessionID, filename, columns)
+}
+
+// RenameDataset follows a dataset file renamed in the session's workspace.
+func (a *Agent) RenameDataset(sessionID, filename, newFilename string) {
+	a.datasets.Rename(sessionID, filename, newFilename)
+}
+
+// RemoveDataset forgets a dataset file deleted from the session's workspace.
+func (a *Agent) RemoveDataset(sessionID, filename string) {
+	a.datasets.Remove(sessionID, filename)
+}
+
+// SessionDatasets returns the session's registered datasets sorted by name.
+func (a *Agent) SessionDatasets(sessionID string) []types.SessionDataset {
+	return a.datasets.Datasets(sessionID)
+}
+
+// datasetsBlock returns the session's <datasets> block for the system prompt, or "" when
+// the session has fewer than two datasets (a single file needs no introduction).
+func (a *Agent) datasetsBlock(sessionID string) string {
+	datasets := a.datasets.Datasets(sessionID)
+	if len(datasets) < 2 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("<datasets>\n")
+	fmt.Fprintf(&b, "This session has %d datasets. Load one with load_dataset(\"name\"). Join two with join_datasets(\"left\", \"right\", on=\"column\", how=\"inner\"), which reports how many rows matched; check the match rate before analyzing the result. Say which dataset each result comes from.\n", len(datasets))
+	for _, d := range datasets {
+		fmt.Fprintf(&b, "- %s (%s)", d.Name, d.Filename)
+		if len(d.Columns) == 0 {
+			b.WriteString(": columns not profiled\n")
+			continue
+		}
+		cols := d.Columns
+		more := ""
+		if len(cols) > maxRegistryColumnsShown {
+			more = fmt.Sprintf(", … %d more", len(cols)-maxRegistryColumnsShown)
+			cols = cols[:maxRegistryColumnsShown]
+		}
+		parts := make([]string, len(cols))
+		for i, col := range cols {
+			parts[i] = col.Name + ":" + col.Inferred
+		}
+		fmt.Fprintf(&b, ": %d columns: %s%s\n", len(d.Columns), strings.Join(parts, ", "), more)
+	}
+	if keys := DatasetJoinKeys(datasets); len(keys) > 0 {
+		b.WriteString("Shared columns (candidate join keys):\n")
+		for _, k := range keys {
+			fmt.Fprintf(&b, "- %s & %s: %s\n", k.Left, k.Right, k.Column)
+		}
+	}
+	b.WriteString("</datasets>")
+	return b.String()
+}
+
+// applyDatasets prepends the session's datasets block as a system message. Like
+// applyEnvironment, call it after context budgeting.
+func (a *Agent) applyDatasets(sessionID string, messages []types.AgentMessage) []types.AgentMessage {
+	block := a.datasetsBlock(sessionID)
+	if block == "" {
+		return messages
+	}
+	return append([]types.AgentMessage{{Role: "system", Content: block}}, messages...)
+}
@@ -2,18 +2,36 @@ package agent
 
 import (
 	"context"
+	"regexp"
 	"strings"
 
+	"stats-agent/config"
 	"stats-agent/prompts"
 	"stats-agent/rag"
+	"stats-agent/tracing"
 	"stats-agent/web/types"
 
 	"go.uber.org/zap"
 )
 
-// RunDocumentMode executes a simple document Q&A workflow without code execution.
-// It queries RAG for document context, combines it with conversation history, and streams a single LLM response.
+// needsContextPattern matches a response asking for another retrieval round instead of answering.
+var needsContextPattern = regexp.MustCompile(`^<needs_context>\s*([\s\S]*?)\s*(?:</needs_context>|$)`)
+
+const needsContextTag = "<needs_context>"
+
+// RunDocumentMode executes a document Q&A workflow without code execution.
+// It queries RAG for document context, combines it with conversation history, and streams the LLM response.
+// With DOCUMENT_MAX_RETRIEVALS above 1, the model may ask for another search instead of answering
+// when the memory block lacks the answer; the last round always answers.
 func (a *Agent) RunDocumentMode(ctx context.Context, input string, sessionID string, history []types.AgentMessage, stream *Stream) {
+	ctx, span := tracing.Start(ctx, "agent.run", tracing.Session(sessionID), tracing.AttrMode.String(types.ModeDocument))
+	defer span.End()
+
+	// Memory entries are numbered [mN] per run for cited footnotes
+	if a.rag != nil {
+		a.rag.ResetMemoryCitations(sessionID)
+	}
+
 	// 1. Create user message but DON'T add to history or RAG yet
 	userMsg := types.AgentMessage{
 		Role:        "user",
@@ -21,8 +39,8 @@ func (a *Agent) RunDocumentMode(ctx context.Context, input string, sessionID str
 		ContentHash: rag.ComputeMessageContentHash("user", input),
 	}
 
-	// 2. Query RAG for state (use configured DocumentModeRAGResults)
-	ragResults := a.cfg.DocumentModeRAGResults
+	// 2. Query RAG for state (use the document mode retrieval budget)
+	budget := a.cfg.RetrievalBudget(types.ModeDocument)
 
 	// Extract content hashes from current history to exclude from RAG results
 	excludeHashes := make([]string, 0, len(history))
@@ -32,143 +50,82 @@ func (a *Agent) RunDocumentMode(ctx context.Context, input string, sessionID str
 		}
 	}
 
-	ragCtx, ragCancel := context.WithTimeout(ctx, a.cfg.LLMRequestTimeout)
-	defer ragCancel()
-	// Document mode doesn't use post-query pruning (simpler flow)
-	// Document mode doesn't use action cache (no code execution in this mode)
-	state, err := a.rag.Query(ragCtx, sessionID, input, ragResults, excludeHashes, nil, "", "document")
-	if err != nil {
-		a.logger.Warn("Failed to query RAG for state, continuing without it",
-			zap.Error(err),
-			zap.String("session_id", sessionID))
-		state = ""
-	}
+	state := a.queryDocumentMemory(ctx, sessionID, input, budget, excludeHashes)
 
     // 3. Build messages for LLM (use document QA prompt) with optional evidence
     // Append user message to history for this request (but don't modify passed-in history yet)
     historyWithUserMsg := append(history, userMsg)
 
-    // Build an ephemeral evidence snippet from state when the question suggests quoting/thresholds
-    docEvidence := a.buildDocEvidenceSnippet(ctx, input, state)
-    messagesForLLM := a.responseHandler.BuildMessagesForLLMWithEvidence(state, docEvidence, historyWithUserMsg)
+	var llmResponse string
+	for round := 1; ; round++ {
+		tracing.MarkTurn(ctx, round)
+		// Build an ephemeral evidence snippet from state when the question suggests quoting/thresholds
+		docEvidence := a.buildDocEvidenceSnippet(ctx, input, state)
+		// Recency-first budgeting for document mode
+		fit := a.contextBudgeter.Fit(ctx, ContextRequest{
+			SessionID:    sessionID,
+			Query:        input,
+			SystemPrompt: prompts.DocumentQA() + a.responseHandler.VerbosityInstruction(sessionID),
+			State:        state,
+			Evidence:     docEvidence,
+			History:      historyWithUserMsg,
+		})
+		if !a.preflightContext(ctx, sessionID, input, fit, stream) {
+			stream.Fail("context window exceeded")
+			return
+		}
+		// Verbosity goes in after budgeting, for retrieval rounds and the final answer alike
+		messagesForLLM := a.responseHandler.ApplyVerbosity(sessionID, fit.Messages)
+		canRetrieve := round < a.cfg.DocumentMaxRetrievals
+		if canRetrieve {
+			messagesForLLM = append([]types.AgentMessage{{Role: "system", Content: prompts.DocumentMoreContext()}}, messagesForLLM...)
+		}
 
-    // Recency-first budgeting for document mode
-    {
-        totalTokens, err := a.memoryManager.CalculateHistorySize(ctx, messagesForLLM)
-        if err != nil {
-            a.logger.Warn("Doc: token count failed; proceeding optimistically", zap.Error(err))
-        } else {
-            // Fixed overhead: system prompt
-            systemTokens, sysErr := a.memoryManager.CountTokens(ctx, prompts.DocumentQA())
-            if sysErr != nil {
-                a.logger.Warn("Doc: system prompt tokens failed", zap.Error(sysErr))
-                systemTokens = 0
-            }
-            // Overhead parts: state + evidence
-            stateTokens := 0
-            if strings.TrimSpace(state) != "" {
-                if tok, e := a.memoryManager.CountTokens(ctx, state); e == nil {
-                    stateTokens = tok
-                }
-            }
-            evidenceTokens := 0
-            if strings.TrimSpace(docEvidence) != "" {
-                if tok, e := a.memoryManager.CountTokens(ctx, docEvidence); e == nil {
-                    evidenceTokens = tok
-                }
-            }
-
-            maxPrompt := a.cfg.ContextLength - a.cfg.ResponseTokenBudget
-            if maxPrompt < 0 {
-                maxPrompt = 0
-            }
-            recencyMin := int(float64(maxPrompt) * a.cfg.ContextSoftLimitRatio)
-            if recencyMin < 0 {
-                recencyMin = 0
-            }
-            overheadCap := maxPrompt - recencyMin
-            if overheadCap < 0 {
-                overheadCap = 0
-            }
-            overheadTokens := systemTokens + stateTokens + evidenceTokens
-
-            // If overhead exceeds cap, first compress state, then drop evidence
-            if overheadTokens > overheadCap && strings.TrimSpace(state) != "" {
-                sumCtx, sumCancel := context.WithTimeout(ctx, a.cfg.LLMRequestTimeout)
-                summarized, sErr := a.rag.SummarizeState(sumCtx, state, input)
-                sumCancel()
-                if sErr == nil && strings.TrimSpace(summarized) != "" {
-                    state = summarized
-                    messagesForLLM = a.responseHandler.BuildMessagesForLLMWithEvidence(state, docEvidence, historyWithUserMsg)
-                    stateTokens = 0
-                    if tok, e := a.memoryManager.CountTokens(ctx, state); e == nil {
-                        stateTokens = tok
-                    }
-                    overheadTokens = systemTokens + stateTokens + evidenceTokens
-                }
-            }
-            if overheadTokens > overheadCap && evidenceTokens > 0 {
-                // Drop ephemeral evidence for this turn
-                docEvidence = ""
-                messagesForLLM = a.responseHandler.BuildMessagesForLLMWithEvidence(state, docEvidence, historyWithUserMsg)
-                evidenceTokens = 0
-                overheadTokens = systemTokens + stateTokens
-            }
-
-            // Allowed for messages (excluding system prompt which is prepended later by getLLMResponseForDocumentMode)
-            allowed := maxPrompt - systemTokens
-            if allowed < 0 {
-                allowed = 0
-            }
-            if totalTokens > allowed {
-                // Trim oldest history messages until within budget
-                tokensToRemove := totalTokens - allowed
-                tokensAccum := 0
-                cut := 0
-                // Only trim from the history portion (not the first system slot in messagesForLLM)
-                // messagesForLLM = [optional system(state/evidence)] + historyWithUserMsg
-                // We will operate on historyWithUserMsg and rebuild
-                for cut < len(historyWithUserMsg) && tokensAccum < tokensToRemove {
-                    msg := historyWithUserMsg[cut]
-                    if !msg.TokenCountComputed {
-                        if tok, e := a.memoryManager.CountTokens(ctx, msg.Content); e == nil {
-                            historyWithUserMsg[cut].TokenCount = tok
-                            historyWithUserMsg[cut].TokenCountComputed = true
-                        } else {
-                            a.logger.Warn("Doc: failed to count tokens for trim", zap.Error(e))
-                            break
-                        }
-                    }
-                    tokensAccum += historyWithUserMsg[cut].TokenCount
-                    cut++
-                }
-                if cut > 0 && cut < len(historyWithUserMsg) {
-                    historyWithUserMsg = historyWithUserMsg[cut:]
-                    messagesForLLM = a.responseHandler.BuildMessagesForLLMWithEvidence(state, docEvidence, historyWithUserMsg)
-                    a.logger.Info("Doc: trimmed history to fit window",
-                        zap.Int("messages_removed", cut),
-                        zap.Int("remaining_messages", len(historyWithUserMsg)))
-                }
-            }
-        }
-    }
+		// 4. Get LLM response with document QA prompt
+		llmCtx, cancelLLM := context.WithCancel(ctx)
+		responseChan, err := getLLMResponseForDocumentMode(llmCtx, a.llm, a.sessionLLMHost(sessionID), messagesForLLM)
+		if err != nil {
+			cancelLLM()
+			a.logger.Error("Failed to get LLM response in document mode",
+				zap.Error(err),
+				zap.String("session_id", sessionID))
+			_ = stream.Status("LLM communication error")
+			stream.Fail("LLM communication error")
+			return
+		}
 
-	// 4. Get single LLM response with document QA prompt
-	responseChan, err := getLLMResponseForDocumentMode(ctx, a.cfg.MainLLMHost, messagesForLLM, a.cfg, a.logger)
-	if err != nil {
-		a.logger.Error("Failed to get LLM response in document mode",
-			zap.Error(err),
-			zap.String("session_id", sessionID))
-		_ = stream.Status("LLM communication error")
-		return
-	}
+		// 5. Collect and stream response
+		if !canRetrieve {
+			temperature := documentModeTemperature
+			llmResponse = a.responseHandler.CollectStreamedResponse(ctx, responseChan, stream, sessionID, &ResponseLimit{
+				Budget:      a.responseHandler.ResponseTokenBudget(sessionID),
+				Cancel:      cancelLLM,
+				Host:        a.sessionLLMHost(sessionID),
+				Messages:    append([]types.AgentMessage{{Role: "system", Content: buildDocumentPrompt()}}, messagesForLLM...),
+				Temperature: &temperature,
+			})
+			cancelLLM()
+			break
+		}
+		var followUp string
+		llmResponse, followUp = a.collectDocumentRound(responseChan, stream, input)
+		cancelLLM()
+		if followUp == "" {
+			break
+		}
 
-	// 5. Collect and stream response
-	llmResponse := a.responseHandler.CollectStreamedResponse(responseChan, stream)
+		a.logger.Debug("Document mode requested another retrieval",
+			zap.String("session_id", sessionID),
+			zap.Int("round", round),
+			zap.String("query", followUp))
+		_ = stream.Status("Searching the document for: " + followUp)
+		state = mergeMemoryBlocks(state, a.queryDocumentMemory(ctx, sessionID, followUp, budget, excludeHashes))
+	}
 
 	if a.responseHandler.IsEmpty(llmResponse) {
 		a.logger.Warn("Empty response in document mode", zap.String("session_id", sessionID))
 		_ = stream.Status("Received empty response from LLM")
+		stream.Fail("empty LLM response")
 		return
 	}
 
@@ -182,7 +139,90 @@ func (a *Agent) RunDocumentMode(ctx context.Context, input string, sessionID str
 		a.rag.AddMessagesAsync(sessionID, []types.AgentMessage{assistantMsg})
 	}
 
-	// Done - single response, no iteration
+}
+
+// queryDocumentMemory retrieves the memory block for a document-mode query. Retrieval
+// failures are logged and yield an empty block.
+func (a *Agent) queryDocumentMemory(ctx context.Context, sessionID, query string, budget config.RetrievalBudget, excludeHashes []string) string {
+	ragCtx, ragCancel := context.WithTimeout(ctx, a.cfg.LLMRequestTimeout)
+	defer ragCancel()
+	// Document mode doesn't use post-query pruning (simpler flow)
+	// Document mode doesn't use action cache (no code execution in this mode)
+	state, err := a.rag.Query(ragCtx, sessionID, query, budget, excludeHashes, nil, "", types.ModeDocument)
+	if err != nil {
+		a.logger.Warn("Failed to query RAG for state, continuing without it",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+		return ""
+	}
+	return state
+}
+
+// collectDocumentRound collects a response that may be a <needs_context> request instead
+// of an answer. Output is held back until the response can no longer start with the tag,
+// so requests never reach the client. Returns the response and the requested search query
+// ("" when the model answered); an empty request falls back to the original question.
+func (a *Agent) collectDocumentRound(responseChan <-chan string, stream *Stream, input string) (string, string) {
+	var b strings.Builder
+	streaming := false
+	for chunk := range responseChan {
+		b.WriteString(chunk)
+		if streaming {
+			if stream != nil {
+				_, _ = stream.WriteString(chunk)
+			}
+			continue
+		}
+		head := strings.TrimLeft(b.String(), " \t\r\n")
+		if strings.HasPrefix(needsContextTag, head) || strings.HasPrefix(head, needsContextTag) {
+			continue
+		}
+		streaming = true
+		if stream != nil {
+			_, _ = stream.WriteString(b.String())
+		}
+	}
+
+	response := b.String()
+	if !streaming {
+		if m := needsContextPattern.FindStringSubmatch(strings.TrimSpace(response)); m != nil {
+			if query := strings.TrimSpace(m[1]); query != "" {
+				return response, query
+			}
+			return response, input
+		}
+		// A short answer that never got past the hold-back
+		if stream != nil {
+			_, _ = stream.WriteString(response)
+		}
+	}
+	if stream != nil && !strings.HasSuffix(response, "\n") {
+		_, _ = stream.WriteString("\n")
+	}
+	return response, ""
+}
+
+// mergeMemoryBlocks combines <memory> blocks from successive retrievals into one,
+// dropping lines already present in an earlier block.
+func mergeMemoryBlocks(blocks ...string) string {
+	seen := make(map[string]bool)
+	var lines []string
+	for _, block := range blocks {
+		block = strings.ReplaceAll(block, "<memory>", "")
+		block = strings.ReplaceAll(block, "</memory>", "")
+		for _, line := range strings.Split(block, "\n") {
+			key := strings.TrimSpace(line)
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "<memory>\n" + strings.Join(lines, "\n") + "\n</memory>"
 }
 
 // buildDocEvidenceSnippet constructs a 150–300 token snippet from the retrieved
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"stats-agent/rag"
+	"stats-agent/web/types"
+)
+
+// effectSizeByTest maps hypothesis tests to the effect size that should accompany them.
+// Correlations and regressions report their own effect sizes (r, coefficients, R²) and are not listed.
+var effectSizeByTest = map[string]string{
+	"t-test":                  "Cohen's d (Hedges' g for small samples) with a 95% CI",
+	"anova":                   "eta-squared or omega-squared",
+	"two-way-anova":           "partial eta-squared for each effect",
+	"repeated-measures-anova": "partial eta-squared (or generalized eta-squared)",
+	"ancova":                  "partial eta-squared for the adjusted group effect",
+	"mann-whitney":            "the rank-biserial correlation",
+	"wilcoxon-signed-rank":    "the matched-pairs rank-biserial correlation",
+	"kruskal-wallis":          "epsilon-squared",
+	"friedman":                "Kendall's W",
+	"chi-square":              "Cramér's V (phi for a 2x2 table)",
+	"fisher-exact":            "the odds ratio with a 95% CI",
+	"mcnemar":                 "the odds ratio of discordant pairs",
+}
+
+// effectSizeOrder fixes the check order so the note is deterministic when several tests match.
+var effectSizeOrder = []string{
+	"t-test", "repeated-measures-anova", "two-way-anova", "ancova", "anova",
+	"mann-whitney", "wilcoxon-signed-rank", "kruskal-wallis", "friedman",
+	"chi-square", "fisher-exact", "mcnemar",
+}
+
+// effectSizeReportedRegex matches effect sizes printed in tool output.
+var effectSizeReportedRegex = regexp.MustCompile(`(?i)cohen'?s?\s*d|hedges'?s?\s*g|\beta[\s_^]*(sq|2|²)|η²|\bomega[\s_^]*(sq|2|²)|ω²|epsilon[\s_^]*(sq|2|²)|ε²|rank[\s_-]*biserial|\brbc\b|cram[eé]r'?s?\s*v|\bphi\b|kendall'?s?\s*w|odds[\s_]*ratio|\bnp2\b|\bng2\b`)
+
+// missingEffectSizeNote returns an evidence note when the output reports a hypothesis
+// test result (a p-value) for a recognized test but no effect size. Returns "" otherwise.
+func missingEffectSizeNote(code, output string) string {
+	if effectSizeReportedRegex.MatchString(output) {
+		return ""
+	}
+
+	meta := rag.ExtractStatisticalMetadata(code, output)
+	if meta["p_value"] == "" || meta["test_types"] == "" {
+		return ""
+	}
+
+	detected := make(map[string]bool)
+	for _, t := range strings.Split(meta["test_types"], ",") {
+		detected[t] = true
+	}
+	for _, test := range effectSizeOrder {
+		if detected[test] {
+			return fmt.Sprintf("The %s result (p=%s) was reported without an effect size. Compute and report %s before interpreting the result.",
+				test, meta["p_value"], effectSizeByTest[test])
+		}
+	}
+	return ""
+}
+
+// SetSessionEffectSizeCheck sets how a session handles test results without an effect size
+// (off, note, or auto). Invalid values fall back to note.
+func (a *Agent) SetSessionEffectSizeCheck(sessionID, mode string) {
+	if sessionID == "" {
+		return
+	}
+	a.effectSizeMu.Lock()
+	defer a.effectSizeMu.Unlock()
+	if !types.IsValidEffectSizeCheck(mode) || mode == types.EffectSizeCheckNote {
+		delete(a.effectSizeCheck, sessionID)
+		return
+	}
+	a.effectSizeCheck[sessionID] = mode
+}
+
+// sessionEffectSizeCheck returns the session's effect size check mode (note when unset).
+func (a *Agent) sessionEffectSizeCheck(sessionID string) string {
+	a.effectSizeMu.RLock()
+	defer a.effectSizeMu.RUnlock()
+	if mode, ok := a.effectSizeCheck[sessionID]; ok {
+		return mode
+	}
+	return types.EffectSizeCheckNote
+}
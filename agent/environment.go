@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// environmentProbeRetry is how long a session whose environment probe failed runs without
+// a descriptor before ensureSessionEnvironment probes again.
+const environmentProbeRetry = 2 * time.Minute
+
+// DescribeSessionEnvironment probes the session executor's Python version and packages,
+// caches the descriptor for the prompt and stores it as a state card. Returns "" when the
+// probe fails; the run proceeds without it and the next probe waits environmentProbeRetry.
+func (a *Agent) DescribeSessionEnvironment(ctx context.Context, sessionID string) string {
+	env, err := a.pythonTool.DescribeEnvironment(ctx, sessionID)
+	if err != nil {
+		a.logger.Warn("Failed to describe execution environment",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+		a.environmentMu.Lock()
+		a.environmentRetryAfter[sessionID] = time.Now().Add(environmentProbeRetry)
+		a.environmentMu.Unlock()
+		return ""
+	}
+	descriptor := env.Descriptor()
+
+	a.environmentMu.Lock()
+	a.environments[sessionID] = descriptor
+	delete(a.environmentRetryAfter, sessionID)
+	a.environmentMu.Unlock()
+
+	if a.rag != nil {
+		if err := a.rag.StoreEnvironmentCard(ctx, sessionID, descriptor); err != nil {
+			a.logger.Warn("Failed to store environment state card",
+				zap.Error(err),
+				zap.String("session_id", sessionID))
+		}
+	}
+	return descriptor
+}
+
+// ensureSessionEnvironment describes the environment of sessions initialized before the
+// server started, whose descriptor is not cached. A failed probe is not repeated until
+// environmentProbeRetry has passed, so an unreachable executor does not delay every run.
+func (a *Agent) ensureSessionEnvironment(ctx context.Context, sessionID string) {
+	a.environmentMu.RLock()
+	_, ok := a.environments[sessionID]
+	retryAfter := a.environmentRetryAfter[sessionID]
+	a.environmentMu.RUnlock()
+	if !ok && time.Now().After(retryAfter) {
+		a.DescribeSessionEnvironment(ctx, sessionID)
+	}
+}
+
+// environmentBlock returns the session's <environment> block for the system prompt, or ""
+// when the environment has not been described.
+func (a *Agent) environmentBlock(sessionID string) string {
+	a.environmentMu.RLock()
+	descriptor := a.environments[sessionID]
+	a.environmentMu.RUnlock()
+	if descriptor == "" {
+		return ""
+	}
+	return "<environment>\n" + descriptor + "\nOnly import packages listed as installed; do not suggest installing others.\n</environment>"
+}
+
+// applyEnvironment prepends the session's environment block as a system message. Like
+// ApplyVerbosity, call it after context budgeting.
+func (a *Agent) applyEnvironment(sessionID string, messages []types.AgentMessage) []types.AgentMessage {
+	block := a.environmentBlock(sessionID)
+	if block == "" {
+		return messages
+	}
+	return append([]types.AgentMessage{{Role: "system", Content: block}}, messages...)
+}
+
+// clearSessionEnvironment drops the session's cached environment descriptor and probe
+// failure.
+func (a *Agent) clearSessionEnvironment(sessionID string) {
+	a.environmentMu.Lock()
+	defer a.environmentMu.Unlock()
+	delete(a.environments, sessionID)
+	delete(a.environmentRetryAfter, sessionID)
+}
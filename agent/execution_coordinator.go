@@ -13,21 +13,32 @@ import (
 // ExecutionCoordinator handles Python code detection, execution, and result processing.
 type ExecutionCoordinator struct {
 	pythonTool *tools.StatefulPythonTool
+	sqlTool    *tools.SQLTool // nil unless SQL_TOOL_ENABLED
 	logger     *zap.Logger
 }
 
 // ExecutionResult contains the outcome of processing an LLM response for code execution.
 type ExecutionResult struct {
 	WasCodeExecuted bool   // Whether code was found and executed
-	Code            string // The extracted Python code
+	Code            string // The extracted Python code (or SQL query)
 	Result          string // Execution result (or error message)
 	HasError        bool   // Whether the execution resulted in an error
+	// Python warnings the code raised, separated from Result
+	Warnings []format.ExecutionWarning
+	// Blocked attempt to modify a protected upload, if the error was one
+	ProtectedFileViolation *tools.ProtectedFileViolation
+}
+
+// ToolContent is the tool message for the execution: the result followed by its warnings block.
+func (r *ExecutionResult) ToolContent() string {
+	return format.AppendWarnings(r.Result, r.Warnings)
 }
 
 // NewExecutionCoordinator creates a new execution coordinator instance.
-func NewExecutionCoordinator(pythonTool *tools.StatefulPythonTool, logger *zap.Logger) *ExecutionCoordinator {
+func NewExecutionCoordinator(pythonTool *tools.StatefulPythonTool, sqlTool *tools.SQLTool, logger *zap.Logger) *ExecutionCoordinator {
 	return &ExecutionCoordinator{
 		pythonTool: pythonTool,
+		sqlTool:    sqlTool,
 		logger:     logger,
 	}
 }
@@ -43,12 +54,21 @@ func (e *ExecutionCoordinator) ProcessResponse(ctx context.Context, llmResponse,
 	code, result, wasExecuted := e.pythonTool.ExecutePythonCode(ctx, processedResponse, sessionID, nil)
 
 	if !wasExecuted {
-		return &ExecutionResult{
-			WasCodeExecuted: false,
-		}, nil
+		return e.processSQL(ctx, processedResponse, sessionID, stream), nil
 	}
+	return e.pythonResult(code, result, sessionID, stream), nil
+}
 
+// pythonResult splits warnings out of an executed cell's output, checks it for errors and
+// streams it as the tool result.
+func (e *ExecutionCoordinator) pythonResult(code, result, sessionID string, stream *Stream) *ExecutionResult {
+	result, warnings := format.SplitWarnings(result)
 	hasError := e.DetectError(result)
+	if len(warnings) > 0 {
+		e.logger.Info("Python execution raised warnings",
+			zap.String("session_id", sessionID),
+			zap.Strings("categories", format.WarningCategories(warnings)))
+	}
 
 	if hasError {
 		e.logger.Warn("Python execution resulted in error",
@@ -56,6 +76,60 @@ func (e *ExecutionCoordinator) ProcessResponse(ctx context.Context, llmResponse,
 			zap.String("error_preview", result[:min(200, len(result))]))
 	}
 
+	var violation *tools.ProtectedFileViolation
+	if hasError {
+		if v, ok := tools.ParseProtectedFileViolation(result); ok {
+			violation = v
+			e.logger.Warn("Executor blocked a change to an uploaded file",
+				zap.String("session_id", sessionID),
+				zap.String("operation", v.Operation),
+				zap.String("file", v.File))
+		}
+	}
+
+	execResult := &ExecutionResult{
+		WasCodeExecuted: true,
+		Code:            code,
+		Result:          result,
+		HasError:        hasError,
+		Warnings:        warnings,
+
+		ProtectedFileViolation: violation,
+	}
+
+	if stream != nil {
+		if err := stream.Tool(execResult.ToolContent()); err != nil {
+			e.logger.Warn("Failed to stream tool result",
+				zap.String("session_id", sessionID),
+				zap.Error(err))
+		}
+	}
+
+	return execResult
+}
+
+// processSQL runs a <sql> block when the response has no Python to execute. The query and
+// its printed result take the place of the code and its output.
+func (e *ExecutionCoordinator) processSQL(ctx context.Context, response, sessionID string, stream *Stream) *ExecutionResult {
+	if e.sqlTool == nil {
+		return &ExecutionResult{WasCodeExecuted: false}
+	}
+	query, result, wasExecuted := e.sqlTool.ExecuteSQLBlock(ctx, response, sessionID)
+	if !wasExecuted {
+		return &ExecutionResult{WasCodeExecuted: false}
+	}
+	return e.sqlResult(query, result, sessionID, stream)
+}
+
+// sqlResult checks a query's printed result for errors and streams it as the tool result.
+func (e *ExecutionCoordinator) sqlResult(query, result, sessionID string, stream *Stream) *ExecutionResult {
+	hasError := e.DetectError(result)
+	if hasError {
+		e.logger.Warn("SQL query resulted in error",
+			zap.String("session_id", sessionID),
+			zap.String("error_preview", result[:min(200, len(result))]))
+	}
+
 	if stream != nil {
 		if err := stream.Tool(result); err != nil {
 			e.logger.Warn("Failed to stream tool result",
@@ -66,10 +140,10 @@ func (e *ExecutionCoordinator) ProcessResponse(ctx context.Context, llmResponse,
 
 	return &ExecutionResult{
 		WasCodeExecuted: true,
-		Code:            code,
+		Code:            query,
 		Result:          result,
 		HasError:        hasError,
-	}, nil
+	}
 }
 
 // DetectError checks if the execution result contains error indicators.
@@ -0,0 +1,28 @@
+package agent
+
+import (
+	"strings"
+
+	"stats-agent/web/format"
+)
+
+// maxBlockerWarnings bounds the warnings quoted in the blocker note.
+const maxBlockerWarnings = 5
+
+// warningBlockersNote asks the model to deal with warnings that may invalidate the last
+// result before building on it. Returns "" when none of the warnings is a potential blocker.
+func warningBlockersNote(warnings []format.ExecutionWarning) string {
+	var blockers []string
+	for _, w := range warnings {
+		if w.IsPotentialBlocker() && len(blockers) < maxBlockerWarnings {
+			blockers = append(blockers, "- "+truncateString(w.String(), 200))
+		}
+	}
+	if len(blockers) == 0 {
+		return ""
+	}
+	return "The last execution raised warnings that may invalidate its results:\n" +
+		strings.Join(blockers, "\n") + "\n" +
+		"Treat them as unresolved assumption problems: fix the cause (e.g. scale predictors, raise max_iter, " +
+		"handle zeros or missing values) and re-run, or state the limitation when reporting these results."
+}
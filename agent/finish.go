@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"stats-agent/llmclient"
+	"stats-agent/prompts"
+	"stats-agent/rag"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// FinishCommand ends an analysis: the agent writes a final conclusions message instead of
+// continuing the analysis loop. Text after the command is passed on as extra instructions.
+const FinishCommand = "/finish"
+
+// finishHistoryMessages bounds the conversation tail sent with the finish prompt;
+// the ledger and retrieved facts carry the earlier results.
+const finishHistoryMessages = 12
+
+// completedSessionNote steers the model away from new code after the user concluded the analysis.
+const completedSessionNote = "The user has concluded this analysis and a final summary was given. Answer from the existing results without writing code. If the question needs new computation, say so and ask the user to confirm before running anything."
+
+// IsFinishCommand reports whether input is the finish command (optionally followed by instructions).
+func IsFinishCommand(input string) bool {
+	fields := strings.Fields(strings.ToLower(input))
+	return len(fields) > 0 && fields[0] == FinishCommand
+}
+
+// newAnalysisPattern matches whole request phrases only, so questions about the existing
+// results ("also", "check", "the model's fit") do not reopen a concluded session.
+var newAnalysisPattern = regexp.MustCompile(`\b(?:run (?:a|an|another|the|it|this|that|some)|re-?run|compute|calculate|fit (?:a|an|another|the)|plot|(?:a|another|new|different) (?:model|test|regression|analysis)|(?:more|further) analys[ie]s|continue the analysis|write code|use code)\b`)
+
+// userRequestsNewAnalysis reports whether a message on a concluded session explicitly
+// asks for more computation, which reopens the analysis.
+func userRequestsNewAnalysis(input string) bool {
+	return newAnalysisPattern.MatchString(strings.ToLower(input))
+}
+
+// runFinishSummary short-circuits the analysis loop into one summarization turn: the
+// results ledger and the session's key facts are compiled into a structured conclusions
+// message, and a completion state card marks the session stage as complete.
+func (a *Agent) runFinishSummary(ctx context.Context, input, sessionID string, history []types.AgentMessage, stream *Stream) {
+	_ = stream.Status("Compiling conclusions...")
+
+	request := "Finish the analysis and write the final conclusions."
+	if extra := strings.TrimSpace(strings.TrimSpace(input)[len(FinishCommand):]); extra != "" {
+		request += " " + extra
+	}
+	summary := a.streamFindingsSummary(ctx, sessionID, history, request, stream)
+	if summary == "" || a.rag == nil {
+		return
+	}
+	assistantMsg := types.AgentMessage{
+		Role:        "assistant",
+		Content:     summary,
+		ContentHash: rag.ComputeMessageContentHash("assistant", summary),
+	}
+	a.rag.AddMessagesAsync(sessionID, []types.AgentMessage{assistantMsg})
+
+	// Detached context: the stage must be recorded even if the stream was just closed
+	markCtx, markCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer markCancel()
+	if err := a.rag.MarkSessionComplete(markCtx, sessionID, getCurrentDataset(history), summary); err != nil {
+		a.logger.Warn("Failed to mark session complete",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+	}
+}
+
+// runBudgetSummary ends a run that ran out of turns or budget with a summary of the findings
+// so far. Unlike /finish, the session is not marked complete: the user can continue.
+func (a *Agent) runBudgetSummary(ctx context.Context, reason, sessionID string, history []types.AgentMessage, stream *Stream) {
+	a.logger.Info("Run budget exhausted, ending with a summary turn",
+		zap.String("session_id", sessionID),
+		zap.String("reason", reason))
+	_ = stream.Status("Budget exhausted: summarizing what was found so far...")
+
+	request := fmt.Sprintf("The analysis run stopped because %s. Do not write code. "+
+		"Summarize what has been found so far, state which questions remain open, and suggest the next steps the user can ask for.", reason)
+	summary := a.streamFindingsSummary(ctx, sessionID, history, request, stream)
+	if summary == "" || a.rag == nil {
+		return
+	}
+	a.rag.AddMessagesAsync(sessionID, []types.AgentMessage{{
+		Role:        "assistant",
+		Content:     summary,
+		ContentHash: rag.ComputeMessageContentHash("assistant", summary),
+	}})
+}
+
+// streamFindingsSummary streams one summary turn built from the results ledger, the session's
+// key facts, and the recent conversation, and returns the summary ("" on failure).
+func (a *Agent) streamFindingsSummary(ctx context.Context, sessionID string, history []types.AgentMessage, request string, stream *Stream) string {
+	ledger := a.actionCache.BuildDoneLedger(sessionID)
+
+	var facts string
+	if a.rag != nil {
+		ragCtx, ragCancel := context.WithTimeout(ctx, a.cfg.LLMRequestTimeout)
+		var err error
+		facts, err = a.rag.Query(ragCtx, sessionID, "statistical test results, effect sizes, model estimates and conclusions", a.cfg.RetrievalBudget(types.ModeDataset).Scale(2), nil, nil, "", types.ModeDataset)
+		ragCancel()
+		if err != nil {
+			a.logger.Warn("Failed to query RAG for finish summary, continuing with ledger only",
+				zap.Error(err),
+				zap.String("session_id", sessionID))
+			facts = ""
+		}
+	}
+
+	tail := history
+	if len(tail) > finishHistoryMessages {
+		tail = tail[len(tail)-finishHistoryMessages:]
+	}
+	tail = append(append([]types.AgentMessage(nil), tail...), types.AgentMessage{Role: "user", Content: request})
+
+	// The budget-exhausted path runs when the context is fullest, so the retrieved facts,
+	// ledger and history go through the budgeter like any other turn
+	responseTokens := a.responseHandler.NegotiatedResponseBudget(sessionID, TurnTypeSummary)
+	fit := a.contextBudgeter.Fit(ctx, ContextRequest{
+		SessionID:      sessionID,
+		Query:          request,
+		SystemPrompt:   prompts.FinishSummary() + a.responseHandler.VerbosityInstruction(sessionID),
+		State:          strings.TrimSpace(facts),
+		Evidence:       strings.TrimSpace(ledger),
+		History:        tail,
+		ResponseTokens: responseTokens,
+	})
+	if !a.preflightContext(ctx, sessionID, request, fit, stream) {
+		return ""
+	}
+	messages := append([]types.AgentMessage{{Role: "system", Content: prompts.FinishSummary()}}, fit.Messages...)
+	messages = a.responseHandler.ApplyVerbosity(sessionID, messages)
+
+	temperature := 0.2
+	if a.cfg.ResponseBudgetNegotiation {
+		ctx = llmclient.WithMaxTokens(ctx, responseTokens)
+	}
+	llmCtx, cancelLLM := context.WithCancel(ctx)
+	defer cancelLLM()
+	responseChan, err := a.llm.ChatStream(llmCtx, a.sessionLLMHost(sessionID), messages, &temperature)
+	if err != nil {
+		a.logger.Error("Failed to get LLM response for findings summary",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+		_ = stream.Status("LLM communication error")
+		return ""
+	}
+
+	summary := a.responseHandler.CollectStreamedResponse(ctx, responseChan, stream, sessionID, &ResponseLimit{
+		Budget:      responseTokens,
+		Cancel:      cancelLLM,
+		Host:        a.sessionLLMHost(sessionID),
+		Messages:    messages,
+		Temperature: &temperature,
+	})
+	if a.responseHandler.IsEmpty(summary) {
+		a.logger.Warn("Empty findings summary", zap.String("session_id", sessionID))
+		_ = stream.Status("Received empty response from LLM")
+		return ""
+	}
+	return summary
+}
@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"stats-agent/prompts"
+	"stats-agent/web/types"
+)
+
+const (
+	minFollowUps = 2
+	maxFollowUps = 3
+	// maxFollowUpWords drops suggestions that ignore the prompt's length limit
+	maxFollowUpWords = 20
+)
+
+// SuggestFollowUps asks the summarization LLM for 2-3 next questions building on the
+// session's completed analyses and the run's final answer. Returns nil when suggestions
+// are disabled or the reply did not follow the format.
+func (a *Agent) SuggestFollowUps(ctx context.Context, sessionID, userQuestion, answer string) ([]string, error) {
+	if !a.cfg.FollowUpSuggestionsEnabled {
+		return nil, nil
+	}
+
+	var b strings.Builder
+	if tags := a.ResultTags(sessionID); len(tags) > 0 {
+		fmt.Fprintf(&b, "Tags: %s\n", strings.Join(tags, ", "))
+	}
+	if ledger := a.actionCache.BuildDoneLedger(sessionID); ledger != "" {
+		fmt.Fprintf(&b, "Completed analyses: %s\n", ledger)
+	}
+	if q := strings.TrimSpace(userQuestion); q != "" {
+		fmt.Fprintf(&b, "User's last question: %s\n", truncateString(q, 500))
+	}
+	fmt.Fprintf(&b, "\nLatest answer:\n%s\n", truncateString(strings.TrimSpace(answer), 2000))
+	b.WriteString("\nRespond with only the questions, one per line.")
+
+	messages := []types.AgentMessage{
+		{Role: "system", Content: prompts.FollowUpSuggestions()},
+		{Role: "user", Content: b.String()},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.cfg.LLMRequestTimeout)
+	defer cancel()
+	reply, err := a.llm.Chat(ctx, a.cfg.SummarizationLLMHost, messages, nil)
+	if err != nil {
+		return nil, fmt.Errorf("llm chat call failed for follow-up suggestions: %w", err)
+	}
+	return parseFollowUps(reply, userQuestion), nil
+}
+
+// parseFollowUps keeps the reply's question lines, stripped of list markers and quotes,
+// without duplicates or a repeat of the user's question. Fewer than two usable questions
+// yields nil.
+func parseFollowUps(reply, userQuestion string) []string {
+	seen := map[string]bool{strings.ToLower(strings.TrimSpace(userQuestion)): true}
+	var questions []string
+	for _, line := range strings.Split(reply, "\n") {
+		q := strings.TrimLeftFunc(line, func(r rune) bool {
+			return unicode.IsSpace(r) || unicode.IsDigit(r) || strings.ContainsRune("-*•.)", r)
+		})
+		q = stripSurroundingQuotes(strings.TrimSpace(q))
+		if !strings.HasSuffix(q, "?") || len(strings.Fields(q)) > maxFollowUpWords {
+			continue
+		}
+		key := strings.ToLower(q)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		questions = append(questions, q)
+		if len(questions) == maxFollowUps {
+			break
+		}
+	}
+	if len(questions) < minFollowUps {
+		return nil
+	}
+	return questions
+}
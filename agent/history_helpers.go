@@ -22,8 +22,9 @@ func getCurrentDataset(history []types.AgentMessage) string {
 
 		// Check for pd.read_csv, read_excel, etc.
 		patterns := []string{
-			`(?i)read_csv\s*\(\s*['"]([^'"]+\.csv)['"]`,
+			`(?i)read_csv\s*\(\s*['"]([^'"]+\.csv(?:\.gz)?)['"]`,
 			`(?i)read_excel\s*\(\s*['"]([^'"]+\.xlsx?)['"]`,
+			`(?i)read_parquet\s*\(\s*['"]([^'"]+\.parquet)['"]`,
 			`(?i)read_table\s*\(\s*['"]([^'"]+)['"]`,
 		}
 
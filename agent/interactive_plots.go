@@ -0,0 +1,25 @@
+package agent
+
+import (
+	"stats-agent/prompts"
+	"stats-agent/web/types"
+)
+
+// plotInstruction returns the Plotly plotting instruction when INTERACTIVE_PLOTS_ENABLED is
+// set, or "".
+func (a *Agent) plotInstruction() string {
+	if !a.cfg.InteractivePlotsEnabled {
+		return ""
+	}
+	return prompts.InteractivePlots()
+}
+
+// applyPlotInstruction prepends the plotting instruction as a system message. Like
+// ApplyVerbosity, call it after context budgeting.
+func (a *Agent) applyPlotInstruction(messages []types.AgentMessage) []types.AgentMessage {
+	instruction := a.plotInstruction()
+	if instruction == "" {
+		return messages
+	}
+	return append([]types.AgentMessage{{Role: "system", Content: instruction}}, messages...)
+}
@@ -0,0 +1,419 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"stats-agent/tools"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// Transformation kinds recorded in the data lineage.
+const (
+	TransformFilter      = "filter"
+	TransformDropMissing = "drop_missing"
+	TransformDeduplicate = "deduplicate"
+	TransformDropColumns = "drop_columns"
+	TransformDropRows    = "drop_rows"
+	TransformImpute      = "impute"
+	TransformRecode      = "recode"
+	TransformRescale     = "rescale"
+)
+
+// maxCohortOperations bounds the transformations listed in the prompt's cohort block.
+const maxCohortOperations = 15
+
+// maxDistributionTargets bounds the columns compared before/after one cell.
+const maxDistributionTargets = 6
+
+var (
+	lineageAssignRegex    = regexp.MustCompile(`^([A-Za-z_]\w*)\s*=\s*(.+)$`)
+	lineageColAssignRegex = regexp.MustCompile(`^[A-Za-z_]\w*(?:\.loc\[[^,\]]*,\s*|\[)\s*['"]([^'"]+)['"]\s*\]\s*=\s*(.+)$`)
+	lineageSubscriptRegex = regexp.MustCompile(`^[A-Za-z_]\w*(?:\.loc)?\[(.+)\](?:\.copy\(\))?$`)
+	lineageQueryRegex     = regexp.MustCompile(`\.query\(\s*['"](.+?)['"]`)
+	lineageConditionRegex = regexp.MustCompile(`==|!=|>=|<=|[<>]|\.isin\(|\.between\(|\.notna\(|\.notnull\(|\.isna\(|\.isnull\(|~`)
+	lineageListArgRegex   = regexp.MustCompile(`(?:subset|columns)\s*=\s*(\[[^\]]*\]|['"][^'"]+['"])`)
+	lineageQuotedRegex    = regexp.MustCompile(`['"]([^'"]+)['"]`)
+	lineageImputeRegex    = regexp.MustCompile(`\.fillna\(|\.interpolate\(|SimpleImputer|KNNImputer|IterativeImputer`)
+	lineageRescaleRegex   = regexp.MustCompile(`np\.log(?:1p|2|10)?\(|np\.sqrt\(|np\.exp\(|winsorize\(|\.clip\(|boxcox\(|yeojohnson\(|zscore\(|StandardScaler|MinMaxScaler|RobustScaler`)
+	lineageRecodeRegex    = regexp.MustCompile(`\.replace\(|\.map\(|pd\.cut\(|pd\.qcut\(|\.astype\(|np\.where\(|\.apply\(|pd\.get_dummies\(`)
+	lineageShapeRegex     = regexp.MustCompile(`\((\d+),\s*(\d+)\)`)
+	lineageRowsRegex      = regexp.MustCompile(`(?i)(\d[\d,]*)\s+rows?\b`)
+	lineageFrameRegex     = regexp.MustCompile(`^([A-Za-z_]\w*)`)
+	lineageSourceRegex    = regexp.MustCompile(`\[\s*['"]([^'"]+)['"]\s*\]`)
+	lineageSaveRegex      = regexp.MustCompile(`\.to_(?:csv|excel|parquet|feather|pickle|stata)\(\s*(?:(?:path_or_buf|path|excel_writer)\s*=\s*)?[fr]?['"]([^'"]+)['"]`)
+)
+
+// ExtractTransformations parses pandas data transformations from code: row filters,
+// dropped rows/columns, imputation, and recoding. Lines that only inspect data are ignored.
+func ExtractTransformations(code string) []types.Transformation {
+	var ops []types.Transformation
+	for _, raw := range strings.Split(code, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "print(") {
+			continue
+		}
+		if op, ok := parseTransformationLine(line); ok {
+			if m := lineageFrameRegex.FindStringSubmatch(line); m != nil {
+				op.Frame = m[1]
+			}
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// ExtractSavedDatasets returns the files code writes a dataframe to (to_csv, to_excel,
+// to_parquet, ...). Writes to computed paths are not recognized.
+func ExtractSavedDatasets(code string) []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, raw := range strings.Split(code, "\n") {
+		line := strings.TrimSpace(raw)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, m := range lineageSaveRegex.FindAllStringSubmatch(line, -1) {
+			name := filepath.Base(m[1])
+			if !seen[name] {
+				seen[name] = true
+				files = append(files, name)
+			}
+		}
+	}
+	return files
+}
+
+func parseTransformationLine(line string) (types.Transformation, bool) {
+	// Column-level changes: df['x'] = ...
+	if m := lineageColAssignRegex.FindStringSubmatch(line); m != nil {
+		column, rhs := m[1], strings.TrimSpace(m[2])
+		switch {
+		case lineageImputeRegex.MatchString(rhs):
+			return types.Transformation{Kind: TransformImpute, Description: "impute " + column + ": " + truncateString(rhs, 100), Columns: []string{column}}, true
+		case lineageRescaleRegex.MatchString(rhs):
+			return types.Transformation{Kind: TransformRescale, Description: "rescale " + column + ": " + truncateString(rhs, 100), Columns: []string{column}}, true
+		case lineageRecodeRegex.MatchString(rhs):
+			return types.Transformation{Kind: TransformRecode, Description: "recode " + column + ": " + truncateString(rhs, 100), Columns: []string{column}}, true
+		}
+		return types.Transformation{}, false
+	}
+
+	// Frame-level changes only count when the result is kept: df = ...
+	m := lineageAssignRegex.FindStringSubmatch(line)
+	if m == nil {
+		if strings.Contains(line, "inplace=True") {
+			return parseFrameOperation(line)
+		}
+		return types.Transformation{}, false
+	}
+	return parseFrameOperation(strings.TrimSpace(m[2]))
+}
+
+// DistributionTargets returns the columns code imputes, rescales or recodes one at a time
+// (df['x'] = ...), whose distributions can be compared before and after it runs. A new
+// column is compared with the first column its expression reads, so df['log_x'] =
+// np.log(df['x']) compares log_x with x.
+func DistributionTargets(code string) []tools.DistributionTarget {
+	var targets []tools.DistributionTarget
+	seen := make(map[string]bool)
+	for _, raw := range strings.Split(code, "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m := lineageColAssignRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		op, ok := parseTransformationLine(line)
+		if !ok || (op.Kind != TransformImpute && op.Kind != TransformRescale && op.Kind != TransformRecode) {
+			continue
+		}
+		frame := lineageFrameRegex.FindStringSubmatch(line)[1]
+		column, source := m[1], m[1]
+		if s := lineageSourceRegex.FindStringSubmatch(m[2]); s != nil {
+			source = s[1]
+		}
+		key := frame + "\x00" + column
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		targets = append(targets, tools.DistributionTarget{Frame: frame, Column: column, Source: source, Kind: op.Kind})
+		if len(targets) == maxDistributionTargets {
+			break
+		}
+	}
+	return targets
+}
+
+func parseFrameOperation(rhs string) (types.Transformation, bool) {
+	columns := lineageListColumns(rhs)
+	switch {
+	case strings.Contains(rhs, ".dropna("):
+		desc := "drop rows with missing values"
+		if len(columns) > 0 {
+			desc += " in " + strings.Join(columns, ", ")
+		}
+		return types.Transformation{Kind: TransformDropMissing, Description: desc, Columns: columns}, true
+	case strings.Contains(rhs, ".drop_duplicates("):
+		return types.Transformation{Kind: TransformDeduplicate, Description: "drop duplicate rows", Columns: columns}, true
+	case strings.Contains(rhs, ".drop("):
+		if strings.Contains(rhs, "columns=") || strings.Contains(rhs, "axis=1") || strings.Contains(rhs, "axis='columns'") || strings.Contains(rhs, `axis="columns"`) {
+			if len(columns) == 0 {
+				columns = lineageQuotedArgs(rhs[strings.Index(rhs, ".drop("):])
+			}
+			return types.Transformation{Kind: TransformDropColumns, Description: "drop columns " + strings.Join(columns, ", "), Columns: columns}, true
+		}
+		return types.Transformation{Kind: TransformDropRows, Description: "drop rows: " + truncateString(rhs, 100)}, true
+	case lineageImputeRegex.MatchString(rhs):
+		return types.Transformation{Kind: TransformImpute, Description: "impute: " + truncateString(rhs, 100), Columns: columns}, true
+	}
+
+	if q := lineageQueryRegex.FindStringSubmatch(rhs); q != nil {
+		return types.Transformation{Kind: TransformFilter, Description: q[1]}, true
+	}
+	if s := lineageSubscriptRegex.FindStringSubmatch(rhs); s != nil && lineageConditionRegex.MatchString(s[1]) {
+		return types.Transformation{Kind: TransformFilter, Description: truncateString(strings.TrimSpace(s[1]), 120)}, true
+	}
+	return types.Transformation{}, false
+}
+
+// lineageListColumns returns the column names passed as subset= or columns=.
+func lineageListColumns(s string) []string {
+	m := lineageListArgRegex.FindStringSubmatch(s)
+	if m == nil {
+		return nil
+	}
+	return lineageQuotedArgs(m[1])
+}
+
+func lineageQuotedArgs(s string) []string {
+	var out []string
+	for _, q := range lineageQuotedRegex.FindAllStringSubmatch(s, -1) {
+		out = append(out, q[1])
+	}
+	return out
+}
+
+// ParseTransformationStep builds a lineage step from executed code and its output.
+// Row counts come from shape tuples or "N rows" mentions: the first is taken as the
+// count before the transformations and the last as the count after. Returns nil when
+// the code neither transforms nor saves a dataset.
+func ParseTransformationStep(code, output string) *types.TransformationStep {
+	ops := ExtractTransformations(code)
+	saved := ExtractSavedDatasets(code)
+	if len(ops) == 0 && len(saved) == 0 {
+		return nil
+	}
+	step := &types.TransformationStep{Operations: ops, SavedTo: saved, ExecutedAt: time.Now()}
+
+	var counts []int
+	if shapes := lineageShapeRegex.FindAllStringSubmatch(output, -1); len(shapes) > 0 {
+		for _, s := range shapes {
+			if n, err := strconv.Atoi(s[1]); err == nil {
+				counts = append(counts, n)
+			}
+		}
+		step.Columns, _ = strconv.Atoi(shapes[len(shapes)-1][2])
+	} else {
+		for _, r := range lineageRowsRegex.FindAllStringSubmatch(output, -1) {
+			if n, err := strconv.Atoi(strings.ReplaceAll(r[1], ",", "")); err == nil {
+				counts = append(counts, n)
+			}
+		}
+	}
+	if len(counts) > 0 {
+		step.RowsAfter = counts[len(counts)-1]
+		if len(counts) > 1 {
+			step.RowsBefore = counts[0]
+		}
+	}
+	return step
+}
+
+// UnsavedTransformations returns the transformations recorded after the last step that
+// saved a dataset to disk: they exist only in the session's memory and are lost when it
+// restarts. A step that transforms and saves counts as saved.
+func UnsavedTransformations(steps []types.TransformationStep) []types.Transformation {
+	var unsaved []types.Transformation
+	for _, step := range steps {
+		if len(step.SavedTo) > 0 {
+			unsaved = nil
+			continue
+		}
+		unsaved = append(unsaved, step.Operations...)
+	}
+	return unsaved
+}
+
+// FormatCohortDefinition renders the lineage as a <cohort> block describing the current
+// analytic cohort. Returns "" when no transformations were recorded.
+func FormatCohortDefinition(steps []types.TransformationStep) string {
+	var lines []string
+	currentRows := 0
+	for _, step := range steps {
+		rows := ""
+		if step.RowsBefore > 0 && step.RowsAfter > 0 {
+			rows = fmt.Sprintf(" (rows %d → %d)", step.RowsBefore, step.RowsAfter)
+		} else if step.RowsAfter > 0 {
+			rows = fmt.Sprintf(" (rows after: %d)", step.RowsAfter)
+		}
+		if step.RowsAfter > 0 {
+			currentRows = step.RowsAfter
+		}
+		for i, op := range step.Operations {
+			line := op.Kind + ": " + op.Description
+			if i == len(step.Operations)-1 {
+				line += rows
+			}
+			lines = append(lines, line)
+		}
+		// Saves matter only once something was transformed
+		if len(lines) > 0 {
+			for _, f := range step.SavedTo {
+				lines = append(lines, "save: wrote "+f)
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	omitted := 0
+	if len(lines) > maxCohortOperations {
+		omitted = len(lines) - maxCohortOperations
+		lines = lines[omitted:]
+	}
+
+	var b strings.Builder
+	b.WriteString("<cohort>\nCurrent analytic cohort: these transformations are already applied in the session. Do not re-apply them; state them when reporting results.\n")
+	if omitted > 0 {
+		fmt.Fprintf(&b, "(%d earlier transformations omitted)\n", omitted)
+	}
+	for i, line := range lines {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, line)
+	}
+	if currentRows > 0 {
+		fmt.Fprintf(&b, "Current rows: %d\n", currentRows)
+	}
+	if unsaved := len(UnsavedTransformations(steps)); unsaved > 0 {
+		fmt.Fprintf(&b, "%d transformation(s) exist only in memory and were not saved to a file. If your conclusions depend on them, say so and suggest saving the cleaned dataset.\n", unsaved)
+	}
+	b.WriteString("</cohort>")
+	return b.String()
+}
+
+// SetSessionLineage replaces a session's transformation log (seeded from stored messages).
+func (a *Agent) SetSessionLineage(sessionID string, steps []types.TransformationStep) {
+	if sessionID == "" {
+		return
+	}
+	a.lineageMu.Lock()
+	defer a.lineageMu.Unlock()
+	if steps == nil {
+		steps = []types.TransformationStep{}
+	}
+	a.lineage[sessionID] = steps
+}
+
+// HasSessionLineage reports whether the session's transformation log was loaded.
+func (a *Agent) HasSessionLineage(sessionID string) bool {
+	a.lineageMu.RLock()
+	defer a.lineageMu.RUnlock()
+	_, ok := a.lineage[sessionID]
+	return ok
+}
+
+// SessionLineage returns a copy of the session's transformation log.
+func (a *Agent) SessionLineage(sessionID string) []types.TransformationStep {
+	a.lineageMu.RLock()
+	defer a.lineageMu.RUnlock()
+	return append([]types.TransformationStep(nil), a.lineage[sessionID]...)
+}
+
+// clearSessionLineage drops the session's transformation log.
+func (a *Agent) clearSessionLineage(sessionID string) {
+	a.lineageMu.Lock()
+	defer a.lineageMu.Unlock()
+	delete(a.lineage, sessionID)
+}
+
+// ForgetRetractedSteps replaces the session's lineage after executed steps were retracted
+// and purges the action cache, so the agent may run those steps again.
+func (a *Agent) ForgetRetractedSteps(sessionID string, steps []types.TransformationStep) {
+	a.SetSessionLineage(sessionID, steps)
+	if a.actionCache != nil {
+		a.actionCache.PurgeSession(sessionID)
+	}
+}
+
+// recordTransformations appends the transformations of successfully executed code to the session's log.
+func (a *Agent) recordTransformations(sessionID, code, output string, userEdited bool) {
+	step := ParseTransformationStep(code, output)
+	if step == nil {
+		return
+	}
+	step.UserEdited = userEdited
+	a.lineageMu.Lock()
+	defer a.lineageMu.Unlock()
+	a.lineage[sessionID] = append(a.lineage[sessionID], *step)
+}
+
+// snapshotDistributions records the pre-cell values of the columns code transforms, so
+// recordDistributionDiffs can compare them afterwards. Returns the targets to compare, or
+// nil when TRANSFORM_DIFF_ENABLED is off, nothing is transformed, or the snapshot failed.
+func (a *Agent) snapshotDistributions(ctx context.Context, sessionID, code string) []tools.DistributionTarget {
+	if !a.cfg.TransformDiffEnabled {
+		return nil
+	}
+	targets := DistributionTargets(code)
+	if len(targets) == 0 {
+		return nil
+	}
+	if err := a.pythonTool.SnapshotDistributions(ctx, sessionID, targets); err != nil {
+		a.logger.Warn("Failed to snapshot distributions before transformation",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+		return nil
+	}
+	return targets
+}
+
+// recordDistributionDiffs compares the snapshotted columns with their values after the
+// cell ran and attaches the result to the session's latest lineage step. Call it after
+// recordTransformations; the comparison is best effort.
+func (a *Agent) recordDistributionDiffs(ctx context.Context, sessionID string, targets []tools.DistributionTarget) {
+	if len(targets) == 0 {
+		return
+	}
+	diffs, err := a.pythonTool.DistributionDiffs(ctx, sessionID, targets, fmt.Sprintf("transform_%d", time.Now().UnixNano()))
+	if err != nil {
+		a.logger.Warn("Failed to compare distributions after transformation",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+		return
+	}
+	if len(diffs) == 0 {
+		return
+	}
+	a.lineageMu.Lock()
+	defer a.lineageMu.Unlock()
+	steps := a.lineage[sessionID]
+	if len(steps) == 0 {
+		return
+	}
+	steps[len(steps)-1].Diffs = diffs
+}
+
+// cohortDefinition returns the session's <cohort> block for the prompt.
+func (a *Agent) cohortDefinition(sessionID string) string {
+	return FormatCohortDefinition(a.SessionLineage(sessionID))
+}
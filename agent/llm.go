@@ -2,35 +2,34 @@ package agent
 
 import (
     "context"
-    "stats-agent/config"
     "stats-agent/llmclient"
     "stats-agent/prompts"
     "stats-agent/web/types"
-
-	"go.uber.org/zap"
 )
 
+// documentModeTemperature is slightly higher for document Q&A (more natural language).
+const documentModeTemperature = 0.3
+
 func buildSystemPrompt() string { return prompts.AgentSystem() }
 
 func buildDocumentPrompt() string { return prompts.DocumentQA() }
 
-func getLLMResponse(ctx context.Context, llamaCppHost string, messages []types.AgentMessage, cfg *config.Config, logger *zap.Logger, temperature *float64) (<-chan string, error) {
-    // Always place our analysis protocol as the first system message.
-    // Keep any existing system memory/context as a separate system message after it.
-    systemMessage := types.AgentMessage{Role: "system", Content: buildSystemPrompt()}
-    chatMessages := append([]types.AgentMessage{systemMessage}, messages...)
+func getLLMResponse(ctx context.Context, client llmclient.ChatClient, llamaCppHost string, messages []types.AgentMessage, temperature *float64) (<-chan string, error) {
+    return client.ChatStream(ctx, llamaCppHost, withSystemPrompt(messages), temperature)
+}
 
-    client := llmclient.New(cfg, logger)
-    return client.ChatStream(ctx, llamaCppHost, chatMessages, temperature)
+// withSystemPrompt places our analysis protocol as the first system message.
+// Any existing system memory/context stays a separate system message after it.
+func withSystemPrompt(messages []types.AgentMessage) []types.AgentMessage {
+    systemMessage := types.AgentMessage{Role: "system", Content: buildSystemPrompt()}
+    return append([]types.AgentMessage{systemMessage}, messages...)
 }
 
-func getLLMResponseForDocumentMode(ctx context.Context, llamaCppHost string, messages []types.AgentMessage, cfg *config.Config, logger *zap.Logger) (<-chan string, error) {
+func getLLMResponseForDocumentMode(ctx context.Context, client llmclient.ChatClient, llamaCppHost string, messages []types.AgentMessage) (<-chan string, error) {
     // Use document Q&A prompt instead of dataset analysis prompt
     systemMessage := types.AgentMessage{Role: "system", Content: buildDocumentPrompt()}
     chatMessages := append([]types.AgentMessage{systemMessage}, messages...)
 
-    // Use a slightly higher temperature for document Q&A (more natural language)
-    temperature := 0.3
-    client := llmclient.New(cfg, logger)
+    temperature := documentModeTemperature
     return client.ChatStream(ctx, llamaCppHost, chatMessages, &temperature)
 }
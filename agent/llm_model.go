@@ -0,0 +1,24 @@
+package agent
+
+// SetSessionLLMModel selects the named LLM_MODELS endpoint for a session's analysis calls.
+// "" (or a name no longer configured) uses MAIN_LLM_HOST.
+func (a *Agent) SetSessionLLMModel(sessionID, model string) {
+	if sessionID == "" {
+		return
+	}
+	a.llmModelMu.Lock()
+	defer a.llmModelMu.Unlock()
+	if _, ok := a.cfg.LLMModel(model); !ok {
+		delete(a.llmModels, sessionID)
+		return
+	}
+	a.llmModels[sessionID] = model
+}
+
+// sessionLLMHost returns the host serving the session's main LLM calls.
+func (a *Agent) sessionLLMHost(sessionID string) string {
+	a.llmModelMu.RLock()
+	model := a.llmModels[sessionID]
+	a.llmModelMu.RUnlock()
+	return a.cfg.LLMHost(model)
+}
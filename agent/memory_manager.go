@@ -14,22 +14,23 @@ import (
 
 // MemoryManager handles token counting, context window management, and history trimming.
 type MemoryManager struct {
-	cfg    *config.Config
-	logger *zap.Logger
+	cfg       *config.Config
+	tokenizer llmclient.TokenCounter
+	logger    *zap.Logger
 }
 
 // NewMemoryManager creates a new memory manager instance.
-func NewMemoryManager(cfg *config.Config, logger *zap.Logger) *MemoryManager {
+func NewMemoryManager(cfg *config.Config, tokenizer llmclient.TokenCounter, logger *zap.Logger) *MemoryManager {
 	return &MemoryManager{
-		cfg:    cfg,
-		logger: logger,
+		cfg:       cfg,
+		tokenizer: tokenizer,
+		logger:    logger,
 	}
 }
 
 // CountTokens returns the token count for the given text using the LLM's tokenize endpoint.
 func (m *MemoryManager) CountTokens(ctx context.Context, text string) (int, error) {
-	client := llmclient.New(m.cfg, m.logger)
-	return client.Tokenize(ctx, m.cfg.MainLLMHost, text)
+	return m.tokenizer.Tokenize(ctx, m.cfg.MainLLMHost, text)
 }
 
 // CalculateHistorySize returns the total token count for the entire message history.
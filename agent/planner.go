@@ -0,0 +1,348 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"stats-agent/config"
+	"stats-agent/llmclient"
+	"stats-agent/prompts"
+	"stats-agent/rag"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// AnalysisPlan is the numbered plan a dataset-mode run works through.
+type AnalysisPlan struct {
+	Goal  string // the request the plan was made for
+	Steps []PlanStep
+}
+
+// PlanStep is one planned code cell. Test is the action signature test that completes it,
+// or "" for steps without a statistical test.
+type PlanStep struct {
+	Description string
+	Test        string
+	Done        bool
+}
+
+// Remaining returns the number of steps not done yet.
+func (p *AnalysisPlan) Remaining() int {
+	remaining := 0
+	for _, step := range p.Steps {
+		if !step.Done {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+// Format renders the plan as stored in its state card; ParsePlanCard reads it back.
+func (p *AnalysisPlan) Format() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Goal: %s\n", p.Goal)
+	for i, step := range p.Steps {
+		mark := " "
+		if step.Done {
+			mark = "x"
+		}
+		fmt.Fprintf(&b, "%d. [%s] %s", i+1, mark, step.Description)
+		if step.Test != "" {
+			fmt.Fprintf(&b, " [test: %s]", step.Test)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+var (
+	planLinePattern = regexp.MustCompile(`^\s*(\d+)[.)]\s+(.+)$`)
+	planCardPattern = regexp.MustCompile(`^(\d+)\.\s+\[( |x)\]\s+(.+)$`)
+	planTestPattern = regexp.MustCompile(`\s*\[test:\s*([A-Za-z0-9_]+)\s*\]\s*$`)
+)
+
+// parsePlanStep splits a trailing [test: NAME] off a step line.
+func parsePlanStep(text string) PlanStep {
+	step := PlanStep{Description: strings.TrimSpace(text)}
+	if m := planTestPattern.FindStringSubmatchIndex(step.Description); m != nil {
+		step.Test = strings.ToLower(step.Description[m[2]:m[3]])
+		step.Description = strings.TrimSpace(step.Description[:m[0]])
+	}
+	return step
+}
+
+// parsePlanReply reads the planning LLM's numbered list, keeping at most maxSteps steps.
+func parsePlanReply(reply string, maxSteps int) []PlanStep {
+	var steps []PlanStep
+	for _, line := range strings.Split(reply, "\n") {
+		m := planLinePattern.FindStringSubmatch(strings.Trim(line, "*"))
+		if m == nil {
+			continue
+		}
+		if step := parsePlanStep(m[2]); step.Description != "" {
+			steps = append(steps, step)
+		}
+		if len(steps) == maxSteps {
+			break
+		}
+	}
+	return steps
+}
+
+// ParsePlanCard reads a plan back from its state card. Returns nil when the card has no
+// steps.
+func ParsePlanCard(card string) *AnalysisPlan {
+	plan := &AnalysisPlan{}
+	for _, line := range strings.Split(card, "\n") {
+		line = strings.TrimSpace(line)
+		if goal, ok := strings.CutPrefix(line, "Goal:"); ok {
+			plan.Goal = strings.TrimSpace(goal)
+			continue
+		}
+		m := planCardPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if n, _ := strconv.Atoi(m[1]); n != len(plan.Steps)+1 {
+			continue
+		}
+		step := parsePlanStep(m[3])
+		step.Done = m[2] == "x"
+		plan.Steps = append(plan.Steps, step)
+	}
+	if len(plan.Steps) == 0 {
+		return nil
+	}
+	return plan
+}
+
+// Planner asks the LLM for a numbered analysis plan on the first turn of a run, keeps it
+// as a plan state card, marks steps done as actions complete, and supplies the remaining
+// steps for each turn's context.
+type Planner struct {
+	cfg    *config.Config
+	llm    llmclient.ChatClient
+	rag    *rag.RAG
+	logger *zap.Logger
+
+	mu    sync.Mutex
+	plans map[string]*AnalysisPlan // by session; loaded from the state card on first use
+}
+
+// NewPlanner creates the planner. rag may be nil, in which case plans are kept in memory.
+func NewPlanner(cfg *config.Config, llm llmclient.ChatClient, rag *rag.RAG, logger *zap.Logger) *Planner {
+	return &Planner{
+		cfg:    cfg,
+		llm:    llm,
+		rag:    rag,
+		logger: logger,
+		plans:  make(map[string]*AnalysisPlan),
+	}
+}
+
+// Plan returns the session's current plan, or nil.
+func (p *Planner) Plan(ctx context.Context, sessionID string) *AnalysisPlan {
+	p.mu.Lock()
+	plan, ok := p.plans[sessionID]
+	p.mu.Unlock()
+	if ok || p.rag == nil {
+		return plan
+	}
+	// Sessions planned before a restart only have the state card
+	plan = ParsePlanCard(p.rag.ActivePlanCard(ctx, sessionID))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if existing, ok := p.plans[sessionID]; ok {
+		return existing
+	}
+	p.plans[sessionID] = plan
+	return plan
+}
+
+// EnsurePlan keeps the session's unfinished plan, or asks the LLM on host for a new one
+// for request. Steps the action cache already has a matching result for start out done.
+// Returns whether a plan was made.
+func (p *Planner) EnsurePlan(ctx context.Context, sessionID, host, request, datasets string, completed []ActionSignature) (bool, error) {
+	if plan := p.Plan(ctx, sessionID); plan != nil {
+		p.mu.Lock()
+		unfinished := plan.Remaining() > 0
+		p.mu.Unlock()
+		if unfinished {
+			return false, nil
+		}
+	}
+
+	var b strings.Builder
+	if datasets != "" {
+		fmt.Fprintf(&b, "%s\n\n", datasets)
+	}
+	fmt.Fprintf(&b, "Request: %s\n\nWrite a plan of at most %d steps.", truncateString(strings.TrimSpace(request), 1500), p.cfg.PlannerMaxSteps)
+	messages := []types.AgentMessage{
+		{Role: "system", Content: prompts.AnalysisPlan()},
+		{Role: "user", Content: b.String()},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.LLMRequestTimeout)
+	defer cancel()
+	reply, err := p.llm.Chat(ctx, host, messages, nil)
+	if err != nil {
+		return false, fmt.Errorf("llm chat call failed for analysis plan: %w", err)
+	}
+	steps := parsePlanReply(reply, p.cfg.PlannerMaxSteps)
+	if len(steps) == 0 {
+		return false, fmt.Errorf("analysis plan reply had no numbered steps")
+	}
+
+	plan := &AnalysisPlan{Goal: truncateString(strings.Join(strings.Fields(request), " "), 300), Steps: steps}
+	for _, sig := range completed {
+		if i := matchPlanStep(plan, sig); i >= 0 {
+			plan.Steps[i].Done = true
+		}
+	}
+	p.mu.Lock()
+	p.plans[sessionID] = plan
+	p.mu.Unlock()
+	p.store(ctx, sessionID, plan)
+	return true, nil
+}
+
+// RecordAction marks the step a successful action completes: the first remaining step
+// with the action's test (preferring one that names its variables), or else the next step
+// if it has no test.
+func (p *Planner) RecordAction(ctx context.Context, sessionID string, sig ActionSignature) {
+	plan := p.Plan(ctx, sessionID)
+	if plan == nil {
+		return
+	}
+	p.mu.Lock()
+	i := matchPlanStep(plan, sig)
+	if i < 0 {
+		for j, step := range plan.Steps {
+			if !step.Done {
+				if step.Test == "" {
+					i = j
+				}
+				break
+			}
+		}
+	}
+	if i >= 0 {
+		plan.Steps[i].Done = true
+	}
+	p.mu.Unlock()
+	if i >= 0 {
+		p.logger.Debug("Plan step completed",
+			zap.String("session_id", sessionID),
+			zap.Int("step", i+1),
+			zap.String("action", sig.String()))
+		p.store(ctx, sessionID, plan)
+	}
+}
+
+// matchPlanStep returns the index of the remaining step completed by sig, or -1. The
+// step's test must match. When sig has variables, a step naming one of them is preferred;
+// otherwise the first step with the test is used unless it quotes other variables.
+func matchPlanStep(plan *AnalysisPlan, sig ActionSignature) int {
+	test := strings.ToLower(sig.Test)
+	if test == "" {
+		return -1
+	}
+	first := -1
+	for i, step := range plan.Steps {
+		// "ttest" in a plan matches ttest_ind and ttest_rel
+		if step.Done || step.Test == "" || !strings.HasPrefix(test, step.Test) {
+			continue
+		}
+		if len(sig.Variables) == 0 {
+			return i
+		}
+		description := strings.ToLower(step.Description)
+		for _, v := range sig.Variables {
+			if strings.Contains(description, strings.ToLower(v)) {
+				return i
+			}
+		}
+		if first < 0 && !stepNamesVariables(description) {
+			first = i
+		}
+	}
+	return first
+}
+
+// stepNamesVariables reports whether a step description quotes variable names, in which
+// case an action on other variables does not complete it.
+func stepNamesVariables(description string) bool {
+	return strings.ContainsAny(description, "'\"`")
+}
+
+// Block returns the <plan> block with the remaining steps for the turn's context, or ""
+// when the session has no plan.
+func (p *Planner) Block(ctx context.Context, sessionID string) string {
+	plan := p.Plan(ctx, sessionID)
+	if plan == nil {
+		return ""
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	remaining := plan.Remaining()
+	if remaining == 0 {
+		return fmt.Sprintf("<plan>\nAll %d planned steps are done. Answer the request from the results instead of running more code, unless they call for another step.\n</plan>", len(plan.Steps))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<plan>\nAnalysis plan: %d of %d steps done. Remaining steps, in order:\n", len(plan.Steps)-remaining, len(plan.Steps))
+	for i, step := range plan.Steps {
+		if !step.Done {
+			fmt.Fprintf(&b, "%d. %s\n", i+1, step.Description)
+		}
+	}
+	b.WriteString("Work on the next remaining step. Skip or change steps the results show do not apply.\n</plan>")
+	return b.String()
+}
+
+// Clear forgets the session's plan.
+func (p *Planner) Clear(sessionID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.plans, sessionID)
+}
+
+// store re-stores the plan's state card. Failures are logged; the plan stays in memory.
+func (p *Planner) store(ctx context.Context, sessionID string, plan *AnalysisPlan) {
+	if p.rag == nil {
+		return
+	}
+	p.mu.Lock()
+	card := plan.Format()
+	p.mu.Unlock()
+	if err := p.rag.StorePlanCard(ctx, sessionID, card); err != nil {
+		p.logger.Warn("Failed to store plan state card",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+	}
+}
+
+// planRun makes a plan for the run's request unless the session's plan is unfinished.
+// Planning failures are logged and the run proceeds without a new plan.
+func (a *Agent) planRun(ctx context.Context, sessionID, input string, stream *Stream) {
+	created, err := a.planner.EnsurePlan(ctx, sessionID, a.sessionLLMHost(sessionID), input, a.datasetsBlock(sessionID), a.actionCache.SuccessfulSignatures(sessionID))
+	if err != nil {
+		a.logger.Warn("Failed to make an analysis plan, continuing without one",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+		return
+	}
+	if !created {
+		return
+	}
+	if plan := a.planner.Plan(ctx, sessionID); plan != nil {
+		a.logger.Info("Made analysis plan",
+			zap.String("session_id", sessionID),
+			zap.Int("steps", len(plan.Steps)))
+		_ = stream.Status(fmt.Sprintf("Planned %d analysis steps", len(plan.Steps)))
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"stats-agent/config"
 	"stats-agent/rag"
@@ -376,3 +380,105 @@ func (qb *QueryBuilder) extractFirstSentence(text string) string {
 
 	return text
 }
+
+// maxLevelMatches caps how many resolved levels are injected into one turn.
+const maxLevelMatches = 8
+
+// LevelMatch is a categorical level mentioned in the user's message and the profiled
+// column that holds it.
+type LevelMatch struct {
+	Level   string
+	Dataset string
+	Column  types.ColumnSchema
+	Count   int
+}
+
+// ResolveLevels finds the categorical levels of the session's level dictionary (dataset
+// → profiled columns) that the user mentions, as whole words in any case. A level held by
+// several columns matches each of them. Numeric codes and levels shorter than three
+// characters are skipped, since they match too much ordinary text.
+func (qb *QueryBuilder) ResolveLevels(userInput string, dictionary map[string][]types.ColumnSchema) []LevelMatch {
+	if len(dictionary) == 0 || strings.TrimSpace(userInput) == "" {
+		return nil
+	}
+	lower := strings.ToLower(userInput)
+
+	datasets := make([]string, 0, len(dictionary))
+	for dataset := range dictionary {
+		datasets = append(datasets, dataset)
+	}
+	sort.Strings(datasets)
+
+	var matches []LevelMatch
+	for _, dataset := range datasets {
+		for _, col := range dictionary[dataset] {
+			for _, level := range col.Levels {
+				value := strings.TrimSpace(level.Value)
+				if !mentionableLevel(value) || !containsWord(lower, strings.ToLower(value)) {
+					continue
+				}
+				matches = append(matches, LevelMatch{Level: value, Dataset: dataset, Column: col, Count: level.Count})
+				if len(matches) >= maxLevelMatches {
+					return matches
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// LevelQueryTokens returns "vars:" tokens naming the columns of resolved levels, so
+// retrieval favors earlier analyses of those columns.
+func (qb *QueryBuilder) LevelQueryTokens(matches []LevelMatch) string {
+	seen := make(map[string]bool)
+	var cols []string
+	for _, m := range matches {
+		if !seen[m.Column.Name] {
+			seen[m.Column.Name] = true
+			cols = append(cols, m.Column.Name)
+		}
+	}
+	if len(cols) == 0 {
+		return ""
+	}
+	return "vars:" + strings.Join(cols, ",")
+}
+
+// mentionableLevel reports whether a level is distinctive enough to match in free text.
+func mentionableLevel(value string) bool {
+	if len([]rune(value)) < 3 {
+		return false
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return false
+	}
+	switch strings.ToLower(value) {
+	case "nan", "none", "null", "true", "false", "yes", "other", "unknown":
+		return false
+	}
+	return true
+}
+
+// containsWord reports whether phrase occurs in text with no letter or digit directly
+// before or after it.
+func containsWord(text, phrase string) bool {
+	for offset := 0; offset < len(text); {
+		idx := strings.Index(text[offset:], phrase)
+		if idx < 0 {
+			return false
+		}
+		start := offset + idx
+		end := start + len(phrase)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if (start == 0 || !isWordRune(before)) && (end == len(text) || !isWordRune(after)) {
+			return true
+		}
+		offset = start + 1
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
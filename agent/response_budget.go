@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"regexp"
+	"strings"
+
+	"stats-agent/web/format"
+	"stats-agent/web/types"
+)
+
+// Turn types drive response budget negotiation: an analysis step is one short code cell,
+// a write-up interprets results in prose and needs room to finish.
+const (
+	TurnTypeCode    = "code"
+	TurnTypeSummary = "summary"
+)
+
+// writeUpRequestRegex matches user requests that ask for prose rather than a new analysis.
+var writeUpRequestRegex = regexp.MustCompile(`(?i)\b(summari[sz]e|summary|interpret|explain|report|write[- ]?up|conclu(de|sion)|what does (this|it) mean)\b`)
+
+// descriptiveTests are action signatures whose results usually prompt another analysis step
+// rather than a write-up.
+var descriptiveTests = map[string]bool{
+	"":              true,
+	"describe":      true,
+	"corr_matrix":   true,
+	"missing_check": true,
+	"value_counts":  true,
+	"median_group":  true,
+	"acf_pacf":      true,
+	"decompose":     true,
+	"roc_curve":     true,
+}
+
+// classifyTurn predicts whether the next response is an analysis step or a write-up.
+// A failed cell always needs a code fix; a successful inferential test is typically
+// followed by its interpretation; before any execution the user's request decides.
+func (a *Agent) classifyTurn(input string, history []types.AgentMessage) string {
+	last := len(history) - 1
+	if last >= 0 && history[last].Role == "tool" {
+		if a.executionCoordinator.DetectError(history[last].Content) {
+			return TurnTypeCode
+		}
+		if last > 0 && history[last-1].Role == "assistant" {
+			if code, ok := format.ExtractCodeContent(history[last-1].Content); ok {
+				if sig := ExtractActionSignature(code, "", 0, ""); sig != nil && !descriptiveTests[sig.Test] {
+					return TurnTypeSummary
+				}
+			}
+		}
+		return TurnTypeCode
+	}
+	if writeUpRequestRegex.MatchString(strings.TrimSpace(input)) {
+		return TurnTypeSummary
+	}
+	return TurnTypeCode
+}
+
+// NegotiatedResponseBudget returns the response budget for a turn type: the session's
+// budget scaled by the configured ratio when RESPONSE_BUDGET_NEGOTIATION is on, else the
+// session's budget unchanged.
+func (r *ResponseHandler) NegotiatedResponseBudget(sessionID, turnType string) int {
+	budget := r.ResponseTokenBudget(sessionID)
+	if !r.cfg.ResponseBudgetNegotiation {
+		return budget
+	}
+	ratio := r.cfg.ResponseBudgetCodeRatio
+	if turnType == TurnTypeSummary {
+		ratio = r.cfg.ResponseBudgetSummaryRatio
+	}
+	return max(int(float64(budget)*ratio), 1)
+}
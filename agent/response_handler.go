@@ -1,9 +1,12 @@
 package agent
 
 import (
+	"context"
 	"stats-agent/config"
+	"stats-agent/prompts"
 	"stats-agent/web/types"
 	"strings"
+	"sync"
 
 	"go.uber.org/zap"
 )
@@ -12,14 +15,90 @@ import (
 type ResponseHandler struct {
 	cfg    *config.Config
 	logger *zap.Logger
+
+	verbosityMu      sync.RWMutex
+	sessionVerbosity map[string]string
+
+	overrunMu        sync.RWMutex
+	overrunCallbacks map[string]OverrunCallback
 }
 
 // NewResponseHandler creates a new response handler instance.
 func NewResponseHandler(cfg *config.Config, logger *zap.Logger) *ResponseHandler {
 	return &ResponseHandler{
-		cfg:    cfg,
-		logger: logger,
+		cfg:              cfg,
+		logger:           logger,
+		sessionVerbosity: make(map[string]string),
+		overrunCallbacks: make(map[string]OverrunCallback),
+	}
+}
+
+// SetVerbosity records the verbosity level for a session. Invalid or standard
+// levels clear the override.
+func (r *ResponseHandler) SetVerbosity(sessionID, verbosity string) {
+	if sessionID == "" {
+		return
+	}
+	r.verbosityMu.Lock()
+	defer r.verbosityMu.Unlock()
+	if !types.IsValidVerbosity(verbosity) || verbosity == types.VerbosityStandard {
+		delete(r.sessionVerbosity, sessionID)
+		return
+	}
+	r.sessionVerbosity[sessionID] = verbosity
+}
+
+// Verbosity returns the session's verbosity level (standard when unset).
+func (r *ResponseHandler) Verbosity(sessionID string) string {
+	r.verbosityMu.RLock()
+	verbosity, ok := r.sessionVerbosity[sessionID]
+	r.verbosityMu.RUnlock()
+	if !ok {
+		return types.VerbosityStandard
+	}
+	return verbosity
+}
+
+// ClearVerbosity removes any verbosity override for the session.
+func (r *ResponseHandler) ClearVerbosity(sessionID string) {
+	r.verbosityMu.Lock()
+	delete(r.sessionVerbosity, sessionID)
+	r.verbosityMu.Unlock()
+}
+
+// ResponseTokenBudget returns the tokens reserved for the LLM response, scaled
+// by the session's verbosity: terse halves it, teaching grows it by half.
+func (r *ResponseHandler) ResponseTokenBudget(sessionID string) int {
+	budget := r.cfg.ResponseTokenBudget
+	switch r.Verbosity(sessionID) {
+	case types.VerbosityTerse:
+		budget = budget / 2
+	case types.VerbosityTeaching:
+		budget = budget * 3 / 2
 	}
+	return budget
+}
+
+// VerbosityInstruction returns the prompt instruction for the session's verbosity,
+// or "" for standard.
+func (r *ResponseHandler) VerbosityInstruction(sessionID string) string {
+	switch r.Verbosity(sessionID) {
+	case types.VerbosityTerse:
+		return prompts.VerbosityTerse()
+	case types.VerbosityTeaching:
+		return prompts.VerbosityTeaching()
+	}
+	return ""
+}
+
+// ApplyVerbosity prepends the session's verbosity instruction as a system message.
+// Call it after context budgeting so rebuilt message lists keep the instruction.
+func (r *ResponseHandler) ApplyVerbosity(sessionID string, messages []types.AgentMessage) []types.AgentMessage {
+	instruction := r.VerbosityInstruction(sessionID)
+	if instruction == "" {
+		return messages
+	}
+	return append([]types.AgentMessage{{Role: "system", Content: instruction}}, messages...)
 }
 
 // BuildMessagesForLLM combines retrieved state with current history for LLM input.
@@ -56,21 +135,72 @@ func (r *ResponseHandler) BuildMessagesForLLMWithEvidence(state, evidence string
 
 // CollectStreamedResponse reads chunks from a streaming response channel and builds
 // the complete response. It also prints chunks to stdout for real-time display.
-func (r *ResponseHandler) CollectStreamedResponse(responseChan <-chan string, stream *Stream) string {
+// In terse sessions, narration preceding a code block is withheld from the stream
+// (it is still returned for history); responses without code are streamed in full.
+// With a limit and a RESPONSE_OVERRUN_ACTION other than none, the response's estimated
+// tokens are watched as it streams; past the budget the generation is cancelled and the
+// action finishes the response.
+func (r *ResponseHandler) CollectStreamedResponse(ctx context.Context, responseChan <-chan string, stream *Stream, sessionID string, limit *ResponseLimit) string {
 	var llmResponseBuilder strings.Builder
 	chunkCount := 0
+	hideReasoning := r.Verbosity(sessionID) == types.VerbosityTerse
+	fenceSeen := false
+	watchBudget := limit != nil && limit.Budget > 0 && r.cfg.ResponseOverrunAction != "" && r.cfg.ResponseOverrunAction != OverrunNone
+	var tracker overrunTracker
+	overran := false
 
 	for chunk := range responseChan {
 		chunkCount++
+		llmResponseBuilder.WriteString(chunk)
+		if watchBudget && tracker.exceeded(chunk, llmResponseBuilder.String(), limit.Budget) {
+			overran = true
+		}
 
 		if stream != nil {
-			_, _ = stream.WriteString(chunk)
+			if !hideReasoning {
+				_, _ = stream.WriteString(chunk)
+			} else if !fenceSeen {
+				// Hold back output until the code fence appears, then stream from the fence on
+				if idx := strings.Index(llmResponseBuilder.String(), "```python"); idx >= 0 {
+					_, _ = stream.WriteString(llmResponseBuilder.String()[idx:])
+					fenceSeen = true
+				}
+			} else {
+				_, _ = stream.WriteString(chunk)
+			}
+		}
+		if overran {
+			break
 		}
-		llmResponseBuilder.WriteString(chunk)
 	}
 
 	llmResponse := llmResponseBuilder.String()
 
+	var overrunTail string
+	if overran {
+		if limit.Cancel != nil {
+			limit.Cancel()
+		}
+		// Drain so the client goroutine can exit once the cancelled request unwinds
+		go func() {
+			for range responseChan {
+			}
+		}()
+		llmResponse, overrunTail = r.finishOverrun(ctx, ResponseOverrun{
+			SessionID: sessionID,
+			Limit:     limit,
+			Partial:   llmResponse,
+			Tokens:    tracker.tokens(),
+		}, stream)
+	}
+
+	// No code block in a terse session: this is the final answer, so show it
+	if stream != nil && hideReasoning && !fenceSeen {
+		_, _ = stream.WriteString(llmResponse)
+	} else if stream != nil && overrunTail != "" {
+		_, _ = stream.WriteString(overrunTail)
+	}
+
 	// Check if response was stopped mid-code-block (missing closing fence)
 	// This happens when stop sequence "\n```\n" triggers
 	if strings.Contains(llmResponse, "```python") && !strings.HasSuffix(strings.TrimSpace(llmResponse), "```") {
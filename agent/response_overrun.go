@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"stats-agent/llmclient"
+	"stats-agent/prompts"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// Response overrun actions (RESPONSE_OVERRUN_ACTION), taken when a streamed response runs
+// past its token budget.
+const (
+	OverrunNone      = "none"
+	OverrunCutoff    = "cutoff"
+	OverrunSummarize = "summarize"
+	OverrunContinue  = "continue"
+)
+
+// overrunFenceGrace lets an open code block run to this multiple of the budget so it can
+// close; a block still open at that point is dropped rather than executed half-written.
+const overrunFenceGrace = 2
+
+// ResponseLimit bounds a streamed response. Cancel stops the generation once the response
+// passes Budget tokens; Host, Messages and Temperature describe the request, for
+// callbacks that ask the model again.
+type ResponseLimit struct {
+	Budget      int
+	Cancel      context.CancelFunc
+	Host        string
+	Messages    []types.AgentMessage
+	Temperature *float64
+}
+
+// ResponseOverrun is a streamed response stopped at its budget.
+type ResponseOverrun struct {
+	SessionID string
+	Limit     *ResponseLimit
+	Partial   string
+	Tokens    int
+}
+
+// OverrunCallback finishes an overrunning response for a summarize or continue action. It
+// returns the text for the action: the condensed response, or the continuation.
+type OverrunCallback func(ctx context.Context, overrun ResponseOverrun) (string, error)
+
+// SetOverrunCallback registers the callback run for a RESPONSE_OVERRUN_ACTION.
+func (r *ResponseHandler) SetOverrunCallback(action string, callback OverrunCallback) {
+	r.overrunMu.Lock()
+	defer r.overrunMu.Unlock()
+	r.overrunCallbacks[action] = callback
+}
+
+func (r *ResponseHandler) overrunCallback(action string) OverrunCallback {
+	r.overrunMu.RLock()
+	defer r.overrunMu.RUnlock()
+	return r.overrunCallbacks[action]
+}
+
+// overrunTracker estimates a streamed response's tokens (about four characters each)
+// without re-reading the whole response on every chunk.
+type overrunTracker struct {
+	runes int
+}
+
+// exceeded adds chunk and reports whether response has passed budget. Past the budget an
+// open code block is allowed to finish, up to overrunFenceGrace times the budget.
+func (t *overrunTracker) exceeded(chunk, response string, budget int) bool {
+	t.runes += utf8.RuneCountInString(chunk)
+	tokens := t.tokens()
+	if budget <= 0 || tokens <= budget {
+		return false
+	}
+	return !hasOpenCodeFence(response) || tokens > budget*overrunFenceGrace
+}
+
+func (t *overrunTracker) tokens() int {
+	return (t.runes + 3) / 4
+}
+
+// hasOpenCodeFence reports whether response has a ```python block without its closing fence.
+func hasOpenCodeFence(response string) bool {
+	openCount := strings.Count(response, "```python")
+	return openCount > strings.Count(response, "```")-openCount
+}
+
+// finishOverrun applies RESPONSE_OVERRUN_ACTION to a response stopped at its budget. It
+// returns the final response and the text to stream after what was already streamed.
+// A code block still open is dropped whatever the action, so it never runs half-written;
+// a failed callback falls back to the cutoff.
+func (r *ResponseHandler) finishOverrun(ctx context.Context, overrun ResponseOverrun, stream *Stream) (string, string) {
+	action := r.cfg.ResponseOverrunAction
+	partial := overrun.Partial
+	fields := []zap.Field{
+		zap.String("session_id", overrun.SessionID),
+		zap.String("action", action),
+		zap.Int("budget", overrun.Limit.Budget),
+		zap.Int("estimated_tokens", overrun.Tokens),
+	}
+
+	if hasOpenCodeFence(partial) {
+		r.logger.Warn("Response overran its budget inside a code block; dropping the block", fields...)
+		if stream != nil {
+			_ = stream.Status("Response stopped at its length budget before the code block finished; the code was not run")
+		}
+		return strings.TrimRight(partial[:strings.LastIndex(partial, "```python")], " \n"), "\n```\n"
+	}
+
+	callback := r.overrunCallback(action)
+	if action == OverrunCutoff || callback == nil {
+		r.logger.Info("Response cut off at its budget", fields...)
+		if stream != nil {
+			_ = stream.Status("Response cut off at its length budget")
+		}
+		return partial, ""
+	}
+
+	text, err := callback(ctx, overrun)
+	if err != nil || strings.TrimSpace(text) == "" {
+		r.logger.Warn("Response overrun callback failed; cutting off", append(fields, zap.Error(err))...)
+		if stream != nil {
+			_ = stream.Status("Response cut off at its length budget")
+		}
+		return partial, ""
+	}
+	r.logger.Info("Finished overrunning response", fields...)
+
+	if action == OverrunSummarize {
+		condensed := strings.TrimSpace(text)
+		return condensed, "\n\n---\n\n" + condensed
+	}
+	return partial + text, text
+}
+
+// condenseOverrun is the summarize callback: the model rewrites its stopped response to
+// fit the budget, with max_tokens set to it.
+func (a *Agent) condenseOverrun(ctx context.Context, overrun ResponseOverrun) (string, error) {
+	limit := overrun.Limit
+	messages := []types.AgentMessage{
+		{Role: "system", Content: prompts.CondenseResponse()},
+		{Role: "assistant", Content: overrun.Partial},
+		{Role: "user", Content: fmt.Sprintf("Rewrite your response in at most %d tokens.", limit.Budget)},
+	}
+	return a.llm.Chat(llmclient.WithMaxTokens(ctx, limit.Budget), limit.Host, messages, limit.Temperature)
+}
+
+// continueOverrun is the continue callback: the original request is sent again with the
+// stopped response, asking the model to finish it. Each follow-up call is capped at the
+// budget; up to RESPONSE_OVERRUN_MAX_CONTINUATIONS calls are made while a call uses its
+// whole budget.
+func (a *Agent) continueOverrun(ctx context.Context, overrun ResponseOverrun) (string, error) {
+	limit := overrun.Limit
+	var continuation strings.Builder
+	for i := 0; i < max(a.cfg.ResponseOverrunMaxContinuations, 1); i++ {
+		messages := append(append([]types.AgentMessage{}, limit.Messages...),
+			types.AgentMessage{Role: "assistant", Content: overrun.Partial + continuation.String()},
+			types.AgentMessage{Role: "system", Content: prompts.ContinueResponse()},
+		)
+		text, err := a.llm.Chat(llmclient.WithMaxTokens(ctx, limit.Budget), limit.Host, messages, limit.Temperature)
+		if err != nil {
+			if continuation.Len() > 0 {
+				break
+			}
+			return "", err
+		}
+		continuation.WriteString(text)
+		if utf8.RuneCountInString(text)/4 < limit.Budget {
+			break
+		}
+	}
+	return continuation.String(), nil
+}
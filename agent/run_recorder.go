@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// RunRecorder persists per-turn snapshots of dataset-mode runs for offline replay.
+type RunRecorder interface {
+	SaveRunTurn(ctx context.Context, turn types.RunTurn) error
+}
+
+// SetRunRecorder enables run recording. A nil recorder disables it.
+func (a *Agent) SetRunRecorder(recorder RunRecorder) {
+	a.runRecorder = recorder
+}
+
+// SystemPromptVersion identifies the current analysis system prompt, so replays can tell
+// whether a recorded turn ran under a different prompt.
+func SystemPromptVersion() string {
+	sum := sha256.Sum256([]byte(buildSystemPrompt()))
+	return hex.EncodeToString(sum[:6])
+}
+
+// recordTurn stores a turn snapshot. Recording is best-effort and never fails the run.
+func (a *Agent) recordTurn(ctx context.Context, turn types.RunTurn) {
+	if a.runRecorder == nil {
+		return
+	}
+	turn.PromptVersion = SystemPromptVersion()
+	turn.Messages = append([]types.AgentMessage(nil), turn.Messages...)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := a.runRecorder.SaveRunTurn(ctx, turn); err != nil {
+		a.logger.Warn("Failed to record run turn",
+			zap.Error(err),
+			zap.String("session_id", turn.SessionID),
+			zap.Int("turn", turn.Turn))
+	}
+}
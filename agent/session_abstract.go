@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"stats-agent/prompts"
+	"stats-agent/web/types"
+)
+
+const (
+	// maxAbstractSentences cuts replies that ignore the prompt's length limit
+	maxAbstractSentences = 3
+	// maxAbstractChars bounds what the sidebar and the title prompt receive
+	maxAbstractChars = 500
+)
+
+// GenerateSessionAbstract folds a completed run into the session's rolling abstract: 2-3
+// sentences on what the session studies and what it found so far. previous is the current
+// abstract, "" after the first run. Returns "" when abstracts are disabled or the reply
+// was empty.
+func (a *Agent) GenerateSessionAbstract(ctx context.Context, sessionID, previous, userQuestion, answer string) (string, error) {
+	if !a.cfg.SessionAbstractEnabled {
+		return "", nil
+	}
+
+	var b strings.Builder
+	if p := strings.TrimSpace(previous); p != "" {
+		fmt.Fprintf(&b, "Previous abstract: %s\n", p)
+	}
+	if tags := a.ResultTags(sessionID); len(tags) > 0 {
+		fmt.Fprintf(&b, "Tags: %s\n", strings.Join(tags, ", "))
+	}
+	if ledger := a.actionCache.BuildDoneLedger(sessionID); ledger != "" {
+		fmt.Fprintf(&b, "Completed analyses: %s\n", ledger)
+	}
+	if q := strings.TrimSpace(userQuestion); q != "" {
+		fmt.Fprintf(&b, "User's last question: %s\n", truncateString(q, 500))
+	}
+	fmt.Fprintf(&b, "\nLatest answer:\n%s\n", truncateString(strings.TrimSpace(answer), 2000))
+	b.WriteString("\nRespond with only the updated abstract.")
+
+	messages := []types.AgentMessage{
+		{Role: "system", Content: prompts.SessionAbstract()},
+		{Role: "user", Content: b.String()},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.cfg.LLMRequestTimeout)
+	defer cancel()
+	reply, err := a.llm.Chat(ctx, a.cfg.SummarizationLLMHost, messages, nil)
+	if err != nil {
+		return "", fmt.Errorf("llm chat call failed for session abstract: %w", err)
+	}
+	return cleanAbstract(reply), nil
+}
+
+// cleanAbstract flattens the reply to one paragraph without a label or surrounding quotes
+// and keeps at most maxAbstractSentences sentences and maxAbstractChars characters.
+func cleanAbstract(reply string) string {
+	text := strings.Join(strings.Fields(reply), " ")
+	if label, rest, ok := strings.Cut(text, ":"); ok && strings.EqualFold(strings.TrimSpace(label), "abstract") {
+		text = strings.TrimSpace(rest)
+	}
+	text = stripSurroundingQuotes(text)
+
+	sentences := 0
+	for i, r := range text {
+		if r != '.' && r != '!' && r != '?' {
+			continue
+		}
+		// A sentence ends at terminal punctuation followed by a space and a capital letter,
+		// so decimals ("p = 0.03") and abbreviations ("e.g. the") do not count
+		rest := text[i+1:]
+		if rest == "" || (rest[0] == ' ' && len(rest) > 1 && unicode.IsUpper(rune(rest[1]))) {
+			sentences++
+			if sentences == maxAbstractSentences {
+				text = text[:i+1]
+				break
+			}
+		}
+	}
+
+	if len(text) > maxAbstractChars {
+		cut := strings.LastIndex(text[:maxAbstractChars], " ")
+		if cut <= 0 {
+			cut = maxAbstractChars
+		}
+		text = strings.TrimRight(text[:cut], ",;:") + "…"
+	}
+	return text
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"stats-agent/prompts"
+	"stats-agent/web/types"
+)
+
+// maxVariableTags caps how many variable tags a session gets; the most tested variables win.
+const maxVariableTags = 4
+
+// resultTestTags maps inferential tests in the action cache to their session tag.
+// Descriptive steps (describe, value_counts, missing checks, plots) are not results and
+// produce no tags.
+var resultTestTags = map[string]string{
+	"chi2":         "chi-square",
+	"fisher":       "fisher exact",
+	"mannwhitneyu": "mann-whitney u",
+	"wilcoxon":     "wilcoxon",
+	"ttest_ind":    "t-test",
+	"ttest_rel":    "paired t-test",
+	"ttest":        "t-test",
+	"shapiro":      "shapiro-wilk",
+	"ks_test":      "kolmogorov-smirnov",
+	"levene":       "levene",
+	"bartlett":     "bartlett",
+	"pearsonr":     "pearson",
+	"spearmanr":    "spearman",
+	"kendalltau":   "kendall",
+	"anova":        "anova",
+	"kruskal":      "kruskal-wallis",
+	"friedman":     "friedman",
+	"linregress":   "linear regression",
+	"logistic":     "logistic regression",
+	"roc_auc":      "roc auc",
+	"arima":        "arima",
+	"prophet":      "prophet",
+	"adf":          "adf",
+	"kpss":         "kpss",
+	"ljungbox":     "ljung-box",
+}
+
+// ResultTags derives session tags from the session's successful tests: the tests used,
+// the datasets they ran on, and the variables tested most often. It returns nil until
+// at least one result has been recorded.
+func (a *Agent) ResultTags(sessionID string) []string {
+	var tests, datasets []string
+	seen := make(map[string]bool)
+	varCounts := make(map[string]int)
+
+	a.actionCache.ensureLoaded(sessionID)
+	a.actionCache.mu.Lock()
+	defer a.actionCache.mu.Unlock()
+	for _, result := range a.actionCache.completed {
+		if result == nil || !result.Success || result.Signature.SessionID != sessionID {
+			continue
+		}
+		tag, ok := resultTestTags[result.Signature.Test]
+		if !ok {
+			continue
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tests = append(tests, tag)
+		}
+		if ds := strings.TrimSpace(result.Signature.Dataset); ds != "" && !seen[ds] {
+			seen[ds] = true
+			datasets = append(datasets, ds)
+		}
+		for _, v := range result.Signature.Variables {
+			varCounts[v]++
+		}
+	}
+	if len(tests) == 0 {
+		return nil
+	}
+
+	sort.Strings(tests)
+	sort.Strings(datasets)
+
+	variables := make([]string, 0, len(varCounts))
+	for v := range varCounts {
+		if !seen[v] {
+			variables = append(variables, v)
+		}
+	}
+	sort.Slice(variables, func(i, j int) bool {
+		if varCounts[variables[i]] != varCounts[variables[j]] {
+			return varCounts[variables[i]] > varCounts[variables[j]]
+		}
+		return variables[i] < variables[j]
+	})
+	if len(variables) > maxVariableTags {
+		variables = variables[:maxVariableTags]
+	}
+
+	tags := append(tests, datasets...)
+	return append(tags, variables...)
+}
+
+// GenerateResultsTitle writes a descriptive session title from the recorded results,
+// e.g. "Treatment effect on score (t-test)". Tags give the model the tests, datasets
+// and variables; the done ledger adds what was actually run.
+func (a *Agent) GenerateResultsTitle(ctx context.Context, sessionID string, tags []string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Tags: %s\n", strings.Join(tags, ", "))
+	if ledger := a.actionCache.BuildDoneLedger(sessionID); ledger != "" {
+		fmt.Fprintf(&b, "Completed analyses: %s\n", ledger)
+	}
+	b.WriteString("\nRespond with only the title.")
+
+	messages := []types.AgentMessage{
+		{Role: "system", Content: prompts.ResultsTitle()},
+		{Role: "user", Content: b.String()},
+	}
+
+	title, err := a.llm.Chat(ctx, a.cfg.SummarizationLLMHost, messages, nil)
+	if err != nil {
+		return "", fmt.Errorf("llm chat call failed for results title generation: %w", err)
+	}
+	return sanitizeTitle(strings.TrimSpace(title)), nil
+}
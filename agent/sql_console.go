@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// QueryWorkspaceSQL runs a read-only SQL console query over the session workspace's
+// CSV and Parquet files, returning at most SQL_CONSOLE_MAX_ROWS rows.
+func (a *Agent) QueryWorkspaceSQL(ctx context.Context, sessionID, query string) (*types.SQLResult, error) {
+	result, err := a.pythonTool.QueryWorkspaceSQL(ctx, sessionID, query, a.cfg.SQLConsoleMaxRows)
+	if err != nil {
+		return nil, err
+	}
+	a.logger.Info("Ran SQL console query",
+		zap.String("session_id", sessionID),
+		zap.Int("rows", len(result.Rows)),
+		zap.Bool("truncated", result.Truncated))
+	return result, nil
+}
+
+// FormatSQLResult renders a console result as a Markdown table of at most maxRows rows,
+// for injecting it into the conversation.
+func FormatSQLResult(result *types.SQLResult, maxRows int) string {
+	if len(result.Columns) == 0 {
+		return "Query returned no result set."
+	}
+	escape := func(s string) string {
+		return strings.ReplaceAll(strings.ReplaceAll(s, "|", "\\|"), "\n", " ")
+	}
+
+	var b strings.Builder
+	cells := make([]string, len(result.Columns))
+	for i, c := range result.Columns {
+		cells[i] = escape(c)
+	}
+	fmt.Fprintf(&b, "| %s |\n|%s\n", strings.Join(cells, " | "), strings.Repeat(" --- |", len(cells)))
+
+	rows := result.Rows
+	if maxRows > 0 && len(rows) > maxRows {
+		rows = rows[:maxRows]
+	}
+	for _, row := range rows {
+		for i, v := range row {
+			cells[i] = escape(v)
+		}
+		fmt.Fprintf(&b, "| %s |\n", strings.Join(cells[:len(row)], " | "))
+	}
+
+	switch {
+	case len(rows) < len(result.Rows):
+		fmt.Fprintf(&b, "\n(%d of %d returned rows shown", len(rows), len(result.Rows))
+		if result.Truncated {
+			b.WriteString("; the query returned more")
+		}
+		b.WriteString(")")
+	case result.Truncated:
+		fmt.Fprintf(&b, "\n(first %d rows; the query returned more)", len(rows))
+	default:
+		fmt.Fprintf(&b, "\n(%d rows)", len(rows))
+	}
+	return b.String()
+}
@@ -0,0 +1,24 @@
+package agent
+
+import (
+	"stats-agent/prompts"
+	"stats-agent/web/types"
+)
+
+// sqlInstruction returns the <sql> block instruction when SQL_TOOL_ENABLED is set, or "".
+func (a *Agent) sqlInstruction() string {
+	if !a.cfg.SQLToolEnabled {
+		return ""
+	}
+	return prompts.SQLTool()
+}
+
+// applySQLInstruction prepends the SQL instruction as a system message. Like
+// applyPlotInstruction, call it after context budgeting.
+func (a *Agent) applySQLInstruction(messages []types.AgentMessage) []types.AgentMessage {
+	instruction := a.sqlInstruction()
+	if instruction == "" {
+		return messages
+	}
+	return append([]types.AgentMessage{{Role: "system", Content: instruction}}, messages...)
+}
@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// ReplayStep is one executed step to re-run when jumping back to a bookmark.
+type ReplayStep struct {
+	// Number is the step's position in the session, for reporting
+	Number int
+	Code   string
+}
+
+// ReplayResult reports a jump back to a bookmarked step.
+type ReplayResult struct {
+	Replayed int
+	// Failed lists the numbers of steps that raised an error when replayed
+	Failed []int
+}
+
+// ReplaySteps restores the session's in-kernel state to a bookmarked step: the namespace
+// is cleared, the init code re-run, and steps executed in order with the pinned seed.
+// Steps that fail are reported and the replay continues, as the original run did. The
+// action cache is purged so the agent may take a different branch from that point.
+func (a *Agent) ReplaySteps(ctx context.Context, sessionID string, uploadedFiles []string, steps []ReplayStep) (*ReplayResult, error) {
+	if err := a.pythonTool.ResetNamespace(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	if _, err := a.pythonTool.InitializeSession(ctx, sessionID, uploadedFiles); err != nil {
+		return nil, fmt.Errorf("failed to re-initialize python session: %w", err)
+	}
+	if a.actionCache != nil {
+		a.actionCache.PurgeSession(sessionID)
+	}
+
+	result := &ReplayResult{}
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return result, fmt.Errorf("replay interrupted after %d steps: %w", result.Replayed, err)
+		}
+		output, err := a.pythonTool.ExecuteCell(ctx, step.Code, sessionID)
+		if err != nil {
+			return result, fmt.Errorf("failed to replay step %d: %w", step.Number, err)
+		}
+		result.Replayed++
+		if a.executionCoordinator.DetectError(output) {
+			a.logger.Warn("Replayed step raised an error",
+				zap.String("session_id", sessionID),
+				zap.Int("step", step.Number))
+			result.Failed = append(result.Failed, step.Number)
+		}
+	}
+
+	a.logger.Info("Replayed session to bookmark",
+		zap.String("session_id", sessionID),
+		zap.Int("replayed", result.Replayed),
+		zap.Int("failed", len(result.Failed)))
+	return result, nil
+}
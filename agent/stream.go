@@ -5,18 +5,25 @@ import (
 	"io"
 	"strings"
 	"sync"
+
+	"stats-agent/web/format"
 )
 
 // FlushHandler receives an assistant segment and an optional tool result.
 type FlushHandler func(assistant string, tool *string)
 
+// EventHandler receives a structured client event: its type and JSON payload.
+type EventHandler func(eventType, payload string)
+
 // Stream captures assistant output and tool results while forwarding data to the client in real time.
 type Stream struct {
 	mu           sync.Mutex
 	logWriter    io.Writer
 	streamWriter io.Writer
 	flush        FlushHandler
+	events       EventHandler
 	segment      strings.Builder
+	failure      string
 }
 
 // NewStream constructs a stream that duplicates assistant output to logWriter and streamWriter,
@@ -60,8 +67,29 @@ func (s *Stream) Status(message string) error {
 	return err
 }
 
+// SetEventHandler routes Event calls to handler (typically the SSE writer).
+func (s *Stream) SetEventHandler(handler EventHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = handler
+}
+
+// Event sends a structured event to the client outside the assistant text. It reports
+// false when no event handler is set, so callers can fall back to a status message.
+func (s *Stream) Event(eventType, payload string) bool {
+	s.mu.Lock()
+	handler := s.events
+	s.mu.Unlock()
+	if handler == nil {
+		return false
+	}
+	handler(eventType, payload)
+	return true
+}
+
 // Tool finalizes the current assistant segment, emits it via the flush handler alongside the tool result,
-// and streams the tool output to the client in markdown code fences.
+// and streams the tool output to the client in markdown code fences. A <warnings> block in the result
+// is streamed after the fence so the client can show it apart from the output.
 func (s *Stream) Tool(result string) error {
 	assistant := s.popSegment()
 	trimmed := strings.TrimSpace(result)
@@ -79,13 +107,34 @@ func (s *Stream) Tool(result string) error {
 		return nil
 	}
 
-	formatted := fmt.Sprintf("\n```\n%s\n```\n", trimmed)
+	output, warnings := format.SplitWarnings(trimmed)
+	formatted := fmt.Sprintf("\n```\n%s\n```\n", strings.TrimSpace(output))
+	if len(warnings) > 0 {
+		formatted += format.AppendWarnings("", warnings) + "\n"
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	_, err := s.streamWriter.Write([]byte(formatted))
 	return err
 }
 
+// Fail records that the run ended on an error it could not recover from. The first
+// reason is kept.
+func (s *Stream) Fail(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failure == "" {
+		s.failure = reason
+	}
+}
+
+// Failure returns why the run failed, "" when it ended normally.
+func (s *Stream) Failure() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failure
+}
+
 // Finalize flushes any remaining assistant output (without an accompanying tool message).
 func (s *Stream) Finalize() {
 	assistant := s.popSegment()
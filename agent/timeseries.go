@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Time-series action names produced by ExtractActionSignature.
+const (
+	tsTestADF       = "adf"
+	tsTestKPSS      = "kpss"
+	tsTestACF       = "acf_pacf"
+	tsTestDecompose = "decompose"
+	tsTestARIMA     = "arima"
+	tsTestProphet   = "prophet"
+	tsTestLjungBox  = "ljungbox"
+)
+
+var (
+	tsAICRegex = regexp.MustCompile(`(?i)\bAIC\s*[=:]?\s*(-?\d+(?:\.\d+)?)`)
+	tsBICRegex = regexp.MustCompile(`(?i)\bBIC\s*[=:]?\s*(-?\d+(?:\.\d+)?)`)
+	tsPRegex   = regexp.MustCompile(`(?i)\bp(?:-?value)?\s*[=:]\s*(\d*\.?\d+(?:e-?\d+)?)`)
+	// Ljung-Box DataFrame output: "<lag>  <lb_stat>  <lb_pvalue>"
+	tsLjungBoxRowRegex = regexp.MustCompile(`(?m)^\s*\d+\s+\d+(?:\.\d+)?\s+(\d*\.?\d+(?:e-?\d+)?)\s*$`)
+)
+
+// isTimeSeriesTest reports whether an action signature test belongs to the time-series toolkit.
+func isTimeSeriesTest(test string) bool {
+	switch test {
+	case tsTestADF, tsTestKPSS, tsTestACF, tsTestDecompose, tsTestARIMA, tsTestProphet, tsTestLjungBox:
+		return true
+	}
+	return false
+}
+
+// extractModelDiagnostics pulls compact diagnostics (p-values, AIC/BIC) from time-series
+// tool output for the done ledger, e.g. "p=0.21" or "aic=812.4,bic=825.1".
+func extractModelDiagnostics(test, output string) string {
+	if !isTimeSeriesTest(test) || strings.HasPrefix(strings.TrimSpace(output), "Error:") {
+		return ""
+	}
+
+	var parts []string
+	switch test {
+	case tsTestADF, tsTestKPSS:
+		if m := tsPRegex.FindStringSubmatch(output); len(m) > 1 {
+			parts = append(parts, "p="+m[1])
+		}
+	case tsTestLjungBox:
+		if m := tsPRegex.FindStringSubmatch(output); len(m) > 1 {
+			parts = append(parts, "lb_p="+m[1])
+		} else if rows := tsLjungBoxRowRegex.FindAllStringSubmatch(output, -1); len(rows) > 0 {
+			parts = append(parts, "lb_p="+rows[len(rows)-1][1])
+		}
+	case tsTestARIMA, tsTestProphet:
+		if m := tsAICRegex.FindStringSubmatch(output); len(m) > 1 {
+			parts = append(parts, "aic="+m[1])
+		}
+		if m := tsBICRegex.FindStringSubmatch(output); len(m) > 1 {
+			parts = append(parts, "bic="+m[1])
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// recommendTimeSeriesStep applies simple workflow rules to suggest the next time-series step
+// after a successful action. Returns "" when no rule applies.
+func recommendTimeSeriesStep(test, output string) string {
+	pValue := func() (float64, bool) {
+		m := tsPRegex.FindStringSubmatch(output)
+		if len(m) < 2 {
+			return 0, false
+		}
+		p, err := strconv.ParseFloat(m[1], 64)
+		return p, err == nil
+	}
+
+	switch test {
+	case tsTestADF:
+		// ADF null hypothesis: unit root (non-stationary)
+		if p, ok := pValue(); ok {
+			if p >= 0.05 {
+				return fmt.Sprintf("ADF p=%g suggests a unit root. Difference the series (or log-transform if variance grows) and re-test before fitting ARIMA.", p)
+			}
+			return fmt.Sprintf("ADF p=%g suggests stationarity. Inspect ACF/PACF to choose AR/MA orders.", p)
+		}
+	case tsTestKPSS:
+		// KPSS null hypothesis: stationary
+		if p, ok := pValue(); ok {
+			if p < 0.05 {
+				return fmt.Sprintf("KPSS p=%g rejects stationarity. Difference or detrend the series before modeling.", p)
+			}
+			return fmt.Sprintf("KPSS p=%g is consistent with stationarity. Inspect ACF/PACF to choose AR/MA orders.", p)
+		}
+	case tsTestDecompose:
+		return "Decomposition done. Test the residual/deseasonalized series for stationarity (ADF and KPSS) before modeling."
+	case tsTestACF:
+		return "Use the ACF/PACF cut-offs to choose ARIMA(p,d,q) orders (or seasonal orders if spikes repeat at the seasonal lag), then fit the model."
+	case tsTestARIMA, tsTestProphet:
+		return "Model fitted. Check residual diagnostics (Ljung-Box on residuals, residual ACF) before interpreting forecasts."
+	case tsTestLjungBox:
+		if p, ok := pValue(); ok && p < 0.05 {
+			return fmt.Sprintf("Ljung-Box p=%g indicates residual autocorrelation. Revise the model orders before forecasting.", p)
+		}
+		return "Residuals look like white noise. Report the model with AIC/BIC and produce the forecast with intervals."
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"stats-agent/config"
+	"stats-agent/llmclient"
+	"stats-agent/prompts"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// Tools offered to the model when TOOL_CALL_MODE is tools or grammar.
+const (
+	toolRunPython = "run_python"
+	toolRunSQL    = "run_sql"
+)
+
+// ToolSpecs returns the functions the model can call: run_python, plus run_sql when the
+// SQL tool is enabled.
+func (e *ExecutionCoordinator) ToolSpecs() []llmclient.ToolSpec {
+	specs := []llmclient.ToolSpec{{
+		Name:        toolRunPython,
+		Description: "Run Python code in the session's persistent namespace and return its printed output.",
+		Parameters:  stringArgumentSchema("code", "The Python code to run, one analysis step."),
+	}}
+	if e.sqlTool != nil {
+		specs = append(specs, llmclient.ToolSpec{
+			Name:        toolRunSQL,
+			Description: "Run one read-only DuckDB query over the uploaded datasets and return the result.",
+			Parameters:  stringArgumentSchema("query", "A single SELECT, WITH, DESCRIBE, SHOW or SUMMARIZE statement."),
+		})
+	}
+	return specs
+}
+
+func stringArgumentSchema(name, description string) map[string]any {
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			name: map[string]any{"type": "string", "description": description},
+		},
+		"required": []string{name},
+	}
+}
+
+// ProcessToolCall executes a structured tool call, dispatching on its name. Unknown tools
+// and malformed arguments come back as an error result, so the model corrects the call
+// like a failed cell.
+func (e *ExecutionCoordinator) ProcessToolCall(ctx context.Context, call llmclient.ToolCall, sessionID string, stream *Stream) (*ExecutionResult, error) {
+	switch call.Name {
+	case toolRunPython:
+		code, err := stringArgument(call, "code")
+		if err != nil {
+			return e.toolCallError(call, err, sessionID, stream), nil
+		}
+		return e.pythonResult(code, e.pythonTool.RunCode(ctx, code, sessionID), sessionID, stream), nil
+	case toolRunSQL:
+		if e.sqlTool == nil {
+			return e.toolCallError(call, fmt.Errorf("the SQL tool is not enabled"), sessionID, stream), nil
+		}
+		query, err := stringArgument(call, "query")
+		if err != nil {
+			return e.toolCallError(call, err, sessionID, stream), nil
+		}
+		return e.sqlResult(query, e.sqlTool.ExecuteQuery(ctx, query, sessionID), sessionID, stream), nil
+	}
+	return e.toolCallError(call, fmt.Errorf("unknown tool %q", call.Name), sessionID, stream), nil
+}
+
+func (e *ExecutionCoordinator) toolCallError(call llmclient.ToolCall, err error, sessionID string, stream *Stream) *ExecutionResult {
+	e.logger.Warn("Rejected structured tool call",
+		zap.String("session_id", sessionID),
+		zap.String("tool", call.Name),
+		zap.Error(err))
+	result := "Error: " + err.Error()
+	if stream != nil {
+		if err := stream.Tool(result); err != nil {
+			e.logger.Warn("Failed to stream tool result",
+				zap.String("session_id", sessionID),
+				zap.Error(err))
+		}
+	}
+	return &ExecutionResult{
+		WasCodeExecuted: true,
+		Code:            string(call.Arguments),
+		Result:          result,
+		HasError:        true,
+	}
+}
+
+// stringArgument returns a required, non-empty string argument of the call.
+func stringArgument(call llmclient.ToolCall, name string) (string, error) {
+	var args map[string]any
+	if err := json.Unmarshal(call.Arguments, &args); err != nil {
+		return "", fmt.Errorf("%s arguments are not a JSON object: %v", call.Name, err)
+	}
+	value, _ := args[name].(string)
+	if strings.TrimSpace(value) == "" {
+		return "", fmt.Errorf("%s needs a non-empty %q string argument", call.Name, name)
+	}
+	return strings.TrimSpace(value), nil
+}
+
+// toolCallText renders a call as the block text mode would have produced, so history,
+// the action cache, replays and the UI handle both modes alike. Calls to unknown tools
+// render as nothing.
+func toolCallText(call llmclient.ToolCall) string {
+	switch call.Name {
+	case toolRunPython:
+		if code, err := stringArgument(call, "code"); err == nil {
+			return "```python\n" + code + "\n```"
+		}
+	case toolRunSQL:
+		if query, err := stringArgument(call, "query"); err == nil {
+			return "<sql>\n" + query + "\n</sql>"
+		}
+	}
+	return ""
+}
+
+// structuredToolCalls reports whether TOOL_CALL_MODE asks the model for JSON calls.
+func (a *Agent) structuredToolCalls() bool {
+	return a.cfg.ToolCallMode == config.ToolCallModeTools || a.cfg.ToolCallMode == config.ToolCallModeGrammar
+}
+
+// toolCallInstruction returns the function calling instruction in structured modes, or "".
+func (a *Agent) toolCallInstruction() string {
+	if !a.structuredToolCalls() {
+		return ""
+	}
+	return prompts.ToolCalls()
+}
+
+// applyToolCallInstruction prepends the function calling instruction as a system message.
+// Like applyPlotInstruction, call it after context budgeting.
+func (a *Agent) applyToolCallInstruction(messages []types.AgentMessage) []types.AgentMessage {
+	instruction := a.toolCallInstruction()
+	if instruction == "" {
+		return messages
+	}
+	return append([]types.AgentMessage{{Role: "system", Content: instruction}}, messages...)
+}
+
+// takeToolCall picks the turn's call (one per turn, like code blocks) and appends its text
+// form to the response and the stream. Without a call the response is left as is, and
+// ProcessResponse still finds blocks a model wrote in text.
+func (a *Agent) takeToolCall(sessionID string, calls []llmclient.ToolCall, response string, stream *Stream) (*llmclient.ToolCall, string) {
+	if len(calls) == 0 {
+		return nil, response
+	}
+	if len(calls) > 1 {
+		a.logger.Info("Model made several tool calls; running the first",
+			zap.String("session_id", sessionID),
+			zap.Int("calls", len(calls)))
+	}
+	call := calls[0]
+	if block := toolCallText(call); block != "" {
+		if strings.TrimSpace(response) != "" {
+			response = strings.TrimRight(response, "\n") + "\n\n"
+		}
+		response += block
+		if stream != nil {
+			_, _ = stream.WriteString(block + "\n")
+		}
+	}
+	return &call, response
+}
@@ -0,0 +1,29 @@
+package agent
+
+import "stats-agent/web/types"
+
+// UsageRecorder receives the aggregate counts of each finished dataset-mode run for
+// opt-in usage telemetry.
+type UsageRecorder interface {
+	RecordRun(usage types.RunUsage)
+}
+
+// SetUsageRecorder enables usage reporting. A nil recorder disables it.
+func (a *Agent) SetUsageRecorder(recorder UsageRecorder) {
+	a.usageRecorder = recorder
+}
+
+// countExecution adds an executed cell to the run's usage. Only the test type of the action
+// signature is kept, so nothing from the code or data leaves the run.
+func countExecution(usage *types.RunUsage, sig *ActionSignature, hasError bool) {
+	usage.Executions++
+	if hasError {
+		usage.ExecutionErrors++
+	}
+	if sig != nil && sig.Test != "" {
+		if usage.Tests == nil {
+			usage.Tests = make(map[string]int)
+		}
+		usage.Tests[sig.Test]++
+	}
+}
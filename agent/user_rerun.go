@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"stats-agent/rag"
+	"stats-agent/web/format"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// userEditedTurn marks action cache entries produced by user re-runs rather than an agent turn.
+const userEditedTurn = -1
+
+// UserEditedCodePrefix starts every user message recording an edited re-run.
+const UserEditedCodePrefix = "I edited the code and re-ran it:"
+
+// RunUserEditedCode executes code the user edited from an assistant python block.
+// The edited action is recorded in the action cache, and the original version is
+// marked as superseded so the agent does not redo it. The code/result pair is stored
+// in RAG as a user message plus tool message.
+func (a *Agent) RunUserEditedCode(ctx context.Context, sessionID, code, originalCode string, history []types.AgentMessage) (*ExecutionResult, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, fmt.Errorf("no code to execute")
+	}
+
+	a.logger.Info("Executing user-edited code", zap.String("session_id", sessionID))
+	return a.runUserCode(ctx, sessionID, code, originalCode, UserEditedCodeMessage(code), history)
+}
+
+// runUserCode executes code the user ran outside an agent turn and records it like an
+// agent action: in the action cache (marking originalCode, if any, as superseded) and in
+// RAG as userContent plus the tool message.
+func (a *Agent) runUserCode(ctx context.Context, sessionID, code, originalCode, userContent string, history []types.AgentMessage) (*ExecutionResult, error) {
+	diffTargets := a.snapshotDistributions(ctx, sessionID, code)
+	result, err := a.pythonTool.ExecuteCell(ctx, code, sessionID)
+	if err != nil {
+		a.logger.Error("Error executing user-edited code", zap.Error(err), zap.String("session_id", sessionID))
+		result = "Error: " + err.Error()
+	}
+	result, warnings := format.SplitWarnings(result)
+	execResult := &ExecutionResult{
+		WasCodeExecuted: true,
+		Code:            code,
+		Result:          result,
+		HasError:        a.executionCoordinator.DetectError(result),
+		Warnings:        warnings,
+	}
+
+	if !execResult.HasError {
+		a.recordTransformations(sessionID, code, result, true)
+		a.recordDistributionDiffs(ctx, sessionID, diffTargets)
+	}
+
+	dataset := getCurrentDataset(history)
+	n := getCurrentSampleSize(history)
+	schemaHash := getCurrentSchemaHash(history)
+
+	original := strings.TrimSpace(originalCode)
+	originalHash := ""
+	if original != "" && a.normalizeCodeHash(original) != a.normalizeCodeHash(code) {
+		originalHash = a.normalizeCodeHash(original)
+	}
+
+	editedSig := ExtractActionSignature(code, dataset, n, schemaHash)
+	if editedSig != nil {
+		editedSig.SessionID = sessionID
+		a.actionCache.Add(*editedSig, ActionResult{
+			Signature:          *editedSig,
+			Output:             result,
+			Success:            !execResult.HasError,
+			Turn:               userEditedTurn,
+			Attempt:            1,
+			CodeNormHash:       a.normalizeCodeHash(code),
+			Diagnostics:        extractModelDiagnostics(editedSig.Test, result),
+			UserEdited:         true,
+			SupersededCodeHash: originalHash,
+		})
+	}
+
+	// The original version is superseded by the user's edit: when it maps to a different
+	// action, record it as done too so the repeat guard skips it if the agent proposes it again.
+	if originalHash != "" {
+		if sig := ExtractActionSignature(original, dataset, n, schemaHash); sig != nil {
+			sig.SessionID = sessionID
+			_, exists := a.actionCache.Get(*sig)
+			if !exists && (editedSig == nil || sig.ComputeHash() != editedSig.ComputeHash()) {
+				a.actionCache.Add(*sig, ActionResult{
+					Signature:    *sig,
+					Output:       "Superseded by a user-edited version",
+					Success:      true,
+					Turn:         userEditedTurn,
+					Attempt:      1,
+					CodeNormHash: originalHash,
+					UserEdited:   true,
+				})
+			}
+		}
+	}
+
+	if a.rag != nil {
+		toolContent := execResult.ToolContent()
+		a.rag.AddMessagesAsync(sessionID, []types.AgentMessage{
+			{Role: "user", Content: userContent, ContentHash: rag.ComputeMessageContentHash("user", userContent)},
+			{Role: "tool", Content: toolContent, ContentHash: rag.ComputeMessageContentHash("tool", toolContent)},
+		})
+	}
+
+	return execResult, nil
+}
+
+// UserEditedCodeMessage formats the user message that records an edited re-run.
+func UserEditedCodeMessage(code string) string {
+	return UserEditedCodePrefix + "\n```python\n" + strings.TrimSpace(code) + "\n```"
+}
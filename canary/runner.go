@@ -0,0 +1,198 @@
+package canary
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"stats-agent/agent"
+	"stats-agent/database"
+	"stats-agent/rag"
+	"stats-agent/tools"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// caseTimeout bounds one canary case, including session setup and teardown.
+const caseTimeout = 10 * time.Minute
+
+// CheckResult is the outcome of one check.
+type CheckResult struct {
+	Name   string
+	Passed bool
+}
+
+// CaseResult is the outcome of one canary case.
+type CaseResult struct {
+	Name     string
+	Score    float64
+	Passed   bool
+	Checks   []CheckResult
+	Duration time.Duration
+	Err      error
+}
+
+// Report collects the results of a canary run.
+type Report struct {
+	Results []CaseResult
+}
+
+// Passed reports whether every case met its minimum score.
+func (r Report) Passed() bool {
+	for _, res := range r.Results {
+		if !res.Passed {
+			return false
+		}
+	}
+	return len(r.Results) > 0
+}
+
+// Print writes a human-readable summary of the report.
+func (r Report) Print(w io.Writer) {
+	passed := 0
+	for _, res := range r.Results {
+		status := "PASS"
+		if !res.Passed {
+			status = "FAIL"
+		} else {
+			passed++
+		}
+		fmt.Fprintf(w, "%s  %-18s score %.2f  (%s)\n", status, res.Name, res.Score, res.Duration.Round(time.Second))
+		if res.Err != nil {
+			fmt.Fprintf(w, "      error: %v\n", res.Err)
+		}
+		for _, c := range res.Checks {
+			if !c.Passed {
+				fmt.Fprintf(w, "      missing: %s\n", c.Name)
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d/%d canary cases passed\n", passed, len(r.Results))
+}
+
+// Runner runs canary cases end to end against the configured LLM hosts and Python executor.
+// Each case gets a throwaway dataset session that is deleted afterwards.
+type Runner struct {
+	agent  *agent.Agent
+	store  database.Store
+	logger *zap.Logger
+}
+
+// NewRunner creates a canary runner.
+func NewRunner(statsAgent *agent.Agent, store database.Store, logger *zap.Logger) *Runner {
+	return &Runner{agent: statsAgent, store: store, logger: logger}
+}
+
+// Run executes the named cases, or the whole suite when names is empty.
+func (r *Runner) Run(ctx context.Context, names []string) Report {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var report Report
+	for _, c := range Cases() {
+		if len(wanted) > 0 && !wanted[c.Name] {
+			continue
+		}
+		r.logger.Info("Running canary case", zap.String("case", c.Name))
+		res := r.runCase(ctx, c)
+		r.logger.Info("Canary case finished",
+			zap.String("case", c.Name),
+			zap.Float64("score", res.Score),
+			zap.Bool("passed", res.Passed),
+			zap.Duration("duration", res.Duration))
+		report.Results = append(report.Results, res)
+	}
+	return report
+}
+
+func (r *Runner) runCase(ctx context.Context, c Case) CaseResult {
+	start := time.Now()
+	res := CaseResult{Name: c.Name}
+
+	ctx, cancel := context.WithTimeout(ctx, caseTimeout)
+	defer cancel()
+
+	transcript, err := r.transcript(ctx, c.Prompt)
+	res.Duration = time.Since(start)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	numbers := transcriptNumbers(transcript)
+	passed := 0
+	for _, check := range c.Checks {
+		ok := check.passes(transcript, numbers)
+		if ok {
+			passed++
+		}
+		res.Checks = append(res.Checks, CheckResult{Name: check.Name, Passed: ok})
+	}
+	if len(c.Checks) > 0 {
+		res.Score = float64(passed) / float64(len(c.Checks))
+	}
+	res.Passed = res.Score >= c.MinScore
+	return res
+}
+
+// transcript runs the prompt in a fresh session with the bundled dataset and returns
+// everything the agent streamed: assistant text, code, and tool output.
+func (r *Runner) transcript(ctx context.Context, prompt string) (string, error) {
+	sessionID, err := r.store.CreateSessionWithMode(ctx, nil, types.ModeDataset)
+	if err != nil {
+		return "", err
+	}
+	sid := sessionID.String()
+	workspaceDir := filepath.Join("workspaces", sid)
+	defer r.cleanup(sessionID, workspaceDir)
+
+	if err := os.MkdirAll(workspaceDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create workspace: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(workspaceDir, DatasetFilename), canaryTrial, 0644); err != nil {
+		return "", fmt.Errorf("failed to write canary dataset: %w", err)
+	}
+	if err := tools.ProtectWorkspaceFiles(workspaceDir, DatasetFilename); err != nil {
+		return "", err
+	}
+
+	initResult, err := r.agent.InitializeSession(ctx, sid, []string{DatasetFilename})
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize python session: %w", err)
+	}
+	history := []types.AgentMessage{
+		{Role: "tool", Content: initResult, ContentHash: rag.ComputeMessageContentHash("tool", initResult)},
+	}
+
+	var out bytes.Buffer
+	stream := agent.NewStream(nil, &out, nil)
+	r.agent.RunDatasetMode(ctx, prompt, sid, history, stream)
+	stream.Finalize()
+
+	if err := ctx.Err(); err != nil {
+		return out.String(), fmt.Errorf("canary run did not finish: %w", err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// cleanup removes the canary session's Python state, RAG documents, database row, and workspace.
+func (r *Runner) cleanup(sessionID uuid.UUID, workspaceDir string) {
+	r.agent.CleanupSession(sessionID.String())
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := r.store.DeleteSession(ctx, sessionID); err != nil {
+		r.logger.Warn("Failed to delete canary session", zap.String("session_id", sessionID.String()), zap.Error(err))
+	}
+	if err := os.RemoveAll(workspaceDir); err != nil {
+		r.logger.Warn("Failed to remove canary workspace", zap.String("path", workspaceDir), zap.Error(err))
+	}
+}
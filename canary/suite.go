@@ -0,0 +1,129 @@
+package canary
+
+import (
+	_ "embed"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// DatasetFilename is the bundled dataset every canary case analyzes.
+const DatasetFilename = "canary_trial.csv"
+
+// canaryTrial is a synthetic two-arm trial: 80 patients, group (treatment/control), age,
+// sex, score, and improved (yes/no). The expected values below are computed from it.
+//
+//go:embed data/canary_trial.csv
+var canaryTrial []byte
+
+// Check is one required element of a canary response: a number that must be reported
+// (any of Values, within Tolerance) or a step that must appear (Pattern).
+type Check struct {
+	Name      string
+	Values    []float64
+	Tolerance float64
+	// Relative makes Tolerance a fraction of the expected value (for p-values)
+	Relative bool
+	Pattern  *regexp.Regexp
+}
+
+// Case is a canonical end-to-end prompt and the checks its response is scored on.
+type Case struct {
+	Name   string
+	Prompt string
+	Checks []Check
+	// MinScore is the fraction of checks that must pass
+	MinScore float64
+}
+
+func number(name string, tolerance float64, values ...float64) Check {
+	return Check{Name: name, Values: values, Tolerance: tolerance}
+}
+
+func pValue(name string, values ...float64) Check {
+	return Check{Name: name, Values: values, Tolerance: 0.05, Relative: true}
+}
+
+func step(name, pattern string) Check {
+	return Check{Name: name, Pattern: regexp.MustCompile(pattern)}
+}
+
+// Cases returns the canary suite. Alternative values cover equivalent methods the model
+// may reasonably pick (Student vs Welch t-test, chi-square with or without Yates' correction).
+func Cases() []Case {
+	return []Case{
+		{
+			Name:   "t-test",
+			Prompt: "Load canary_trial.csv and test whether score differs between the treatment and control groups with an independent samples t-test. Report the group means, the t statistic, and the p-value.",
+			Checks: []Check{
+				step("loads the dataset", `read_csv\(\s*['"][^'"]*canary_trial\.csv`),
+				step("runs a t-test", `(?i)ttest_ind|t-test|ttest`),
+				number("treatment mean score", 0.01, 57.79),
+				number("control mean score", 0.01, 48.70),
+				number("t statistic", 0.02, 3.98, -3.98),
+				pValue("p-value", 0.000154, 0.0002),
+			},
+			MinScore: 0.8,
+		},
+		{
+			Name:   "chi-square",
+			Prompt: "Using canary_trial.csv, run a chi-square test of independence between group and improved. Show the contingency table, the chi-square statistic, and the p-value.",
+			Checks: []Check{
+				step("loads the dataset", `read_csv\(\s*['"][^'"]*canary_trial\.csv`),
+				step("builds a contingency table", `(?i)crosstab|contingency|pivot_table`),
+				step("runs a chi-square test", `(?i)chi2_contingency|chi-square|chi2`),
+				number("treatment improved count", 0, 22),
+				number("control improved count", 0, 16),
+				number("chi-square statistic", 0.01, 1.80, 1.25),
+				pValue("p-value", 0.179, 0.263),
+			},
+			MinScore: 0.8,
+		},
+		{
+			Name:   "describe-columns",
+			Prompt: "Describe the columns of canary_trial.csv: give the number of rows, and the mean and standard deviation of age and score.",
+			Checks: []Check{
+				step("loads the dataset", `read_csv\(\s*['"][^'"]*canary_trial\.csv`),
+				step("summarizes columns", `(?i)\.describe\(|\.info\(|\.mean\(`),
+				number("row count", 0, 80),
+				number("mean age", 0.01, 44.91),
+				number("age standard deviation", 0.01, 14.64),
+				number("mean score", 0.01, 53.24),
+				number("score standard deviation", 0.01, 11.14),
+			},
+			MinScore: 0.8,
+		},
+	}
+}
+
+var numberRegex = regexp.MustCompile(`-?\d+(?:\.\d+)?(?:[eE][-+]?\d+)?`)
+
+// transcriptNumbers returns every number in a response transcript.
+func transcriptNumbers(transcript string) []float64 {
+	var out []float64
+	for _, m := range numberRegex.FindAllString(transcript, -1) {
+		if v, err := strconv.ParseFloat(m, 64); err == nil {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// passes reports whether the transcript satisfies the check.
+func (c Check) passes(transcript string, numbers []float64) bool {
+	if c.Pattern != nil {
+		return c.Pattern.MatchString(transcript)
+	}
+	for _, want := range c.Values {
+		tolerance := c.Tolerance
+		if c.Relative {
+			tolerance = math.Abs(want) * c.Tolerance
+		}
+		for _, got := range numbers {
+			if math.Abs(got-want) <= tolerance+1e-9 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+// Package chaos injects controlled failures into the LLM client, the Python executor and
+// the store so resilience tests can exercise the agent's recovery paths (self-correction
+// after failed executions, retrieval falling back when embeddings fail, retries after LLM
+// timeouts). It is enabled only by CHAOS_ENABLED and must never run in production.
+package chaos
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"stats-agent/config"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Fault is a kind of injected failure.
+type Fault string
+
+const (
+	FaultLLMTimeout         Fault = "llm_timeout"
+	FaultEmbeddingError     Fault = "embedding_error"
+	FaultExecutorDisconnect Fault = "executor_disconnect"
+	FaultDBLatency          Fault = "db_latency"
+)
+
+// FaultStats counts the calls that could have failed and the failures injected.
+type FaultStats struct {
+	Fault    Fault   `json:"fault"`
+	Rate     float64 `json:"rate"`
+	Calls    int64   `json:"calls"`
+	Injected int64   `json:"injected"`
+}
+
+// Injector decides which calls fail. A nil *Injector injects nothing, and the Wrap
+// functions return their argument unchanged for it.
+type Injector struct {
+	logger  *zap.Logger
+	latency time.Duration
+	rates   map[Fault]float64
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	stats map[Fault]*FaultStats
+}
+
+// New returns an injector configured from the CHAOS_* settings, or nil when CHAOS_ENABLED
+// is off. CHAOS_SEED makes the failure sequence reproducible across test runs.
+func New(cfg *config.Config, logger *zap.Logger) *Injector {
+	if cfg == nil || !cfg.ChaosEnabled {
+		return nil
+	}
+	seed := cfg.ChaosSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	inj := &Injector{
+		logger:  logger,
+		latency: cfg.ChaosDBLatency,
+		rates: map[Fault]float64{
+			FaultLLMTimeout:         cfg.ChaosLLMTimeoutRate,
+			FaultEmbeddingError:     cfg.ChaosEmbeddingErrorRate,
+			FaultExecutorDisconnect: cfg.ChaosExecutorDisconnectRate,
+			FaultDBLatency:          cfg.ChaosDBLatencyRate,
+		},
+		rng:   rand.New(rand.NewSource(seed)),
+		stats: make(map[Fault]*FaultStats),
+	}
+	for fault, rate := range inj.rates {
+		inj.stats[fault] = &FaultStats{Fault: fault, Rate: rate}
+	}
+	logger.Warn("Failure injection is ENABLED; do not run this configuration in production",
+		zap.Int64("seed", seed),
+		zap.Float64("llm_timeout_rate", cfg.ChaosLLMTimeoutRate),
+		zap.Float64("embedding_error_rate", cfg.ChaosEmbeddingErrorRate),
+		zap.Float64("executor_disconnect_rate", cfg.ChaosExecutorDisconnectRate),
+		zap.Float64("db_latency_rate", cfg.ChaosDBLatencyRate),
+		zap.Duration("db_latency", cfg.ChaosDBLatency))
+	return inj
+}
+
+// inject counts a call and reports whether it should fail.
+func (i *Injector) inject(fault Fault) bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	s := i.stats[fault]
+	s.Calls++
+	if s.Rate <= 0 || i.rng.Float64() >= s.Rate {
+		return false
+	}
+	s.Injected++
+	i.logger.Debug("Injecting failure", zap.String("fault", string(fault)))
+	return true
+}
+
+// Stats returns the per-fault counters, sorted by fault.
+func (i *Injector) Stats() []FaultStats {
+	if i == nil {
+		return nil
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	stats := make([]FaultStats, 0, len(i.stats))
+	for _, s := range i.stats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(a, b int) bool { return stats[a].Fault < stats[b].Fault })
+	return stats
+}
+
+// Handler serves the counters as JSON so resilience tests can assert how many failures
+// were injected alongside what the agent did about them.
+func (i *Injector) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"faults": i.Stats()})
+	}
+}
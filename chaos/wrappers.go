@@ -0,0 +1,171 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"stats-agent/database"
+	"stats-agent/llmclient"
+	"stats-agent/tools"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// errEmbeddingFailure mimics the embedding server failing a request.
+var errEmbeddingFailure = errors.New("chaos: injected embedding failure: server returned status 500")
+
+// WrapLLM fails chat and rerank calls with a timeout and embedding calls with a server
+// error at the configured rates. Tokenize calls pass through.
+func WrapLLM(llm llmclient.LLM, inj *Injector) llmclient.LLM {
+	if inj == nil {
+		return llm
+	}
+	return &chaosLLM{LLM: llm, inj: inj}
+}
+
+type chaosLLM struct {
+	llmclient.LLM
+	inj *Injector
+}
+
+func (c *chaosLLM) Chat(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (string, error) {
+	if c.inj.inject(FaultLLMTimeout) {
+		return "", fmt.Errorf("chaos: injected LLM timeout: %w", context.DeadlineExceeded)
+	}
+	return c.LLM.Chat(ctx, host, messages, temperature)
+}
+
+func (c *chaosLLM) ChatStream(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (<-chan string, error) {
+	if c.inj.inject(FaultLLMTimeout) {
+		return nil, fmt.Errorf("chaos: injected LLM timeout: %w", context.DeadlineExceeded)
+	}
+	return c.LLM.ChatStream(ctx, host, messages, temperature)
+}
+
+func (c *chaosLLM) Embed(ctx context.Context, host string, doc string) ([]float32, error) {
+	if c.inj.inject(FaultEmbeddingError) {
+		return nil, errEmbeddingFailure
+	}
+	return c.LLM.Embed(ctx, host, doc)
+}
+
+func (c *chaosLLM) EmbedBatch(ctx context.Context, host string, docs []string) ([][]float32, error) {
+	if c.inj.inject(FaultEmbeddingError) {
+		return nil, errEmbeddingFailure
+	}
+	return c.LLM.EmbedBatch(ctx, host, docs)
+}
+
+func (c *chaosLLM) Rerank(ctx context.Context, host, model, query string, documents []string) ([]float64, error) {
+	reranker, ok := c.LLM.(llmclient.Reranker)
+	if !ok {
+		return nil, errors.New("chaos: wrapped LLM does not rerank")
+	}
+	if c.inj.inject(FaultLLMTimeout) {
+		return nil, fmt.Errorf("chaos: injected rerank timeout: %w", context.DeadlineExceeded)
+	}
+	return reranker.Rerank(ctx, host, model, query, documents)
+}
+
+// WrapExecutor fails Python executor calls as if the connection dropped mid-call.
+func WrapExecutor(executor tools.Executor, inj *Injector) tools.Executor {
+	if inj == nil {
+		return executor
+	}
+	return &chaosExecutor{Executor: executor, inj: inj}
+}
+
+type chaosExecutor struct {
+	tools.Executor
+	inj *Injector
+}
+
+func (c *chaosExecutor) Call(ctx context.Context, input string, sessionID string) (string, error) {
+	if c.inj.inject(FaultExecutorDisconnect) {
+		return "", fmt.Errorf("chaos: injected executor disconnect: %w", io.ErrUnexpectedEOF)
+	}
+	return c.Executor.Call(ctx, input, sessionID)
+}
+
+// WrapStore delays the store's hot-path calls (message history, RAG writes and searches)
+// by CHAOS_DB_LATENCY_MS at the configured rate. Other calls pass through.
+func WrapStore(store database.Store, inj *Injector) database.Store {
+	if inj == nil {
+		return store
+	}
+	return &chaosStore{Store: store, inj: inj}
+}
+
+type chaosStore struct {
+	database.Store
+	inj *Injector
+}
+
+// delay sleeps for the configured latency when a fault is injected, returning early with
+// the context's error if it is cancelled meanwhile.
+func (s *chaosStore) delay(ctx context.Context) error {
+	if !s.inj.inject(FaultDBLatency) || s.inj.latency <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(s.inj.latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *chaosStore) CreateMessage(ctx context.Context, msg types.ChatMessage) error {
+	if err := s.delay(ctx); err != nil {
+		return err
+	}
+	return s.Store.CreateMessage(ctx, msg)
+}
+
+func (s *chaosStore) GetMessagesBySession(ctx context.Context, sessionID uuid.UUID) ([]types.ChatMessage, error) {
+	if err := s.delay(ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.GetMessagesBySession(ctx, sessionID)
+}
+
+func (s *chaosStore) UpsertDocument(ctx context.Context, documentID uuid.UUID, content string, metadata map[string]string, contentHash string) (uuid.UUID, error) {
+	if err := s.delay(ctx); err != nil {
+		return uuid.Nil, err
+	}
+	return s.Store.UpsertDocument(ctx, documentID, content, metadata, contentHash)
+}
+
+func (s *chaosStore) CreateEmbedding(ctx context.Context, documentID uuid.UUID, windowIndex, windowStart, windowEnd int, windowText string, embedding []float32) error {
+	if err := s.delay(ctx); err != nil {
+		return err
+	}
+	return s.Store.CreateEmbedding(ctx, documentID, windowIndex, windowStart, windowEnd, windowText, embedding)
+}
+
+func (s *chaosStore) GetDocumentsBatch(ctx context.Context, ids []uuid.UUID) (map[string]string, error) {
+	if err := s.delay(ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.GetDocumentsBatch(ctx, ids)
+}
+
+func (s *chaosStore) SearchRAGDocumentsBM25Language(ctx context.Context, query string, limit int, sessionID string, excludeHashes []string, language string, dataset string) ([]database.BM25SearchResult, error) {
+	if err := s.delay(ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.SearchRAGDocumentsBM25Language(ctx, query, limit, sessionID, excludeHashes, language, dataset)
+}
+
+func (s *chaosStore) VectorSearchRAGDocumentsForModel(ctx context.Context, queryVector []float32, limit int, sessionID string, excludeHashes []string, embeddingModel string, dataset string) ([]database.VectorSearchResult, error) {
+	if err := s.delay(ctx); err != nil {
+		return nil, err
+	}
+	return s.Store.VectorSearchRAGDocumentsForModel(ctx, queryVector, limit, sessionID, excludeHashes, embeddingModel, dataset)
+}
@@ -0,0 +1,123 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"stats-agent/config"
+	"stats-agent/llmclient"
+
+	"go.uber.org/zap"
+)
+
+// stubExecutor records the calls that reached the executor.
+type stubExecutor struct {
+	calls int
+}
+
+func (s *stubExecutor) Call(_ context.Context, input string, _ string) (string, error) {
+	s.calls++
+	return "ran: " + input, nil
+}
+
+func (s *stubExecutor) CleanupSession(string) {}
+
+func (s *stubExecutor) Close() {}
+
+// stubEmbedder answers embedding calls; the other LLM methods are not used.
+type stubEmbedder struct {
+	llmclient.LLM
+	calls int
+}
+
+func (s *stubEmbedder) Embed(context.Context, string, string) ([]float32, error) {
+	s.calls++
+	return []float32{1}, nil
+}
+
+func (s *stubEmbedder) EmbedBatch(_ context.Context, _ string, docs []string) ([][]float32, error) {
+	s.calls++
+	return make([][]float32, len(docs)), nil
+}
+
+func newTestInjector(t *testing.T, cfg config.Config) *Injector {
+	t.Helper()
+	cfg.ChaosEnabled = true
+	cfg.ChaosSeed = 1
+	inj := New(&cfg, zap.NewNop())
+	if inj == nil {
+		t.Fatal("New returned nil with CHAOS_ENABLED set")
+	}
+	return inj
+}
+
+func faultStats(inj *Injector, fault Fault) FaultStats {
+	for _, s := range inj.Stats() {
+		if s.Fault == fault {
+			return s
+		}
+	}
+	return FaultStats{}
+}
+
+func TestNewDisabled(t *testing.T) {
+	if inj := New(&config.Config{ChaosExecutorDisconnectRate: 1}, zap.NewNop()); inj != nil {
+		t.Fatal("New returned an injector with CHAOS_ENABLED off")
+	}
+	executor := &stubExecutor{}
+	if got := WrapExecutor(executor, nil); got != executor {
+		t.Fatal("WrapExecutor wrapped the executor for a nil injector")
+	}
+}
+
+// An injected disconnect must surface as an error the caller can recognize, without the
+// code reaching the executor, so StatefulPythonTool reports it and the agent can retry.
+func TestExecutorDisconnectSurfacesAsError(t *testing.T) {
+	inj := newTestInjector(t, config.Config{ChaosExecutorDisconnectRate: 1})
+	executor := &stubExecutor{}
+	wrapped := WrapExecutor(executor, inj)
+
+	for range 3 {
+		output, err := wrapped.Call(context.Background(), "print(1)", "session")
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("Call error = %v, want io.ErrUnexpectedEOF", err)
+		}
+		if output != "" {
+			t.Fatalf("Call output = %q, want none", output)
+		}
+	}
+	if executor.calls != 0 {
+		t.Fatalf("executor got %d calls, want 0", executor.calls)
+	}
+	if s := faultStats(inj, FaultExecutorDisconnect); s.Calls != 3 || s.Injected != 3 {
+		t.Fatalf("stats = %+v, want 3 calls and 3 injected", s)
+	}
+}
+
+// Embedding failures are returned as errors, which is what sends retrieval to its
+// metadata fallback; calls of other faults pass through untouched.
+func TestEmbeddingErrorLeavesOtherCallsAlone(t *testing.T) {
+	inj := newTestInjector(t, config.Config{ChaosEmbeddingErrorRate: 1})
+	embedder := &stubEmbedder{}
+	llm := WrapLLM(embedder, inj)
+
+	if _, err := llm.EmbedBatch(context.Background(), "host", []string{"a", "b"}); !errors.Is(err, errEmbeddingFailure) {
+		t.Fatalf("EmbedBatch error = %v, want the injected embedding failure", err)
+	}
+	if _, err := llm.Embed(context.Background(), "host", "a"); !errors.Is(err, errEmbeddingFailure) {
+		t.Fatalf("Embed error = %v, want the injected embedding failure", err)
+	}
+	if embedder.calls != 0 {
+		t.Fatalf("embedder got %d calls, want 0", embedder.calls)
+	}
+
+	executor := &stubExecutor{}
+	if _, err := WrapExecutor(executor, inj).Call(context.Background(), "print(1)", "session"); err != nil {
+		t.Fatalf("executor call failed with its rate at 0: %v", err)
+	}
+	if executor.calls != 1 {
+		t.Fatalf("executor got %d calls, want 1", executor.calls)
+	}
+}
@@ -24,6 +24,7 @@ const (
     defaultEmbeddingTokenTarget             = 400
     defaultMinTokenCheckCharThreshold       = 100
 	defaultMaxHybridCandidates              = 100
+	defaultRAGIngestWorkers                 = 4
 	defaultHybridSemanticWeight             = 0.7
 	defaultHybridBM25Weight                 = 0.3
 	defaultHybridStateBoost                 = 1.4
@@ -35,6 +36,10 @@ const (
 	defaultHybridDocumentFactBoost          = 1.0
 	defaultHybridDocumentSummaryBoost       = 1.5
 	defaultHybridDocumentDocumentBoost      = 1.6
+	defaultHybridPDFSummaryBoost            = 1.8
+	defaultHybridAnnotationBoost            = 1.4
+	defaultRetrievalCandidateMultiplier     = 4
+	defaultRetrievalMinCandidates           = 20
 	defaultPDFTokenThreshold                = 0.75
 	defaultPDFFirstPagesPriority            = 3
 	defaultPDFEnableTableDetection          = true
@@ -42,16 +47,31 @@ const (
 	defaultPDFExtractorURL                  = "http://localhost:5001"
 	defaultPDFExtractorEnabled              = true
     defaultPDFExtractorTimeout              = 30 * time.Second
+    defaultPDFCacheDir                      = "cache/pdf"
+    defaultPDFCacheMaxMB                    = 256
     // PDF page cleanup defaults
     defaultPDFHeaderFooterRepeatThreshold   = 0.6
     defaultPDFReferencesTrimEnabled         = true
     defaultPDFReferencesCitationDensity     = 0.5
-    // Retrieval defaults
-    defaultRAGResults                      = 3
+    defaultPDFSummarySourceChars            = 12000
+    // PDF extraction quality
+    defaultPDFQualityThreshold              = 0.6
+    defaultPDFQualityRetryEnabled           = true
+    defaultPDFQualityMinWeight              = 0.5
+    // Retrieval budgets: memory entries per category (dataset mode / document mode)
+    defaultRAGDatasetFactBudget             = 3
+    defaultRAGDatasetStateBudget            = 1
+    defaultRAGDatasetDocumentBudget         = 1
+    defaultRAGDatasetUserBudget             = 1
+    defaultRAGDocumentFactBudget            = 1
+    defaultRAGDocumentStateBudget           = 1
+    defaultRAGDocumentDocumentBudget        = 5
+    defaultRAGDocumentUserBudget            = 1
     // Document mode defaults
     defaultDocumentModeEnabled              = true
-    defaultDocumentModeRAGResults           = 5
+    defaultDocumentMaxRetrievals            = 1
     defaultWebPort                          = 8080
+    defaultChatPageTurns                    = 20
     // LLM backoff defaults
     defaultRetryDelaySeconds                = 2 * time.Second
     defaultLLMBackoffMaxSeconds             = 30 * time.Second
@@ -63,22 +83,152 @@ const (
     defaultDocumentChunkOverlap             = 0.0
     // Completion headroom for assistant response
     defaultResponseTokenBudget              = 512
+    // Database maintenance defaults
+    defaultDBMaintenanceInterval            = 6 * time.Hour
+    defaultDBReindexGrowthRatio             = 0.2
+    // Fact consolidation defaults
+    defaultFactConsolidationInterval        = 30 * time.Minute
+    defaultFactConsolidationSimilarity      = 0.95
+    // SSE connection defaults
+    defaultSSEHeartbeatInterval             = 15 * time.Second
+    defaultSSEWriteTimeout                  = 10 * time.Second
 )
 
+// RetrievalBudget caps how many memory entries each retrieval category contributes to
+// the <memory> block, so one kind of content (e.g. PDF chunks) cannot crowd out the others.
+type RetrievalBudget struct {
+	Facts     int // conversation facts, summaries, assistant and tool messages
+	State     int // state cards
+	Documents int // PDF chunks and summaries
+	User      int // user messages
+}
+
+// Total returns the number of entries across all categories.
+func (b RetrievalBudget) Total() int {
+	return b.Facts + b.State + b.Documents + b.User
+}
+
+// Scale returns the budget with every category multiplied by factor.
+func (b RetrievalBudget) Scale(factor int) RetrievalBudget {
+	return RetrievalBudget{Facts: b.Facts * factor, State: b.State * factor, Documents: b.Documents * factor, User: b.User * factor}
+}
+
+// LLMModel is an additional LLM endpoint users can choose for a session. Cost and
+// Latency are free-text labels shown in the model selector.
+type LLMModel struct {
+	Name    string `mapstructure:"NAME"`
+	Host    string `mapstructure:"HOST"`
+	Cost    string `mapstructure:"COST"`
+	Latency string `mapstructure:"LATENCY"`
+}
+
+// RetrievalArm is an alternative hybrid scoring configuration in the retrieval experiment.
+// Zero weights inherit the baseline HYBRID_* values; an unset Rerank inherits RERANK_ENABLED.
+type RetrievalArm struct {
+	Name           string  `mapstructure:"NAME"`
+	Percent        int     `mapstructure:"PERCENT"`
+	SemanticWeight float64 `mapstructure:"SEMANTIC_WEIGHT"`
+	BM25Weight     float64 `mapstructure:"BM25_WEIGHT"`
+	FactBoost      float64 `mapstructure:"FACT_BOOST"`
+	SummaryBoost   float64 `mapstructure:"SUMMARY_BOOST"`
+	DocumentBoost  float64 `mapstructure:"DOCUMENT_BOOST"`
+	StateBoost     float64 `mapstructure:"STATE_BOOST"`
+	Rerank         *bool   `mapstructure:"RERANK"`
+}
+
+// Built-in retrieval policies. "dataset" and "document" are the defaults of the session
+// modes; "mixed" sits between them for sessions that read papers alongside a dataset.
+const (
+	RetrievalPolicyDataset  = "dataset"
+	RetrievalPolicyDocument = "document"
+	RetrievalPolicyMixed    = "mixed"
+)
+
+// RetrievalPolicy holds the hybrid scoring weights, thresholds and candidate limits a
+// RAG query runs with. Entries of RETRIEVAL_POLICIES are overrides: zero fields inherit
+// the built-in policy of the session's mode, and an entry named after a built-in policy
+// replaces its values for every session.
+type RetrievalPolicy struct {
+	Name                string  `mapstructure:"NAME"`
+	SemanticWeight      float64 `mapstructure:"SEMANTIC_WEIGHT"`
+	BM25Weight          float64 `mapstructure:"BM25_WEIGHT"`
+	FactBoost           float64 `mapstructure:"FACT_BOOST"`
+	SummaryBoost        float64 `mapstructure:"SUMMARY_BOOST"`
+	DocumentBoost       float64 `mapstructure:"DOCUMENT_BOOST"`
+	StateBoost          float64 `mapstructure:"STATE_BOOST"`
+	PDFSummaryBoost     float64 `mapstructure:"PDF_SUMMARY_BOOST"`
+	AnnotationBoost     float64 `mapstructure:"ANNOTATION_BOOST"`
+	ErrorPenalty        float64 `mapstructure:"ERROR_PENALTY"`
+	SemanticThreshold   float64 `mapstructure:"SEMANTIC_THRESHOLD"`
+	BM25Threshold       float64 `mapstructure:"BM25_THRESHOLD"`
+	CandidateMultiplier int     `mapstructure:"CANDIDATE_MULTIPLIER"` // candidates fetched per memory entry in the budget
+	MinCandidates       int     `mapstructure:"MIN_CANDIDATES"`
+	MaxCandidates       int     `mapstructure:"MAX_CANDIDATES"`
+}
+
 // Config holds the application's configuration
 type Config struct {
 	LogLevel                         string        `mapstructure:"LOG_LEVEL"`
 	WebPort                          int           `mapstructure:"WEB_PORT"`
+	ChatPageTurns                    int           `mapstructure:"CHAT_PAGE_TURNS"`
 	PythonExecutorAddress            string        `mapstructure:"PYTHON_EXECUTOR_ADDRESS"`
 	PythonExecutorAddresses          []string      `mapstructure:"PYTHON_EXECUTOR_ADDRESSES"`
 	PythonExecutorPool               []string      `mapstructure:"PYTHON_EXECUTOR_POOL"`
 	MainLLMHost                      string        `mapstructure:"MAIN_LLM_HOST"`
+	// Alternative endpoints selectable per session; the default is MAIN_LLM_HOST
+	LLMModels                        []LLMModel    `mapstructure:"LLM_MODELS"`
 	EmbeddingLLMHost                 string        `mapstructure:"EMBEDDING_LLM_HOST"`
+	MultilingualEmbeddingHost        string        `mapstructure:"MULTILINGUAL_EMBEDDING_HOST"`
 	SummarizationLLMHost             string        `mapstructure:"SUMMARIZATION_LLM_HOST"`
+	// Provider API per role: llamacpp (default), openai, anthropic or ollama
+	MainLLMProvider                  string        `mapstructure:"MAIN_LLM_PROVIDER"`
+	MainLLMModel                     string        `mapstructure:"MAIN_LLM_MODEL"`
+	MainLLMAPIKey                    string        `mapstructure:"MAIN_LLM_API_KEY"`
+	SummarizationLLMProvider         string        `mapstructure:"SUMMARIZATION_LLM_PROVIDER"`
+	SummarizationLLMModel            string        `mapstructure:"SUMMARIZATION_LLM_MODEL"`
+	SummarizationLLMAPIKey           string        `mapstructure:"SUMMARIZATION_LLM_API_KEY"`
+	EmbeddingLLMProvider             string        `mapstructure:"EMBEDDING_LLM_PROVIDER"`
+	EmbeddingLLMModel                string        `mapstructure:"EMBEDDING_LLM_MODEL"`
+	EmbeddingLLMAPIKey               string        `mapstructure:"EMBEDDING_LLM_API_KEY"`
+	// Backup hosts per role, tried in order while the role's host has an open circuit breaker
+	MainLLMBackupHosts               []string      `mapstructure:"MAIN_LLM_BACKUP_HOSTS"`
+	SummarizationLLMBackupHosts      []string      `mapstructure:"SUMMARIZATION_LLM_BACKUP_HOSTS"`
+	EmbeddingLLMBackupHosts          []string      `mapstructure:"EMBEDDING_LLM_BACKUP_HOSTS"`
+	// Circuit breaker per LLM host: opens after this many consecutive failures, half-opens after the cooldown (seconds)
+	LLMBreakerFailures               int           `mapstructure:"LLM_BREAKER_FAILURES"`
+	LLMBreakerCooldown               time.Duration `mapstructure:"LLM_BREAKER_COOLDOWN"`
+	// Seconds between health probes of llama.cpp and Ollama hosts; 0 disables probing
+	LLMHealthProbeInterval           time.Duration `mapstructure:"LLM_HEALTH_PROBE_INTERVAL"`
+	// Describe captured figures with the summarization LLM for alt text and search
+	FigureAltTextEnabled             bool          `mapstructure:"FIGURE_ALT_TEXT_ENABLED"`
+	// Ask the agent for Plotly figures, rendered as interactive charts with a PNG fallback
+	InteractivePlotsEnabled          bool          `mapstructure:"INTERACTIVE_PLOTS_ENABLED"`
+	// Suggest follow-up questions as clickable chips after each completed dataset run
+	FollowUpSuggestionsEnabled       bool          `mapstructure:"FOLLOWUP_SUGGESTIONS_ENABLED"`
+	// Keep a rolling 2-3 sentence abstract per session, updated after each completed run
+	SessionAbstractEnabled           bool          `mapstructure:"SESSION_ABSTRACT_ENABLED"`
 	MaxTurns                         int           `mapstructure:"MAX_TURNS"`
-	RAGResults                       int           `mapstructure:"RAG_RESULTS"`
+	// Per-run budgets; 0 disables. An exhausted budget ends the run with a summary turn
+	RunMaxDuration                   time.Duration `mapstructure:"RUN_MAX_DURATION"`
+	RunMaxLLMCalls                   int           `mapstructure:"RUN_MAX_LLM_CALLS"`
+	RunMaxExecutedCells              int           `mapstructure:"RUN_MAX_EXECUTED_CELLS"`
+	// Retrieval budgets per category, per mode
+	RAGDatasetFactBudget             int           `mapstructure:"RAG_DATASET_FACT_BUDGET"`
+	RAGDatasetStateBudget            int           `mapstructure:"RAG_DATASET_STATE_BUDGET"`
+	RAGDatasetDocumentBudget         int           `mapstructure:"RAG_DATASET_DOCUMENT_BUDGET"`
+	RAGDatasetUserBudget             int           `mapstructure:"RAG_DATASET_USER_BUDGET"`
+	RAGDocumentFactBudget            int           `mapstructure:"RAG_DOCUMENT_FACT_BUDGET"`
+	RAGDocumentStateBudget           int           `mapstructure:"RAG_DOCUMENT_STATE_BUDGET"`
+	RAGDocumentDocumentBudget        int           `mapstructure:"RAG_DOCUMENT_DOCUMENT_BUDGET"`
+	RAGDocumentUserBudget            int           `mapstructure:"RAG_DOCUMENT_USER_BUDGET"`
+	RAGExcludedRoles                 []string      `mapstructure:"RAG_EXCLUDED_ROLES"`
+	RAGExcludedPatterns              []string      `mapstructure:"RAG_EXCLUDED_PATTERNS"`
+	// Scope retrieval to the session's active dataset (queries can still ask across datasets)
+	RAGScopeToDataset                bool          `mapstructure:"RAG_SCOPE_TO_DATASET"`
 	ContextLength                    int           `mapstructure:"CONTEXT_LENGTH"`
 	ContextSoftLimitRatio            float64       `mapstructure:"CONTEXT_SOFT_LIMIT_RATIO"`
+	// Summarize history trimmed to fit the context window into the turn's state block
+	ContextSummarizeTrimmed          bool          `mapstructure:"CONTEXT_SUMMARIZE_TRIMMED"`
 	MaxRetries                       int           `mapstructure:"MAX_RETRIES"`
     RetryDelaySeconds                time.Duration `mapstructure:"RETRY_DELAY_SECONDS"`
     LLMBackoffMaxSeconds             time.Duration `mapstructure:"LLM_BACKOFF_MAX_SECONDS"`
@@ -102,15 +252,57 @@ type Config struct {
 	PythonExecutorDialTimeoutSeconds time.Duration `mapstructure:"PYTHON_EXECUTOR_DIAL_TIMEOUT_SECONDS"`
 	PythonExecutorIOTimeoutSeconds   time.Duration `mapstructure:"PYTHON_EXECUTOR_IO_TIMEOUT_SECONDS"`
 	PythonExecutorMaxConnections     int           `mapstructure:"PYTHON_EXECUTOR_MAX_CONNECTIONS"`
+	// Executor transport: "tcp" (executor services) or "local" (subprocess per session)
+	PythonExecutorMode               string        `mapstructure:"PYTHON_EXECUTOR_MODE"`
+	PythonExecutorLocalPython        string        `mapstructure:"PYTHON_EXECUTOR_LOCAL_PYTHON"`
+	PythonExecutorLocalScript        string        `mapstructure:"PYTHON_EXECUTOR_LOCAL_SCRIPT"`
+	// Storage backend: "postgres" (server) or "sqlite" (single-user desktop mode)
+	DatabaseDriver                   string        `mapstructure:"DATABASE_DRIVER"`
+	SQLitePath                       string        `mapstructure:"SQLITE_PATH"`
+	// Several web replicas on one Postgres database: active runs are shared through the
+	// database (refreshed every RUN_HEARTBEAT_INTERVAL seconds) and RAG ingestion takes a
+	// per-session advisory lock
+	MultiReplicaEnabled              bool          `mapstructure:"MULTI_REPLICA_ENABLED"`
+	RunHeartbeatInterval             time.Duration `mapstructure:"RUN_HEARTBEAT_INTERVAL"`
+	// Upload virus scanning through clamd; infected files are moved to the quarantine directory
+	UploadScanEnabled                bool          `mapstructure:"UPLOAD_SCAN_ENABLED"`
+	ClamdAddress                     string        `mapstructure:"CLAMD_ADDRESS"`
+	UploadScanTimeout                time.Duration `mapstructure:"UPLOAD_SCAN_TIMEOUT"`
+	UploadScanFailOpen               bool          `mapstructure:"UPLOAD_SCAN_FAIL_OPEN"`
+	UploadQuarantineDir              string        `mapstructure:"UPLOAD_QUARANTINE_DIR"`
+	// Dataset profiling on upload: row count, column types, missingness and distributions
+	DatasetProfileEnabled            bool          `mapstructure:"DATASET_PROFILE_ENABLED"`
+	DatasetProfileTimeout            time.Duration `mapstructure:"DATASET_PROFILE_TIMEOUT"`
+	// Content filter on user messages and streamed model output: regex patterns plus an
+	// optional HTTP classifier; a hit is blocked, shown as a warning, or only logged
+	ContentFilterEnabled             bool          `mapstructure:"CONTENT_FILTER_ENABLED"`
+	ContentFilterAction              string        `mapstructure:"CONTENT_FILTER_ACTION"`
+	ContentFilterPatterns            []string      `mapstructure:"CONTENT_FILTER_PATTERNS"`
+	ContentFilterClassifierURL       string        `mapstructure:"CONTENT_FILTER_CLASSIFIER_URL"`
+	ContentFilterThreshold           float64       `mapstructure:"CONTENT_FILTER_THRESHOLD"`
+	ContentFilterTimeout             time.Duration `mapstructure:"CONTENT_FILTER_TIMEOUT"`
 	MaxEmbeddingChars                int           `mapstructure:"MAX_EMBEDDING_CHARS"`
     EmbeddingTokenSoftLimit          int           `mapstructure:"EMBEDDING_TOKEN_SOFT_LIMIT"`
     EmbeddingTokenTarget             int           `mapstructure:"EMBEDDING_TOKEN_TARGET"`
+	// Texts sent per embedding request when ingesting chunks, PDF pages and messages
+	EmbeddingBatchSize               int           `mapstructure:"EMBEDDING_BATCH_SIZE"`
     MinTokenCheckCharThreshold       int           `mapstructure:"MIN_TOKEN_CHECK_CHAR_THRESHOLD"`
 	ConversationChunkSize            int           `mapstructure:"CONVERSATION_CHUNK_SIZE"`
 	ConversationChunkOverlap         float64       `mapstructure:"CONVERSATION_CHUNK_OVERLAP"`
 	DocumentChunkSize                int           `mapstructure:"DOCUMENT_CHUNK_SIZE"`
 	DocumentChunkOverlap             float64       `mapstructure:"DOCUMENT_CHUNK_OVERLAP"`
 	MaxHybridCandidates              int           `mapstructure:"MAX_HYBRID_CANDIDATES"`
+	RAGIngestWorkers                 int           `mapstructure:"RAG_INGEST_WORKERS"`
+	// Workers writing queued background batches across sessions, and messages per batch (0 = no cap)
+	RAGIngestQueueWorkers            int           `mapstructure:"RAG_INGEST_QUEUE_WORKERS"`
+	RAGIngestMaxBatch                int           `mapstructure:"RAG_INGEST_MAX_BATCH"`
+	// Per-session soft limits on background RAG writes; 0 disables each
+	RAGIngestCoalesceWindow          time.Duration `mapstructure:"RAG_INGEST_COALESCE_WINDOW"`
+	RAGIngestMaxBatchesPerMinute     int           `mapstructure:"RAG_INGEST_MAX_BATCHES_PER_MINUTE"`
+	RAGIngestMaxPending              int           `mapstructure:"RAG_INGEST_MAX_PENDING"`
+	// Serve repeated fact and searchable summaries from the database; TTL in hours, 0 = entries never expire
+	SummaryCacheEnabled              bool          `mapstructure:"SUMMARY_CACHE_ENABLED"`
+	SummaryCacheTTL                  time.Duration `mapstructure:"SUMMARY_CACHE_TTL"`
 	HybridSemanticWeight             float64       `mapstructure:"HYBRID_SEMANTIC_WEIGHT"`
 	HybridBM25Weight                 float64       `mapstructure:"HYBRID_BM25_WEIGHT"`
 	HybridStateBoost                 float64       `mapstructure:"HYBRID_STATE_BOOST"`
@@ -122,6 +314,13 @@ type Config struct {
 	HybridDocumentFactBoost          float64       `mapstructure:"HYBRID_DOCUMENT_FACT_BOOST"`
 	HybridDocumentSummaryBoost       float64       `mapstructure:"HYBRID_DOCUMENT_SUMMARY_BOOST"`
 	HybridDocumentDocumentBoost      float64       `mapstructure:"HYBRID_DOCUMENT_DOCUMENT_BOOST"`
+	HybridPDFSummaryBoost            float64       `mapstructure:"HYBRID_PDF_SUMMARY_BOOST"`
+	HybridAnnotationBoost            float64       `mapstructure:"HYBRID_ANNOTATION_BOOST"`
+	// Cross-encoder reranking of the top hybrid candidates; an empty host disables it
+	RerankHost                       string        `mapstructure:"RERANK_HOST"`
+	RerankModel                      string        `mapstructure:"RERANK_MODEL"`
+	RerankTopK                       int           `mapstructure:"RERANK_TOP_K"`
+	RerankEnabled                    bool          `mapstructure:"RERANK_ENABLED"`
 	PDFTokenThreshold                float64       `mapstructure:"PDF_TOKEN_THRESHOLD"`
 	PDFFirstPagesPriority            int           `mapstructure:"PDF_FIRST_PAGES_PRIORITY"`
 	PDFEnableTableDetection          bool          `mapstructure:"PDF_ENABLE_TABLE_DETECTION"`
@@ -129,6 +328,10 @@ type Config struct {
     PDFExtractorURL                  string        `mapstructure:"PDF_EXTRACTOR_URL"`
     PDFExtractorEnabled              bool          `mapstructure:"PDF_EXTRACTOR_ENABLED"`
     PDFExtractorTimeout              time.Duration `mapstructure:"PDF_EXTRACTOR_TIMEOUT"`
+    // On-disk LRU cache of extracted pages, keyed by file hash
+    PDFCacheEnabled                  bool          `mapstructure:"PDF_CACHE_ENABLED"`
+    PDFCacheDir                      string        `mapstructure:"PDF_CACHE_DIR"`
+    PDFCacheMaxMB                    int           `mapstructure:"PDF_CACHE_MAX_MB"`
     // PDF extractor tuning params (passed as query params)
     PDFExtractorMode                 string        `mapstructure:"PDF_EXTRACTOR_MODE"`
     PDFExtractorWordMargin           float64       `mapstructure:"PDF_EXTRACTOR_WORD_MARGIN"`
@@ -142,10 +345,122 @@ type Config struct {
     PDFHeaderFooterRepeatThreshold   float64       `mapstructure:"PDF_HEADER_FOOTER_REPEAT_THRESHOLD"`
     PDFReferencesTrimEnabled         bool          `mapstructure:"PDF_REFERENCES_TRIM_ENABLED"`
     PDFReferencesCitationDensity     float64       `mapstructure:"PDF_REFERENCES_CITATION_DENSITY"`
+    // PDF key-facts summary: characters of opening text sent to the summarization LLM
+    PDFSummarySourceChars            int           `mapstructure:"PDF_SUMMARY_SOURCE_CHARS"`
+    // PDF extraction quality: retry/flag threshold and the retrieval weight of unreadable pages
+    PDFQualityThreshold              float64       `mapstructure:"PDF_QUALITY_THRESHOLD"`
+    PDFQualityRetryEnabled           bool          `mapstructure:"PDF_QUALITY_RETRY_ENABLED"`
+    PDFQualityMinWeight              float64       `mapstructure:"PDF_QUALITY_MIN_WEIGHT"`
     // Document mode configuration
     DocumentModeEnabled              bool          `mapstructure:"DOCUMENT_MODE_ENABLED"`
-    DocumentModeRAGResults           int           `mapstructure:"DOCUMENT_MODE_RAG_RESULTS"`
+    DocumentMaxRetrievals            int           `mapstructure:"DOCUMENT_MAX_RETRIEVALS"`
     ResponseTokenBudget              int           `mapstructure:"RESPONSE_TOKEN_BUDGET"`
+    // Scale the response budget per turn (code step vs. write-up) and send it as max_tokens
+    ResponseBudgetNegotiation        bool          `mapstructure:"RESPONSE_BUDGET_NEGOTIATION"`
+    ResponseBudgetCodeRatio          float64       `mapstructure:"RESPONSE_BUDGET_CODE_RATIO"`
+    ResponseBudgetSummaryRatio       float64       `mapstructure:"RESPONSE_BUDGET_SUMMARY_RATIO"`
+    // What to do when a streamed response runs past its budget: none, cutoff, summarize, continue
+    ResponseOverrunAction            string        `mapstructure:"RESPONSE_OVERRUN_ACTION"`
+    ResponseOverrunMaxContinuations  int           `mapstructure:"RESPONSE_OVERRUN_MAX_CONTINUATIONS"`
+    // Cookie signing / CSRF
+    SessionSecret                    string        `mapstructure:"SESSION_SECRET"`
+    CookieSecure                     bool          `mapstructure:"COOKIE_SECURE"`
+    // Bulk admin jobs: bearer token for /admin/ (empty disables the API) and CSV export directory
+    AdminToken                       string        `mapstructure:"ADMIN_TOKEN"`
+    AdminExportDir                   string        `mapstructure:"ADMIN_EXPORT_DIR"`
+    // Accounts: require sign-in, open email/password registration, and OAuth providers
+    // (a provider is enabled by its client ID; callbacks go to OAUTH_REDIRECT_BASE_URL)
+    AuthRequired                     bool          `mapstructure:"AUTH_REQUIRED"`
+    AuthRegistrationEnabled          bool          `mapstructure:"AUTH_REGISTRATION_ENABLED"`
+    OAuthRedirectBaseURL             string        `mapstructure:"OAUTH_REDIRECT_BASE_URL"`
+    OAuthGoogleClientID              string        `mapstructure:"OAUTH_GOOGLE_CLIENT_ID"`
+    OAuthGoogleClientSecret          string        `mapstructure:"OAUTH_GOOGLE_CLIENT_SECRET"`
+    OAuthGitHubClientID              string        `mapstructure:"OAUTH_GITHUB_CLIENT_ID"`
+    OAuthGitHubClientSecret          string        `mapstructure:"OAUTH_GITHUB_CLIENT_SECRET"`
+    // Signed read-only session links; TTL in hours, 0 = links never expire
+    ShareLinksEnabled                bool          `mapstructure:"SHARE_LINKS_ENABLED"`
+    ShareLinkTTL                     time.Duration `mapstructure:"SHARE_LINK_TTL"`
+    // Database maintenance (ANALYZE / VACUUM / vector reindex)
+    DBMaintenanceEnabled             bool          `mapstructure:"DB_MAINTENANCE_ENABLED"`
+    DBMaintenanceInterval            time.Duration `mapstructure:"DB_MAINTENANCE_INTERVAL"`
+    DBReindexGrowthRatio             float64       `mapstructure:"DB_REINDEX_GROWTH_RATIO"`
+    // Approximate nearest-neighbour index on rag_embeddings (Postgres only)
+    VectorIndexType                  string        `mapstructure:"VECTOR_INDEX_TYPE"`
+    VectorIndexLists                 int           `mapstructure:"VECTOR_INDEX_LISTS"`
+    VectorIndexM                     int           `mapstructure:"VECTOR_INDEX_M"`
+    VectorIndexEFConstruction        int           `mapstructure:"VECTOR_INDEX_EF_CONSTRUCTION"`
+    // Fact clustering / consolidation job
+    FactConsolidationEnabled         bool          `mapstructure:"FACT_CONSOLIDATION_ENABLED"`
+    FactConsolidationInterval        time.Duration `mapstructure:"FACT_CONSOLIDATION_INTERVAL"`
+    FactConsolidationSimilarity      float64       `mapstructure:"FACT_CONSOLIDATION_SIMILARITY"`
+    // Same test run across this many variables is rolled up into one results table (0 disables)
+    ScreeningRollupMinTests          int           `mapstructure:"SCREENING_ROLLUP_MIN_TESTS"`
+    // Read-only DuckDB console over the session workspace's CSV/Parquet files
+    SQLConsoleEnabled                bool          `mapstructure:"SQL_CONSOLE_ENABLED"`
+    SQLConsoleMaxRows                int           `mapstructure:"SQL_CONSOLE_MAX_ROWS"`
+    // Lineage: before/after distribution comparison of imputed, rescaled or recoded columns
+    TransformDiffEnabled             bool          `mapstructure:"TRANSFORM_DIFF_ENABLED"`
+    // Lets the agent query uploaded datasets with <sql> blocks run in DuckDB
+    SQLToolEnabled                   bool          `mapstructure:"SQL_TOOL_ENABLED"`
+    SQLToolMaxRows                   int           `mapstructure:"SQL_TOOL_MAX_ROWS"`
+    // How the agent asks for code execution: text (fenced blocks), tools or grammar (JSON calls)
+    ToolCallMode                     string        `mapstructure:"TOOL_CALL_MODE"`
+    // Ask for a numbered analysis plan on a run's first turn and track its steps
+    PlannerEnabled                   bool          `mapstructure:"PLANNER_ENABLED"`
+    PlannerMaxSteps                  int           `mapstructure:"PLANNER_MAX_STEPS"`
+    // Opt-in anonymous usage telemetry (aggregate counts only, see telemetry.Report)
+    TelemetryEnabled                 bool          `mapstructure:"TELEMETRY_ENABLED"`
+    TelemetryEndpoint                string        `mapstructure:"TELEMETRY_ENDPOINT"`
+    TelemetryInterval                time.Duration `mapstructure:"TELEMETRY_INTERVAL"`
+    // Session report export; PDF needs a Gotenberg-compatible HTML-to-PDF converter
+    ReportPDFURL                     string        `mapstructure:"REPORT_PDF_URL"`
+    ReportPDFTimeout                 time.Duration `mapstructure:"REPORT_PDF_TIMEOUT"`
+    // RAG archival tiers: old conversation chunks leave default retrieval
+    RAGArchiveEnabled                bool          `mapstructure:"RAG_ARCHIVE_ENABLED"`
+    RAGArchiveInterval               time.Duration `mapstructure:"RAG_ARCHIVE_INTERVAL"`
+    RAGArchiveAfter                  time.Duration `mapstructure:"RAG_ARCHIVE_AFTER"`
+    RAGArchiveTTL                    time.Duration `mapstructure:"RAG_ARCHIVE_TTL"`
+    RAGArchiveRoles                  []string      `mapstructure:"RAG_ARCHIVE_ROLES"`
+    // SSE heartbeats and connection timeouts (also used by the WebSocket transport)
+    SSEHeartbeatInterval             time.Duration `mapstructure:"SSE_HEARTBEAT_INTERVAL"`
+    SSEWriteTimeout                  time.Duration `mapstructure:"SSE_WRITE_TIMEOUT"`
+    SSEIdleTimeout                   time.Duration `mapstructure:"SSE_IDLE_TIMEOUT"`
+    StreamWebSocketEnabled           bool          `mapstructure:"STREAM_WEBSOCKET_ENABLED"`
+    // Retrieval A/B experiment: sessions are bucketed into arms by percentage
+    RetrievalExperimentEnabled       bool          `mapstructure:"RETRIEVAL_EXPERIMENT_ENABLED"`
+    RetrievalExperimentArms          []RetrievalArm `mapstructure:"RETRIEVAL_EXPERIMENT_ARMS"`
+    // Named retrieval policy overrides, selectable per session
+    RetrievalPolicies                []RetrievalPolicy `mapstructure:"RETRIEVAL_POLICIES"`
+    // Long-running analysis notifications (webhook, email to the session owner, in-tab browser)
+    NotifyLongRunMinutes             time.Duration `mapstructure:"NOTIFY_LONG_RUN_MINUTES"`
+    NotifyWebhookURL                 string        `mapstructure:"NOTIFY_WEBHOOK_URL"`
+    NotifyBaseURL                    string        `mapstructure:"NOTIFY_BASE_URL"`
+    NotifyEmailEnabled               bool          `mapstructure:"NOTIFY_EMAIL_ENABLED"`
+    SMTPHost                         string        `mapstructure:"SMTP_HOST"`
+    SMTPPort                         int           `mapstructure:"SMTP_PORT"`
+    SMTPUsername                     string        `mapstructure:"SMTP_USERNAME"`
+    SMTPPassword                     string        `mapstructure:"SMTP_PASSWORD"`
+    SMTPFrom                         string        `mapstructure:"SMTP_FROM"`
+    // Per-turn run recording for offline replay (stats-agent replay)
+    RunRecordingEnabled              bool          `mapstructure:"RUN_RECORDING_ENABLED"`
+    ReplayLLMHost                    string        `mapstructure:"REPLAY_LLM_HOST"`
+    // Prometheus metrics at /metrics; the optional token is required as a bearer token
+    MetricsEnabled                   bool          `mapstructure:"METRICS_ENABLED"`
+    MetricsToken                     string        `mapstructure:"METRICS_TOKEN"`
+    // OpenTelemetry tracing exported over OTLP/HTTP
+    TracingEnabled                   bool          `mapstructure:"TRACING_ENABLED"`
+    TracingOTLPEndpoint              string        `mapstructure:"TRACING_OTLP_ENDPOINT"`
+    TracingOTLPInsecure              bool          `mapstructure:"TRACING_OTLP_INSECURE"`
+    TracingSampleRatio               float64       `mapstructure:"TRACING_SAMPLE_RATIO"`
+    TracingServiceName               string        `mapstructure:"TRACING_SERVICE_NAME"`
+    // Failure injection for resilience tests; never enable in production
+    ChaosEnabled                     bool          `mapstructure:"CHAOS_ENABLED"`
+    ChaosSeed                        int64         `mapstructure:"CHAOS_SEED"`
+    ChaosLLMTimeoutRate              float64       `mapstructure:"CHAOS_LLM_TIMEOUT_RATE"`
+    ChaosEmbeddingErrorRate          float64       `mapstructure:"CHAOS_EMBEDDING_ERROR_RATE"`
+    ChaosExecutorDisconnectRate      float64       `mapstructure:"CHAOS_EXECUTOR_DISCONNECT_RATE"`
+    ChaosDBLatencyRate               float64       `mapstructure:"CHAOS_DB_LATENCY_RATE"`
+    ChaosDBLatency                   time.Duration `mapstructure:"CHAOS_DB_LATENCY_MS"`
 }
 
 func Load(logger *zap.Logger) *Config {
@@ -160,13 +475,33 @@ func Load(logger *zap.Logger) *Config {
 	// Set default values
 	viper.SetDefault("LOG_LEVEL", "info")
 	viper.SetDefault("WEB_PORT", 8080)
+	viper.SetDefault("CHAT_PAGE_TURNS", defaultChatPageTurns)
 	viper.SetDefault("PYTHON_EXECUTOR_ADDRESSES", []string{})
 	viper.SetDefault("PYTHON_EXECUTOR_POOL", []string{})
 	viper.SetDefault("MAIN_LLM_HOST", "http://localhost:8080")
 	viper.SetDefault("EMBEDDING_LLM_HOST", "http://localhost:8081")
+	viper.SetDefault("MULTILINGUAL_EMBEDDING_HOST", "")
 	viper.SetDefault("SUMMARIZATION_LLM_HOST", "http://localhost:8082")
+	for _, role := range []string{"MAIN", "SUMMARIZATION", "EMBEDDING"} {
+		viper.SetDefault(role+"_LLM_PROVIDER", ProviderLlamaCpp)
+		viper.SetDefault(role+"_LLM_MODEL", "")
+		viper.SetDefault(role+"_LLM_API_KEY", "")
+		viper.SetDefault(role+"_LLM_BACKUP_HOSTS", []string{})
+	}
+	viper.SetDefault("LLM_BREAKER_FAILURES", 3)
+	viper.SetDefault("LLM_BREAKER_COOLDOWN", 30)
+	viper.SetDefault("LLM_HEALTH_PROBE_INTERVAL", 15)
+	viper.SetDefault("FIGURE_ALT_TEXT_ENABLED", true)
+	viper.SetDefault("INTERACTIVE_PLOTS_ENABLED", false)
+	viper.SetDefault("FOLLOWUP_SUGGESTIONS_ENABLED", true)
+	viper.SetDefault("SESSION_ABSTRACT_ENABLED", true)
+	viper.SetDefault("MAX_TURNS", 30)
+	viper.SetDefault("RUN_MAX_DURATION", 0)
+	viper.SetDefault("RUN_MAX_LLM_CALLS", 0)
+	viper.SetDefault("RUN_MAX_EXECUTED_CELLS", 0)
 	viper.SetDefault("CONTEXT_LENGTH", 4096)
 	viper.SetDefault("CONTEXT_SOFT_LIMIT_RATIO", defaultContextSoftLimitRatio)
+	viper.SetDefault("CONTEXT_SUMMARIZE_TRIMMED", false)
     viper.SetDefault("MAX_RETRIES", 5)
     viper.SetDefault("RETRY_DELAY_SECONDS", 2)
     viper.SetDefault("LLM_BACKOFF_MAX_SECONDS", 30)
@@ -190,11 +525,40 @@ func Load(logger *zap.Logger) *Config {
 	viper.SetDefault("PYTHON_EXECUTOR_DIAL_TIMEOUT_SECONDS", 3)
 	viper.SetDefault("PYTHON_EXECUTOR_IO_TIMEOUT_SECONDS", 60)
 	viper.SetDefault("PYTHON_EXECUTOR_MAX_CONNECTIONS", 4)
+	viper.SetDefault("PYTHON_EXECUTOR_MODE", "tcp")
+	viper.SetDefault("PYTHON_EXECUTOR_LOCAL_PYTHON", "")
+	viper.SetDefault("PYTHON_EXECUTOR_LOCAL_SCRIPT", "docker/executor/executor.py")
+	viper.SetDefault("DATABASE_DRIVER", "postgres")
+	viper.SetDefault("SQLITE_PATH", "stats_agent.db")
+	viper.SetDefault("MULTI_REPLICA_ENABLED", false)
+	viper.SetDefault("RUN_HEARTBEAT_INTERVAL", 5)
+	viper.SetDefault("UPLOAD_SCAN_ENABLED", false)
+	viper.SetDefault("CLAMD_ADDRESS", "tcp://localhost:3310")
+	viper.SetDefault("UPLOAD_SCAN_TIMEOUT", 30)
+	viper.SetDefault("UPLOAD_SCAN_FAIL_OPEN", false)
+	viper.SetDefault("UPLOAD_QUARANTINE_DIR", "quarantine")
+	viper.SetDefault("DATASET_PROFILE_ENABLED", true)
+	viper.SetDefault("DATASET_PROFILE_TIMEOUT", 60)
+	viper.SetDefault("CONTENT_FILTER_ENABLED", false)
+	viper.SetDefault("CONTENT_FILTER_ACTION", "warn")
+	viper.SetDefault("CONTENT_FILTER_PATTERNS", []string{})
+	viper.SetDefault("CONTENT_FILTER_CLASSIFIER_URL", "")
+	viper.SetDefault("CONTENT_FILTER_THRESHOLD", 0.5)
+	viper.SetDefault("CONTENT_FILTER_TIMEOUT", 5)
 	viper.SetDefault("MAX_EMBEDDING_CHARS", 1000)
     viper.SetDefault("EMBEDDING_TOKEN_SOFT_LIMIT", 450)
     viper.SetDefault("EMBEDDING_TOKEN_TARGET", 400)
+	viper.SetDefault("EMBEDDING_BATCH_SIZE", 64)
     viper.SetDefault("MIN_TOKEN_CHECK_CHAR_THRESHOLD", 100)
     viper.SetDefault("MAX_HYBRID_CANDIDATES", 100)
+    viper.SetDefault("RAG_INGEST_WORKERS", defaultRAGIngestWorkers)
+	viper.SetDefault("RAG_INGEST_QUEUE_WORKERS", 2)
+	viper.SetDefault("RAG_INGEST_MAX_BATCH", 20)
+	viper.SetDefault("RAG_INGEST_COALESCE_WINDOW", 2)
+	viper.SetDefault("RAG_INGEST_MAX_BATCHES_PER_MINUTE", 12)
+	viper.SetDefault("RAG_INGEST_MAX_PENDING", 40)
+	viper.SetDefault("SUMMARY_CACHE_ENABLED", true)
+	viper.SetDefault("SUMMARY_CACHE_TTL", 720)
 	viper.SetDefault("HYBRID_SEMANTIC_WEIGHT", defaultHybridSemanticWeight)
 	viper.SetDefault("HYBRID_BM25_WEIGHT", defaultHybridBM25Weight)
 	viper.SetDefault("HYBRID_STATE_BOOST", defaultHybridStateBoost)
@@ -206,6 +570,12 @@ func Load(logger *zap.Logger) *Config {
 	viper.SetDefault("HYBRID_DOCUMENT_FACT_BOOST", defaultHybridDocumentFactBoost)
 	viper.SetDefault("HYBRID_DOCUMENT_SUMMARY_BOOST", defaultHybridDocumentSummaryBoost)
 	viper.SetDefault("HYBRID_DOCUMENT_DOCUMENT_BOOST", defaultHybridDocumentDocumentBoost)
+	viper.SetDefault("HYBRID_PDF_SUMMARY_BOOST", defaultHybridPDFSummaryBoost)
+	viper.SetDefault("HYBRID_ANNOTATION_BOOST", defaultHybridAnnotationBoost)
+	viper.SetDefault("RERANK_HOST", "")
+	viper.SetDefault("RERANK_MODEL", "")
+	viper.SetDefault("RERANK_TOP_K", 20)
+	viper.SetDefault("RERANK_ENABLED", true)
     viper.SetDefault("CONVERSATION_CHUNK_SIZE", defaultConversationChunkSize)
     viper.SetDefault("CONVERSATION_CHUNK_OVERLAP", defaultConversationChunkOverlap)
     viper.SetDefault("DOCUMENT_CHUNK_SIZE", defaultDocumentChunkSize)
@@ -217,6 +587,9 @@ func Load(logger *zap.Logger) *Config {
     viper.SetDefault("PDF_EXTRACTOR_URL", defaultPDFExtractorURL)
     viper.SetDefault("PDF_EXTRACTOR_ENABLED", defaultPDFExtractorEnabled)
     viper.SetDefault("PDF_EXTRACTOR_TIMEOUT", defaultPDFExtractorTimeout)
+    viper.SetDefault("PDF_CACHE_ENABLED", true)
+    viper.SetDefault("PDF_CACHE_DIR", defaultPDFCacheDir)
+    viper.SetDefault("PDF_CACHE_MAX_MB", defaultPDFCacheMaxMB)
     // No defaults for tuning params; set in config.yaml when desired
     viper.SetDefault("PDF_EXTRACTOR_MODE", "")
     viper.SetDefault("PDF_EXTRACTOR_WORD_MARGIN", 0.0)
@@ -230,11 +603,107 @@ func Load(logger *zap.Logger) *Config {
     viper.SetDefault("PDF_HEADER_FOOTER_REPEAT_THRESHOLD", defaultPDFHeaderFooterRepeatThreshold)
     viper.SetDefault("PDF_REFERENCES_TRIM_ENABLED", defaultPDFReferencesTrimEnabled)
     viper.SetDefault("PDF_REFERENCES_CITATION_DENSITY", defaultPDFReferencesCitationDensity)
+    viper.SetDefault("PDF_SUMMARY_SOURCE_CHARS", defaultPDFSummarySourceChars)
+    viper.SetDefault("PDF_QUALITY_THRESHOLD", defaultPDFQualityThreshold)
+    viper.SetDefault("PDF_QUALITY_RETRY_ENABLED", defaultPDFQualityRetryEnabled)
+    viper.SetDefault("PDF_QUALITY_MIN_WEIGHT", defaultPDFQualityMinWeight)
     // Retrieval + Document mode defaults
-    viper.SetDefault("RAG_RESULTS", defaultRAGResults)
+    viper.SetDefault("RAG_DATASET_FACT_BUDGET", defaultRAGDatasetFactBudget)
+    viper.SetDefault("RAG_DATASET_STATE_BUDGET", defaultRAGDatasetStateBudget)
+    viper.SetDefault("RAG_DATASET_DOCUMENT_BUDGET", defaultRAGDatasetDocumentBudget)
+    viper.SetDefault("RAG_DATASET_USER_BUDGET", defaultRAGDatasetUserBudget)
+    viper.SetDefault("RAG_DOCUMENT_FACT_BUDGET", defaultRAGDocumentFactBudget)
+    viper.SetDefault("RAG_DOCUMENT_STATE_BUDGET", defaultRAGDocumentStateBudget)
+    viper.SetDefault("RAG_DOCUMENT_DOCUMENT_BUDGET", defaultRAGDocumentDocumentBudget)
+    viper.SetDefault("RAG_DOCUMENT_USER_BUDGET", defaultRAGDocumentUserBudget)
+    // Ingestion policy: system messages and the Python init banner never reach RAG
+    viper.SetDefault("RAG_EXCLUDED_ROLES", []string{"system"})
+    viper.SetDefault("RAG_EXCLUDED_PATTERNS", []string{
+        `POCKET STATISTICIAN SESSION INITIALIZED`,
+        `^The user has uploaded a file:`,
+        `^/finish\b`,
+    })
+    viper.SetDefault("RAG_SCOPE_TO_DATASET", true)
     viper.SetDefault("DOCUMENT_MODE_ENABLED", defaultDocumentModeEnabled)
-    viper.SetDefault("DOCUMENT_MODE_RAG_RESULTS", defaultDocumentModeRAGResults)
+    viper.SetDefault("DOCUMENT_MAX_RETRIEVALS", defaultDocumentMaxRetrievals)
     viper.SetDefault("RESPONSE_TOKEN_BUDGET", defaultResponseTokenBudget)
+    viper.SetDefault("RESPONSE_BUDGET_NEGOTIATION", false)
+    viper.SetDefault("RESPONSE_BUDGET_CODE_RATIO", 1.0)
+    viper.SetDefault("RESPONSE_BUDGET_SUMMARY_RATIO", 2.0)
+    viper.SetDefault("RESPONSE_OVERRUN_ACTION", "none")
+    viper.SetDefault("RESPONSE_OVERRUN_MAX_CONTINUATIONS", 1)
+    viper.SetDefault("SESSION_SECRET", "")
+    viper.SetDefault("COOKIE_SECURE", false)
+    viper.SetDefault("ADMIN_TOKEN", "")
+    viper.SetDefault("ADMIN_EXPORT_DIR", "exports")
+    viper.SetDefault("AUTH_REQUIRED", false)
+    viper.SetDefault("AUTH_REGISTRATION_ENABLED", true)
+    viper.SetDefault("OAUTH_REDIRECT_BASE_URL", "")
+    viper.SetDefault("OAUTH_GOOGLE_CLIENT_ID", "")
+    viper.SetDefault("OAUTH_GOOGLE_CLIENT_SECRET", "")
+    viper.SetDefault("OAUTH_GITHUB_CLIENT_ID", "")
+    viper.SetDefault("OAUTH_GITHUB_CLIENT_SECRET", "")
+    viper.SetDefault("SHARE_LINKS_ENABLED", true)
+    viper.SetDefault("SHARE_LINK_TTL", 168)
+    viper.SetDefault("DB_MAINTENANCE_ENABLED", false)
+    viper.SetDefault("DB_MAINTENANCE_INTERVAL", 6)
+    viper.SetDefault("DB_REINDEX_GROWTH_RATIO", defaultDBReindexGrowthRatio)
+    viper.SetDefault("VECTOR_INDEX_TYPE", "ivfflat")
+    viper.SetDefault("VECTOR_INDEX_LISTS", 0)
+    viper.SetDefault("VECTOR_INDEX_M", 16)
+    viper.SetDefault("VECTOR_INDEX_EF_CONSTRUCTION", 64)
+    viper.SetDefault("FACT_CONSOLIDATION_ENABLED", false)
+    viper.SetDefault("FACT_CONSOLIDATION_INTERVAL", 30)
+    viper.SetDefault("FACT_CONSOLIDATION_SIMILARITY", defaultFactConsolidationSimilarity)
+    viper.SetDefault("SCREENING_ROLLUP_MIN_TESTS", 5)
+    viper.SetDefault("SQL_CONSOLE_ENABLED", true)
+    viper.SetDefault("SQL_CONSOLE_MAX_ROWS", 1000)
+    viper.SetDefault("TRANSFORM_DIFF_ENABLED", true)
+    viper.SetDefault("SQL_TOOL_ENABLED", false)
+    viper.SetDefault("SQL_TOOL_MAX_ROWS", 50)
+    viper.SetDefault("TOOL_CALL_MODE", ToolCallModeText)
+    viper.SetDefault("PLANNER_ENABLED", false)
+    viper.SetDefault("PLANNER_MAX_STEPS", 8)
+    viper.SetDefault("TELEMETRY_ENABLED", false)
+    viper.SetDefault("TELEMETRY_ENDPOINT", "")
+    viper.SetDefault("TELEMETRY_INTERVAL", 24)
+    viper.SetDefault("REPORT_PDF_URL", "")
+    viper.SetDefault("REPORT_PDF_TIMEOUT", 60)
+    viper.SetDefault("RAG_ARCHIVE_ENABLED", false)
+    viper.SetDefault("RAG_ARCHIVE_INTERVAL", 6)
+    viper.SetDefault("RAG_ARCHIVE_AFTER", 168)
+    viper.SetDefault("RAG_ARCHIVE_TTL", 0)
+    viper.SetDefault("RAG_ARCHIVE_ROLES", []string{"user", "assistant", "tool"})
+    viper.SetDefault("SSE_HEARTBEAT_INTERVAL", 15)
+    viper.SetDefault("SSE_WRITE_TIMEOUT", 10)
+    viper.SetDefault("SSE_IDLE_TIMEOUT", 30)
+    viper.SetDefault("STREAM_WEBSOCKET_ENABLED", true)
+    viper.SetDefault("RETRIEVAL_EXPERIMENT_ENABLED", false)
+    viper.SetDefault("NOTIFY_LONG_RUN_MINUTES", 0)
+    viper.SetDefault("NOTIFY_WEBHOOK_URL", "")
+    viper.SetDefault("NOTIFY_BASE_URL", "")
+    viper.SetDefault("NOTIFY_EMAIL_ENABLED", false)
+    viper.SetDefault("SMTP_HOST", "")
+    viper.SetDefault("SMTP_PORT", 587)
+    viper.SetDefault("SMTP_USERNAME", "")
+    viper.SetDefault("SMTP_PASSWORD", "")
+    viper.SetDefault("SMTP_FROM", "")
+    viper.SetDefault("RUN_RECORDING_ENABLED", false)
+    viper.SetDefault("REPLAY_LLM_HOST", "")
+    viper.SetDefault("METRICS_ENABLED", false)
+    viper.SetDefault("METRICS_TOKEN", "")
+    viper.SetDefault("TRACING_ENABLED", false)
+    viper.SetDefault("TRACING_OTLP_ENDPOINT", "localhost:4318")
+    viper.SetDefault("TRACING_OTLP_INSECURE", true)
+    viper.SetDefault("TRACING_SAMPLE_RATIO", 1.0)
+    viper.SetDefault("TRACING_SERVICE_NAME", "stats-agent")
+    viper.SetDefault("CHAOS_ENABLED", false)
+    viper.SetDefault("CHAOS_SEED", 0)
+    viper.SetDefault("CHAOS_LLM_TIMEOUT_RATE", 0.0)
+    viper.SetDefault("CHAOS_EMBEDDING_ERROR_RATE", 0.0)
+    viper.SetDefault("CHAOS_EXECUTOR_DISCONNECT_RATE", 0.0)
+    viper.SetDefault("CHAOS_DB_LATENCY_RATE", 0.0)
+    viper.SetDefault("CHAOS_DB_LATENCY_MS", 500)
 
 	if err := viper.ReadInConfig(); err != nil {
 		if logger != nil {
@@ -253,6 +722,19 @@ func Load(logger *zap.Logger) *Config {
 		}
 	}
 
+	// Surface every problem at once rather than letting bad values fall back to defaults
+	// and show up later as odd retrieval behavior.
+	if unknown := unknownKeys(viper.AllKeys()); len(unknown) > 0 && logger != nil {
+		logger.Warn("Ignoring unknown config keys", zap.Strings("keys", unknown))
+	}
+	if problems := validate(&config); len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Invalid configuration (%d problems):\n  - %s\n", len(problems), strings.Join(problems, "\n  - "))
+		if logger != nil {
+			logger.Fatal("Invalid configuration", zap.Strings("problems", problems))
+		}
+		os.Exit(1)
+	}
+
 	// Normalize executor address configuration.
 	if len(config.PythonExecutorAddresses) == 0 && len(config.PythonExecutorPool) > 0 {
 		config.PythonExecutorAddresses = config.PythonExecutorPool
@@ -274,6 +756,8 @@ func Load(logger *zap.Logger) *Config {
 		config.PythonExecutorAddresses = []string{"localhost:9999"}
 	}
 	config.PythonExecutorPool = config.PythonExecutorAddresses
+	config.PythonExecutorMode = strings.ToLower(config.PythonExecutorMode)
+	config.DatabaseDriver = strings.ToLower(config.DatabaseDriver)
 
 	if config.ContextSoftLimitRatio <= 0 || config.ContextSoftLimitRatio >= 1 {
 		if logger != nil {
@@ -288,11 +772,33 @@ func Load(logger *zap.Logger) *Config {
     config.RetryDelaySeconds = config.RetryDelaySeconds * time.Second
     config.LLMBackoffMaxSeconds = config.LLMBackoffMaxSeconds * time.Second
 	config.LLMRequestTimeout = config.LLMRequestTimeout * time.Second
+	config.LLMBreakerCooldown = config.LLMBreakerCooldown * time.Second
+	config.LLMHealthProbeInterval = config.LLMHealthProbeInterval * time.Second
 	config.CleanupInterval = config.CleanupInterval * time.Hour
 	config.SessionRetentionAge = config.SessionRetentionAge * time.Hour
+	config.ShareLinkTTL = config.ShareLinkTTL * time.Hour
+	config.DBMaintenanceInterval = config.DBMaintenanceInterval * time.Hour
+	config.FactConsolidationInterval = config.FactConsolidationInterval * time.Minute
+	config.RAGArchiveInterval = config.RAGArchiveInterval * time.Hour
+	config.RAGArchiveAfter = config.RAGArchiveAfter * time.Hour
+	config.RAGArchiveTTL = config.RAGArchiveTTL * time.Hour
+	config.SummaryCacheTTL = config.SummaryCacheTTL * time.Hour
+	config.SSEHeartbeatInterval = config.SSEHeartbeatInterval * time.Second
+	config.SSEWriteTimeout = config.SSEWriteTimeout * time.Second
+	config.SSEIdleTimeout = config.SSEIdleTimeout * time.Minute
+	config.NotifyLongRunMinutes = config.NotifyLongRunMinutes * time.Minute
+	config.TelemetryInterval = config.TelemetryInterval * time.Hour
+	config.ReportPDFTimeout = config.ReportPDFTimeout * time.Second
+	config.RunMaxDuration = config.RunMaxDuration * time.Minute
 	config.PythonExecutorCooldownSeconds = config.PythonExecutorCooldownSeconds * time.Second
 	config.PythonExecutorDialTimeoutSeconds = config.PythonExecutorDialTimeoutSeconds * time.Second
 	config.PythonExecutorIOTimeoutSeconds = config.PythonExecutorIOTimeoutSeconds * time.Second
+	config.UploadScanTimeout = config.UploadScanTimeout * time.Second
+	config.DatasetProfileTimeout = config.DatasetProfileTimeout * time.Second
+	config.ContentFilterTimeout = config.ContentFilterTimeout * time.Second
+	config.RAGIngestCoalesceWindow = config.RAGIngestCoalesceWindow * time.Second
+	config.ChaosDBLatency = config.ChaosDBLatency * time.Millisecond
+	config.RunHeartbeatInterval = config.RunHeartbeatInterval * time.Second
 
     if config.PythonExecutorCooldownSeconds <= 0 {
         config.PythonExecutorCooldownSeconds = defaultPythonExecutorCooldownSeconds
@@ -330,6 +836,9 @@ func Load(logger *zap.Logger) *Config {
 	if config.MaxHybridCandidates <= 0 {
 		config.MaxHybridCandidates = defaultMaxHybridCandidates
 	}
+	if config.RAGIngestWorkers <= 0 {
+		config.RAGIngestWorkers = defaultRAGIngestWorkers
+	}
 	if config.HybridSemanticWeight <= 0 {
 		config.HybridSemanticWeight = defaultHybridSemanticWeight
 	}
@@ -365,6 +874,15 @@ func Load(logger *zap.Logger) *Config {
 	if config.HybridDocumentDocumentBoost <= 0 {
 		config.HybridDocumentDocumentBoost = defaultHybridDocumentDocumentBoost
 	}
+	if config.HybridPDFSummaryBoost <= 0 {
+		config.HybridPDFSummaryBoost = defaultHybridPDFSummaryBoost
+	}
+	if config.HybridAnnotationBoost <= 0 {
+		config.HybridAnnotationBoost = defaultHybridAnnotationBoost
+	}
+	if config.PDFSummarySourceChars <= 0 {
+		config.PDFSummarySourceChars = defaultPDFSummarySourceChars
+	}
 	if config.PDFTokenThreshold <= 0 || config.PDFTokenThreshold > 1 {
 		if logger != nil {
 			logger.Warn("Invalid PDF token threshold; using default",
@@ -383,6 +901,9 @@ func Load(logger *zap.Logger) *Config {
     if config.ConversationChunkOverlap <= 0 {
         config.ConversationChunkOverlap = defaultConversationChunkOverlap
     }
+    if config.DocumentMaxRetrievals <= 0 {
+        config.DocumentMaxRetrievals = defaultDocumentMaxRetrievals
+    }
     if config.DocumentChunkSize <= 0 {
         config.DocumentChunkSize = defaultDocumentChunkSize
     }
@@ -397,13 +918,297 @@ func Load(logger *zap.Logger) *Config {
         }
         config.WebPort = defaultWebPort
     }
+    if config.ChatPageTurns <= 0 {
+        config.ChatPageTurns = defaultChatPageTurns
+    }
     if config.ResponseTokenBudget <= 0 {
         config.ResponseTokenBudget = defaultResponseTokenBudget
     }
+    if config.DBMaintenanceInterval <= 0 {
+        config.DBMaintenanceInterval = defaultDBMaintenanceInterval
+    }
+    if config.DBReindexGrowthRatio <= 0 {
+        config.DBReindexGrowthRatio = defaultDBReindexGrowthRatio
+    }
+    if config.FactConsolidationInterval <= 0 {
+        config.FactConsolidationInterval = defaultFactConsolidationInterval
+    }
+    if config.FactConsolidationSimilarity <= 0 || config.FactConsolidationSimilarity > 1 {
+        config.FactConsolidationSimilarity = defaultFactConsolidationSimilarity
+    }
+    if config.SSEHeartbeatInterval <= 0 {
+        config.SSEHeartbeatInterval = defaultSSEHeartbeatInterval
+    }
+    if config.SSEWriteTimeout <= 0 {
+        config.SSEWriteTimeout = defaultSSEWriteTimeout
+    }
+    // SSEIdleTimeout <= 0 disables the idle cutoff
+    for i := range config.LLMModels {
+        config.LLMModels[i].Name = strings.TrimSpace(config.LLMModels[i].Name)
+    }
+    config.MainLLMProvider = normalizeProvider(config.MainLLMProvider)
+    config.SummarizationLLMProvider = normalizeProvider(config.SummarizationLLMProvider)
+    config.EmbeddingLLMProvider = normalizeProvider(config.EmbeddingLLMProvider)
+    config.ContentFilterAction = strings.ToLower(strings.TrimSpace(config.ContentFilterAction))
+    config.ResponseOverrunAction = strings.ToLower(strings.TrimSpace(config.ResponseOverrunAction))
+    config.ToolCallMode = strings.ToLower(strings.TrimSpace(config.ToolCallMode))
+    if config.ToolCallMode == "" {
+        config.ToolCallMode = ToolCallModeText
+    }
+    if config.RetrievalExperimentEnabled {
+        // Drop empty arms (validate already rejected bad names and totals over 100%)
+        arms := make([]RetrievalArm, 0, len(config.RetrievalExperimentArms))
+        for _, arm := range config.RetrievalExperimentArms {
+            arm.Name = strings.TrimSpace(arm.Name)
+            if arm.Name == "" || arm.Name == "control" || arm.Percent <= 0 {
+                continue
+            }
+            arms = append(arms, arm)
+        }
+        config.RetrievalExperimentArms = arms
+        if len(arms) == 0 {
+            config.RetrievalExperimentEnabled = false
+        }
+    }
+    config.VectorIndexType = strings.ToLower(strings.TrimSpace(config.VectorIndexType))
+    for i := range config.RetrievalPolicies {
+        config.RetrievalPolicies[i].Name = strings.ToLower(strings.TrimSpace(config.RetrievalPolicies[i].Name))
+    }
 
 	return &config
 }
 
+// RetrievalBudget returns the memory budget for a session mode ("dataset" or "document").
+func (c *Config) RetrievalBudget(mode string) RetrievalBudget {
+    if mode == "document" {
+        return RetrievalBudget{
+            Facts:     c.RAGDocumentFactBudget,
+            State:     c.RAGDocumentStateBudget,
+            Documents: c.RAGDocumentDocumentBudget,
+            User:      c.RAGDocumentUserBudget,
+        }
+    }
+    return RetrievalBudget{
+        Facts:     c.RAGDatasetFactBudget,
+        State:     c.RAGDatasetStateBudget,
+        Documents: c.RAGDatasetDocumentBudget,
+        User:      c.RAGDatasetUserBudget,
+    }
+}
+
+// RetrievalPolicy returns the policy a RAG query runs with. name selects a built-in policy
+// or a RETRIEVAL_POLICIES entry; "" (or a name no longer configured) uses the policy of
+// the session mode ("dataset" or "document").
+func (c *Config) RetrievalPolicy(mode, name string) RetrievalPolicy {
+    policy := c.builtinRetrievalPolicy(mode)
+    if name == "" || !c.HasRetrievalPolicy(name) {
+        name = policy.Name
+    }
+    if isBuiltinRetrievalPolicy(name) {
+        policy = c.builtinRetrievalPolicy(name)
+    }
+    for _, override := range c.RetrievalPolicies {
+        if override.Name == name {
+            policy = override.inherit(policy)
+            break
+        }
+    }
+    policy.Name = name
+    return policy
+}
+
+// HasRetrievalPolicy reports whether name is a built-in or configured retrieval policy.
+func (c *Config) HasRetrievalPolicy(name string) bool {
+    if isBuiltinRetrievalPolicy(name) {
+        return true
+    }
+    for _, p := range c.RetrievalPolicies {
+        if p.Name == name {
+            return true
+        }
+    }
+    return false
+}
+
+// RetrievalPolicyNames lists the built-in policies followed by the configured ones.
+func (c *Config) RetrievalPolicyNames() []string {
+    names := []string{RetrievalPolicyDataset, RetrievalPolicyDocument, RetrievalPolicyMixed}
+    for _, p := range c.RetrievalPolicies {
+        if !isBuiltinRetrievalPolicy(p.Name) {
+            names = append(names, p.Name)
+        }
+    }
+    return names
+}
+
+func isBuiltinRetrievalPolicy(name string) bool {
+    return name == RetrievalPolicyDataset || name == RetrievalPolicyDocument || name == RetrievalPolicyMixed
+}
+
+// builtinRetrievalPolicy builds a built-in policy from the HYBRID_* settings. Unknown
+// names get the dataset policy.
+func (c *Config) builtinRetrievalPolicy(name string) RetrievalPolicy {
+    policy := RetrievalPolicy{
+        Name:                RetrievalPolicyDataset,
+        SemanticWeight:      c.HybridSemanticWeight,
+        BM25Weight:          c.HybridBM25Weight,
+        FactBoost:           c.HybridDatasetFactBoost,
+        SummaryBoost:        c.HybridDatasetSummaryBoost,
+        DocumentBoost:       c.HybridDatasetDocumentBoost,
+        StateBoost:          c.HybridStateBoost,
+        PDFSummaryBoost:     c.HybridPDFSummaryBoost,
+        AnnotationBoost:     c.HybridAnnotationBoost,
+        ErrorPenalty:        c.HybridErrorPenalty,
+        SemanticThreshold:   c.SemanticSimilarityThreshold,
+        BM25Threshold:       c.BM25ScoreThreshold,
+        CandidateMultiplier: defaultRetrievalCandidateMultiplier,
+        MinCandidates:       defaultRetrievalMinCandidates,
+        MaxCandidates:       c.MaxHybridCandidates,
+    }
+    switch name {
+    case RetrievalPolicyDocument:
+        policy.Name = RetrievalPolicyDocument
+        policy.FactBoost = c.HybridDocumentFactBoost
+        policy.SummaryBoost = c.HybridDocumentSummaryBoost
+        policy.DocumentBoost = c.HybridDocumentDocumentBoost
+    case RetrievalPolicyMixed:
+        // Halfway between the mode boosts, so neither conversation facts nor paper
+        // passages crowd the other out
+        policy.Name = RetrievalPolicyMixed
+        policy.FactBoost = (c.HybridDatasetFactBoost + c.HybridDocumentFactBoost) / 2
+        policy.SummaryBoost = (c.HybridDatasetSummaryBoost + c.HybridDocumentSummaryBoost) / 2
+        policy.DocumentBoost = (c.HybridDatasetDocumentBoost + c.HybridDocumentDocumentBoost) / 2
+    }
+    return policy
+}
+
+// inherit returns p with its zero fields taken from base.
+func (p RetrievalPolicy) inherit(base RetrievalPolicy) RetrievalPolicy {
+    float := func(v, fallback float64) float64 {
+        if v != 0 {
+            return v
+        }
+        return fallback
+    }
+    integer := func(v, fallback int) int {
+        if v != 0 {
+            return v
+        }
+        return fallback
+    }
+    return RetrievalPolicy{
+        Name:                p.Name,
+        SemanticWeight:      float(p.SemanticWeight, base.SemanticWeight),
+        BM25Weight:          float(p.BM25Weight, base.BM25Weight),
+        FactBoost:           float(p.FactBoost, base.FactBoost),
+        SummaryBoost:        float(p.SummaryBoost, base.SummaryBoost),
+        DocumentBoost:       float(p.DocumentBoost, base.DocumentBoost),
+        StateBoost:          float(p.StateBoost, base.StateBoost),
+        PDFSummaryBoost:     float(p.PDFSummaryBoost, base.PDFSummaryBoost),
+        AnnotationBoost:     float(p.AnnotationBoost, base.AnnotationBoost),
+        ErrorPenalty:        float(p.ErrorPenalty, base.ErrorPenalty),
+        SemanticThreshold:   float(p.SemanticThreshold, base.SemanticThreshold),
+        BM25Threshold:       float(p.BM25Threshold, base.BM25Threshold),
+        CandidateMultiplier: integer(p.CandidateMultiplier, base.CandidateMultiplier),
+        MinCandidates:       integer(p.MinCandidates, base.MinCandidates),
+        MaxCandidates:       integer(p.MaxCandidates, base.MaxCandidates),
+    }
+}
+
+// LLM provider APIs selectable per role.
+const (
+    ProviderLlamaCpp  = "llamacpp"
+    ProviderOpenAI    = "openai"
+    ProviderAnthropic = "anthropic"
+    ProviderOllama    = "ollama"
+)
+
+// Tool call modes (TOOL_CALL_MODE). Text parses fenced Python and <sql> blocks out of the
+// response; tools sends the OpenAI tools schema; grammar constrains the reply to a JSON
+// object with llama.cpp's schema grammars.
+const (
+    ToolCallModeText    = "text"
+    ToolCallModeTools   = "tools"
+    ToolCallModeGrammar = "grammar"
+)
+
+// LLMRole is the provider setting of one model role and the hosts it serves.
+type LLMRole struct {
+    Name     string // main, summarization or embedding
+    Provider string
+    Model    string
+    APIKey   string
+    Hosts    []string
+    // Primary is the role's own host (MAIN_LLM_HOST, ...) and Backups its <ROLE>_LLM_BACKUP_HOSTS,
+    // which are also listed in Hosts
+    Primary  string
+    Backups  []string
+}
+
+// LLMRoles returns the provider settings per role. The main role serves MAIN_LLM_HOST,
+// the LLM_MODELS endpoints and REPLAY_LLM_HOST; embedding serves both embedding hosts.
+// Each role also serves its backup hosts.
+func (c *Config) LLMRoles() []LLMRole {
+    mainHosts := []string{c.MainLLMHost}
+    for _, m := range c.LLMModels {
+        mainHosts = append(mainHosts, m.Host)
+    }
+    mainBackups := nonEmpty(append([]string(nil), c.MainLLMBackupHosts...))
+    summarizationBackups := nonEmpty(append([]string(nil), c.SummarizationLLMBackupHosts...))
+    embeddingBackups := nonEmpty(append([]string(nil), c.EmbeddingLLMBackupHosts...))
+    return []LLMRole{
+        {Name: "main", Provider: c.MainLLMProvider, Model: c.MainLLMModel, APIKey: c.MainLLMAPIKey,
+            Hosts: nonEmpty(append(append(mainHosts, c.ReplayLLMHost), mainBackups...)),
+            Primary: c.MainLLMHost, Backups: mainBackups},
+        {Name: "summarization", Provider: c.SummarizationLLMProvider, Model: c.SummarizationLLMModel, APIKey: c.SummarizationLLMAPIKey,
+            Hosts: nonEmpty(append([]string{c.SummarizationLLMHost}, summarizationBackups...)),
+            Primary: c.SummarizationLLMHost, Backups: summarizationBackups},
+        {Name: "embedding", Provider: c.EmbeddingLLMProvider, Model: c.EmbeddingLLMModel, APIKey: c.EmbeddingLLMAPIKey,
+            Hosts: nonEmpty(append([]string{c.EmbeddingLLMHost, c.MultilingualEmbeddingHost}, embeddingBackups...)),
+            Primary: c.EmbeddingLLMHost, Backups: embeddingBackups},
+    }
+}
+
+func normalizeProvider(provider string) string {
+    provider = strings.ToLower(strings.TrimSpace(provider))
+    if provider == "" || provider == "llama.cpp" {
+        return ProviderLlamaCpp
+    }
+    return provider
+}
+
+func nonEmpty(values []string) []string {
+    out := values[:0]
+    for _, v := range values {
+        if strings.TrimSpace(v) != "" {
+            out = append(out, v)
+        }
+    }
+    return out
+}
+
+// LLMHost returns the host of the named LLM_MODELS endpoint, falling back to
+// MAIN_LLM_HOST for "" and unknown names (e.g. a model removed from the config).
+func (c *Config) LLMHost(model string) string {
+    if m, ok := c.LLMModel(model); ok {
+        return m.Host
+    }
+    return c.MainLLMHost
+}
+
+// LLMModel looks up a configured LLM_MODELS endpoint by name.
+func (c *Config) LLMModel(name string) (LLMModel, bool) {
+    if name == "" {
+        return LLMModel{}, false
+    }
+    for _, m := range c.LLMModels {
+        if m.Name == name {
+            return m, true
+        }
+    }
+    return LLMModel{}, false
+}
+
 // ContextSoftLimitTokens returns the token count threshold that triggers memory compression.
 func (c *Config) ContextSoftLimitTokens() int {
     ratio := c.ContextSoftLimitRatio
@@ -0,0 +1,592 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// hybridWeightTolerance is how far HYBRID_SEMANTIC_WEIGHT + HYBRID_BM25_WEIGHT may drift from 1.
+const hybridWeightTolerance = 0.01
+
+// validate checks ranges and cross-field consistency of the decoded configuration before
+// any value is normalized, so a misconfigured file fails at startup instead of silently
+// falling back to defaults. Durations are still in their configured units. Returns one
+// message per problem.
+func validate(c *Config) []string {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+	positive := func(key string, v float64) {
+		if v <= 0 {
+			fail("%s must be > 0 (got %v)", key, v)
+		}
+	}
+	ratio := func(key string, v float64, lowOpen, highOpen bool) {
+		if (lowOpen && v <= 0) || v < 0 || (highOpen && v >= 1) || v > 1 {
+			low, high := "[", "]"
+			if lowOpen {
+				low = "("
+			}
+			if highOpen {
+				high = ")"
+			}
+			fail("%s must be in %s0, 1%s (got %v)", key, low, high, v)
+		}
+	}
+	host := func(key, v string, required bool) {
+		if v == "" {
+			if required {
+				fail("%s must be set", key)
+			}
+			return
+		}
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("%s must be an http(s) URL (got %q)", key, v)
+		}
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "error":
+	default:
+		fail("LOG_LEVEL must be one of debug, info, warn, error (got %q)", c.LogLevel)
+	}
+	if c.WebPort <= 0 || c.WebPort > 65535 {
+		fail("WEB_PORT must be in 1..65535 (got %d)", c.WebPort)
+	}
+	positive("CHAT_PAGE_TURNS", float64(c.ChatPageTurns))
+	host("MAIN_LLM_HOST", c.MainLLMHost, true)
+	seenModels := make(map[string]bool)
+	for i, m := range c.LLMModels {
+		label := fmt.Sprintf("LLM_MODELS[%d]", i)
+		name := strings.TrimSpace(m.Name)
+		switch {
+		case name == "":
+			fail("%s needs a NAME", label)
+		case seenModels[name]:
+			fail("%s: duplicate model name %q", label, name)
+		}
+		seenModels[name] = true
+		host(label+" HOST", m.Host, true)
+	}
+	host("EMBEDDING_LLM_HOST", c.EmbeddingLLMHost, true)
+	host("SUMMARIZATION_LLM_HOST", c.SummarizationLLMHost, true)
+	host("MULTILINGUAL_EMBEDDING_HOST", c.MultilingualEmbeddingHost, false)
+	host("REPLAY_LLM_HOST", c.ReplayLLMHost, false)
+	for _, backup := range []struct {
+		key   string
+		hosts []string
+	}{
+		{"MAIN_LLM_BACKUP_HOSTS", c.MainLLMBackupHosts},
+		{"SUMMARIZATION_LLM_BACKUP_HOSTS", c.SummarizationLLMBackupHosts},
+		{"EMBEDDING_LLM_BACKUP_HOSTS", c.EmbeddingLLMBackupHosts},
+	} {
+		for _, h := range backup.hosts {
+			host(backup.key, strings.TrimSpace(h), false)
+		}
+	}
+	positive("LLM_BREAKER_FAILURES", float64(c.LLMBreakerFailures))
+	positive("LLM_BREAKER_COOLDOWN", float64(c.LLMBreakerCooldown))
+	if c.LLMHealthProbeInterval < 0 {
+		fail("LLM_HEALTH_PROBE_INTERVAL must be >= 0 (got %d)", c.LLMHealthProbeInterval)
+	}
+	// Calls are routed to a provider by host, so roles sharing a host must agree on it
+	hostRoles := make(map[string]LLMRole)
+	for _, role := range c.LLMRoles() {
+		key := strings.ToUpper(role.Name) + "_LLM"
+		provider := normalizeProvider(role.Provider)
+		switch provider {
+		case ProviderLlamaCpp:
+		case ProviderOpenAI, ProviderOllama:
+			if strings.TrimSpace(role.Model) == "" {
+				fail("%s_MODEL must be set for the %s provider", key, provider)
+			}
+		case ProviderAnthropic:
+			if role.Name == "embedding" {
+				fail("EMBEDDING_LLM_PROVIDER cannot be anthropic (it has no embeddings API)")
+			}
+			if strings.TrimSpace(role.Model) == "" || strings.TrimSpace(role.APIKey) == "" {
+				fail("%s_MODEL and %s_API_KEY must be set for the anthropic provider", key, key)
+			}
+		default:
+			fail("%s_PROVIDER must be one of llamacpp, openai, anthropic, ollama (got %q)", key, role.Provider)
+		}
+		for _, h := range role.Hosts {
+			h = strings.TrimRight(h, "/")
+			if other, ok := hostRoles[h]; ok && (normalizeProvider(other.Provider) != provider || other.Model != role.Model || other.APIKey != role.APIKey) {
+				fail("the %s and %s roles share host %s but use different providers, models or API keys", other.Name, role.Name, h)
+			}
+			hostRoles[h] = role
+		}
+	}
+	if c.PDFExtractorEnabled {
+		host("PDF_EXTRACTOR_URL", c.PDFExtractorURL, true)
+	}
+	if c.PDFCacheEnabled {
+		if strings.TrimSpace(c.PDFCacheDir) == "" {
+			fail("PDF_CACHE_DIR must be set when PDF_CACHE_ENABLED is true")
+		}
+		positive("PDF_CACHE_MAX_MB", float64(c.PDFCacheMaxMB))
+	}
+	switch strings.ToLower(c.PythonExecutorMode) {
+	case "tcp":
+	case "local":
+		if c.PythonExecutorLocalScript == "" {
+			fail("PYTHON_EXECUTOR_LOCAL_SCRIPT must be set when PYTHON_EXECUTOR_MODE is local")
+		}
+	default:
+		fail("PYTHON_EXECUTOR_MODE must be tcp or local (got %q)", c.PythonExecutorMode)
+	}
+	if c.UploadScanEnabled {
+		if !strings.HasPrefix(c.ClamdAddress, "tcp://") && !strings.HasPrefix(c.ClamdAddress, "unix://") {
+			fail("CLAMD_ADDRESS must start with tcp:// or unix:// (got %q)", c.ClamdAddress)
+		}
+		positive("UPLOAD_SCAN_TIMEOUT", float64(c.UploadScanTimeout))
+		if strings.TrimSpace(c.UploadQuarantineDir) == "" {
+			fail("UPLOAD_QUARANTINE_DIR must be set when UPLOAD_SCAN_ENABLED is true")
+		}
+	}
+	if c.DatasetProfileEnabled {
+		positive("DATASET_PROFILE_TIMEOUT", float64(c.DatasetProfileTimeout))
+	}
+	if c.AdminToken != "" && c.AdminExportDir == "" {
+		fail("ADMIN_EXPORT_DIR must be set when ADMIN_TOKEN is set")
+	}
+	oauthProviders := []struct{ name, id, secret string }{
+		{"GOOGLE", c.OAuthGoogleClientID, c.OAuthGoogleClientSecret},
+		{"GITHUB", c.OAuthGitHubClientID, c.OAuthGitHubClientSecret},
+	}
+	oauthEnabled := false
+	for _, p := range oauthProviders {
+		if p.id == "" {
+			continue
+		}
+		oauthEnabled = true
+		if p.secret == "" {
+			fail("OAUTH_%s_CLIENT_SECRET must be set when OAUTH_%s_CLIENT_ID is set", p.name, p.name)
+		}
+	}
+	if oauthEnabled {
+		host("OAUTH_REDIRECT_BASE_URL", c.OAuthRedirectBaseURL, true)
+	}
+	if c.ShareLinkTTL < 0 {
+		fail("SHARE_LINK_TTL must be >= 0 (got %v)", int64(c.ShareLinkTTL))
+	}
+	if c.AuthRequired && !c.AuthRegistrationEnabled && !oauthEnabled {
+		fail("AUTH_REQUIRED without AUTH_REGISTRATION_ENABLED or an OAuth provider leaves no way to create an account")
+	}
+	if c.ContentFilterEnabled {
+		switch strings.ToLower(c.ContentFilterAction) {
+		case "block", "warn", "log":
+		default:
+			fail("CONTENT_FILTER_ACTION must be one of block, warn, log (got %q)", c.ContentFilterAction)
+		}
+		for _, pattern := range c.ContentFilterPatterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				fail("CONTENT_FILTER_PATTERNS entry %q is not a valid regular expression: %v", pattern, err)
+			}
+		}
+		host("CONTENT_FILTER_CLASSIFIER_URL", c.ContentFilterClassifierURL, false)
+		ratio("CONTENT_FILTER_THRESHOLD", c.ContentFilterThreshold, true, false)
+		positive("CONTENT_FILTER_TIMEOUT", float64(c.ContentFilterTimeout))
+		if len(c.ContentFilterPatterns) == 0 && c.ContentFilterClassifierURL == "" {
+			fail("CONTENT_FILTER_ENABLED needs CONTENT_FILTER_PATTERNS or CONTENT_FILTER_CLASSIFIER_URL")
+		}
+	}
+	switch strings.ToLower(c.DatabaseDriver) {
+	case "postgres":
+	case "sqlite":
+		if strings.TrimSpace(c.SQLitePath) == "" {
+			fail("SQLITE_PATH must be set when DATABASE_DRIVER is sqlite")
+		}
+		if c.MultiReplicaEnabled {
+			fail("MULTI_REPLICA_ENABLED needs DATABASE_DRIVER postgres; a SQLite file serves one process")
+		}
+	default:
+		fail("DATABASE_DRIVER must be postgres or sqlite (got %q)", c.DatabaseDriver)
+	}
+	if c.MultiReplicaEnabled {
+		positive("RUN_HEARTBEAT_INTERVAL", float64(c.RunHeartbeatInterval))
+	}
+
+	// Agent and LLM
+	positive("MAX_TURNS", float64(c.MaxTurns))
+	if c.RunMaxDuration < 0 || c.RunMaxLLMCalls < 0 || c.RunMaxExecutedCells < 0 {
+		fail("RUN_MAX_DURATION, RUN_MAX_LLM_CALLS and RUN_MAX_EXECUTED_CELLS must be >= 0 (0 disables)")
+	}
+	positive("CONTEXT_LENGTH", float64(c.ContextLength))
+	ratio("CONTEXT_SOFT_LIMIT_RATIO", c.ContextSoftLimitRatio, true, true)
+	positive("RESPONSE_TOKEN_BUDGET", float64(c.ResponseTokenBudget))
+	if c.ContextLength > 0 && c.ResponseTokenBudget*2 > c.ContextLength {
+		fail("RESPONSE_TOKEN_BUDGET (%d) must be at most half of CONTEXT_LENGTH (%d) to leave room for the prompt",
+			c.ResponseTokenBudget, c.ContextLength)
+	}
+	if c.ContextLength > 0 && c.ContextSoftLimitRatio > 0 &&
+		int(float64(c.ContextLength)*c.ContextSoftLimitRatio)+c.ResponseTokenBudget > c.ContextLength {
+		fail("CONTEXT_SOFT_LIMIT_RATIO (%v) leaves no room for RESPONSE_TOKEN_BUDGET (%d) within CONTEXT_LENGTH (%d)",
+			c.ContextSoftLimitRatio, c.ResponseTokenBudget, c.ContextLength)
+	}
+	if c.ResponseBudgetNegotiation {
+		positive("RESPONSE_BUDGET_CODE_RATIO", c.ResponseBudgetCodeRatio)
+		positive("RESPONSE_BUDGET_SUMMARY_RATIO", c.ResponseBudgetSummaryRatio)
+		if largest := int(float64(c.ResponseTokenBudget) * max(c.ResponseBudgetCodeRatio, c.ResponseBudgetSummaryRatio)); c.ContextLength > 0 && largest*2 > c.ContextLength {
+			fail("the largest negotiated response budget (%d tokens) must be at most half of CONTEXT_LENGTH (%d)", largest, c.ContextLength)
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(c.ResponseOverrunAction)) {
+	case "", "none", "cutoff", "summarize":
+	case "continue":
+		positive("RESPONSE_OVERRUN_MAX_CONTINUATIONS", float64(c.ResponseOverrunMaxContinuations))
+	default:
+		fail("RESPONSE_OVERRUN_ACTION must be one of none, cutoff, summarize, continue (got %q)", c.ResponseOverrunAction)
+	}
+	positive("RETRY_DELAY_SECONDS", float64(c.RetryDelaySeconds))
+	positive("LLM_BACKOFF_MAX_SECONDS", float64(c.LLMBackoffMaxSeconds))
+	if c.RetryDelaySeconds > c.LLMBackoffMaxSeconds {
+		fail("RETRY_DELAY_SECONDS (%d) must not exceed LLM_BACKOFF_MAX_SECONDS (%d)", c.RetryDelaySeconds, c.LLMBackoffMaxSeconds)
+	}
+	ratio("LLM_BACKOFF_JITTER_RATIO", c.LLMBackoffJitterRatio, false, false)
+	if c.BaseTemperature < 0 || c.MaxTemperature < 0 || c.TemperatureStep < 0 {
+		fail("BASE_TEMPERATURE, MAX_TEMPERATURE and TEMPERATURE_STEP must be >= 0")
+	}
+	if c.BaseTemperature > c.MaxTemperature {
+		fail("BASE_TEMPERATURE (%v) must not exceed MAX_TEMPERATURE (%v)", c.BaseTemperature, c.MaxTemperature)
+	}
+
+	// Retrieval
+	positive("MAX_HYBRID_CANDIDATES", float64(c.MaxHybridCandidates))
+	positive("RAG_INGEST_WORKERS", float64(c.RAGIngestWorkers))
+	positive("RAG_INGEST_QUEUE_WORKERS", float64(c.RAGIngestQueueWorkers))
+	if c.RAGIngestCoalesceWindow < 0 || c.RAGIngestMaxBatchesPerMinute < 0 || c.RAGIngestMaxPending < 0 || c.RAGIngestMaxBatch < 0 {
+		fail("RAG_INGEST_COALESCE_WINDOW, RAG_INGEST_MAX_BATCHES_PER_MINUTE, RAG_INGEST_MAX_PENDING and RAG_INGEST_MAX_BATCH must be >= 0")
+	}
+	if c.SummaryCacheTTL < 0 {
+		fail("SUMMARY_CACHE_TTL must be >= 0 (got %d)", c.SummaryCacheTTL)
+	}
+	for _, mode := range []string{"DATASET", "DOCUMENT"} {
+		budget := c.RetrievalBudget(strings.ToLower(mode))
+		categories := []struct {
+			key string
+			v   int
+		}{{"FACT", budget.Facts}, {"STATE", budget.State}, {"DOCUMENT", budget.Documents}, {"USER", budget.User}}
+		for _, cat := range categories {
+			if cat.v < 0 {
+				fail("RAG_%s_%s_BUDGET must be >= 0 (got %d)", mode, cat.key, cat.v)
+			}
+		}
+		if budget.Total() <= 0 {
+			fail("RAG_%s_*_BUDGET must allow at least one memory entry", mode)
+		}
+		if c.MaxHybridCandidates > 0 && budget.Total() > c.MaxHybridCandidates {
+			fail("RAG_%s_*_BUDGET total (%d) must not exceed MAX_HYBRID_CANDIDATES (%d)", mode, budget.Total(), c.MaxHybridCandidates)
+		}
+	}
+	ratio("SEMANTIC_SIMILARITY_THRESHOLD", c.SemanticSimilarityThreshold, true, false)
+	if c.BM25ScoreThreshold < 0 {
+		fail("BM25_SCORE_THRESHOLD must be >= 0 (got %v)", c.BM25ScoreThreshold)
+	}
+	checkHybridWeights(fail, "HYBRID_SEMANTIC_WEIGHT", "HYBRID_BM25_WEIGHT", c.HybridSemanticWeight, c.HybridBM25Weight)
+	positive("HYBRID_STATE_BOOST", c.HybridStateBoost)
+	ratio("HYBRID_ERROR_PENALTY", c.HybridErrorPenalty, true, true)
+	positive("HYBRID_DATASET_FACT_BOOST", c.HybridDatasetFactBoost)
+	positive("HYBRID_DATASET_SUMMARY_BOOST", c.HybridDatasetSummaryBoost)
+	positive("HYBRID_DATASET_DOCUMENT_BOOST", c.HybridDatasetDocumentBoost)
+	positive("HYBRID_DOCUMENT_FACT_BOOST", c.HybridDocumentFactBoost)
+	positive("HYBRID_DOCUMENT_SUMMARY_BOOST", c.HybridDocumentSummaryBoost)
+	positive("HYBRID_DOCUMENT_DOCUMENT_BOOST", c.HybridDocumentDocumentBoost)
+	positive("HYBRID_PDF_SUMMARY_BOOST", c.HybridPDFSummaryBoost)
+	positive("HYBRID_ANNOTATION_BOOST", c.HybridAnnotationBoost)
+	host("RERANK_HOST", c.RerankHost, false)
+	if c.RerankHost != "" {
+		positive("RERANK_TOP_K", float64(c.RerankTopK))
+	}
+	for _, pattern := range c.RAGExcludedPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			fail("RAG_EXCLUDED_PATTERNS entry %q is not a valid regular expression: %v", pattern, err)
+		}
+	}
+
+	// Embedding and chunking
+	positive("MAX_EMBEDDING_CHARS", float64(c.MaxEmbeddingChars))
+	positive("EMBEDDING_TOKEN_TARGET", float64(c.EmbeddingTokenTarget))
+	positive("EMBEDDING_TOKEN_SOFT_LIMIT", float64(c.EmbeddingTokenSoftLimit))
+	positive("EMBEDDING_BATCH_SIZE", float64(c.EmbeddingBatchSize))
+	if c.EmbeddingTokenTarget > c.EmbeddingTokenSoftLimit {
+		fail("EMBEDDING_TOKEN_TARGET (%d) must not exceed EMBEDDING_TOKEN_SOFT_LIMIT (%d)", c.EmbeddingTokenTarget, c.EmbeddingTokenSoftLimit)
+	}
+	positive("MIN_TOKEN_CHECK_CHAR_THRESHOLD", float64(c.MinTokenCheckCharThreshold))
+	positive("CONVERSATION_CHUNK_SIZE", float64(c.ConversationChunkSize))
+	ratio("CONVERSATION_CHUNK_OVERLAP", c.ConversationChunkOverlap, true, true)
+	positive("DOCUMENT_CHUNK_SIZE", float64(c.DocumentChunkSize))
+	ratio("DOCUMENT_CHUNK_OVERLAP", c.DocumentChunkOverlap, false, true)
+
+	// PDF processing
+	ratio("PDF_TOKEN_THRESHOLD", c.PDFTokenThreshold, true, false)
+	if c.PDFFirstPagesPriority < 0 {
+		fail("PDF_FIRST_PAGES_PRIORITY must be >= 0 (got %d)", c.PDFFirstPagesPriority)
+	}
+	ratio("PDF_HEADER_FOOTER_REPEAT_THRESHOLD", c.PDFHeaderFooterRepeatThreshold, true, false)
+	ratio("PDF_REFERENCES_CITATION_DENSITY", c.PDFReferencesCitationDensity, true, false)
+	positive("PDF_SUMMARY_SOURCE_CHARS", float64(c.PDFSummarySourceChars))
+	ratio("PDF_QUALITY_THRESHOLD", c.PDFQualityThreshold, false, false)
+	ratio("PDF_QUALITY_MIN_WEIGHT", c.PDFQualityMinWeight, false, false)
+	positive("DOCUMENT_MAX_RETRIEVALS", float64(c.DocumentMaxRetrievals))
+
+	// Background jobs and streaming
+	if c.DBMaintenanceEnabled {
+		positive("DB_MAINTENANCE_INTERVAL", float64(c.DBMaintenanceInterval))
+	}
+	positive("DB_REINDEX_GROWTH_RATIO", c.DBReindexGrowthRatio)
+	switch strings.ToLower(strings.TrimSpace(c.VectorIndexType)) {
+	case "ivfflat":
+		if c.VectorIndexLists < 0 || c.VectorIndexLists > 32768 {
+			fail("VECTOR_INDEX_LISTS must be in 0..32768, 0 sizing the lists from the row count (got %d)", c.VectorIndexLists)
+		}
+	case "hnsw":
+		if c.VectorIndexM < 2 || c.VectorIndexM > 100 {
+			fail("VECTOR_INDEX_M must be in 2..100 (got %d)", c.VectorIndexM)
+		}
+		if c.VectorIndexEFConstruction < 2*c.VectorIndexM || c.VectorIndexEFConstruction > 1000 {
+			fail("VECTOR_INDEX_EF_CONSTRUCTION must be in 2*VECTOR_INDEX_M..1000 (got %d)", c.VectorIndexEFConstruction)
+		}
+	default:
+		fail("VECTOR_INDEX_TYPE must be ivfflat or hnsw (got %q)", c.VectorIndexType)
+	}
+	if c.FactConsolidationEnabled {
+		positive("FACT_CONSOLIDATION_INTERVAL", float64(c.FactConsolidationInterval))
+	}
+	ratio("FACT_CONSOLIDATION_SIMILARITY", c.FactConsolidationSimilarity, true, false)
+	if c.ScreeningRollupMinTests < 0 || c.ScreeningRollupMinTests == 1 {
+		fail("SCREENING_ROLLUP_MIN_TESTS must be 0 (disabled) or at least 2 (got %d)", c.ScreeningRollupMinTests)
+	}
+	if c.SQLConsoleEnabled {
+		positive("SQL_CONSOLE_MAX_ROWS", float64(c.SQLConsoleMaxRows))
+	}
+	if c.SQLToolEnabled {
+		positive("SQL_TOOL_MAX_ROWS", float64(c.SQLToolMaxRows))
+	}
+	if c.PlannerEnabled {
+		positive("PLANNER_MAX_STEPS", float64(c.PlannerMaxSteps))
+	}
+	mainProvider := normalizeProvider(c.MainLLMProvider)
+	switch strings.ToLower(strings.TrimSpace(c.ToolCallMode)) {
+	case "", ToolCallModeText:
+	case ToolCallModeTools:
+		if mainProvider != ProviderLlamaCpp && mainProvider != ProviderOpenAI {
+			fail("TOOL_CALL_MODE=tools needs MAIN_LLM_PROVIDER llamacpp or openai (got %q)", mainProvider)
+		}
+	case ToolCallModeGrammar:
+		if mainProvider != ProviderLlamaCpp {
+			fail("TOOL_CALL_MODE=grammar needs MAIN_LLM_PROVIDER llamacpp (got %q)", mainProvider)
+		}
+	default:
+		fail("TOOL_CALL_MODE must be one of text, tools, grammar (got %q)", c.ToolCallMode)
+	}
+	if c.TelemetryEnabled {
+		host("TELEMETRY_ENDPOINT", c.TelemetryEndpoint, true)
+		positive("TELEMETRY_INTERVAL", float64(c.TelemetryInterval))
+	}
+	host("REPORT_PDF_URL", c.ReportPDFURL, false)
+	if c.ReportPDFURL != "" {
+		positive("REPORT_PDF_TIMEOUT", float64(c.ReportPDFTimeout))
+	}
+	if c.RAGArchiveEnabled {
+		positive("RAG_ARCHIVE_INTERVAL", float64(c.RAGArchiveInterval))
+		positive("RAG_ARCHIVE_AFTER", float64(c.RAGArchiveAfter))
+		if len(c.RAGArchiveRoles) == 0 {
+			fail("RAG_ARCHIVE_ROLES must list at least one role when RAG_ARCHIVE_ENABLED is true")
+		}
+	}
+	if c.RAGArchiveTTL < 0 {
+		fail("RAG_ARCHIVE_TTL must be >= 0 (got %d)", c.RAGArchiveTTL)
+	}
+	positive("SSE_HEARTBEAT_INTERVAL", float64(c.SSEHeartbeatInterval))
+	positive("SSE_WRITE_TIMEOUT", float64(c.SSEWriteTimeout))
+	// SSE_IDLE_TIMEOUT is in minutes, SSE_HEARTBEAT_INTERVAL in seconds
+	if c.SSEIdleTimeout > 0 && c.SSEIdleTimeout*60 <= c.SSEHeartbeatInterval {
+		fail("SSE_IDLE_TIMEOUT (%d min) must exceed SSE_HEARTBEAT_INTERVAL (%d s), or be 0 to disable", c.SSEIdleTimeout, c.SSEHeartbeatInterval)
+	}
+
+	// Notifications
+	if c.NotifyLongRunMinutes < 0 {
+		fail("NOTIFY_LONG_RUN_MINUTES must be >= 0 (got %d)", c.NotifyLongRunMinutes)
+	}
+	host("NOTIFY_WEBHOOK_URL", c.NotifyWebhookURL, false)
+	host("NOTIFY_BASE_URL", c.NotifyBaseURL, false)
+	if c.NotifyEmailEnabled {
+		if c.SMTPHost == "" || c.SMTPFrom == "" {
+			fail("NOTIFY_EMAIL_ENABLED requires SMTP_HOST and SMTP_FROM")
+		}
+		if c.SMTPPort <= 0 || c.SMTPPort > 65535 {
+			fail("SMTP_PORT must be in 1..65535 (got %d)", c.SMTPPort)
+		}
+	}
+
+	if c.RetrievalExperimentEnabled {
+		total := 0
+		seen := make(map[string]bool)
+		for i, arm := range c.RetrievalExperimentArms {
+			name := strings.TrimSpace(arm.Name)
+			label := fmt.Sprintf("RETRIEVAL_EXPERIMENT_ARMS[%d]", i)
+			switch {
+			case name == "":
+				fail("%s needs a NAME", label)
+			case name == "control":
+				fail("%s: \"control\" is reserved for sessions outside every arm", label)
+			case seen[name]:
+				fail("%s: duplicate arm name %q", label, name)
+			}
+			seen[name] = true
+			if arm.Percent < 0 {
+				fail("%s PERCENT must be >= 0 (got %d)", label, arm.Percent)
+			}
+			total += arm.Percent
+			if arm.SemanticWeight != 0 || arm.BM25Weight != 0 {
+				checkHybridWeights(fail, label+" SEMANTIC_WEIGHT", label+" BM25_WEIGHT", arm.SemanticWeight, arm.BM25Weight)
+			}
+			if arm.FactBoost < 0 || arm.SummaryBoost < 0 || arm.DocumentBoost < 0 || arm.StateBoost < 0 {
+				fail("%s boosts must be >= 0 (0 keeps the configured value)", label)
+			}
+			if arm.Rerank != nil && *arm.Rerank && c.RerankHost == "" {
+				fail("%s RERANK needs RERANK_HOST", label)
+			}
+		}
+		if total > 100 {
+			fail("RETRIEVAL_EXPERIMENT_ARMS percentages sum to %d%%; the total must be at most 100%%", total)
+		}
+	}
+
+	seenPolicies := make(map[string]bool)
+	for i, policy := range c.RetrievalPolicies {
+		name := strings.ToLower(strings.TrimSpace(policy.Name))
+		label := fmt.Sprintf("RETRIEVAL_POLICIES[%d]", i)
+		switch {
+		case name == "":
+			fail("%s needs a NAME", label)
+		case seenPolicies[name]:
+			fail("%s: duplicate policy name %q", label, name)
+		}
+		seenPolicies[name] = true
+		if policy.SemanticWeight != 0 || policy.BM25Weight != 0 {
+			checkHybridWeights(fail, label+" SEMANTIC_WEIGHT", label+" BM25_WEIGHT", policy.SemanticWeight, policy.BM25Weight)
+		}
+		if policy.FactBoost < 0 || policy.SummaryBoost < 0 || policy.DocumentBoost < 0 || policy.StateBoost < 0 || policy.PDFSummaryBoost < 0 || policy.AnnotationBoost < 0 {
+			fail("%s boosts must be >= 0 (0 keeps the inherited value)", label)
+		}
+		ratio(label+" ERROR_PENALTY", policy.ErrorPenalty, false, true)
+		ratio(label+" SEMANTIC_THRESHOLD", policy.SemanticThreshold, false, false)
+		if policy.BM25Threshold < 0 {
+			fail("%s BM25_THRESHOLD must be >= 0 (got %v)", label, policy.BM25Threshold)
+		}
+		if policy.CandidateMultiplier < 0 || policy.MinCandidates < 0 || policy.MaxCandidates < 0 {
+			fail("%s CANDIDATE_MULTIPLIER, MIN_CANDIDATES and MAX_CANDIDATES must be >= 0", label)
+		}
+		if policy.MinCandidates > 0 && policy.MaxCandidates > 0 && policy.MinCandidates > policy.MaxCandidates {
+			fail("%s MIN_CANDIDATES (%d) must not exceed MAX_CANDIDATES (%d)", label, policy.MinCandidates, policy.MaxCandidates)
+		}
+	}
+
+	// Tracing
+	if c.TracingEnabled {
+		if c.TracingOTLPEndpoint == "" {
+			fail("TRACING_OTLP_ENDPOINT must be set when TRACING_ENABLED is true")
+		}
+		ratio("TRACING_SAMPLE_RATIO", c.TracingSampleRatio, false, false)
+	}
+
+	// Failure injection
+	if c.ChaosEnabled {
+		ratio("CHAOS_LLM_TIMEOUT_RATE", c.ChaosLLMTimeoutRate, false, false)
+		ratio("CHAOS_EMBEDDING_ERROR_RATE", c.ChaosEmbeddingErrorRate, false, false)
+		ratio("CHAOS_EXECUTOR_DISCONNECT_RATE", c.ChaosExecutorDisconnectRate, false, false)
+		ratio("CHAOS_DB_LATENCY_RATE", c.ChaosDBLatencyRate, false, false)
+		if c.ChaosDBLatency < 0 {
+			fail("CHAOS_DB_LATENCY_MS must be >= 0")
+		}
+	}
+
+	return errs
+}
+
+// checkHybridWeights reports hybrid score weights that are negative, both zero, or do
+// not sum to 1; scores would otherwise drift against the similarity thresholds.
+func checkHybridWeights(fail func(string, ...interface{}), semanticKey, bm25Key string, semantic, bm25 float64) {
+	if semantic < 0 || bm25 < 0 {
+		fail("%s and %s must be >= 0 (got %v, %v)", semanticKey, bm25Key, semantic, bm25)
+		return
+	}
+	if semantic == 0 && bm25 == 0 {
+		fail("%s and %s cannot both be 0", semanticKey, bm25Key)
+		return
+	}
+	if math.Abs(semantic+bm25-1) > hybridWeightTolerance {
+		fail("%s (%v) + %s (%v) must sum to 1 (got %v)", semanticKey, semantic, bm25Key, bm25, semantic+bm25)
+	}
+}
+
+// unknownKeys returns the loaded keys that match no Config field, each with the closest
+// known key as a suggestion when one is near. Viper lowercases keys; results are uppercased.
+func unknownKeys(loaded []string) []string {
+	known := make(map[string]bool)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("mapstructure"); tag != "" {
+			known[strings.ToLower(tag)] = true
+		}
+	}
+
+	var unknown []string
+	seen := make(map[string]bool)
+	for _, key := range loaded {
+		// Nested keys (a.b) belong to their top-level field
+		top := strings.SplitN(key, ".", 2)[0]
+		if known[top] || seen[top] {
+			continue
+		}
+		seen[top] = true
+		entry := strings.ToUpper(top)
+		if suggestion := closestKey(top, known); suggestion != "" {
+			entry += fmt.Sprintf(" (did you mean %s?)", strings.ToUpper(suggestion))
+		}
+		unknown = append(unknown, entry)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// closestKey returns the known key within a small edit distance of key, or "".
+func closestKey(key string, known map[string]bool) string {
+	best, bestDist := "", len(key)/4+1
+	for k := range known {
+		if d := editDistance(key, k); d < bestDist || (d == bestDist && k < best) {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// Action results use only portable SQL, so both backends share it. Rows are appended per
+// execution and read back in insertion order; the latest row for a signature wins.
+
+// SaveActionResult appends an executed action to the session's action history.
+func (s *PostgresStore) SaveActionResult(ctx context.Context, record types.ActionRecord) error {
+	return saveActionResult(ctx, s.DB, record)
+}
+
+// SaveActionResult appends an executed action to the session's action history.
+func (s *SQLiteStore) SaveActionResult(ctx context.Context, record types.ActionRecord) error {
+	return saveActionResult(ctx, s.DB, record)
+}
+
+// GetActionResults returns the session's executed actions, oldest first.
+func (s *PostgresStore) GetActionResults(ctx context.Context, sessionID string) ([]types.ActionRecord, error) {
+	return getActionResults(ctx, s.DB, sessionID)
+}
+
+// GetActionResults returns the session's executed actions, oldest first.
+func (s *SQLiteStore) GetActionResults(ctx context.Context, sessionID string) ([]types.ActionRecord, error) {
+	return getActionResults(ctx, s.DB, sessionID)
+}
+
+// DeleteActionResults forgets the session's executed actions.
+func (s *PostgresStore) DeleteActionResults(ctx context.Context, sessionID string) error {
+	return deleteActionResults(ctx, s.DB, sessionID)
+}
+
+// DeleteActionResults forgets the session's executed actions.
+func (s *SQLiteStore) DeleteActionResults(ctx context.Context, sessionID string) error {
+	return deleteActionResults(ctx, s.DB, sessionID)
+}
+
+func saveActionResult(ctx context.Context, db *sql.DB, record types.ActionRecord) error {
+	sessionID, err := uuid.Parse(record.SessionID)
+	if err != nil {
+		return fmt.Errorf("invalid session ID: %w", err)
+	}
+	query := `
+		INSERT INTO action_results (session_id, signature_hash, signature, output, success, turn, attempt,
+			code_norm_hash, diagnostics, user_edited, superseded_code_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	if _, err := db.ExecContext(ctx, query, sessionID, record.SignatureHash, record.Signature, record.Output,
+		record.Success, record.Turn, record.Attempt, record.CodeNormHash, record.Diagnostics,
+		record.UserEdited, record.SupersededCodeHash); err != nil {
+		return fmt.Errorf("failed to save action result: %w", err)
+	}
+	return nil
+}
+
+func getActionResults(ctx context.Context, db *sql.DB, sessionID string) ([]types.ActionRecord, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session ID: %w", err)
+	}
+	query := `
+		SELECT signature_hash, signature, output, success, turn, attempt,
+			code_norm_hash, diagnostics, user_edited, superseded_code_hash
+		FROM action_results
+		WHERE session_id = $1
+		ORDER BY id
+	`
+	rows, err := db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query action results: %w", err)
+	}
+	defer rows.Close()
+
+	var records []types.ActionRecord
+	for rows.Next() {
+		record := types.ActionRecord{SessionID: sessionID}
+		if err := rows.Scan(&record.SignatureHash, &record.Signature, &record.Output, &record.Success,
+			&record.Turn, &record.Attempt, &record.CodeNormHash, &record.Diagnostics,
+			&record.UserEdited, &record.SupersededCodeHash); err != nil {
+			return nil, fmt.Errorf("failed to scan action result: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read action results: %w", err)
+	}
+	return records, nil
+}
+
+func deleteActionResults(ctx context.Context, db *sql.DB, sessionID string) error {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return fmt.Errorf("invalid session ID: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `DELETE FROM action_results WHERE session_id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete action results: %w", err)
+	}
+	return nil
+}
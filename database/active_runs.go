@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// The active-run registry lets several replicas share one database (MULTI_REPLICA_ENABLED).
+// Its rows are plain, so both backends share the SQL; only the advisory lock differs.
+
+// ClaimActiveRun records the run as the session's active run, replacing any other.
+func (s *PostgresStore) ClaimActiveRun(ctx context.Context, run types.ActiveRun) error {
+	return claimActiveRun(ctx, s.DB, run)
+}
+
+// TouchActiveRun refreshes the run's heartbeat. It reports false when the session's row
+// is gone or belongs to another run: the run was stopped or replaced.
+func (s *PostgresStore) TouchActiveRun(ctx context.Context, sessionID uuid.UUID, token string) (bool, error) {
+	return touchActiveRun(ctx, s.DB, sessionID, token)
+}
+
+// GetActiveRun returns the session's run with a heartbeat since staleBefore, or sql.ErrNoRows.
+func (s *PostgresStore) GetActiveRun(ctx context.Context, sessionID uuid.UUID, staleBefore time.Time) (types.ActiveRun, error) {
+	return getActiveRun(ctx, s.DB, sessionID, staleBefore)
+}
+
+// ReleaseActiveRun removes the session's run if token matches it, or whatever run it has
+// when token is empty.
+func (s *PostgresStore) ReleaseActiveRun(ctx context.Context, sessionID uuid.UUID, token string) error {
+	return releaseActiveRun(ctx, s.DB, sessionID, token)
+}
+
+// WithAdvisoryLock runs fn holding the session-level advisory lock for key, waiting for
+// other holders (on any replica) to finish. The lock lives on one pooled connection,
+// which is returned to the pool afterwards.
+func (s *PostgresStore) WithAdvisoryLock(ctx context.Context, key string, fn func(ctx context.Context) error) error {
+	conn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection for advisory lock: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtextextended($1, 0))`, key); err != nil {
+		return fmt.Errorf("failed to take advisory lock %q: %w", key, err)
+	}
+	defer func() {
+		// Unlock even when ctx is done, or the lock stays held by the pooled connection
+		unlockCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if _, err := conn.ExecContext(unlockCtx, `SELECT pg_advisory_unlock(hashtextextended($1, 0))`, key); err != nil {
+			// Closing the connection for good releases the lock
+			_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+	return fn(ctx)
+}
+
+// ClaimActiveRun records the run as the session's active run, replacing any other.
+func (s *SQLiteStore) ClaimActiveRun(ctx context.Context, run types.ActiveRun) error {
+	return claimActiveRun(ctx, s.DB, run)
+}
+
+// TouchActiveRun refreshes the run's heartbeat. It reports false when the session's row
+// is gone or belongs to another run: the run was stopped or replaced.
+func (s *SQLiteStore) TouchActiveRun(ctx context.Context, sessionID uuid.UUID, token string) (bool, error) {
+	return touchActiveRun(ctx, s.DB, sessionID, token)
+}
+
+// GetActiveRun returns the session's run with a heartbeat since staleBefore, or sql.ErrNoRows.
+func (s *SQLiteStore) GetActiveRun(ctx context.Context, sessionID uuid.UUID, staleBefore time.Time) (types.ActiveRun, error) {
+	return getActiveRun(ctx, s.DB, sessionID, staleBefore)
+}
+
+// ReleaseActiveRun removes the session's run if token matches it, or whatever run it has
+// when token is empty.
+func (s *SQLiteStore) ReleaseActiveRun(ctx context.Context, sessionID uuid.UUID, token string) error {
+	return releaseActiveRun(ctx, s.DB, sessionID, token)
+}
+
+// WithAdvisoryLock runs fn. A SQLite file is served by one process, whose own locking
+// already serializes the work.
+func (s *SQLiteStore) WithAdvisoryLock(ctx context.Context, key string, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func claimActiveRun(ctx context.Context, db *sql.DB, run types.ActiveRun) error {
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO active_runs (session_id, user_message_id, token, instance_id, heartbeat_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (session_id) DO UPDATE
+		SET user_message_id = EXCLUDED.user_message_id, token = EXCLUDED.token,
+		    instance_id = EXCLUDED.instance_id, heartbeat_at = EXCLUDED.heartbeat_at`,
+		run.SessionID, run.UserMessageID, run.Token, run.InstanceID, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to claim active run: %w", err)
+	}
+	return nil
+}
+
+func touchActiveRun(ctx context.Context, db *sql.DB, sessionID uuid.UUID, token string) (bool, error) {
+	res, err := db.ExecContext(ctx,
+		`UPDATE active_runs SET heartbeat_at = $3 WHERE session_id = $1 AND token = $2`,
+		sessionID, token, time.Now().UTC())
+	if err != nil {
+		return false, fmt.Errorf("failed to refresh active run: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+func getActiveRun(ctx context.Context, db *sql.DB, sessionID uuid.UUID, staleBefore time.Time) (types.ActiveRun, error) {
+	var run types.ActiveRun
+	err := db.QueryRowContext(ctx, `
+		SELECT session_id, user_message_id, token, instance_id, heartbeat_at
+		FROM active_runs WHERE session_id = $1 AND heartbeat_at >= $2`,
+		sessionID, staleBefore.UTC()).Scan(&run.SessionID, &run.UserMessageID, &run.Token, &run.InstanceID, &run.HeartbeatAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return types.ActiveRun{}, err
+		}
+		return types.ActiveRun{}, fmt.Errorf("failed to get active run: %w", err)
+	}
+	return run, nil
+}
+
+func releaseActiveRun(ctx context.Context, db *sql.DB, sessionID uuid.UUID, token string) error {
+	var err error
+	if token == "" {
+		_, err = db.ExecContext(ctx, `DELETE FROM active_runs WHERE session_id = $1`, sessionID)
+	} else {
+		_, err = db.ExecContext(ctx, `DELETE FROM active_runs WHERE session_id = $1 AND token = $2`, sessionID, token)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release active run: %w", err)
+	}
+	return nil
+}
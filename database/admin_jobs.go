@@ -0,0 +1,384 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// Admin jobs use only portable SQL (->>, CAST and $N placeholders), so both backends
+// share it.
+
+const adminJobColumns = `id, kind, params, status, resume_after, processed, failed, error, result, created_at, updated_at`
+
+// CreateAdminJob records a pending bulk admin job.
+func (s *PostgresStore) CreateAdminJob(ctx context.Context, kind string, params types.AdminJobParams) (types.AdminJob, error) {
+	return createAdminJob(ctx, s.DB, kind, params)
+}
+
+// GetAdminJob returns an admin job, or sql.ErrNoRows.
+func (s *PostgresStore) GetAdminJob(ctx context.Context, jobID uuid.UUID) (types.AdminJob, error) {
+	return getAdminJob(ctx, s.DB, jobID)
+}
+
+// ListAdminJobs returns the most recent admin jobs, newest first.
+func (s *PostgresStore) ListAdminJobs(ctx context.Context, limit int) ([]types.AdminJob, error) {
+	return queryAdminJobs(ctx, s.DB, `SELECT `+adminJobColumns+` FROM admin_jobs ORDER BY created_at DESC LIMIT $1`, limit)
+}
+
+// ListResumableAdminJobs returns the pending and running admin jobs, oldest first.
+func (s *PostgresStore) ListResumableAdminJobs(ctx context.Context) ([]types.AdminJob, error) {
+	return queryAdminJobs(ctx, s.DB, `SELECT `+adminJobColumns+` FROM admin_jobs WHERE status IN ($1, $2) ORDER BY created_at`,
+		types.AdminJobPending, types.AdminJobRunning)
+}
+
+// SaveAdminJobProgress stores the job's status, cursor, counts, error and result. A job
+// cancelled meanwhile is left as it is; the returned flag reports whether it was saved.
+func (s *PostgresStore) SaveAdminJobProgress(ctx context.Context, job types.AdminJob) (bool, error) {
+	return saveAdminJobProgress(ctx, s.DB, job)
+}
+
+// CancelAdminJob marks a pending or running job cancelled, or returns sql.ErrNoRows.
+func (s *PostgresStore) CancelAdminJob(ctx context.Context, jobID uuid.UUID) error {
+	return cancelAdminJob(ctx, s.DB, jobID)
+}
+
+// ListSessionIDsAfter returns up to limit session IDs greater than after, in ID order,
+// optionally only the user's sessions last active before lastActiveBefore.
+func (s *PostgresStore) ListSessionIDsAfter(ctx context.Context, after uuid.UUID, userID *uuid.UUID, lastActiveBefore *time.Time, limit int) ([]uuid.UUID, error) {
+	return listSessionIDsAfter(ctx, s.DB, after, userID, lastActiveBefore, limit)
+}
+
+// ArchiveSession hides a session from the session list without deleting anything.
+func (s *PostgresStore) ArchiveSession(ctx context.Context, sessionID uuid.UUID) error {
+	return archiveSession(ctx, s.DB, sessionID)
+}
+
+// UpdateMessageContentHashes rewrites the session's message hashes and the references to
+// them in RAG document metadata. See updateMessageContentHashes.
+func (s *PostgresStore) UpdateMessageContentHashes(ctx context.Context, sessionID uuid.UUID, updates []types.MessageHashUpdate) (int64, int64, error) {
+	return updateMessageContentHashes(ctx, s.DB, sessionID, updates)
+}
+
+// GetSessionUsage returns usage rows for up to limit sessions with IDs greater than after.
+func (s *PostgresStore) GetSessionUsage(ctx context.Context, after uuid.UUID, limit int) ([]types.SessionUsage, error) {
+	return getSessionUsage(ctx, s.DB, after, limit)
+}
+
+// CreateAdminJob records a pending bulk admin job.
+func (s *SQLiteStore) CreateAdminJob(ctx context.Context, kind string, params types.AdminJobParams) (types.AdminJob, error) {
+	return createAdminJob(ctx, s.DB, kind, params)
+}
+
+// GetAdminJob returns an admin job, or sql.ErrNoRows.
+func (s *SQLiteStore) GetAdminJob(ctx context.Context, jobID uuid.UUID) (types.AdminJob, error) {
+	return getAdminJob(ctx, s.DB, jobID)
+}
+
+// ListAdminJobs returns the most recent admin jobs, newest first.
+func (s *SQLiteStore) ListAdminJobs(ctx context.Context, limit int) ([]types.AdminJob, error) {
+	return queryAdminJobs(ctx, s.DB, `SELECT `+adminJobColumns+` FROM admin_jobs ORDER BY created_at DESC LIMIT $1`, limit)
+}
+
+// ListResumableAdminJobs returns the pending and running admin jobs, oldest first.
+func (s *SQLiteStore) ListResumableAdminJobs(ctx context.Context) ([]types.AdminJob, error) {
+	return queryAdminJobs(ctx, s.DB, `SELECT `+adminJobColumns+` FROM admin_jobs WHERE status IN ($1, $2) ORDER BY created_at`,
+		types.AdminJobPending, types.AdminJobRunning)
+}
+
+// SaveAdminJobProgress stores the job's status, cursor, counts, error and result. A job
+// cancelled meanwhile is left as it is; the returned flag reports whether it was saved.
+func (s *SQLiteStore) SaveAdminJobProgress(ctx context.Context, job types.AdminJob) (bool, error) {
+	return saveAdminJobProgress(ctx, s.DB, job)
+}
+
+// CancelAdminJob marks a pending or running job cancelled, or returns sql.ErrNoRows.
+func (s *SQLiteStore) CancelAdminJob(ctx context.Context, jobID uuid.UUID) error {
+	return cancelAdminJob(ctx, s.DB, jobID)
+}
+
+// ListSessionIDsAfter returns up to limit session IDs greater than after, in ID order,
+// optionally only the user's sessions last active before lastActiveBefore.
+func (s *SQLiteStore) ListSessionIDsAfter(ctx context.Context, after uuid.UUID, userID *uuid.UUID, lastActiveBefore *time.Time, limit int) ([]uuid.UUID, error) {
+	return listSessionIDsAfter(ctx, s.DB, after, userID, lastActiveBefore, limit)
+}
+
+// ArchiveSession hides a session from the session list without deleting anything.
+func (s *SQLiteStore) ArchiveSession(ctx context.Context, sessionID uuid.UUID) error {
+	return archiveSession(ctx, s.DB, sessionID)
+}
+
+// UpdateMessageContentHashes rewrites the session's message hashes and the references to
+// them in RAG document metadata. See updateMessageContentHashes.
+func (s *SQLiteStore) UpdateMessageContentHashes(ctx context.Context, sessionID uuid.UUID, updates []types.MessageHashUpdate) (int64, int64, error) {
+	return updateMessageContentHashes(ctx, s.DB, sessionID, updates)
+}
+
+// GetSessionUsage returns usage rows for up to limit sessions with IDs greater than after.
+func (s *SQLiteStore) GetSessionUsage(ctx context.Context, after uuid.UUID, limit int) ([]types.SessionUsage, error) {
+	return getSessionUsage(ctx, s.DB, after, limit)
+}
+
+func createAdminJob(ctx context.Context, db *sql.DB, kind string, params types.AdminJobParams) (types.AdminJob, error) {
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return types.AdminJob{}, fmt.Errorf("failed to encode admin job params: %w", err)
+	}
+	now := time.Now().UTC()
+	job := types.AdminJob{ID: uuid.New(), Kind: kind, Params: params, Status: types.AdminJobPending, CreatedAt: now, UpdatedAt: now}
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO admin_jobs (id, kind, params, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		job.ID, kind, string(paramsJSON), job.Status, now, now); err != nil {
+		return types.AdminJob{}, fmt.Errorf("failed to save admin job: %w", err)
+	}
+	return job, nil
+}
+
+func getAdminJob(ctx context.Context, db *sql.DB, jobID uuid.UUID) (types.AdminJob, error) {
+	jobs, err := queryAdminJobs(ctx, db, `SELECT `+adminJobColumns+` FROM admin_jobs WHERE id = $1`, jobID)
+	if err != nil {
+		return types.AdminJob{}, err
+	}
+	if len(jobs) == 0 {
+		return types.AdminJob{}, sql.ErrNoRows
+	}
+	return jobs[0], nil
+}
+
+func queryAdminJobs(ctx context.Context, db *sql.DB, query string, args ...any) ([]types.AdminJob, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admin jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []types.AdminJob
+	for rows.Next() {
+		var job types.AdminJob
+		var params []byte
+		if err := rows.Scan(&job.ID, &job.Kind, &params, &job.Status, &job.Cursor, &job.Processed, &job.Failed,
+			&job.Error, &job.Result, &job.CreatedAt, &job.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan admin job: %w", err)
+		}
+		if err := json.Unmarshal(params, &job.Params); err != nil {
+			return nil, fmt.Errorf("failed to decode params of admin job %s: %w", job.ID, err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating admin jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+func saveAdminJobProgress(ctx context.Context, db *sql.DB, job types.AdminJob) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		UPDATE admin_jobs
+		SET status = $2, resume_after = $3, processed = $4, failed = $5, error = $6, result = $7, updated_at = $8
+		WHERE id = $1 AND status IN ($9, $10)`,
+		job.ID, job.Status, job.Cursor, job.Processed, job.Failed, job.Error, job.Result, time.Now().UTC(),
+		types.AdminJobPending, types.AdminJobRunning)
+	if err != nil {
+		return false, fmt.Errorf("failed to save admin job progress: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n > 0, nil
+}
+
+func cancelAdminJob(ctx context.Context, db *sql.DB, jobID uuid.UUID) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE admin_jobs SET status = $2, updated_at = $3
+		WHERE id = $1 AND status IN ($4, $5)`,
+		jobID, types.AdminJobCancelled, time.Now().UTC(), types.AdminJobPending, types.AdminJobRunning)
+	if err != nil {
+		return fmt.Errorf("failed to cancel admin job: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func listSessionIDsAfter(ctx context.Context, db *sql.DB, after uuid.UUID, userID *uuid.UUID, lastActiveBefore *time.Time, limit int) ([]uuid.UUID, error) {
+	conditions := []string{"id > $1"}
+	args := []any{after}
+	if userID != nil {
+		args = append(args, *userID)
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	if lastActiveBefore != nil {
+		args = append(args, *lastActiveBefore)
+		conditions = append(conditions, fmt.Sprintf("last_active < $%d", len(args)))
+	}
+	args = append(args, limit)
+	query := fmt.Sprintf(`SELECT id FROM sessions WHERE %s ORDER BY id LIMIT $%d`, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan session ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+	return ids, nil
+}
+
+func archiveSession(ctx context.Context, db *sql.DB, sessionID uuid.UUID) error {
+	if _, err := db.ExecContext(ctx, `UPDATE sessions SET is_active = $2 WHERE id = $1`, sessionID, false); err != nil {
+		return fmt.Errorf("failed to archive session: %w", err)
+	}
+	return nil
+}
+
+// updateMessageContentHashes stores new content hashes for the session's messages and
+// rewrites the message_hash, tool_content_hash and source_content_hash references in its
+// RAG documents, in one transaction, so retraction and history exclusion keep matching.
+// Returns the number of messages and documents updated.
+func updateMessageContentHashes(ctx context.Context, db *sql.DB, sessionID uuid.UUID, updates []types.MessageHashUpdate) (int64, int64, error) {
+	if len(updates) == 0 {
+		return 0, 0, nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin hash update: %w", err)
+	}
+	defer tx.Rollback()
+
+	var messages, documents int64
+	remap := make(map[string]string, len(updates))
+	for _, u := range updates {
+		res, err := tx.ExecContext(ctx, `UPDATE messages SET content_hash = $3 WHERE session_id = $1 AND id = $2`, sessionID, u.MessageID, u.NewHash)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to update hash of message %s: %w", u.MessageID, err)
+		}
+		n, _ := res.RowsAffected()
+		messages += n
+		if u.OldHash != "" {
+			remap[u.OldHash] = u.NewHash
+		}
+	}
+
+	olds := make([]string, 0, len(remap))
+	for old := range remap {
+		olds = append(olds, old)
+	}
+	hashKeys := []string{"message_hash", "tool_content_hash", "source_content_hash"}
+	var conditions []string
+	args := []any{sessionID.String()}
+	for _, key := range hashKeys {
+		in, vals := inClause(len(args)+1, olds)
+		conditions = append(conditions, "(metadata ->> '"+key+"') IN ("+in+")")
+		args = append(args, vals...)
+	}
+	if len(olds) > 0 {
+		type doc struct {
+			id       uuid.UUID
+			metadata []byte
+		}
+		rows, err := tx.QueryContext(ctx, `SELECT id, metadata FROM rag_documents WHERE (metadata ->> 'session_id') = $1 AND (`+strings.Join(conditions, " OR ")+`)`, args...)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to find documents referencing old hashes: %w", err)
+		}
+		var docs []doc
+		for rows.Next() {
+			var d doc
+			if err := rows.Scan(&d.id, &d.metadata); err != nil {
+				rows.Close()
+				return 0, 0, fmt.Errorf("failed to scan document: %w", err)
+			}
+			docs = append(docs, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, 0, fmt.Errorf("error iterating documents: %w", err)
+		}
+
+		for _, d := range docs {
+			metadata := make(map[string]any)
+			if err := json.Unmarshal(d.metadata, &metadata); err != nil {
+				return 0, 0, fmt.Errorf("failed to decode metadata of document %s: %w", d.id, err)
+			}
+			for _, key := range hashKeys {
+				if old, ok := metadata[key].(string); ok && remap[old] != "" {
+					metadata[key] = remap[old]
+				}
+			}
+			metaJSON, err := json.Marshal(metadata)
+			if err != nil {
+				return 0, 0, fmt.Errorf("failed to encode metadata of document %s: %w", d.id, err)
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE rag_documents SET metadata = $2 WHERE id = $1`, d.id, string(metaJSON)); err != nil {
+				return 0, 0, fmt.Errorf("failed to update metadata of document %s: %w", d.id, err)
+			}
+			documents++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit hash update: %w", err)
+	}
+	return messages, documents, nil
+}
+
+func getSessionUsage(ctx context.Context, db *sql.DB, after uuid.UUID, limit int) ([]types.SessionUsage, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT s.id, s.user_id, COALESCE(s.mode, 'dataset'), s.created_at, s.last_active, s.is_active,
+		       (SELECT COUNT(*) FROM messages m WHERE m.session_id = s.id AND m.role = 'user'),
+		       (SELECT COUNT(*) FROM messages m WHERE m.session_id = s.id AND m.role = 'assistant'),
+		       (SELECT COUNT(*) FROM messages m WHERE m.session_id = s.id AND m.role = 'tool'),
+		       (SELECT COUNT(*) FROM files f WHERE f.session_id = s.id),
+		       (SELECT COUNT(*) FROM rag_documents d WHERE (d.metadata ->> 'session_id') = CAST(s.id AS TEXT))
+		FROM sessions s
+		WHERE s.id > $1
+		ORDER BY s.id
+		LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []types.SessionUsage
+	for rows.Next() {
+		var u types.SessionUsage
+		var userID sql.NullString
+		if err := rows.Scan(&u.SessionID, &userID, &u.Mode, &u.CreatedAt, &u.LastActive, &u.IsActive,
+			&u.UserMessages, &u.AssistantMessages, &u.ToolMessages, &u.Files, &u.Documents); err != nil {
+			return nil, fmt.Errorf("failed to scan session usage: %w", err)
+		}
+		if userID.Valid {
+			if parsed, err := uuid.Parse(userID.String); err == nil {
+				u.UserID = &parsed
+			}
+		}
+		usage = append(usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating session usage: %w", err)
+	}
+	return usage, nil
+}
@@ -0,0 +1,170 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// Analysis jobs are plain rows, so both backends share the SQL. Listing leaves out the
+// events, which can run to megabytes for a long job.
+
+const analysisJobColumns = `id, session_id, user_message_id, status, error, created_at, updated_at, finished_at`
+
+// CreateAnalysisJob records a running background job for a user message.
+func (s *PostgresStore) CreateAnalysisJob(ctx context.Context, sessionID uuid.UUID, userMessageID string) (types.AnalysisJob, error) {
+	return createAnalysisJob(ctx, s.DB, sessionID, userMessageID)
+}
+
+// GetAnalysisJob returns one of the session's jobs with its events, or sql.ErrNoRows.
+func (s *PostgresStore) GetAnalysisJob(ctx context.Context, sessionID, jobID uuid.UUID) (types.AnalysisJob, error) {
+	return getAnalysisJob(ctx, s.DB, sessionID, jobID)
+}
+
+// ListAnalysisJobs returns the session's most recent jobs without their events, newest first.
+func (s *PostgresStore) ListAnalysisJobs(ctx context.Context, sessionID uuid.UUID, limit int) ([]types.AnalysisJob, error) {
+	return listAnalysisJobs(ctx, s.DB, sessionID, limit)
+}
+
+// SaveAnalysisJob stores the job's status, error, events and finish time.
+func (s *PostgresStore) SaveAnalysisJob(ctx context.Context, job types.AnalysisJob) error {
+	return saveAnalysisJob(ctx, s.DB, job)
+}
+
+// FailInterruptedAnalysisJobs marks running jobs last saved before staleBefore failed
+// with reason: their process is gone.
+func (s *PostgresStore) FailInterruptedAnalysisJobs(ctx context.Context, reason string, staleBefore time.Time) (int64, error) {
+	return failInterruptedAnalysisJobs(ctx, s.DB, reason, staleBefore)
+}
+
+// CreateAnalysisJob records a running background job for a user message.
+func (s *SQLiteStore) CreateAnalysisJob(ctx context.Context, sessionID uuid.UUID, userMessageID string) (types.AnalysisJob, error) {
+	return createAnalysisJob(ctx, s.DB, sessionID, userMessageID)
+}
+
+// GetAnalysisJob returns one of the session's jobs with its events, or sql.ErrNoRows.
+func (s *SQLiteStore) GetAnalysisJob(ctx context.Context, sessionID, jobID uuid.UUID) (types.AnalysisJob, error) {
+	return getAnalysisJob(ctx, s.DB, sessionID, jobID)
+}
+
+// ListAnalysisJobs returns the session's most recent jobs without their events, newest first.
+func (s *SQLiteStore) ListAnalysisJobs(ctx context.Context, sessionID uuid.UUID, limit int) ([]types.AnalysisJob, error) {
+	return listAnalysisJobs(ctx, s.DB, sessionID, limit)
+}
+
+// SaveAnalysisJob stores the job's status, error, events and finish time.
+func (s *SQLiteStore) SaveAnalysisJob(ctx context.Context, job types.AnalysisJob) error {
+	return saveAnalysisJob(ctx, s.DB, job)
+}
+
+// FailInterruptedAnalysisJobs marks running jobs last saved before staleBefore failed
+// with reason: their process is gone.
+func (s *SQLiteStore) FailInterruptedAnalysisJobs(ctx context.Context, reason string, staleBefore time.Time) (int64, error) {
+	return failInterruptedAnalysisJobs(ctx, s.DB, reason, staleBefore)
+}
+
+func createAnalysisJob(ctx context.Context, db *sql.DB, sessionID uuid.UUID, userMessageID string) (types.AnalysisJob, error) {
+	job := types.AnalysisJob{
+		ID:            uuid.New(),
+		SessionID:     sessionID,
+		UserMessageID: userMessageID,
+		Status:        types.AnalysisJobRunning,
+		CreatedAt:     time.Now().UTC(),
+	}
+	job.UpdatedAt = job.CreatedAt
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO analysis_jobs (id, session_id, user_message_id, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		job.ID, job.SessionID, job.UserMessageID, job.Status, job.CreatedAt, job.UpdatedAt); err != nil {
+		return types.AnalysisJob{}, fmt.Errorf("failed to save analysis job: %w", err)
+	}
+	return job, nil
+}
+
+func getAnalysisJob(ctx context.Context, db *sql.DB, sessionID, jobID uuid.UUID) (types.AnalysisJob, error) {
+	row := db.QueryRowContext(ctx,
+		`SELECT `+analysisJobColumns+`, events FROM analysis_jobs WHERE id = $1 AND session_id = $2`,
+		jobID, sessionID)
+	job, err := scanAnalysisJob(row, true)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return types.AnalysisJob{}, err
+		}
+		return types.AnalysisJob{}, fmt.Errorf("failed to get analysis job: %w", err)
+	}
+	return job, nil
+}
+
+func listAnalysisJobs(ctx context.Context, db *sql.DB, sessionID uuid.UUID, limit int) ([]types.AnalysisJob, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT `+analysisJobColumns+` FROM analysis_jobs WHERE session_id = $1 ORDER BY created_at DESC LIMIT $2`,
+		sessionID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query analysis jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []types.AnalysisJob
+	for rows.Next() {
+		job, err := scanAnalysisJob(rows, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan analysis job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating analysis jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+func scanAnalysisJob(row interface{ Scan(...any) error }, withEvents bool) (types.AnalysisJob, error) {
+	var job types.AnalysisJob
+	var finishedAt sql.NullTime
+	dest := []any{&job.ID, &job.SessionID, &job.UserMessageID, &job.Status, &job.Error, &job.CreatedAt, &job.UpdatedAt, &finishedAt}
+	if withEvents {
+		dest = append(dest, &job.Events)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return types.AnalysisJob{}, err
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return job, nil
+}
+
+func saveAnalysisJob(ctx context.Context, db *sql.DB, job types.AnalysisJob) error {
+	var finishedAt sql.NullTime
+	if job.FinishedAt != nil {
+		finishedAt = sql.NullTime{Time: job.FinishedAt.UTC(), Valid: true}
+	}
+	if _, err := db.ExecContext(ctx, `
+		UPDATE analysis_jobs SET status = $2, error = $3, events = $4, finished_at = $5, updated_at = $6
+		WHERE id = $1`,
+		job.ID, job.Status, job.Error, job.Events, finishedAt, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to save analysis job: %w", err)
+	}
+	return nil
+}
+
+func failInterruptedAnalysisJobs(ctx context.Context, db *sql.DB, reason string, staleBefore time.Time) (int64, error) {
+	now := time.Now().UTC()
+	res, err := db.ExecContext(ctx, `
+		UPDATE analysis_jobs SET status = $1, error = $2, finished_at = $3, updated_at = $3
+		WHERE status = $4 AND updated_at < $5`,
+		types.AnalysisJobFailed, reason, now, types.AnalysisJobRunning, staleBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to fail interrupted analysis jobs: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n, nil
+}
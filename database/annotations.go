@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// Message annotations are plain rows like step bookmarks, so both backends share the SQL.
+
+// CreateMessageAnnotation stores a user's note on a message and returns it.
+func (s *PostgresStore) CreateMessageAnnotation(ctx context.Context, annotation types.MessageAnnotation) (types.MessageAnnotation, error) {
+	return createMessageAnnotation(ctx, s.DB, annotation)
+}
+
+// GetMessageAnnotations returns the session's annotations, oldest first.
+func (s *PostgresStore) GetMessageAnnotations(ctx context.Context, sessionID uuid.UUID) ([]types.MessageAnnotation, error) {
+	return getMessageAnnotations(ctx, s.DB, sessionID)
+}
+
+// GetMessageAnnotation returns one of the session's annotations, or sql.ErrNoRows.
+func (s *PostgresStore) GetMessageAnnotation(ctx context.Context, sessionID, annotationID uuid.UUID) (types.MessageAnnotation, error) {
+	return getMessageAnnotation(ctx, s.DB, sessionID, annotationID)
+}
+
+// DeleteMessageAnnotation removes one of the session's annotations.
+func (s *PostgresStore) DeleteMessageAnnotation(ctx context.Context, sessionID, annotationID uuid.UUID) error {
+	return deleteMessageAnnotation(ctx, s.DB, sessionID, annotationID)
+}
+
+// CreateMessageAnnotation stores a user's note on a message and returns it.
+func (s *SQLiteStore) CreateMessageAnnotation(ctx context.Context, annotation types.MessageAnnotation) (types.MessageAnnotation, error) {
+	return createMessageAnnotation(ctx, s.DB, annotation)
+}
+
+// GetMessageAnnotations returns the session's annotations, oldest first.
+func (s *SQLiteStore) GetMessageAnnotations(ctx context.Context, sessionID uuid.UUID) ([]types.MessageAnnotation, error) {
+	return getMessageAnnotations(ctx, s.DB, sessionID)
+}
+
+// GetMessageAnnotation returns one of the session's annotations, or sql.ErrNoRows.
+func (s *SQLiteStore) GetMessageAnnotation(ctx context.Context, sessionID, annotationID uuid.UUID) (types.MessageAnnotation, error) {
+	return getMessageAnnotation(ctx, s.DB, sessionID, annotationID)
+}
+
+// DeleteMessageAnnotation removes one of the session's annotations.
+func (s *SQLiteStore) DeleteMessageAnnotation(ctx context.Context, sessionID, annotationID uuid.UUID) error {
+	return deleteMessageAnnotation(ctx, s.DB, sessionID, annotationID)
+}
+
+const messageAnnotationColumns = `id, session_id, message_id, note, rag_document_id, created_at`
+
+func createMessageAnnotation(ctx context.Context, db *sql.DB, annotation types.MessageAnnotation) (types.MessageAnnotation, error) {
+	if annotation.ID == uuid.Nil {
+		annotation.ID = uuid.New()
+	}
+	var ragDocumentID uuid.NullUUID
+	if annotation.RAGDocumentID != nil {
+		ragDocumentID = uuid.NullUUID{UUID: *annotation.RAGDocumentID, Valid: true}
+	}
+	query := `
+		INSERT INTO message_annotations (id, session_id, message_id, note, rag_document_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at
+	`
+	err := db.QueryRowContext(ctx, query, annotation.ID, annotation.SessionID, annotation.MessageID, annotation.Note, ragDocumentID, time.Now().UTC()).
+		Scan(&annotation.CreatedAt)
+	if err != nil {
+		return types.MessageAnnotation{}, fmt.Errorf("failed to save message annotation: %w", err)
+	}
+	return annotation, nil
+}
+
+func getMessageAnnotations(ctx context.Context, db *sql.DB, sessionID uuid.UUID) ([]types.MessageAnnotation, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+messageAnnotationColumns+`
+		FROM message_annotations
+		WHERE session_id = $1
+		ORDER BY created_at, id`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message annotations: %w", err)
+	}
+	defer rows.Close()
+
+	var annotations []types.MessageAnnotation
+	for rows.Next() {
+		a, err := scanMessageAnnotation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message annotation: %w", err)
+		}
+		annotations = append(annotations, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message annotations: %w", err)
+	}
+	return annotations, nil
+}
+
+func getMessageAnnotation(ctx context.Context, db *sql.DB, sessionID, annotationID uuid.UUID) (types.MessageAnnotation, error) {
+	row := db.QueryRowContext(ctx, `
+		SELECT `+messageAnnotationColumns+`
+		FROM message_annotations
+		WHERE id = $1 AND session_id = $2`, annotationID, sessionID)
+	a, err := scanMessageAnnotation(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return types.MessageAnnotation{}, sql.ErrNoRows
+		}
+		return types.MessageAnnotation{}, fmt.Errorf("failed to get message annotation: %w", err)
+	}
+	return a, nil
+}
+
+func deleteMessageAnnotation(ctx context.Context, db *sql.DB, sessionID, annotationID uuid.UUID) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM message_annotations WHERE id = $1 AND session_id = $2`, annotationID, sessionID); err != nil {
+		return fmt.Errorf("failed to delete message annotation: %w", err)
+	}
+	return nil
+}
+
+func scanMessageAnnotation(row interface{ Scan(...any) error }) (types.MessageAnnotation, error) {
+	var a types.MessageAnnotation
+	var ragDocumentID uuid.NullUUID
+	if err := row.Scan(&a.ID, &a.SessionID, &a.MessageID, &a.Note, &ragDocumentID, &a.CreatedAt); err != nil {
+		return types.MessageAnnotation{}, err
+	}
+	if ragDocumentID.Valid {
+		id := ragDocumentID.UUID
+		a.RAGDocumentID = &id
+	}
+	return a, nil
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pgvector/pgvector-go"
+)
+
+// FactEmbedding is a fact document paired with its first embedding window.
+type FactEmbedding struct {
+	DocumentID uuid.UUID
+	Content    string
+	Metadata   map[string]string
+	Embedding  []float32
+	CreatedAt  time.Time
+}
+
+// ListSessionFactEmbeddings returns a session's fact documents with their primary
+// (window 0) embedding, newest first.
+func (s *PostgresStore) ListSessionFactEmbeddings(ctx context.Context, sessionID string) ([]FactEmbedding, error) {
+	query := `
+		SELECT rd.id, rd.content, rd.metadata, re.embedding, rd.created_at
+		FROM rag_documents rd
+		INNER JOIN rag_embeddings re ON re.document_id = rd.id AND re.window_index = 0
+		WHERE rd.metadata ->> 'session_id' = $1
+		  AND rd.metadata ->> 'role' = 'fact'
+		  AND COALESCE(rd.metadata ->> 'type', '') = ''
+		ORDER BY rd.created_at DESC
+	`
+
+	rows, err := s.DB.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fact embeddings for session %s: %w", sessionID, err)
+	}
+	defer rows.Close()
+
+	var facts []FactEmbedding
+	for rows.Next() {
+		var (
+			fact         FactEmbedding
+			metadataJSON []byte
+			embedding    pgvector.Vector
+		)
+		if err := rows.Scan(&fact.DocumentID, &fact.Content, &metadataJSON, &embedding, &fact.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan fact embedding row: %w", err)
+		}
+
+		fact.Metadata = make(map[string]string)
+		if len(metadataJSON) > 0 {
+			if err := json.Unmarshal(metadataJSON, &fact.Metadata); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal fact metadata: %w", err)
+			}
+		}
+		if slice := embedding.Slice(); len(slice) > 0 {
+			fact.Embedding = make([]float32, len(slice))
+			copy(fact.Embedding, slice)
+		}
+		facts = append(facts, fact)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating fact embedding rows: %w", err)
+	}
+
+	return facts, nil
+}
+
+// ConsolidateFacts records the duplicate IDs on the canonical fact's metadata
+// (consolidated_from), points memory checkpoints that listed a duplicate at the canonical
+// fact, and deletes the duplicates, in a single transaction.
+func (s *PostgresStore) ConsolidateFacts(ctx context.Context, canonicalID uuid.UUID, duplicateIDs []uuid.UUID) error {
+	if len(duplicateIDs) == 0 {
+		return nil
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin fact consolidation: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Merge with provenance already on the canonical fact (from earlier consolidations)
+	var existing string
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(metadata ->> 'consolidated_from', '') FROM rag_documents WHERE id = $1 FOR UPDATE`,
+		canonicalID).Scan(&existing); err != nil {
+		return fmt.Errorf("failed to load canonical fact %s: %w", canonicalID, err)
+	}
+
+	ids := make([]string, 0, len(duplicateIDs))
+	if existing != "" {
+		ids = append(ids, existing)
+	}
+	for _, id := range duplicateIDs {
+		ids = append(ids, id.String())
+	}
+	provenance := strings.Join(ids, ",")
+	count := strings.Count(provenance, ",") + 1
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE rag_documents
+		SET metadata = metadata || jsonb_build_object('consolidated_from', $2::text, 'consolidated_count', $3::text)
+		WHERE id = $1
+	`, canonicalID, provenance, fmt.Sprintf("%d", count)); err != nil {
+		return fmt.Errorf("failed to record provenance on fact %s: %w", canonicalID, err)
+	}
+
+	deleteIDs := make([]string, len(duplicateIDs))
+	for i, id := range duplicateIDs {
+		deleteIDs[i] = id.String()
+	}
+	joinedIDs := strings.Join(deleteIDs, ",")
+
+	// Checkpoints taken before the merge keep the fact in scope through its canonical copy
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO memory_checkpoint_documents (checkpoint_id, document_id, kind, content_hash, state_content)
+		SELECT checkpoint_id, $1, kind, COALESCE((SELECT content_hash FROM rag_documents WHERE id = $1), ''), state_content
+		FROM memory_checkpoint_documents
+		WHERE document_id::text = ANY(string_to_array($2, ','))
+		ON CONFLICT DO NOTHING
+	`, canonicalID, joinedIDs); err != nil {
+		return fmt.Errorf("failed to repoint checkpoints to fact %s: %w", canonicalID, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM memory_checkpoint_documents WHERE document_id::text = ANY(string_to_array($1, ','))`, joinedIDs); err != nil {
+		return fmt.Errorf("failed to drop consolidated facts from checkpoints: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM rag_documents WHERE id::text = ANY(string_to_array($1, ','))`, joinedIDs); err != nil {
+		return fmt.Errorf("failed to delete consolidated facts: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit fact consolidation: %w", err)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package database
 import (
     "context"
     "database/sql"
+    "encoding/json"
     "errors"
     "fmt"
     "path/filepath"
@@ -15,10 +16,11 @@ import (
 )
 
 type PostgresStore struct {
-	DB *sql.DB
+	DB          *sql.DB
+	vectorIndex VectorIndexOptions
 }
 
-func NewPostgresStore(connStr string) (*PostgresStore, error) {
+func NewPostgresStore(connStr string, vectorIndex VectorIndexOptions) (*PostgresStore, error) {
 	db, err := sql.Open("pgx", connStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %w", err)
@@ -26,7 +28,7 @@ func NewPostgresStore(connStr string) (*PostgresStore, error) {
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
-	return &PostgresStore{DB: db}, nil
+	return &PostgresStore{DB: db, vectorIndex: vectorIndex}, nil
 }
 
 // EnsureSchema creates the required tables if they do not already exist.
@@ -50,10 +52,35 @@ func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
             workspace_path TEXT NOT NULL,
             title TEXT DEFAULT '',
             is_active BOOLEAN DEFAULT TRUE,
-            mode TEXT DEFAULT 'dataset'
+            mode TEXT DEFAULT 'dataset',
+            verbosity TEXT DEFAULT 'standard',
+            effect_size_check TEXT DEFAULT 'note',
+            tags JSONB DEFAULT '[]'::jsonb,
+            random_seed BIGINT,
+            llm_model TEXT DEFAULT '',
+            retrieval_policy TEXT DEFAULT '',
+            abstract TEXT DEFAULT ''
         )`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
+		`CREATE TABLE IF NOT EXISTS user_identities (
+            provider TEXT NOT NULL,
+            subject TEXT NOT NULL,
+            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            email TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMPTZ DEFAULT NOW(),
+            PRIMARY KEY (provider, subject)
+        )`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_last_active ON sessions(last_active DESC)`,
+		`CREATE TABLE IF NOT EXISTS session_permissions (
+            id UUID PRIMARY KEY,
+            session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            role TEXT NOT NULL,
+            created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+            created_at TIMESTAMPTZ DEFAULT NOW(),
+            expires_at TIMESTAMPTZ,
+            revoked_at TIMESTAMPTZ
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_session_permissions_session ON session_permissions(session_id)`,
 		`CREATE TABLE IF NOT EXISTS messages (
             id UUID PRIMARY KEY,
             session_id UUID REFERENCES sessions(id) ON DELETE CASCADE,
@@ -70,7 +97,10 @@ func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
             content TEXT NOT NULL,
             content_hash TEXT,
             metadata JSONB DEFAULT '{}'::jsonb,
-            created_at TIMESTAMPTZ DEFAULT NOW()
+            created_at TIMESTAMPTZ DEFAULT NOW(),
+            tier TEXT NOT NULL DEFAULT 'hot',
+            archived_at TIMESTAMPTZ,
+            dataset TEXT NOT NULL DEFAULT ''
         )`,
 		`CREATE TABLE IF NOT EXISTS rag_embeddings (
             id UUID PRIMARY KEY,
@@ -92,8 +122,127 @@ func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
             file_size BIGINT,
             created_at TIMESTAMPTZ DEFAULT NOW(),
             message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+            column_types JSONB DEFAULT '{}'::jsonb,
+            alt_text TEXT DEFAULT '',
             CONSTRAINT unique_session_filename UNIQUE(session_id, filename)
         )`,
+		`CREATE TABLE IF NOT EXISTS retrieval_experiment_events (
+            id BIGSERIAL PRIMARY KEY,
+            session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            arm TEXT NOT NULL,
+            signal TEXT NOT NULL,
+            value DOUBLE PRECISION NOT NULL,
+            created_at TIMESTAMPTZ DEFAULT NOW()
+        )`,
+		`CREATE TABLE IF NOT EXISTS run_turns (
+            id BIGSERIAL PRIMARY KEY,
+            session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            run_id UUID NOT NULL,
+            turn INTEGER NOT NULL,
+            query TEXT,
+            retrieval TEXT,
+            messages JSONB NOT NULL,
+            prompt_version TEXT,
+            host TEXT,
+            temperature DOUBLE PRECISION,
+            response TEXT,
+            created_at TIMESTAMPTZ DEFAULT NOW()
+        )`,
+		`CREATE TABLE IF NOT EXISTS step_bookmarks (
+            id UUID PRIMARY KEY,
+            session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            message_id UUID NOT NULL,
+            label TEXT,
+            created_at TIMESTAMPTZ DEFAULT NOW(),
+            UNIQUE (session_id, message_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS message_annotations (
+            id UUID PRIMARY KEY,
+            session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            message_id UUID NOT NULL,
+            note TEXT NOT NULL,
+            rag_document_id UUID,
+            created_at TIMESTAMPTZ DEFAULT NOW()
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_message_annotations_session ON message_annotations(session_id, created_at)`,
+		`CREATE TABLE IF NOT EXISTS session_redirects (
+            old_session_id UUID PRIMARY KEY,
+            session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            created_at TIMESTAMPTZ DEFAULT NOW()
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_session_redirects_session ON session_redirects(session_id)`,
+		`CREATE TABLE IF NOT EXISTS action_results (
+            id BIGSERIAL PRIMARY KEY,
+            session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            signature_hash TEXT NOT NULL,
+            signature JSONB NOT NULL,
+            output TEXT NOT NULL DEFAULT '',
+            success BOOLEAN NOT NULL,
+            turn INTEGER NOT NULL DEFAULT 0,
+            attempt INTEGER NOT NULL DEFAULT 0,
+            code_norm_hash TEXT NOT NULL DEFAULT '',
+            diagnostics TEXT NOT NULL DEFAULT '',
+            user_edited BOOLEAN NOT NULL DEFAULT FALSE,
+            superseded_code_hash TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMPTZ DEFAULT NOW()
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_action_results_session ON action_results(session_id, id)`,
+		`CREATE TABLE IF NOT EXISTS admin_jobs (
+            id UUID PRIMARY KEY,
+            kind TEXT NOT NULL,
+            params JSONB NOT NULL DEFAULT '{}',
+            status TEXT NOT NULL,
+            resume_after TEXT NOT NULL DEFAULT '',
+            processed INTEGER NOT NULL DEFAULT 0,
+            failed INTEGER NOT NULL DEFAULT 0,
+            error TEXT NOT NULL DEFAULT '',
+            result TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMPTZ DEFAULT NOW(),
+            updated_at TIMESTAMPTZ DEFAULT NOW()
+        )`,
+		`CREATE TABLE IF NOT EXISTS analysis_jobs (
+            id UUID PRIMARY KEY,
+            session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            user_message_id TEXT NOT NULL,
+            status TEXT NOT NULL,
+            error TEXT NOT NULL DEFAULT '',
+            events TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMPTZ DEFAULT NOW(),
+            updated_at TIMESTAMPTZ DEFAULT NOW(),
+            finished_at TIMESTAMPTZ
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_analysis_jobs_session ON analysis_jobs(session_id, created_at DESC)`,
+		`CREATE TABLE IF NOT EXISTS active_runs (
+            session_id UUID PRIMARY KEY REFERENCES sessions(id) ON DELETE CASCADE,
+            user_message_id TEXT NOT NULL,
+            token TEXT NOT NULL,
+            instance_id TEXT NOT NULL,
+            heartbeat_at TIMESTAMPTZ NOT NULL
+        )`,
+		`CREATE TABLE IF NOT EXISTS memory_checkpoints (
+            id UUID PRIMARY KEY,
+            session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            name TEXT NOT NULL,
+            created_at TIMESTAMPTZ DEFAULT NOW(),
+            UNIQUE (session_id, name)
+        )`,
+		`CREATE TABLE IF NOT EXISTS memory_checkpoint_documents (
+            checkpoint_id UUID NOT NULL REFERENCES memory_checkpoints(id) ON DELETE CASCADE,
+            document_id UUID NOT NULL,
+            kind TEXT NOT NULL DEFAULT '',
+            content_hash TEXT NOT NULL DEFAULT '',
+            state_content TEXT,
+            PRIMARY KEY (checkpoint_id, document_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS summary_cache (
+            cache_key TEXT PRIMARY KEY,
+            kind TEXT NOT NULL,
+            summary TEXT NOT NULL,
+            hits INTEGER NOT NULL DEFAULT 0,
+            created_at TIMESTAMPTZ NOT NULL,
+            last_used_at TIMESTAMPTZ NOT NULL
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_summary_cache_created ON summary_cache(created_at)`,
 	}
 
 	for _, stmt := range stmts {
@@ -108,6 +257,33 @@ func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
 		// This is a schema migration compatibility step, not a critical operation
 	}
 
+	// Add columns introduced after the initial schema
+	columnMigrations := []string{
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS verbosity TEXT DEFAULT 'standard'`,
+		`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS effect_size_check TEXT DEFAULT 'note'`,
+		`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS tags JSONB DEFAULT '[]'::jsonb`,
+		`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS random_seed BIGINT`,
+		`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS llm_model TEXT DEFAULT ''`,
+		`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS retrieval_policy TEXT DEFAULT ''`,
+		`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS abstract TEXT DEFAULT ''`,
+		`ALTER TABLE files ADD COLUMN IF NOT EXISTS column_types JSONB DEFAULT '{}'::jsonb`,
+		`ALTER TABLE files ADD COLUMN IF NOT EXISTS alt_text TEXT DEFAULT ''`,
+		`ALTER TABLE rag_documents ADD COLUMN IF NOT EXISTS tier TEXT NOT NULL DEFAULT 'hot'`,
+		`ALTER TABLE rag_documents ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ`,
+		`ALTER TABLE rag_documents ADD COLUMN IF NOT EXISTS dataset TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_tsv tsvector GENERATED ALWAYS AS (to_tsvector('english', content)) STORED`,
+	}
+	for _, stmt := range columnMigrations {
+		if _, err := s.DB.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to apply column migration: %w", err)
+		}
+	}
+	if err := backfillRAGDocumentDatasets(ctx, s.DB); err != nil {
+		return err
+	}
+
 	// Migrate existing rag_documents to new schema
 	// Check if old schema exists (has document_id column)
 	var hasDocumentID bool
@@ -143,7 +319,10 @@ func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
 				content TEXT NOT NULL,
 				content_hash TEXT,
 				metadata JSONB DEFAULT '{}'::jsonb,
-				created_at TIMESTAMPTZ DEFAULT NOW()
+				created_at TIMESTAMPTZ DEFAULT NOW(),
+				tier TEXT NOT NULL DEFAULT 'hot',
+				archived_at TIMESTAMPTZ,
+				dataset TEXT NOT NULL DEFAULT ''
 			)
 		`); err != nil {
 			return fmt.Errorf("failed to recreate rag_documents: %w", err)
@@ -185,20 +364,24 @@ func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
 
 	indexStmts := []string{
 		`CREATE INDEX IF NOT EXISTS idx_messages_role ON messages(role)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_content_tsv ON messages USING GIN (content_tsv)`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_user_active ON sessions(user_id, is_active, last_active DESC)`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_mode ON sessions(mode)`,
 		`CREATE INDEX IF NOT EXISTS idx_rag_documents_created_at ON rag_documents(created_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_rag_documents_content_hash ON rag_documents(content_hash)`,
+		`CREATE INDEX IF NOT EXISTS idx_rag_documents_tier ON rag_documents(tier, created_at)`,
 		`CREATE UNIQUE INDEX IF NOT EXISTS idx_rag_documents_session_role_hash ON rag_documents (content_hash, COALESCE(metadata ->> 'session_id', ''), COALESCE(metadata ->> 'role', '')) WHERE content_hash IS NOT NULL`,
 		`CREATE INDEX IF NOT EXISTS idx_rag_documents_metadata_dataset ON rag_documents ((metadata ->> 'dataset'))`,
+		`CREATE INDEX IF NOT EXISTS idx_rag_documents_session_dataset ON rag_documents ((COALESCE(metadata ->> 'session_id', '')), dataset, tier)`,
 		`CREATE INDEX IF NOT EXISTS idx_rag_documents_metadata_primary_test ON rag_documents ((metadata ->> 'primary_test'))`,
 		`CREATE INDEX IF NOT EXISTS idx_rag_documents_metadata_role ON rag_documents ((metadata ->> 'role'))`,
 		`CREATE INDEX IF NOT EXISTS idx_rag_documents_metadata_session_id ON rag_documents ((metadata ->> 'session_id'))`,
 		`CREATE INDEX IF NOT EXISTS idx_rag_embeddings_document_id ON rag_embeddings(document_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_rag_embeddings_vector_cosine ON rag_embeddings USING ivfflat (embedding vector_cosine_ops) WITH (lists = 100)`,
 		`CREATE INDEX IF NOT EXISTS idx_files_session_id ON files(session_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_files_message_id ON files(message_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_files_created_at ON files(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_retrieval_experiment_events_arm ON retrieval_experiment_events(arm, signal, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_run_turns_session_run ON run_turns(session_id, run_id, turn)`,
 	}
 
 	for _, stmt := range indexStmts {
@@ -207,6 +390,16 @@ func (s *PostgresStore) EnsureSchema(ctx context.Context) error {
 		}
 	}
 
+	// The vector index is built with the configured method, and rebuilt when an existing
+	// one was built with another method or parameters
+	rows, err := s.CountRAGEmbeddings(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := s.ensureVectorIndex(ctx, rows); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -262,7 +455,7 @@ func (s *PostgresStore) CreateSessionWithMode(ctx context.Context, userID *uuid.
 
 func (s *PostgresStore) GetSessionByID(ctx context.Context, sessionID uuid.UUID) (types.Session, error) {
 	query := `
-		SELECT id, user_id, created_at, last_active, workspace_path, title, is_active, COALESCE(mode, 'dataset') as mode
+		SELECT id, user_id, created_at, last_active, workspace_path, title, is_active, COALESCE(mode, 'dataset') as mode, COALESCE(verbosity, 'standard') as verbosity, COALESCE(effect_size_check, 'note') as effect_size_check, COALESCE(tags, '[]'::jsonb) as tags, random_seed, COALESCE(llm_model, '') as llm_model, COALESCE(retrieval_policy, '') as retrieval_policy, COALESCE(abstract, '') as abstract
 		FROM sessions
 		WHERE id = $1
 	`
@@ -270,12 +463,18 @@ func (s *PostgresStore) GetSessionByID(ctx context.Context, sessionID uuid.UUID)
 
 	var session types.Session
 	var userID sql.NullString
-	if err := row.Scan(&session.ID, &userID, &session.CreatedAt, &session.LastActive, &session.WorkspacePath, &session.Title, &session.IsActive, &session.Mode); err != nil {
+	var tagsJSON []byte
+	var randomSeed sql.NullInt64
+	if err := row.Scan(&session.ID, &userID, &session.CreatedAt, &session.LastActive, &session.WorkspacePath, &session.Title, &session.IsActive, &session.Mode, &session.Verbosity, &session.EffectSizeCheck, &tagsJSON, &randomSeed, &session.LLMModel, &session.RetrievalPolicy, &session.Abstract); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return types.Session{}, fmt.Errorf("session not found: %w", err)
 		}
 		return types.Session{}, fmt.Errorf("failed to scan session: %w", err)
 	}
+	if err := json.Unmarshal(tagsJSON, &session.Tags); err != nil {
+		return types.Session{}, fmt.Errorf("failed to unmarshal session tags: %w", err)
+	}
+	session.RandomSeed = nullInt64Ptr(randomSeed)
 
 	if userID.Valid {
 		parsedUUID, err := uuid.Parse(userID.String)
@@ -298,6 +497,22 @@ func (s *PostgresStore) UpdateSessionTitle(ctx context.Context, sessionID uuid.U
 	return nil
 }
 
+// UpdateSessionTags replaces the session's result tags.
+func (s *PostgresStore) UpdateSessionTags(ctx context.Context, sessionID uuid.UUID, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session tags: %w", err)
+	}
+	query := `UPDATE sessions SET tags = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, tagsJSON, sessionID); err != nil {
+		return fmt.Errorf("failed to update session tags: %w", err)
+	}
+	return nil
+}
+
 func (s *PostgresStore) UpdateSessionMode(ctx context.Context, sessionID uuid.UUID, mode string) error {
 	// Validate mode
 	if mode != "dataset" && mode != "document" {
@@ -312,6 +527,71 @@ func (s *PostgresStore) UpdateSessionMode(ctx context.Context, sessionID uuid.UU
 	return nil
 }
 
+func (s *PostgresStore) UpdateSessionVerbosity(ctx context.Context, sessionID uuid.UUID, verbosity string) error {
+	// Validate verbosity
+	if !types.IsValidVerbosity(verbosity) {
+		return fmt.Errorf("invalid verbosity: must be 'terse', 'standard', or 'teaching'")
+	}
+
+	query := `UPDATE sessions SET verbosity = $1 WHERE id = $2`
+	_, err := s.DB.ExecContext(ctx, query, verbosity, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update session verbosity: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresStore) UpdateSessionEffectSizeCheck(ctx context.Context, sessionID uuid.UUID, mode string) error {
+	if !types.IsValidEffectSizeCheck(mode) {
+		return fmt.Errorf("invalid effect size check: must be 'off', 'note', or 'auto'")
+	}
+
+	query := `UPDATE sessions SET effect_size_check = $1 WHERE id = $2`
+	_, err := s.DB.ExecContext(ctx, query, mode, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to update session effect size check: %w", err)
+	}
+	return nil
+}
+
+// UpdateSessionRandomSeed pins the session's random seed, or clears it when seed is nil.
+func (s *PostgresStore) UpdateSessionRandomSeed(ctx context.Context, sessionID uuid.UUID, seed *int64) error {
+	query := `UPDATE sessions SET random_seed = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, int64PtrToNull(seed), sessionID); err != nil {
+		return fmt.Errorf("failed to update session random seed: %w", err)
+	}
+	return nil
+}
+
+// UpdateSessionLLMModel sets the named LLM endpoint the session uses ("" for the default).
+func (s *PostgresStore) UpdateSessionLLMModel(ctx context.Context, sessionID uuid.UUID, model string) error {
+	query := `UPDATE sessions SET llm_model = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, model, sessionID); err != nil {
+		return fmt.Errorf("failed to update session LLM model: %w", err)
+	}
+	return nil
+}
+
+// UpdateSessionRetrievalPolicy sets the named retrieval policy the session's queries use
+// ("" for the policy of the session mode).
+func (s *PostgresStore) UpdateSessionRetrievalPolicy(ctx context.Context, sessionID uuid.UUID, policy string) error {
+	query := `UPDATE sessions SET retrieval_policy = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, policy, sessionID); err != nil {
+		return fmt.Errorf("failed to update session retrieval policy: %w", err)
+	}
+	return nil
+}
+
+// UpdateSessionAbstract stores the session's rolling abstract, the short summary of the
+// conversation shown in the sidebar.
+func (s *PostgresStore) UpdateSessionAbstract(ctx context.Context, sessionID uuid.UUID, abstract string) error {
+	query := `UPDATE sessions SET abstract = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, abstract, sessionID); err != nil {
+		return fmt.Errorf("failed to update session abstract: %w", err)
+	}
+	return nil
+}
+
 func (s *PostgresStore) GetSessions(ctx context.Context, userID *uuid.UUID) ([]types.Session, error) {
 	var query string
 	var rows *sql.Rows
@@ -319,7 +599,7 @@ func (s *PostgresStore) GetSessions(ctx context.Context, userID *uuid.UUID) ([]t
 
 	if userID != nil {
 		query = `
-			SELECT id, user_id, created_at, last_active, workspace_path, title, is_active, COALESCE(mode, 'dataset') as mode
+			SELECT id, user_id, created_at, last_active, workspace_path, title, is_active, COALESCE(mode, 'dataset') as mode, COALESCE(verbosity, 'standard') as verbosity, COALESCE(effect_size_check, 'note') as effect_size_check, COALESCE(tags, '[]'::jsonb) as tags, random_seed, COALESCE(llm_model, '') as llm_model, COALESCE(retrieval_policy, '') as retrieval_policy, COALESCE(abstract, '') as abstract
 			FROM sessions
 			WHERE is_active = true AND user_id = $1
 			ORDER BY last_active DESC
@@ -327,7 +607,7 @@ func (s *PostgresStore) GetSessions(ctx context.Context, userID *uuid.UUID) ([]t
 		rows, err = s.DB.QueryContext(ctx, query, userID)
 	} else {
 		query = `
-			SELECT id, user_id, created_at, last_active, workspace_path, title, is_active, COALESCE(mode, 'dataset') as mode
+			SELECT id, user_id, created_at, last_active, workspace_path, title, is_active, COALESCE(mode, 'dataset') as mode, COALESCE(verbosity, 'standard') as verbosity, COALESCE(effect_size_check, 'note') as effect_size_check, COALESCE(tags, '[]'::jsonb) as tags, random_seed, COALESCE(llm_model, '') as llm_model, COALESCE(retrieval_policy, '') as retrieval_policy, COALESCE(abstract, '') as abstract
 			FROM sessions
 			WHERE is_active = true
 			ORDER BY last_active DESC
@@ -344,9 +624,15 @@ func (s *PostgresStore) GetSessions(ctx context.Context, userID *uuid.UUID) ([]t
 	for rows.Next() {
 		var session types.Session
 		var userID sql.NullString
-		if err := rows.Scan(&session.ID, &userID, &session.CreatedAt, &session.LastActive, &session.WorkspacePath, &session.Title, &session.IsActive, &session.Mode); err != nil {
+		var tagsJSON []byte
+	var randomSeed sql.NullInt64
+		if err := rows.Scan(&session.ID, &userID, &session.CreatedAt, &session.LastActive, &session.WorkspacePath, &session.Title, &session.IsActive, &session.Mode, &session.Verbosity, &session.EffectSizeCheck, &tagsJSON, &randomSeed, &session.LLMModel, &session.RetrievalPolicy, &session.Abstract); err != nil {
 			return nil, fmt.Errorf("failed to scan session row: %w", err)
 		}
+		if err := json.Unmarshal(tagsJSON, &session.Tags); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal tags for session %s: %w", session.ID, err)
+		}
+		session.RandomSeed = nullInt64Ptr(randomSeed)
 		if userID.Valid {
 			parsedUUID, err := uuid.Parse(userID.String)
 			if err != nil {
@@ -485,6 +771,35 @@ func (s *PostgresStore) GetStaleSessions(ctx context.Context, lastActiveBefore t
 	return sessionIDs, nil
 }
 
+// GetRecentlyActiveSessions returns IDs of sessions active at or after the given time.
+func (s *PostgresStore) GetRecentlyActiveSessions(ctx context.Context, lastActiveAfter time.Time) ([]uuid.UUID, error) {
+	query := `
+		SELECT id FROM sessions
+		WHERE last_active >= $1
+		ORDER BY last_active DESC
+	`
+	rows, err := s.DB.QueryContext(ctx, query, lastActiveAfter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recently active sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessionIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan session ID: %w", err)
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recently active sessions: %w", err)
+	}
+
+	return sessionIDs, nil
+}
+
 func (s *PostgresStore) DeleteSession(ctx context.Context, sessionID uuid.UUID) error {
 	query := `DELETE FROM sessions WHERE id = $1`
 	result, err := s.DB.ExecContext(ctx, query, sessionID)
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -108,6 +109,67 @@ func (s *PostgresStore) GetFilesBySession(ctx context.Context, sessionID uuid.UU
 	return files, nil
 }
 
+// artifactFilesFilter selects a session's figures ($1 is the session): images, and
+// interactive Plotly figures in place of the PNG fallback saved beside them.
+const artifactFilesFilter = `
+		session_id = $1 AND (file_type = 'plot' OR (file_type = 'image' AND NOT EXISTS (
+			SELECT 1 FROM files fig
+			WHERE fig.session_id = files.session_id AND fig.file_type = 'plot'
+				AND fig.filename = (substr(files.filename, 1, length(files.filename) - 4) || '.plotly.json')
+		)))`
+
+// GetArtifactFiles returns a page of the session's figures, newest first, with the total
+// number of figures.
+func (s *PostgresStore) GetArtifactFiles(ctx context.Context, sessionID uuid.UUID, limit, offset int) ([]FileRecord, int, error) {
+	var total int
+	if err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM files WHERE`+artifactFilesFilter, sessionID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count artifact files: %w", err)
+	}
+
+	query := `
+		SELECT id, session_id, filename, file_path, file_type, file_size, created_at, message_id
+		FROM files
+		WHERE` + artifactFilesFilter + `
+		ORDER BY created_at DESC, filename
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := s.DB.QueryContext(ctx, query, sessionID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query artifact files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []FileRecord
+	for rows.Next() {
+		var file FileRecord
+		var messageID sql.NullString
+		if err := rows.Scan(&file.ID, &file.SessionID, &file.Filename, &file.FilePath, &file.FileType, &file.FileSize, &file.CreatedAt, &messageID); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan artifact file row: %w", err)
+		}
+		file.MessageID = nullStringToUUID(messageID)
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating artifact file rows: %w", err)
+	}
+	return files, total, nil
+}
+
+// LinkFilesToMessage records the message that displays a run's files.
+func (s *PostgresStore) LinkFilesToMessage(ctx context.Context, sessionID uuid.UUID, filenames []string, messageID uuid.UUID) error {
+	return linkFilesToMessage(ctx, s.DB, sessionID, filenames, messageID)
+}
+
+func linkFilesToMessage(ctx context.Context, db *sql.DB, sessionID uuid.UUID, filenames []string, messageID uuid.UUID) error {
+	query := `UPDATE files SET message_id = $1 WHERE session_id = $2 AND filename = $3`
+	for _, filename := range filenames {
+		if _, err := db.ExecContext(ctx, query, messageID, sessionID, filename); err != nil {
+			return fmt.Errorf("failed to link file %s to message: %w", filename, err)
+		}
+	}
+	return nil
+}
+
 // GetNewFilesBySession returns files created after the specified time for a session.
 // This is used to detect new files since the last check.
 func (s *PostgresStore) GetNewFilesBySession(ctx context.Context, sessionID uuid.UUID, after time.Time) ([]FileRecord, error) {
@@ -213,6 +275,125 @@ func (s *PostgresStore) GetTrackedFilenames(ctx context.Context, sessionID uuid.
 	return filenames, nil
 }
 
+// GetColumnTypeOverrides returns the user's column type overrides for every dataset in a
+// session, keyed by filename and then column name.
+func (s *PostgresStore) GetColumnTypeOverrides(ctx context.Context, sessionID uuid.UUID) (map[string]map[string]string, error) {
+	query := `
+		SELECT filename, column_types
+		FROM files
+		WHERE session_id = $1 AND column_types IS NOT NULL AND column_types <> '{}'::jsonb
+	`
+
+	rows, err := s.DB.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query column type overrides: %w", err)
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]map[string]string)
+	for rows.Next() {
+		var filename string
+		var typesJSON []byte
+		if err := rows.Scan(&filename, &typesJSON); err != nil {
+			return nil, fmt.Errorf("failed to scan column type overrides: %w", err)
+		}
+		var columnTypes map[string]string
+		if err := json.Unmarshal(typesJSON, &columnTypes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal column types for %s: %w", filename, err)
+		}
+		overrides[filename] = columnTypes
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating column type overrides: %w", err)
+	}
+
+	return overrides, nil
+}
+
+// SetColumnTypeOverrides replaces the column type overrides of a session's dataset.
+func (s *PostgresStore) SetColumnTypeOverrides(ctx context.Context, sessionID uuid.UUID, filename string, columnTypes map[string]string) error {
+	if columnTypes == nil {
+		columnTypes = map[string]string{}
+	}
+	typesJSON, err := json.Marshal(columnTypes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal column types: %w", err)
+	}
+
+	query := `UPDATE files SET column_types = $1 WHERE session_id = $2 AND filename = $3`
+	result, err := s.DB.ExecContext(ctx, query, typesJSON, sessionID, filename)
+	if err != nil {
+		return fmt.Errorf("failed to update column types: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("file not found")
+	}
+
+	return nil
+}
+
+// GetFileAltTexts returns the generated alt text of a session's files, keyed by filename.
+// Files without alt text are omitted.
+func (s *PostgresStore) GetFileAltTexts(ctx context.Context, sessionID uuid.UUID) (map[string]string, error) {
+	query := `SELECT filename, alt_text FROM files WHERE session_id = $1 AND alt_text IS NOT NULL AND alt_text <> ''`
+
+	rows, err := s.DB.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file alt text: %w", err)
+	}
+	defer rows.Close()
+
+	altTexts := make(map[string]string)
+	for rows.Next() {
+		var filename, altText string
+		if err := rows.Scan(&filename, &altText); err != nil {
+			return nil, fmt.Errorf("failed to scan file alt text: %w", err)
+		}
+		altTexts[filename] = altText
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating file alt text: %w", err)
+	}
+
+	return altTexts, nil
+}
+
+// SetFileAltText stores the alt text of a session's file.
+func (s *PostgresStore) SetFileAltText(ctx context.Context, sessionID uuid.UUID, filename, altText string) error {
+	query := `UPDATE files SET alt_text = $1 WHERE session_id = $2 AND filename = $3`
+	result, err := s.DB.ExecContext(ctx, query, altText, sessionID, filename)
+	if err != nil {
+		return fmt.Errorf("failed to update file alt text: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errors.New("file not found")
+	}
+	return nil
+}
+
+// RenameFile renames a session's file record. Renaming a file without a record is not an
+// error: uploads are only recorded once a run picks them up.
+func (s *PostgresStore) RenameFile(ctx context.Context, sessionID uuid.UUID, filename, newFilename, newFilePath string) error {
+	query := `UPDATE files SET filename = $1, file_path = $2 WHERE session_id = $3 AND filename = $4`
+	if _, err := s.DB.ExecContext(ctx, query, newFilename, newFilePath, sessionID, filename); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
+
 // DeleteFile removes a file record from the database
 func (s *PostgresStore) DeleteFile(ctx context.Context, fileID uuid.UUID) error {
 	query := `DELETE FROM files WHERE id = $1`
@@ -251,3 +432,19 @@ func nullStringToUUID(ns sql.NullString) *uuid.UUID {
 	}
 	return &u
 }
+
+// Helper functions for *int64 <-> sql.NullInt64 conversion
+func int64PtrToNull(v *int64) sql.NullInt64 {
+	if v == nil {
+		return sql.NullInt64{Valid: false}
+	}
+	return sql.NullInt64{Int64: *v, Valid: true}
+}
+
+func nullInt64Ptr(n sql.NullInt64) *int64 {
+	if !n.Valid {
+		return nil
+	}
+	v := n.Int64
+	return &v
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Tables that receive the bulk of RAG writes and deletes.
+var maintenanceTables = []string{"rag_documents", "rag_embeddings", "messages", "files", "sessions"}
+
+// VectorIndexName is the approximate nearest-neighbour index on rag_embeddings. Its
+// method and parameters come from VectorIndexOptions.
+const VectorIndexName = "idx_rag_embeddings_vector_cosine"
+
+// Vector index methods supported by pgvector.
+const (
+	VectorIndexIVFFlat = "ivfflat"
+	VectorIndexHNSW    = "hnsw"
+)
+
+// minIVFFlatLists is the floor of automatically sized ivfflat lists, and the fixed value
+// indexes were built with before lists were configurable.
+const minIVFFlatLists = 100
+
+// VectorIndexOptions configures the vector index. ivfflat lists are fixed at build time
+// and degrade as the table grows well beyond its size at build; hnsw builds slower but
+// keeps its recall as the table grows.
+type VectorIndexOptions struct {
+	Type           string // VectorIndexIVFFlat (default) or VectorIndexHNSW
+	Lists          int    // ivfflat lists; 0 sizes them from the row count
+	M              int    // hnsw connections per node
+	EFConstruction int    // hnsw candidate list size while building
+}
+
+func (o VectorIndexOptions) method() string {
+	if o.Type == VectorIndexHNSW {
+		return VectorIndexHNSW
+	}
+	return VectorIndexIVFFlat
+}
+
+// lists returns the ivfflat lists for rows embeddings: the configured value, or pgvector's
+// guidance of rows/1000 (the square root of rows beyond a million rows).
+func (o VectorIndexOptions) lists(rows int64) int {
+	if o.Lists > 0 {
+		return o.Lists
+	}
+	lists := int(rows / 1000)
+	if rows > 1_000_000 {
+		lists = int(math.Sqrt(float64(rows)))
+	}
+	return max(lists, minIVFFlatLists)
+}
+
+// params returns the storage parameters to build the index over rows embeddings with.
+func (o VectorIndexOptions) params(rows int64) map[string]string {
+	if o.method() == VectorIndexHNSW {
+		return map[string]string{"m": strconv.Itoa(o.M), "ef_construction": strconv.Itoa(o.EFConstruction)}
+	}
+	return map[string]string{"lists": strconv.Itoa(o.lists(rows))}
+}
+
+// matches reports whether an index built with method and params fits the options for
+// rows embeddings. Automatically sized lists tolerate drift up to a factor of two, so a
+// growing table is not rebuilt on every maintenance run.
+func (o VectorIndexOptions) matches(method string, params map[string]string, rows int64) bool {
+	if method != o.method() {
+		return false
+	}
+	if method == VectorIndexHNSW || o.Lists > 0 {
+		want := o.params(rows)
+		for key, value := range want {
+			if params[key] != value {
+				return false
+			}
+		}
+		return true
+	}
+	lists, err := strconv.Atoi(params["lists"])
+	if err != nil || lists <= 0 {
+		return false
+	}
+	want := o.lists(rows)
+	return want < 2*lists && lists < 2*want
+}
+
+// AnalyzeTables refreshes planner statistics for the RAG tables.
+func (s *PostgresStore) AnalyzeTables(ctx context.Context) error {
+	for _, table := range maintenanceTables {
+		if _, err := s.DB.ExecContext(ctx, `ANALYZE `+table); err != nil {
+			return fmt.Errorf("failed to analyze %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// VacuumTables reclaims dead tuples left by deleted sessions, messages, and RAG rows.
+// VACUUM cannot run inside a transaction, so each table is a separate statement.
+func (s *PostgresStore) VacuumTables(ctx context.Context) error {
+	for _, table := range maintenanceTables {
+		if _, err := s.DB.ExecContext(ctx, `VACUUM `+table); err != nil {
+			return fmt.Errorf("failed to vacuum %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// CountRAGEmbeddings returns the number of embedding rows (the vector index size).
+func (s *PostgresStore) CountRAGEmbeddings(ctx context.Context) (int64, error) {
+	var count int64
+	if err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM rag_embeddings`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count rag embeddings: %w", err)
+	}
+	return count, nil
+}
+
+// ReindexVectorIndex refreshes the vector index after the embedding table grew. An index
+// whose parameters no longer fit the row count (automatically sized ivfflat lists) is
+// rebuilt with new ones, any other is reindexed in place; neither blocks writes.
+func (s *PostgresStore) ReindexVectorIndex(ctx context.Context) error {
+	rows, err := s.CountRAGEmbeddings(ctx)
+	if err != nil {
+		return err
+	}
+	rebuilt, err := s.ensureVectorIndex(ctx, rows)
+	if err != nil || rebuilt {
+		return err
+	}
+	if _, err := s.DB.ExecContext(ctx, `REINDEX INDEX CONCURRENTLY `+VectorIndexName); err != nil {
+		return fmt.Errorf("failed to reindex %s: %w", VectorIndexName, err)
+	}
+	if _, err := s.DB.ExecContext(ctx, `ANALYZE rag_embeddings`); err != nil {
+		return fmt.Errorf("failed to analyze rag_embeddings: %w", err)
+	}
+	return nil
+}
+
+// ensureVectorIndex builds the vector index when it is missing or was built with another
+// method or parameters than the options call for at rows embeddings. Returns whether the
+// index was built.
+func (s *PostgresStore) ensureVectorIndex(ctx context.Context, rows int64) (bool, error) {
+	method, params, err := s.currentVectorIndex(ctx)
+	if err != nil {
+		return false, err
+	}
+	if method != "" && s.vectorIndex.matches(method, params, rows) {
+		return false, nil
+	}
+	if err := s.buildVectorIndex(ctx, rows); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// currentVectorIndex returns the method and storage parameters of the vector index, or
+// "" when it does not exist.
+func (s *PostgresStore) currentVectorIndex(ctx context.Context) (string, map[string]string, error) {
+	var method, options string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT am.amname, COALESCE(array_to_string(c.reloptions, ','), '')
+		FROM pg_class c
+		JOIN pg_am am ON am.oid = c.relam
+		WHERE c.relname = $1 AND c.relkind = 'i'
+	`, VectorIndexName).Scan(&method, &options)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to inspect %s: %w", VectorIndexName, err)
+	}
+	params := make(map[string]string)
+	for _, option := range strings.Split(options, ",") {
+		if key, value, ok := strings.Cut(option, "="); ok {
+			params[key] = value
+		}
+	}
+	return method, params, nil
+}
+
+// buildVectorIndex builds the index under a temporary name and swaps it in, so searches
+// keep using the old index until the new one is ready. A build left over from an
+// interrupted run is dropped first.
+func (s *PostgresStore) buildVectorIndex(ctx context.Context, rows int64) error {
+	params := s.vectorIndex.params(rows)
+	with := make([]string, 0, len(params))
+	for _, key := range []string{"lists", "m", "ef_construction"} {
+		if value, ok := params[key]; ok {
+			with = append(with, key+" = "+value)
+		}
+	}
+	building := VectorIndexName + "_build"
+	stmts := []string{
+		`DROP INDEX CONCURRENTLY IF EXISTS ` + building,
+		fmt.Sprintf(`CREATE INDEX CONCURRENTLY %s ON rag_embeddings USING %s (embedding vector_cosine_ops) WITH (%s)`,
+			building, s.vectorIndex.method(), strings.Join(with, ", ")),
+		`DROP INDEX CONCURRENTLY IF EXISTS ` + VectorIndexName,
+		`ALTER INDEX ` + building + ` RENAME TO ` + VectorIndexName,
+		`ANALYZE rag_embeddings`,
+	}
+	for _, stmt := range stmts {
+		if _, err := s.DB.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to build %s: %w", VectorIndexName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// ErrCheckpointNameTaken is returned when the session already has a checkpoint with the name.
+var ErrCheckpointNameTaken = errors.New("checkpoint name already used in this session")
+
+// Memory checkpoints use only portable SQL (->> and $N placeholders), so both backends
+// share it.
+
+// sessionMemoryDocumentsSelect lists a session's RAG documents as checkpoint rows; $1 is
+// the session ID. State cards keep their content, since they are updated in place.
+const sessionMemoryDocumentsSelect = `
+	SELECT id,
+	       COALESCE(NULLIF(metadata ->> 'type', ''), metadata ->> 'role', ''),
+	       COALESCE(content_hash, ''),
+	       CASE WHEN (metadata ->> 'type') = 'state' THEN content END
+	FROM rag_documents
+	WHERE (metadata ->> 'session_id') = $1`
+
+// CreateMemoryCheckpoint snapshots the session's RAG documents under name.
+func (s *PostgresStore) CreateMemoryCheckpoint(ctx context.Context, sessionID uuid.UUID, name string) (types.MemoryCheckpoint, error) {
+	return createMemoryCheckpoint(ctx, s.DB, sessionID, name)
+}
+
+// GetMemoryCheckpoints returns the session's checkpoints, oldest first.
+func (s *PostgresStore) GetMemoryCheckpoints(ctx context.Context, sessionID uuid.UUID) ([]types.MemoryCheckpoint, error) {
+	return getMemoryCheckpoints(ctx, s.DB, sessionID)
+}
+
+// GetMemoryCheckpointDocuments returns the documents recorded in one of the session's
+// checkpoints, or sql.ErrNoRows when the session has no such checkpoint.
+func (s *PostgresStore) GetMemoryCheckpointDocuments(ctx context.Context, sessionID, checkpointID uuid.UUID) ([]types.CheckpointDocument, error) {
+	return getMemoryCheckpointDocuments(ctx, s.DB, sessionID, checkpointID)
+}
+
+// ListSessionMemoryDocuments returns the session's current RAG documents as checkpoint rows.
+func (s *PostgresStore) ListSessionMemoryDocuments(ctx context.Context, sessionID uuid.UUID) ([]types.CheckpointDocument, error) {
+	return queryCheckpointDocuments(ctx, s.DB, sessionMemoryDocumentsSelect, sessionID.String())
+}
+
+// DeleteMemoryCheckpoint removes one of the session's checkpoints.
+func (s *PostgresStore) DeleteMemoryCheckpoint(ctx context.Context, sessionID, checkpointID uuid.UUID) error {
+	return deleteMemoryCheckpoint(ctx, s.DB, sessionID, checkpointID)
+}
+
+// CreateMemoryCheckpoint snapshots the session's RAG documents under name.
+func (s *SQLiteStore) CreateMemoryCheckpoint(ctx context.Context, sessionID uuid.UUID, name string) (types.MemoryCheckpoint, error) {
+	return createMemoryCheckpoint(ctx, s.DB, sessionID, name)
+}
+
+// GetMemoryCheckpoints returns the session's checkpoints, oldest first.
+func (s *SQLiteStore) GetMemoryCheckpoints(ctx context.Context, sessionID uuid.UUID) ([]types.MemoryCheckpoint, error) {
+	return getMemoryCheckpoints(ctx, s.DB, sessionID)
+}
+
+// GetMemoryCheckpointDocuments returns the documents recorded in one of the session's
+// checkpoints, or sql.ErrNoRows when the session has no such checkpoint.
+func (s *SQLiteStore) GetMemoryCheckpointDocuments(ctx context.Context, sessionID, checkpointID uuid.UUID) ([]types.CheckpointDocument, error) {
+	return getMemoryCheckpointDocuments(ctx, s.DB, sessionID, checkpointID)
+}
+
+// ListSessionMemoryDocuments returns the session's current RAG documents as checkpoint rows.
+func (s *SQLiteStore) ListSessionMemoryDocuments(ctx context.Context, sessionID uuid.UUID) ([]types.CheckpointDocument, error) {
+	return queryCheckpointDocuments(ctx, s.DB, sessionMemoryDocumentsSelect, sessionID.String())
+}
+
+// DeleteMemoryCheckpoint removes one of the session's checkpoints.
+func (s *SQLiteStore) DeleteMemoryCheckpoint(ctx context.Context, sessionID, checkpointID uuid.UUID) error {
+	return deleteMemoryCheckpoint(ctx, s.DB, sessionID, checkpointID)
+}
+
+func createMemoryCheckpoint(ctx context.Context, db *sql.DB, sessionID uuid.UUID, name string) (types.MemoryCheckpoint, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return types.MemoryCheckpoint{}, fmt.Errorf("failed to begin memory checkpoint: %w", err)
+	}
+	defer tx.Rollback()
+
+	var taken bool
+	if err := tx.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM memory_checkpoints WHERE session_id = $1 AND name = $2)`,
+		sessionID, name).Scan(&taken); err != nil {
+		return types.MemoryCheckpoint{}, fmt.Errorf("failed to check checkpoint name: %w", err)
+	}
+	if taken {
+		return types.MemoryCheckpoint{}, ErrCheckpointNameTaken
+	}
+
+	checkpoint := types.MemoryCheckpoint{ID: uuid.New(), SessionID: sessionID, Name: name, CreatedAt: time.Now().UTC()}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO memory_checkpoints (id, session_id, name, created_at) VALUES ($1, $2, $3, $4)`,
+		checkpoint.ID, sessionID, name, checkpoint.CreatedAt); err != nil {
+		return types.MemoryCheckpoint{}, fmt.Errorf("failed to save memory checkpoint: %w", err)
+	}
+	rows, err := tx.QueryContext(ctx, sessionMemoryDocumentsSelect, sessionID.String())
+	if err != nil {
+		return types.MemoryCheckpoint{}, fmt.Errorf("failed to query memory documents: %w", err)
+	}
+	docs, err := scanCheckpointDocuments(rows)
+	if err != nil {
+		return types.MemoryCheckpoint{}, err
+	}
+	for _, d := range docs {
+		var state any
+		if d.Kind == "state" {
+			state = d.StateContent
+			checkpoint.StateCardCount++
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO memory_checkpoint_documents (checkpoint_id, document_id, kind, content_hash, state_content)
+			VALUES ($1, $2, $3, $4, $5)`,
+			checkpoint.ID, d.DocumentID, d.Kind, d.ContentHash, state); err != nil {
+			return types.MemoryCheckpoint{}, fmt.Errorf("failed to snapshot memory document %s: %w", d.DocumentID, err)
+		}
+	}
+	checkpoint.DocumentCount = len(docs)
+	if err := tx.Commit(); err != nil {
+		return types.MemoryCheckpoint{}, fmt.Errorf("failed to commit memory checkpoint: %w", err)
+	}
+	return checkpoint, nil
+}
+
+func getMemoryCheckpoints(ctx context.Context, db *sql.DB, sessionID uuid.UUID) ([]types.MemoryCheckpoint, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.id, c.session_id, c.name, c.created_at,
+		       COUNT(d.document_id), COUNT(d.state_content)
+		FROM memory_checkpoints c
+		LEFT JOIN memory_checkpoint_documents d ON d.checkpoint_id = c.id
+		WHERE c.session_id = $1
+		GROUP BY c.id, c.session_id, c.name, c.created_at
+		ORDER BY c.created_at, c.id`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query memory checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	var checkpoints []types.MemoryCheckpoint
+	for rows.Next() {
+		var c types.MemoryCheckpoint
+		if err := rows.Scan(&c.ID, &c.SessionID, &c.Name, &c.CreatedAt, &c.DocumentCount, &c.StateCardCount); err != nil {
+			return nil, fmt.Errorf("failed to scan memory checkpoint: %w", err)
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating memory checkpoints: %w", err)
+	}
+	return checkpoints, nil
+}
+
+func getMemoryCheckpointDocuments(ctx context.Context, db *sql.DB, sessionID, checkpointID uuid.UUID) ([]types.CheckpointDocument, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM memory_checkpoints WHERE id = $1 AND session_id = $2)`,
+		checkpointID, sessionID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to look up memory checkpoint: %w", err)
+	}
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+	return queryCheckpointDocuments(ctx, db, `
+		SELECT document_id, kind, content_hash, state_content
+		FROM memory_checkpoint_documents
+		WHERE checkpoint_id = $1`, checkpointID)
+}
+
+func queryCheckpointDocuments(ctx context.Context, db *sql.DB, query string, arg any) ([]types.CheckpointDocument, error) {
+	rows, err := db.QueryContext(ctx, query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checkpoint documents: %w", err)
+	}
+	return scanCheckpointDocuments(rows)
+}
+
+// scanCheckpointDocuments reads and closes rows of (document_id, kind, content_hash, state_content).
+func scanCheckpointDocuments(rows *sql.Rows) ([]types.CheckpointDocument, error) {
+	defer rows.Close()
+
+	var docs []types.CheckpointDocument
+	for rows.Next() {
+		var (
+			d     types.CheckpointDocument
+			state sql.NullString
+		)
+		if err := rows.Scan(&d.DocumentID, &d.Kind, &d.ContentHash, &state); err != nil {
+			return nil, fmt.Errorf("failed to scan checkpoint document: %w", err)
+		}
+		d.StateContent = state.String
+		docs = append(docs, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating checkpoint documents: %w", err)
+	}
+	return docs, nil
+}
+
+func deleteMemoryCheckpoint(ctx context.Context, db *sql.DB, sessionID, checkpointID uuid.UUID) error {
+	result, err := db.ExecContext(ctx, `DELETE FROM memory_checkpoints WHERE id = $1 AND session_id = $2`, checkpointID, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete memory checkpoint: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// GetMessagesPageBySession returns the messages of the last `turns` user turns (each user
+// message with the replies that follow it) before the message `before`, oldest first. An
+// empty `before` pages back from the end of the conversation. hasMore reports whether
+// older messages remain; the ID of the first returned message is the next cursor.
+func (s *PostgresStore) GetMessagesPageBySession(ctx context.Context, sessionID uuid.UUID, before string, turns int) ([]types.ChatMessage, bool, error) {
+	query, moreQuery, args := messagePageQueries(sessionID, before, turns)
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query message page: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []types.ChatMessage
+	for rows.Next() {
+		var msg types.ChatMessage
+		var sessionUUID uuid.UUID
+		if err := rows.Scan(&msg.ID, &sessionUUID, &msg.Role, &msg.Content, &msg.Rendered, &msg.ContentHash); err != nil {
+			return nil, false, fmt.Errorf("failed to scan message row: %w", err)
+		}
+		msg.SessionID = sessionUUID.String()
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("error iterating message rows: %w", err)
+	}
+
+	var hasMore bool
+	if err := s.DB.QueryRowContext(ctx, moreQuery, args...).Scan(&hasMore); err != nil {
+		return nil, false, fmt.Errorf("failed to check for older messages: %w", err)
+	}
+	return messages, hasMore, nil
+}
+
+// messagePageQueries builds the page query and the "older messages remain" query shared by
+// both backends. The page starts at the turns-th most recent user message before the
+// cursor; when there are fewer user messages than that, it starts at the beginning.
+func messagePageQueries(sessionID uuid.UUID, before string, turns int) (query, moreQuery string, args []any) {
+	args = []any{sessionID, turns - 1}
+	cursor := ""
+	if before != "" {
+		args = append(args, before)
+		cursor = ` AND created_at < (SELECT created_at FROM messages WHERE id = $3 AND session_id = $1)`
+	}
+
+	start := `(SELECT created_at FROM messages
+		WHERE session_id = $1 AND role = 'user'` + cursor + `
+		ORDER BY created_at DESC LIMIT 1 OFFSET $2)`
+
+	query = `
+		SELECT id, session_id, role, content, rendered, content_hash FROM messages
+		WHERE session_id = $1 AND created_at >= COALESCE(` + start + `, created_at)` + cursor + `
+		ORDER BY created_at ASC`
+	moreQuery = `SELECT EXISTS (SELECT 1 FROM messages WHERE session_id = $1 AND created_at < ` + start + `)`
+	return query, moreQuery, args
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// messageSnippetOptions configures ts_headline: up to two short fragments around the
+// matches, each match wrapped in the types.SnippetMatch markers.
+const messageSnippetOptions = `StartSel=` + types.SnippetMatchStart + `, StopSel=` + types.SnippetMatchEnd +
+	`, MaxWords=18, MinWords=6, MaxFragments=2, FragmentDelimiter=" … "`
+
+// likeSnippetRadius is how many characters the SQLite snippet keeps on each side of the
+// first match.
+const likeSnippetRadius = 80
+
+// SearchMessages full-text searches the chat messages of every active session owned by
+// userID and returns the best matches, with a highlighted snippet of each. The query uses
+// web search syntax ("quoted phrases", -excluded words, or) and is stemmed as English
+// against the messages' content_tsv column (GIN indexed).
+func (s *PostgresStore) SearchMessages(ctx context.Context, userID uuid.UUID, query string, limit int) ([]types.MessageSearchResult, error) {
+	trimmed := strings.TrimSpace(query)
+	if trimmed == "" || limit <= 0 {
+		return nil, nil
+	}
+
+	// Rank and limit first so ts_headline only runs on the returned rows
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT hit.id, hit.session_id, hit.title, hit.role, hit.created_at,
+			ts_headline('english', m.content, websearch_to_tsquery('english', $2), $3)
+		FROM (
+			SELECT m.id, m.session_id, COALESCE(s.title, '') AS title, m.role, m.created_at,
+				ts_rank_cd(m.content_tsv, q) AS rank
+			FROM messages m
+			JOIN sessions s ON s.id = m.session_id,
+				websearch_to_tsquery('english', $2) q
+			WHERE s.user_id = $1 AND s.is_active = true AND m.role <> 'system'
+				AND m.content_tsv @@ q
+			ORDER BY rank DESC, m.created_at DESC
+			LIMIT $4
+		) hit
+		JOIN messages m ON m.id = hit.id
+		ORDER BY hit.rank DESC, hit.created_at DESC`,
+		userID, trimmed, messageSnippetOptions, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	var results []types.MessageSearchResult
+	for rows.Next() {
+		var result types.MessageSearchResult
+		var messageID, sessionID uuid.UUID
+		if err := rows.Scan(&messageID, &sessionID, &result.SessionTitle, &result.Role, &result.CreatedAt, &result.Snippet); err != nil {
+			return nil, fmt.Errorf("failed to scan message search row: %w", err)
+		}
+		result.MessageID = messageID.String()
+		result.SessionID = sessionID.String()
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message search rows: %w", err)
+	}
+	return results, nil
+}
+
+// SearchMessages searches the chat messages of every active session owned by userID.
+// SQLite has no tsvector: every word of the query must appear in the message
+// (case-insensitively for ASCII), and matches come newest first.
+func (s *SQLiteStore) SearchMessages(ctx context.Context, userID uuid.UUID, query string, limit int) ([]types.MessageSearchResult, error) {
+	words := strings.Fields(query)
+	if len(words) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	var builder strings.Builder
+	args := []any{userID}
+	builder.WriteString(`
+		SELECT m.id, m.session_id, COALESCE(s.title, ''), m.role, m.created_at, m.content
+		FROM messages m
+		JOIN sessions s ON s.id = m.session_id
+		WHERE s.user_id = $1 AND s.is_active = 1 AND m.role <> 'system'`)
+	for _, word := range words {
+		args = append(args, "%"+escapeLike(word)+"%")
+		fmt.Fprintf(&builder, ` AND m.content LIKE $%d ESCAPE '\'`, len(args))
+	}
+	args = append(args, limit)
+	fmt.Fprintf(&builder, ` ORDER BY m.created_at DESC LIMIT $%d`, len(args))
+
+	rows, err := s.DB.QueryContext(ctx, builder.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	var results []types.MessageSearchResult
+	for rows.Next() {
+		var result types.MessageSearchResult
+		var content string
+		if err := rows.Scan(&result.MessageID, &result.SessionID, &result.SessionTitle, &result.Role, &result.CreatedAt, &content); err != nil {
+			return nil, fmt.Errorf("failed to scan message search row: %w", err)
+		}
+		result.Snippet = likeSnippet(content, words)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message search rows: %w", err)
+	}
+	return results, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s for a pattern using ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// asciiLower lower-cases ASCII letters only, like SQLite's LIKE, so byte offsets into the
+// result are offsets into s.
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// likeSnippet cuts content around the first occurrence of any of words and wraps every
+// occurrence in the snippet with the types.SnippetMatch markers, like ts_headline does.
+func likeSnippet(content string, words []string) string {
+	content = strings.Join(strings.Fields(content), " ")
+	lower := asciiLower(content)
+	first := -1
+	for _, word := range words {
+		if i := strings.Index(lower, asciiLower(word)); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	start, end := max(first-likeSnippetRadius, 0), min(first+likeSnippetRadius, len(content))
+	for start > 0 && !utf8.RuneStart(content[start]) {
+		start--
+	}
+	for end < len(content) && !utf8.RuneStart(content[end]) {
+		end++
+	}
+	snippet, lowerSnippet := content[start:end], lower[start:end]
+
+	var builder strings.Builder
+	if start > 0 {
+		builder.WriteString("… ")
+	}
+	for i := 0; i < len(snippet); {
+		matched := 0
+		for _, word := range words {
+			if n := len(word); n > matched && strings.HasPrefix(lowerSnippet[i:], asciiLower(word)) {
+				matched = n
+			}
+		}
+		if matched == 0 {
+			builder.WriteByte(snippet[i])
+			i++
+			continue
+		}
+		builder.WriteString(types.SnippetMatchStart)
+		builder.WriteString(snippet[i : i+matched])
+		builder.WriteString(types.SnippetMatchEnd)
+		i += matched
+	}
+	if end < len(content) {
+		builder.WriteString(" …")
+	}
+	return builder.String()
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// rag_documents.dataset mirrors metadata ->> 'dataset' as an indexed column so retrieval
+// can be scoped to one dataset of a session. Documents not tied to a dataset (questions
+// asked before a file was named, PDFs, session notes) keep an empty dataset and are
+// visible from every scope.
+
+// ragDocumentDataset returns the dataset column value for a document's metadata.
+func ragDocumentDataset(metadata map[string]string) string {
+	return strings.TrimSpace(metadata["dataset"])
+}
+
+// writeDatasetScope appends the dataset scope of a search to a query whose documents are
+// aliased rd. An empty dataset searches across all datasets.
+func writeDatasetScope(builder *strings.Builder, args []any, dataset string) []any {
+	if dataset == "" {
+		return args
+	}
+	builder.WriteString(fmt.Sprintf(" AND rd.dataset IN ($%d, '')", len(args)+1))
+	return append(args, dataset)
+}
+
+// GetLatestSessionDataset returns the dataset of the session's most recently stored
+// document that has one, or "" when none does.
+func (s *PostgresStore) GetLatestSessionDataset(ctx context.Context, sessionID string) (string, error) {
+	return latestSessionDataset(ctx, s.DB, sessionID)
+}
+
+// backfillRAGDocumentDatasets copies metadata ->> 'dataset' into the dataset column of
+// documents stored before the column existed. Shared by both backends.
+func backfillRAGDocumentDatasets(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE rag_documents SET dataset = TRIM(metadata ->> 'dataset')
+		WHERE dataset = '' AND TRIM(COALESCE(metadata ->> 'dataset', '')) <> ''`)
+	if err != nil {
+		return fmt.Errorf("failed to backfill rag document datasets: %w", err)
+	}
+	return nil
+}
+
+func latestSessionDataset(ctx context.Context, db *sql.DB, sessionID string) (string, error) {
+	var dataset string
+	err := db.QueryRowContext(ctx, `
+		SELECT dataset FROM rag_documents
+		WHERE COALESCE(metadata ->> 'session_id', '') = $1 AND dataset <> ''
+		ORDER BY created_at DESC LIMIT 1`, sessionID).Scan(&dataset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up latest dataset for session %s: %w", sessionID, err)
+	}
+	return dataset, nil
+}
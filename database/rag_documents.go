@@ -68,15 +68,15 @@ func (s *PostgresStore) UpsertDocument(ctx context.Context, documentID uuid.UUID
 	hashValue := sql.NullString{String: contentHash, Valid: contentHash != ""}
 
 	query := `
-		INSERT INTO rag_documents (id, content, metadata, content_hash, created_at)
-		VALUES ($1, $2, $3, $4, NOW())
+		INSERT INTO rag_documents (id, content, metadata, content_hash, dataset, created_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
 		ON CONFLICT (id)
-		DO UPDATE SET content = EXCLUDED.content, metadata = EXCLUDED.metadata, content_hash = EXCLUDED.content_hash, created_at = NOW()
+		DO UPDATE SET content = EXCLUDED.content, metadata = EXCLUDED.metadata, content_hash = EXCLUDED.content_hash, dataset = EXCLUDED.dataset, created_at = NOW(), tier = 'hot', archived_at = NULL
 		RETURNING id
 	`
 
 	var returnedID uuid.UUID
-	if err := s.DB.QueryRowContext(ctx, query, documentID, content, string(metaJSON), hashValue).Scan(&returnedID); err != nil {
+	if err := s.DB.QueryRowContext(ctx, query, documentID, content, string(metaJSON), hashValue, ragDocumentDataset(metadata)).Scan(&returnedID); err != nil {
 		return uuid.Nil, fmt.Errorf("failed to upsert rag document: %w", err)
 	}
 	return returnedID, nil
@@ -289,6 +289,56 @@ func (s *PostgresStore) ListStateDocuments(ctx context.Context, sessionID string
 	return docs, nil
 }
 
+// QueryDocumentsByMetadata returns the newest documents whose metadata contains every
+// key/value pair in filters.
+func (s *PostgresStore) QueryDocumentsByMetadata(ctx context.Context, filters map[string]string, limit int) ([]RAGDocument, error) {
+	if len(filters) == 0 || limit <= 0 {
+		return nil, nil
+	}
+	filterJSON, err := json.Marshal(filters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata filters: %w", err)
+	}
+
+	const query = `
+		SELECT id, content, metadata, content_hash, created_at
+		FROM rag_documents
+		WHERE metadata @> $1::jsonb AND tier = 'hot'
+		ORDER BY created_at DESC
+		LIMIT $2`
+
+	rows, err := s.DB.QueryContext(ctx, query, string(filterJSON), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rag documents by metadata: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []RAGDocument
+	for rows.Next() {
+		var (
+			id        uuid.UUID
+			content   string
+			metaJSON  []byte
+			hash      sql.NullString
+			createdAt time.Time
+		)
+		if err := rows.Scan(&id, &content, &metaJSON, &hash, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan rag document: %w", err)
+		}
+		meta := make(map[string]string)
+		if len(metaJSON) > 0 {
+			if err := json.Unmarshal(metaJSON, &meta); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal metadata for document %s: %w", id, err)
+			}
+		}
+		docs = append(docs, RAGDocument{ID: id, Content: content, Metadata: meta, ContentHash: hash.String, CreatedAt: createdAt})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rag documents: %w", err)
+	}
+	return docs, nil
+}
+
 // DeleteRAGDocument deletes a rag document by id (cascades delete to embeddings via FK).
 func (s *PostgresStore) DeleteRAGDocument(ctx context.Context, id uuid.UUID) error {
 	_, err := s.DB.ExecContext(ctx, `DELETE FROM rag_documents WHERE id = $1`, id)
@@ -565,18 +615,34 @@ func (s *PostgresStore) FindDocumentIDsByContentHash(ctx context.Context, sessio
 // SearchRAGDocumentsBM25 performs a BM25-style full-text search over the stored RAG documents.
 // It returns ranked results ordered by their textual relevance to the provided query.
 func (s *PostgresStore) SearchRAGDocumentsBM25(ctx context.Context, query string, limit int, sessionID string, excludeHashes []string) ([]BM25SearchResult, error) {
+	return s.SearchRAGDocumentsBM25Language(ctx, query, limit, sessionID, excludeHashes, "english", "")
+}
+
+// SearchRAGDocumentsBM25Language runs the BM25 search over documents whose metadata language
+// matches the given PostgreSQL text search configuration (documents without one count as english),
+// stemming both the documents and the query in that language. A non-empty dataset limits the
+// search to that dataset's documents and those not tied to any dataset.
+func (s *PostgresStore) SearchRAGDocumentsBM25Language(ctx context.Context, query string, limit int, sessionID string, excludeHashes []string, language string, dataset string) ([]BM25SearchResult, error) {
+	return s.searchBM25Tier(ctx, query, limit, sessionID, excludeHashes, language, RAGTierHot, dataset)
+}
+
+// searchBM25Tier runs the BM25 search over the documents of one retrieval tier.
+func (s *PostgresStore) searchBM25Tier(ctx context.Context, query string, limit int, sessionID string, excludeHashes []string, language string, tier string, dataset string) ([]BM25SearchResult, error) {
 	trimmed := strings.TrimSpace(query)
 	if trimmed == "" || limit <= 0 {
 		return nil, nil
 	}
+	if language == "" {
+		language = "english"
+	}
 
 	// Try rich websearch_to_tsquery first, then fallback to simpler plainto_tsquery on error
-	results, err := s.searchBM25With(ctx, trimmed, limit, sessionID, excludeHashes, "websearch_to_tsquery")
+	results, err := s.searchBM25With(ctx, trimmed, limit, sessionID, excludeHashes, "websearch_to_tsquery", language, tier, dataset)
 	if err == nil {
 		return results, nil
 	}
 	// Fallback attempt
-	fallback, fbErr := s.searchBM25With(ctx, trimmed, limit, sessionID, excludeHashes, "plainto_tsquery")
+	fallback, fbErr := s.searchBM25With(ctx, trimmed, limit, sessionID, excludeHashes, "plainto_tsquery", language, tier, dataset)
 	if fbErr == nil {
 		return fallback, nil
 	}
@@ -584,15 +650,16 @@ func (s *PostgresStore) SearchRAGDocumentsBM25(ctx context.Context, query string
 }
 
 // searchBM25With builds and executes a BM25-like query using the provided tsquery function name
-// (e.g., "websearch_to_tsquery" or "plainto_tsquery").
-func (s *PostgresStore) searchBM25With(ctx context.Context, trimmed string, limit int, sessionID string, excludeHashes []string, tsFunc string) ([]BM25SearchResult, error) {
+// (e.g., "websearch_to_tsquery" or "plainto_tsquery") and text search configuration.
+func (s *PostgresStore) searchBM25With(ctx context.Context, trimmed string, limit int, sessionID string, excludeHashes []string, tsFunc string, language string, tier string, dataset string) ([]BM25SearchResult, error) {
 	const searchableTextExpr = "rd.content || ' ' || COALESCE(meta.metadata_text, '')"
-	rankExpr := "ts_rank_cd(to_tsvector('english', " + searchableTextExpr + "), " + tsFunc + "('english', $1))"
+	// $2 is the language as a text search configuration, $3 the same value for the metadata filter
+	rankExpr := "ts_rank_cd(to_tsvector($2::regconfig, " + searchableTextExpr + "), " + tsFunc + "($2::regconfig, $1))"
 	positionExpr := "position(lower($1) in lower(" + searchableTextExpr + "))"
 	bonusExpr := "CASE WHEN " + positionExpr + " > 0 THEN 0.2 ELSE 0 END"
 
 	var builder strings.Builder
-	args := []any{trimmed}
+	args := []any{trimmed, language, language}
 
 	builder.WriteString("SELECT rd.id, rd.metadata, rd.content, ")
 	builder.WriteString(rankExpr)
@@ -613,6 +680,14 @@ func (s *PostgresStore) searchBM25With(ctx context.Context, trimmed string, limi
 	// Exclude superseded state cards while preserving all other document types
 	builder.WriteString(" AND (COALESCE(rd.metadata ->> 'type', '') <> 'state' OR COALESCE(rd.metadata ->> 'state_status', '') <> 'superseded')")
 
+	// Only documents in this language
+	builder.WriteString(" AND COALESCE(rd.metadata ->> 'language', 'english') = $3")
+
+	builder.WriteString(" AND rd.tier = $")
+	builder.WriteString(strconv.Itoa(len(args) + 1))
+	args = append(args, tier)
+	args = writeDatasetScope(&builder, args, dataset)
+
 	// Exclude documents with matching content hashes
 	if len(excludeHashes) > 0 {
 		builder.WriteString(" AND (rd.content_hash IS NULL OR rd.content_hash NOT IN (")
@@ -690,6 +765,18 @@ type VectorSearchResult struct {
 // VectorSearchRAGDocuments performs a cosine similarity search using pgvector.
 // Returns documents ordered by similarity (highest first), joining embeddings with documents.
 func (s *PostgresStore) VectorSearchRAGDocuments(ctx context.Context, queryVector []float32, limit int, sessionID string, excludeHashes []string) ([]VectorSearchResult, error) {
+	return s.VectorSearchRAGDocumentsForModel(ctx, queryVector, limit, sessionID, excludeHashes, "", "")
+}
+
+// VectorSearchRAGDocumentsForModel restricts the vector search to documents embedded by the
+// given embedding model ("" for the default host), since vectors from different models are
+// not comparable. A non-empty dataset scopes the search like SearchRAGDocumentsBM25Language.
+func (s *PostgresStore) VectorSearchRAGDocumentsForModel(ctx context.Context, queryVector []float32, limit int, sessionID string, excludeHashes []string, embeddingModel string, dataset string) ([]VectorSearchResult, error) {
+	return s.vectorSearchTier(ctx, queryVector, limit, sessionID, excludeHashes, embeddingModel, RAGTierHot, dataset)
+}
+
+// vectorSearchTier runs the vector search over the documents of one retrieval tier.
+func (s *PostgresStore) vectorSearchTier(ctx context.Context, queryVector []float32, limit int, sessionID string, excludeHashes []string, embeddingModel string, tier string, dataset string) ([]VectorSearchResult, error) {
 	if len(queryVector) == 0 || limit <= 0 {
 		return nil, nil
 	}
@@ -704,6 +791,9 @@ func (s *PostgresStore) VectorSearchRAGDocuments(ctx context.Context, queryVecto
 	builder.WriteString("FROM rag_embeddings re ")
 	builder.WriteString("INNER JOIN rag_documents rd ON re.document_id = rd.id ")
 	builder.WriteString("WHERE re.embedding IS NOT NULL ")
+	builder.WriteString("AND COALESCE(rd.metadata ->> 'embedding_model', '') = $2 ")
+	builder.WriteString("AND rd.tier = $3 ")
+	args = append(args, embeddingModel, tier)
 
 	// Apply session-specific filtering when provided
 	if sessionID != "" {
@@ -712,6 +802,8 @@ func (s *PostgresStore) VectorSearchRAGDocuments(ctx context.Context, queryVecto
 		args = append(args, sessionID)
 		builder.WriteString(" ")
 	}
+	args = writeDatasetScope(&builder, args, dataset)
+	builder.WriteString(" ")
 
 	// Exclude superseded state cards while preserving other types
 	builder.WriteString("AND (COALESCE(rd.metadata ->> 'type', '') <> 'state' OR COALESCE(rd.metadata ->> 'state_status', '') <> 'superseded') ")
@@ -783,6 +875,45 @@ func (s *PostgresStore) VectorSearchRAGDocuments(ctx context.Context, queryVecto
 	return results, nil
 }
 
+// GetSessionDocumentLanguages returns the distinct detected languages and embedding models
+// of a session's documents. Documents without a language tag are not included.
+func (s *PostgresStore) GetSessionDocumentLanguages(ctx context.Context, sessionID string) (languages []string, embeddingModels []string, err error) {
+	query := `
+		SELECT DISTINCT COALESCE(metadata ->> 'language', ''), COALESCE(metadata ->> 'embedding_model', '')
+		FROM rag_documents
+		WHERE metadata ->> 'session_id' = $1
+		  AND (metadata ? 'language' OR metadata ? 'embedding_model')
+	`
+
+	rows, err := s.DB.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query document languages for session %s: %w", sessionID, err)
+	}
+	defer rows.Close()
+
+	seenLang := make(map[string]bool)
+	seenModel := make(map[string]bool)
+	for rows.Next() {
+		var language, model string
+		if err := rows.Scan(&language, &model); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan document language: %w", err)
+		}
+		if language != "" && !seenLang[language] {
+			seenLang[language] = true
+			languages = append(languages, language)
+		}
+		if model != "" && !seenModel[model] {
+			seenModel[model] = true
+			embeddingModels = append(embeddingModels, model)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating document languages: %w", err)
+	}
+
+	return languages, embeddingModels, nil
+}
+
 // DeleteRAGDocumentsBySession removes all RAG documents associated with the provided session.
 func (s *PostgresStore) DeleteRAGDocumentsBySession(ctx context.Context, sessionID uuid.UUID) (int64, error) {
 	const query = `DELETE FROM rag_documents WHERE metadata ->> 'session_id' = $1`
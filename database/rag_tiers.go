@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Retrieval tiers of rag_documents. Hot documents are searched on every turn; archived
+// documents are only searched when the user asks for their full history.
+const (
+	RAGTierHot      = "hot"
+	RAGTierArchived = "archived"
+)
+
+// ArchiveRAGDocuments moves hot documents created before createdBefore whose role is one of
+// roles (or summaries of such documents) to the archived tier. Returns the number moved.
+func (s *PostgresStore) ArchiveRAGDocuments(ctx context.Context, createdBefore time.Time, roles []string) (int64, error) {
+	return archiveRAGDocuments(ctx, s.DB, time.Now(), createdBefore, roles)
+}
+
+// DeleteArchivedRAGDocuments removes documents archived before archivedBefore (their
+// embeddings cascade). Returns the number deleted.
+func (s *PostgresStore) DeleteArchivedRAGDocuments(ctx context.Context, archivedBefore time.Time) (int64, error) {
+	return deleteArchivedRAGDocuments(ctx, s.DB, archivedBefore)
+}
+
+// SearchArchivedRAGDocumentsBM25 runs the BM25 search over a session's archived documents.
+func (s *PostgresStore) SearchArchivedRAGDocumentsBM25(ctx context.Context, query string, limit int, sessionID string) ([]BM25SearchResult, error) {
+	return s.searchBM25Tier(ctx, query, limit, sessionID, nil, "english", RAGTierArchived, "")
+}
+
+// VectorSearchArchivedRAGDocuments runs the vector search over a session's archived documents.
+func (s *PostgresStore) VectorSearchArchivedRAGDocuments(ctx context.Context, queryVector []float32, limit int, sessionID string, embeddingModel string) ([]VectorSearchResult, error) {
+	return s.vectorSearchTier(ctx, queryVector, limit, sessionID, nil, embeddingModel, RAGTierArchived, "")
+}
+
+// archiveRAGDocuments is shared by both backends; the statement is portable and the
+// caller passes times already converted for its driver.
+func archiveRAGDocuments(ctx context.Context, db *sql.DB, now, createdBefore time.Time, roles []string) (int64, error) {
+	if len(roles) == 0 {
+		return 0, nil
+	}
+
+	args := []any{now, createdBefore}
+	placeholders := make([]string, len(roles))
+	for i, role := range roles {
+		placeholders[i] = fmt.Sprintf("$%d", len(args)+1)
+		args = append(args, role)
+	}
+	roleList := strings.Join(placeholders, ", ")
+
+	// Summaries of long messages follow the tier of the message they summarize
+	query := `
+		UPDATE rag_documents SET tier = 'archived', archived_at = $1
+		WHERE tier = 'hot' AND created_at < $2
+		  AND (COALESCE(metadata ->> 'role', '') IN (` + roleList + `)
+		       OR (COALESCE(metadata ->> 'role', '') = 'summary' AND COALESCE(metadata ->> 'parent_document_role', '') IN (` + roleList + `)))`
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive rag documents: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to determine rows archived: %w", err)
+	}
+	return rowsAffected, nil
+}
+
+func deleteArchivedRAGDocuments(ctx context.Context, db *sql.DB, archivedBefore time.Time) (int64, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM rag_documents WHERE tier = 'archived' AND archived_at < $1`, archivedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete archived rag documents: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to determine rows deleted: %w", err)
+	}
+	return rowsAffected, nil
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// MessageArtifactRefs identifies messages being retracted and the keys their RAG
+// artifacts were stored under.
+type MessageArtifactRefs struct {
+	// MessageIDs are the messages to delete; annotations stored for them are matched by ID
+	MessageIDs []uuid.UUID
+	// ContentHashes are the messages' content hashes. A standalone message's document has
+	// the same content_hash; facts record theirs as message_hash and tool_content_hash,
+	// state cards as source_content_hash.
+	ContentHashes []string
+}
+
+// RetractionResult counts what a retraction deleted.
+type RetractionResult struct {
+	Messages  int64
+	Documents int64
+}
+
+// Retraction uses only portable SQL (->> and $N placeholders), so both backends share it.
+
+// RetractMessageArtifacts deletes messages together with every RAG document derived from
+// them (facts, summaries, chunks, state cards, annotations; embeddings cascade), in one
+// transaction. Derived documents are found first by the refs' hashes and message IDs and
+// then by following parent_document_id links from documents already found.
+func (s *PostgresStore) RetractMessageArtifacts(ctx context.Context, sessionID uuid.UUID, refs MessageArtifactRefs) (RetractionResult, error) {
+	return retractMessageArtifacts(ctx, s.DB, sessionID, refs)
+}
+
+// RetractMessageArtifacts deletes messages together with every RAG document derived from
+// them, in one transaction. See PostgresStore.RetractMessageArtifacts.
+func (s *SQLiteStore) RetractMessageArtifacts(ctx context.Context, sessionID uuid.UUID, refs MessageArtifactRefs) (RetractionResult, error) {
+	return retractMessageArtifacts(ctx, s.DB, sessionID, refs)
+}
+
+// DeleteMessageDocuments deletes the session's RAG documents derived from messages with
+// the given content hashes, and their descendants, keeping the messages. Used to rebuild
+// a session's memory from its messages.
+func (s *PostgresStore) DeleteMessageDocuments(ctx context.Context, sessionID uuid.UUID, contentHashes []string) (int64, error) {
+	return deleteMessageDocuments(ctx, s.DB, sessionID, contentHashes)
+}
+
+// DeleteMessageDocuments deletes the session's RAG documents derived from messages with
+// the given content hashes. See PostgresStore.DeleteMessageDocuments.
+func (s *SQLiteStore) DeleteMessageDocuments(ctx context.Context, sessionID uuid.UUID, contentHashes []string) (int64, error) {
+	return deleteMessageDocuments(ctx, s.DB, sessionID, contentHashes)
+}
+
+func deleteMessageDocuments(ctx context.Context, db *sql.DB, sessionID uuid.UUID, contentHashes []string) (int64, error) {
+	if len(contentHashes) == 0 {
+		return 0, nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin message document deletion: %w", err)
+	}
+	defer tx.Rollback()
+
+	found, err := findMessageArtifacts(ctx, tx, sessionID.String(), nil, MessageArtifactRefs{ContentHashes: contentHashes})
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	if len(found) > 0 {
+		in, args := inClause(1, found)
+		res, err := tx.ExecContext(ctx, `DELETE FROM rag_documents WHERE id IN (`+in+`)`, args...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete message documents: %w", err)
+		}
+		deleted, _ = res.RowsAffected()
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit message document deletion: %w", err)
+	}
+	return deleted, nil
+}
+
+func retractMessageArtifacts(ctx context.Context, db *sql.DB, sessionID uuid.UUID, refs MessageArtifactRefs) (RetractionResult, error) {
+	var result RetractionResult
+	if len(refs.MessageIDs) == 0 {
+		return result, nil
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("failed to begin message retraction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Phase 1: collect the derived documents
+	messageIDs := make([]string, len(refs.MessageIDs))
+	for i, id := range refs.MessageIDs {
+		messageIDs[i] = id.String()
+	}
+	found, err := findMessageArtifacts(ctx, tx, sessionID.String(), messageIDs, refs)
+	if err != nil {
+		return result, err
+	}
+
+	// Phase 2: delete documents, message rows and the rows keyed by them together
+	if len(found) > 0 {
+		in, args := inClause(1, found)
+		res, err := tx.ExecContext(ctx, `DELETE FROM rag_documents WHERE id IN (`+in+`)`, args...)
+		if err != nil {
+			return result, fmt.Errorf("failed to delete retracted documents: %w", err)
+		}
+		result.Documents, _ = res.RowsAffected()
+	}
+
+	in, args := inClause(2, messageIDs)
+	args = append([]any{sessionID}, args...)
+	for _, table := range []string{"message_annotations", "step_bookmarks"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE session_id = $1 AND message_id IN (`+in+`)`, args...); err != nil {
+			return result, fmt.Errorf("failed to delete %s of retracted messages: %w", table, err)
+		}
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE session_id = $1 AND id IN (`+in+`)`, args...)
+	if err != nil {
+		return result, fmt.Errorf("failed to delete retracted messages: %w", err)
+	}
+	result.Messages, _ = res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("failed to commit message retraction: %w", err)
+	}
+	return result, nil
+}
+
+// findMessageArtifacts returns the IDs of the session's documents stored for the messages,
+// plus their descendants through parent_document_id.
+func findMessageArtifacts(ctx context.Context, tx *sql.Tx, sessionID string, messageIDs []string, refs MessageArtifactRefs) ([]string, error) {
+	var conditions []string
+	args := []any{sessionID}
+	addIn := func(expr string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		in, vals := inClause(len(args)+1, values)
+		conditions = append(conditions, expr+" IN ("+in+")")
+		args = append(args, vals...)
+	}
+	addIn("content_hash", refs.ContentHashes)
+	addIn("(metadata ->> 'message_hash')", refs.ContentHashes)
+	addIn("(metadata ->> 'tool_content_hash')", refs.ContentHashes)
+	addIn("(metadata ->> 'source_content_hash')", refs.ContentHashes)
+	addIn("(metadata ->> 'message_id')", messageIDs)
+
+	seen := make(map[string]bool)
+	var found []string
+	frontier, err := queryDocumentIDs(ctx, tx,
+		`SELECT id FROM rag_documents WHERE (metadata ->> 'session_id') = $1 AND (`+strings.Join(conditions, " OR ")+`)`, args)
+	if err != nil {
+		return nil, err
+	}
+	// Summaries and chunks point at their parent; walk down until no new children appear
+	for len(frontier) > 0 {
+		var next []string
+		for _, id := range frontier {
+			if !seen[id] {
+				seen[id] = true
+				found = append(found, id)
+				next = append(next, id)
+			}
+		}
+		if len(next) == 0 {
+			break
+		}
+		in, vals := inClause(2, next)
+		frontier, err = queryDocumentIDs(ctx, tx,
+			`SELECT id FROM rag_documents WHERE (metadata ->> 'session_id') = $1 AND (metadata ->> 'parent_document_id') IN (`+in+`)`,
+			append([]any{sessionID}, vals...))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+func queryDocumentIDs(ctx context.Context, tx *sql.Tx, query string, args []any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find message artifacts: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan message artifact: %w", err)
+		}
+		ids = append(ids, id.String())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message artifacts: %w", err)
+	}
+	return ids, nil
+}
+
+// inClause returns "$start,$start+1,..." for values and the values as query arguments.
+func inClause(start int, values []string) (string, []any) {
+	placeholders := make([]string, len(values))
+	args := make([]any, len(values))
+	for i, v := range values {
+		placeholders[i] = fmt.Sprintf("$%d", start+i)
+		args[i] = v
+	}
+	return strings.Join(placeholders, ","), args
+}
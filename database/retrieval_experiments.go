@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetrievalArmSummary aggregates one outcome signal for one experiment arm.
+type RetrievalArmSummary struct {
+	Arm      string  `json:"arm"`
+	Signal   string  `json:"signal"`
+	Count    int64   `json:"count"`
+	Average  float64 `json:"average"`
+	Sessions int64   `json:"sessions"`
+}
+
+// CreateRetrievalExperimentEvent records an outcome signal for the arm a session was assigned to.
+func (s *PostgresStore) CreateRetrievalExperimentEvent(ctx context.Context, sessionID uuid.UUID, arm, signal string, value float64) error {
+	query := `
+		INSERT INTO retrieval_experiment_events (session_id, arm, signal, value)
+		VALUES ($1, $2, $3, $4)
+	`
+	if _, err := s.DB.ExecContext(ctx, query, sessionID, arm, signal, value); err != nil {
+		return fmt.Errorf("failed to create retrieval experiment event: %w", err)
+	}
+	return nil
+}
+
+// GetRetrievalExperimentSummary aggregates experiment events recorded at or after since, per arm and signal.
+func (s *PostgresStore) GetRetrievalExperimentSummary(ctx context.Context, since time.Time) ([]RetrievalArmSummary, error) {
+	query := `
+		SELECT arm, signal, COUNT(*), AVG(value), COUNT(DISTINCT session_id)
+		FROM retrieval_experiment_events
+		WHERE created_at >= $1
+		GROUP BY arm, signal
+		ORDER BY arm, signal
+	`
+	rows, err := s.DB.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query retrieval experiment summary: %w", err)
+	}
+	defer rows.Close()
+
+	var summaries []RetrievalArmSummary
+	for rows.Next() {
+		var summary RetrievalArmSummary
+		if err := rows.Scan(&summary.Arm, &summary.Signal, &summary.Count, &summary.Average, &summary.Sessions); err != nil {
+			return nil, fmt.Errorf("failed to scan retrieval experiment summary: %w", err)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating retrieval experiment summary: %w", err)
+	}
+
+	return summaries, nil
+}
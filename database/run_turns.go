@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// SaveRunTurn records one agent turn for offline replay.
+func (s *PostgresStore) SaveRunTurn(ctx context.Context, turn types.RunTurn) error {
+	sessionID, err := uuid.Parse(turn.SessionID)
+	if err != nil {
+		return fmt.Errorf("invalid session ID: %w", err)
+	}
+	runID, err := uuid.Parse(turn.RunID)
+	if err != nil {
+		return fmt.Errorf("invalid run ID: %w", err)
+	}
+	messagesJSON, err := json.Marshal(turn.Messages)
+	if err != nil {
+		return fmt.Errorf("failed to marshal run turn messages: %w", err)
+	}
+
+	query := `
+		INSERT INTO run_turns (session_id, run_id, turn, query, retrieval, messages, prompt_version, host, temperature, response)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	if _, err := s.DB.ExecContext(ctx, query, sessionID, runID, turn.Turn, turn.Query, turn.Retrieval,
+		messagesJSON, turn.PromptVersion, turn.Host, turn.Temperature, turn.Response); err != nil {
+		return fmt.Errorf("failed to save run turn: %w", err)
+	}
+	return nil
+}
+
+// GetRunTurns returns the recorded turns of a run in order. An empty runID selects the
+// session's most recent run.
+func (s *PostgresStore) GetRunTurns(ctx context.Context, sessionID uuid.UUID, runID string) ([]types.RunTurn, error) {
+	query := `
+		SELECT run_id, turn, COALESCE(query, ''), COALESCE(retrieval, ''), messages,
+		       COALESCE(prompt_version, ''), COALESCE(host, ''), COALESCE(temperature, 0), COALESCE(response, ''), created_at
+		FROM run_turns
+		WHERE session_id = $1 AND run_id = $2
+		ORDER BY turn, id
+	`
+	args := []any{sessionID, runID}
+	if runID == "" {
+		query = `
+		SELECT run_id, turn, COALESCE(query, ''), COALESCE(retrieval, ''), messages,
+		       COALESCE(prompt_version, ''), COALESCE(host, ''), COALESCE(temperature, 0), COALESCE(response, ''), created_at
+		FROM run_turns
+		WHERE session_id = $1 AND run_id = (
+			SELECT run_id FROM run_turns WHERE session_id = $1 ORDER BY created_at DESC, id DESC LIMIT 1
+		)
+		ORDER BY turn, id
+	`
+		args = []any{sessionID}
+	} else if _, err := uuid.Parse(runID); err != nil {
+		return nil, fmt.Errorf("invalid run ID: %w", err)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query run turns: %w", err)
+	}
+	defer rows.Close()
+
+	var turns []types.RunTurn
+	for rows.Next() {
+		var turn types.RunTurn
+		var messagesJSON []byte
+		if err := rows.Scan(&turn.RunID, &turn.Turn, &turn.Query, &turn.Retrieval, &messagesJSON,
+			&turn.PromptVersion, &turn.Host, &turn.Temperature, &turn.Response, &turn.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan run turn: %w", err)
+		}
+		if err := json.Unmarshal(messagesJSON, &turn.Messages); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal run turn messages: %w", err)
+		}
+		turn.SessionID = sessionID.String()
+		turns = append(turns, turn)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating run turns: %w", err)
+	}
+
+	return turns, nil
+}
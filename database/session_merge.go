@@ -0,0 +1,291 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// SessionMergeResult counts what a session merge moved and what it dropped as duplicates
+// of rows the target session already had.
+type SessionMergeResult struct {
+	Messages           int64
+	DuplicateMessages  int64
+	Documents          int64
+	DuplicateDocuments int64
+	Files              int64
+	DuplicateFiles     int64
+}
+
+// Merging uses only portable SQL (->>, REPLACE and $N placeholders), so both backends
+// share it. Metadata is rewritten in Go rather than with jsonb_set/json_set.
+
+// MergeSessions moves everything of sourceID into targetID in one transaction and deletes
+// the source session, leaving a redirect from its ID. See mergeSessions.
+func (s *PostgresStore) MergeSessions(ctx context.Context, sourceID, targetID uuid.UUID, fileNames map[string]string) (SessionMergeResult, error) {
+	return mergeSessions(ctx, s.DB, sourceID, targetID, fileNames)
+}
+
+// MergeSessions moves everything of sourceID into targetID in one transaction and deletes
+// the source session, leaving a redirect from its ID. See mergeSessions.
+func (s *SQLiteStore) MergeSessions(ctx context.Context, sourceID, targetID uuid.UUID, fileNames map[string]string) (SessionMergeResult, error) {
+	return mergeSessions(ctx, s.DB, sourceID, targetID, fileNames)
+}
+
+// ResolveSessionRedirect returns the session a merged session ID now points to, or
+// sql.ErrNoRows.
+func (s *PostgresStore) ResolveSessionRedirect(ctx context.Context, sessionID uuid.UUID) (uuid.UUID, error) {
+	return resolveSessionRedirect(ctx, s.DB, sessionID)
+}
+
+// ResolveSessionRedirect returns the session a merged session ID now points to, or
+// sql.ErrNoRows.
+func (s *SQLiteStore) ResolveSessionRedirect(ctx context.Context, sessionID uuid.UUID) (uuid.UUID, error) {
+	return resolveSessionRedirect(ctx, s.DB, sessionID)
+}
+
+func resolveSessionRedirect(ctx context.Context, db *sql.DB, sessionID uuid.UUID) (uuid.UUID, error) {
+	var target uuid.UUID
+	err := db.QueryRowContext(ctx, `SELECT session_id FROM session_redirects WHERE old_session_id = $1`, sessionID).Scan(&target)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return target, nil
+}
+
+// mergeSessions folds the source session into the target. Messages keep their created_at,
+// so the merged history reads chronologically. A source message or RAG document whose
+// role and content hash the target already has is dropped as a duplicate; the rest are
+// moved, with session_id rewritten in document metadata and workspace links in rendered
+// messages. fileNames maps source filenames to their name in the target workspace (the
+// caller has already moved the files on disk); a source file whose name the target
+// already tracks is dropped. Redirects to the source, and the source itself, then point
+// to the target.
+func mergeSessions(ctx context.Context, db *sql.DB, sourceID, targetID uuid.UUID, fileNames map[string]string) (SessionMergeResult, error) {
+	var result SessionMergeResult
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, fmt.Errorf("failed to begin session merge: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := mergeSessionFiles(ctx, tx, sourceID, targetID, fileNames, &result); err != nil {
+		return result, err
+	}
+	if err := mergeSessionMessages(ctx, tx, sourceID, targetID, fileNames, &result); err != nil {
+		return result, err
+	}
+	if err := mergeSessionDocuments(ctx, tx, sourceID, targetID, &result); err != nil {
+		return result, err
+	}
+
+	for _, table := range []string{"step_bookmarks", "message_annotations", "run_turns", "retrieval_experiment_events", "action_results"} {
+		if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET session_id = $2 WHERE session_id = $1`, sourceID, targetID); err != nil {
+			return result, fmt.Errorf("failed to move %s: %w", table, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE session_redirects SET session_id = $2 WHERE session_id = $1`, sourceID, targetID); err != nil {
+		return result, fmt.Errorf("failed to update session redirects: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO session_redirects (old_session_id, session_id) VALUES ($1, $2)`, sourceID, targetID); err != nil {
+		return result, fmt.Errorf("failed to record session redirect: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE sessions SET last_active = CURRENT_TIMESTAMP WHERE id = $1`, targetID); err != nil {
+		return result, fmt.Errorf("failed to touch merged session: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1`, sourceID); err != nil {
+		return result, fmt.Errorf("failed to delete merged session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("failed to commit session merge: %w", err)
+	}
+	return result, nil
+}
+
+func mergeSessionFiles(ctx context.Context, tx *sql.Tx, sourceID, targetID uuid.UUID, fileNames map[string]string, result *SessionMergeResult) error {
+	tracked := make(map[string]bool)
+	rows, err := tx.QueryContext(ctx, `SELECT filename FROM files WHERE session_id = $1`, targetID)
+	if err != nil {
+		return fmt.Errorf("failed to query target files: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan target file: %w", err)
+		}
+		tracked[name] = true
+	}
+	rows.Close()
+
+	type sourceFile struct {
+		id       uuid.UUID
+		filename string
+		filePath string
+	}
+	var files []sourceFile
+	rows, err = tx.QueryContext(ctx, `SELECT id, filename, file_path FROM files WHERE session_id = $1`, sourceID)
+	if err != nil {
+		return fmt.Errorf("failed to query source files: %w", err)
+	}
+	for rows.Next() {
+		var f sourceFile
+		if err := rows.Scan(&f.id, &f.filename, &f.filePath); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan source file: %w", err)
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read source files: %w", err)
+	}
+
+	for _, f := range files {
+		name := f.filename
+		if renamed, ok := fileNames[name]; ok {
+			name = renamed
+		}
+		if tracked[name] {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM files WHERE id = $1`, f.id); err != nil {
+				return fmt.Errorf("failed to drop duplicate file %s: %w", f.filename, err)
+			}
+			result.DuplicateFiles++
+			continue
+		}
+		filePath := strings.ReplaceAll(f.filePath, sourceID.String(), targetID.String())
+		if name != f.filename && strings.HasSuffix(filePath, f.filename) {
+			filePath = strings.TrimSuffix(filePath, f.filename) + name
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE files SET session_id = $2, filename = $3, file_path = $4 WHERE id = $1`,
+			f.id, targetID, name, filePath); err != nil {
+			return fmt.Errorf("failed to move file %s: %w", f.filename, err)
+		}
+		tracked[name] = true
+		result.Files++
+	}
+	return nil
+}
+
+func mergeSessionMessages(ctx context.Context, tx *sql.Tx, sourceID, targetID uuid.UUID, fileNames map[string]string, result *SessionMergeResult) error {
+	duplicates, err := queryDocumentIDs(ctx, tx, `
+		SELECT m.id FROM messages m
+		WHERE m.session_id = $1 AND m.content_hash <> ''
+		  AND EXISTS (SELECT 1 FROM messages t WHERE t.session_id = $2 AND t.role = m.role AND t.content_hash = m.content_hash)`,
+		[]any{sourceID, targetID})
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		in, args := inClause(2, duplicates)
+		args = append([]any{sourceID}, args...)
+		for _, table := range []string{"message_annotations", "step_bookmarks"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE session_id = $1 AND message_id IN (`+in+`)`, args...); err != nil {
+				return fmt.Errorf("failed to delete %s of duplicate messages: %w", table, err)
+			}
+		}
+		res, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE session_id = $1 AND id IN (`+in+`)`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to delete duplicate messages: %w", err)
+		}
+		result.DuplicateMessages, _ = res.RowsAffected()
+	}
+
+	// Rendered messages link workspace files by URL: renamed files first, then the rest
+	sourcePrefix := "/workspaces/" + sourceID.String() + "/"
+	targetPrefix := "/workspaces/" + targetID.String() + "/"
+	for from, to := range fileNames {
+		if from == to {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE messages SET rendered = REPLACE(rendered, $2, $3) WHERE session_id = $1`,
+			sourceID, sourcePrefix+from, targetPrefix+to); err != nil {
+			return fmt.Errorf("failed to relink renamed file %s: %w", from, err)
+		}
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE messages SET session_id = $2, rendered = REPLACE(rendered, $3, $4) WHERE session_id = $1`,
+		sourceID, targetID, sourcePrefix, targetPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to move messages: %w", err)
+	}
+	result.Messages, _ = res.RowsAffected()
+	return nil
+}
+
+func mergeSessionDocuments(ctx context.Context, tx *sql.Tx, sourceID, targetID uuid.UUID, result *SessionMergeResult) error {
+	existing := make(map[string]bool)
+	rows, err := tx.QueryContext(ctx, `
+		SELECT COALESCE(metadata ->> 'role', ''), content_hash FROM rag_documents
+		WHERE (metadata ->> 'session_id') = $1 AND content_hash IS NOT NULL`, targetID.String())
+	if err != nil {
+		return fmt.Errorf("failed to query target documents: %w", err)
+	}
+	for rows.Next() {
+		var role, hash string
+		if err := rows.Scan(&role, &hash); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan target document: %w", err)
+		}
+		existing[role+"\x00"+hash] = true
+	}
+	rows.Close()
+
+	type sourceDocument struct {
+		id       uuid.UUID
+		hash     sql.NullString
+		metadata []byte
+	}
+	var documents []sourceDocument
+	rows, err = tx.QueryContext(ctx, `SELECT id, content_hash, metadata FROM rag_documents WHERE (metadata ->> 'session_id') = $1`, sourceID.String())
+	if err != nil {
+		return fmt.Errorf("failed to query source documents: %w", err)
+	}
+	for rows.Next() {
+		var d sourceDocument
+		if err := rows.Scan(&d.id, &d.hash, &d.metadata); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan source document: %w", err)
+		}
+		documents = append(documents, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read source documents: %w", err)
+	}
+
+	for _, d := range documents {
+		metadata := make(map[string]any)
+		if err := json.Unmarshal(d.metadata, &metadata); err != nil {
+			return fmt.Errorf("failed to decode metadata of document %s: %w", d.id, err)
+		}
+		role, _ := metadata["role"].(string)
+		if d.hash.Valid && existing[role+"\x00"+d.hash.String] {
+			// Embeddings cascade
+			if _, err := tx.ExecContext(ctx, `DELETE FROM rag_documents WHERE id = $1`, d.id); err != nil {
+				return fmt.Errorf("failed to drop duplicate document %s: %w", d.id, err)
+			}
+			result.DuplicateDocuments++
+			continue
+		}
+		metadata["session_id"] = targetID.String()
+		metaJSON, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata of document %s: %w", d.id, err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE rag_documents SET metadata = $2 WHERE id = $1`, d.id, string(metaJSON)); err != nil {
+			return fmt.Errorf("failed to move document %s: %w", d.id, err)
+		}
+		if d.hash.Valid {
+			existing[role+"\x00"+d.hash.String] = true
+		}
+		result.Documents++
+	}
+	return nil
+}
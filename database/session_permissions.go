@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// Session permissions are plain rows, so both backends share the SQL.
+
+const sessionPermissionColumns = `id, session_id, role, created_by, created_at, expires_at, revoked_at`
+
+// CreateSessionPermission stores a role grant on a session and returns it.
+func (s *PostgresStore) CreateSessionPermission(ctx context.Context, permission types.SessionPermission) (types.SessionPermission, error) {
+	return createSessionPermission(ctx, s.DB, permission)
+}
+
+// GetSessionPermission returns a permission, or sql.ErrNoRows.
+func (s *PostgresStore) GetSessionPermission(ctx context.Context, permissionID uuid.UUID) (types.SessionPermission, error) {
+	return getSessionPermission(ctx, s.DB, permissionID)
+}
+
+// ListSessionPermissions returns the session's permissions that are not revoked, newest first.
+func (s *PostgresStore) ListSessionPermissions(ctx context.Context, sessionID uuid.UUID) ([]types.SessionPermission, error) {
+	return listSessionPermissions(ctx, s.DB, sessionID)
+}
+
+// RevokeSessionPermission revokes one of the session's permissions, or returns sql.ErrNoRows.
+func (s *PostgresStore) RevokeSessionPermission(ctx context.Context, sessionID, permissionID uuid.UUID) error {
+	return revokeSessionPermission(ctx, s.DB, sessionID, permissionID)
+}
+
+// CreateSessionPermission stores a role grant on a session and returns it.
+func (s *SQLiteStore) CreateSessionPermission(ctx context.Context, permission types.SessionPermission) (types.SessionPermission, error) {
+	return createSessionPermission(ctx, s.DB, permission)
+}
+
+// GetSessionPermission returns a permission, or sql.ErrNoRows.
+func (s *SQLiteStore) GetSessionPermission(ctx context.Context, permissionID uuid.UUID) (types.SessionPermission, error) {
+	return getSessionPermission(ctx, s.DB, permissionID)
+}
+
+// ListSessionPermissions returns the session's permissions that are not revoked, newest first.
+func (s *SQLiteStore) ListSessionPermissions(ctx context.Context, sessionID uuid.UUID) ([]types.SessionPermission, error) {
+	return listSessionPermissions(ctx, s.DB, sessionID)
+}
+
+// RevokeSessionPermission revokes one of the session's permissions, or returns sql.ErrNoRows.
+func (s *SQLiteStore) RevokeSessionPermission(ctx context.Context, sessionID, permissionID uuid.UUID) error {
+	return revokeSessionPermission(ctx, s.DB, sessionID, permissionID)
+}
+
+func createSessionPermission(ctx context.Context, db *sql.DB, permission types.SessionPermission) (types.SessionPermission, error) {
+	if permission.ID == uuid.Nil {
+		permission.ID = uuid.New()
+	}
+	permission.CreatedAt = time.Now().UTC()
+	var createdBy uuid.NullUUID
+	if permission.CreatedBy != nil {
+		createdBy = uuid.NullUUID{UUID: *permission.CreatedBy, Valid: true}
+	}
+	var expiresAt sql.NullTime
+	if permission.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: permission.ExpiresAt.UTC(), Valid: true}
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO session_permissions (id, session_id, role, created_by, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		permission.ID, permission.SessionID, string(permission.Role), createdBy, permission.CreatedAt, expiresAt)
+	if err != nil {
+		return types.SessionPermission{}, fmt.Errorf("failed to save session permission: %w", err)
+	}
+	return permission, nil
+}
+
+func getSessionPermission(ctx context.Context, db *sql.DB, permissionID uuid.UUID) (types.SessionPermission, error) {
+	row := db.QueryRowContext(ctx, `SELECT `+sessionPermissionColumns+` FROM session_permissions WHERE id = $1`, permissionID)
+	return scanSessionPermission(row)
+}
+
+func listSessionPermissions(ctx context.Context, db *sql.DB, sessionID uuid.UUID) ([]types.SessionPermission, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+sessionPermissionColumns+` FROM session_permissions
+		WHERE session_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list session permissions: %w", err)
+	}
+	defer rows.Close()
+
+	var permissions []types.SessionPermission
+	for rows.Next() {
+		permission, err := scanSessionPermission(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session permission: %w", err)
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+func revokeSessionPermission(ctx context.Context, db *sql.DB, sessionID, permissionID uuid.UUID) error {
+	result, err := db.ExecContext(ctx, `
+		UPDATE session_permissions SET revoked_at = $3
+		WHERE id = $1 AND session_id = $2 AND revoked_at IS NULL`,
+		permissionID, sessionID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to revoke session permission: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to revoke session permission: %w", err)
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanSessionPermission(row interface{ Scan(...any) error }) (types.SessionPermission, error) {
+	var permission types.SessionPermission
+	var role string
+	var createdBy uuid.NullUUID
+	var expiresAt, revokedAt sql.NullTime
+	if err := row.Scan(&permission.ID, &permission.SessionID, &role, &createdBy, &permission.CreatedAt, &expiresAt, &revokedAt); err != nil {
+		return types.SessionPermission{}, err
+	}
+	permission.Role = types.SessionRole(role)
+	if createdBy.Valid {
+		permission.CreatedBy = &createdBy.UUID
+	}
+	if expiresAt.Valid {
+		permission.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		permission.RevokedAt = &revokedAt.Time
+	}
+	return permission, nil
+}
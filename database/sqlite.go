@@ -0,0 +1,726 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore implements Store on a single SQLite file for single-user desktop installs.
+// Embeddings are stored as little-endian float32 blobs and both vector and keyword search
+// are scored in Go over the filtered candidates, which is fast enough for the few thousand
+// documents a single researcher accumulates.
+type SQLiteStore struct {
+	DB *sql.DB
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	// Foreign keys are off by default in SQLite and the pragma is per connection, so it goes in
+	// the DSN. WAL plus a busy timeout lets readers proceed while the agent writes.
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Set("_time_format", "sqlite")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	return &SQLiteStore{DB: db}, nil
+}
+
+// sqliteTime normalizes timestamps to UTC so the stored text sorts and compares chronologically.
+func sqliteTime(t time.Time) time.Time {
+	return t.UTC()
+}
+
+// EnsureSchema creates the required tables if they do not already exist. The layout mirrors
+// the PostgreSQL schema with JSON kept as TEXT and embeddings as BLOBs.
+func (s *SQLiteStore) EnsureSchema(ctx context.Context) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS users (
+            id TEXT PRIMARY KEY,
+            email TEXT UNIQUE,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS user_identities (
+            provider TEXT NOT NULL,
+            subject TEXT NOT NULL,
+            user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            email TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (provider, subject)
+        )`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+            id TEXT PRIMARY KEY,
+            user_id TEXT REFERENCES users(id) ON DELETE CASCADE,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            last_active TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            workspace_path TEXT NOT NULL,
+            title TEXT DEFAULT '',
+            is_active BOOLEAN DEFAULT 1,
+            mode TEXT DEFAULT 'dataset',
+            verbosity TEXT DEFAULT 'standard',
+            effect_size_check TEXT DEFAULT 'note',
+            tags TEXT DEFAULT '[]',
+            random_seed INTEGER,
+            llm_model TEXT DEFAULT '',
+            retrieval_policy TEXT DEFAULT '',
+            abstract TEXT DEFAULT ''
+        )`,
+		`CREATE TABLE IF NOT EXISTS session_permissions (
+            id TEXT PRIMARY KEY,
+            session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            role TEXT NOT NULL,
+            created_by TEXT REFERENCES users(id) ON DELETE SET NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            expires_at TIMESTAMP,
+            revoked_at TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS messages (
+            id TEXT PRIMARY KEY,
+            session_id TEXT REFERENCES sessions(id) ON DELETE CASCADE,
+            role TEXT NOT NULL,
+            content TEXT NOT NULL,
+            rendered TEXT NOT NULL,
+            content_hash TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS rag_documents (
+            id TEXT PRIMARY KEY,
+            content TEXT NOT NULL,
+            content_hash TEXT,
+            metadata TEXT DEFAULT '{}',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            tier TEXT NOT NULL DEFAULT 'hot',
+            archived_at TIMESTAMP,
+            dataset TEXT NOT NULL DEFAULT ''
+        )`,
+		`CREATE TABLE IF NOT EXISTS rag_embeddings (
+            id TEXT PRIMARY KEY,
+            document_id TEXT NOT NULL REFERENCES rag_documents(id) ON DELETE CASCADE,
+            window_index INTEGER NOT NULL,
+            window_start INTEGER NOT NULL,
+            window_end INTEGER NOT NULL,
+            window_text TEXT NOT NULL,
+            embedding BLOB NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE(document_id, window_index)
+        )`,
+		`CREATE TABLE IF NOT EXISTS files (
+            id TEXT PRIMARY KEY,
+            session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            filename TEXT NOT NULL,
+            file_path TEXT NOT NULL,
+            file_type TEXT,
+            file_size INTEGER,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            message_id TEXT REFERENCES messages(id) ON DELETE SET NULL,
+            column_types TEXT DEFAULT '{}',
+            alt_text TEXT DEFAULT '',
+            CONSTRAINT unique_session_filename UNIQUE(session_id, filename)
+        )`,
+		`CREATE TABLE IF NOT EXISTS retrieval_experiment_events (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            arm TEXT NOT NULL,
+            signal TEXT NOT NULL,
+            value REAL NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS run_turns (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            run_id TEXT NOT NULL,
+            turn INTEGER NOT NULL,
+            query TEXT,
+            retrieval TEXT,
+            messages TEXT NOT NULL,
+            prompt_version TEXT,
+            host TEXT,
+            temperature REAL,
+            response TEXT,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS step_bookmarks (
+            id TEXT PRIMARY KEY,
+            session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            message_id TEXT NOT NULL,
+            label TEXT,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (session_id, message_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS message_annotations (
+            id TEXT PRIMARY KEY,
+            session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            message_id TEXT NOT NULL,
+            note TEXT NOT NULL,
+            rag_document_id TEXT,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_message_annotations_session ON message_annotations(session_id, created_at)`,
+		`CREATE TABLE IF NOT EXISTS session_redirects (
+            old_session_id TEXT PRIMARY KEY,
+            session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_session_redirects_session ON session_redirects(session_id)`,
+		`CREATE TABLE IF NOT EXISTS action_results (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            signature_hash TEXT NOT NULL,
+            signature TEXT NOT NULL,
+            output TEXT NOT NULL DEFAULT '',
+            success BOOLEAN NOT NULL,
+            turn INTEGER NOT NULL DEFAULT 0,
+            attempt INTEGER NOT NULL DEFAULT 0,
+            code_norm_hash TEXT NOT NULL DEFAULT '',
+            diagnostics TEXT NOT NULL DEFAULT '',
+            user_edited BOOLEAN NOT NULL DEFAULT 0,
+            superseded_code_hash TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_action_results_session ON action_results(session_id, id)`,
+		`CREATE TABLE IF NOT EXISTS admin_jobs (
+            id TEXT PRIMARY KEY,
+            kind TEXT NOT NULL,
+            params TEXT NOT NULL DEFAULT '{}',
+            status TEXT NOT NULL,
+            resume_after TEXT NOT NULL DEFAULT '',
+            processed INTEGER NOT NULL DEFAULT 0,
+            failed INTEGER NOT NULL DEFAULT 0,
+            error TEXT NOT NULL DEFAULT '',
+            result TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        )`,
+		`CREATE TABLE IF NOT EXISTS analysis_jobs (
+            id TEXT PRIMARY KEY,
+            session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            user_message_id TEXT NOT NULL,
+            status TEXT NOT NULL,
+            error TEXT NOT NULL DEFAULT '',
+            events TEXT NOT NULL DEFAULT '',
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            finished_at TIMESTAMP
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_analysis_jobs_session ON analysis_jobs(session_id, created_at DESC)`,
+		`CREATE TABLE IF NOT EXISTS active_runs (
+            session_id TEXT PRIMARY KEY REFERENCES sessions(id) ON DELETE CASCADE,
+            user_message_id TEXT NOT NULL,
+            token TEXT NOT NULL,
+            instance_id TEXT NOT NULL,
+            heartbeat_at TIMESTAMP NOT NULL
+        )`,
+		`CREATE TABLE IF NOT EXISTS memory_checkpoints (
+            id TEXT PRIMARY KEY,
+            session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+            name TEXT NOT NULL,
+            created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (session_id, name)
+        )`,
+		`CREATE TABLE IF NOT EXISTS memory_checkpoint_documents (
+            checkpoint_id TEXT NOT NULL REFERENCES memory_checkpoints(id) ON DELETE CASCADE,
+            document_id TEXT NOT NULL,
+            kind TEXT NOT NULL DEFAULT '',
+            content_hash TEXT NOT NULL DEFAULT '',
+            state_content TEXT,
+            PRIMARY KEY (checkpoint_id, document_id)
+        )`,
+		`CREATE TABLE IF NOT EXISTS summary_cache (
+            cache_key TEXT PRIMARY KEY,
+            kind TEXT NOT NULL,
+            summary TEXT NOT NULL,
+            hits INTEGER NOT NULL DEFAULT 0,
+            created_at TIMESTAMP NOT NULL,
+            last_used_at TIMESTAMP NOT NULL
+        )`,
+		`CREATE INDEX IF NOT EXISTS idx_summary_cache_created ON summary_cache(created_at)`,
+	}
+
+	for _, stmt := range stmts {
+		if _, err := s.DB.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to execute schema statement: %w", err)
+		}
+	}
+
+	// SQLite has no ADD COLUMN IF NOT EXISTS; columns added after a table shipped are
+	// checked against table_info first
+	columnMigrations := []struct{ table, column, definition string }{
+		{"users", "display_name", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "password_hash", "TEXT NOT NULL DEFAULT ''"},
+		{"files", "alt_text", "TEXT DEFAULT ''"},
+		{"sessions", "random_seed", "INTEGER"},
+		{"sessions", "llm_model", "TEXT DEFAULT ''"},
+		{"sessions", "retrieval_policy", "TEXT DEFAULT ''"},
+		{"sessions", "abstract", "TEXT DEFAULT ''"},
+		{"rag_documents", "tier", "TEXT NOT NULL DEFAULT 'hot'"},
+		{"rag_documents", "archived_at", "TIMESTAMP"},
+		{"rag_documents", "dataset", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, m := range columnMigrations {
+		if err := s.addColumnIfMissing(ctx, m.table, m.column, m.definition); err != nil {
+			return fmt.Errorf("failed to apply column migration: %w", err)
+		}
+	}
+	if err := backfillRAGDocumentDatasets(ctx, s.DB); err != nil {
+		return err
+	}
+
+	indexStmts := []string{
+		`CREATE INDEX IF NOT EXISTS idx_sessions_user_active ON sessions(user_id, is_active, last_active DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_last_active ON sessions(last_active DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_session_permissions_session ON session_permissions(session_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_session_created_at ON messages(session_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_content_hash ON messages(content_hash)`,
+		`CREATE INDEX IF NOT EXISTS idx_rag_documents_created_at ON rag_documents(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_rag_documents_content_hash ON rag_documents(content_hash)`,
+		`CREATE INDEX IF NOT EXISTS idx_rag_documents_tier ON rag_documents(tier, created_at)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_rag_documents_session_role_hash ON rag_documents (content_hash, COALESCE(metadata ->> 'session_id', ''), COALESCE(metadata ->> 'role', '')) WHERE content_hash IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_rag_documents_metadata_session_id ON rag_documents ((metadata ->> 'session_id'))`,
+		`CREATE INDEX IF NOT EXISTS idx_rag_documents_session_dataset ON rag_documents (COALESCE(metadata ->> 'session_id', ''), dataset, tier)`,
+		`CREATE INDEX IF NOT EXISTS idx_rag_embeddings_document_id ON rag_embeddings(document_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_files_session_id ON files(session_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_files_message_id ON files(message_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_retrieval_experiment_events_arm ON retrieval_experiment_events(arm, signal, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_run_turns_session_run ON run_turns(session_id, run_id, turn)`,
+	}
+
+	for _, stmt := range indexStmts {
+		if _, err := s.DB.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to ensure index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// addColumnIfMissing adds column to table unless table_info already lists it.
+func (s *SQLiteStore) addColumnIfMissing(ctx context.Context, table, column, definition string) error {
+	var exists bool
+	err := s.DB.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM pragma_table_info($1) WHERE name = $2)`, table, column).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s columns: %w", table, err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := s.DB.ExecContext(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) CreateUser(ctx context.Context) (uuid.UUID, error) {
+	userID := uuid.New()
+	query := `INSERT INTO users (id, created_at) VALUES ($1, $2)`
+	_, err := s.DB.ExecContext(ctx, query, userID, sqliteTime(time.Now()))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return userID, nil
+}
+
+func (s *SQLiteStore) GetUserByID(ctx context.Context, userID uuid.UUID) error {
+	query := `SELECT id FROM users WHERE id = $1`
+	var id uuid.UUID
+	err := s.DB.QueryRowContext(ctx, query, userID).Scan(&id)
+	return err
+}
+
+func (s *SQLiteStore) CreateSession(ctx context.Context, userID *uuid.UUID) (uuid.UUID, error) {
+	return s.CreateSessionWithMode(ctx, userID, "dataset")
+}
+
+func (s *SQLiteStore) CreateSessionWithMode(ctx context.Context, userID *uuid.UUID, mode string) (uuid.UUID, error) {
+	sessionID := uuid.New()
+	workspacePath := filepath.Join("workspaces", sessionID.String())
+	now := time.Now()
+	initialTitle := fmt.Sprintf("Chat from %s", now.Format("January 2, 2006"))
+
+	if mode != "dataset" && mode != "document" {
+		mode = "dataset"
+	}
+
+	query := `
+        INSERT INTO sessions (id, user_id, created_at, last_active, workspace_path, title, is_active, mode)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `
+	_, err := s.DB.ExecContext(ctx, query, sessionID, uuidToNullString(userID), sqliteTime(now), sqliteTime(now), workspacePath, initialTitle, true, mode)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return sessionID, nil
+}
+
+const sqliteSessionColumns = `id, user_id, created_at, last_active, workspace_path, title, is_active, COALESCE(mode, 'dataset'), COALESCE(verbosity, 'standard'), COALESCE(effect_size_check, 'note'), COALESCE(tags, '[]'), random_seed, COALESCE(llm_model, ''), COALESCE(retrieval_policy, ''), COALESCE(abstract, '')`
+
+// scanSQLiteSession scans a row selected with sqliteSessionColumns.
+func scanSQLiteSession(scan func(dest ...any) error) (types.Session, error) {
+	var session types.Session
+	var userID sql.NullString
+	var tagsJSON string
+	var randomSeed sql.NullInt64
+	if err := scan(&session.ID, &userID, &session.CreatedAt, &session.LastActive, &session.WorkspacePath, &session.Title, &session.IsActive, &session.Mode, &session.Verbosity, &session.EffectSizeCheck, &tagsJSON, &randomSeed, &session.LLMModel, &session.RetrievalPolicy, &session.Abstract); err != nil {
+		return types.Session{}, err
+	}
+	if err := json.Unmarshal([]byte(tagsJSON), &session.Tags); err != nil {
+		return types.Session{}, fmt.Errorf("failed to unmarshal tags for session %s: %w", session.ID, err)
+	}
+	session.RandomSeed = nullInt64Ptr(randomSeed)
+	if userID.Valid {
+		parsedUUID, err := uuid.Parse(userID.String)
+		if err != nil {
+			return types.Session{}, fmt.Errorf("failed to parse user ID from session %s: %w", session.ID, err)
+		}
+		session.UserID = &parsedUUID
+	}
+	return session, nil
+}
+
+func (s *SQLiteStore) GetSessionByID(ctx context.Context, sessionID uuid.UUID) (types.Session, error) {
+	query := `SELECT ` + sqliteSessionColumns + ` FROM sessions WHERE id = $1`
+	session, err := scanSQLiteSession(s.DB.QueryRowContext(ctx, query, sessionID).Scan)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return types.Session{}, fmt.Errorf("session not found: %w", err)
+		}
+		return types.Session{}, fmt.Errorf("failed to scan session: %w", err)
+	}
+	return session, nil
+}
+
+func (s *SQLiteStore) UpdateSessionTitle(ctx context.Context, sessionID uuid.UUID, title string) error {
+	query := `UPDATE sessions SET title = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, title, sessionID); err != nil {
+		return fmt.Errorf("failed to update session title: %w", err)
+	}
+	return nil
+}
+
+// UpdateSessionTags replaces the session's result tags.
+func (s *SQLiteStore) UpdateSessionTags(ctx context.Context, sessionID uuid.UUID, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session tags: %w", err)
+	}
+	query := `UPDATE sessions SET tags = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, string(tagsJSON), sessionID); err != nil {
+		return fmt.Errorf("failed to update session tags: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UpdateSessionMode(ctx context.Context, sessionID uuid.UUID, mode string) error {
+	if mode != "dataset" && mode != "document" {
+		return fmt.Errorf("invalid mode: must be 'dataset' or 'document'")
+	}
+
+	query := `UPDATE sessions SET mode = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, mode, sessionID); err != nil {
+		return fmt.Errorf("failed to update session mode: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UpdateSessionVerbosity(ctx context.Context, sessionID uuid.UUID, verbosity string) error {
+	if !types.IsValidVerbosity(verbosity) {
+		return fmt.Errorf("invalid verbosity: must be 'terse', 'standard', or 'teaching'")
+	}
+
+	query := `UPDATE sessions SET verbosity = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, verbosity, sessionID); err != nil {
+		return fmt.Errorf("failed to update session verbosity: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UpdateSessionEffectSizeCheck(ctx context.Context, sessionID uuid.UUID, mode string) error {
+	if !types.IsValidEffectSizeCheck(mode) {
+		return fmt.Errorf("invalid effect size check: must be 'off', 'note', or 'auto'")
+	}
+
+	query := `UPDATE sessions SET effect_size_check = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, mode, sessionID); err != nil {
+		return fmt.Errorf("failed to update session effect size check: %w", err)
+	}
+	return nil
+}
+
+// UpdateSessionRandomSeed pins the session's random seed, or clears it when seed is nil.
+func (s *SQLiteStore) UpdateSessionRandomSeed(ctx context.Context, sessionID uuid.UUID, seed *int64) error {
+	query := `UPDATE sessions SET random_seed = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, int64PtrToNull(seed), sessionID); err != nil {
+		return fmt.Errorf("failed to update session random seed: %w", err)
+	}
+	return nil
+}
+
+// UpdateSessionLLMModel sets the named LLM endpoint the session uses ("" for the default).
+func (s *SQLiteStore) UpdateSessionLLMModel(ctx context.Context, sessionID uuid.UUID, model string) error {
+	query := `UPDATE sessions SET llm_model = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, model, sessionID); err != nil {
+		return fmt.Errorf("failed to update session LLM model: %w", err)
+	}
+	return nil
+}
+
+// UpdateSessionRetrievalPolicy sets the named retrieval policy the session's queries use
+// ("" for the policy of the session mode).
+func (s *SQLiteStore) UpdateSessionRetrievalPolicy(ctx context.Context, sessionID uuid.UUID, policy string) error {
+	query := `UPDATE sessions SET retrieval_policy = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, policy, sessionID); err != nil {
+		return fmt.Errorf("failed to update session retrieval policy: %w", err)
+	}
+	return nil
+}
+
+// UpdateSessionAbstract stores the session's rolling abstract.
+func (s *SQLiteStore) UpdateSessionAbstract(ctx context.Context, sessionID uuid.UUID, abstract string) error {
+	query := `UPDATE sessions SET abstract = $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, abstract, sessionID); err != nil {
+		return fmt.Errorf("failed to update session abstract: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetSessions(ctx context.Context, userID *uuid.UUID) ([]types.Session, error) {
+	var rows *sql.Rows
+	var err error
+	if userID != nil {
+		query := `SELECT ` + sqliteSessionColumns + ` FROM sessions WHERE is_active = 1 AND user_id = $1 ORDER BY last_active DESC`
+		rows, err = s.DB.QueryContext(ctx, query, userID)
+	} else {
+		query := `SELECT ` + sqliteSessionColumns + ` FROM sessions WHERE is_active = 1 ORDER BY last_active DESC`
+		rows, err = s.DB.QueryContext(ctx, query)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []types.Session
+	for rows.Next() {
+		session, err := scanSQLiteSession(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session row: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating session rows: %w", err)
+	}
+
+	return sessions, nil
+}
+
+func (s *SQLiteStore) CreateMessage(ctx context.Context, msg types.ChatMessage) error {
+	messageUUID, err := uuid.Parse(msg.ID)
+	if err != nil {
+		return fmt.Errorf("invalid message ID: %w", err)
+	}
+	sessionUUID, err := uuid.Parse(msg.SessionID)
+	if err != nil {
+		return fmt.Errorf("invalid session ID in message: %w", err)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := sqliteTime(time.Now())
+	query := `
+		INSERT INTO messages (id, session_id, role, content, rendered, content_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	if _, err := tx.ExecContext(ctx, query, messageUUID, sessionUUID, msg.Role, msg.Content, msg.Rendered, msg.ContentHash, now); err != nil {
+		return fmt.Errorf("failed to insert message: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE sessions SET last_active = $1 WHERE id = $2`, now, sessionUUID); err != nil {
+		return fmt.Errorf("failed to update session last_active: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// AppendToMessageRendered appends additional HTML to an existing message's rendered field.
+func (s *SQLiteStore) AppendToMessageRendered(ctx context.Context, messageID string, extraHTML string) error {
+	if extraHTML == "" {
+		return nil
+	}
+
+	msgUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		return fmt.Errorf("invalid message ID: %w", err)
+	}
+
+	query := `UPDATE messages SET rendered = COALESCE(rendered, '') || $1 WHERE id = $2`
+	if _, err := s.DB.ExecContext(ctx, query, extraHTML, msgUUID); err != nil {
+		return fmt.Errorf("append to message rendered: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetMessagesBySession(ctx context.Context, sessionID uuid.UUID) ([]types.ChatMessage, error) {
+	query := `
+		SELECT id, session_id, role, content, rendered, content_hash FROM messages
+		WHERE session_id = $1 ORDER BY created_at ASC
+	`
+	rows, err := s.DB.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []types.ChatMessage
+	for rows.Next() {
+		var msg types.ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.SessionID, &msg.Role, &msg.Content, &msg.Rendered, &msg.ContentHash); err != nil {
+			return nil, fmt.Errorf("failed to scan message row: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message rows: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (s *SQLiteStore) GetMessagesPageBySession(ctx context.Context, sessionID uuid.UUID, before string, turns int) ([]types.ChatMessage, bool, error) {
+	query, moreQuery, args := messagePageQueries(sessionID, before, turns)
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query message page: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []types.ChatMessage
+	for rows.Next() {
+		var msg types.ChatMessage
+		if err := rows.Scan(&msg.ID, &msg.SessionID, &msg.Role, &msg.Content, &msg.Rendered, &msg.ContentHash); err != nil {
+			return nil, false, fmt.Errorf("failed to scan message row: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("error iterating message rows: %w", err)
+	}
+
+	var hasMore bool
+	if err := s.DB.QueryRowContext(ctx, moreQuery, args...).Scan(&hasMore); err != nil {
+		return nil, false, fmt.Errorf("failed to check for older messages: %w", err)
+	}
+	return messages, hasMore, nil
+}
+
+func (s *SQLiteStore) GetStaleSessions(ctx context.Context, lastActiveBefore time.Time) ([]uuid.UUID, error) {
+	query := `SELECT id FROM sessions WHERE last_active < $1 ORDER BY last_active ASC`
+	return s.querySessionIDs(ctx, query, sqliteTime(lastActiveBefore))
+}
+
+// GetRecentlyActiveSessions returns IDs of sessions active at or after the given time.
+func (s *SQLiteStore) GetRecentlyActiveSessions(ctx context.Context, lastActiveAfter time.Time) ([]uuid.UUID, error) {
+	query := `SELECT id FROM sessions WHERE last_active >= $1 ORDER BY last_active DESC`
+	return s.querySessionIDs(ctx, query, sqliteTime(lastActiveAfter))
+}
+
+func (s *SQLiteStore) querySessionIDs(ctx context.Context, query string, args ...any) ([]uuid.UUID, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessionIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan session ID: %w", err)
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+
+	return sessionIDs, nil
+}
+
+func (s *SQLiteStore) DeleteSession(ctx context.Context, sessionID uuid.UUID) error {
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1`, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errors.New("session not found")
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteUser(ctx context.Context, userID uuid.UUID) error {
+	sessions, err := s.GetSessions(ctx, &userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user sessions: %w", err)
+	}
+
+	// RAG documents are keyed by metadata, not a foreign key; clean up as much as possible
+	for _, session := range sessions {
+		_, _ = s.DeleteRAGDocumentsBySession(ctx, session.ID)
+	}
+
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
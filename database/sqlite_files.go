@@ -0,0 +1,432 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+const sqliteFileColumns = `id, session_id, filename, file_path, file_type, file_size, created_at, message_id`
+
+// scanSQLiteFile scans a row selected with sqliteFileColumns.
+func scanSQLiteFile(scan func(dest ...any) error) (FileRecord, error) {
+	var file FileRecord
+	var fileType sql.NullString
+	var fileSize sql.NullInt64
+	var messageID sql.NullString
+	if err := scan(&file.ID, &file.SessionID, &file.Filename, &file.FilePath, &fileType, &fileSize, &file.CreatedAt, &messageID); err != nil {
+		return FileRecord{}, err
+	}
+	file.FileType = fileType.String
+	file.FileSize = fileSize.Int64
+	file.MessageID = nullStringToUUID(messageID)
+	return file, nil
+}
+
+// CreateFile inserts a new file record. If a file with the same session_id and filename
+// already exists, it returns the existing file (idempotent operation).
+func (s *SQLiteStore) CreateFile(ctx context.Context, file FileRecord) (FileRecord, error) {
+	query := `
+		INSERT INTO files (id, session_id, filename, file_path, file_type, file_size, created_at, message_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (session_id, filename) DO UPDATE SET id = files.id
+		RETURNING ` + sqliteFileColumns
+
+	result, err := scanSQLiteFile(s.DB.QueryRowContext(ctx, query,
+		file.ID,
+		file.SessionID,
+		file.Filename,
+		file.FilePath,
+		file.FileType,
+		file.FileSize,
+		sqliteTime(file.CreatedAt),
+		uuidToNullString(file.MessageID),
+	).Scan)
+	if err != nil {
+		return FileRecord{}, fmt.Errorf("failed to create file record: %w", err)
+	}
+	return result, nil
+}
+
+// GetFilesBySession returns all files for a given session, ordered by creation time
+func (s *SQLiteStore) GetFilesBySession(ctx context.Context, sessionID uuid.UUID) ([]FileRecord, error) {
+	query := `SELECT ` + sqliteFileColumns + ` FROM files WHERE session_id = $1 ORDER BY created_at ASC`
+	return s.queryFiles(ctx, query, sessionID)
+}
+
+// GetNewFilesBySession returns files created after the specified time for a session.
+func (s *SQLiteStore) GetNewFilesBySession(ctx context.Context, sessionID uuid.UUID, after time.Time) ([]FileRecord, error) {
+	query := `SELECT ` + sqliteFileColumns + ` FROM files WHERE session_id = $1 AND created_at > $2 ORDER BY created_at ASC`
+	return s.queryFiles(ctx, query, sessionID, sqliteTime(after))
+}
+
+// GetArtifactFiles returns a page of the session's figures, newest first, with the total
+// number of figures.
+func (s *SQLiteStore) GetArtifactFiles(ctx context.Context, sessionID uuid.UUID, limit, offset int) ([]FileRecord, int, error) {
+	var total int
+	if err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM files WHERE`+artifactFilesFilter, sessionID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count artifact files: %w", err)
+	}
+	query := `SELECT ` + sqliteFileColumns + ` FROM files WHERE` + artifactFilesFilter + ` ORDER BY created_at DESC, filename LIMIT $2 OFFSET $3`
+	files, err := s.queryFiles(ctx, query, sessionID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	return files, total, nil
+}
+
+// LinkFilesToMessage records the message that displays a run's files.
+func (s *SQLiteStore) LinkFilesToMessage(ctx context.Context, sessionID uuid.UUID, filenames []string, messageID uuid.UUID) error {
+	return linkFilesToMessage(ctx, s.DB, sessionID, filenames, messageID)
+}
+
+func (s *SQLiteStore) queryFiles(ctx context.Context, query string, args ...any) ([]FileRecord, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []FileRecord
+	for rows.Next() {
+		file, err := scanSQLiteFile(rows.Scan)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan file row: %w", err)
+		}
+		files = append(files, file)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating file rows: %w", err)
+	}
+
+	return files, nil
+}
+
+// GetFileBySessionAndName retrieves a specific file by session ID and filename
+func (s *SQLiteStore) GetFileBySessionAndName(ctx context.Context, sessionID uuid.UUID, filename string) (FileRecord, error) {
+	query := `SELECT ` + sqliteFileColumns + ` FROM files WHERE session_id = $1 AND filename = $2`
+	file, err := scanSQLiteFile(s.DB.QueryRowContext(ctx, query, sessionID, filename).Scan)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return FileRecord{}, fmt.Errorf("file not found: %w", err)
+		}
+		return FileRecord{}, fmt.Errorf("failed to get file: %w", err)
+	}
+	return file, nil
+}
+
+// GetTrackedFilenames returns a set of all tracked filenames for a session
+func (s *SQLiteStore) GetTrackedFilenames(ctx context.Context, sessionID uuid.UUID) (map[string]bool, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT filename FROM files WHERE session_id = $1`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tracked filenames: %w", err)
+	}
+	defer rows.Close()
+
+	filenames := make(map[string]bool)
+	for rows.Next() {
+		var filename string
+		if err := rows.Scan(&filename); err != nil {
+			return nil, fmt.Errorf("failed to scan filename: %w", err)
+		}
+		filenames[filename] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating filenames: %w", err)
+	}
+
+	return filenames, nil
+}
+
+// GetColumnTypeOverrides returns the user's column type overrides for every dataset in a
+// session, keyed by filename and then column name.
+func (s *SQLiteStore) GetColumnTypeOverrides(ctx context.Context, sessionID uuid.UUID) (map[string]map[string]string, error) {
+	query := `
+		SELECT filename, column_types
+		FROM files
+		WHERE session_id = $1 AND column_types IS NOT NULL AND column_types <> '{}'
+	`
+
+	rows, err := s.DB.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query column type overrides: %w", err)
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]map[string]string)
+	for rows.Next() {
+		var filename, typesJSON string
+		if err := rows.Scan(&filename, &typesJSON); err != nil {
+			return nil, fmt.Errorf("failed to scan column type overrides: %w", err)
+		}
+		var columnTypes map[string]string
+		if err := json.Unmarshal([]byte(typesJSON), &columnTypes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal column types for %s: %w", filename, err)
+		}
+		if len(columnTypes) > 0 {
+			overrides[filename] = columnTypes
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating column type overrides: %w", err)
+	}
+
+	return overrides, nil
+}
+
+// SetColumnTypeOverrides replaces the column type overrides of a session's dataset.
+func (s *SQLiteStore) SetColumnTypeOverrides(ctx context.Context, sessionID uuid.UUID, filename string, columnTypes map[string]string) error {
+	if columnTypes == nil {
+		columnTypes = map[string]string{}
+	}
+	typesJSON, err := json.Marshal(columnTypes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal column types: %w", err)
+	}
+
+	query := `UPDATE files SET column_types = $1 WHERE session_id = $2 AND filename = $3`
+	result, err := s.DB.ExecContext(ctx, query, string(typesJSON), sessionID, filename)
+	if err != nil {
+		return fmt.Errorf("failed to update column types: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errors.New("file not found")
+	}
+	return nil
+}
+
+// GetFileAltTexts returns the generated alt text of a session's files, keyed by filename.
+// Files without alt text are omitted.
+func (s *SQLiteStore) GetFileAltTexts(ctx context.Context, sessionID uuid.UUID) (map[string]string, error) {
+	query := `SELECT filename, alt_text FROM files WHERE session_id = $1 AND alt_text IS NOT NULL AND alt_text <> ''`
+
+	rows, err := s.DB.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query file alt text: %w", err)
+	}
+	defer rows.Close()
+
+	altTexts := make(map[string]string)
+	for rows.Next() {
+		var filename, altText string
+		if err := rows.Scan(&filename, &altText); err != nil {
+			return nil, fmt.Errorf("failed to scan file alt text: %w", err)
+		}
+		altTexts[filename] = altText
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating file alt text: %w", err)
+	}
+
+	return altTexts, nil
+}
+
+// SetFileAltText stores the alt text of a session's file.
+func (s *SQLiteStore) SetFileAltText(ctx context.Context, sessionID uuid.UUID, filename, altText string) error {
+	query := `UPDATE files SET alt_text = $1 WHERE session_id = $2 AND filename = $3`
+	result, err := s.DB.ExecContext(ctx, query, altText, sessionID, filename)
+	if err != nil {
+		return fmt.Errorf("failed to update file alt text: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errors.New("file not found")
+	}
+	return nil
+}
+
+// RenameFile renames a session's file record. Renaming a file without a record is not an
+// error: uploads are only recorded once a run picks them up.
+func (s *SQLiteStore) RenameFile(ctx context.Context, sessionID uuid.UUID, filename, newFilename, newFilePath string) error {
+	query := `UPDATE files SET filename = $1, file_path = $2 WHERE session_id = $3 AND filename = $4`
+	if _, err := s.DB.ExecContext(ctx, query, newFilename, newFilePath, sessionID, filename); err != nil {
+		return fmt.Errorf("failed to rename file: %w", err)
+	}
+	return nil
+}
+
+// DeleteFile removes a file record from the database
+func (s *SQLiteStore) DeleteFile(ctx context.Context, fileID uuid.UUID) error {
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM files WHERE id = $1`, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errors.New("file not found")
+	}
+	return nil
+}
+
+// CreateRetrievalExperimentEvent records an outcome signal for the arm a session was assigned to.
+func (s *SQLiteStore) CreateRetrievalExperimentEvent(ctx context.Context, sessionID uuid.UUID, arm, signal string, value float64) error {
+	query := `
+		INSERT INTO retrieval_experiment_events (session_id, arm, signal, value, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	if _, err := s.DB.ExecContext(ctx, query, sessionID, arm, signal, value, sqliteTime(time.Now())); err != nil {
+		return fmt.Errorf("failed to create retrieval experiment event: %w", err)
+	}
+	return nil
+}
+
+// GetRetrievalExperimentSummary aggregates experiment events recorded at or after since, per arm and signal.
+func (s *SQLiteStore) GetRetrievalExperimentSummary(ctx context.Context, since time.Time) ([]RetrievalArmSummary, error) {
+	query := `
+		SELECT arm, signal, COUNT(*), AVG(value), COUNT(DISTINCT session_id)
+		FROM retrieval_experiment_events
+		WHERE created_at >= $1
+		GROUP BY arm, signal
+		ORDER BY arm, signal
+	`
+	rows, err := s.DB.QueryContext(ctx, query, sqliteTime(since))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query retrieval experiment summary: %w", err)
+	}
+	defer rows.Close()
+
+	var summaries []RetrievalArmSummary
+	for rows.Next() {
+		var summary RetrievalArmSummary
+		if err := rows.Scan(&summary.Arm, &summary.Signal, &summary.Count, &summary.Average, &summary.Sessions); err != nil {
+			return nil, fmt.Errorf("failed to scan retrieval experiment summary: %w", err)
+		}
+		summaries = append(summaries, summary)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating retrieval experiment summary: %w", err)
+	}
+
+	return summaries, nil
+}
+
+// SaveRunTurn records one agent turn for offline replay.
+func (s *SQLiteStore) SaveRunTurn(ctx context.Context, turn types.RunTurn) error {
+	sessionID, err := uuid.Parse(turn.SessionID)
+	if err != nil {
+		return fmt.Errorf("invalid session ID: %w", err)
+	}
+	runID, err := uuid.Parse(turn.RunID)
+	if err != nil {
+		return fmt.Errorf("invalid run ID: %w", err)
+	}
+	messagesJSON, err := json.Marshal(turn.Messages)
+	if err != nil {
+		return fmt.Errorf("failed to marshal run turn messages: %w", err)
+	}
+
+	query := `
+		INSERT INTO run_turns (session_id, run_id, turn, query, retrieval, messages, prompt_version, host, temperature, response, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	if _, err := s.DB.ExecContext(ctx, query, sessionID, runID, turn.Turn, turn.Query, turn.Retrieval,
+		string(messagesJSON), turn.PromptVersion, turn.Host, turn.Temperature, turn.Response, sqliteTime(time.Now())); err != nil {
+		return fmt.Errorf("failed to save run turn: %w", err)
+	}
+	return nil
+}
+
+// GetRunTurns returns the recorded turns of a run in order. An empty runID selects the
+// session's most recent run.
+func (s *SQLiteStore) GetRunTurns(ctx context.Context, sessionID uuid.UUID, runID string) ([]types.RunTurn, error) {
+	const columns = `run_id, turn, COALESCE(query, ''), COALESCE(retrieval, ''), messages,
+		       COALESCE(prompt_version, ''), COALESCE(host, ''), COALESCE(temperature, 0), COALESCE(response, ''), created_at`
+	query := `SELECT ` + columns + ` FROM run_turns WHERE session_id = $1 AND run_id = $2 ORDER BY turn, id`
+	args := []any{sessionID, runID}
+	if runID == "" {
+		query = `SELECT ` + columns + ` FROM run_turns
+		WHERE session_id = $1 AND run_id = (
+			SELECT run_id FROM run_turns WHERE session_id = $1 ORDER BY id DESC LIMIT 1
+		)
+		ORDER BY turn, id`
+		args = []any{sessionID}
+	} else if parsed, err := uuid.Parse(runID); err != nil {
+		return nil, fmt.Errorf("invalid run ID: %w", err)
+	} else {
+		args[1] = parsed.String()
+	}
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query run turns: %w", err)
+	}
+	defer rows.Close()
+
+	var turns []types.RunTurn
+	for rows.Next() {
+		var turn types.RunTurn
+		var messagesJSON string
+		if err := rows.Scan(&turn.RunID, &turn.Turn, &turn.Query, &turn.Retrieval, &messagesJSON,
+			&turn.PromptVersion, &turn.Host, &turn.Temperature, &turn.Response, &turn.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan run turn: %w", err)
+		}
+		if err := json.Unmarshal([]byte(messagesJSON), &turn.Messages); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal run turn messages: %w", err)
+		}
+		turn.SessionID = sessionID.String()
+		turns = append(turns, turn)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating run turns: %w", err)
+	}
+
+	return turns, nil
+}
+
+// AnalyzeTables refreshes the query planner statistics.
+func (s *SQLiteStore) AnalyzeTables(ctx context.Context) error {
+	if _, err := s.DB.ExecContext(ctx, `ANALYZE`); err != nil {
+		return fmt.Errorf("failed to analyze database: %w", err)
+	}
+	return nil
+}
+
+// VacuumTables rebuilds the database file to reclaim space left by deleted rows. SQLite
+// vacuums the whole file at once rather than per table.
+func (s *SQLiteStore) VacuumTables(ctx context.Context) error {
+	if _, err := s.DB.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	return nil
+}
+
+// CountRAGEmbeddings returns the number of embedding rows.
+func (s *SQLiteStore) CountRAGEmbeddings(ctx context.Context) (int64, error) {
+	var count int64
+	if err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM rag_embeddings`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count rag embeddings: %w", err)
+	}
+	return count, nil
+}
+
+// ReindexVectorIndex is a no-op: vector search scans the embeddings directly, so there is
+// no approximate index to rebuild.
+func (s *SQLiteStore) ReindexVectorIndex(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,919 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// BM25 parameters for the in-process keyword search (the usual Okapi defaults).
+const (
+	sqliteBM25K1 = 1.2
+	sqliteBM25B  = 0.75
+)
+
+// encodeEmbedding packs a vector as little-endian float32s for a BLOB column.
+func encodeEmbedding(embedding []float32) []byte {
+	buf := make([]byte, 4*len(embedding))
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(v))
+	}
+	return buf
+}
+
+// decodeEmbedding unpacks a BLOB written by encodeEmbedding.
+func decodeEmbedding(buf []byte) []float32 {
+	if len(buf) == 0 {
+		return nil
+	}
+	embedding := make([]float32, len(buf)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return embedding
+}
+
+// cosineSimilarity matches pgvector's 1 - (a <=> b); mismatched or zero vectors score 0.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+func decodeMetadata(metaJSON []byte) (map[string]string, error) {
+	meta := make(map[string]string)
+	if len(metaJSON) > 0 {
+		if err := json.Unmarshal(metaJSON, &meta); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
+// UpsertDocument stores or updates a RAG document's content and metadata (without embeddings).
+func (s *SQLiteStore) UpsertDocument(ctx context.Context, documentID uuid.UUID, content string, metadata map[string]string, contentHash string) (uuid.UUID, error) {
+	metaJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to marshal metadata for rag document: %w", err)
+	}
+
+	hashValue := sql.NullString{String: contentHash, Valid: contentHash != ""}
+
+	query := `
+		INSERT INTO rag_documents (id, content, metadata, content_hash, dataset, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id)
+		DO UPDATE SET content = excluded.content, metadata = excluded.metadata, content_hash = excluded.content_hash, dataset = excluded.dataset, created_at = excluded.created_at, tier = 'hot', archived_at = NULL
+		RETURNING id
+	`
+
+	var returnedID uuid.UUID
+	if err := s.DB.QueryRowContext(ctx, query, documentID, content, string(metaJSON), hashValue, ragDocumentDataset(metadata), sqliteTime(time.Now())).Scan(&returnedID); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to upsert rag document: %w", err)
+	}
+	return returnedID, nil
+}
+
+// CreateEmbedding stores a single embedding window for a document.
+func (s *SQLiteStore) CreateEmbedding(ctx context.Context, documentID uuid.UUID, windowIndex, windowStart, windowEnd int, windowText string, embedding []float32) error {
+	if len(embedding) == 0 {
+		return fmt.Errorf("cannot create embedding with empty vector")
+	}
+
+	query := `
+		INSERT INTO rag_embeddings (id, document_id, window_index, window_start, window_end, window_text, embedding, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (document_id, window_index)
+		DO UPDATE SET window_start = excluded.window_start, window_end = excluded.window_end, window_text = excluded.window_text, embedding = excluded.embedding, created_at = excluded.created_at
+	`
+
+	if _, err := s.DB.ExecContext(ctx, query, uuid.New(), documentID, windowIndex, windowStart, windowEnd, windowText, encodeEmbedding(embedding), sqliteTime(time.Now())); err != nil {
+		return fmt.Errorf("failed to create embedding for document %s window %d: %w", documentID, windowIndex, err)
+	}
+	return nil
+}
+
+// GetDocumentEmbeddings retrieves all embedding windows for a specific document.
+func (s *SQLiteStore) GetDocumentEmbeddings(ctx context.Context, documentID uuid.UUID) ([]RAGEmbedding, error) {
+	query := `
+		SELECT id, document_id, window_index, window_start, window_end, window_text, embedding, created_at
+		FROM rag_embeddings
+		WHERE document_id = $1
+		ORDER BY window_index ASC
+	`
+
+	rows, err := s.DB.QueryContext(ctx, query, documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query embeddings for document %s: %w", documentID, err)
+	}
+	defer rows.Close()
+
+	var embeddings []RAGEmbedding
+	for rows.Next() {
+		var embedding RAGEmbedding
+		var blob []byte
+		if err := rows.Scan(&embedding.ID, &embedding.DocumentID, &embedding.WindowIndex, &embedding.WindowStart, &embedding.WindowEnd, &embedding.WindowText, &blob, &embedding.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan embedding row: %w", err)
+		}
+		embedding.Embedding = decodeEmbedding(blob)
+		embeddings = append(embeddings, embedding)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating embedding rows: %w", err)
+	}
+
+	return embeddings, nil
+}
+
+// UpsertRAGDocument stores a document with a single embedding window.
+func (s *SQLiteStore) UpsertRAGDocument(ctx context.Context, documentID uuid.UUID, content string, embeddingContent string, metadata map[string]string, contentHash string, embedding []float32) error {
+	if _, err := s.UpsertDocument(ctx, documentID, content, metadata, contentHash); err != nil {
+		return err
+	}
+
+	if len(embedding) > 0 {
+		windowText := embeddingContent
+		if windowText == "" {
+			windowText = content
+		}
+		if err := s.CreateEmbedding(ctx, documentID, 0, 0, len(windowText), windowText, embedding); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FindStateDocument returns the most recent state document for a (sessionID, dataset, stage).
+func (s *SQLiteStore) FindStateDocument(ctx context.Context, sessionID, dataset, stage string) (uuid.UUID, string, map[string]string, error) {
+	if sessionID == "" || dataset == "" || stage == "" {
+		return uuid.Nil, "", nil, sql.ErrNoRows
+	}
+	const query = `
+        SELECT id, content, metadata
+        FROM rag_documents
+        WHERE (metadata ->> 'session_id') = $1
+          AND (metadata ->> 'type') = 'state'
+          AND (metadata ->> 'dataset') = $2
+          AND (metadata ->> 'stage') = $3
+        ORDER BY created_at DESC
+        LIMIT 1`
+
+	return s.scanStateDocument(s.DB.QueryRowContext(ctx, query, sessionID, dataset, stage))
+}
+
+// FindStateDocumentWithFilters returns the most recent state document for a (sessionID, dataset, stage, filters_key).
+func (s *SQLiteStore) FindStateDocumentWithFilters(ctx context.Context, sessionID, dataset, stage, filtersKey string) (uuid.UUID, string, map[string]string, error) {
+	if sessionID == "" || dataset == "" || stage == "" {
+		return uuid.Nil, "", nil, sql.ErrNoRows
+	}
+	const query = `
+        SELECT id, content, metadata
+        FROM rag_documents
+        WHERE (metadata ->> 'session_id') = $1
+          AND (metadata ->> 'type') = 'state'
+          AND (metadata ->> 'dataset') = $2
+          AND (metadata ->> 'stage') = $3
+          AND COALESCE((metadata ->> 'filters_key'), '') = $4
+        ORDER BY created_at DESC
+        LIMIT 1`
+
+	return s.scanStateDocument(s.DB.QueryRowContext(ctx, query, sessionID, dataset, stage, strings.TrimSpace(filtersKey)))
+}
+
+func (s *SQLiteStore) scanStateDocument(row *sql.Row) (uuid.UUID, string, map[string]string, error) {
+	var (
+		id       uuid.UUID
+		content  string
+		metaJSON []byte
+	)
+	if err := row.Scan(&id, &content, &metaJSON); err != nil {
+		return uuid.Nil, "", nil, err
+	}
+	meta, err := decodeMetadata(metaJSON)
+	if err != nil {
+		return uuid.Nil, "", nil, err
+	}
+	return id, content, meta, nil
+}
+
+// ListStateDocuments lists all state documents for a session ordered by newest first.
+func (s *SQLiteStore) ListStateDocuments(ctx context.Context, sessionID string) ([]RAGDocument, error) {
+	const query = `
+        SELECT id, content, metadata, content_hash, created_at
+        FROM rag_documents
+        WHERE (metadata ->> 'session_id') = $1 AND (metadata ->> 'type') = 'state'
+        ORDER BY created_at DESC`
+
+	return s.queryDocuments(ctx, query, sessionID)
+}
+
+// QueryDocumentsByMetadata returns the newest documents whose metadata contains every
+// key/value pair in filters.
+func (s *SQLiteStore) QueryDocumentsByMetadata(ctx context.Context, filters map[string]string, limit int) ([]RAGDocument, error) {
+	if len(filters) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	var builder strings.Builder
+	builder.WriteString("SELECT id, content, metadata, content_hash, created_at FROM rag_documents WHERE tier = 'hot'")
+	args := make([]any, 0, 2*len(filters)+1)
+	for key, value := range filters {
+		builder.WriteString(fmt.Sprintf(" AND (metadata ->> $%d) = $%d", len(args)+1, len(args)+2))
+		args = append(args, key, value)
+	}
+	builder.WriteString(fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args)+1))
+	args = append(args, limit)
+
+	return s.queryDocuments(ctx, builder.String(), args...)
+}
+
+// queryDocuments runs a query selecting id, content, metadata, content_hash, created_at.
+func (s *SQLiteStore) queryDocuments(ctx context.Context, query string, args ...any) ([]RAGDocument, error) {
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rag documents: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []RAGDocument
+	for rows.Next() {
+		var (
+			doc      RAGDocument
+			metaJSON []byte
+			hash     sql.NullString
+		)
+		if err := rows.Scan(&doc.ID, &doc.Content, &metaJSON, &hash, &doc.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan rag document: %w", err)
+		}
+		if doc.Metadata, err = decodeMetadata(metaJSON); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal metadata for document %s: %w", doc.ID, err)
+		}
+		doc.ContentHash = hash.String
+		docs = append(docs, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rag documents: %w", err)
+	}
+	return docs, nil
+}
+
+// DeleteRAGDocument deletes a rag document by id (cascades delete to embeddings via FK).
+func (s *SQLiteStore) DeleteRAGDocument(ctx context.Context, id uuid.UUID) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM rag_documents WHERE id = $1`, id)
+	return err
+}
+
+// ListRAGDocuments returns all persisted RAG documents with their first embedding window.
+func (s *SQLiteStore) ListRAGDocuments(ctx context.Context) ([]StoredRAGDocument, error) {
+	const query = `
+		SELECT rd.id, rd.content, re.window_text, rd.metadata, rd.content_hash, re.embedding, rd.created_at
+		FROM rag_documents rd
+		LEFT JOIN rag_embeddings re ON re.document_id = rd.id AND re.window_index = 0
+		ORDER BY rd.created_at ASC
+	`
+
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rag documents: %w", err)
+	}
+	defer rows.Close()
+
+	var documents []StoredRAGDocument
+	for rows.Next() {
+		var (
+			doc              StoredRAGDocument
+			embeddingContent sql.NullString
+			metadataJSON     []byte
+			contentHash      sql.NullString
+			blob             []byte
+		)
+		if err := rows.Scan(&doc.ID, &doc.Content, &embeddingContent, &metadataJSON, &contentHash, &blob, &doc.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan rag document: %w", err)
+		}
+		if doc.Metadata, err = decodeMetadata(metadataJSON); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal rag document metadata: %w", err)
+		}
+		doc.DocumentID = doc.ID
+		doc.EmbeddingContent = embeddingContent.String
+		doc.ContentHash = contentHash.String
+		doc.Embedding = decodeEmbedding(blob)
+		documents = append(documents, doc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rag documents: %w", err)
+	}
+
+	return documents, nil
+}
+
+// HasSessionPDFEmbeddings returns true if there is at least one embedding row
+// for documents of type 'pdf' associated with the given session.
+func (s *SQLiteStore) HasSessionPDFEmbeddings(ctx context.Context, sessionID uuid.UUID) (bool, error) {
+	const query = `
+        SELECT EXISTS (
+            SELECT 1
+            FROM rag_embeddings e
+            JOIN rag_documents d ON d.id = e.document_id
+            WHERE (d.metadata ->> 'session_id') = $1
+              AND (
+                    (d.metadata ->> 'type') IN ('pdf', 'pdf_summary')
+                 OR ((d.metadata ->> 'type') = 'document_chunk' AND COALESCE(d.metadata ->> 'filename', '') <> '')
+              )
+        )
+    `
+	var exists bool
+	if err := s.DB.QueryRowContext(ctx, query, sessionID.String()).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check session pdf embeddings: %w", err)
+	}
+	return exists, nil
+}
+
+// GetRAGDocumentContent returns the stored content for a given document ID.
+func (s *SQLiteStore) GetRAGDocumentContent(ctx context.Context, documentID uuid.UUID) (string, error) {
+	var content string
+	err := s.DB.QueryRowContext(ctx, `SELECT content FROM rag_documents WHERE id = $1`, documentID).Scan(&content)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", sql.ErrNoRows
+		}
+		return "", fmt.Errorf("failed to fetch rag document content: %w", err)
+	}
+	return content, nil
+}
+
+// GetDocumentsBatch returns contents for multiple document IDs using a single query.
+// Returns a map of id.String() -> content.
+func (s *SQLiteStore) GetDocumentsBatch(ctx context.Context, ids []uuid.UUID) (map[string]string, error) {
+	result := make(map[string]string)
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+		args[i] = id
+	}
+	query := "SELECT id, content FROM rag_documents WHERE id IN (" + strings.Join(placeholders, ", ") + ")"
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to batch fetch documents: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			return nil, fmt.Errorf("failed to scan batch document row: %w", err)
+		}
+		result[id.String()] = content
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating batch document rows: %w", err)
+	}
+	return result, nil
+}
+
+// GetDocument retrieves a full document record by ID.
+func (s *SQLiteStore) GetDocument(ctx context.Context, documentID uuid.UUID) (RAGDocument, error) {
+	docs, err := s.queryDocuments(ctx, `SELECT id, content, metadata, content_hash, created_at FROM rag_documents WHERE id = $1`, documentID)
+	if err != nil {
+		return RAGDocument{}, fmt.Errorf("failed to fetch document: %w", err)
+	}
+	if len(docs) == 0 {
+		return RAGDocument{}, sql.ErrNoRows
+	}
+	return docs[0], nil
+}
+
+// FindRAGDocumentByHash looks for an existing RAG document using session, role, and hashed content.
+// Returns uuid.Nil when no matching record exists or hash is empty.
+func (s *SQLiteStore) FindRAGDocumentByHash(ctx context.Context, sessionID, role, contentHash string) (uuid.UUID, error) {
+	if contentHash == "" {
+		return uuid.Nil, nil
+	}
+
+	var builder strings.Builder
+	builder.WriteString("SELECT id FROM rag_documents WHERE content_hash = $1")
+	args := []any{contentHash}
+	if sessionID != "" {
+		builder.WriteString(fmt.Sprintf(" AND COALESCE(metadata ->> 'session_id', '') = $%d", len(args)+1))
+		args = append(args, sessionID)
+	}
+	if role != "" {
+		builder.WriteString(fmt.Sprintf(" AND COALESCE(metadata ->> 'role', '') = $%d", len(args)+1))
+		args = append(args, role)
+	}
+	builder.WriteString(" LIMIT 1")
+
+	var documentID uuid.UUID
+	if err := s.DB.QueryRowContext(ctx, builder.String(), args...).Scan(&documentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, nil
+		}
+		return uuid.Nil, fmt.Errorf("failed to lookup rag document by hash: %w", err)
+	}
+	return documentID, nil
+}
+
+// FindDocumentIDsByContentHash returns document IDs for given content hashes.
+// Returns map: content_hash → document_id
+func (s *SQLiteStore) FindDocumentIDsByContentHash(ctx context.Context, sessionID string, contentHashes []string) (map[string]string, error) {
+	result := make(map[string]string)
+	if len(contentHashes) == 0 {
+		return result, nil
+	}
+
+	placeholders := make([]string, len(contentHashes))
+	args := make([]any, 0, len(contentHashes)+1)
+	args = append(args, sessionID)
+	for i, hash := range contentHashes {
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+		args = append(args, hash)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT content_hash, id
+		FROM rag_documents
+		WHERE (metadata ->> 'session_id') = $1
+		  AND content_hash IN (%s)
+	`, strings.Join(placeholders, ","))
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query document IDs by content hash: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash, docID string
+		if err := rows.Scan(&hash, &docID); err != nil {
+			return nil, fmt.Errorf("failed to scan document ID: %w", err)
+		}
+		result[hash] = docID
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating document ID results: %w", err)
+	}
+
+	return result, nil
+}
+
+// writeSearchFilters appends the filters shared by keyword and vector search (tier, session,
+// dataset, superseded state cards, excluded content hashes) to a query whose documents are
+// aliased rd.
+func writeSearchFilters(builder *strings.Builder, args []any, tier string, sessionID string, dataset string, excludeHashes []string) []any {
+	builder.WriteString(fmt.Sprintf(" AND rd.tier = $%d", len(args)+1))
+	args = append(args, tier)
+
+	if sessionID != "" {
+		builder.WriteString(fmt.Sprintf(" AND COALESCE(rd.metadata ->> 'session_id', '') = $%d", len(args)+1))
+		args = append(args, sessionID)
+	}
+	args = writeDatasetScope(builder, args, dataset)
+
+	// Exclude superseded state cards while preserving all other document types
+	builder.WriteString(" AND (COALESCE(rd.metadata ->> 'type', '') <> 'state' OR COALESCE(rd.metadata ->> 'state_status', '') <> 'superseded')")
+
+	if len(excludeHashes) > 0 {
+		placeholders := make([]string, len(excludeHashes))
+		for i, hash := range excludeHashes {
+			placeholders[i] = fmt.Sprintf("$%d", len(args)+1)
+			args = append(args, hash)
+		}
+		builder.WriteString(" AND (rd.content_hash IS NULL OR rd.content_hash NOT IN (" + strings.Join(placeholders, ", ") + "))")
+	}
+	return args
+}
+
+// SearchRAGDocumentsBM25 performs a BM25 keyword search over the stored RAG documents.
+func (s *SQLiteStore) SearchRAGDocumentsBM25(ctx context.Context, query string, limit int, sessionID string, excludeHashes []string) ([]BM25SearchResult, error) {
+	return s.SearchRAGDocumentsBM25Language(ctx, query, limit, sessionID, excludeHashes, "english", "")
+}
+
+// SearchRAGDocumentsBM25Language scores the candidate documents with Okapi BM25 in Go. Only
+// documents tagged with the language are searched (untagged ones count as english), but
+// terms are matched without stemming. Scores are mapped into [0, 1) like ts_rank_cd so
+// BM25_SCORE_THRESHOLD keeps a similar meaning on both backends. A non-empty dataset limits
+// the search to that dataset's documents and those not tied to any dataset.
+func (s *SQLiteStore) SearchRAGDocumentsBM25Language(ctx context.Context, query string, limit int, sessionID string, excludeHashes []string, language string, dataset string) ([]BM25SearchResult, error) {
+	return s.searchBM25Tier(ctx, query, limit, sessionID, excludeHashes, language, RAGTierHot, dataset)
+}
+
+// searchBM25Tier runs the BM25 search over the documents of one retrieval tier.
+func (s *SQLiteStore) searchBM25Tier(ctx context.Context, query string, limit int, sessionID string, excludeHashes []string, language string, tier string, dataset string) ([]BM25SearchResult, error) {
+	trimmed := strings.TrimSpace(query)
+	if trimmed == "" || limit <= 0 {
+		return nil, nil
+	}
+	if language == "" {
+		language = "english"
+	}
+	queryTerms := uniqueTerms(searchTerms(trimmed))
+	lowerQuery := strings.ToLower(trimmed)
+
+	var builder strings.Builder
+	args := []any{language}
+	builder.WriteString("SELECT rd.id, rd.metadata, rd.content FROM rag_documents rd WHERE COALESCE(rd.metadata ->> 'language', 'english') = $1")
+	args = writeSearchFilters(&builder, args, tier, sessionID, dataset, excludeHashes)
+
+	rows, err := s.DB.QueryContext(ctx, builder.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute BM25 search: %w", err)
+	}
+	defer rows.Close()
+
+	type candidate struct {
+		result BM25SearchResult
+		freqs  map[string]int
+		length int
+	}
+	var candidates []candidate
+	docFreq := make(map[string]int)
+	totalLength := 0
+	for rows.Next() {
+		var (
+			documentID   uuid.UUID
+			metadataJSON []byte
+			content      string
+		)
+		if err := rows.Scan(&documentID, &metadataJSON, &content); err != nil {
+			return nil, fmt.Errorf("failed to scan BM25 search result: %w", err)
+		}
+		metadata, err := decodeMetadata(metadataJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal BM25 metadata: %w", err)
+		}
+
+		searchable := content + " " + metadataSearchText(metadata)
+		terms := searchTerms(searchable)
+		freqs := make(map[string]int)
+		for _, term := range terms {
+			freqs[term]++
+		}
+		for _, term := range queryTerms {
+			if freqs[term] > 0 {
+				docFreq[term]++
+			}
+		}
+		totalLength += len(terms)
+
+		var bonus float64
+		if strings.Contains(strings.ToLower(searchable), lowerQuery) {
+			bonus = 0.2
+		}
+		candidates = append(candidates, candidate{
+			result: BM25SearchResult{DocumentID: documentID, Metadata: metadata, Content: content, ExactMatchBonus: bonus},
+			freqs:  freqs,
+			length: len(terms),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating BM25 search results: %w", err)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	n := float64(len(candidates))
+	avgLength := math.Max(float64(totalLength)/n, 1)
+	var results []BM25SearchResult
+	for _, c := range candidates {
+		var score float64
+		for _, term := range queryTerms {
+			tf := float64(c.freqs[term])
+			if tf == 0 {
+				continue
+			}
+			df := float64(docFreq[term])
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			score += idf * tf * (sqliteBM25K1 + 1) / (tf + sqliteBM25K1*(1-sqliteBM25B+sqliteBM25B*float64(c.length)/avgLength))
+		}
+		if score <= 0 && c.result.ExactMatchBonus == 0 {
+			continue
+		}
+		c.result.BM25Score = score / (score + 1)
+		results = append(results, c.result)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].BM25Score+results[i].ExactMatchBonus > results[j].BM25Score+results[j].ExactMatchBonus
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// metadataSearchText renders metadata like the PostgreSQL search does: each key with
+// underscores as spaces, then the value as-is and with underscores as spaces.
+func metadataSearchText(metadata map[string]string) string {
+	parts := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		parts = append(parts, strings.ReplaceAll(key, "_", " ")+" "+value+" "+strings.ReplaceAll(value, "_", " "))
+	}
+	return strings.Join(parts, " ")
+}
+
+// searchTerms lowercases text and splits it on anything that is not a letter or digit.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	unique := terms[:0:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			unique = append(unique, term)
+		}
+	}
+	return unique
+}
+
+// VectorSearchRAGDocuments performs a cosine similarity search over all embedding windows.
+func (s *SQLiteStore) VectorSearchRAGDocuments(ctx context.Context, queryVector []float32, limit int, sessionID string, excludeHashes []string) ([]VectorSearchResult, error) {
+	return s.VectorSearchRAGDocumentsForModel(ctx, queryVector, limit, sessionID, excludeHashes, "", "")
+}
+
+// VectorSearchRAGDocumentsForModel loads the candidate windows embedded by the given model
+// ("" for the default host), optionally scoped to a dataset, and ranks them by cosine
+// similarity in Go.
+func (s *SQLiteStore) VectorSearchRAGDocumentsForModel(ctx context.Context, queryVector []float32, limit int, sessionID string, excludeHashes []string, embeddingModel string, dataset string) ([]VectorSearchResult, error) {
+	return s.vectorSearchTier(ctx, queryVector, limit, sessionID, excludeHashes, embeddingModel, RAGTierHot, dataset)
+}
+
+// vectorSearchTier runs the vector search over the documents of one retrieval tier.
+func (s *SQLiteStore) vectorSearchTier(ctx context.Context, queryVector []float32, limit int, sessionID string, excludeHashes []string, embeddingModel string, tier string, dataset string) ([]VectorSearchResult, error) {
+	if len(queryVector) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	var builder strings.Builder
+	args := []any{embeddingModel}
+	builder.WriteString("SELECT rd.id, rd.metadata, rd.content, re.window_text, re.window_index, re.window_start, re.window_end, re.embedding ")
+	builder.WriteString("FROM rag_embeddings re INNER JOIN rag_documents rd ON re.document_id = rd.id ")
+	builder.WriteString("WHERE COALESCE(rd.metadata ->> 'embedding_model', '') = $1")
+	args = writeSearchFilters(&builder, args, tier, sessionID, dataset, excludeHashes)
+
+	rows, err := s.DB.QueryContext(ctx, builder.String(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute vector search: %w", err)
+	}
+	defer rows.Close()
+
+	var results []VectorSearchResult
+	for rows.Next() {
+		var (
+			result       VectorSearchResult
+			metadataJSON []byte
+			blob         []byte
+		)
+		if err := rows.Scan(&result.DocumentID, &metadataJSON, &result.Content, &result.EmbeddingContent, &result.WindowIndex, &result.WindowStart, &result.WindowEnd, &blob); err != nil {
+			return nil, fmt.Errorf("failed to scan vector search result: %w", err)
+		}
+		if result.Metadata, err = decodeMetadata(metadataJSON); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal vector search metadata: %w", err)
+		}
+		result.Similarity = cosineSimilarity(queryVector, decodeEmbedding(blob))
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating vector search results: %w", err)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Similarity > results[j].Similarity
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// GetLatestSessionDataset returns the dataset of the session's most recently stored
+// document that has one, or "" when none does.
+func (s *SQLiteStore) GetLatestSessionDataset(ctx context.Context, sessionID string) (string, error) {
+	return latestSessionDataset(ctx, s.DB, sessionID)
+}
+
+// GetSessionDocumentLanguages returns the distinct detected languages and embedding models
+// of a session's documents. Documents without a language tag are not included.
+func (s *SQLiteStore) GetSessionDocumentLanguages(ctx context.Context, sessionID string) (languages []string, embeddingModels []string, err error) {
+	query := `
+		SELECT DISTINCT COALESCE(metadata ->> 'language', ''), COALESCE(metadata ->> 'embedding_model', '')
+		FROM rag_documents
+		WHERE (metadata ->> 'session_id') = $1
+		  AND (json_type(metadata, '$.language') IS NOT NULL OR json_type(metadata, '$.embedding_model') IS NOT NULL)
+	`
+
+	rows, err := s.DB.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query document languages for session %s: %w", sessionID, err)
+	}
+	defer rows.Close()
+
+	seenLang := make(map[string]bool)
+	seenModel := make(map[string]bool)
+	for rows.Next() {
+		var language, model string
+		if err := rows.Scan(&language, &model); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan document language: %w", err)
+		}
+		if language != "" && !seenLang[language] {
+			seenLang[language] = true
+			languages = append(languages, language)
+		}
+		if model != "" && !seenModel[model] {
+			seenModel[model] = true
+			embeddingModels = append(embeddingModels, model)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating document languages: %w", err)
+	}
+
+	return languages, embeddingModels, nil
+}
+
+// DeleteRAGDocumentsBySession removes all RAG documents associated with the provided session.
+func (s *SQLiteStore) DeleteRAGDocumentsBySession(ctx context.Context, sessionID uuid.UUID) (int64, error) {
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM rag_documents WHERE (metadata ->> 'session_id') = $1`, sessionID.String())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete rag documents for session %s: %w", sessionID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to determine rows deleted for session %s: %w", sessionID, err)
+	}
+	return rowsAffected, nil
+}
+
+// ListSessionFactEmbeddings returns a session's fact documents with their primary
+// (window 0) embedding, newest first.
+func (s *SQLiteStore) ListSessionFactEmbeddings(ctx context.Context, sessionID string) ([]FactEmbedding, error) {
+	query := `
+		SELECT rd.id, rd.content, rd.metadata, re.embedding, rd.created_at
+		FROM rag_documents rd
+		INNER JOIN rag_embeddings re ON re.document_id = rd.id AND re.window_index = 0
+		WHERE (rd.metadata ->> 'session_id') = $1
+		  AND (rd.metadata ->> 'role') = 'fact'
+		  AND COALESCE(rd.metadata ->> 'type', '') = ''
+		ORDER BY rd.created_at DESC
+	`
+
+	rows, err := s.DB.QueryContext(ctx, query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fact embeddings for session %s: %w", sessionID, err)
+	}
+	defer rows.Close()
+
+	var facts []FactEmbedding
+	for rows.Next() {
+		var (
+			fact         FactEmbedding
+			metadataJSON []byte
+			blob         []byte
+		)
+		if err := rows.Scan(&fact.DocumentID, &fact.Content, &metadataJSON, &blob, &fact.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan fact embedding row: %w", err)
+		}
+		if fact.Metadata, err = decodeMetadata(metadataJSON); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal fact metadata: %w", err)
+		}
+		fact.Embedding = decodeEmbedding(blob)
+		facts = append(facts, fact)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating fact embedding rows: %w", err)
+	}
+
+	return facts, nil
+}
+
+// ConsolidateFacts records the duplicate IDs on the canonical fact's metadata
+// (consolidated_from), points memory checkpoints that listed a duplicate at the canonical
+// fact, and deletes the duplicates, in a single transaction.
+func (s *SQLiteStore) ConsolidateFacts(ctx context.Context, canonicalID uuid.UUID, duplicateIDs []uuid.UUID) error {
+	if len(duplicateIDs) == 0 {
+		return nil
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin fact consolidation: %w", err)
+	}
+	defer tx.Rollback()
+
+	var existing string
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(metadata ->> 'consolidated_from', '') FROM rag_documents WHERE id = $1`,
+		canonicalID).Scan(&existing); err != nil {
+		return fmt.Errorf("failed to load canonical fact %s: %w", canonicalID, err)
+	}
+
+	ids := make([]string, 0, len(duplicateIDs)+1)
+	if existing != "" {
+		ids = append(ids, existing)
+	}
+	placeholders := make([]string, len(duplicateIDs))
+	deleteArgs := make([]any, len(duplicateIDs))
+	for i, id := range duplicateIDs {
+		ids = append(ids, id.String())
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		deleteArgs[i] = id
+	}
+	provenance := strings.Join(ids, ",")
+	count := strings.Count(provenance, ",") + 1
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE rag_documents
+		SET metadata = json_set(COALESCE(metadata, '{}'), '$.consolidated_from', $2, '$.consolidated_count', $3)
+		WHERE id = $1
+	`, canonicalID, provenance, strconv.Itoa(count)); err != nil {
+		return fmt.Errorf("failed to record provenance on fact %s: %w", canonicalID, err)
+	}
+
+	// Checkpoints taken before the merge keep the fact in scope through its canonical copy
+	repointArgs := append([]any{canonicalID}, deleteArgs...)
+	repointPlaceholders := make([]string, len(duplicateIDs))
+	for i := range duplicateIDs {
+		repointPlaceholders[i] = fmt.Sprintf("$%d", i+2)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO memory_checkpoint_documents (checkpoint_id, document_id, kind, content_hash, state_content)
+		SELECT checkpoint_id, $1, kind, COALESCE((SELECT content_hash FROM rag_documents WHERE id = $1), ''), state_content
+		FROM memory_checkpoint_documents
+		WHERE document_id IN (`+strings.Join(repointPlaceholders, ", ")+`)
+		ON CONFLICT DO NOTHING
+	`, repointArgs...); err != nil {
+		return fmt.Errorf("failed to repoint checkpoints to fact %s: %w", canonicalID, err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM memory_checkpoint_documents WHERE document_id IN (`+strings.Join(placeholders, ", ")+`)`, deleteArgs...); err != nil {
+		return fmt.Errorf("failed to drop consolidated facts from checkpoints: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM rag_documents WHERE id IN (`+strings.Join(placeholders, ", ")+`)`, deleteArgs...); err != nil {
+		return fmt.Errorf("failed to delete consolidated facts: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit fact consolidation: %w", err)
+	}
+	return nil
+}
+
+// ArchiveRAGDocuments moves hot documents created before createdBefore whose role is one of
+// roles (or summaries of such documents) to the archived tier. Returns the number moved.
+func (s *SQLiteStore) ArchiveRAGDocuments(ctx context.Context, createdBefore time.Time, roles []string) (int64, error) {
+	return archiveRAGDocuments(ctx, s.DB, sqliteTime(time.Now()), sqliteTime(createdBefore), roles)
+}
+
+// DeleteArchivedRAGDocuments removes documents archived before archivedBefore (their
+// embeddings cascade). Returns the number deleted.
+func (s *SQLiteStore) DeleteArchivedRAGDocuments(ctx context.Context, archivedBefore time.Time) (int64, error) {
+	return deleteArchivedRAGDocuments(ctx, s.DB, sqliteTime(archivedBefore))
+}
+
+// SearchArchivedRAGDocumentsBM25 runs the BM25 search over a session's archived documents.
+func (s *SQLiteStore) SearchArchivedRAGDocumentsBM25(ctx context.Context, query string, limit int, sessionID string) ([]BM25SearchResult, error) {
+	return s.searchBM25Tier(ctx, query, limit, sessionID, nil, "english", RAGTierArchived, "")
+}
+
+// VectorSearchArchivedRAGDocuments runs the vector search over a session's archived documents.
+func (s *SQLiteStore) VectorSearchArchivedRAGDocuments(ctx context.Context, queryVector []float32, limit int, sessionID string, embeddingModel string) ([]VectorSearchResult, error) {
+	return s.vectorSearchTier(ctx, queryVector, limit, sessionID, nil, embeddingModel, RAGTierArchived, "")
+}
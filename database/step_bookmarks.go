@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// Step bookmarks are plain rows without JSON or vectors, so both backends share the SQL.
+
+// CreateStepBookmark bookmarks a step, replacing the label of an existing bookmark on the
+// same step. Returns the stored bookmark.
+func (s *PostgresStore) CreateStepBookmark(ctx context.Context, bookmark types.StepBookmark) (types.StepBookmark, error) {
+	return createStepBookmark(ctx, s.DB, bookmark)
+}
+
+// GetStepBookmarks returns the session's bookmarks, oldest first.
+func (s *PostgresStore) GetStepBookmarks(ctx context.Context, sessionID uuid.UUID) ([]types.StepBookmark, error) {
+	return getStepBookmarks(ctx, s.DB, sessionID)
+}
+
+// DeleteStepBookmark removes one of the session's bookmarks.
+func (s *PostgresStore) DeleteStepBookmark(ctx context.Context, sessionID, bookmarkID uuid.UUID) error {
+	return deleteStepBookmark(ctx, s.DB, sessionID, bookmarkID)
+}
+
+// CreateStepBookmark bookmarks a step, replacing the label of an existing bookmark on the
+// same step. Returns the stored bookmark.
+func (s *SQLiteStore) CreateStepBookmark(ctx context.Context, bookmark types.StepBookmark) (types.StepBookmark, error) {
+	return createStepBookmark(ctx, s.DB, bookmark)
+}
+
+// GetStepBookmarks returns the session's bookmarks, oldest first.
+func (s *SQLiteStore) GetStepBookmarks(ctx context.Context, sessionID uuid.UUID) ([]types.StepBookmark, error) {
+	return getStepBookmarks(ctx, s.DB, sessionID)
+}
+
+// DeleteStepBookmark removes one of the session's bookmarks.
+func (s *SQLiteStore) DeleteStepBookmark(ctx context.Context, sessionID, bookmarkID uuid.UUID) error {
+	return deleteStepBookmark(ctx, s.DB, sessionID, bookmarkID)
+}
+
+func createStepBookmark(ctx context.Context, db *sql.DB, bookmark types.StepBookmark) (types.StepBookmark, error) {
+	if bookmark.ID == uuid.Nil {
+		bookmark.ID = uuid.New()
+	}
+	query := `
+		INSERT INTO step_bookmarks (id, session_id, message_id, label, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (session_id, message_id) DO UPDATE SET label = excluded.label
+		RETURNING id, created_at
+	`
+	err := db.QueryRowContext(ctx, query, bookmark.ID, bookmark.SessionID, bookmark.MessageID, bookmark.Label, time.Now().UTC()).
+		Scan(&bookmark.ID, &bookmark.CreatedAt)
+	if err != nil {
+		return types.StepBookmark{}, fmt.Errorf("failed to save step bookmark: %w", err)
+	}
+	return bookmark, nil
+}
+
+func getStepBookmarks(ctx context.Context, db *sql.DB, sessionID uuid.UUID) ([]types.StepBookmark, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, session_id, message_id, COALESCE(label, ''), created_at
+		FROM step_bookmarks
+		WHERE session_id = $1
+		ORDER BY created_at, id`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query step bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	var bookmarks []types.StepBookmark
+	for rows.Next() {
+		var b types.StepBookmark
+		if err := rows.Scan(&b.ID, &b.SessionID, &b.MessageID, &b.Label, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan step bookmark: %w", err)
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating step bookmarks: %w", err)
+	}
+	return bookmarks, nil
+}
+
+func deleteStepBookmark(ctx context.Context, db *sql.DB, sessionID, bookmarkID uuid.UUID) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM step_bookmarks WHERE id = $1 AND session_id = $2`, bookmarkID, sessionID); err != nil {
+		return fmt.Errorf("failed to delete step bookmark: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// Store is the persistence layer used by the services, the RAG pipeline and the admin
+// commands. PostgresStore is the server backend; SQLiteStore runs the whole app from a
+// single local file for single-user desktop installs.
+type Store interface {
+	EnsureSchema(ctx context.Context) error
+
+	// Users and sessions
+	CreateUser(ctx context.Context) (uuid.UUID, error)
+	GetUserByID(ctx context.Context, userID uuid.UUID) error
+	DeleteUser(ctx context.Context, userID uuid.UUID) error
+	GetUserAccount(ctx context.Context, userID uuid.UUID) (types.UserAccount, error)
+	GetUserAccountByEmail(ctx context.Context, email string) (types.UserAccount, error)
+	SetUserAccount(ctx context.Context, account types.UserAccount) error
+	GetUserByIdentity(ctx context.Context, provider, subject string) (uuid.UUID, error)
+	LinkUserIdentity(ctx context.Context, userID uuid.UUID, provider, subject, email string) error
+	ClaimUserSessions(ctx context.Context, fromUserID, toUserID uuid.UUID) (int64, error)
+	CreateSession(ctx context.Context, userID *uuid.UUID) (uuid.UUID, error)
+	CreateSessionWithMode(ctx context.Context, userID *uuid.UUID, mode string) (uuid.UUID, error)
+	GetSessionByID(ctx context.Context, sessionID uuid.UUID) (types.Session, error)
+	GetSessions(ctx context.Context, userID *uuid.UUID) ([]types.Session, error)
+	UpdateSessionTitle(ctx context.Context, sessionID uuid.UUID, title string) error
+	UpdateSessionTags(ctx context.Context, sessionID uuid.UUID, tags []string) error
+	UpdateSessionMode(ctx context.Context, sessionID uuid.UUID, mode string) error
+	UpdateSessionVerbosity(ctx context.Context, sessionID uuid.UUID, verbosity string) error
+	UpdateSessionEffectSizeCheck(ctx context.Context, sessionID uuid.UUID, mode string) error
+	UpdateSessionRandomSeed(ctx context.Context, sessionID uuid.UUID, seed *int64) error
+	UpdateSessionLLMModel(ctx context.Context, sessionID uuid.UUID, model string) error
+	UpdateSessionRetrievalPolicy(ctx context.Context, sessionID uuid.UUID, policy string) error
+	UpdateSessionAbstract(ctx context.Context, sessionID uuid.UUID, abstract string) error
+	GetStaleSessions(ctx context.Context, lastActiveBefore time.Time) ([]uuid.UUID, error)
+	GetRecentlyActiveSessions(ctx context.Context, lastActiveAfter time.Time) ([]uuid.UUID, error)
+	DeleteSession(ctx context.Context, sessionID uuid.UUID) error
+	MergeSessions(ctx context.Context, sourceID, targetID uuid.UUID, fileNames map[string]string) (SessionMergeResult, error)
+	ResolveSessionRedirect(ctx context.Context, sessionID uuid.UUID) (uuid.UUID, error)
+	ListSessionIDsAfter(ctx context.Context, after uuid.UUID, userID *uuid.UUID, lastActiveBefore *time.Time, limit int) ([]uuid.UUID, error)
+	ArchiveSession(ctx context.Context, sessionID uuid.UUID) error
+	GetSessionUsage(ctx context.Context, after uuid.UUID, limit int) ([]types.SessionUsage, error)
+
+	// Messages
+	CreateMessage(ctx context.Context, msg types.ChatMessage) error
+	AppendToMessageRendered(ctx context.Context, messageID string, extraHTML string) error
+	GetMessagesBySession(ctx context.Context, sessionID uuid.UUID) ([]types.ChatMessage, error)
+	GetMessagesPageBySession(ctx context.Context, sessionID uuid.UUID, before string, turns int) ([]types.ChatMessage, bool, error)
+	RetractMessageArtifacts(ctx context.Context, sessionID uuid.UUID, refs MessageArtifactRefs) (RetractionResult, error)
+	UpdateMessageContentHashes(ctx context.Context, sessionID uuid.UUID, updates []types.MessageHashUpdate) (int64, int64, error)
+	SearchMessages(ctx context.Context, userID uuid.UUID, query string, limit int) ([]types.MessageSearchResult, error)
+
+	// Files
+	CreateFile(ctx context.Context, file FileRecord) (FileRecord, error)
+	GetFilesBySession(ctx context.Context, sessionID uuid.UUID) ([]FileRecord, error)
+	GetNewFilesBySession(ctx context.Context, sessionID uuid.UUID, after time.Time) ([]FileRecord, error)
+	GetFileBySessionAndName(ctx context.Context, sessionID uuid.UUID, filename string) (FileRecord, error)
+	GetArtifactFiles(ctx context.Context, sessionID uuid.UUID, limit, offset int) ([]FileRecord, int, error)
+	LinkFilesToMessage(ctx context.Context, sessionID uuid.UUID, filenames []string, messageID uuid.UUID) error
+	GetTrackedFilenames(ctx context.Context, sessionID uuid.UUID) (map[string]bool, error)
+	GetColumnTypeOverrides(ctx context.Context, sessionID uuid.UUID) (map[string]map[string]string, error)
+	SetColumnTypeOverrides(ctx context.Context, sessionID uuid.UUID, filename string, columnTypes map[string]string) error
+	GetFileAltTexts(ctx context.Context, sessionID uuid.UUID) (map[string]string, error)
+	SetFileAltText(ctx context.Context, sessionID uuid.UUID, filename, altText string) error
+	DeleteFile(ctx context.Context, fileID uuid.UUID) error
+	RenameFile(ctx context.Context, sessionID uuid.UUID, filename, newFilename, newFilePath string) error
+
+	// RAG documents and embeddings
+	UpsertDocument(ctx context.Context, documentID uuid.UUID, content string, metadata map[string]string, contentHash string) (uuid.UUID, error)
+	CreateEmbedding(ctx context.Context, documentID uuid.UUID, windowIndex, windowStart, windowEnd int, windowText string, embedding []float32) error
+	GetDocumentEmbeddings(ctx context.Context, documentID uuid.UUID) ([]RAGEmbedding, error)
+	UpsertRAGDocument(ctx context.Context, documentID uuid.UUID, content string, embeddingContent string, metadata map[string]string, contentHash string, embedding []float32) error
+	FindStateDocument(ctx context.Context, sessionID, dataset, stage string) (uuid.UUID, string, map[string]string, error)
+	FindStateDocumentWithFilters(ctx context.Context, sessionID, dataset, stage, filtersKey string) (uuid.UUID, string, map[string]string, error)
+	ListStateDocuments(ctx context.Context, sessionID string) ([]RAGDocument, error)
+	QueryDocumentsByMetadata(ctx context.Context, filters map[string]string, limit int) ([]RAGDocument, error)
+	DeleteRAGDocument(ctx context.Context, id uuid.UUID) error
+	ListRAGDocuments(ctx context.Context) ([]StoredRAGDocument, error)
+	HasSessionPDFEmbeddings(ctx context.Context, sessionID uuid.UUID) (bool, error)
+	GetRAGDocumentContent(ctx context.Context, documentID uuid.UUID) (string, error)
+	GetDocumentsBatch(ctx context.Context, ids []uuid.UUID) (map[string]string, error)
+	GetDocument(ctx context.Context, documentID uuid.UUID) (RAGDocument, error)
+	FindRAGDocumentByHash(ctx context.Context, sessionID, role, contentHash string) (uuid.UUID, error)
+	FindDocumentIDsByContentHash(ctx context.Context, sessionID string, contentHashes []string) (map[string]string, error)
+	SearchRAGDocumentsBM25(ctx context.Context, query string, limit int, sessionID string, excludeHashes []string) ([]BM25SearchResult, error)
+	SearchRAGDocumentsBM25Language(ctx context.Context, query string, limit int, sessionID string, excludeHashes []string, language string, dataset string) ([]BM25SearchResult, error)
+	VectorSearchRAGDocuments(ctx context.Context, queryVector []float32, limit int, sessionID string, excludeHashes []string) ([]VectorSearchResult, error)
+	VectorSearchRAGDocumentsForModel(ctx context.Context, queryVector []float32, limit int, sessionID string, excludeHashes []string, embeddingModel string, dataset string) ([]VectorSearchResult, error)
+	GetSessionDocumentLanguages(ctx context.Context, sessionID string) (languages []string, embeddingModels []string, err error)
+	GetLatestSessionDataset(ctx context.Context, sessionID string) (string, error)
+	DeleteRAGDocumentsBySession(ctx context.Context, sessionID uuid.UUID) (int64, error)
+	DeleteMessageDocuments(ctx context.Context, sessionID uuid.UUID, contentHashes []string) (int64, error)
+	ListSessionFactEmbeddings(ctx context.Context, sessionID string) ([]FactEmbedding, error)
+	ConsolidateFacts(ctx context.Context, canonicalID uuid.UUID, duplicateIDs []uuid.UUID) error
+	ArchiveRAGDocuments(ctx context.Context, createdBefore time.Time, roles []string) (int64, error)
+	DeleteArchivedRAGDocuments(ctx context.Context, archivedBefore time.Time) (int64, error)
+	SearchArchivedRAGDocumentsBM25(ctx context.Context, query string, limit int, sessionID string) ([]BM25SearchResult, error)
+	VectorSearchArchivedRAGDocuments(ctx context.Context, queryVector []float32, limit int, sessionID string, embeddingModel string) ([]VectorSearchResult, error)
+
+	// Retrieval experiments and run recording
+	CreateRetrievalExperimentEvent(ctx context.Context, sessionID uuid.UUID, arm, signal string, value float64) error
+	GetRetrievalExperimentSummary(ctx context.Context, since time.Time) ([]RetrievalArmSummary, error)
+	SaveRunTurn(ctx context.Context, turn types.RunTurn) error
+	GetRunTurns(ctx context.Context, sessionID uuid.UUID, runID string) ([]types.RunTurn, error)
+
+	// Action cache persistence
+	SaveActionResult(ctx context.Context, record types.ActionRecord) error
+	GetActionResults(ctx context.Context, sessionID string) ([]types.ActionRecord, error)
+	DeleteActionResults(ctx context.Context, sessionID string) error
+
+	// Summary cache (fact and searchable summaries shared across sessions)
+	GetCachedSummary(ctx context.Context, key string, createdAfter time.Time) (string, error)
+	SaveCachedSummary(ctx context.Context, key, kind, summary string) error
+	PruneSummaryCache(ctx context.Context, createdBefore time.Time) (int64, error)
+
+	// Step bookmarks
+	CreateStepBookmark(ctx context.Context, bookmark types.StepBookmark) (types.StepBookmark, error)
+	GetStepBookmarks(ctx context.Context, sessionID uuid.UUID) ([]types.StepBookmark, error)
+	DeleteStepBookmark(ctx context.Context, sessionID, bookmarkID uuid.UUID) error
+
+	// Session permissions (share links)
+	CreateSessionPermission(ctx context.Context, permission types.SessionPermission) (types.SessionPermission, error)
+	GetSessionPermission(ctx context.Context, permissionID uuid.UUID) (types.SessionPermission, error)
+	ListSessionPermissions(ctx context.Context, sessionID uuid.UUID) ([]types.SessionPermission, error)
+	RevokeSessionPermission(ctx context.Context, sessionID, permissionID uuid.UUID) error
+
+	// Message annotations
+	CreateMessageAnnotation(ctx context.Context, annotation types.MessageAnnotation) (types.MessageAnnotation, error)
+	GetMessageAnnotations(ctx context.Context, sessionID uuid.UUID) ([]types.MessageAnnotation, error)
+	GetMessageAnnotation(ctx context.Context, sessionID, annotationID uuid.UUID) (types.MessageAnnotation, error)
+	DeleteMessageAnnotation(ctx context.Context, sessionID, annotationID uuid.UUID) error
+
+	// Memory checkpoints
+	CreateMemoryCheckpoint(ctx context.Context, sessionID uuid.UUID, name string) (types.MemoryCheckpoint, error)
+	GetMemoryCheckpoints(ctx context.Context, sessionID uuid.UUID) ([]types.MemoryCheckpoint, error)
+	GetMemoryCheckpointDocuments(ctx context.Context, sessionID, checkpointID uuid.UUID) ([]types.CheckpointDocument, error)
+	ListSessionMemoryDocuments(ctx context.Context, sessionID uuid.UUID) ([]types.CheckpointDocument, error)
+	DeleteMemoryCheckpoint(ctx context.Context, sessionID, checkpointID uuid.UUID) error
+
+	// Bulk admin jobs
+	CreateAdminJob(ctx context.Context, kind string, params types.AdminJobParams) (types.AdminJob, error)
+	GetAdminJob(ctx context.Context, jobID uuid.UUID) (types.AdminJob, error)
+	ListAdminJobs(ctx context.Context, limit int) ([]types.AdminJob, error)
+	ListResumableAdminJobs(ctx context.Context) ([]types.AdminJob, error)
+	SaveAdminJobProgress(ctx context.Context, job types.AdminJob) (bool, error)
+	CancelAdminJob(ctx context.Context, jobID uuid.UUID) error
+
+	// Background analysis jobs
+	CreateAnalysisJob(ctx context.Context, sessionID uuid.UUID, userMessageID string) (types.AnalysisJob, error)
+	GetAnalysisJob(ctx context.Context, sessionID, jobID uuid.UUID) (types.AnalysisJob, error)
+	ListAnalysisJobs(ctx context.Context, sessionID uuid.UUID, limit int) ([]types.AnalysisJob, error)
+	SaveAnalysisJob(ctx context.Context, job types.AnalysisJob) error
+	FailInterruptedAnalysisJobs(ctx context.Context, reason string, staleBefore time.Time) (int64, error)
+
+	// Multi-replica coordination
+	ClaimActiveRun(ctx context.Context, run types.ActiveRun) error
+	TouchActiveRun(ctx context.Context, sessionID uuid.UUID, token string) (bool, error)
+	GetActiveRun(ctx context.Context, sessionID uuid.UUID, staleBefore time.Time) (types.ActiveRun, error)
+	ReleaseActiveRun(ctx context.Context, sessionID uuid.UUID, token string) error
+	WithAdvisoryLock(ctx context.Context, key string, fn func(ctx context.Context) error) error
+
+	// Maintenance
+	AnalyzeTables(ctx context.Context) error
+	VacuumTables(ctx context.Context) error
+	CountRAGEmbeddings(ctx context.Context) (int64, error)
+	ReindexVectorIndex(ctx context.Context) error
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*SQLiteStore)(nil)
+)
+
+// Open connects to the store selected by driver ("postgres" or "sqlite"). dsn is the
+// PostgreSQL connection string or the SQLite database file path. vectorIndex configures the
+// Postgres vector index; SQLite scans embeddings directly and ignores it.
+func Open(driver, dsn string, vectorIndex VectorIndexOptions) (Store, error) {
+	// Return through explicit nil checks: a nil concrete pointer in a Store is not a nil Store
+	switch driver {
+	case "", "postgres":
+		store, err := NewPostgresStore(dsn, vectorIndex)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "sqlite":
+		store, err := NewSQLiteStore(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
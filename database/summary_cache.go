@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// The summary cache maps a hash of a summarization prompt to the summary the LLM gave for
+// it, so the same code/result pair summarized in another session costs no LLM call. Its
+// SQL is portable, so both backends share it.
+
+// GetCachedSummary returns the summary stored under key after createdAfter (zero for any
+// age) and counts the hit, or sql.ErrNoRows.
+func (s *PostgresStore) GetCachedSummary(ctx context.Context, key string, createdAfter time.Time) (string, error) {
+	return getCachedSummary(ctx, s.DB, key, createdAfter)
+}
+
+// GetCachedSummary returns the summary stored under key after createdAfter (zero for any
+// age) and counts the hit, or sql.ErrNoRows.
+func (s *SQLiteStore) GetCachedSummary(ctx context.Context, key string, createdAfter time.Time) (string, error) {
+	return getCachedSummary(ctx, s.DB, key, createdAfter)
+}
+
+// SaveCachedSummary stores summary under key, replacing an expired entry.
+func (s *PostgresStore) SaveCachedSummary(ctx context.Context, key, kind, summary string) error {
+	return saveCachedSummary(ctx, s.DB, key, kind, summary)
+}
+
+// SaveCachedSummary stores summary under key, replacing an expired entry.
+func (s *SQLiteStore) SaveCachedSummary(ctx context.Context, key, kind, summary string) error {
+	return saveCachedSummary(ctx, s.DB, key, kind, summary)
+}
+
+// PruneSummaryCache deletes the entries created before createdBefore and returns how many.
+func (s *PostgresStore) PruneSummaryCache(ctx context.Context, createdBefore time.Time) (int64, error) {
+	return pruneSummaryCache(ctx, s.DB, createdBefore)
+}
+
+// PruneSummaryCache deletes the entries created before createdBefore and returns how many.
+func (s *SQLiteStore) PruneSummaryCache(ctx context.Context, createdBefore time.Time) (int64, error) {
+	return pruneSummaryCache(ctx, s.DB, createdBefore)
+}
+
+func getCachedSummary(ctx context.Context, db *sql.DB, key string, createdAfter time.Time) (string, error) {
+	var summary string
+	err := db.QueryRowContext(ctx, `
+		UPDATE summary_cache SET hits = hits + 1, last_used_at = $3
+		WHERE cache_key = $1 AND created_at >= $2
+		RETURNING summary`,
+		key, createdAfter.UTC(), time.Now().UTC()).Scan(&summary)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", err
+		}
+		return "", fmt.Errorf("failed to get cached summary: %w", err)
+	}
+	return summary, nil
+}
+
+func saveCachedSummary(ctx context.Context, db *sql.DB, key, kind, summary string) error {
+	now := time.Now().UTC()
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO summary_cache (cache_key, kind, summary, hits, created_at, last_used_at)
+		VALUES ($1, $2, $3, 0, $4, $4)
+		ON CONFLICT (cache_key) DO UPDATE
+		SET kind = EXCLUDED.kind, summary = EXCLUDED.summary, hits = 0,
+		    created_at = EXCLUDED.created_at, last_used_at = EXCLUDED.last_used_at`,
+		key, kind, summary, now); err != nil {
+		return fmt.Errorf("failed to save cached summary: %w", err)
+	}
+	return nil
+}
+
+func pruneSummaryCache(ctx context.Context, db *sql.DB, createdBefore time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM summary_cache WHERE created_at < $1`, createdBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune summary cache: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,176 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// User accounts and OAuth identities use only portable SQL, so both backends share it.
+
+var (
+	// ErrEmailTaken is returned when another account already uses the email.
+	ErrEmailTaken = errors.New("email already registered")
+	// ErrUserHasAccount is returned when an account is requested for a user that already
+	// has one.
+	ErrUserHasAccount = errors.New("user already has an account")
+)
+
+const userAccountColumns = `id, email, COALESCE(display_name, ''), COALESCE(password_hash, ''), created_at`
+
+// GetUserAccount returns a user with its sign-in details, or sql.ErrNoRows. Anonymous
+// users have an empty email.
+func (s *PostgresStore) GetUserAccount(ctx context.Context, userID uuid.UUID) (types.UserAccount, error) {
+	return getUserAccount(ctx, s.DB, `SELECT `+userAccountColumns+` FROM users WHERE id = $1`, userID)
+}
+
+// GetUserAccountByEmail returns the account with the (normalized) email, or sql.ErrNoRows.
+func (s *PostgresStore) GetUserAccountByEmail(ctx context.Context, email string) (types.UserAccount, error) {
+	return getUserAccount(ctx, s.DB, `SELECT `+userAccountColumns+` FROM users WHERE email = $1`, email)
+}
+
+// SetUserAccount turns an anonymous user into an account. See setUserAccount.
+func (s *PostgresStore) SetUserAccount(ctx context.Context, account types.UserAccount) error {
+	return setUserAccount(ctx, s.DB, account)
+}
+
+// GetUserByIdentity returns the user linked to an OAuth identity, or sql.ErrNoRows.
+func (s *PostgresStore) GetUserByIdentity(ctx context.Context, provider, subject string) (uuid.UUID, error) {
+	return getUserByIdentity(ctx, s.DB, provider, subject)
+}
+
+// LinkUserIdentity links an OAuth identity to a user.
+func (s *PostgresStore) LinkUserIdentity(ctx context.Context, userID uuid.UUID, provider, subject, email string) error {
+	return linkUserIdentity(ctx, s.DB, userID, provider, subject, email)
+}
+
+// ClaimUserSessions moves an anonymous user's sessions to an account. See claimUserSessions.
+func (s *PostgresStore) ClaimUserSessions(ctx context.Context, fromUserID, toUserID uuid.UUID) (int64, error) {
+	return claimUserSessions(ctx, s.DB, fromUserID, toUserID)
+}
+
+// GetUserAccount returns a user with its sign-in details, or sql.ErrNoRows. Anonymous
+// users have an empty email.
+func (s *SQLiteStore) GetUserAccount(ctx context.Context, userID uuid.UUID) (types.UserAccount, error) {
+	return getUserAccount(ctx, s.DB, `SELECT `+userAccountColumns+` FROM users WHERE id = $1`, userID)
+}
+
+// GetUserAccountByEmail returns the account with the (normalized) email, or sql.ErrNoRows.
+func (s *SQLiteStore) GetUserAccountByEmail(ctx context.Context, email string) (types.UserAccount, error) {
+	return getUserAccount(ctx, s.DB, `SELECT `+userAccountColumns+` FROM users WHERE email = $1`, email)
+}
+
+// SetUserAccount turns an anonymous user into an account. See setUserAccount.
+func (s *SQLiteStore) SetUserAccount(ctx context.Context, account types.UserAccount) error {
+	return setUserAccount(ctx, s.DB, account)
+}
+
+// GetUserByIdentity returns the user linked to an OAuth identity, or sql.ErrNoRows.
+func (s *SQLiteStore) GetUserByIdentity(ctx context.Context, provider, subject string) (uuid.UUID, error) {
+	return getUserByIdentity(ctx, s.DB, provider, subject)
+}
+
+// LinkUserIdentity links an OAuth identity to a user.
+func (s *SQLiteStore) LinkUserIdentity(ctx context.Context, userID uuid.UUID, provider, subject, email string) error {
+	return linkUserIdentity(ctx, s.DB, userID, provider, subject, email)
+}
+
+// ClaimUserSessions moves an anonymous user's sessions to an account. See claimUserSessions.
+func (s *SQLiteStore) ClaimUserSessions(ctx context.Context, fromUserID, toUserID uuid.UUID) (int64, error) {
+	return claimUserSessions(ctx, s.DB, fromUserID, toUserID)
+}
+
+func getUserAccount(ctx context.Context, db *sql.DB, query string, arg any) (types.UserAccount, error) {
+	var account types.UserAccount
+	var email sql.NullString
+	err := db.QueryRowContext(ctx, query, arg).
+		Scan(&account.ID, &email, &account.DisplayName, &account.PasswordHash, &account.CreatedAt)
+	if err != nil {
+		return types.UserAccount{}, err
+	}
+	account.Email = email.String
+	return account, nil
+}
+
+// setUserAccount sets the email, display name and password hash of an anonymous user, so
+// the sessions it already owns become the account's. Returns ErrEmailTaken when another
+// user has the email and ErrUserHasAccount when the user is not anonymous.
+func setUserAccount(ctx context.Context, db *sql.DB, account types.UserAccount) error {
+	result, err := db.ExecContext(ctx, `
+		UPDATE users SET email = $2, display_name = $3, password_hash = $4
+		WHERE id = $1 AND email IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM users WHERE email = $2)`,
+		account.ID, account.Email, account.DisplayName, account.PasswordHash)
+	if err != nil {
+		return fmt.Errorf("failed to save user account: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to save user account: %w", err)
+	} else if n > 0 {
+		return nil
+	}
+
+	existing, err := getUserAccount(ctx, db, `SELECT `+userAccountColumns+` FROM users WHERE id = $1`, account.ID)
+	if err != nil {
+		return err
+	}
+	if existing.Email != "" {
+		return ErrUserHasAccount
+	}
+	return ErrEmailTaken
+}
+
+func getUserByIdentity(ctx context.Context, db *sql.DB, provider, subject string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := db.QueryRowContext(ctx,
+		`SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`, provider, subject).Scan(&userID)
+	return userID, err
+}
+
+func linkUserIdentity(ctx context.Context, db *sql.DB, userID uuid.UUID, provider, subject, email string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO user_identities (provider, subject, user_id, email, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (provider, subject) DO UPDATE SET email = excluded.email`,
+		provider, subject, userID, email, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to link %s identity: %w", provider, err)
+	}
+	return nil
+}
+
+// claimUserSessions moves every session of fromUserID to toUserID and deletes fromUserID
+// if it is anonymous. This is how the sessions started before signing in follow the user
+// into the account. Returns the number of sessions moved.
+func claimUserSessions(ctx context.Context, db *sql.DB, fromUserID, toUserID uuid.UUID) (int64, error) {
+	if fromUserID == toUserID {
+		return 0, nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin session claim: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE sessions SET user_id = $2 WHERE user_id = $1`, fromUserID, toUserID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move sessions: %w", err)
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to move sessions: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1 AND email IS NULL`, fromUserID); err != nil {
+		return 0, fmt.Errorf("failed to delete anonymous user: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit session claim: %w", err)
+	}
+	return moved, nil
+}
@@ -4,6 +4,8 @@ import io
 import os
 import signal
 import argparse
+import json
+import warnings
 
 # A special token to signal the end of a message.
 EOM_TOKEN = "<|EOM|>"
@@ -11,10 +13,143 @@ EOM_TOKEN = "<|EOM|>"
 # This is now our session manager.
 sessions = {}
 
+# Root of the per-session workspace directories (overridable with --workspaces)
+WORKSPACES_ROOT = '/app/workspaces'
+
+# SIGALRM-based timeouts are unavailable on Windows; there the caller enforces its own I/O timeout
+HAS_ALARM = hasattr(signal, 'SIGALRM')
+
+# Workspace manifest (JSON list of filenames) of user-uploaded source files, written by the server.
+# Listed files and the manifest itself are read-only for executed code; the rest of the workspace is writable.
+PROTECTED_MANIFEST = '.protected_files'
+
+# Resolved paths guarded while session code runs (empty outside execute_code)
+protected_paths = set()
+
 # Custom exception for timeouts
 class TimeoutException(Exception):
     pass
 
+class ProtectedFileError(Exception):
+    """Raised when executed code tries to modify a protected upload.
+
+    Deliberately not an OSError, so library fallbacks (e.g. shutil.move's copy-and-delete)
+    do not swallow it. The message is "operation=<op> file=<name>" so the server can parse it.
+    """
+    def __init__(self, operation, path):
+        super().__init__(f"operation={operation} file={os.path.basename(path)}")
+
+def load_protected_paths(workspace_dir):
+    """Returns the resolved paths of the workspace's protected uploads, including the manifest."""
+    manifest = os.path.join(workspace_dir, PROTECTED_MANIFEST)
+    paths = {os.path.realpath(manifest)}
+    try:
+        with open(manifest, encoding='utf-8') as f:
+            names = json.load(f)
+    except (OSError, ValueError):
+        return paths
+    for name in names if isinstance(names, list) else []:
+        if isinstance(name, str) and name:
+            paths.add(os.path.realpath(os.path.join(workspace_dir, name)))
+    return paths
+
+def _guarded(path):
+    if not protected_paths or path is None or isinstance(path, int):
+        return None
+    try:
+        resolved = os.path.realpath(os.fsdecode(path))
+    except (TypeError, ValueError):
+        return None
+    return resolved if resolved in protected_paths else None
+
+WRITE_FLAGS = os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_TRUNC | os.O_CREAT
+
+def protect_uploads_hook(event, args):
+    """Audit hook enforcing the read-only policy for protected uploads."""
+    if not protected_paths:
+        return
+    if event == 'open':
+        path, mode, flags = args
+        writing = (isinstance(mode, str) and any(c in mode for c in 'wax+')) or (isinstance(flags, int) and flags & WRITE_FLAGS)
+        if writing and _guarded(path):
+            raise ProtectedFileError('overwrite', os.fsdecode(path))
+    elif event == 'os.remove':
+        if _guarded(args[0]):
+            raise ProtectedFileError('delete', os.fsdecode(args[0]))
+    elif event == 'os.rename':
+        for path in args[:2]:
+            if _guarded(path):
+                raise ProtectedFileError('rename', os.fsdecode(path))
+    elif event in ('os.truncate', 'os.chmod', 'os.chown'):
+        if _guarded(args[0]):
+            raise ProtectedFileError(event.split('.', 1)[1], os.fsdecode(args[0]))
+    elif event == 'shutil.rmtree':
+        root = args[0]
+        if root is None or isinstance(root, int):
+            return
+        root = os.path.realpath(os.fsdecode(root))
+        for path in protected_paths:
+            if path.startswith(root + os.sep):
+                raise ProtectedFileError('delete', path)
+
+sys.addaudithook(protect_uploads_hook)
+
+# Warnings raised while a cell runs are appended to its output in this block, one
+# "Category: message" line each, so the server can show them apart from stdout.
+WARNINGS_OPEN = "<warnings>"
+WARNINGS_CLOSE = "</warnings>"
+
+def format_warnings(caught):
+    """Returns the warnings block for the recorded warnings, or "" when there are none."""
+    lines = []
+    for w in caught:
+        message = ' '.join(str(w.message).split())
+        line = f"{w.category.__name__}: {message}"
+        if line not in lines:
+            lines.append(line)
+    if not lines:
+        return ""
+    return "\n" + WARNINGS_OPEN + "\n" + "\n".join(lines) + "\n" + WARNINGS_CLOSE + "\n"
+
+# Suffix of Plotly figures saved by fig.show(); the server renders them as interactive charts
+PLOTLY_FIGURE_SUFFIX = '.plotly.json'
+
+def _safe_figure_name(name):
+    name = ''.join(c if c.isalnum() or c in '-_' else '_' for c in str(name)).strip('_')
+    return name[:80]
+
+def _next_figure_name():
+    index = 1
+    while os.path.exists(f"figure_{index}{PLOTLY_FIGURE_SUFFIX}"):
+        index += 1
+    return f"figure_{index}"
+
+def _save_plotly_figure(fig, *args, **kwargs):
+    """Replacement for BaseFigure.show: there is no browser in the executor, so the figure
+    is saved to the workspace as Plotly JSON instead, with a PNG copy when kaleido is
+    installed. Both are picked up by the server like any other saved file."""
+    meta = fig.layout.meta
+    name = _safe_figure_name(meta.get('name', '')) if isinstance(meta, dict) else ''
+    if not name:
+        name = _next_figure_name()
+    with open(name + PLOTLY_FIGURE_SUFFIX, 'w', encoding='utf-8') as f:
+        f.write(fig.to_json())
+    try:
+        fig.write_image(name + '.png')
+    except Exception:
+        pass  # No static renderer; the interactive figure still works
+    print(f"Saved interactive figure: {name}{PLOTLY_FIGURE_SUFFIX}")
+
+def capture_plotly_figures(code):
+    """Routes fig.show() to _save_plotly_figure once the session code uses Plotly."""
+    if 'plotly' not in code and 'plotly' not in sys.modules:
+        return
+    try:
+        from plotly.basedatatypes import BaseFigure
+    except ImportError:
+        return
+    BaseFigure.show = _save_plotly_figure
+
 def timeout_handler(signum, frame):
     """Handler to raise an exception when the alarm signal is received."""
     raise TimeoutException("Execution timed out")
@@ -25,41 +160,108 @@ def execute_code(session_id, code, timeout_seconds):
         sessions[session_id] = {}
     
     session_state = sessions[session_id]
-    workspace_dir = os.path.join('/app/workspaces', session_id)
+    workspace_dir = os.path.join(WORKSPACES_ROOT, session_id)
     os.makedirs(workspace_dir, exist_ok=True)
     
     original_dir = os.getcwd()
     os.chdir(workspace_dir)
+    protected_paths.update(load_protected_paths(workspace_dir))
+    capture_plotly_figures(code)
 
-    # Set the signal handler for the alarm signal
-    signal.signal(signal.SIGALRM, timeout_handler)
-    # Set the alarm
-    signal.alarm(timeout_seconds)
+    if HAS_ALARM:
+        # Set the signal handler for the alarm signal
+        signal.signal(signal.SIGALRM, timeout_handler)
+        # Set the alarm
+        signal.alarm(timeout_seconds)
 
     old_stdout = sys.stdout
     redirected_output = sys.stdout = io.StringIO()
+    # Record warnings instead of letting them go to stderr, once per distinct warning
+    catcher = warnings.catch_warnings(record=True)
+    caught = catcher.__enter__()
+    warnings.simplefilter('always')
     try:
         exec(code, session_state)
         output = redirected_output.getvalue()
         # If execution completes successfully, cancel the alarm
-        signal.alarm(0)
-        return output
+        if HAS_ALARM:
+            signal.alarm(0)
+        return output + format_warnings(caught)
     except TimeoutException as e:
-        return f"Error: {str(e)}"
+        return f"Error: {str(e)}" + format_warnings(caught)
     except Exception as e:
-        return f"Error: {type(e).__name__}: {str(e)}"
+        return f"Error: {type(e).__name__}: {str(e)}" + format_warnings(caught)
     finally:
+        catcher.__exit__(None, None, None)
         # Always ensure the alarm is cancelled and stdout is restored
-        signal.alarm(0)
+        if HAS_ALARM:
+            signal.alarm(0)
+        protected_paths.clear()
         sys.stdout = old_stdout
         os.chdir(original_dir)
 
 
+def parse_session_field(field):
+    """Splits the session field into the session ID and the caller's traceparent, if any.
+
+    While tracing, the server sends "<session_id>;<traceparent>" so executor logs can be
+    matched to the agent run's trace.
+    """
+    session_id, _, traceparent = field.partition(';')
+    return session_id, traceparent
+
+
+def session_banner(session_id, traceparent):
+    if traceparent:
+        return f"=== Session {session_id} (trace {traceparent}) ==="
+    return f"=== Session {session_id} ==="
+
+
+def serve_stdio(timeout_seconds):
+    """Serves the executor protocol over stdin/stdout for a local subprocess executor.
+
+    Protocol messages use the real stdout; logs go to stderr so they never mix with results.
+    """
+    reader = io.TextIOWrapper(sys.stdin.buffer, encoding='utf-8', newline='')
+    writer = io.TextIOWrapper(sys.stdout.buffer, encoding='utf-8', newline='')
+    full_message = ""
+    while True:
+        data = reader.read(1)
+        if not data:
+            return
+        full_message += data
+        if not full_message.endswith(EOM_TOKEN):
+            continue
+        message, full_message = full_message[:-len(EOM_TOKEN)], ""
+
+        parts = message.split('|', 1)
+        if len(parts) != 2:
+            result = "Error: Invalid message format. Expected 'session_id|code'."
+        else:
+            session_id, traceparent = parse_session_field(parts[0])
+            code = parts[1]
+            print(session_banner(session_id, traceparent), file=sys.stderr)
+            result = execute_code(session_id, code, timeout_seconds)
+            if not result.strip():
+                result = "Success: Code executed with no output."
+
+        writer.write(result + EOM_TOKEN)
+        writer.flush()
+
+
 def main():
     """Listens for connections and executes code in sandboxed sessions."""
+    global WORKSPACES_ROOT
     parser = argparse.ArgumentParser()
     parser.add_argument("--timeout", type=int, default=60, help="Timeout for code execution in seconds.")
+    parser.add_argument("--workspaces", default=WORKSPACES_ROOT, help="Root directory of session workspaces.")
+    parser.add_argument("--stdio", action="store_true", help="Serve one client over stdin/stdout instead of TCP.")
     args = parser.parse_args()
+    WORKSPACES_ROOT = args.workspaces
+
+    if args.stdio:
+        serve_stdio(args.timeout)
+        return
     
     server_socket = socket.socket(socket.AF_INET, socket.SOCK_STREAM)
     server_socket.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
@@ -88,9 +290,10 @@ def main():
                         conn.sendall(b"Error: Invalid message format. Expected 'session_id|code'.")
                         continue
 
-                    session_id, code = parts
+                    session_id, traceparent = parse_session_field(parts[0])
+                    code = parts[1]
 
-                    print(f"=== Session {session_id} ===")
+                    print(session_banner(session_id, traceparent))
                     print("Executing Python code:")
                     lines = code.split('\n')
                     for i, line in enumerate(lines, 1):
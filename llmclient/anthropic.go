@@ -0,0 +1,247 @@
+package llmclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"stats-agent/config"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+const (
+	anthropicVersion = "2023-06-01"
+	// anthropicDefaultMaxTokens is sent when no per-call cap is set; the API requires one
+	anthropicDefaultMaxTokens = 4096
+)
+
+// ErrEmbeddingsUnsupported is returned by providers without an embeddings API.
+var ErrEmbeddingsUnsupported = errors.New("provider does not support embeddings")
+
+// AnthropicClient speaks the Anthropic Messages API. System messages are joined into
+// the request's system prompt; embeddings are not supported.
+type AnthropicClient struct {
+	cfg        *config.Config
+	httpClient *http.Client
+	logger     *zap.Logger
+	model      string
+	apiKey     string
+}
+
+// NewAnthropic creates an Anthropic Messages API client for model.
+func NewAnthropic(cfg *config.Config, logger *zap.Logger, model, apiKey string) *AnthropicClient {
+	return &AnthropicClient{
+		cfg:        cfg,
+		httpClient: newHTTPClient(cfg.LLMRequestTimeout),
+		logger:     logger,
+		model:      model,
+		apiKey:     apiKey,
+	}
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens,omitempty"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+type anthropicStreamEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+}
+
+// Name returns the provider name.
+func (c *AnthropicClient) Name() string {
+	return config.ProviderAnthropic
+}
+
+// request converts agent messages: system messages become the system prompt, tool output
+// is sent as user turns, and consecutive turns of the same role are merged because the
+// API requires alternating roles starting with the user.
+func (c *AnthropicClient) request(ctx context.Context, messages []types.AgentMessage, temperature *float64, stream bool) anthropicRequest {
+	req := anthropicRequest{
+		Model:       c.model,
+		MaxTokens:   maxTokensFrom(ctx),
+		Temperature: temperature,
+		Stream:      stream,
+	}
+	if req.MaxTokens == 0 {
+		req.MaxTokens = anthropicDefaultMaxTokens
+	}
+
+	var system []string
+	for _, m := range messages {
+		role := m.Role
+		switch role {
+		case "system":
+			system = append(system, m.Content)
+			continue
+		case "assistant":
+		default:
+			role = "user"
+		}
+		if n := len(req.Messages); n > 0 && req.Messages[n-1].Role == role {
+			req.Messages[n-1].Content += "\n\n" + m.Content
+			continue
+		}
+		if len(req.Messages) == 0 && role == "assistant" {
+			req.Messages = append(req.Messages, anthropicMessage{Role: "user", Content: "(conversation continues)"})
+		}
+		req.Messages = append(req.Messages, anthropicMessage{Role: role, Content: m.Content})
+	}
+	req.System = strings.Join(system, "\n\n")
+	return req
+}
+
+func (c *AnthropicClient) post(ctx context.Context, host, path string, body any) (*http.Response, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal anthropic request: %w", err)
+	}
+	return postJSON(ctx, c.cfg, c.httpClient, strings.TrimRight(host, "/")+path, jsonBody, map[string]string{
+		"x-api-key":         c.apiKey,
+		"anthropic-version": anthropicVersion,
+	})
+}
+
+// Chat performs a non-streaming Messages API call.
+func (c *AnthropicClient) Chat(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (string, error) {
+	resp, err := c.post(ctx, host, "/v1/messages", c.request(ctx, messages, temperature, false))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", readError(resp)
+	}
+
+	var ar anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ar); err != nil {
+		return "", fmt.Errorf("decode anthropic response: %w", err)
+	}
+	var text strings.Builder
+	for _, block := range ar.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if text.Len() == 0 {
+		return "", fmt.Errorf("no text content in anthropic response")
+	}
+	return text.String(), nil
+}
+
+// ChatStream performs a streaming Messages API call, with the same fence-aware stop as
+// the llama.cpp client.
+func (c *AnthropicClient) ChatStream(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (<-chan string, error) {
+	body := c.request(ctx, messages, temperature, true)
+	out := make(chan string)
+
+	go func() {
+		defer close(out)
+
+		resp, err := c.post(ctx, host, "/v1/messages", body)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.logger.Error("send anthropic stream request", zap.Error(err))
+			}
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			c.logger.Error("Anthropic API non-200 for stream", zap.Error(readError(resp)))
+			return
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		var fence fenceCutter
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var event anthropicStreamEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				continue
+			}
+			if event.Type == "message_stop" {
+				break
+			}
+			if event.Type != "content_block_delta" || event.Delta.Type != "text_delta" {
+				continue
+			}
+			toEmit, stop := fence.cut(event.Delta.Text)
+			if toEmit != "" {
+				out <- toEmit
+			}
+			if stop {
+				break
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			c.logger.Error("read anthropic stream", zap.Error(err))
+		}
+	}()
+
+	return out, nil
+}
+
+// Tokenize counts tokens with the API's count_tokens endpoint, falling back to an
+// estimate when the call fails.
+func (c *AnthropicClient) Tokenize(ctx context.Context, host string, text string) (int, error) {
+	body := map[string]any{
+		"model":    c.model,
+		"messages": []anthropicMessage{{Role: "user", Content: text}},
+	}
+	resp, err := c.post(ctx, host, "/v1/messages/count_tokens", body)
+	if err != nil {
+		return estimateTokens(text), nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return estimateTokens(text), nil
+	}
+	var counted struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&counted); err != nil {
+		return estimateTokens(text), nil
+	}
+	return counted.InputTokens, nil
+}
+
+// Embed is not supported by the Anthropic API.
+func (c *AnthropicClient) Embed(ctx context.Context, host string, doc string) ([]float32, error) {
+	return nil, ErrEmbeddingsUnsupported
+}
+
+// EmbedBatch is not supported by the Anthropic API.
+func (c *AnthropicClient) EmbedBatch(ctx context.Context, host string, docs []string) ([][]float32, error) {
+	return nil, ErrEmbeddingsUnsupported
+}
@@ -12,7 +12,6 @@ import (
 	"stats-agent/config"
 	"stats-agent/web/types"
 	"strings"
-	"time"
 
 	"go.uber.org/zap"
 )
@@ -23,7 +22,8 @@ var ErrContextWindowExceeded = errors.New("context window exceeded")
 
 type streamChoice struct {
 	Delta struct {
-		Content string `json:"content"`
+		Content   string          `json:"content"`
+		ToolCalls []toolCallDelta `json:"tool_calls"`
 	} `json:"delta"`
 	Index int `json:"index"`
 }
@@ -33,10 +33,30 @@ type streamResponse struct {
 }
 
 type chatRequest struct {
+	Model       string               `json:"model,omitempty"` // Required by hosted OpenAI-compatible APIs
 	Messages    []types.AgentMessage `json:"messages"`
 	Stream      bool                 `json:"stream"`
 	Stop        []string             `json:"stop,omitempty"`        // Stop sequences to halt generation
 	Temperature *float64             `json:"temperature,omitempty"` // Per-request temperature override
+	MaxTokens   int                  `json:"max_tokens,omitempty"`  // Per-request response cap (see WithMaxTokens)
+	// Structured tool calls (see WithTools)
+	Tools          []chatTool      `json:"tools,omitempty"`
+	ToolChoice     string          `json:"tool_choice,omitempty"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+}
+
+type maxTokensKey struct{}
+
+// WithMaxTokens returns a context that caps the response length of chat calls made
+// with it. Carrying the cap on the context keeps the LLM interface and its wrappers
+// unchanged; n <= 0 leaves the server default in place.
+func WithMaxTokens(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, maxTokensKey{}, n)
+}
+
+func maxTokensFrom(ctx context.Context) int {
+	n, _ := ctx.Value(maxTokensKey{}).(int)
+	return max(n, 0)
 }
 
 type chatResponse struct {
@@ -54,10 +74,18 @@ type embeddingResponse []struct {
 	Embedding [][]float32 `json:"embedding"`
 }
 
+// Client speaks the llama.cpp server API: OpenAI-style chat and embeddings plus
+// llama.cpp's /tokenize. Configured through NewOpenAI it is the OpenAI-compatible
+// provider, which sends a model name and bearer token and estimates token counts.
 type Client struct {
     cfg        *config.Config
     httpClient *http.Client
     logger     *zap.Logger
+    name       string
+    model      string
+    apiKey     string
+    // estimateTokens is set for APIs without a /tokenize endpoint
+    estimateTokens bool
 }
 
 func New(cfg *config.Config, logger *zap.Logger) *Client {
@@ -65,8 +93,30 @@ func New(cfg *config.Config, logger *zap.Logger) *Client {
 	// cancellation or server closing the stream.
 	return &Client{
 		cfg:        cfg,
-		httpClient: &http.Client{Timeout: cfg.LLMRequestTimeout},
+		httpClient: newHTTPClient(cfg.LLMRequestTimeout),
 		logger:     logger,
+		name:       config.ProviderLlamaCpp,
+	}
+}
+
+// NewOpenAI creates a client for an OpenAI-compatible API (OpenAI, vLLM, LM Studio, ...).
+func NewOpenAI(cfg *config.Config, logger *zap.Logger, model, apiKey string) *Client {
+	c := New(cfg, logger)
+	c.name = config.ProviderOpenAI
+	c.model = model
+	c.apiKey = apiKey
+	c.estimateTokens = true
+	return c
+}
+
+// Name returns the provider name.
+func (c *Client) Name() string {
+	return c.name
+}
+
+func (c *Client) authorize(req *http.Request) {
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
 	}
 }
 
@@ -74,9 +124,11 @@ func New(cfg *config.Config, logger *zap.Logger) *Client {
 // temperature is optional; pass nil to use server default.
 func (c *Client) Chat(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (string, error) {
 	reqBody := chatRequest{
+		Model:       c.model,
 		Messages:    messages,
 		Stream:      false,
 		Temperature: temperature,
+		MaxTokens:   maxTokensFrom(ctx),
 	}
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
@@ -93,6 +145,7 @@ func (c *Client) Chat(ctx context.Context, host string, messages []types.AgentMe
 			return "", fmt.Errorf("create chat request: %w", err)
 		}
 		req.Header.Set("Content-Type", "application/json")
+		c.authorize(req)
 
 		resp, err = c.httpClient.Do(req)
 		if err != nil {
@@ -125,7 +178,7 @@ func (c *Client) Chat(ctx context.Context, host string, messages []types.AgentMe
 		if strings.Contains(string(bodyBytes), "exceeds the available context size") {
 			return "", ErrContextWindowExceeded
 		}
-		return "", fmt.Errorf("llm server status %s: %s", resp.Status, string(bodyBytes))
+		return "", &StatusError{Server: "llm server", StatusCode: resp.StatusCode, Status: resp.Status, Body: string(bodyBytes)}
 	}
 
 	var cr chatResponse
@@ -139,15 +192,22 @@ func (c *Client) Chat(ctx context.Context, host string, messages []types.AgentMe
 }
 
 // ChatStream performs a streaming chat completion call and returns a channel of chunks.
-// temperature is optional; pass nil to use server default.
+// temperature is optional; pass nil to use server default. With WithTools on ctx, the
+// model's tool calls are collected into the options' ToolCalls before the channel closes.
 func (c *Client) ChatStream(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (<-chan string, error) {
 	// See rationale in Chat(): omit stop sequence to avoid backends removing
 	// Markdown backticks from the output. The agent will still add a missing
 	// closing fence if needed for robustness.
 	reqBody := chatRequest{
+		Model:       c.model,
 		Messages:    messages,
 		Stream:      true,
 		Temperature: temperature,
+		MaxTokens:   maxTokensFrom(ctx),
+	}
+	toolOpts, withTools := toolOptionsFrom(ctx)
+	if withTools {
+		applyTools(&reqBody, toolOpts)
 	}
 	jsonBody, err := json.Marshal(reqBody)
 	if err != nil {
@@ -169,6 +229,7 @@ func (c *Client) ChatStream(ctx context.Context, host string, messages []types.A
 				return
 			}
 			req.Header.Set("Content-Type", "application/json")
+			c.authorize(req)
 			req.Header.Set("Accept", "text/event-stream")
 
 			r, err := c.httpClient.Do(req)
@@ -212,11 +273,11 @@ func (c *Client) ChatStream(ctx context.Context, host string, messages []types.A
 		}
 
 		scanner := bufio.NewScanner(resp.Body)
-		// Fence-aware stop: detect first complete ```python ... ``` block and stop thereafter
-		var window string
-		var opened bool
-		openAbs := -1
-		total := 0
+		var fence fenceCutter
+		var calls toolCallAccumulator
+		// A grammar-mode reply is one JSON object, so it is parsed once complete
+		grammar := withTools && toolOpts.Mode == config.ToolCallModeGrammar
+		var reply strings.Builder
 		for scanner.Scan() {
 			line := scanner.Text()
 			if strings.HasPrefix(line, "data: ") {
@@ -228,43 +289,14 @@ func (c *Client) ChatStream(ctx context.Context, host string, messages []types.A
 				if err := json.Unmarshal([]byte(data), &sr); err == nil {
 					if len(sr.Choices) > 0 {
 						chunk := sr.Choices[0].Delta.Content
-						// Debug log each raw delta chunk as received from the LLM server
-						//c.logger.Debug("llm stream delta", zap.String("delta", chunk))
-
-						// Update detection window/state before emitting
-						total += len(chunk)
-						window += chunk
-						// Cap window to a reasonable size for detection across chunk boundaries
-						if len(window) > 2048 {
-							window = window[len(window)-2048:]
-						}
-						if !opened {
-							if idx := strings.Index(window, "```python"); idx != -1 {
-								opened = true
-								openAbs = total - (len(window) - idx)
-							}
+						if withTools {
+							calls.add(sr.Choices[0].Delta.ToolCalls)
 						}
-
-						// Decide what to emit and whether to stop (on closing fence)
-						toEmit := chunk
-						shouldStop := false
-						if opened {
-							if idx := strings.LastIndex(window, "```"); idx != -1 {
-								closeAbs := total - (len(window) - idx)
-								if closeAbs > openAbs {
-									// If closing fence falls within this chunk, trim to it
-									chunkStartAbs := total - len(chunk)
-									if closeAbs >= chunkStartAbs {
-										cut := closeAbs - chunkStartAbs + 3 // include "```"
-										if cut > 0 && cut <= len(chunk) {
-											toEmit = chunk[:cut]
-										}
-									}
-									shouldStop = true
-								}
-							}
+						if grammar {
+							reply.WriteString(chunk)
+							continue
 						}
-
+						toEmit, shouldStop := fence.cut(chunk)
 						if len(toEmit) > 0 {
 							out <- toEmit
 						}
@@ -278,28 +310,21 @@ func (c *Client) ChatStream(ctx context.Context, host string, messages []types.A
 		if err := scanner.Err(); err != nil {
 			c.logger.Error("read chat stream", zap.Error(err))
 		}
+		if grammar {
+			if content := parseGrammarReply(reply.String(), toolOpts.Calls); content != "" {
+				out <- content
+			}
+		}
+		if withTools {
+			calls.flush(toolOpts.Calls)
+		}
 	}()
 
 	return out, nil
 }
 
 func (c *Client) backoffSleep(attempt int) {
-    // Exponential backoff with configurable jitter and cap
-    base := c.cfg.RetryDelaySeconds
-    if base <= 0 {
-        base = time.Second // config normalization should prevent this
-    }
-    d := base * time.Duration(1<<attempt)
-    maxWait := c.cfg.LLMBackoffMaxSeconds
-    if maxWait > 0 && d > maxWait {
-        d = maxWait
-    }
-    jitterRatio := c.cfg.LLMBackoffJitterRatio
-    if jitterRatio < 0 || jitterRatio > 1 {
-        jitterRatio = 0.1
-    }
-    jitter := time.Duration(float64(d) * jitterRatio)
-    time.Sleep(d - jitter + time.Duration(time.Now().UnixNano()%int64(2*jitter+1)))
+    backoffSleep(c.cfg, attempt)
 }
 
 // Embed generates an embedding vector for the provided document using the
@@ -307,6 +332,7 @@ func (c *Client) backoffSleep(attempt int) {
 func (c *Client) Embed(ctx context.Context, host string, doc string) ([]float32, error) {
     // OpenAI-style request/response
     type reqBody struct {
+        Model string   `json:"model,omitempty"`
         Input []string `json:"input"`
     }
     type respBody struct {
@@ -316,7 +342,7 @@ func (c *Client) Embed(ctx context.Context, host string, doc string) ([]float32,
         } `json:"data"`
     }
 
-    jsonBody, err := json.Marshal(reqBody{Input: []string{doc}})
+    jsonBody, err := json.Marshal(reqBody{Model: c.model, Input: []string{doc}})
     if err != nil {
         return nil, fmt.Errorf("marshal embedding request: %w", err)
     }
@@ -330,6 +356,7 @@ func (c *Client) Embed(ctx context.Context, host string, doc string) ([]float32,
             return nil, fmt.Errorf("create embedding request: %w", err)
         }
         req.Header.Set("Content-Type", "application/json")
+        c.authorize(req)
 
         r, err := c.httpClient.Do(req)
         if err != nil {
@@ -358,7 +385,7 @@ func (c *Client) Embed(ctx context.Context, host string, doc string) ([]float32,
 
     if resp.StatusCode != http.StatusOK {
         bodyBytes, _ := io.ReadAll(resp.Body)
-        return nil, fmt.Errorf("embedding server status %s: %s", resp.Status, strings.TrimSpace(string(bodyBytes)))
+        return nil, &StatusError{Server: "embedding server", StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(bodyBytes))}
     }
 
     var rb respBody
@@ -381,6 +408,7 @@ func (c *Client) EmbedBatch(ctx context.Context, host string, docs []string) ([]
 
     // Preferred: OpenAI-style batch request
     type reqBody struct {
+        Model string   `json:"model,omitempty"`
         Input []string `json:"input"`
     }
     type respBody struct {
@@ -390,7 +418,7 @@ func (c *Client) EmbedBatch(ctx context.Context, host string, docs []string) ([]
         } `json:"data"`
     }
 
-    body, err := json.Marshal(reqBody{Input: docs})
+    body, err := json.Marshal(reqBody{Model: c.model, Input: docs})
     if err != nil {
         return nil, fmt.Errorf("marshal embedding batch request: %w", err)
     }
@@ -405,6 +433,7 @@ func (c *Client) EmbedBatch(ctx context.Context, host string, docs []string) ([]
             return nil, fmt.Errorf("create embedding batch request: %w", err)
         }
         req.Header.Set("Content-Type", "application/json")
+        c.authorize(req)
 
         r, err := c.httpClient.Do(req)
         if err != nil {
@@ -0,0 +1,264 @@
+package llmclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"stats-agent/config"
+	"stats-agent/metrics"
+
+	"go.uber.org/zap"
+)
+
+// breakerState is a host's circuit breaker state; its value is the state's gauge value.
+type breakerState int
+
+const (
+	// breakerClosed: the host serves its calls.
+	breakerClosed breakerState = iota
+	// breakerHalfOpen: the cooldown passed (or a probe succeeded); the next call decides.
+	breakerHalfOpen
+	// breakerOpen: the host failed LLM_BREAKER_FAILURES times in a row; calls go to backups.
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half_open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+// errEmptyStream marks a stream that closed without a chunk while the caller still waited.
+var errEmptyStream = errors.New("stream closed without output")
+
+// healthProbePaths are the health endpoints probed per provider. Hosted APIs (openai,
+// anthropic) are not probed; their breakers follow the calls alone.
+var healthProbePaths = map[string]string{
+	config.ProviderLlamaCpp: "/health",
+	config.ProviderOllama:   "/api/version",
+}
+
+type hostBreaker struct {
+	host     string
+	role     string
+	provider string
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// hostHealth keeps a circuit breaker per configured LLM host and picks the hosts a call may
+// use: the host it was sent to, or while that host's breaker is open, the backups of its
+// role (<ROLE>_LLM_BACKUP_HOSTS) in order. Timeouts, transport errors and 5xx responses
+// count as failures; 4xx responses, context overflow and calls the caller cancelled do not.
+type hostHealth struct {
+	cfg      *config.Config
+	logger   *zap.Logger
+	mu       sync.Mutex
+	breakers map[string]*hostBreaker
+	// backups maps a role's primary host to its backup hosts
+	backups map[string][]string
+}
+
+func newHostHealth(cfg *config.Config, logger *zap.Logger) *hostHealth {
+	return &hostHealth{
+		cfg:      cfg,
+		logger:   logger,
+		breakers: make(map[string]*hostBreaker),
+		backups:  make(map[string][]string),
+	}
+}
+
+// track registers the role's hosts, served by provider.
+func (h *hostHealth) track(role config.LLMRole, provider string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, host := range role.Hosts {
+		key := routeKey(host)
+		if _, ok := h.breakers[key]; ok {
+			continue
+		}
+		h.breakers[key] = &hostBreaker{host: key, role: role.Name, provider: provider}
+		metrics.LLMBreakerState.Set(float64(breakerClosed), key, role.Name)
+	}
+	if len(role.Backups) > 0 && role.Primary != "" {
+		backups := make([]string, 0, len(role.Backups))
+		for _, backup := range role.Backups {
+			backups = append(backups, routeKey(backup))
+		}
+		h.backups[routeKey(role.Primary)] = backups
+	}
+}
+
+// route returns the hosts to try for a call sent to host, in order: host and its backups
+// whose breakers allow calls. When none do, it returns host alone so the call still runs.
+func (h *hostHealth) route(host string) []string {
+	key := routeKey(host)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	candidates := append([]string{key}, h.backups[key]...)
+	hosts := make([]string, 0, len(candidates))
+	for _, candidate := range candidates {
+		if h.allowLocked(candidate) {
+			hosts = append(hosts, candidate)
+		}
+	}
+	if len(hosts) == 0 {
+		return []string{key}
+	}
+	return hosts
+}
+
+// allowLocked reports whether host may be called, half-opening an open breaker whose
+// cooldown has passed. Untracked hosts are always allowed.
+func (h *hostHealth) allowLocked(host string) bool {
+	b, ok := h.breakers[host]
+	if !ok || b.state != breakerOpen {
+		return true
+	}
+	if time.Since(b.openedAt) < h.cfg.LLMBreakerCooldown {
+		return false
+	}
+	h.setStateLocked(b, breakerHalfOpen)
+	return true
+}
+
+// record updates host's breaker with a call's outcome and reports whether the call
+// failed because of the host, in which case the caller may try the next host.
+func (h *hostHealth) record(ctx context.Context, host string, err error) bool {
+	failed := isHostFailure(ctx, err)
+	if err != nil && !failed {
+		// The caller or the request was at fault; the host answered
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b, ok := h.breakers[routeKey(host)]
+	if !ok {
+		return failed
+	}
+	if !failed {
+		b.failures = 0
+		h.setStateLocked(b, breakerClosed)
+		return false
+	}
+	h.failLocked(b, err)
+	return true
+}
+
+func (h *hostHealth) failLocked(b *hostBreaker, err error) {
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= h.cfg.LLMBreakerFailures) {
+		b.openedAt = time.Now()
+		h.setStateLocked(b, breakerOpen)
+		h.logger.Warn("LLM host failing; circuit breaker opened",
+			zap.String("host", b.host),
+			zap.String("role", b.role),
+			zap.Int("consecutive_failures", b.failures),
+			zap.Duration("cooldown", h.cfg.LLMBreakerCooldown),
+			zap.Strings("backups", h.backups[b.host]),
+			zap.Error(err))
+	}
+}
+
+func (h *hostHealth) setStateLocked(b *hostBreaker, state breakerState) {
+	if b.state == state {
+		return
+	}
+	if state == breakerClosed && b.state == breakerHalfOpen {
+		h.logger.Info("LLM host recovered; circuit breaker closed",
+			zap.String("host", b.host), zap.String("role", b.role))
+	}
+	b.state = state
+	metrics.LLMBreakerState.Set(float64(state), b.host, b.role)
+}
+
+// isHostFailure reports whether err means the host is unhealthy: a timeout, transport
+// error, 5xx or unusable response. Cancellation by the caller, context overflow and other
+// 4xx responses are not the host's fault.
+func isHostFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || errors.Is(err, ErrContextWindowExceeded) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
+
+// probe checks the health endpoint of every probed host once. A failed probe counts like a
+// failed call; a healthy probe half-opens an open breaker so the next call can close it
+// (a host can report healthy and still fail completions, so a probe never closes it).
+func (h *hostHealth) probe(ctx context.Context, client *http.Client) {
+	h.mu.Lock()
+	hosts := make([]*hostBreaker, 0, len(h.breakers))
+	for _, b := range h.breakers {
+		if _, ok := healthProbePaths[b.provider]; ok {
+			hosts = append(hosts, b)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, b := range hosts {
+		err := probeHost(ctx, client, b.host+healthProbePaths[b.provider])
+		if ctx.Err() != nil {
+			return
+		}
+		h.mu.Lock()
+		if err != nil {
+			h.failLocked(b, err)
+		} else if b.state == breakerOpen {
+			h.setStateLocked(b, breakerHalfOpen)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func probeHost(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		// llama.cpp answers 503 while the model loads
+		return &StatusError{Server: "health check", StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	return nil
+}
+
+// ProbeHosts checks the health endpoint of each llama.cpp and Ollama host every
+// LLM_HEALTH_PROBE_INTERVAL until ctx is done, so a dead host's breaker opens before calls
+// wait on it and a recovered one is retried without waiting for the cooldown. A zero
+// interval disables probing; breakers then follow the calls alone.
+func (r *Router) ProbeHosts(ctx context.Context) {
+	interval := r.cfg.LLMHealthProbeInterval
+	if interval <= 0 {
+		return
+	}
+	client := newHTTPClient(min(interval, 5*time.Second))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.health.probe(ctx, client)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
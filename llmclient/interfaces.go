@@ -0,0 +1,47 @@
+package llmclient
+
+import (
+	"context"
+
+	"stats-agent/web/types"
+)
+
+// ChatClient sends chat completions to an LLM host.
+type ChatClient interface {
+	Chat(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (string, error)
+	ChatStream(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (<-chan string, error)
+}
+
+// Embedder generates embeddings on an embedding host.
+type Embedder interface {
+	Embed(ctx context.Context, host string, doc string) ([]float32, error)
+	EmbedBatch(ctx context.Context, host string, docs []string) ([][]float32, error)
+}
+
+// TokenCounter counts tokens with a host's tokenizer.
+type TokenCounter interface {
+	Tokenize(ctx context.Context, host string, text string) (int, error)
+}
+
+// LLM is everything the agent and RAG need from the model servers. *Client implements it
+// over HTTP; tests and offline tools can pass fakes or recorded fixtures instead.
+type LLM interface {
+	ChatClient
+	Embedder
+	TokenCounter
+}
+
+// Provider is one backend API behind the LLM interface. Router picks a provider per call
+// from the host, so components keep passing hosts and never see which API serves them.
+type Provider interface {
+	LLM
+	Name() string
+}
+
+var (
+	_ LLM      = (*Client)(nil)
+	_ LLM      = (*Router)(nil)
+	_ Provider = (*Client)(nil)
+	_ Provider = (*AnthropicClient)(nil)
+	_ Provider = (*OllamaClient)(nil)
+)
@@ -0,0 +1,196 @@
+package llmclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"stats-agent/config"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// OllamaClient speaks Ollama's native API (/api/chat, /api/embed). Ollama has no
+// tokenize endpoint, so token counts are estimated.
+type OllamaClient struct {
+	cfg        *config.Config
+	httpClient *http.Client
+	logger     *zap.Logger
+	model      string
+}
+
+// NewOllama creates an Ollama client for model.
+func NewOllama(cfg *config.Config, logger *zap.Logger, model string) *OllamaClient {
+	return &OllamaClient{
+		cfg:        cfg,
+		httpClient: newHTTPClient(cfg.LLMRequestTimeout),
+		logger:     logger,
+		model:      model,
+	}
+}
+
+type ollamaOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+}
+
+type ollamaChatRequest struct {
+	Model    string               `json:"model"`
+	Messages []types.AgentMessage `json:"messages"`
+	Stream   bool                 `json:"stream"`
+	Options  ollamaOptions        `json:"options"`
+}
+
+type ollamaChatResponse struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done  bool   `json:"done"`
+	Error string `json:"error"`
+}
+
+// Name returns the provider name.
+func (c *OllamaClient) Name() string {
+	return config.ProviderOllama
+}
+
+func (c *OllamaClient) post(ctx context.Context, host, path string, body any) (*http.Response, error) {
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal ollama request: %w", err)
+	}
+	return postJSON(ctx, c.cfg, c.httpClient, strings.TrimRight(host, "/")+path, jsonBody, nil)
+}
+
+func (c *OllamaClient) chatRequest(ctx context.Context, messages []types.AgentMessage, temperature *float64, stream bool) ollamaChatRequest {
+	// Ollama only knows system, user, assistant and tool; anything else is user input
+	converted := make([]types.AgentMessage, len(messages))
+	for i, m := range messages {
+		converted[i] = types.AgentMessage{Role: m.Role, Content: m.Content}
+		switch m.Role {
+		case "system", "user", "assistant", "tool":
+		default:
+			converted[i].Role = "user"
+		}
+	}
+	return ollamaChatRequest{
+		Model:    c.model,
+		Messages: converted,
+		Stream:   stream,
+		Options:  ollamaOptions{Temperature: temperature, NumPredict: maxTokensFrom(ctx)},
+	}
+}
+
+// Chat performs a non-streaming chat call.
+func (c *OllamaClient) Chat(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (string, error) {
+	resp, err := c.post(ctx, host, "/api/chat", c.chatRequest(ctx, messages, temperature, false))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", readError(resp)
+	}
+
+	var cr ollamaChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
+		return "", fmt.Errorf("decode ollama chat response: %w", err)
+	}
+	if cr.Error != "" {
+		return "", fmt.Errorf("ollama chat: %s", cr.Error)
+	}
+	return cr.Message.Content, nil
+}
+
+// ChatStream performs a streaming chat call (newline-delimited JSON), with the same
+// fence-aware stop as the llama.cpp client.
+func (c *OllamaClient) ChatStream(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (<-chan string, error) {
+	body := c.chatRequest(ctx, messages, temperature, true)
+	out := make(chan string)
+
+	go func() {
+		defer close(out)
+
+		resp, err := c.post(ctx, host, "/api/chat", body)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.logger.Error("send ollama stream request", zap.Error(err))
+			}
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			c.logger.Error("Ollama non-200 for stream", zap.Error(readError(resp)))
+			return
+		}
+
+		scanner := bufio.NewScanner(resp.Body)
+		var fence fenceCutter
+		for scanner.Scan() {
+			var chunk ollamaChatResponse
+			if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+				continue
+			}
+			if chunk.Error != "" {
+				c.logger.Error("Ollama stream error", zap.String("error", chunk.Error))
+				return
+			}
+			toEmit, stop := fence.cut(chunk.Message.Content)
+			if toEmit != "" {
+				out <- toEmit
+			}
+			if stop || chunk.Done {
+				break
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			c.logger.Error("read ollama stream", zap.Error(err))
+		}
+	}()
+
+	return out, nil
+}
+
+// Embed generates one embedding.
+func (c *OllamaClient) Embed(ctx context.Context, host string, doc string) ([]float32, error) {
+	vecs, err := c.EmbedBatch(ctx, host, []string{doc})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+// EmbedBatch generates embeddings for docs in one /api/embed call.
+func (c *OllamaClient) EmbedBatch(ctx context.Context, host string, docs []string) ([][]float32, error) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	resp, err := c.post(ctx, host, "/api/embed", map[string]any{"model": c.model, "input": docs})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp)
+	}
+
+	var er struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&er); err != nil {
+		return nil, fmt.Errorf("decode ollama embedding response: %w", err)
+	}
+	if len(er.Embeddings) != len(docs) {
+		return nil, fmt.Errorf("ollama returned %d embeddings for %d documents", len(er.Embeddings), len(docs))
+	}
+	return er.Embeddings, nil
+}
+
+// Tokenize estimates the token count; Ollama does not expose its tokenizer.
+func (c *OllamaClient) Tokenize(ctx context.Context, host string, text string) (int, error) {
+	return estimateTokens(text), nil
+}
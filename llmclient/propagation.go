@@ -0,0 +1,28 @@
+package llmclient
+
+import (
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// traceTransport adds the W3C trace context of each request's context to its headers, so
+// LLM servers and gateways that trace join the agent's trace. Until tracing.Setup
+// installs a propagator the global one is a no-op and headers are left alone.
+type traceTransport struct {
+	base http.RoundTripper
+}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	otel.GetTextMapPropagator().Inject(req.Context(), propagation.HeaderCarrier(req.Header))
+	return t.base.RoundTrip(req)
+}
+
+// newHTTPClient returns an HTTP client for LLM calls that propagates trace context.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: traceTransport{base: http.DefaultTransport}}
+}
@@ -0,0 +1,156 @@
+package llmclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"stats-agent/config"
+)
+
+// backoffSleep waits before retry attempt+1: exponential backoff from RETRY_DELAY_SECONDS
+// with LLM_BACKOFF_JITTER_RATIO jitter, capped at LLM_BACKOFF_MAX_SECONDS.
+func backoffSleep(cfg *config.Config, attempt int) {
+	base := cfg.RetryDelaySeconds
+	if base <= 0 {
+		base = time.Second // config normalization should prevent this
+	}
+	d := base * time.Duration(1<<attempt)
+	maxWait := cfg.LLMBackoffMaxSeconds
+	if maxWait > 0 && d > maxWait {
+		d = maxWait
+	}
+	jitterRatio := cfg.LLMBackoffJitterRatio
+	if jitterRatio < 0 || jitterRatio > 1 {
+		jitterRatio = 0.1
+	}
+	jitter := time.Duration(float64(d) * jitterRatio)
+	time.Sleep(d - jitter + time.Duration(time.Now().UnixNano()%int64(2*jitter+1)))
+}
+
+// postJSON sends body to url with the given headers, retrying on transport errors and on
+// 503 (model loading, overloaded) with backoff. The caller closes the response body.
+func postJSON(ctx context.Context, cfg *config.Config, httpClient *http.Client, url string, body []byte, headers map[string]string) (*http.Response, error) {
+	var lastErr error
+	for attempt := 0; attempt < max(cfg.MaxRetries, 1); attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			backoffSleep(cfg, attempt)
+			continue
+		}
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			lastErr = fmt.Errorf("server status %s", resp.Status)
+			backoffSleep(cfg, attempt)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("no response from %s: %w", url, lastErr)
+}
+
+// StatusError is a non-200 response from a model server. A 5xx counts against the host's
+// circuit breaker; a 4xx is the request's fault, not the host's.
+type StatusError struct {
+	Server     string // e.g. "llm server", "embedding server"
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s status %s: %s", e.Server, e.Status, e.Body)
+}
+
+// readError turns a non-200 response into an error, mapping context overflow messages
+// to ErrContextWindowExceeded.
+func readError(resp *http.Response) error {
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	body := strings.TrimSpace(string(bodyBytes))
+	lower := strings.ToLower(body)
+	if strings.Contains(lower, "exceeds the available context size") ||
+		strings.Contains(lower, "prompt is too long") ||
+		strings.Contains(lower, "context_length_exceeded") {
+		return ErrContextWindowExceeded
+	}
+	return &StatusError{Server: "llm server", StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+}
+
+// estimateTokens approximates a token count (about four characters per token) for APIs
+// that do not expose their tokenizer.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// cutBlocks are the blocks the agent executes after a reply; the stream stops once the
+// first of them closes, so the model cannot continue with its own made-up output.
+var cutBlocks = []struct{ open, close string }{
+	{"```python", "```"},
+	{"<sql>", "</sql>"},
+}
+
+// fenceCutter implements the fence-aware stop shared by all streaming providers: once
+// the first ```python or <sql> block closes, the stream is cut after its closing tag.
+type fenceCutter struct {
+	window  string
+	close   string // closing tag of the open block, "" until one opens
+	openEnd int    // stream offset just past the opening tag
+	total   int
+}
+
+// cut returns the part of chunk to emit and whether the stream should stop after it.
+func (f *fenceCutter) cut(chunk string) (string, bool) {
+	// Update detection window/state before emitting
+	f.total += len(chunk)
+	f.window += chunk
+	// Cap window to a reasonable size for detection across chunk boundaries
+	if len(f.window) > 2048 {
+		f.window = f.window[len(f.window)-2048:]
+	}
+	windowStart := f.total - len(f.window)
+	if f.close == "" {
+		first := -1
+		for _, block := range cutBlocks {
+			if idx := strings.Index(f.window, block.open); idx != -1 && (first == -1 || idx < first) {
+				first = idx
+				f.close = block.close
+				f.openEnd = windowStart + idx + len(block.open)
+			}
+		}
+		if f.close == "" {
+			return chunk, false
+		}
+	}
+
+	from := max(f.openEnd-windowStart, 0)
+	idx := strings.Index(f.window[from:], f.close)
+	if idx == -1 {
+		return chunk, false
+	}
+	// If the closing tag ends within this chunk, trim to it
+	closeEnd := windowStart + from + idx + len(f.close)
+	chunkStart := f.total - len(chunk)
+	if cut := closeEnd - chunkStart; cut > 0 && cut <= len(chunk) {
+		return chunk[:cut], true
+	}
+	return chunk, true
+}
@@ -0,0 +1,77 @@
+package llmclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Reranker scores documents against a query with a cross-encoder (bge-reranker style).
+type Reranker interface {
+	// Rerank returns one relevance score per document, in the order given. Higher is
+	// more relevant; scores are only comparable within one call.
+	Rerank(ctx context.Context, host, model, query string, documents []string) ([]float64, error)
+}
+
+var (
+	_ Reranker = (*Client)(nil)
+	_ Reranker = (*Router)(nil)
+)
+
+type rerankRequest struct {
+	Model     string   `json:"model,omitempty"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopN      int      `json:"top_n"`
+}
+
+type rerankResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+// Rerank calls the /v1/rerank endpoint of a llama.cpp server started with --reranking.
+func (c *Client) Rerank(ctx context.Context, host, model, query string, documents []string) ([]float64, error) {
+	if len(documents) == 0 {
+		return nil, nil
+	}
+	jsonBody, err := json.Marshal(rerankRequest{Model: model, Query: query, Documents: documents, TopN: len(documents)})
+	if err != nil {
+		return nil, fmt.Errorf("marshal rerank request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/rerank", strings.TrimRight(host, "/"))
+	resp, err := postJSON(ctx, c.cfg, c.httpClient, url, jsonBody, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Server: "reranker", StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(bodyBytes))}
+	}
+
+	var rr rerankResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
+		return nil, fmt.Errorf("decode rerank response: %w", err)
+	}
+	if len(rr.Results) != len(documents) {
+		return nil, fmt.Errorf("reranker returned %d scores for %d documents", len(rr.Results), len(documents))
+	}
+	scores := make([]float64, len(documents))
+	seen := make([]bool, len(documents))
+	for _, result := range rr.Results {
+		if result.Index < 0 || result.Index >= len(documents) || seen[result.Index] {
+			return nil, fmt.Errorf("reranker returned an invalid document index %d", result.Index)
+		}
+		seen[result.Index] = true
+		scores[result.Index] = result.RelevanceScore
+	}
+	return scores, nil
+}
@@ -0,0 +1,197 @@
+package llmclient
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"stats-agent/config"
+	"stats-agent/metrics"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// Router implements LLM over the providers configured per role (MAIN_LLM_PROVIDER,
+// SUMMARIZATION_LLM_PROVIDER, EMBEDDING_LLM_PROVIDER). Each call goes to the provider of
+// the role that serves its host; unknown hosts use the llama.cpp client. A circuit breaker
+// per host (health.go) sends calls to the role's backup hosts while the host is failing.
+// Reranking always goes to the llama.cpp client, behind RERANK_HOST's own breaker.
+type Router struct {
+	cfg      *config.Config
+	byHost   map[string]Provider
+	fallback Provider
+	reranker Reranker
+	health   *hostHealth
+	logger   *zap.Logger
+}
+
+// NewRouter builds one provider per role and maps the role's hosts to it. Config
+// validation guarantees roles sharing a host agree on its provider.
+func NewRouter(cfg *config.Config, logger *zap.Logger) *Router {
+	llamaCpp := New(cfg, logger)
+	r := &Router{
+		cfg:      cfg,
+		byHost:   make(map[string]Provider),
+		fallback: llamaCpp,
+		reranker: llamaCpp,
+		health:   newHostHealth(cfg, logger),
+		logger:   logger,
+	}
+	for _, role := range cfg.LLMRoles() {
+		provider := newProvider(cfg, logger, role, r.fallback)
+		for _, host := range role.Hosts {
+			r.byHost[routeKey(host)] = provider
+		}
+		r.health.track(role, provider.Name())
+		logger.Info("LLM role configured",
+			zap.String("role", role.Name),
+			zap.String("provider", provider.Name()),
+			zap.String("model", role.Model),
+			zap.Strings("hosts", role.Hosts),
+			zap.Strings("backup_hosts", role.Backups))
+	}
+	if cfg.RerankHost != "" {
+		r.health.track(config.LLMRole{Name: "rerank", Hosts: []string{cfg.RerankHost}}, config.ProviderLlamaCpp)
+	}
+	return r
+}
+
+func newProvider(cfg *config.Config, logger *zap.Logger, role config.LLMRole, llamaCpp Provider) Provider {
+	switch role.Provider {
+	case config.ProviderOpenAI:
+		return NewOpenAI(cfg, logger, role.Model, role.APIKey)
+	case config.ProviderAnthropic:
+		return NewAnthropic(cfg, logger, role.Model, role.APIKey)
+	case config.ProviderOllama:
+		return NewOllama(cfg, logger, role.Model)
+	}
+	return llamaCpp
+}
+
+func routeKey(host string) string {
+	return strings.TrimRight(strings.TrimSpace(host), "/")
+}
+
+// Provider returns the provider serving host.
+func (r *Router) Provider(host string) Provider {
+	if p, ok := r.byHost[routeKey(host)]; ok {
+		return p
+	}
+	return r.fallback
+}
+
+func (r *Router) Chat(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (string, error) {
+	var response string
+	err := r.withFailover(ctx, host, "chat", func(host string) error {
+		var err error
+		response, err = r.Provider(host).Chat(ctx, host, messages, temperature)
+		return err
+	})
+	return response, err
+}
+
+// ChatStream cannot move to another host once chunks flow, so it only starts on a host
+// whose breaker allows calls. A stream that closes without output counts as a failure
+// unless tool calls were requested (a reply can be all tool calls).
+func (r *Router) ChatStream(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (<-chan string, error) {
+	served := r.health.route(host)[0]
+	r.countFailover(host, served)
+	start := time.Now()
+	in, err := r.Provider(served).ChatStream(ctx, served, messages, temperature)
+	if err != nil {
+		observe(served, "chat_stream", start, err)
+		r.health.record(ctx, served, err)
+		return nil, err
+	}
+	_, withTools := toolOptionsFrom(ctx)
+
+	// Forward the stream so the call is timed until it closes; consumers always drain it
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		received := false
+		for chunk := range in {
+			received = true
+			out <- chunk
+		}
+		streamErr := ctx.Err()
+		if streamErr == nil && !received && !withTools {
+			streamErr = errEmptyStream
+		}
+		observe(served, "chat_stream", start, streamErr)
+		r.health.record(ctx, served, streamErr)
+	}()
+	return out, nil
+}
+
+func (r *Router) Embed(ctx context.Context, host string, doc string) ([]float32, error) {
+	var embedding []float32
+	err := r.withFailover(ctx, host, "embed", func(host string) error {
+		var err error
+		embedding, err = r.Provider(host).Embed(ctx, host, doc)
+		return err
+	})
+	return embedding, err
+}
+
+func (r *Router) EmbedBatch(ctx context.Context, host string, docs []string) ([][]float32, error) {
+	var embeddings [][]float32
+	err := r.withFailover(ctx, host, "embed_batch", func(host string) error {
+		var err error
+		embeddings, err = r.Provider(host).EmbedBatch(ctx, host, docs)
+		return err
+	})
+	return embeddings, err
+}
+
+func (r *Router) Tokenize(ctx context.Context, host string, text string) (int, error) {
+	var tokens int
+	err := r.withFailover(ctx, host, "tokenize", func(host string) error {
+		var err error
+		tokens, err = r.Provider(host).Tokenize(ctx, host, text)
+		return err
+	})
+	return tokens, err
+}
+
+// Rerank scores documents on the reranker at host.
+func (r *Router) Rerank(ctx context.Context, host, model, query string, documents []string) ([]float64, error) {
+	var scores []float64
+	err := r.withFailover(ctx, host, "rerank", func(host string) error {
+		var err error
+		scores, err = r.reranker.Rerank(ctx, host, model, query, documents)
+		return err
+	})
+	return scores, err
+}
+
+// withFailover runs call on the hosts the breakers allow for host, in order, until one
+// succeeds or fails for a reason other than the host (see isHostFailure). Each attempt is
+// observed under the host that served it. Returns the last attempt's error.
+func (r *Router) withFailover(ctx context.Context, host, operation string, call func(host string) error) error {
+	var err error
+	for _, served := range r.health.route(host) {
+		r.countFailover(host, served)
+		start := time.Now()
+		err = call(served)
+		observe(served, operation, start, err)
+		if !r.health.record(ctx, served, err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// countFailover counts a call sent to host but served by another host.
+func (r *Router) countFailover(host, served string) {
+	if key := routeKey(host); served != key {
+		metrics.LLMFailovers.Inc(key, served)
+		r.logger.Debug("LLM call failed over to backup host", zap.String("host", key), zap.String("backup", served))
+	}
+}
+
+// observe records an LLM call's latency under its host.
+func observe(host, operation string, start time.Time, err error) {
+	metrics.LLMRequestDuration.ObserveSince(start, routeKey(host), operation, metrics.Outcome(err))
+}
@@ -21,7 +21,11 @@ type TokenizeResponse struct {
 }
 
 // Tokenize requests tokenization for text at the given host and returns the token count.
+// APIs without a /tokenize endpoint get an estimate instead.
 func (c *Client) Tokenize(ctx context.Context, host string, text string) (int, error) {
+    if c.estimateTokens {
+        return estimateTokens(text), nil
+    }
     reqBody := TokenizeRequest{Content: text}
     jsonBody, err := json.Marshal(reqBody)
     if err != nil {
@@ -37,6 +41,7 @@ func (c *Client) Tokenize(ctx context.Context, host string, text string) (int, e
             return 0, fmt.Errorf("create tokenize request: %w", err)
         }
         req.Header.Set("Content-Type", "application/json")
+        c.authorize(req)
 
         r, err := c.httpClient.Do(req)
         if err != nil {
@@ -65,7 +70,7 @@ func (c *Client) Tokenize(ctx context.Context, host string, text string) (int, e
 
     if resp.StatusCode != http.StatusOK {
         bodyBytes, _ := io.ReadAll(resp.Body)
-        return 0, fmt.Errorf("tokenize server status %s: %s", resp.Status, strings.TrimSpace(string(bodyBytes)))
+        return 0, &StatusError{Server: "tokenize server", StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(bodyBytes))}
     }
 
     var tr TokenizeResponse
@@ -0,0 +1,219 @@
+package llmclient
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"stats-agent/config"
+	"stats-agent/web/types"
+)
+
+// ToolSpec describes a function the model may call. Parameters is its JSON schema.
+type ToolSpec struct {
+	Name        string
+	Description string
+	Parameters  map[string]any
+}
+
+// ToolCall is a function call returned by the model, with its arguments as JSON.
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments json.RawMessage
+}
+
+// ToolCalls collects the calls of a streamed response. The stream fills it before its
+// channel closes, so callers read it after draining the channel.
+type ToolCalls struct {
+	mu    sync.Mutex
+	calls []ToolCall
+}
+
+// Calls returns the collected calls in the order the model made them.
+func (t *ToolCalls) Calls() []ToolCall {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ToolCall(nil), t.calls...)
+}
+
+func (t *ToolCalls) add(call ToolCall) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls = append(t.calls, call)
+}
+
+// ToolOptions turns on structured tool calls for a streaming chat call (see WithTools).
+type ToolOptions struct {
+	Mode  string // config.ToolCallModeTools or config.ToolCallModeGrammar
+	Tools []ToolSpec
+	Calls *ToolCalls // receives the calls the model made
+}
+
+type toolOptionsKey struct{}
+
+// WithTools returns a context whose ChatStream calls offer opts.Tools to the model. Like
+// WithMaxTokens, it keeps the LLM interface unchanged. The llama.cpp and OpenAI-compatible
+// clients honour it; other providers ignore it and answer in text, so callers keep
+// parsing the text when no call comes back.
+func WithTools(ctx context.Context, opts ToolOptions) context.Context {
+	return context.WithValue(ctx, toolOptionsKey{}, opts)
+}
+
+func toolOptionsFrom(ctx context.Context) (ToolOptions, bool) {
+	opts, ok := ctx.Value(toolOptionsKey{}).(ToolOptions)
+	if !ok || len(opts.Tools) == 0 || opts.Calls == nil {
+		return ToolOptions{}, false
+	}
+	switch opts.Mode {
+	case config.ToolCallModeTools, config.ToolCallModeGrammar:
+		return opts, true
+	}
+	return ToolOptions{}, false
+}
+
+type chatTool struct {
+	Type     string       `json:"type"`
+	Function chatFunction `json:"function"`
+}
+
+type chatFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+type responseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema struct {
+		Name   string         `json:"name"`
+		Schema map[string]any `json:"schema"`
+	} `json:"json_schema"`
+}
+
+// toolCallDelta is one fragment of a streamed OpenAI tool call; the name arrives first and
+// the arguments in pieces, all under the call's index.
+type toolCallDelta struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// applyTools adds the tool options to a chat request. Tools mode sends the OpenAI tools
+// schema. Grammar mode constrains the whole reply to the JSON object of grammarSchema,
+// which llama.cpp enforces with a grammar.
+//
+// Stored history keeps tool results as plain "tool" messages without call IDs, which
+// function-calling templates reject, so they are replayed as user turns.
+func applyTools(req *chatRequest, opts ToolOptions) {
+	messages := make([]types.AgentMessage, len(req.Messages))
+	for i, m := range req.Messages {
+		if m.Role == "tool" {
+			m = types.AgentMessage{Role: "user", Content: "Tool output:\n" + m.Content}
+		}
+		messages[i] = m
+	}
+	req.Messages = messages
+
+	if opts.Mode == config.ToolCallModeGrammar {
+		format := &responseFormat{Type: "json_schema"}
+		format.JSONSchema.Name = "agent_turn"
+		format.JSONSchema.Schema = grammarSchema(opts.Tools)
+		req.ResponseFormat = format
+		return
+	}
+	for _, tool := range opts.Tools {
+		req.Tools = append(req.Tools, chatTool{
+			Type:     "function",
+			Function: chatFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
+	}
+	req.ToolChoice = "auto"
+}
+
+// grammarSchema is the reply schema of grammar mode: the text of the turn, and either no
+// call or one call to a known tool with its arguments.
+func grammarSchema(tools []ToolSpec) map[string]any {
+	calls := []any{map[string]any{"type": "null"}}
+	for _, tool := range tools {
+		calls = append(calls, map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"name":      map[string]any{"const": tool.Name},
+				"arguments": tool.Parameters,
+			},
+			"required": []string{"name", "arguments"},
+		})
+	}
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"content":   map[string]any{"type": "string"},
+			"tool_call": map[string]any{"anyOf": calls},
+		},
+		"required": []string{"content", "tool_call"},
+	}
+}
+
+// toolCallAccumulator joins streamed tool call fragments by index.
+type toolCallAccumulator struct {
+	calls []ToolCall
+	args  []strings.Builder
+}
+
+func (a *toolCallAccumulator) add(deltas []toolCallDelta) {
+	for _, d := range deltas {
+		if d.Index < 0 {
+			continue
+		}
+		for len(a.calls) <= d.Index {
+			a.calls = append(a.calls, ToolCall{})
+			a.args = append(a.args, strings.Builder{})
+		}
+		if d.ID != "" {
+			a.calls[d.Index].ID = d.ID
+		}
+		if d.Function.Name != "" {
+			a.calls[d.Index].Name = d.Function.Name
+		}
+		a.args[d.Index].WriteString(d.Function.Arguments)
+	}
+}
+
+// flush hands the completed calls to sink, skipping fragments that never got a name.
+func (a *toolCallAccumulator) flush(sink *ToolCalls) {
+	for i, call := range a.calls {
+		if call.Name == "" {
+			continue
+		}
+		args := strings.TrimSpace(a.args[i].String())
+		if args == "" {
+			args = "{}"
+		}
+		call.Arguments = json.RawMessage(args)
+		sink.add(call)
+	}
+}
+
+// parseGrammarReply splits a grammar-mode reply into its text and call. A reply that is
+// not the expected object (a server that ignored the schema) is returned as text.
+func parseGrammarReply(reply string, sink *ToolCalls) string {
+	var parsed struct {
+		Content  string `json:"content"`
+		ToolCall *struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		} `json:"tool_call"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(reply)), &parsed); err != nil {
+		return reply
+	}
+	if parsed.ToolCall != nil && parsed.ToolCall.Name != "" {
+		sink.add(ToolCall{Name: parsed.ToolCall.Name, Arguments: parsed.ToolCall.Arguments})
+	}
+	return parsed.Content
+}
@@ -0,0 +1,333 @@
+// Package metrics keeps in-process counters, gauges and histograms of the agent's
+// internals and serves them in the Prometheus text exposition format. Series are recorded
+// whether or not METRICS_ENABLED is set; the flag only controls the /metrics endpoint.
+// Labels are limited to values from configuration (hosts, modes, transports) and fixed
+// outcomes, never session IDs, so the number of series stays small.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outcome label values.
+const (
+	OutcomeOK    = "ok"
+	OutcomeError = "error"
+)
+
+// Outcome returns the outcome label for err.
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeOK
+}
+
+var (
+	// LatencyBuckets are histogram bounds in seconds, from fast embedding calls to long
+	// generations and analyses.
+	LatencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+	// SizeBuckets are histogram bounds for item counts such as embedding batch sizes.
+	SizeBuckets = []float64{1, 2, 4, 8, 16, 32, 64, 128, 256}
+)
+
+// The series instrumented across the agent, RAG pipeline, executor and LLM client.
+var (
+	LLMRequestDuration = NewHistogramVec("stats_agent_llm_request_duration_seconds",
+		"Duration of LLM calls per host; streaming calls are timed until the stream closes.",
+		LatencyBuckets, "host", "operation", "outcome")
+	LLMBreakerState = NewGaugeVec("stats_agent_llm_host_breaker_state",
+		"Circuit breaker state per LLM host: 0 closed, 1 half-open (trial calls), 2 open (calls go to backups).",
+		"host", "role")
+	LLMFailovers = NewCounterVec("stats_agent_llm_failovers_total",
+		"Calls served by a backup host because the host they were sent to was failing.",
+		"host", "backup")
+	RAGQueryDuration = NewHistogramVec("stats_agent_rag_query_duration_seconds",
+		"Duration of RAG memory queries, including the metadata fallback.",
+		LatencyBuckets, "mode", "outcome")
+	EmbeddingBatchSize = NewHistogramVec("stats_agent_embedding_batch_size",
+		"Documents per embedding batch sent to an embedding host.",
+		SizeBuckets, "host")
+	PythonExecutionDuration = NewHistogramVec("stats_agent_python_execution_duration_seconds",
+		"Duration of Python executor calls; outcome is error when the executor itself failed.",
+		LatencyBuckets, "outcome")
+	StreamConnections = NewGaugeVec("stats_agent_stream_connections",
+		"Open response streams by transport.",
+		"transport")
+	ActionCacheLookups = NewCounterVec("stats_agent_action_cache_lookups_total",
+		"Action cache lookups by result; the hit rate is hit / (hit + miss).",
+		"result")
+	SummaryCacheLookups = NewCounterVec("stats_agent_summary_cache_lookups_total",
+		"Summary cache lookups by summary kind and result; a hit skips the summarization LLM call.",
+		"kind", "result")
+	DBMaintenanceDuration = NewHistogramVec("stats_agent_db_maintenance_duration_seconds",
+		"Duration of scheduled database maintenance tasks (analyze, vacuum, reindex).",
+		LatencyBuckets, "task")
+)
+
+// collector is one registered metric family.
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// family holds the series of one metric, keyed by their label values.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string][]string // key -> label values
+}
+
+func newFamily(name, help, kind string, labels []string) family {
+	return family{name: name, help: help, kind: kind, labels: labels, series: make(map[string][]string)}
+}
+
+// key checks the label values and returns the series key. Callers hold f.mu.
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	if _, ok := f.series[key]; !ok {
+		f.series[key] = append([]string(nil), values...)
+	}
+	return key
+}
+
+// sortedKeys returns the series keys in label order, so scrapes are stable. Callers hold f.mu.
+func (f *family) sortedKeys() []string {
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *family) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+}
+
+// labelString formats label pairs, with extra appended (for histogram "le").
+func (f *family) labelString(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range f.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + `="` + escapeLabel(values[i]) + `"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra[i] + `="` + escapeLabel(extra[i+1]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// CounterVec is a counter per label combination.
+type CounterVec struct {
+	family
+	values map[string]float64
+}
+
+// NewCounterVec creates and registers a counter.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{family: newFamily(name, help, "counter", labels), values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the series.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative, to the series.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(labelValues)] += delta
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(c.series[key]), formatFloat(c.values[key]))
+	}
+}
+
+// GaugeVec is a gauge per label combination.
+type GaugeVec struct {
+	family
+	values map[string]float64
+}
+
+// NewGaugeVec creates and registers a gauge.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{family: newFamily(name, help, "gauge", labels), values: make(map[string]float64)}
+	register(g)
+	return g
+}
+
+// Inc adds one to the series.
+func (g *GaugeVec) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec subtracts one from the series.
+func (g *GaugeVec) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Add adds delta to the series.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(labelValues)] += delta
+}
+
+// Set sets the series to value.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(labelValues)] = value
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w)
+	for _, key := range g.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(g.series[key]), formatFloat(g.values[key]))
+	}
+}
+
+// HistogramVec is a histogram per label combination.
+type HistogramVec struct {
+	family
+	buckets []float64
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates and registers a histogram with the given upper bucket bounds,
+// in increasing order. The +Inf bucket is implicit.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{family: newFamily(name, help, "histogram", labels), buckets: buckets, values: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+// Observe records one value in the series.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(labelValues)
+	hist := h.values[key]
+	if hist == nil {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.count++
+	hist.sum += value
+}
+
+// ObserveSince records the seconds elapsed since start.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, key := range h.sortedKeys() {
+		values := h.series[key]
+		hist := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(values), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(values), hist.count)
+	}
+}
+
+// WriteText writes every registered metric in the Prometheus text exposition format.
+func WriteText(w io.Writer) error {
+	registryMu.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registered metrics to a Prometheus scrape.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = WriteText(w)
+	})
+}
@@ -0,0 +1,178 @@
+package pdf
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MinPageChars is the text a page needs to count as extracted. Scanned pages usually come
+// back empty or with a few stray characters.
+const MinPageChars = 100
+
+// cidPattern matches pdfminer's placeholders for glyphs it could not map to text.
+var cidPattern = regexp.MustCompile(`\(cid:\d+\)`)
+
+// Quality scores how well text was extracted from a PDF.
+type Quality struct {
+	// Score combines the measures below, from 0 (unusable) to 1.
+	Score float64
+	// MedianPageChars is the median number of non-space characters per page.
+	MedianPageChars int
+	// TextPageRatio is the share of pages with at least MinPageChars characters.
+	TextPageRatio float64
+	// WordRatio is the share of letters in tokens shaped like dictionary words. Glued
+	// words ("theresultsshowthat") and letter-spaced text ("t h e") both lower it.
+	WordRatio float64
+	// GarbledRatio is the share of characters that are replacement characters, private
+	// use or control characters, or (cid:N) placeholders.
+	GarbledRatio float64
+	// NeedsOCR is set when most pages have no text layer.
+	NeedsOCR bool
+}
+
+// ScorePages scores the extraction of a whole document.
+func ScorePages(pages []Page) Quality {
+	if len(pages) == 0 {
+		return Quality{NeedsOCR: true}
+	}
+
+	var stats textStats
+	pageChars := make([]int, 0, len(pages))
+	textPages := 0
+	for _, p := range pages {
+		s := measureText(p.Text)
+		stats.add(s)
+		pageChars = append(pageChars, s.chars)
+		if s.chars >= MinPageChars {
+			textPages++
+		}
+	}
+	sort.Ints(pageChars)
+
+	q := stats.quality()
+	q.MedianPageChars = pageChars[len(pageChars)/2]
+	q.TextPageRatio = float64(textPages) / float64(len(pages))
+	q.NeedsOCR = q.TextPageRatio < 0.5
+	q.Score *= q.TextPageRatio
+	return q
+}
+
+// ScorePage scores the extraction of a single page. Unlike ScorePages, short pages are
+// not penalized: a title page or a figure caption can be short and still clean.
+func ScorePage(text string) Quality {
+	s := measureText(text)
+	q := s.quality()
+	q.MedianPageChars = s.chars
+	if s.chars >= MinPageChars {
+		q.TextPageRatio = 1
+	}
+	return q
+}
+
+// textStats are raw counts behind a Quality.
+type textStats struct {
+	chars       int
+	garbled     int
+	letters     int // Letters in tokens
+	wordLetters int // Letters in word-shaped tokens
+}
+
+func (s *textStats) add(o textStats) {
+	s.chars += o.chars
+	s.garbled += o.garbled
+	s.letters += o.letters
+	s.wordLetters += o.wordLetters
+}
+
+func (s textStats) quality() Quality {
+	var q Quality
+	if s.chars == 0 || s.letters == 0 {
+		return q
+	}
+	q.WordRatio = float64(s.wordLetters) / float64(s.letters)
+	q.GarbledRatio = float64(s.garbled) / float64(s.chars)
+
+	// Clean prose scores above 0.9 (abbreviations and symbols make up the rest);
+	// 5% garbled characters is already unreadable.
+	wordScore := clamp01(q.WordRatio / 0.8)
+	garbledScore := clamp01(1 - q.GarbledRatio*20)
+	q.Score = wordScore * garbledScore
+	return q
+}
+
+func measureText(text string) textStats {
+	var s textStats
+	for _, m := range cidPattern.FindAllString(text, -1) {
+		s.garbled += len(m)
+	}
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		s.chars++
+		if r == unicode.ReplacementChar || unicode.Is(unicode.Co, r) || unicode.IsControl(r) {
+			s.garbled++
+		}
+	}
+
+	for _, token := range strings.Fields(cidPattern.ReplaceAllString(text, " ")) {
+		token = strings.TrimFunc(token, func(r rune) bool { return !unicode.IsLetter(r) })
+		if token == "" {
+			continue // Numbers and punctuation say nothing about extraction quality
+		}
+		n := utf8.RuneCountInString(token)
+		s.letters += n
+		if wordShaped(token) {
+			s.wordLetters += n
+		}
+	}
+	return s
+}
+
+// wordShaped reports whether token looks like a dictionary word: 2-20 letters (a single
+// "a"/"I" is allowed), at most one case change after the first letter, and a vowel
+// when written in Latin script.
+func wordShaped(token string) bool {
+	runes := []rune(token)
+	if len(runes) == 1 {
+		return runes[0] == 'a' || runes[0] == 'A' || runes[0] == 'I'
+	}
+	if len(runes) > 20 {
+		return false
+	}
+
+	latin, vowel := false, false
+	caseChanges := 0
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && r != '-' && r != '\'' {
+			return false
+		}
+		if unicode.Is(unicode.Latin, r) {
+			latin = true
+			if strings.ContainsRune("aeiouyAEIOUYàáâäèéêëìíîïòóôöùúûüåæøœ", r) {
+				vowel = true
+			}
+		}
+		if i > 1 && unicode.IsUpper(r) != unicode.IsUpper(runes[i-1]) {
+			caseChanges++
+		}
+	}
+	if latin && !vowel {
+		return false
+	}
+	// "Results" and "ANOVA" have none, "pValue" one; "tHeReSuLt" is noise
+	return caseChanges <= 1
+}
+
+func clamp01(x float64) float64 {
+	if x < 0 {
+		return 0
+	}
+	if x > 1 {
+		return 1
+	}
+	return x
+}
@@ -22,14 +22,73 @@ var pdfKeyFacts string
 //go:embed title_generator.txt
 var titleGenerator string
 
+//go:embed results_title.txt
+var resultsTitle string
+
 //go:embed document_qa.txt
 var documentQA string
 
+//go:embed dataset_comparison.txt
+var datasetComparison string
+
+//go:embed finish_summary.txt
+var finishSummary string
+
+//go:embed verbosity_terse.txt
+var verbosityTerse string
+
+//go:embed verbosity_teaching.txt
+var verbosityTeaching string
+
+//go:embed figure_alt_text.txt
+var figureAltText string
+
+//go:embed followup_suggestions.txt
+var followUpSuggestions string
+
+//go:embed session_abstract.txt
+var sessionAbstract string
+
+//go:embed document_more_context.txt
+var documentMoreContext string
+
+//go:embed interactive_plots.txt
+var interactivePlots string
+
+//go:embed condense_response.txt
+var condenseResponse string
+
+//go:embed continue_response.txt
+var continueResponse string
+
+//go:embed sql_tool.txt
+var sqlTool string
+
+//go:embed tool_calls.txt
+var toolCalls string
+
+//go:embed analysis_plan.txt
+var analysisPlan string
+
 func AgentSystem() string         { return agentSystem }
 func SummarizeMemory() string     { return summarizeMemory }
 func FactSummary() string         { return factSummary }
 func SearchableSummary() string   { return searchableSummary }
 func PDFKeyFacts() string         { return pdfKeyFacts }
 func TitleGenerator() string      { return titleGenerator }
+func ResultsTitle() string        { return resultsTitle }
 func DocumentQA() string          { return documentQA }
-
+func DatasetComparison() string   { return datasetComparison }
+func FinishSummary() string       { return finishSummary }
+func VerbosityTerse() string      { return verbosityTerse }
+func VerbosityTeaching() string   { return verbosityTeaching }
+func FigureAltText() string       { return figureAltText }
+func FollowUpSuggestions() string { return followUpSuggestions }
+func SessionAbstract() string     { return sessionAbstract }
+func DocumentMoreContext() string { return documentMoreContext }
+func InteractivePlots() string    { return interactivePlots }
+func CondenseResponse() string    { return condenseResponse }
+func ContinueResponse() string    { return continueResponse }
+func SQLTool() string             { return sqlTool }
+func ToolCalls() string           { return toolCalls }
+func AnalysisPlan() string        { return analysisPlan }
@@ -7,6 +7,7 @@ import (
     "regexp"
     "strconv"
     "strings"
+    "sync"
     "time"
 
 	"stats-agent/web/format"
@@ -17,23 +18,39 @@ import (
 )
 
 func (r *RAG) AddMessagesToStore(ctx context.Context, sessionID string, messages []types.AgentMessage) error {
-	processedIndices := make(map[int]bool)
+	return r.withIngestLock(ctx, sessionID, func(ctx context.Context) error {
+		return r.addMessagesToStore(ctx, sessionID, messages)
+	})
+}
 
-	for i := range messages {
-		if processedIndices[i] {
-			continue
-		}
+func (r *RAG) addMessagesToStore(ctx context.Context, sessionID string, messages []types.AgentMessage) error {
+	// Plan in message order: pairing, the ingestion policy, metadata and hash dedup are
+	// cheap and depend on earlier messages
+	plans := r.planDocuments(ctx, sessionID, messages)
 
-		docData, skip, err := r.prepareDocumentForMessage(ctx, sessionID, messages, i, processedIndices)
-		if err != nil {
-			r.logger.Warn("Failed to prepare RAG document", zap.Error(err))
-			continue
-		}
+	// Summarizer calls dominate ingestion latency and are independent per message
+	r.generatePlanSummaries(ctx, plans)
+
+	// Finish and persist in message order so near-duplicate checks see earlier messages
+	newFacts := false
+	for _, plan := range plans {
+		docData, skip := r.finishDocument(ctx, sessionID, plan)
 		if skip || docData == nil {
 			continue
 		}
-
 		r.persistPreparedDocument(ctx, docData)
+		if plan.code != "" {
+			newFacts = true
+		}
+	}
+
+	// Screening loops: fold per-variable results of the same test into one rollup
+	if newFacts && r.cfg.ScreeningRollupMinTests > 0 {
+		if _, err := r.RollupScreeningFacts(ctx, sessionID); err != nil {
+			r.logger.Warn("Failed to roll up screening facts, continuing",
+				zap.Error(err),
+				zap.String("session_id", sessionID))
+		}
 	}
 
 	return nil
@@ -76,31 +93,96 @@ func (r *RAG) ensureDatasetMetadata(sessionID string, metadata map[string]string
 	return ""
 }
 
-func (r *RAG) prepareDocumentForMessage(
+// documentPlan is one message (or assistant+tool pair) accepted for ingestion, with the
+// inputs and outputs of its summarizer calls.
+type documentPlan struct {
+	message        types.AgentMessage
+	documentID     uuid.UUID
+	metadata       map[string]string
+	storedContent  string
+	contentToEmbed string
+	contentHash    string
+	// duplicate documents are not stored; facts still go through state card ingestion
+	duplicate bool
+
+	// Fact inputs (assistant code and its tool output)
+	code     string
+	result   string
+	statMeta map[string]string
+
+	needsSearchableSummary bool
+	factSummary            string
+	factErr                error
+	searchableSummary      string
+	searchableErr          error
+}
+
+// planDocuments walks messages in order and plans a document for each message that
+// passes the ingestion policy. Assistant messages followed by a tool message are planned
+// together as a fact.
+func (r *RAG) planDocuments(ctx context.Context, sessionID string, messages []types.AgentMessage) []*documentPlan {
+	processed := make(map[int]bool)
+	// Hashes planned earlier in this batch are not in the store yet
+	planned := make(map[string]bool)
+	var plans []*documentPlan
+
+	for i := range messages {
+		if processed[i] {
+			continue
+		}
+		plan := r.planDocumentForMessage(ctx, sessionID, messages, i, processed, planned)
+		if plan != nil {
+			plans = append(plans, plan)
+		}
+	}
+	return plans
+}
+
+func (r *RAG) planDocumentForMessage(
 	ctx context.Context,
 	sessionID string,
 	messages []types.AgentMessage,
 	index int,
 	processed map[int]bool,
-) (*ragDocumentData, bool, error) {
+	planned map[string]bool,
+) *documentPlan {
 	processed[index] = true
 	message := messages[index]
 
+	// Ingestion policy: excluded roles/patterns and upload notifications never reach RAG
+	admitted, ok := r.ingestion.Admit(message.Role, message.Content)
+	if !ok {
+		if message.Role == "assistant" && index+1 < len(messages) && messages[index+1].Role == "tool" {
+			processed[index+1] = true
+		}
+		r.logger.Debug("Message excluded from RAG by ingestion policy", zap.String("role", message.Role))
+		return nil
+	}
+
 	documentUUID := uuid.New()
 	documentID := documentUUID.String()
 	metadata := map[string]string{"document_id": documentID}
 	if sessionID != "" {
 		metadata["session_id"] = sessionID
 	}
+	if message.ContentHash != "" {
+		metadata["message_hash"] = message.ContentHash
+	}
 	_ = r.ensureDatasetMetadata(sessionID, metadata, message.Content)
 
-	var storedContent string
-	var contentToEmbed string
-	var summaryDoc *summaryDocument
+	plan := &documentPlan{
+		message:    message,
+		documentID: documentUUID,
+		metadata:   metadata,
+	}
 
 	if message.Role == "assistant" && index+1 < len(messages) && messages[index+1].Role == "tool" {
 		toolMessage := messages[index+1]
 		processed[index+1] = true
+		if _, ok := r.ingestion.Admit(toolMessage.Role, toolMessage.Content); !ok {
+			r.logger.Debug("Tool output excluded from RAG by ingestion policy")
+			return nil
+		}
 		metadata["role"] = "fact"
 
 		// Capture tool content hash for downstream state ingestion
@@ -111,25 +193,28 @@ func (r *RAG) prepareDocumentForMessage(
 		assistantContent := canonicalizeFactText(message.Content)
 		toolContent := canonicalizeFactText(toolMessage.Content)
 
+		if artifact := format.ClassifyArtifact(toolMessage.Content); artifact.Type != format.ArtifactText {
+			metadata["artifact_type"] = string(artifact.Type)
+		}
+
 		// Extract statistical metadata FIRST (before fact generation)
-		var statMeta map[string]string
 		if format.HasCodeBlock(message.Content) {
 			code, _ := format.ExtractCodeContent(message.Content)
-			statMeta = ExtractStatisticalMetadata(code, toolContent)
+			plan.statMeta = ExtractStatisticalMetadata(code, toolContent)
 
 			// Ensure dataset is resolved and added to both structural metadata AND statistical metadata
 			if dataset := r.ensureDatasetMetadata(sessionID, metadata, code, toolContent); dataset != "" {
-				if statMeta == nil {
-					statMeta = make(map[string]string)
+				if plan.statMeta == nil {
+					plan.statMeta = make(map[string]string)
 				}
-				statMeta["dataset"] = dataset
+				plan.statMeta["dataset"] = dataset
 			}
 		}
 
 		userContent := ""
 		for prev := index - 1; prev >= 0; prev-- {
 			if messages[prev].Role == "user" {
-				userContent = canonicalizeFactText(messages[prev].Content)
+				userContent = canonicalizeFactText(stripUploadNotifications(messages[prev].Content))
 				break
 			}
 		}
@@ -145,9 +230,9 @@ func (r *RAG) prepareDocumentForMessage(
 		factJSON, marshalErr := json.Marshal(factPayload)
 		if marshalErr != nil {
 			r.logger.Warn("Failed to marshal fact payload, falling back to concatenated format", zap.Error(marshalErr))
-			storedContent = fmt.Sprintf("%s\n\n%s", assistantContent, toolContent)
+			plan.storedContent = fmt.Sprintf("%s\n\n%s", assistantContent, toolContent)
 		} else {
-			storedContent = string(factJSON)
+			plan.storedContent = string(factJSON)
 		}
 
 		// Store assistant hash for deduplication (tool outputs can be identical across different queries)
@@ -157,36 +242,14 @@ func (r *RAG) prepareDocumentForMessage(
 		}
 
 		// Extract code from markdown format (retrained model outputs ```python natively)
-		re := regexp.MustCompile("(?s)" + "```python\n(.*?)\n```")
-		matches := re.FindStringSubmatch(message.Content)
-
-		var code string
+		matches := pythonBlockPattern.FindStringSubmatch(message.Content)
 		if len(matches) > 1 {
-			code = strings.TrimSpace(matches[1])
+			plan.code = strings.TrimSpace(matches[1])
 		}
-
-		if code != "" {
-			result := strings.TrimSpace(toolMessage.Content)
-			// Pass statistical metadata to fact generator
-			summary, err := r.generateFactSummary(ctx, code, result, statMeta)
-			if err != nil {
-				r.logger.Warn("LLM fact summarization failed, using fallback summary",
-					zap.Error(err),
-					zap.Int("code_length", len(code)),
-					zap.Int("result_length", len(result)))
-				contentToEmbed = "A code execution event occurred but could not be summarized."
-			} else {
-				contentToEmbed = strings.TrimSpace(summary)
-			}
-
-			// Attempt State Card ingestion (evidence-only, validated) using assistant+tool pair
-			func() {
-				ctx2, cancel := context.WithTimeout(ctx, 30*time.Second)
-				defer cancel()
-				r.ingestStateCard(ctx2, sessionID, metadata, code, result)
-			}()
+		if plan.code != "" {
+			plan.result = strings.TrimSpace(toolMessage.Content)
 		} else {
-			contentToEmbed = "An assistant action with a tool execution occurred."
+			plan.contentToEmbed = "An assistant action with a tool execution occurred."
 		}
 	} else {
 		// Store all standalone messages (not part of assistant+tool pairs)
@@ -196,97 +259,151 @@ func (r *RAG) prepareDocumentForMessage(
 		// - Orphaned code blocks (environment failures)
 		// - Any other assistant responses without tool execution
 
-		storedContent = canonicalizeFactText(message.Content)
-
-		// For user messages with file upload notifications, extract the user's actual question
-		if message.Role == "user" && strings.Contains(message.Content, "[📎 File uploaded:") {
-			// Remove file upload notification line(s) but keep the user's question
-			lines := strings.Split(storedContent, "\n")
-			var userLines []string
-			for _, line := range lines {
-				// Skip file upload notification lines
-				if !strings.Contains(line, "[📎 File uploaded:") && strings.TrimSpace(line) != "" {
-					userLines = append(userLines, line)
-				}
-			}
+		plan.storedContent = canonicalizeFactText(admitted)
 
-			// If there's no user question beyond the notification, skip storage
-			// (the PDF pages are already stored via AddPDFPagesToRAG)
-			if len(userLines) == 0 {
-				return nil, true, nil
+		metadata["role"] = message.Role
+		plan.contentToEmbed = canonicalizeFactText(plan.storedContent)
+	}
+
+	role := metadata["role"]
+	contentHash := HashContent(NormalizeForHash(plan.storedContent))
+	if contentHash != "" {
+		metadata["content_hash"] = contentHash
+		plan.contentHash = contentHash
+		if planned[role+"|"+contentHash] {
+			plan.duplicate = true
+		} else {
+			existingDocID, err := r.store.FindRAGDocumentByHash(ctx, sessionID, role, contentHash)
+			if err != nil {
+				r.logger.Warn("Failed to check for existing RAG document",
+					zap.Error(err),
+					zap.String("session_id", sessionID))
+				plan.duplicate = true
+			} else if existingDocID != uuid.Nil {
+				r.logger.Debug("Skipping duplicate RAG document",
+					zap.String("existing_document_id", existingDocID.String()),
+					zap.String("session_id", sessionID),
+					zap.String("role", role))
+				plan.duplicate = true
 			}
+		}
+		planned[role+"|"+contentHash] = true
+	}
 
-			storedContent = strings.Join(userLines, "\n")
+	plan.needsSearchableSummary = !plan.duplicate && role != "fact" && len(plan.storedContent) > 500
+
+	// Ensure dataset is in metadata for all messages (returns value but we've already set it)
+	_ = r.ensureDatasetMetadata(sessionID, metadata, message.Content, plan.storedContent, plan.contentToEmbed)
+
+	return plan
+}
+
+// generatePlanSummaries runs the fact and searchable summary calls of all plans on at
+// most RAG_INGEST_WORKERS goroutines. Each call writes only its own plan.
+func (r *RAG) generatePlanSummaries(ctx context.Context, plans []*documentPlan) {
+	workers := max(r.cfg.RAGIngestWorkers, 1)
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+
+	for _, plan := range plans {
+		// Duplicate facts keep their state card ingestion but need no summary
+		needsFact := plan.code != "" && !plan.duplicate
+		if !needsFact && !plan.needsSearchableSummary {
+			continue
 		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(plan *documentPlan) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if needsFact {
+				// Pass statistical metadata to fact generator
+				plan.factSummary, plan.factErr = r.generateFactSummary(ctx, plan.code, plan.result, plan.statMeta)
+			}
+			if plan.needsSearchableSummary {
+				plan.searchableSummary, plan.searchableErr = r.generateSearchableSummary(ctx, plan.storedContent)
+			}
+		}(plan)
+	}
+	wg.Wait()
+}
 
-		metadata["role"] = message.Role
-		contentToEmbed = canonicalizeFactText(storedContent)
-
-		// Check for near-duplicates using vector similarity
-		// SKIP this check for user messages - every user question is contextually important
-		if message.Role != "user" {
-			queryEmbedding, err := r.embedder(ctx, contentToEmbed)
-			if err == nil && len(queryEmbedding) > 0 {
-				results, err := r.store.VectorSearchRAGDocuments(ctx, queryEmbedding, 1, sessionID, nil)
-				if err != nil {
-					r.logger.Warn("Deduplication query failed, proceeding to add document anyway", zap.Error(err))
-				} else if len(results) > 0 && results[0].Similarity > 0.98 && results[0].Metadata["role"] == message.Role {
-					r.logger.Debug("Skipping duplicate content", zap.Float64("similarity", results[0].Similarity), zap.String("role", message.Role))
-					return nil, true, nil
-				}
+// finishDocument completes a plan once its summaries are in: state card ingestion for
+// facts, the near-duplicate check for standalone messages, and the summary document.
+func (r *RAG) finishDocument(ctx context.Context, sessionID string, plan *documentPlan) (*ragDocumentData, bool) {
+	message := plan.message
+	metadata := plan.metadata
+
+	if plan.code != "" {
+		if !plan.duplicate {
+			if plan.factErr != nil {
+				r.logger.Warn("LLM fact summarization failed, using fallback summary",
+					zap.Error(plan.factErr),
+					zap.Int("code_length", len(plan.code)),
+					zap.Int("result_length", len(plan.result)))
+				plan.contentToEmbed = "A code execution event occurred but could not be summarized."
+			} else {
+				plan.contentToEmbed = strings.TrimSpace(plan.factSummary)
 			}
 		}
+
+		// Attempt State Card ingestion (evidence-only, validated) using assistant+tool pair
+		func() {
+			ctx2, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+			r.ingestStateCard(ctx2, sessionID, metadata, plan.code, plan.result)
+		}()
 	}
 
-	if storedContent == "" {
-		storedContent = contentToEmbed
+	if plan.duplicate {
+		return nil, true
 	}
 
-	role := metadata["role"]
-	normalizedContent := NormalizeForHash(storedContent)
-	contentHash := HashContent(normalizedContent)
-	if contentHash != "" {
-		metadata["content_hash"] = contentHash
-		existingDocID, err := r.store.FindRAGDocumentByHash(ctx, sessionID, role, contentHash)
-		if err != nil {
-			r.logger.Warn("Failed to check for existing RAG document",
-				zap.Error(err),
-				zap.String("session_id", sessionID))
-			return nil, true, nil
-		}
-		if existingDocID != uuid.Nil {
-			r.logger.Debug("Skipping duplicate RAG document",
-				zap.String("existing_document_id", existingDocID.String()),
-				zap.String("session_id", sessionID),
-				zap.String("role", role))
-			return nil, true, nil
+	// Check for near-duplicates using vector similarity
+	// SKIP this check for user messages - every user question is contextually important
+	if metadata["role"] != "fact" && message.Role != "user" {
+		queryEmbedding, err := r.embedder(ctx, plan.contentToEmbed)
+		if err == nil && len(queryEmbedding) > 0 {
+			results, err := r.store.VectorSearchRAGDocuments(ctx, queryEmbedding, 1, sessionID, nil)
+			if err != nil {
+				r.logger.Warn("Deduplication query failed, proceeding to add document anyway", zap.Error(err))
+			} else if len(results) > 0 && results[0].Similarity > 0.98 && results[0].Metadata["role"] == message.Role {
+				r.logger.Debug("Skipping duplicate content", zap.Float64("similarity", results[0].Similarity), zap.String("role", message.Role))
+				return nil, true
+			}
 		}
 	}
 
-	if role != "fact" && len(storedContent) > 500 {
-		summary, err := r.generateSearchableSummary(ctx, storedContent)
-		if err != nil {
+	storedContent := plan.storedContent
+	if storedContent == "" {
+		storedContent = plan.contentToEmbed
+	}
+
+	var summaryDoc *summaryDocument
+	if plan.needsSearchableSummary {
+		if plan.searchableErr != nil {
 			r.logger.Warn("Failed to create searchable summary for long message, will use full content",
-				zap.Error(err),
+				zap.Error(plan.searchableErr),
 				zap.Int("content_length", len(storedContent)))
 		} else {
-			summaryDoc = r.buildSummaryDocument(summary, metadata, sessionID, message.Role)
+			summaryDoc = r.buildSummaryDocument(plan.searchableSummary, metadata, sessionID, message.Role)
 		}
 	}
 
-	// Ensure dataset is in metadata for all messages (returns value but we've already set it)
-	_ = r.ensureDatasetMetadata(sessionID, metadata, message.Content, storedContent, contentToEmbed)
-
 	return &ragDocumentData{
-		ID:            documentUUID,
+		ID:            plan.documentID,
 		Metadata:      metadata,
 		StoredContent: storedContent,
-		EmbedContent:  contentToEmbed,
-		ContentHash:   contentHash,
+		EmbedContent:  plan.contentToEmbed,
+		ContentHash:   plan.contentHash,
 		SummaryDoc:    summaryDoc,
-	}, false, nil
+	}, false
 }
 
+// pythonBlockPattern extracts the code of a markdown python block.
+var pythonBlockPattern = regexp.MustCompile("(?s)" + "```python\n(.*?)\n```")
+
+
 func (r *RAG) buildSummaryDocument(summary string, parentMetadata map[string]string, sessionID, messageRole string) *summaryDocument {
 	summaryID := uuid.New()
 	metadata := map[string]string{
@@ -530,6 +647,12 @@ func (r *RAG) persistConversationChunks(ctx context.Context, baseMetadata map[st
 		processedChunks = append(processedChunks, content)
 	}
 
+	// Persist the chunk documents first, then embed all their windows in one batch
+	var (
+		chunkContents []string
+		docIDs        []uuid.UUID
+		chunkIndexes  []int
+	)
 	for _, chunkContent := range processedChunks {
 		chunkContent = strings.TrimSpace(chunkContent)
 		if chunkContent == "" {
@@ -563,29 +686,35 @@ func (r *RAG) persistConversationChunks(ctx context.Context, baseMetadata map[st
 			continue
 		}
 
-		// Create embedding windows (conversation chunks usually fit in 1-2 windows)
-		windows, err := r.createEmbeddingWindows(ctx, chunkContent)
-		if err != nil {
-			r.logger.Warn("Failed to create embedding windows for conversation chunk",
-				zap.Error(err),
-				zap.String("document_id", chunkDocID.String()),
-				zap.Int("chunk_index", chunkIndex))
-			chunkIndex++
-			continue
-		}
+		chunkContents = append(chunkContents, chunkContent)
+		docIDs = append(docIDs, docID)
+		chunkIndexes = append(chunkIndexes, chunkIndex)
+		chunkIndex++
+	}
+
+	if len(chunkContents) == 0 {
+		return
+	}
 
-		// Store all embedding windows
+	// Conversation chunks usually fit in 1-2 windows each
+	windowsPerChunk, err := r.createEmbeddingWindowsBatch(ctx, chunkContents, r.cfg.EmbeddingLLMHost)
+	if err != nil {
+		r.logger.Warn("Failed to batch create embedding windows for conversation chunks",
+			zap.Error(err),
+			zap.String("parent_document_id", parentDocumentID))
+		return
+	}
+
+	for i, windows := range windowsPerChunk {
 		for _, window := range windows {
-			if err := r.store.CreateEmbedding(ctx, docID, window.WindowIndex, window.WindowStart, window.WindowEnd, window.WindowText, window.Embedding); err != nil {
+			if err := r.store.CreateEmbedding(ctx, docIDs[i], window.WindowIndex, window.WindowStart, window.WindowEnd, window.WindowText, window.Embedding); err != nil {
 				r.logger.Warn("Failed to store embedding window for conversation chunk",
 					zap.Error(err),
-					zap.String("document_id", chunkDocID.String()),
-					zap.Int("chunk_index", chunkIndex),
+					zap.String("document_id", docIDs[i].String()),
+					zap.Int("chunk_index", chunkIndexes[i]),
 					zap.Int("window_index", window.WindowIndex))
 			}
 		}
-
-		chunkIndex++
 	}
 }
 
@@ -713,7 +842,7 @@ func (r *RAG) persistDocumentChunks(ctx context.Context, baseMetadata map[string
     }
 
     // Perform batch windowing + embedding
-    windowsPerChunk, err := r.createEmbeddingWindowsBatch(ctx, chunkContents)
+    windowsPerChunk, err := r.createEmbeddingWindowsBatch(ctx, chunkContents, r.embeddingHostFor(baseMetadata))
     if err != nil {
         r.logger.Warn("Failed to batch create embedding windows for document chunks", zap.Error(err))
         return
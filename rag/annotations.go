@@ -0,0 +1,51 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// StoreAnnotation adds a user's note on a message to session memory with the "annotation"
+// role, which retrieval boosts by HYBRID_ANNOTATION_BOOST. subject says what the note is
+// about (e.g. "step 3") and is prefixed to the content. Returns the document ID so the
+// note can be removed from memory when it is deleted.
+func (r *RAG) StoreAnnotation(ctx context.Context, sessionID, messageID, subject, note string) (uuid.UUID, error) {
+	content := fmt.Sprintf("User note on %s: %s", subject, note)
+	contentHash := HashContent(NormalizeForHash(content))
+	md := map[string]string{
+		"session_id":         sessionID,
+		"role":               "annotation",
+		"type":               "annotation",
+		"message_id":         messageID,
+		"source_captured_at": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	docID, err := r.store.UpsertDocument(ctx, uuid.New(), content, md, contentHash)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to store annotation: %w", err)
+	}
+
+	windows, err := r.createEmbeddingWindows(ctx, content)
+	if err != nil {
+		r.logger.Warn("Failed to create embedding for annotation", zap.Error(err))
+		return docID, nil
+	}
+	for _, w := range windows {
+		if e := r.store.CreateEmbedding(ctx, docID, w.WindowIndex, w.WindowStart, w.WindowEnd, w.WindowText, w.Embedding); e != nil {
+			r.logger.Warn("Failed to store embedding window for annotation", zap.Error(e))
+		}
+	}
+	return docID, nil
+}
+
+// DeleteAnnotation removes an annotation stored by StoreAnnotation from session memory.
+func (r *RAG) DeleteAnnotation(ctx context.Context, documentID uuid.UUID) error {
+	if err := r.store.DeleteRAGDocument(ctx, documentID); err != nil {
+		return fmt.Errorf("failed to delete annotation from memory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package rag
+
+import "regexp"
+
+// fullHistoryPattern matches requests to look beyond recent memory, e.g. "search my full
+// history", "across the whole conversation history" or "check the archive".
+var fullHistoryPattern = regexp.MustCompile(`(?i)\b(?:full|whole|entire|complete|all(?: of)?(?: my| the)?)\s+(?:chat\s+|conversation\s+|session\s+)?history\b|\b(?:search|check|look in)\s+(?:the\s+|my\s+)?archives?\b`)
+
+// WantsFullHistory reports whether the query asks for the session's full history, in which
+// case retrieval also searches the archived tier.
+func WantsFullHistory(query string) bool {
+	return fullHistoryPattern.MatchString(query)
+}
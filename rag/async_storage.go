@@ -3,42 +3,341 @@ package rag
 import (
 	"context"
 	"stats-agent/web/types"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
-// AddMessagesAsync stores messages to RAG asynchronously with retry logic.
-// This method is non-blocking and designed for proactive storage during conversation.
+// ingestRateWindow is the window RAG_INGEST_MAX_BATCHES_PER_MINUTE is counted over.
+const ingestRateWindow = time.Minute
+
+// maxIngestAttempts is how many times a batch is written before it is given up.
+const maxIngestAttempts = 3
+
+// IngestionStats counts background RAG writes since startup, with the current queue depth.
+type IngestionStats struct {
+	// Enqueued messages passed to AddMessagesAsync
+	Enqueued int64
+	// Merged messages joined a batch that was already waiting, or duplicated a message in it
+	Merged int64
+	// Dropped messages were discarded because the session's queue was full
+	Dropped int64
+	// Batches written, how many attempts were retried and how many batches failed after retries
+	Batches int64
+	Retried int64
+	Failed  int64
+	// Pending messages queued across sessions, sessions with queued writes, and workers
+	// writing a batch out of the pool's Workers
+	QueueDepth     int
+	QueuedSessions int
+	ActiveWorkers  int
+	Workers        int
+}
+
+// ingestQueue holds one session's pending background writes. A session is handed to at
+// most one worker at a time, so its batches are written in order, one at a time.
+type ingestQueue struct {
+	sessionID string
+	pending   []types.AgentMessage
+	// scheduled is set while the session waits for a worker or holds one
+	scheduled bool
+	// dropped is set when the session is deleted; workers discard the queue
+	dropped bool
+	// attempts counts failed writes of the batch at the front of pending
+	attempts int
+	// batchTimes are the start times of batches within the last ingestRateWindow
+	batchTimes []time.Time
+}
+
+// AddMessagesAsync queues messages for storage in RAG and returns immediately. Writes of
+// a session that arrive within RAG_INGEST_COALESCE_WINDOW are merged into batches of up to
+// RAG_INGEST_MAX_BATCH messages, a session writes at most RAG_INGEST_MAX_BATCHES_PER_MINUTE
+// batches (later writes wait and coalesce), and beyond RAG_INGEST_MAX_PENDING queued
+// messages the oldest are dropped. Batches are written by a pool of
+// RAG_INGEST_QUEUE_WORKERS workers and retried with exponential backoff.
 func (r *RAG) AddMessagesAsync(sessionID string, messages []types.AgentMessage) {
 	if len(messages) == 0 {
 		return
 	}
+	r.ingestWorkersOnce.Do(r.startIngestWorkers)
+
+	r.ingestMu.Lock()
+	defer r.ingestMu.Unlock()
+
+	q := r.ingestQueues[sessionID]
+	if q == nil {
+		q = &ingestQueue{sessionID: sessionID}
+		r.ingestQueues[sessionID] = q
+	}
+	r.ingestStats.Enqueued += int64(len(messages))
+	waiting := len(q.pending) > 0
+	var duplicates int
+	q.pending, duplicates = appendUnique(q.pending, messages)
+	r.ingestStats.Merged += int64(duplicates)
+	if waiting {
+		r.ingestStats.Merged += int64(len(messages) - duplicates)
+	}
 
-	go func(sessionID string, messages []types.AgentMessage) {
-		const maxAttempts = 3
-		for attempt := range maxAttempts {
-			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-			err := r.AddMessagesToStore(ctx, sessionID, messages)
-			cancel()
+	if limit := r.cfg.RAGIngestMaxPending; limit > 0 && len(q.pending) > limit {
+		var dropped int
+		q.pending, dropped = dropOldestPending(q.pending, limit)
+		r.ingestStats.Dropped += int64(dropped)
+		r.logger.Warn("RAG ingestion queue full, dropped oldest messages",
+			zap.String("session_id", sessionID),
+			zap.Int("dropped", dropped),
+			zap.Int64("dropped_total", r.ingestStats.Dropped))
+	}
+
+	if !q.scheduled {
+		q.scheduled = true
+		r.scheduleIngest(q, 0)
+	}
+}
+
+// forgetIngestQueue drops a deleted session's queue and its unwritten messages. A worker
+// holding the queue discards it, and removes a batch it was writing meanwhile, so no
+// documents outlive the session.
+func (r *RAG) forgetIngestQueue(sessionID string) {
+	r.ingestMu.Lock()
+	defer r.ingestMu.Unlock()
+	if q := r.ingestQueues[sessionID]; q != nil {
+		q.pending = nil
+		q.dropped = true
+		delete(r.ingestQueues, sessionID)
+	}
+}
 
+// IngestionStats returns the background write counters and queue depth.
+func (r *RAG) IngestionStats() IngestionStats {
+	r.ingestMu.Lock()
+	defer r.ingestMu.Unlock()
+	stats := r.ingestStats
+	for _, q := range r.ingestQueues {
+		stats.QueueDepth += len(q.pending)
+		if len(q.pending) > 0 {
+			stats.QueuedSessions++
+		}
+	}
+	stats.ActiveWorkers = r.ingestActive
+	stats.Workers = r.cfg.RAGIngestQueueWorkers
+	return stats
+}
+
+// startIngestWorkers starts the worker pool that writes queued batches.
+func (r *RAG) startIngestWorkers() {
+	r.ingestReady = sync.NewCond(&r.ingestMu)
+	for range max(r.cfg.RAGIngestQueueWorkers, 1) {
+		go r.ingestWorker()
+	}
+}
+
+// scheduleIngest hands the session to the workers once the coalesce window, the rate
+// limit and minDelay have passed. Called with ingestMu held.
+func (r *RAG) scheduleIngest(q *ingestQueue, minDelay time.Duration) {
+	wait := max(r.nextBatchDelay(q, time.Now()), minDelay)
+	if wait <= 0 {
+		r.ingestReadyQueue = append(r.ingestReadyQueue, q)
+		r.ingestReady.Signal()
+		return
+	}
+	time.AfterFunc(wait, func() {
+		r.ingestMu.Lock()
+		r.ingestReadyQueue = append(r.ingestReadyQueue, q)
+		r.ingestReady.Signal()
+		r.ingestMu.Unlock()
+	})
+}
+
+// ingestWorker writes one batch of a ready session at a time. A session with writes left
+// afterwards is scheduled again, so sessions take turns on the pool.
+func (r *RAG) ingestWorker() {
+	r.ingestMu.Lock()
+	defer r.ingestMu.Unlock()
+	for {
+		for len(r.ingestReadyQueue) == 0 {
+			r.ingestReady.Wait()
+		}
+		q := r.ingestReadyQueue[0]
+		r.ingestReadyQueue = r.ingestReadyQueue[1:]
+		if q.dropped {
+			continue
+		}
+
+		batch := nextIngestBatch(q.pending, r.cfg.RAGIngestMaxBatch)
+		if len(batch) == 0 {
+			r.finishIngest(q)
+			continue
+		}
+		// Taken off the queue, so retractions and drops meanwhile cannot touch it
+		q.pending = append([]types.AgentMessage(nil), q.pending[len(batch):]...)
+		q.batchTimes = append(q.batchTimes, time.Now())
+		r.ingestStats.Batches++
+		r.ingestActive++
+		r.ingestMu.Unlock()
+
+		err := r.storeBatch(q.sessionID, batch)
+
+		r.ingestMu.Lock()
+		r.ingestActive--
+		if q.dropped {
 			if err == nil {
-				r.logger.Info("Stored messages to RAG",
-					zap.Int("message_count", len(messages)),
-					zap.String("session_id", sessionID))
-				return
+				// The session was deleted while the batch was written
+				go r.deleteDroppedDocuments(q.sessionID)
 			}
-
-			if attempt < maxAttempts-1 {
-				// Exponential backoff: 1s, 2s
-				time.Sleep(time.Second * time.Duration(attempt+1))
+			continue
+		}
+		if err == nil {
+			q.attempts = 0
+		} else {
+			q.attempts++
+			if q.attempts < maxIngestAttempts {
+				// Back at the front of the queue, so later writes still follow it
+				q.pending = append(append([]types.AgentMessage(nil), batch...), q.pending...)
+				r.ingestStats.Retried++
+				r.scheduleIngest(q, ingestBackoff(q.attempts))
 				continue
 			}
-
+			r.ingestStats.Failed++
 			r.logger.Error("RAG storage failed after retries",
 				zap.Error(err),
-				zap.String("session_id", sessionID),
-				zap.Int("message_count", len(messages)))
+				zap.String("session_id", q.sessionID),
+				zap.Int("message_count", len(batch)))
+			q.attempts = 0
 		}
-	}(sessionID, messages)
+		if len(q.pending) > 0 {
+			r.scheduleIngest(q, 0)
+			continue
+		}
+		r.finishIngest(q)
+	}
+}
+
+// finishIngest marks a session with nothing left to write as idle and drops its queue
+// once its rate window has passed; until then the queue keeps the batch times the rate
+// limit counts. Called with ingestMu held.
+func (r *RAG) finishIngest(q *ingestQueue) {
+	q.scheduled = false
+	if len(q.batchTimes) == 0 {
+		r.dropIdleQueue(q)
+		return
+	}
+	time.AfterFunc(time.Until(q.batchTimes[len(q.batchTimes)-1].Add(ingestRateWindow)), func() {
+		r.ingestMu.Lock()
+		defer r.ingestMu.Unlock()
+		if !q.scheduled && len(q.pending) == 0 {
+			r.dropIdleQueue(q)
+		}
+	})
+}
+
+// dropIdleQueue removes q from the session map unless a newer queue replaced it. Called
+// with ingestMu held.
+func (r *RAG) dropIdleQueue(q *ingestQueue) {
+	if r.ingestQueues[q.sessionID] == q {
+		delete(r.ingestQueues, q.sessionID)
+	}
+}
+
+// deleteDroppedDocuments removes the documents a batch stored after its session was deleted.
+func (r *RAG) deleteDroppedDocuments(sessionID string) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := r.store.DeleteRAGDocumentsBySession(ctx, sessionUUID); err != nil {
+		r.logger.Warn("Failed to delete documents written after session deletion",
+			zap.Error(err),
+			zap.String("session_id", sessionID))
+	}
+}
+
+// nextBatchDelay returns how long to wait before the next batch: the coalesce window,
+// extended until the session is back under its batch rate. Prunes expired batch times.
+// Called with ingestMu held.
+func (r *RAG) nextBatchDelay(q *ingestQueue, now time.Time) time.Duration {
+	recent := q.batchTimes[:0]
+	for _, t := range q.batchTimes {
+		if now.Sub(t) < ingestRateWindow {
+			recent = append(recent, t)
+		}
+	}
+	q.batchTimes = recent
+
+	wait := r.cfg.RAGIngestCoalesceWindow
+	if limit := r.cfg.RAGIngestMaxBatchesPerMinute; limit > 0 && len(recent) >= limit {
+		if untilSlot := recent[len(recent)-limit].Add(ingestRateWindow).Sub(now); untilSlot > wait {
+			wait = untilSlot
+		}
+	}
+	return wait
+}
+
+func (r *RAG) storeBatch(sessionID string, messages []types.AgentMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := r.AddMessagesToStore(ctx, sessionID, messages); err != nil {
+		r.logger.Warn("RAG storage attempt failed",
+			zap.Error(err),
+			zap.String("session_id", sessionID),
+			zap.Int("message_count", len(messages)))
+		return err
+	}
+	r.logger.Info("Stored messages to RAG",
+		zap.Int("message_count", len(messages)),
+		zap.String("session_id", sessionID))
+	return nil
+}
+
+// ingestBackoff returns the wait before retrying a batch after its nth failure: 1s, 2s, 4s, ...
+func ingestBackoff(attempt int) time.Duration {
+	return time.Second << (attempt - 1)
+}
+
+// nextIngestBatch returns the first limit pending messages (all of them when limit is 0).
+// A batch never ends between an assistant message and the tool message answering it.
+func nextIngestBatch(pending []types.AgentMessage, limit int) []types.AgentMessage {
+	if limit <= 0 || len(pending) <= limit {
+		return pending
+	}
+	end := limit
+	for end < len(pending) && pending[end].Role == "tool" {
+		end++
+	}
+	return pending[:end]
+}
+
+// appendUnique appends messages to pending, skipping any message identical to one
+// already pending. Returns the new pending list and the number of skipped duplicates.
+func appendUnique(pending, messages []types.AgentMessage) ([]types.AgentMessage, int) {
+	duplicates := 0
+	for _, m := range messages {
+		duplicate := false
+		for _, p := range pending {
+			if p.Role == m.Role && p.Content == m.Content {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			duplicates++
+			continue
+		}
+		pending = append(pending, m)
+	}
+	return pending, duplicates
+}
+
+// dropOldestPending trims pending to at most limit messages from the front. A tool
+// message left without the assistant message it answers is dropped too, so facts are
+// never stored half-paired. Returns the kept messages and the number dropped.
+func dropOldestPending(pending []types.AgentMessage, limit int) ([]types.AgentMessage, int) {
+	cut := len(pending) - limit
+	for cut < len(pending) && pending[cut].Role == "tool" {
+		cut++
+	}
+	return append([]types.AgentMessage(nil), pending[cut:]...), cut
 }
@@ -0,0 +1,250 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// learnedKinds are the document kinds a checkpoint diff lists by content: what the session
+// learned, as opposed to the raw messages and chunks it only counts.
+var learnedKinds = map[string]bool{
+	"fact":       true,
+	"rollup":     true,
+	"annotation": true,
+	"pin":        true,
+}
+
+// checkpointScope restricts a session's retrieval to the memory recorded in a checkpoint.
+type checkpointScope struct {
+	checkpoint types.MemoryCheckpoint
+	documents  map[string]bool
+	stateCards map[string]string
+}
+
+// CreateCheckpoint records the session's current memory under name. Writes still waiting
+// in the session's ingestion queue are not included.
+func (r *RAG) CreateCheckpoint(ctx context.Context, sessionID uuid.UUID, name string) (types.MemoryCheckpoint, error) {
+	checkpoint, err := r.store.CreateMemoryCheckpoint(ctx, sessionID, name)
+	if err != nil {
+		return types.MemoryCheckpoint{}, err
+	}
+	r.logger.Info("Created memory checkpoint",
+		zap.String("session_id", sessionID.String()),
+		zap.String("checkpoint", name),
+		zap.Int("documents", checkpoint.DocumentCount),
+		zap.Int("state_cards", checkpoint.StateCardCount))
+	return checkpoint, nil
+}
+
+// ScopeToCheckpoint makes the session's retrieval answer "as of" the checkpoint: only
+// documents recorded in it are returned, and state cards read as they were then.
+// A nil checkpoint restores retrieval over the current memory. The scope lasts until it
+// is cleared or the server restarts.
+func (r *RAG) ScopeToCheckpoint(ctx context.Context, sessionID uuid.UUID, checkpoint *types.MemoryCheckpoint) error {
+	id := sessionID.String()
+	if checkpoint == nil {
+		r.checkpointMu.Lock()
+		delete(r.checkpointScopes, id)
+		r.checkpointMu.Unlock()
+		return nil
+	}
+
+	docs, err := r.store.GetMemoryCheckpointDocuments(ctx, sessionID, checkpoint.ID)
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoint documents: %w", err)
+	}
+	scope := &checkpointScope{
+		checkpoint: *checkpoint,
+		documents:  make(map[string]bool, len(docs)),
+		stateCards: make(map[string]string),
+	}
+	for _, d := range docs {
+		docID := d.DocumentID.String()
+		scope.documents[docID] = true
+		if d.Kind == "state" {
+			scope.stateCards[docID] = d.StateContent
+		}
+	}
+
+	r.checkpointMu.Lock()
+	r.checkpointScopes[id] = scope
+	r.checkpointMu.Unlock()
+	return nil
+}
+
+// RetrievalCheckpoint returns the checkpoint the session's retrieval is scoped to.
+func (r *RAG) RetrievalCheckpoint(sessionID string) (types.MemoryCheckpoint, bool) {
+	r.checkpointMu.RLock()
+	defer r.checkpointMu.RUnlock()
+	scope, ok := r.checkpointScopes[sessionID]
+	if !ok {
+		return types.MemoryCheckpoint{}, false
+	}
+	return scope.checkpoint, true
+}
+
+// forgetCheckpointScope drops the session's retrieval scope, e.g. when the checkpoint is deleted.
+func (r *RAG) forgetCheckpointScope(sessionID string, checkpointID uuid.UUID) {
+	r.checkpointMu.Lock()
+	defer r.checkpointMu.Unlock()
+	if scope, ok := r.checkpointScopes[sessionID]; ok && (checkpointID == uuid.Nil || scope.checkpoint.ID == checkpointID) {
+		delete(r.checkpointScopes, sessionID)
+	}
+}
+
+// DeleteCheckpoint removes a checkpoint and clears the session's retrieval scope if it
+// pointed at it.
+func (r *RAG) DeleteCheckpoint(ctx context.Context, sessionID, checkpointID uuid.UUID) error {
+	if err := r.store.DeleteMemoryCheckpoint(ctx, sessionID, checkpointID); err != nil {
+		return err
+	}
+	r.forgetCheckpointScope(sessionID.String(), checkpointID)
+	return nil
+}
+
+// applyCheckpointScope drops candidates created after the session's scoped checkpoint and
+// restores state cards to their checkpoint content. No-op for unscoped sessions.
+func (r *RAG) applyCheckpointScope(sessionID string, candidates map[string]*hybridCandidate, docContents map[string]string) {
+	r.checkpointMu.RLock()
+	scope, ok := r.checkpointScopes[sessionID]
+	r.checkpointMu.RUnlock()
+	if !ok {
+		return
+	}
+
+	dropped := 0
+	for docID, cand := range candidates {
+		lookupID := ResolveLookupID(cand.DocumentID, cand.Metadata)
+		if !scope.documents[docID] || (lookupID != "" && !scope.documents[lookupID]) {
+			delete(candidates, docID)
+			dropped++
+			continue
+		}
+		if content, ok := scope.stateCards[docID]; ok {
+			cand.Content = content
+			docContents[docID] = content
+			if lookupID != "" {
+				docContents[lookupID] = content
+			}
+		}
+	}
+	if dropped > 0 {
+		r.logger.Debug("Scoped retrieval to memory checkpoint",
+			zap.String("session_id", sessionID),
+			zap.String("checkpoint", scope.checkpoint.Name),
+			zap.Int("dropped", dropped))
+	}
+}
+
+// DiffCheckpoints compares the memory recorded at from with to, or with the current
+// memory when to is nil. Learned and forgotten entries carry the document content when
+// it is still stored.
+func (r *RAG) DiffCheckpoints(ctx context.Context, sessionID uuid.UUID, from types.MemoryCheckpoint, to *types.MemoryCheckpoint) (types.MemoryCheckpointDiff, error) {
+	fromDocs, err := r.store.GetMemoryCheckpointDocuments(ctx, sessionID, from.ID)
+	if err != nil {
+		return types.MemoryCheckpointDiff{}, fmt.Errorf("failed to load checkpoint %q: %w", from.Name, err)
+	}
+	toName := "current"
+	var toDocs []types.CheckpointDocument
+	if to != nil {
+		toName = to.Name
+		toDocs, err = r.store.GetMemoryCheckpointDocuments(ctx, sessionID, to.ID)
+		if err != nil {
+			return types.MemoryCheckpointDiff{}, fmt.Errorf("failed to load checkpoint %q: %w", to.Name, err)
+		}
+	} else {
+		toDocs, err = r.store.ListSessionMemoryDocuments(ctx, sessionID)
+		if err != nil {
+			return types.MemoryCheckpointDiff{}, fmt.Errorf("failed to load session memory: %w", err)
+		}
+	}
+
+	diff := diffCheckpointDocuments(fromDocs, toDocs)
+	diff.From, diff.To = from.Name, toName
+
+	// Fill in the content of learned and forgotten documents still in the store
+	var ids []uuid.UUID
+	for _, e := range append(append([]types.MemoryDiffEntry(nil), diff.Learned...), diff.Forgotten...) {
+		ids = append(ids, e.DocumentID)
+	}
+	if len(ids) > 0 {
+		contents, err := r.store.GetDocumentsBatch(ctx, ids)
+		if err != nil {
+			r.logger.Warn("Failed to load contents for checkpoint diff", zap.Error(err), zap.String("session_id", sessionID.String()))
+		} else {
+			for i := range diff.Learned {
+				diff.Learned[i].Content = contents[diff.Learned[i].DocumentID.String()]
+			}
+			for i := range diff.Forgotten {
+				diff.Forgotten[i].Content = contents[diff.Forgotten[i].DocumentID.String()]
+			}
+		}
+	}
+	return diff, nil
+}
+
+// diffCheckpointDocuments counts the documents added and removed between two snapshots,
+// lists the learned kinds among them and the state cards whose content differs.
+func diffCheckpointDocuments(from, to []types.CheckpointDocument) types.MemoryCheckpointDiff {
+	diff := types.MemoryCheckpointDiff{
+		Added:        make(map[string]int),
+		Removed:      make(map[string]int),
+		Learned:      []types.MemoryDiffEntry{},
+		Forgotten:    []types.MemoryDiffEntry{},
+		StateChanges: []types.StateCardChange{},
+	}
+	before := make(map[uuid.UUID]types.CheckpointDocument, len(from))
+	for _, d := range from {
+		before[d.DocumentID] = d
+	}
+	after := make(map[uuid.UUID]types.CheckpointDocument, len(to))
+	for _, d := range to {
+		after[d.DocumentID] = d
+	}
+
+	for _, d := range to {
+		old, existed := before[d.DocumentID]
+		switch {
+		case !existed:
+			diff.Added[d.Kind]++
+			if learnedKinds[d.Kind] {
+				diff.Learned = append(diff.Learned, types.MemoryDiffEntry{DocumentID: d.DocumentID, Kind: d.Kind})
+			}
+			if d.Kind == "state" {
+				diff.StateChanges = append(diff.StateChanges, types.StateCardChange{DocumentID: d.DocumentID, After: d.StateContent})
+			}
+		case d.Kind == "state" && old.StateContent != d.StateContent:
+			diff.StateChanges = append(diff.StateChanges, types.StateCardChange{DocumentID: d.DocumentID, Before: old.StateContent, After: d.StateContent})
+		}
+	}
+	for _, d := range from {
+		if _, kept := after[d.DocumentID]; kept {
+			continue
+		}
+		diff.Removed[d.Kind]++
+		if learnedKinds[d.Kind] {
+			diff.Forgotten = append(diff.Forgotten, types.MemoryDiffEntry{DocumentID: d.DocumentID, Kind: d.Kind})
+		}
+		if d.Kind == "state" {
+			diff.StateChanges = append(diff.StateChanges, types.StateCardChange{DocumentID: d.DocumentID, Before: d.StateContent})
+		}
+	}
+
+	// Stable output for the same pair of snapshots
+	sort.Slice(diff.Learned, func(i, j int) bool {
+		return diff.Learned[i].DocumentID.String() < diff.Learned[j].DocumentID.String()
+	})
+	sort.Slice(diff.Forgotten, func(i, j int) bool {
+		return diff.Forgotten[i].DocumentID.String() < diff.Forgotten[j].DocumentID.String()
+	})
+	sort.Slice(diff.StateChanges, func(i, j int) bool {
+		return diff.StateChanges[i].DocumentID.String() < diff.StateChanges[j].DocumentID.String()
+	})
+	return diff
+}
@@ -0,0 +1,143 @@
+package rag
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"stats-agent/web/types"
+)
+
+// memoryCitationPattern matches the [mN] tags the model uses to cite memory entries.
+var memoryCitationPattern = regexp.MustCompile(`\[m(\d+)\]`)
+
+// maxCitationContext bounds the stored context kept for a cited memory entry.
+const maxCitationContext = 4000
+
+// memoryCitations numbers the memory entries shown during a session's run so the model
+// can cite them as [mN], and the footnotes given to the entries it cited.
+type memoryCitations struct {
+	tags      map[string]int // lookup ID → N of its [mN] tag
+	entries   map[int]types.MemoryCitation
+	footnotes map[int]int // N → footnote number
+}
+
+// ResetMemoryCitations starts a new run's numbering of the session's memory entries.
+func (r *RAG) ResetMemoryCitations(sessionID string) {
+	r.citationsMu.Lock()
+	defer r.citationsMu.Unlock()
+	delete(r.citations, sessionID)
+}
+
+// tagMemoryLines prefixes the first of an entry's <memory> lines with its [mN] tag and
+// records the entry. An entry retrieved again in the same run keeps its number.
+func (r *RAG) tagMemoryLines(sessionID, lookupID, role, content string, lines []string) []string {
+	if sessionID == "" || len(lines) == 0 || !strings.HasPrefix(lines[0], "- ") {
+		return lines
+	}
+
+	r.citationsMu.Lock()
+	c := r.citations[sessionID]
+	if c == nil {
+		c = &memoryCitations{
+			tags:      make(map[string]int),
+			entries:   make(map[int]types.MemoryCitation),
+			footnotes: make(map[int]int),
+		}
+		r.citations[sessionID] = c
+	}
+	n, ok := c.tags[lookupID]
+	if !ok {
+		n = len(c.tags) + 1
+		c.tags[lookupID] = n
+	}
+	c.entries[n] = types.MemoryCitation{
+		DocumentID: lookupID,
+		Role:       role,
+		Excerpt:    strings.TrimPrefix(StripMemoryCitations(strings.TrimSpace(strings.Join(lines, ""))), "- "),
+		Context:    citationContext(StripMemoryCitations(content)),
+	}
+	r.citationsMu.Unlock()
+
+	tagged := make([]string, len(lines))
+	for i, line := range lines {
+		tagged[i] = StripMemoryCitations(line)
+	}
+	tagged[0] = fmt.Sprintf("- [m%d] %s", n, strings.TrimPrefix(tagged[0], "- "))
+	return tagged
+}
+
+// citationContext renders a stored document for a footnote: a fact's exchange one
+// speaker per paragraph, anything else as stored.
+func citationContext(content string) string {
+	var fact factStoredContent
+	if err := json.Unmarshal([]byte(content), &fact); err == nil && (fact.User != "" || fact.Assistant != "" || fact.Tool != "") {
+		var parts []string
+		if fact.User != "" {
+			parts = append(parts, "User: "+strings.TrimSpace(fact.User))
+		}
+		if fact.Assistant != "" {
+			parts = append(parts, "Assistant: "+strings.TrimSpace(fact.Assistant))
+		}
+		if fact.Tool != "" {
+			parts = append(parts, "Output: "+strings.TrimSpace(fact.Tool))
+		}
+		content = strings.Join(parts, "\n\n")
+	}
+	content = strings.TrimSpace(content)
+	if len(content) > maxCitationContext {
+		content = compressMiddle(content, maxCitationContext, maxCitationContext*3/4, maxCitationContext/4)
+	}
+	return content
+}
+
+// CiteMemory returns the memory entries an assistant message cites with [mN] tags, in
+// footnote order. Footnotes are numbered in order of first citation across the run, so
+// the messages of one run share a numbering. Tags naming no entry shown in this run are
+// ignored.
+func (r *RAG) CiteMemory(sessionID, text string) []types.MemoryCitation {
+	matches := memoryCitationPattern.FindAllStringSubmatch(text, -1)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	r.citationsMu.Lock()
+	defer r.citationsMu.Unlock()
+	c := r.citations[sessionID]
+	if c == nil {
+		return nil
+	}
+
+	seen := make(map[int]bool)
+	var citations []types.MemoryCitation
+	for _, m := range matches {
+		n, _ := strconv.Atoi(m[1])
+		entry, ok := c.entries[n]
+		if !ok || seen[n] {
+			continue
+		}
+		seen[n] = true
+		footnote, ok := c.footnotes[n]
+		if !ok {
+			footnote = len(c.footnotes) + 1
+			c.footnotes[n] = footnote
+		}
+		entry.Tag = n
+		entry.Footnote = footnote
+		citations = append(citations, entry)
+	}
+	sort.Slice(citations, func(i, j int) bool { return citations[i].Footnote < citations[j].Footnote })
+	return citations
+}
+
+// StripMemoryCitations removes [mN] tags, whose numbers only hold within the run that
+// produced them, from text carried into later prompts.
+func StripMemoryCitations(text string) string {
+	if !strings.Contains(text, "[m") {
+		return text
+	}
+	return memoryCitationPattern.ReplaceAllString(text, "")
+}
@@ -0,0 +1,67 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// StageProfile is the state card stage holding a dataset's column types.
+const StageProfile = "profile"
+
+// StoreColumnProfile stores the dataset's profiling state card (its effective column
+// types) and supersedes the dataset's previous profile cards, so retrieval only surfaces
+// the current types.
+func (r *RAG) StoreColumnProfile(ctx context.Context, sessionID, dataset, profile string) error {
+	if sessionID == "" || dataset == "" {
+		return fmt.Errorf("session ID and dataset are required")
+	}
+
+	docID := uuid.New()
+	docs, err := r.store.ListStateDocuments(ctx, sessionID)
+	if err != nil {
+		r.logger.Warn("Failed to list state documents", zap.Error(err), zap.String("session_id", sessionID))
+	}
+	for _, doc := range docs {
+		if doc.Metadata["stage"] != StageProfile || doc.Metadata["dataset"] != dataset || doc.Metadata["state_status"] == "superseded" {
+			continue
+		}
+		meta := cloneStringMap(doc.Metadata)
+		meta["state_status"] = "superseded"
+		meta["superseded_by"] = docID.String()
+		if _, err := r.store.UpsertDocument(ctx, doc.ID, doc.Content, meta, doc.ContentHash); err != nil {
+			r.logger.Warn("Failed to supersede profile state", zap.Error(err), zap.String("document_id", doc.ID.String()))
+		}
+	}
+
+	content := fmt.Sprintf("[dataset:%s | stage:%s]\n%s", dataset, StageProfile, strings.TrimSpace(profile))
+	md := map[string]string{
+		"session_id":         sessionID,
+		"role":               "state",
+		"type":               "state",
+		"dataset":            dataset,
+		"stage":              StageProfile,
+		"source_type":        "column_types",
+		"source_captured_at": time.Now().UTC().Format(time.RFC3339),
+		"state_status":       "active",
+	}
+	if _, err := r.store.UpsertDocument(ctx, docID, content, md, HashContent(NormalizeForHash(content))); err != nil {
+		return fmt.Errorf("failed to store profile state: %w", err)
+	}
+
+	windows, err := r.createEmbeddingWindows(ctx, content)
+	if err != nil {
+		r.logger.Warn("Failed to create embedding for profile state", zap.Error(err))
+		return nil
+	}
+	for _, w := range windows {
+		if e := r.store.CreateEmbedding(ctx, docID, w.WindowIndex, w.WindowStart, w.WindowEnd, w.WindowText, w.Embedding); e != nil {
+			r.logger.Warn("Failed to store embedding window for profile state", zap.Error(e))
+		}
+	}
+	return nil
+}
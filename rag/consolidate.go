@@ -0,0 +1,89 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ConsolidateSessionFacts clusters a session's facts by embedding similarity and
+// collapses each cluster of near-duplicates into its newest member. The newest
+// fact is kept as canonical (iterative re-runs usually fix earlier attempts) and
+// records the removed IDs in its consolidated_from metadata.
+// Returns the number of facts removed.
+func (r *RAG) ConsolidateSessionFacts(ctx context.Context, sessionID string) (int, error) {
+	facts, err := r.store.ListSessionFactEmbeddings(ctx, sessionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list session facts: %w", err)
+	}
+	if len(facts) < 2 {
+		return 0, nil
+	}
+
+	threshold := r.cfg.FactConsolidationSimilarity
+	assigned := make([]bool, len(facts))
+	removed := 0
+
+	// Facts are ordered newest first, so the first unassigned fact seeds each cluster
+	for i := range facts {
+		if assigned[i] || len(facts[i].Embedding) == 0 {
+			continue
+		}
+		assigned[i] = true
+
+		var duplicates []uuid.UUID
+		for j := i + 1; j < len(facts); j++ {
+			if assigned[j] || len(facts[j].Embedding) == 0 {
+				continue
+			}
+			// Never merge facts about different datasets
+			if facts[i].Metadata["dataset"] != facts[j].Metadata["dataset"] {
+				continue
+			}
+			if cosineSimilarity(facts[i].Embedding, facts[j].Embedding) >= threshold {
+				assigned[j] = true
+				duplicates = append(duplicates, facts[j].DocumentID)
+			}
+		}
+
+		if len(duplicates) == 0 {
+			continue
+		}
+		if err := r.store.ConsolidateFacts(ctx, facts[i].DocumentID, duplicates); err != nil {
+			r.logger.Warn("Failed to consolidate fact cluster, continuing",
+				zap.Error(err),
+				zap.String("session_id", sessionID),
+				zap.String("canonical_id", facts[i].DocumentID.String()))
+			continue
+		}
+		removed += len(duplicates)
+	}
+
+	if removed > 0 {
+		r.logger.Info("Consolidated near-duplicate facts",
+			zap.String("session_id", sessionID),
+			zap.Int("facts_before", len(facts)),
+			zap.Int("facts_removed", removed))
+	}
+	return removed, nil
+}
+
+// cosineSimilarity returns the cosine similarity of two vectors (0 when undefined).
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -12,6 +12,7 @@ import (
     "stats-agent/config"
     "stats-agent/database"
     "stats-agent/llmclient"
+    "stats-agent/metrics"
 
     "github.com/google/uuid"
     lru "github.com/hashicorp/golang-lru"
@@ -25,7 +26,8 @@ type EmbeddingFunc func(ctx context.Context, text string) ([]float32, error)
 
 type RAG struct {
     cfg                        *config.Config
-    store                      *database.PostgresStore
+    store                      database.Store
+    llm                        llmclient.LLM
     embedder                   EmbeddingFunc
     logger                     *zap.Logger
     embeddingTokenSoftLimit    int
@@ -37,6 +39,27 @@ type RAG struct {
     sentenceSplitter           SentenceSplitter
     tokenCache                 *lru.Cache
     tokenCacheMu               sync.RWMutex
+    ingestion                  *IngestionPolicy
+    // Per-session background write queues (async_storage.go)
+    ingestMu                   sync.Mutex
+    ingestQueues               map[string]*ingestQueue
+    ingestStats                IngestionStats
+    // Worker pool writing ready sessions' batches, started on first use
+    ingestWorkersOnce          sync.Once
+    ingestReady                *sync.Cond
+    ingestReadyQueue           []*ingestQueue
+    ingestActive               int
+    // Cross-encoder for the optional reranking pass (rerank.go); nil disables it
+    reranker                   llmclient.Reranker
+    // Per-session retrieval scoped to a memory checkpoint (checkpoints.go)
+    checkpointMu               sync.RWMutex
+    checkpointScopes           map[string]*checkpointScope
+    // Per-session [mN] numbering of memory entries for cited footnotes (citations.go)
+    citationsMu                sync.Mutex
+    citations                  map[string]*memoryCitations
+    // Per-session retrieval policy selections (retrieval_policy.go)
+    policyMu                   sync.RWMutex
+    sessionPolicies            map[string]string
 }
 
 type factStoredContent struct {
@@ -75,12 +98,22 @@ type summaryDocument struct {
 	Metadata map[string]string
 }
 
-func New(cfg *config.Config, store *database.PostgresStore, logger *zap.Logger) (*RAG, error) {
+// New creates the RAG service. llm serves the summarization, embedding, and tokenize calls;
+// pass llmclient.New for the configured hosts or a fake in tests.
+func New(cfg *config.Config, store database.Store, llm llmclient.LLM, logger *zap.Logger) (*RAG, error) {
 	if store == nil {
 		return nil, fmt.Errorf("postgres store is required for RAG persistence")
 	}
+	if llm == nil {
+		return nil, fmt.Errorf("an LLM client is required for RAG")
+	}
+
+	embedder := createLlamaCppEmbedding(cfg, llm)
 
-	embedder := createLlamaCppEmbedding(cfg, logger)
+	ingestion, err := NewIngestionPolicy(cfg.RAGExcludedRoles, cfg.RAGExcludedPatterns)
+	if err != nil {
+		return nil, err
+	}
 
     embeddingSoftLimit := cfg.EmbeddingTokenSoftLimit
     embeddingTarget := cfg.EmbeddingTokenTarget
@@ -98,6 +131,7 @@ func New(cfg *config.Config, store *database.PostgresStore, logger *zap.Logger)
     r := &RAG{
         cfg:                        cfg,
         store:                      store,
+        llm:                        llm,
         embedder:                   embedder,
         logger:                     logger,
         embeddingTokenSoftLimit:    embeddingSoftLimit,
@@ -107,6 +141,11 @@ func New(cfg *config.Config, store *database.PostgresStore, logger *zap.Logger)
         sessionDatasets:            make(map[string]string),
         sentenceSplitter:           NewRegexSentenceSplitter(),
         tokenCache:                 tc,
+        ingestion:                  ingestion,
+        ingestQueues:               make(map[string]*ingestQueue),
+        checkpointScopes:           make(map[string]*checkpointScope),
+        citations:                  make(map[string]*memoryCitations),
+        sessionPolicies:            make(map[string]string),
     }
 
 	return r, nil
@@ -150,16 +189,8 @@ func ComputeMessageContentHash(role, content string) string {
 	normalized := CanonicalizeFactText(content)
 
 	// For user messages, strip file upload notifications (same as RAG does)
-	if role == "user" && strings.Contains(normalized, "[📎 File uploaded:") {
-		lines := strings.Split(normalized, "\n")
-		var userLines []string
-		for _, line := range lines {
-			// Skip file upload notification lines and empty lines
-			if !strings.Contains(line, "[📎 File uploaded:") && strings.TrimSpace(line) != "" {
-				userLines = append(userLines, line)
-			}
-		}
-		normalized = strings.Join(userLines, "\n")
+	if role == "user" {
+		normalized = stripUploadNotifications(normalized)
 	}
 
 	// Hash using standard normalization
@@ -223,12 +254,18 @@ func filterStructuralMetadata(metadata map[string]string) map[string]string {
 		"document_id":          true,
 		"type":                 true,
 		"content_hash":         true,
+		"message_hash":         true, // Source message hashes, for retracting a message's artifacts
+		"tool_content_hash":    true,
 		"parent_document_id":   true,
 		"parent_document_role": true,
 		"chunk_index":          true,
 		"dataset":              true, // Keep for query boosting and metadata filtering
 		"filename":             true, // Original filename
 		"page_number":          true, // Page number for PDFs
+		"language":             true, // Detected language (text search configuration) for PDFs
+		"embedding_model":      true, // Set when embedded by a non-default host (multilingual)
+		"extraction_quality":   true, // PDF page text extraction score, 0-1
+		"needs_ocr":            true, // PDF without a usable text layer
 	}
 
 	for key, value := range metadata {
@@ -330,20 +367,52 @@ func resolveRole(metadata map[string]string) string {
 	return metadata["parent_document_role"]
 }
 
-func createLlamaCppEmbedding(cfg *config.Config, logger *zap.Logger) EmbeddingFunc {
-    client := llmclient.New(cfg, logger)
+func createLlamaCppEmbedding(cfg *config.Config, client llmclient.Embedder) EmbeddingFunc {
     return func(ctx context.Context, doc string) ([]float32, error) {
         return client.Embed(ctx, cfg.EmbeddingLLMHost, doc)
     }
 }
 
-// embedBatch generates embeddings for multiple documents.
-// Uses a client helper and falls back to sequential calls when necessary.
-func (r *RAG) embedBatch(ctx context.Context, docs []string) ([][]float32, error) {
+// embedBatchWithHost generates embeddings for multiple documents using the given embedding host,
+// in requests of at most EMBEDDING_BATCH_SIZE documents. Clients without batch support fall
+// back to sequential calls.
+func (r *RAG) embedBatchWithHost(ctx context.Context, host string, docs []string) ([][]float32, error) {
     if len(docs) == 0 {
         return nil, nil
     }
-    client := llmclient.New(r.cfg, r.logger)
-    // Try batched client call first; if not implemented it will fall back to sequential.
-    return client.EmbedBatch(ctx, r.cfg.EmbeddingLLMHost, docs)
+    size := r.cfg.EmbeddingBatchSize
+    if size <= 0 {
+        size = len(docs)
+    }
+    embeddings := make([][]float32, 0, len(docs))
+    for start := 0; start < len(docs); start += size {
+        batch := docs[start:min(start+size, len(docs))]
+        metrics.EmbeddingBatchSize.Observe(float64(len(batch)), host)
+        vecs, err := r.llm.EmbedBatch(ctx, host, batch)
+        if err != nil {
+            return nil, err
+        }
+        embeddings = append(embeddings, vecs...)
+    }
+    return embeddings, nil
+}
+
+// embeddingHostFor returns the embedding host for a document: the multilingual host for
+// documents tagged with the multilingual embedding model, otherwise the default host.
+func (r *RAG) embeddingHostFor(metadata map[string]string) string {
+    if metadata["embedding_model"] == EmbeddingModelMultilingual && r.cfg.MultilingualEmbeddingHost != "" {
+        return r.cfg.MultilingualEmbeddingHost
+    }
+    return r.cfg.EmbeddingLLMHost
+}
+
+// embedderFor returns the embedding function matching a document's embedding host.
+func (r *RAG) embedderFor(metadata map[string]string) EmbeddingFunc {
+    host := r.embeddingHostFor(metadata)
+    if host == r.cfg.EmbeddingLLMHost {
+        return r.embedder
+    }
+    return func(ctx context.Context, doc string) ([]float32, error) {
+        return r.llm.Embed(ctx, host, doc)
+    }
 }
@@ -0,0 +1,82 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// StageDatasetProfile is the state card stage holding a dataset's upload profile.
+const StageDatasetProfile = "dataset_profile"
+
+// StoreDatasetProfile stores the dataset's upload profile as a state card and supersedes
+// the dataset's previous one. The content is the formatted profile; the metadata carries
+// its size and the columns with missing values, so it can be filtered on.
+func (r *RAG) StoreDatasetProfile(ctx context.Context, sessionID string, profile *types.DatasetProfile, formatted string) error {
+	if sessionID == "" || profile == nil || profile.Dataset == "" {
+		return fmt.Errorf("session ID and dataset are required")
+	}
+
+	docID := uuid.New()
+	docs, err := r.store.ListStateDocuments(ctx, sessionID)
+	if err != nil {
+		r.logger.Warn("Failed to list state documents", zap.Error(err), zap.String("session_id", sessionID))
+	}
+	for _, doc := range docs {
+		if doc.Metadata["stage"] != StageDatasetProfile || doc.Metadata["dataset"] != profile.Dataset || doc.Metadata["state_status"] == "superseded" {
+			continue
+		}
+		meta := cloneStringMap(doc.Metadata)
+		meta["state_status"] = "superseded"
+		meta["superseded_by"] = docID.String()
+		if _, err := r.store.UpsertDocument(ctx, doc.ID, doc.Content, meta, doc.ContentHash); err != nil {
+			r.logger.Warn("Failed to supersede dataset profile state", zap.Error(err), zap.String("document_id", doc.ID.String()))
+		}
+	}
+
+	var missing []string
+	for _, col := range profile.Columns {
+		if col.Missing > 0 {
+			missing = append(missing, col.Name)
+		}
+	}
+
+	content := fmt.Sprintf("[dataset:%s | stage:%s]\n%s", profile.Dataset, StageDatasetProfile, strings.TrimSpace(formatted))
+	md := map[string]string{
+		"session_id":         sessionID,
+		"role":               "state",
+		"type":               "state",
+		"dataset":            profile.Dataset,
+		"stage":              StageDatasetProfile,
+		"source_type":        "upload_profile",
+		"source_captured_at": time.Now().UTC().Format(time.RFC3339),
+		"state_status":       "active",
+		"rows":               strconv.Itoa(profile.Rows),
+		"columns":            strconv.Itoa(len(profile.Columns)),
+	}
+	if len(missing) > 0 {
+		md["missing_columns"] = strings.Join(missing, ",")
+	}
+	if _, err := r.store.UpsertDocument(ctx, docID, content, md, HashContent(NormalizeForHash(content))); err != nil {
+		return fmt.Errorf("failed to store dataset profile state: %w", err)
+	}
+
+	windows, err := r.createEmbeddingWindows(ctx, content)
+	if err != nil {
+		r.logger.Warn("Failed to create embedding for dataset profile state", zap.Error(err))
+		return nil
+	}
+	for _, w := range windows {
+		if e := r.store.CreateEmbedding(ctx, docID, w.WindowIndex, w.WindowStart, w.WindowEnd, w.WindowText, w.Embedding); e != nil {
+			r.logger.Warn("Failed to store embedding window for dataset profile state", zap.Error(e))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package rag
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// crossDatasetPattern matches requests to look beyond the active dataset, e.g. "compare
+// across datasets", "in both files", "what did the other dataset show".
+var crossDatasetPattern = regexp.MustCompile(`(?i)\b(?:across|all|both|each|every|other|previous|earlier)\s+(?:of\s+)?(?:the\s+|my\s+)?(?:data\s*sets?|data\s+files?|files|uploads)\b`)
+
+// joinDatasetsPattern matches requests to combine datasets ("join sales with customers",
+// "merge the files"), which need memory of every dataset involved.
+var joinDatasetsPattern = regexp.MustCompile(`(?i)\b(?:join|joining|merge|merging)\b`)
+
+// WantsAllDatasets reports whether the query asks to search every dataset of the session
+// instead of only the active one.
+func WantsAllDatasets(query string) bool {
+	return crossDatasetPattern.MatchString(query) || joinDatasetsPattern.MatchString(query)
+}
+
+// retrievalDataset returns the dataset a session's retrieval is scoped to, or "" to search
+// across datasets. The active dataset is the one the session worked with last; queries
+// that ask across datasets or name a different data file are not scoped.
+func (r *RAG) retrievalDataset(ctx context.Context, sessionID, query string) string {
+	if !r.cfg.RAGScopeToDataset || sessionID == "" || WantsAllDatasets(query) {
+		return ""
+	}
+
+	dataset := r.getSessionDataset(sessionID)
+	if dataset == "" {
+		// Not seen since a restart: fall back to the latest dataset stored for the session
+		latest, err := r.store.GetLatestSessionDataset(ctx, sessionID)
+		if err != nil {
+			r.logger.Warn("Failed to look up the active dataset, searching across datasets", zap.Error(err), zap.String("session_id", sessionID))
+			return ""
+		}
+		r.rememberSessionDataset(sessionID, latest)
+		dataset = latest
+	}
+
+	if match := datasetQueryRegex.FindStringSubmatch(query); len(match) > 1 && !strings.EqualFold(match[1], dataset) {
+		return ""
+	}
+	return dataset
+}
@@ -8,8 +8,6 @@ import (
     "encoding/hex"
     "time"
 
-    "stats-agent/llmclient"
-
     "go.uber.org/zap"
 )
 
@@ -183,8 +181,7 @@ func (r *RAG) countTokensForEmbeddingExact(ctx context.Context, text string) (in
     if r.cfg == nil || strings.TrimSpace(r.cfg.EmbeddingLLMHost) == "" {
         return 0, fmt.Errorf("embedding LLM host not configured")
     }
-    client := llmclient.New(r.cfg, r.logger)
-    return client.Tokenize(ctx, r.cfg.EmbeddingLLMHost, text)
+    return r.llm.Tokenize(ctx, r.cfg.EmbeddingLLMHost, text)
 }
 
 // EmbeddingWindow represents a single window of text with its embedding.
@@ -198,32 +195,38 @@ type EmbeddingWindow struct {
 
 // createEmbeddingWindows splits text into multiple windows and generates an embedding for each.
 // This ensures all content is searchable, even if it exceeds the embedding model's token limit.
+// All windows are embedded in one batch call to the default embedding host.
 func (r *RAG) createEmbeddingWindows(ctx context.Context, content string) ([]EmbeddingWindow, error) {
+	windows, err := r.createEmbeddingWindowsBatch(ctx, []string{content}, r.cfg.EmbeddingLLMHost)
+	if err != nil {
+		return nil, err
+	}
+	return windows[0], nil
+}
+
+// splitEmbeddingWindows splits text into windows of at most EMBEDDING_TOKEN_TARGET tokens,
+// without embedding them. Window sizing always uses the default embedding tokenizer.
+func (r *RAG) splitEmbeddingWindows(ctx context.Context, content string) ([]EmbeddingWindow, error) {
 	trimmed := strings.TrimSpace(content)
 	if trimmed == "" {
 		return nil, nil
 	}
 
-    targetTokens := r.embeddingTokenTarget
+	targetTokens := r.embeddingTokenTarget
 
 	// Check total token count
-    totalTokens, err := r.countTokensForEmbedding(ctx, trimmed)
+	totalTokens, err := r.countTokensForEmbedding(ctx, trimmed)
 	if err != nil {
 		return nil, fmt.Errorf("failed to count tokens: %w", err)
 	}
 
-	// If content fits in one window, create single embedding
+	// If content fits in one window, use it whole
 	if totalTokens <= targetTokens {
-        embedding, err := r.embedder(ctx, trimmed)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create embedding: %w", err)
-		}
 		return []EmbeddingWindow{{
 			WindowIndex: 0,
 			WindowStart: 0,
 			WindowEnd:   len(trimmed),
 			WindowText:  trimmed,
-			Embedding:   embedding,
 		}}, nil
 	}
 
@@ -233,7 +236,7 @@ func (r *RAG) createEmbeddingWindows(ctx context.Context, content string) ([]Emb
 	windowIndex := 0
 	currentPos := 0
 
-    for i := 0; i < len(words); {
+	for i := 0; i < len(words); {
 		accumulated := []string{}
 		startPos := currentPos
 
@@ -245,7 +248,7 @@ func (r *RAG) createEmbeddingWindows(ctx context.Context, content string) ([]Emb
 			// Check token count every N words or at the end
 			if (len(accumulated)%checkInterval == 0) || (j == len(words)-1) {
 				testText := strings.Join(accumulated, " ")
-            tokens, err := r.countTokensForEmbedding(ctx, testText)
+				tokens, err := r.countTokensForEmbedding(ctx, testText)
 				if err != nil {
 					return nil, fmt.Errorf("failed to count tokens for window: %w", err)
 				}
@@ -259,7 +262,7 @@ func (r *RAG) createEmbeddingWindows(ctx context.Context, content string) ([]Emb
 					for k := 0; k < wordsToRemove; k++ {
 						testWithOne := append(accumulated, words[j-wordsToRemove+k+1])
 						testText := strings.Join(testWithOne, " ")
-                tokens, _ := r.countTokensForEmbedding(ctx, testText)
+						tokens, _ := r.countTokensForEmbedding(ctx, testText)
 						if tokens <= targetTokens {
 							accumulated = testWithOne
 						} else {
@@ -277,18 +280,12 @@ func (r *RAG) createEmbeddingWindows(ctx context.Context, content string) ([]Emb
 		}
 
 		windowText := strings.Join(accumulated, " ")
-        embedding, err := r.embedder(ctx, windowText)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create embedding for window %d: %w", windowIndex, err)
-		}
-
 		endPos := currentPos + len(windowText)
 		windows = append(windows, EmbeddingWindow{
 			WindowIndex: windowIndex,
 			WindowStart: startPos,
 			WindowEnd:   endPos,
 			WindowText:  windowText,
-			Embedding:   embedding,
 		})
 
 		i += len(accumulated)
@@ -296,144 +293,44 @@ func (r *RAG) createEmbeddingWindows(ctx context.Context, content string) ([]Emb
 		windowIndex++
 	}
 
-    return windows, nil
+	return windows, nil
 }
 
-// createEmbeddingWindowsBatch splits each chunk into windows and generates embeddings in a single batch call.
-// It returns a slice of windows per input chunk, preserving order.
-func (r *RAG) createEmbeddingWindowsBatch(ctx context.Context, chunks []string) ([][]EmbeddingWindow, error) {
-    if len(chunks) == 0 {
-        return nil, nil
-    }
-
-    targetTokens := r.embeddingTokenTarget
-
-    // First pass: compute window texts and positions per chunk
-    type rawWindow struct {
-        chunkIdx   int
-        start      int
-        end        int
-        text       string
-        windowIdx  int
-    }
-    var allWindows []rawWindow
-    perChunkCounts := make([]int, len(chunks))
-
-    for ci, content := range chunks {
-        trimmed := strings.TrimSpace(content)
-        if trimmed == "" {
-            perChunkCounts[ci] = 0
-            continue
-        }
-
-        // Count total tokens with cache
-        totalTokens, err := r.countTokensForEmbedding(ctx, trimmed)
-        if err != nil {
-            return nil, fmt.Errorf("failed to count tokens: %w", err)
-        }
-
-        // One window fits
-        if totalTokens <= targetTokens {
-            allWindows = append(allWindows, rawWindow{
-                chunkIdx:  ci,
-                start:     0,
-                end:       len(trimmed),
-                text:      trimmed,
-                windowIdx: 0,
-            })
-            perChunkCounts[ci] = 1
-            continue
-        }
-
-        // Multi-window split mirroring createEmbeddingWindows logic
-        words := strings.Fields(trimmed)
-        windowIndex := 0
-        currentPos := 0
-        for i := 0; i < len(words); {
-            accumulated := []string{}
-            startPos := currentPos
-            const checkInterval = 10
-
-            for j := i; j < len(words); j++ {
-                accumulated = append(accumulated, words[j])
-                if (len(accumulated)%checkInterval == 0) || (j == len(words)-1) {
-                    testText := strings.Join(accumulated, " ")
-                    tokens, err := r.countTokensForEmbedding(ctx, testText)
-                    if err != nil {
-                        return nil, fmt.Errorf("failed to count tokens for window: %w", err)
-                    }
-                    if tokens > targetTokens {
-                        // Backtrack
-                        wordsToRemove := min(checkInterval, len(accumulated))
-                        accumulated = accumulated[:len(accumulated)-wordsToRemove]
-                        for k := 0; k < wordsToRemove; k++ {
-                            testWithOne := append(accumulated, words[j-wordsToRemove+k+1])
-                            testText := strings.Join(testWithOne, " ")
-                            tokens, _ := r.countTokensForEmbedding(ctx, testText)
-                            if tokens <= targetTokens {
-                                accumulated = testWithOne
-                            } else {
-                                break
-                            }
-                        }
-                        break
-                    }
-                }
-            }
-
-            if len(accumulated) == 0 {
-                accumulated = []string{words[i]}
-            }
-
-            windowText := strings.Join(accumulated, " ")
-            endPos := currentPos + len(windowText)
-
-            allWindows = append(allWindows, rawWindow{
-                chunkIdx:  ci,
-                start:     startPos,
-                end:       endPos,
-                text:      windowText,
-                windowIdx: windowIndex,
-            })
-
-            i += len(accumulated)
-            currentPos = endPos + 1
-            windowIndex++
-        }
-        perChunkCounts[ci] = windowIndex
-    }
-
-    // Flatten texts for a single embedding call
-    flatTexts := make([]string, len(allWindows))
-    for i, w := range allWindows {
-        flatTexts[i] = w.text
-    }
-
-    embeddings, err := r.embedBatch(ctx, flatTexts)
-    if err != nil {
-        return nil, err
-    }
-    if len(embeddings) != len(allWindows) {
-        return nil, fmt.Errorf("embedding batch size mismatch: got %d, want %d", len(embeddings), len(allWindows))
-    }
+// createEmbeddingWindowsBatch splits each chunk into windows and embeds every window of every
+// chunk through batched calls (EMBEDDING_BATCH_SIZE texts each). It returns the windows per
+// input chunk, preserving order; empty chunks get none. host selects the embedding server.
+func (r *RAG) createEmbeddingWindowsBatch(ctx context.Context, chunks []string, host string) ([][]EmbeddingWindow, error) {
+	if len(chunks) == 0 {
+		return nil, nil
+	}
 
-    // Distribute embeddings back to per-chunk windows
-    result := make([][]EmbeddingWindow, len(chunks))
-    for i := range result {
-        if perChunkCounts[i] > 0 {
-            result[i] = make([]EmbeddingWindow, 0, perChunkCounts[i])
-        }
-    }
+	result := make([][]EmbeddingWindow, len(chunks))
+	var texts []string
+	for ci, content := range chunks {
+		windows, err := r.splitEmbeddingWindows(ctx, content)
+		if err != nil {
+			return nil, err
+		}
+		result[ci] = windows
+		for _, w := range windows {
+			texts = append(texts, w.WindowText)
+		}
+	}
 
-    for i, w := range allWindows {
-        result[w.chunkIdx] = append(result[w.chunkIdx], EmbeddingWindow{
-            WindowIndex: w.windowIdx,
-            WindowStart: w.start,
-            WindowEnd:   w.end,
-            WindowText:  w.text,
-            Embedding:   embeddings[i],
-        })
-    }
+	embeddings, err := r.embedBatchWithHost(ctx, host, texts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings: %w", err)
+	}
+	if len(embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedding batch size mismatch: got %d, want %d", len(embeddings), len(texts))
+	}
 
-    return result, nil
+	next := 0
+	for ci := range result {
+		for wi := range result[ci] {
+			result[ci][wi].Embedding = embeddings[next]
+			next++
+		}
+	}
+	return result, nil
 }
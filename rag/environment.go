@@ -0,0 +1,65 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// StageEnvironment is the state card stage holding the session's execution environment.
+const StageEnvironment = "environment"
+
+// StoreEnvironmentCard stores the session's execution environment descriptor as a state
+// card and supersedes the previous one.
+func (r *RAG) StoreEnvironmentCard(ctx context.Context, sessionID, descriptor string) error {
+	if sessionID == "" {
+		return fmt.Errorf("session ID is required")
+	}
+
+	docID := uuid.New()
+	docs, err := r.store.ListStateDocuments(ctx, sessionID)
+	if err != nil {
+		r.logger.Warn("Failed to list state documents", zap.Error(err), zap.String("session_id", sessionID))
+	}
+	for _, doc := range docs {
+		if doc.Metadata["stage"] != StageEnvironment || doc.Metadata["state_status"] == "superseded" {
+			continue
+		}
+		meta := cloneStringMap(doc.Metadata)
+		meta["state_status"] = "superseded"
+		meta["superseded_by"] = docID.String()
+		if _, err := r.store.UpsertDocument(ctx, doc.ID, doc.Content, meta, doc.ContentHash); err != nil {
+			r.logger.Warn("Failed to supersede environment state", zap.Error(err), zap.String("document_id", doc.ID.String()))
+		}
+	}
+
+	content := fmt.Sprintf("[stage:%s]\n%s", StageEnvironment, strings.TrimSpace(descriptor))
+	md := map[string]string{
+		"session_id":         sessionID,
+		"role":               "state",
+		"type":               "state",
+		"stage":              StageEnvironment,
+		"source_type":        "executor",
+		"source_captured_at": time.Now().UTC().Format(time.RFC3339),
+		"state_status":       "active",
+	}
+	if _, err := r.store.UpsertDocument(ctx, docID, content, md, HashContent(NormalizeForHash(content))); err != nil {
+		return fmt.Errorf("failed to store environment state: %w", err)
+	}
+
+	windows, err := r.createEmbeddingWindows(ctx, content)
+	if err != nil {
+		r.logger.Warn("Failed to create embedding for environment state", zap.Error(err))
+		return nil
+	}
+	for _, w := range windows {
+		if e := r.store.CreateEmbedding(ctx, docID, w.WindowIndex, w.WindowStart, w.WindowEnd, w.WindowText, w.Embedding); e != nil {
+			r.logger.Warn("Failed to store embedding window for environment state", zap.Error(e))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package rag
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"stats-agent/config"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ControlArm is the experiment arm of sessions scored with the baseline HYBRID_* configuration.
+const ControlArm = "control"
+
+// Outcome signals recorded per experiment arm.
+const (
+	SignalHits     = "hits"     // memory documents returned by a hybrid query
+	SignalTurns    = "turns"    // agent turns needed to finish a run
+	SignalFeedback = "feedback" // user rating of an answer: 1 helpful, 0 not helpful
+)
+
+// experimentArm returns the arm a session is assigned to, or nil for control.
+// Assignment hashes the session ID, so a session stays in one arm for its lifetime
+// and its outcome signals are attributable to a single scoring configuration.
+func (r *RAG) experimentArm(sessionID string) *config.RetrievalArm {
+	if !r.cfg.RetrievalExperimentEnabled || sessionID == "" {
+		return nil
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(sessionID))
+	bucket := int(h.Sum32() % 100)
+
+	cumulative := 0
+	for i := range r.cfg.RetrievalExperimentArms {
+		cumulative += r.cfg.RetrievalExperimentArms[i].Percent
+		if bucket < cumulative {
+			return &r.cfg.RetrievalExperimentArms[i]
+		}
+	}
+	return nil
+}
+
+// ExperimentArmName returns the name of the session's experiment arm ("control" when unassigned).
+func (r *RAG) ExperimentArmName(sessionID string) string {
+	if arm := r.experimentArm(sessionID); arm != nil {
+		return arm.Name
+	}
+	return ControlArm
+}
+
+// RecordRetrievalOutcome stores an outcome signal for the session's experiment arm.
+// It is a no-op when the experiment is disabled. Failures are logged, never returned:
+// experiment bookkeeping must not affect the conversation.
+func (r *RAG) RecordRetrievalOutcome(sessionID, signal string, value float64) {
+	if !r.cfg.RetrievalExperimentEnabled {
+		return
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return
+	}
+
+	// Detached context: outcomes are often recorded as a run is being cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	arm := r.ExperimentArmName(sessionID)
+	if err := r.store.CreateRetrievalExperimentEvent(ctx, sessionUUID, arm, signal, value); err != nil {
+		r.logger.Warn("Failed to record retrieval experiment outcome",
+			zap.Error(err),
+			zap.String("session_id", sessionID),
+			zap.String("arm", arm),
+			zap.String("signal", signal))
+	}
+}
+
+// armWeight returns the arm's override when set, otherwise the baseline value.
+func armWeight(override, baseline float64) float64 {
+	if override > 0 {
+		return override
+	}
+	return baseline
+}
@@ -0,0 +1,80 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"stats-agent/prompts"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// DescribeFigure asks the summarization LLM for a short alt text of a captured figure,
+// based on the code that generated it and the output printed alongside.
+func (r *RAG) DescribeFigure(ctx context.Context, filename, code, output string) (string, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return "", fmt.Errorf("no generating code for figure %s", filename)
+	}
+
+	var user strings.Builder
+	user.WriteString("Figure file: ")
+	user.WriteString(filename)
+	user.WriteString("\n\nCode:\n")
+	user.WriteString(compressMiddle(code, 3000, 1500, 1500))
+	if output = strings.TrimSpace(output); output != "" {
+		user.WriteString("\n\nPrinted output:\n")
+		user.WriteString(compressMiddle(output, 1500, 1000, 500))
+	}
+	user.WriteString("\n\nReturn only the alt text.")
+
+	msgs := []types.AgentMessage{
+		{Role: "system", Content: prompts.FigureAltText()},
+		{Role: "user", Content: user.String()},
+	}
+
+	altText, err := r.llm.Chat(ctx, r.cfg.SummarizationLLMHost, msgs, nil)
+	if err != nil {
+		return "", fmt.Errorf("llm chat for figure alt text failed: %w", err)
+	}
+	altText = strings.Join(strings.Fields(altText), " ")
+	if altText == "" {
+		return "", fmt.Errorf("empty alt text for figure %s", filename)
+	}
+	return altText, nil
+}
+
+// StoreFigureDescription indexes a figure's alt text as a fact so the figure can be found
+// by what it shows.
+func (r *RAG) StoreFigureDescription(ctx context.Context, sessionID, filename, altText string) error {
+	content := fmt.Sprintf("Figure %s: %s", filename, altText)
+	contentHash := HashContent(NormalizeForHash(content))
+	md := map[string]string{
+		"session_id":         sessionID,
+		"role":               "fact",
+		"type":               "figure",
+		"filename":           filename,
+		"source_captured_at": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	docID, err := r.store.UpsertDocument(ctx, uuid.New(), content, md, contentHash)
+	if err != nil {
+		return fmt.Errorf("failed to store figure description: %w", err)
+	}
+
+	windows, err := r.createEmbeddingWindows(ctx, content)
+	if err != nil {
+		r.logger.Warn("Failed to create embedding for figure description", zap.Error(err))
+		return nil
+	}
+	for _, w := range windows {
+		if e := r.store.CreateEmbedding(ctx, docID, w.WindowIndex, w.WindowStart, w.WindowEnd, w.WindowText, w.Embedding); e != nil {
+			r.logger.Warn("Failed to store embedding window for figure description", zap.Error(e))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package rag
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// withIngestLock runs fn while holding the session's ingestion lock. With
+// MULTI_REPLICA_ENABLED it is a Postgres advisory lock, so two replicas never ingest into
+// the same session at once: their hash and near-duplicate checks would both miss the
+// other's documents. Without it ingestion runs unlocked as before; within one process
+// the ingestion queue already writes a session's batches one at a time.
+func (r *RAG) withIngestLock(ctx context.Context, sessionID string, fn func(ctx context.Context) error) error {
+	if !r.cfg.MultiReplicaEnabled {
+		return fn(ctx)
+	}
+	start := time.Now()
+	return r.store.WithAdvisoryLock(ctx, "rag-ingest:"+sessionID, func(ctx context.Context) error {
+		if waited := time.Since(start); waited > time.Second {
+			r.logger.Debug("Waited for RAG ingestion lock",
+				zap.String("session_id", sessionID),
+				zap.Duration("waited", waited))
+		}
+		return fn(ctx)
+	})
+}
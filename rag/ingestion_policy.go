@@ -0,0 +1,92 @@
+package rag
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// uploadNotificationMarker starts the file upload line prepended to user messages.
+// The line is a UI notification, never part of the user's question.
+const uploadNotificationMarker = "[📎 File uploaded:"
+
+// IngestionPolicy decides which messages are stored in RAG and which stored documents
+// may be retrieved. Messages with an excluded role or content matching an excluded
+// pattern are never stored; documents stored before a rule was added are filtered
+// out at retrieval.
+type IngestionPolicy struct {
+	excludedRoles    map[string]bool
+	excludedPatterns []*regexp.Regexp
+}
+
+// NewIngestionPolicy builds a policy from excluded roles and content regex patterns.
+func NewIngestionPolicy(excludedRoles, excludedPatterns []string) (*IngestionPolicy, error) {
+	p := &IngestionPolicy{excludedRoles: make(map[string]bool, len(excludedRoles))}
+	for _, role := range excludedRoles {
+		if role = strings.ToLower(strings.TrimSpace(role)); role != "" {
+			p.excludedRoles[role] = true
+		}
+	}
+	for _, pattern := range excludedPatterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RAG exclusion pattern %q: %w", pattern, err)
+		}
+		p.excludedPatterns = append(p.excludedPatterns, re)
+	}
+	return p, nil
+}
+
+// Admit returns the content to store for a message and whether it may be stored at all.
+// Upload notification lines are stripped from user messages; a message left empty
+// after stripping is not stored (uploaded PDFs are stored separately).
+func (p *IngestionPolicy) Admit(role, content string) (string, bool) {
+	if role == "user" {
+		content = stripUploadNotifications(content)
+	}
+	if strings.TrimSpace(content) == "" {
+		return "", false
+	}
+	if p.excluded(role, content) {
+		return "", false
+	}
+	return content, true
+}
+
+// Retrievable reports whether a stored document may be returned from a search.
+func (p *IngestionPolicy) Retrievable(role, content string) bool {
+	return !p.excluded(role, content)
+}
+
+func (p *IngestionPolicy) excluded(role, content string) bool {
+	if p == nil {
+		return false
+	}
+	if p.excludedRoles[strings.ToLower(role)] {
+		return true
+	}
+	for _, re := range p.excludedPatterns {
+		if re.MatchString(content) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripUploadNotifications removes file upload notification lines and blank lines.
+func stripUploadNotifications(content string) string {
+	if !strings.Contains(content, uploadNotificationMarker) {
+		return content
+	}
+	lines := strings.Split(content, "\n")
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if !strings.Contains(line, uploadNotificationMarker) && strings.TrimSpace(line) != "" {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
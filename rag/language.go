@@ -0,0 +1,71 @@
+package rag
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DefaultLanguage is the text search configuration used for content without a detected language.
+const DefaultLanguage = "english"
+
+// EmbeddingModelMultilingual marks documents embedded by the multilingual embedding host.
+// Their vectors live in a different space and are only searched with multilingual query embeddings.
+const EmbeddingModelMultilingual = "multilingual"
+
+// languageStopwords holds high-frequency function words per language. Keys are
+// PostgreSQL text search configuration names, so a detected language can be used
+// directly as the BM25 tsvector/tsquery configuration.
+var languageStopwords = map[string][]string{
+	"english":    {"the", "and", "of", "to", "in", "is", "that", "for", "with", "are", "was", "this", "were", "by", "from"},
+	"german":     {"der", "die", "und", "das", "ist", "nicht", "mit", "den", "von", "zu", "ein", "eine", "auf", "wurde", "sich"},
+	"french":     {"le", "la", "les", "et", "des", "est", "une", "dans", "pour", "que", "qui", "du", "sur", "pas", "avec"},
+	"spanish":    {"el", "la", "los", "las", "y", "que", "de", "en", "es", "una", "por", "con", "para", "del", "se"},
+	"italian":    {"il", "la", "di", "che", "e", "per", "una", "sono", "con", "del", "della", "non", "gli", "nel", "è"},
+	"portuguese": {"o", "os", "as", "e", "que", "de", "em", "um", "uma", "para", "com", "não", "do", "da", "foi"},
+	"dutch":      {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "met", "voor", "zijn", "werd", "ook", "bij"},
+	"swedish":    {"och", "att", "det", "som", "en", "på", "är", "av", "för", "med", "till", "den", "inte", "har", "var"},
+	"danish":     {"og", "at", "det", "som", "en", "på", "er", "af", "for", "med", "til", "den", "ikke", "har", "blev"},
+	"finnish":    {"ja", "on", "ei", "että", "oli", "se", "myös", "kun", "tai", "ovat", "mutta", "sen", "joka", "tämä", "niin"},
+	"russian":    {"и", "в", "не", "на", "что", "с", "по", "как", "это", "для", "из", "от", "к", "были", "при"},
+	"turkish":    {"ve", "bir", "bu", "da", "de", "için", "ile", "olarak", "olan", "daha", "çok", "gibi", "en", "ise", "kadar"},
+}
+
+// Minimum stopword hits before a non-English language is accepted.
+const minLanguageHits = 8
+
+// DetectLanguage returns the dominant language of text as a PostgreSQL text search
+// configuration name, using stopword frequencies. Falls back to DefaultLanguage
+// when the text is short or no language clearly dominates.
+func DetectLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if len(words) == 0 {
+		return DefaultLanguage
+	}
+
+	counts := make(map[string]int, len(words))
+	for _, w := range words {
+		counts[w]++
+	}
+
+	best, bestHits, englishHits := DefaultLanguage, 0, 0
+	for lang, stopwords := range languageStopwords {
+		hits := 0
+		for _, sw := range stopwords {
+			hits += counts[sw]
+		}
+		if lang == DefaultLanguage {
+			englishHits = hits
+		}
+		if hits > bestHits || (hits == bestHits && lang < best) {
+			best, bestHits = lang, hits
+		}
+	}
+
+	// Stay with English unless another language clearly dominates
+	if best != DefaultLanguage && (bestHits < minLanguageHits || bestHits < englishHits*3/2) {
+		return DefaultLanguage
+	}
+	return best
+}
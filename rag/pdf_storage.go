@@ -3,6 +3,8 @@ package rag
 import (
     "context"
     "fmt"
+    "math"
+    "strconv"
     "strings"
     "stats-agent/pdf"
 
@@ -16,19 +18,55 @@ func (r *RAG) AddPDFPagesToRAG(ctx context.Context, sessionID, filename string,
 	if len(pages) == 0 {
 		return nil
 	}
+	return r.withIngestLock(ctx, sessionID, func(ctx context.Context) error {
+		return r.addPDFPagesToRAG(ctx, sessionID, filename, pages)
+	})
+}
 
+func (r *RAG) addPDFPagesToRAG(ctx context.Context, sessionID, filename string, pages []pdf.Page) error {
     pagesAdded := 0
     chunksCreated := 0
-    var pageOneText string
+    var leadingText strings.Builder
+
+    // Detect the dominant language from the first pages; it drives BM25 stemming and,
+    // for non-English papers, routing to the multilingual embedding host when configured
+    var sample strings.Builder
+    for _, page := range pages {
+        if sample.Len() > 20000 {
+            break
+        }
+        sample.WriteString(page.Text)
+        sample.WriteString("\n")
+    }
+    language := DetectLanguage(sample.String())
+    // A scanned document is flagged on every page it stores, so answers can say so
+    needsOCR := pdf.ScorePages(pages).NeedsOCR
+    multilingual := language != DefaultLanguage && r.cfg.MultilingualEmbeddingHost != ""
+    r.logger.Info("Detected PDF language",
+        zap.String("filename", filename),
+        zap.String("language", language),
+        zap.Bool("multilingual_embedding", multilingual))
+    host := r.cfg.EmbeddingLLMHost
+    if multilingual {
+        host = r.cfg.MultilingualEmbeddingHost
+    }
+
+    // Pages that fit in one chunk are stored as the loop goes and embedded together afterwards
+    var (
+        pageContents []string
+        pageDocIDs   []uuid.UUID
+        pageNumbers  []int
+    )
 
 	for _, page := range pages {
 		if page.Text == "" {
 			continue // Skip empty pages
 		}
 
-        // Capture page 1 text for a key-facts summary later
-        if page.PageNumber == 1 {
-            pageOneText = page.Text
+        // Capture the opening pages for the key-facts summary
+        if leadingText.Len() < r.cfg.PDFSummarySourceChars {
+            leadingText.WriteString(page.Text)
+            leadingText.WriteString("\n\n")
         }
 
         // Create document ID and content hash
@@ -43,6 +81,15 @@ func (r *RAG) AddPDFPagesToRAG(ctx context.Context, sessionID, filename string,
 			"type":        "pdf",
 			"filename":    filename,
 			"page_number": fmt.Sprintf("%d", page.PageNumber),
+			"language":    language,
+		}
+		// Down-weights garbled pages in retrieval (see qualityWeight)
+		metadata["extraction_quality"] = strconv.FormatFloat(pdf.ScorePage(page.Text).Score, 'f', 2, 64)
+		if needsOCR {
+			metadata["needs_ocr"] = "true"
+		}
+		if multilingual {
+			metadata["embedding_model"] = EmbeddingModelMultilingual
 		}
 
 		// Content for embedding - just the text without prefix
@@ -84,67 +131,53 @@ func (r *RAG) AddPDFPagesToRAG(ctx context.Context, sessionID, filename string,
 				continue
 			}
 
-			// Create embedding windows (may be 1 or more depending on page length)
-			windows, err := r.createEmbeddingWindows(ctx, fullContent)
-			if err != nil {
-				r.logger.Warn("Failed to create embedding windows for PDF page",
-					zap.Error(err),
-					zap.String("filename", filename),
-					zap.Int("page", page.PageNumber))
-				continue
-			}
-
-			// Store all embedding windows
-			for _, window := range windows {
-				if err := r.store.CreateEmbedding(ctx, docID, window.WindowIndex, window.WindowStart, window.WindowEnd, window.WindowText, window.Embedding); err != nil {
-					r.logger.Warn("Failed to store embedding window for PDF page",
-						zap.Error(err),
-						zap.String("filename", filename),
-						zap.Int("page", page.PageNumber),
-						zap.Int("window_index", window.WindowIndex))
-					// Continue with other windows
-				}
-			}
-
-			r.logger.Debug("Stored PDF page with multiple embedding windows",
-				zap.String("filename", filename),
-				zap.Int("page", page.PageNumber),
-				zap.Int("windows", len(windows)))
-			pagesAdded++
+			pageContents = append(pageContents, fullContent)
+			pageDocIDs = append(pageDocIDs, docID)
+			pageNumbers = append(pageNumbers, page.PageNumber)
 		}
 	}
 
+    // Embed the windows of every single-chunk page (1 or more per page) in batches
+    if len(pageContents) > 0 {
+        windowsPerPage, err := r.createEmbeddingWindowsBatch(ctx, pageContents, host)
+        if err != nil {
+            r.logger.Warn("Failed to create embedding windows for PDF pages",
+                zap.Error(err),
+                zap.String("filename", filename),
+                zap.Int("pages", len(pageContents)))
+        }
+        for i, windows := range windowsPerPage {
+            for _, window := range windows {
+                if err := r.store.CreateEmbedding(ctx, pageDocIDs[i], window.WindowIndex, window.WindowStart, window.WindowEnd, window.WindowText, window.Embedding); err != nil {
+                    r.logger.Warn("Failed to store embedding window for PDF page",
+                        zap.Error(err),
+                        zap.String("filename", filename),
+                        zap.Int("page", pageNumbers[i]),
+                        zap.Int("window_index", window.WindowIndex))
+                    // Continue with other windows
+                }
+            }
+            r.logger.Debug("Stored PDF page with multiple embedding windows",
+                zap.String("filename", filename),
+                zap.Int("page", pageNumbers[i]),
+                zap.Int("windows", len(windows)))
+            pagesAdded++
+        }
+    }
+
     if pagesAdded == 0 && chunksCreated == 0 {
         r.logger.Warn("No PDF pages could be embedded", zap.String("filename", filename))
         return nil
     }
 
-    // After we have embedded, generate and persist a short Key Facts summary from page 1
-    if strings.TrimSpace(pageOneText) != "" {
-        sumCtx, cancel := context.WithTimeout(ctx, r.cfg.LLMRequestTimeout)
-        summary, err := r.SummarizePDFKeyFacts(sumCtx, filename, pageOneText)
-        cancel()
-        if err != nil {
-            r.logger.Warn("Failed to generate PDF key facts summary", zap.Error(err), zap.String("filename", filename))
-        } else {
-            summaryID := uuid.New()
-            meta := map[string]string{
-                "session_id":  sessionID,
-                "document_id": summaryID.String(),
-                "role":        "summary",
-                "type":        "pdf_summary",
-                "filename":    filename,
-                "page_number": "1",
-            }
-            r.persistSummaryDocument(ctx, &summaryDocument{
-                ID:       summaryID.String(),
-                Content:  summary,
-                Metadata: meta,
-            })
-            r.logger.Info("Stored PDF key facts summary",
-                zap.String("filename", filename),
-                zap.String("summary_id", summaryID.String()))
-        }
+    // Summarize objectives, methods, and key results in the background: the LLM call
+    // outlives the upload request, and chunk retrieval works before the summary lands
+    opening := leadingText.String()
+    if runes := []rune(opening); len(runes) > r.cfg.PDFSummarySourceChars {
+        opening = string(runes[:r.cfg.PDFSummarySourceChars])
+    }
+    if strings.TrimSpace(opening) != "" {
+        go r.storePDFKeyFacts(sessionID, filename, opening)
     }
 
     r.logger.Info("Added PDF pages to RAG",
@@ -154,3 +187,50 @@ func (r *RAG) AddPDFPagesToRAG(ctx context.Context, sessionID, filename string,
 
     return nil
 }
+
+// storePDFKeyFacts generates the key-facts overview for a PDF and stores it as a
+// pdf_summary document, which hybrid scoring boosts for broad questions about the paper.
+func (r *RAG) storePDFKeyFacts(sessionID, filename, leadingText string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.LLMRequestTimeout)
+	defer cancel()
+
+	summary, err := r.SummarizePDFKeyFacts(ctx, filename, leadingText)
+	if err != nil {
+		r.logger.Warn("Failed to generate PDF key facts summary", zap.Error(err), zap.String("filename", filename))
+		return
+	}
+
+	summaryID := uuid.New()
+	r.persistSummaryDocument(ctx, &summaryDocument{
+		ID:      summaryID.String(),
+		Content: summary,
+		Metadata: map[string]string{
+			"session_id":  sessionID,
+			"document_id": summaryID.String(),
+			"role":        "summary",
+			"type":        "pdf_summary",
+			"filename":    filename,
+			"page_number": "1",
+		},
+	})
+	r.logger.Info("Stored PDF key facts summary",
+		zap.String("filename", filename),
+		zap.String("summary_id", summaryID.String()))
+}
+
+// qualityWeight scales the score of a PDF page or chunk by its extraction quality, from
+// PDF_QUALITY_MIN_WEIGHT for unreadable text to 1 for clean text. Documents stored
+// without a score are not affected.
+func (r *RAG) qualityWeight(metadata map[string]string) float64 {
+    raw, ok := metadata["extraction_quality"]
+    if !ok {
+        return 1
+    }
+    quality, err := strconv.ParseFloat(raw, 64)
+    if err != nil {
+        return 1
+    }
+    quality = math.Max(0, math.Min(1, quality))
+    floor := r.cfg.PDFQualityMinWeight
+    return floor + (1-floor)*quality
+}
@@ -10,6 +10,8 @@ import (
 )
 
 func (r *RAG) DeleteSessionDocuments(sessionID string) error {
+	r.forgetIngestQueue(sessionID)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -36,5 +38,8 @@ func (r *RAG) DeleteSessionDocuments(sessionID string) error {
 	}
 
 	r.clearSessionDataset(sessionID)
+	r.forgetCheckpointScope(sessionID, uuid.Nil)
+	r.ResetMemoryCitations(sessionID)
+	r.SetSessionRetrievalPolicy(sessionID, "")
 	return nil
 }
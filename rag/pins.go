@@ -0,0 +1,108 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// MaxPins caps a session's pinned facts, since every memory block carries all of them.
+const MaxPins = 20
+
+// pinnedKey is the metadata flag of pinned documents.
+const pinnedKey = "pinned"
+
+// isPinned reports whether a document is a pinned fact. Pins are prepended to the memory
+// block by prependPins, so ranked retrieval skips them.
+func isPinned(metadata map[string]string) bool {
+	return metadata[pinnedKey] == "true"
+}
+
+// StorePin pins a fact to session memory. Pins are not embedded: they are never ranked,
+// only prepended to every memory block.
+func (r *RAG) StorePin(ctx context.Context, sessionID uuid.UUID, text string) (types.MemoryPin, error) {
+	md := map[string]string{
+		"session_id":         sessionID.String(),
+		"role":               "pin",
+		"type":               "pin",
+		pinnedKey:            "true",
+		"source_captured_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	docID, err := r.store.UpsertDocument(ctx, uuid.New(), text, md, HashContent(NormalizeForHash(text)))
+	if err != nil {
+		return types.MemoryPin{}, fmt.Errorf("failed to store pin: %w", err)
+	}
+	return types.MemoryPin{ID: docID, SessionID: sessionID, Text: text, CreatedAt: time.Now().UTC()}, nil
+}
+
+// ListPins returns the session's pinned facts, oldest first.
+func (r *RAG) ListPins(ctx context.Context, sessionID uuid.UUID) ([]types.MemoryPin, error) {
+	docs, err := r.store.QueryDocumentsByMetadata(ctx, map[string]string{"session_id": sessionID.String(), pinnedKey: "true"}, MaxPins)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pins: %w", err)
+	}
+	pins := make([]types.MemoryPin, 0, len(docs))
+	for i := len(docs) - 1; i >= 0; i-- {
+		pins = append(pins, types.MemoryPin{
+			ID:        docs[i].ID,
+			SessionID: sessionID,
+			Text:      docs[i].Content,
+			CreatedAt: docs[i].CreatedAt,
+		})
+	}
+	return pins, nil
+}
+
+// DeletePin unpins a fact. Returns false when pinID is not one of the session's pins.
+func (r *RAG) DeletePin(ctx context.Context, sessionID, pinID uuid.UUID) (bool, error) {
+	pins, err := r.ListPins(ctx, sessionID)
+	if err != nil {
+		return false, err
+	}
+	for _, pin := range pins {
+		if pin.ID != pinID {
+			continue
+		}
+		if err := r.store.DeleteRAGDocument(ctx, pinID); err != nil {
+			return false, fmt.Errorf("failed to delete pin: %w", err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// prependPins puts the session's pinned facts at the top of memory, opening a memory block
+// when retrieval found nothing. Failing to load pins is logged and memory is returned as is.
+func (r *RAG) prependPins(ctx context.Context, sessionID, memory string) string {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return memory
+	}
+	pins, err := r.ListPins(ctx, sessionUUID)
+	if err != nil {
+		r.logger.Warn("Failed to load pinned facts", zap.Error(err), zap.String("session_id", sessionID))
+		return memory
+	}
+	if len(pins) == 0 {
+		return memory
+	}
+
+	var b strings.Builder
+	b.WriteString("<memory>\n")
+	for _, pin := range pins {
+		fmt.Fprintf(&b, "- pinned: %s\n", pin.Text)
+	}
+	if body, ok := strings.CutPrefix(memory, "<memory>\n"); ok {
+		b.WriteString(body)
+	} else {
+		b.WriteString("</memory>\n")
+		b.WriteString(memory)
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// StagePlan is the state card stage holding the session's analysis plan and which of its
+// steps are done.
+const StagePlan = "plan"
+
+// StorePlanCard stores the session's analysis plan as a state card and supersedes the
+// previous one. The planner re-stores the card whenever a step is completed.
+func (r *RAG) StorePlanCard(ctx context.Context, sessionID, plan string) error {
+	if sessionID == "" {
+		return fmt.Errorf("session ID is required")
+	}
+
+	docID := uuid.New()
+	r.supersedePlanCards(ctx, sessionID, docID.String())
+
+	content := fmt.Sprintf("[stage:%s]\n%s", StagePlan, strings.TrimSpace(plan))
+	md := map[string]string{
+		"session_id":         sessionID,
+		"role":               "state",
+		"type":               "state",
+		"stage":              StagePlan,
+		"source_type":        "planner",
+		"source_captured_at": time.Now().UTC().Format(time.RFC3339),
+		"state_status":       "active",
+	}
+	if _, err := r.store.UpsertDocument(ctx, docID, content, md, HashContent(NormalizeForHash(content))); err != nil {
+		return fmt.Errorf("failed to store plan state: %w", err)
+	}
+
+	windows, err := r.createEmbeddingWindows(ctx, content)
+	if err != nil {
+		r.logger.Warn("Failed to create embedding for plan state", zap.Error(err))
+		return nil
+	}
+	for _, w := range windows {
+		if e := r.store.CreateEmbedding(ctx, docID, w.WindowIndex, w.WindowStart, w.WindowEnd, w.WindowText, w.Embedding); e != nil {
+			r.logger.Warn("Failed to store embedding window for plan state", zap.Error(e))
+		}
+	}
+	return nil
+}
+
+// ActivePlanCard returns the body of the session's active plan card (without the stage
+// header), or "" when the session has no plan.
+func (r *RAG) ActivePlanCard(ctx context.Context, sessionID string) string {
+	docs, err := r.store.ListStateDocuments(ctx, sessionID)
+	if err != nil {
+		r.logger.Warn("Failed to list state documents", zap.Error(err), zap.String("session_id", sessionID))
+		return ""
+	}
+	for _, doc := range docs {
+		if doc.Metadata["stage"] != StagePlan || doc.Metadata["state_status"] == "superseded" {
+			continue
+		}
+		_, body, _ := strings.Cut(doc.Content, "\n")
+		return strings.TrimSpace(body)
+	}
+	return ""
+}
+
+func (r *RAG) supersedePlanCards(ctx context.Context, sessionID, supersededBy string) {
+	docs, err := r.store.ListStateDocuments(ctx, sessionID)
+	if err != nil {
+		r.logger.Warn("Failed to list state documents", zap.Error(err), zap.String("session_id", sessionID))
+		return
+	}
+	for _, doc := range docs {
+		if doc.Metadata["stage"] != StagePlan || doc.Metadata["state_status"] == "superseded" {
+			continue
+		}
+		meta := cloneStringMap(doc.Metadata)
+		meta["state_status"] = "superseded"
+		meta["superseded_by"] = supersededBy
+		if _, err := r.store.UpsertDocument(ctx, doc.ID, doc.Content, meta, doc.ContentHash); err != nil {
+			r.logger.Warn("Failed to supersede plan state", zap.Error(err), zap.String("document_id", doc.ID.String()))
+		}
+	}
+}
@@ -2,18 +2,40 @@ package rag
 
 import (
 	"context"
+	"time"
 
+	"stats-agent/config"
+	"stats-agent/metrics"
+	"stats-agent/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
-func (r *RAG) Query(ctx context.Context, sessionID string, query string, nResults int, excludeHashes []string, historyDocIDs []string, doneLedger string, mode string) (string, error) {
+// Query retrieves session memory for query. The budget caps how many entries each
+// retrieval category (facts, state cards, document chunks, user messages) contributes.
+// The session's pinned facts head the result whatever was retrieved.
+func (r *RAG) Query(ctx context.Context, sessionID string, query string, budget config.RetrievalBudget, excludeHashes []string, historyDocIDs []string, doneLedger string, mode string) (result string, err error) {
+	ctx, span := tracing.Start(ctx, "rag.query", tracing.Session(sessionID), tracing.AttrMode.String(mode))
+	defer span.End()
+	start := time.Now()
+	defer func() { metrics.RAGQueryDuration.ObserveSince(start, mode, metrics.Outcome(err)) }()
+	defer func() {
+		if err == nil {
+			result = r.prependPins(ctx, sessionID, result)
+		}
+	}()
+
 	expandedQuery := r.expandQuery(query)
-	context, hits, err := r.queryHybrid(ctx, sessionID, expandedQuery, nResults, excludeHashes, historyDocIDs, doneLedger, mode)
+	context, hits, err := r.queryHybrid(ctx, sessionID, expandedQuery, budget, excludeHashes, historyDocIDs, doneLedger, mode)
 	if err != nil {
+		span.RecordError(err)
 		return "", err
 	}
+	span.SetAttributes(attribute.Int("rag.hits", hits))
 
-	if hits > 0 || !r.cfg.EnableMetadataFallback {
+	// The metadata fallback reads the current memory, so it is skipped while scoped to a checkpoint
+	if _, scoped := r.RetrievalCheckpoint(sessionID); hits > 0 || scoped || !r.cfg.EnableMetadataFallback {
 		return context, nil
 	}
 
@@ -26,7 +48,7 @@ func (r *RAG) Query(ctx context.Context, sessionID string, query string, nResult
 		zap.String("query", query),
 		zap.Any("filters", filters))
 
-	fallbackContext, err := r.QueryByMetadata(ctx, sessionID, filters, nResults)
+	fallbackContext, err := r.QueryByMetadata(ctx, sessionID, filters, budget.Total())
 	if err != nil {
 		return "", err
 	}
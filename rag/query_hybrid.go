@@ -10,7 +10,12 @@ import (
 	"strings"
 	"unicode"
 
+	"stats-agent/config"
+	"stats-agent/database"
+	"stats-agent/tracing"
+
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 )
 
@@ -150,21 +155,27 @@ func (r *RAG) getRelevantContentBatch(ctx context.Context, candidates []*hybridC
 	return result, nil
 }
 
-func (r *RAG) queryHybrid(ctx context.Context, sessionID string, query string, nResults int, excludeHashes []string, historyDocIDs []string, doneLedger string, mode string) (string, int, error) {
-	if nResults <= 0 {
+func (r *RAG) queryHybrid(ctx context.Context, sessionID string, query string, budget config.RetrievalBudget, excludeHashes []string, historyDocIDs []string, doneLedger string, mode string) (string, int, error) {
+	if budget.Total() <= 0 {
 		return "", 0, nil
 	}
 
-	candidateLimit := max(nResults*4, 20)
-	maxHybridCandidates := r.maxHybridCandidates
+	// Weights, thresholds and candidate limits come from the session's retrieval policy
+	policy := r.retrievalPolicy(sessionID, mode)
+	multiplier := policy.CandidateMultiplier
+	if multiplier <= 0 {
+		multiplier = 4
+	}
+	candidateLimit := max(budget.Total()*multiplier, policy.MinCandidates)
+	maxHybridCandidates := policy.MaxCandidates
 	if maxHybridCandidates <= 0 {
-		maxHybridCandidates = r.cfg.MaxHybridCandidates
+		maxHybridCandidates = r.maxHybridCandidates
 	}
-	minSemanticSimilarity := r.cfg.SemanticSimilarityThreshold
+	minSemanticSimilarity := policy.SemanticThreshold
 	if minSemanticSimilarity <= 0 || minSemanticSimilarity > 1 {
 		minSemanticSimilarity = 0.7
 	}
-	minBM25Score := r.cfg.BM25ScoreThreshold
+	minBM25Score := policy.BM25Threshold
 	if minBM25Score < 0 {
 		minBM25Score = 0
 	}
@@ -196,20 +207,28 @@ func (r *RAG) queryHybrid(ctx context.Context, sessionID string, query string, n
 		}
 	}
 
+	if _, exists := metadataHints["artifact_type"]; !exists {
+		if artifactType := artifactTypeForQuery(lowerQuery); artifactType != "" {
+			metadataHints["artifact_type"] = artifactType
+		}
+	}
+
 	// 1) Gather candidates (vector + bm25 + batch parent content)
-	candidates, docContents, err := r.gatherCandidates(ctx, sessionID, query, candidateLimit, excludeHashes, minSemanticSimilarity, minBM25Score)
+	candidates, docContents, err := r.gatherCandidates(ctx, sessionID, query, candidateLimit, excludeHashes, minSemanticSimilarity, minBM25Score, WantsFullHistory(query))
 	if err != nil {
 		r.logger.Warn("gatherCandidates failed", zap.Error(err))
 	}
+	r.applyCheckpointScope(sessionID, candidates, docContents)
 	if len(candidates) == 0 {
+		r.RecordRetrievalOutcome(sessionID, SignalHits, 0)
 		return "", 0, nil
 	}
 
 	// 2) Score and rank hybrid
-	candidateList := r.scoreHybrid(query, mode, metadataHints, candidates, isQueryForError)
+	candidateList := r.scoreHybrid(query, policy, metadataHints, candidates, isQueryForError)
 
-	// 3) Filter by history
-	filtered1 := r.filterHistory(candidateList, historyDocIDs)
+	// 3) Filter by history, then optionally reorder the top candidates with a cross-encoder
+	filtered1 := r.rerankCandidates(ctx, sessionID, query, r.filterHistory(candidateList, historyDocIDs))
 
 	// 4) Bucket summaries
 	filtered2 := r.bucketSummaries(filtered1)
@@ -218,77 +237,157 @@ func (r *RAG) queryHybrid(ctx context.Context, sessionID string, query string, n
 	filtered3 := r.deduplicateShingles(filtered2, excludeHashes)
 
 	// 6) Format output memory block
-	return r.formatMemoryBlock(ctx, filtered3, nResults, doneLedger, docContents, excludeHashes)
+	memory, hits, err := r.formatMemoryBlock(ctx, sessionID, filtered3, budget, doneLedger, docContents, excludeHashes)
+	if err == nil {
+		r.RecordRetrievalOutcome(sessionID, SignalHits, float64(hits))
+	}
+	return memory, hits, err
 }
 
 // gatherCandidates performs vector and BM25 searches, merges signals into candidates,
-// and primes candidate.Content using a batch document fetch for parent content.
-func (r *RAG) gatherCandidates(ctx context.Context, sessionID, query string, candidateLimit int, excludeHashes []string, minSemanticSimilarity, minBM25Score float64) (map[string]*hybridCandidate, map[string]string, error) {
+// and primes candidate.Content using a batch document fetch for parent content. The
+// archived tier is searched too when includeArchived is set.
+func (r *RAG) gatherCandidates(ctx context.Context, sessionID, query string, candidateLimit int, excludeHashes []string, minSemanticSimilarity, minBM25Score float64, includeArchived bool) (map[string]*hybridCandidate, map[string]string, error) {
 	candidates := make(map[string]*hybridCandidate)
 
+	addSemantic := func(semanticResults []database.VectorSearchResult) {
+		for _, res := range semanticResults {
+			docID := res.DocumentID.String()
+			similarity := res.Similarity
+			if similarity < minSemanticSimilarity {
+				continue
+			}
+			embContent := res.EmbeddingContent
+			if embContent == "" {
+				embContent = res.Content
+			}
+			cand := ensureCandidate(candidates, docID, res.Metadata)
+			if similarity > cand.SemanticScore {
+				cand.SemanticScore = similarity
+				cand.Content = embContent
+				cand.WindowIndex = res.WindowIndex
+			}
+			cand.HasSemantic = true
+		}
+	}
+
+	addBM25 := func(bm25Results []database.BM25SearchResult) {
+		for _, bm := range bm25Results {
+			docID := bm.DocumentID.String()
+			combined := bm.BM25Score + bm.ExactMatchBonus
+			if combined < minBM25Score {
+				continue
+			}
+			cand := ensureCandidate(candidates, docID, bm.Metadata)
+			existingCombined := cand.BM25Score + cand.ExactBonus
+			embContent := bm.EmbeddingContent
+			if embContent == "" {
+				embContent = bm.Content
+			}
+			if combined > existingCombined {
+				cand.BM25Score = bm.BM25Score
+				cand.ExactBonus = bm.ExactMatchBonus
+				if embContent != "" {
+					cand.Content = embContent
+				}
+			} else if cand.Content == "" && embContent != "" {
+				cand.Content = embContent
+			}
+			cand.HasBM25 = true
+		}
+	}
+
+	// Hot documents are scoped to the active dataset unless the query asks across datasets
+	dataset := r.retrievalDataset(ctx, sessionID, query)
+	if dataset != "" {
+		r.logger.Debug("Scoping retrieval to the active dataset", zap.String("session_id", sessionID), zap.String("dataset", dataset))
+	}
+
 	// Vector search
-	queryEmbedding, err := r.embedder(ctx, query)
+	vectorCtx, vectorSpan := tracing.Start(ctx, "rag.vector_search", tracing.Session(sessionID))
+	queryEmbedding, err := r.embedder(vectorCtx, query)
 	if err != nil {
 		r.logger.Warn("Failed to generate query embedding, using BM25 fallback only", zap.Error(err))
+		vectorSpan.RecordError(err)
 	} else if len(queryEmbedding) > 0 {
-		semanticResults, err := r.store.VectorSearchRAGDocuments(ctx, queryEmbedding, candidateLimit, sessionID, excludeHashes)
+		semanticResults, err := r.store.VectorSearchRAGDocumentsForModel(vectorCtx, queryEmbedding, candidateLimit, sessionID, excludeHashes, "", dataset)
 		if err != nil {
 			r.logger.Warn("Vector search failed, using BM25 fallback only", zap.Error(err))
+			vectorSpan.RecordError(err)
 		} else {
-			for _, res := range semanticResults {
-				docID := res.DocumentID.String()
-				similarity := res.Similarity
-				if similarity < minSemanticSimilarity {
-					continue
-				}
-				embContent := res.EmbeddingContent
-				if embContent == "" {
-					embContent = res.Content
-				}
-				cand := ensureCandidate(candidates, docID, res.Metadata)
-				if similarity > cand.SemanticScore {
-					cand.SemanticScore = similarity
-					cand.Content = embContent
-					cand.WindowIndex = res.WindowIndex
-				}
-				cand.HasSemantic = true
-			}
+			addSemantic(semanticResults)
+			vectorSpan.SetAttributes(attribute.Int("rag.results", len(semanticResults)))
 		}
 	}
+	vectorSpan.End()
 
 	// BM25 search
-	bm25Results, err := r.store.SearchRAGDocumentsBM25(ctx, query, candidateLimit, sessionID, excludeHashes)
+	bm25Ctx, bm25Span := tracing.Start(ctx, "rag.bm25_search", tracing.Session(sessionID))
+	bm25Results, err := r.store.SearchRAGDocumentsBM25Language(bm25Ctx, query, candidateLimit, sessionID, excludeHashes, DefaultLanguage, dataset)
+	bm25Span.SetAttributes(attribute.Int("rag.results", len(bm25Results)))
+	tracing.End(bm25Span, err)
 	if err != nil {
 		r.logger.Warn("BM25 search failed, falling back to semantic results only", zap.Error(err), zap.Int("candidate_limit", candidateLimit), zap.String("session_id", sessionID))
 		bm25Results = nil
 	}
-	for _, bm := range bm25Results {
-		docID := bm.DocumentID.String()
-		combined := bm.BM25Score + bm.ExactMatchBonus
-		if combined < minBM25Score {
-			continue
+	addBM25(bm25Results)
+
+	// Archived conversation chunks are only searched on demand, and only within the session
+	if includeArchived && sessionID != "" {
+		if len(queryEmbedding) > 0 {
+			archivedResults, err := r.store.VectorSearchArchivedRAGDocuments(ctx, queryEmbedding, candidateLimit, sessionID, "")
+			if err != nil {
+				r.logger.Warn("Archived vector search failed", zap.Error(err), zap.String("session_id", sessionID))
+			}
+			addSemantic(archivedResults)
 		}
-		cand := ensureCandidate(candidates, docID, bm.Metadata)
-		existingCombined := cand.BM25Score + cand.ExactBonus
-		embContent := bm.EmbeddingContent
-		if embContent == "" {
-			embContent = bm.Content
+		archivedBM25, err := r.store.SearchArchivedRAGDocumentsBM25(ctx, query, candidateLimit, sessionID)
+		if err != nil {
+			r.logger.Warn("Archived BM25 search failed", zap.Error(err), zap.String("session_id", sessionID))
 		}
-		if combined > existingCombined {
-			cand.BM25Score = bm.BM25Score
-			cand.ExactBonus = bm.ExactMatchBonus
-			if embContent != "" {
-				cand.Content = embContent
+		addBM25(archivedBM25)
+	}
+
+	// Non-English documents: search them with their own stemming and embedding space
+	if sessionID != "" {
+		languages, models, err := r.store.GetSessionDocumentLanguages(ctx, sessionID)
+		if err != nil {
+			r.logger.Warn("Failed to load session document languages, searching English only", zap.Error(err), zap.String("session_id", sessionID))
+		}
+		for _, language := range languages {
+			if language == DefaultLanguage {
+				continue
+			}
+			langResults, err := r.store.SearchRAGDocumentsBM25Language(ctx, query, candidateLimit, sessionID, excludeHashes, language, dataset)
+			if err != nil {
+				r.logger.Warn("Language BM25 search failed", zap.Error(err), zap.String("language", language))
+				continue
+			}
+			addBM25(langResults)
+		}
+		for _, model := range models {
+			if model != EmbeddingModelMultilingual || r.cfg.MultilingualEmbeddingHost == "" {
+				continue
+			}
+			mlEmbedding, err := r.embedderFor(map[string]string{"embedding_model": model})(ctx, query)
+			if err != nil {
+				r.logger.Warn("Failed to generate multilingual query embedding", zap.Error(err))
+				continue
+			}
+			mlResults, err := r.store.VectorSearchRAGDocumentsForModel(ctx, mlEmbedding, candidateLimit, sessionID, excludeHashes, model, dataset)
+			if err != nil {
+				r.logger.Warn("Multilingual vector search failed", zap.Error(err))
+				continue
 			}
-		} else if cand.Content == "" && embContent != "" {
-			cand.Content = embContent
+			addSemantic(mlResults)
 		}
-		cand.HasBM25 = true
 	}
 
 	// Batch fetch parent contents to prime cand.Content
 	docContents := make(map[string]string)
 	if len(candidates) > 0 {
+		ctx, fetchSpan := tracing.Start(ctx, "rag.content_fetch", tracing.Session(sessionID), attribute.Int("rag.candidates", len(candidates)))
+		defer fetchSpan.End()
 		candSlice := make([]*hybridCandidate, 0, len(candidates))
 		for _, c := range candidates {
 			candSlice = append(candSlice, c)
@@ -338,9 +437,9 @@ func (r *RAG) gatherCandidates(ctx context.Context, sessionID, query string, can
 	return candidates, docContents, nil
 }
 
-// scoreHybrid normalizes and combines semantic and BM25 scores, applies mode-specific boosts,
+// scoreHybrid normalizes and combines semantic and BM25 scores, applies the policy's boosts,
 // metadata hints, and echo penalties, and returns a ranked candidate slice.
-func (r *RAG) scoreHybrid(query, mode string, metadataHints map[string]string, candidates map[string]*hybridCandidate, isQueryForError bool) []*hybridCandidate {
+func (r *RAG) scoreHybrid(query string, policy config.RetrievalPolicy, metadataHints map[string]string, candidates map[string]*hybridCandidate, isQueryForError bool) []*hybridCandidate {
 	var maxSemantic, maxBM float64
 	for _, cand := range candidates {
 		if cand.SemanticScore > maxSemantic {
@@ -352,11 +451,11 @@ func (r *RAG) scoreHybrid(query, mode string, metadataHints map[string]string, c
 		}
 	}
 
-	semanticWeight := r.cfg.HybridSemanticWeight
+	semanticWeight := policy.SemanticWeight
+	bm25Weight := policy.BM25Weight
 	if semanticWeight < 0 {
 		semanticWeight = 0
 	}
-	bm25Weight := r.cfg.HybridBM25Weight
 	if bm25Weight < 0 {
 		bm25Weight = 0
 	}
@@ -384,31 +483,27 @@ func (r *RAG) scoreHybrid(query, mode string, metadataHints map[string]string, c
 		role := cand.Metadata["role"]
 		docType := cand.Metadata["type"]
 
-		var factBoost, summaryBoost, documentBoost float64
-		if mode == "document" {
-			factBoost = r.cfg.HybridDocumentFactBoost
-			summaryBoost = r.cfg.HybridDocumentSummaryBoost
-			documentBoost = r.cfg.HybridDocumentDocumentBoost
-		} else {
-			factBoost = r.cfg.HybridDatasetFactBoost
-			summaryBoost = r.cfg.HybridDatasetSummaryBoost
-			documentBoost = r.cfg.HybridDatasetDocumentBoost
-		}
-
 		if role == "fact" && docType != "chunk" && docType != "document_chunk" {
-			combined *= factBoost
+			combined *= policy.FactBoost
 		}
 		if docType == "summary" {
-			combined *= summaryBoost
+			combined *= policy.SummaryBoost
 		}
 		if docType == "state" {
-			combined *= r.cfg.HybridStateBoost
+			combined *= policy.StateBoost
+		}
+		if docType == "pdf_summary" {
+			combined *= policy.PDFSummaryBoost
+		}
+		if role == "annotation" {
+			combined *= policy.AnnotationBoost
 		}
 		if role == "document" || docType == "pdf" || docType == "document_chunk" {
-			combined *= documentBoost
+			combined *= policy.DocumentBoost
+			combined *= r.qualityWeight(cand.Metadata)
 		}
 		if cand.Content != "" && strings.Contains(cand.Content, "Error:") && !isQueryForError {
-			combined *= r.cfg.HybridErrorPenalty
+			combined *= policy.ErrorPenalty
 		}
 
 		if len(metadataHints) > 0 && cand.Metadata != nil {
@@ -564,8 +659,36 @@ func (r *RAG) deduplicateShingles(candidates []*hybridCandidate, excludeHashes [
 	return filtered
 }
 
+// Retrieval budget categories.
+const (
+	budgetFacts     = "facts"
+	budgetState     = "state"
+	budgetDocuments = "documents"
+	budgetUser      = "user"
+)
+
+// budgetCategory maps a retrieved document to the budget category it counts against.
+// PDF pages, PDF summaries and chunks of long documents count as documents; conversation
+// summaries, assistant and tool messages count as facts; user annotations count against
+// the user budget.
+func budgetCategory(role string, metadata map[string]string) string {
+	switch {
+	case role == "state" || metadata["type"] == "state":
+		return budgetState
+	case role == "document" || metadata["type"] == "pdf" || metadata["type"] == "pdf_summary" || metadata["type"] == "document_chunk":
+		return budgetDocuments
+	case role == "user" || role == "annotation":
+		return budgetUser
+	default:
+		return budgetFacts
+	}
+}
+
 // formatMemoryBlock builds the final <memory> block from ranked candidates and returns it with count.
-func (r *RAG) formatMemoryBlock(ctx context.Context, candidateList []*hybridCandidate, nResults int, doneLedger string, docContents map[string]string, excludeHashes []string) (string, int, error) {
+// Candidates are taken in rank order until their category's budget is used up, so a
+// session dominated by one kind of content still surfaces the others. Each entry is
+// tagged [mN] so the answer can cite it.
+func (r *RAG) formatMemoryBlock(ctx context.Context, sessionID string, candidateList []*hybridCandidate, budget config.RetrievalBudget, doneLedger string, docContents map[string]string, excludeHashes []string) (string, int, error) {
 	if docContents == nil {
 		docContents = make(map[string]string)
 	}
@@ -575,6 +698,13 @@ func (r *RAG) formatMemoryBlock(ctx context.Context, candidateList []*hybridCand
 	processedDocIDs := make(map[string]bool)
 	lastEmittedUser := ""
 	addedDocs := 0
+	limits := map[string]int{
+		budgetFacts:     budget.Facts,
+		budgetState:     budget.State,
+		budgetDocuments: budget.Documents,
+		budgetUser:      budget.User,
+	}
+	used := make(map[string]int, len(limits))
 	excludeHashSet := make(map[string]bool, len(excludeHashes))
 	for _, h := range excludeHashes {
 		if h != "" {
@@ -583,7 +713,7 @@ func (r *RAG) formatMemoryBlock(ctx context.Context, candidateList []*hybridCand
 	}
 
 	for _, cand := range candidateList {
-		if addedDocs >= nResults {
+		if addedDocs >= budget.Total() {
 			break
 		}
 		docID := cand.DocumentID
@@ -596,7 +726,12 @@ func (r *RAG) formatMemoryBlock(ctx context.Context, candidateList []*hybridCand
 			r.logger.Warn("Unable to resolve lookup identifier for document", zap.String("document_id", docID))
 			continue
 		}
-		if processedDocIDs[lookupID] {
+		if processedDocIDs[lookupID] || isPinned(cand.Metadata) {
+			continue
+		}
+		role := resolveRole(cand.Metadata)
+		category := budgetCategory(role, cand.Metadata)
+		if used[category] >= limits[category] {
 			continue
 		}
 
@@ -620,9 +755,13 @@ func (r *RAG) formatMemoryBlock(ctx context.Context, candidateList []*hybridCand
 			docContents[lookupID] = content
 		}
 
-		role := resolveRole(cand.Metadata)
+		// Documents stored before an exclusion rule was added are still filtered out
+		if !r.ingestion.Retrievable(role, content) {
+			processedDocIDs[lookupID] = true
+			continue
+		}
 		var lines []string
-		if role == "fact" {
+		if role == "fact" && cand.Metadata["type"] != "rollup" {
 			var fact factStoredContent
 			if err := json.Unmarshal([]byte(content), &fact); err == nil && (fact.User != "" || fact.Assistant != "" || fact.Tool != "") {
 				skipFact := false
@@ -653,10 +792,11 @@ func (r *RAG) formatMemoryBlock(ctx context.Context, candidateList []*hybridCand
 				if fact.Tool != "" {
 					lines = append(lines, fmt.Sprintf("- tool: %s\n", canonicalizeFactText(fact.Tool)))
 				}
-				for _, line := range lines {
+				for _, line := range r.tagMemoryLines(sessionID, lookupID, role, content, lines) {
 					contextBuilder.WriteString(line)
 				}
 				processedDocIDs[lookupID] = true
+				used[category]++
 				addedDocs++
 				continue
 			}
@@ -668,13 +808,16 @@ func (r *RAG) formatMemoryBlock(ctx context.Context, candidateList []*hybridCand
 			label := role
 			if cand.Metadata["type"] == "state" || role == "state" {
 				label = "state"
+			} else if cand.Metadata["type"] == "rollup" {
+				label = "rollup"
 			}
 			lines = append(lines, fmt.Sprintf("- %s: %s\n", label, content))
 		}
-		for _, line := range lines {
+		for _, line := range r.tagMemoryLines(sessionID, lookupID, role, content, lines) {
 			contextBuilder.WriteString(line)
 		}
 		processedDocIDs[lookupID] = true
+		used[category]++
 		addedDocs++
 	}
 
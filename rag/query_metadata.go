@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"stats-agent/web/format"
 	"strings"
 
 	"github.com/google/uuid"
@@ -21,7 +22,7 @@ type documentRecord struct {
 
 var (
 	metadataKeyPattern   = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
-	datasetQueryRegex    = regexp.MustCompile(`(?i)([A-Za-z0-9_\-]+\.(?:csv|tsv|xlsx?|xls))`)
+	datasetQueryRegex    = regexp.MustCompile(`(?i)([A-Za-z0-9_\-]+\.(?:csv\.gz|csv|tsv|xlsx?|xls|parquet))`)
 	metadataColonPattern = regexp.MustCompile(`(?i)\b(dataset|role|primary_test|analysis_stage)\s*:\s*["']?([^'"\n;,]+)`)
 	metadataTestKeywords = []struct {
 		value  string
@@ -40,8 +41,7 @@ func (r *RAG) QueryByMetadata(ctx context.Context, sessionID string, filters map
 		return "", nil
 	}
 
-	conditions := make([]string, 0)
-	args := make([]any, 0)
+	conditions := make(map[string]string)
 
 	for key, value := range filters {
 		key = strings.TrimSpace(key)
@@ -53,75 +53,42 @@ func (r *RAG) QueryByMetadata(ctx context.Context, sessionID string, filters map
 			r.logger.Warn("Skipping metadata filter with invalid key", zap.String("key", key))
 			continue
 		}
-		filterJSON, err := json.Marshal(map[string]string{key: value})
-		if err != nil {
-			r.logger.Warn("Failed to marshal metadata filter", zap.String("key", key), zap.Error(err))
-			continue
-		}
-		conditions = append(conditions, fmt.Sprintf("metadata @> $%d::jsonb", len(args)+1))
-		args = append(args, string(filterJSON))
+		conditions[key] = value
 	}
 
 	if sessionID != "" {
-		filterJSON, err := json.Marshal(map[string]string{"session_id": sessionID})
-		if err != nil {
-			return "", fmt.Errorf("marshal session filter: %w", err)
-		}
-		conditions = append(conditions, fmt.Sprintf("metadata @> $%d::jsonb", len(args)+1))
-		args = append(args, string(filterJSON))
+		conditions["session_id"] = sessionID
 	}
 
 	if len(conditions) == 0 {
 		return "", fmt.Errorf("at least one metadata filter or sessionID must be provided")
 	}
 
-	queryBuilder := strings.Builder{}
-	queryBuilder.WriteString("SELECT id, content, metadata FROM rag_documents WHERE ")
-	queryBuilder.WriteString(strings.Join(conditions, " AND "))
-	queryBuilder.WriteString(fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d", len(args)+1))
-	args = append(args, nResults)
-
-	rows, err := r.store.DB.QueryContext(ctx, queryBuilder.String(), args...)
+	docs, err := r.store.QueryDocumentsByMetadata(ctx, conditions, nResults)
 	if err != nil {
 		return "", fmt.Errorf("query rag_documents by metadata: %w", err)
 	}
-	defer rows.Close()
-
-	var records []documentRecord
-	for rows.Next() {
-		var docID uuid.UUID
-		var content string
-		var metadataBytes []byte
-		if err := rows.Scan(&docID, &content, &metadataBytes); err != nil {
-			return "", fmt.Errorf("scan rag_documents row: %w", err)
-		}
 
-		meta := make(map[string]string)
-		if len(metadataBytes) > 0 {
-			if err := json.Unmarshal(metadataBytes, &meta); err != nil {
-				r.logger.Warn("Failed to unmarshal document metadata", zap.Error(err), zap.String("document_id", docID.String()))
-			}
+	records := make([]documentRecord, 0, len(docs))
+	for _, doc := range docs {
+		if isPinned(doc.Metadata) {
+			continue
 		}
-
 		records = append(records, documentRecord{
-			documentID: docID.String(),
-			content:    content,
-			metadata:   meta,
+			documentID: doc.ID.String(),
+			content:    doc.Content,
+			metadata:   doc.Metadata,
 		})
 	}
 
-	if err := rows.Err(); err != nil {
-		return "", fmt.Errorf("iterate rag_documents rows: %w", err)
-	}
-
 	if len(records) == 0 {
 		return "", nil
 	}
 
-	return r.renderRecordsToMemory(ctx, records, nResults), nil
+	return r.renderRecordsToMemory(ctx, sessionID, records, nResults), nil
 }
 
-func (r *RAG) renderRecordsToMemory(ctx context.Context, records []documentRecord, limit int) string {
+func (r *RAG) renderRecordsToMemory(ctx context.Context, sessionID string, records []documentRecord, limit int) string {
 	docContents := make(map[string]string)
 	processedDocIDs := make(map[string]bool)
 	var contextBuilder strings.Builder
@@ -202,7 +169,7 @@ func (r *RAG) renderRecordsToMemory(ctx context.Context, records []documentRecor
 					lines = append(lines, fmt.Sprintf("- tool: %s\n", canonicalizeFactText(fact.Tool)))
 				}
 
-				for _, line := range lines {
+				for _, line := range r.tagMemoryLines(sessionID, lookupID, role, content, lines) {
 					contextBuilder.WriteString(line)
 				}
 
@@ -219,7 +186,7 @@ func (r *RAG) renderRecordsToMemory(ctx context.Context, records []documentRecor
 			lines = append(lines, fmt.Sprintf("- %s: %s\n", role, content))
 		}
 
-		for _, line := range lines {
+		for _, line := range r.tagMemoryLines(sessionID, lookupID, role, content, lines) {
 			contextBuilder.WriteString(line)
 		}
 
@@ -231,6 +198,28 @@ func (r *RAG) renderRecordsToMemory(ctx context.Context, records []documentRecor
 	return contextBuilder.String()
 }
 
+// artifactQueryKeywords maps query wording to the artifact type of the tool outputs it asks about.
+var artifactQueryKeywords = []struct {
+	value  format.ArtifactType
+	tokens []string
+}{
+	{format.ArtifactRegressionSummary, []string{"coefficient", "regression", "r-squared"}},
+	{format.ArtifactContingencyTable, []string{"contingency", "crosstab", "cross-tab", "cross tab"}},
+	{format.ArtifactModelDiagnostics, []string{"diagnostic", "residual", "durbin", "heteroscedastic", "multicollinear"}},
+}
+
+// artifactTypeForQuery returns the artifact type a lowercased query asks about, or "".
+func artifactTypeForQuery(lowerQuery string) string {
+	for _, mapping := range artifactQueryKeywords {
+		for _, token := range mapping.tokens {
+			if strings.Contains(lowerQuery, token) {
+				return string(mapping.value)
+			}
+		}
+	}
+	return ""
+}
+
 func extractSimpleMetadata(query string, maxFilters int) map[string]string {
 
 	filters := make(map[string]string)
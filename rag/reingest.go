@@ -0,0 +1,48 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ReingestSession rebuilds the memory derived from a session's messages, e.g. after the
+// fact or summary pipeline changed. The documents stored for the messages (and their
+// summaries and chunks) are deleted, then the messages are stored again in order, in
+// batches of up to RAG_INGEST_MAX_BATCH. Documents not derived from messages (PDF pages,
+// annotations, upload profiles) are kept. Queued background writes of the session are
+// dropped, since the messages are stored here.
+func (r *RAG) ReingestSession(ctx context.Context, sessionID uuid.UUID, messages []types.AgentMessage) (int64, error) {
+	id := sessionID.String()
+	hashes := make(map[string]bool, len(messages))
+	var hashList []string
+	for _, m := range messages {
+		if m.ContentHash != "" && !hashes[m.ContentHash] {
+			hashes[m.ContentHash] = true
+			hashList = append(hashList, m.ContentHash)
+		}
+	}
+	r.dropPendingMessages(id, hashes)
+
+	deleted, err := r.store.DeleteMessageDocuments(ctx, sessionID, hashList)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete message documents: %w", err)
+	}
+
+	for pending := messages; len(pending) > 0; {
+		batch := nextIngestBatch(pending, r.cfg.RAGIngestMaxBatch)
+		if err := r.AddMessagesToStore(ctx, id, batch); err != nil {
+			return deleted, fmt.Errorf("failed to store messages: %w", err)
+		}
+		pending = pending[len(batch):]
+	}
+	r.logger.Info("Re-ingested session messages",
+		zap.String("session_id", id),
+		zap.Int("messages", len(messages)),
+		zap.Int64("documents_deleted", deleted))
+	return deleted, nil
+}
@@ -0,0 +1,82 @@
+package rag
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"stats-agent/llmclient"
+
+	"go.uber.org/zap"
+)
+
+// SetReranker enables the cross-encoder pass over the top RERANK_TOP_K hybrid candidates.
+// main.go sets it when RERANK_HOST is configured.
+func (r *RAG) SetReranker(reranker llmclient.Reranker) {
+	r.reranker = reranker
+}
+
+// rerankEnabled reports whether the session's queries are reranked: RERANK_ENABLED, unless
+// the session's experiment arm sets RERANK.
+func (r *RAG) rerankEnabled(sessionID string) bool {
+	if r.reranker == nil {
+		return false
+	}
+	if arm := r.experimentArm(sessionID); arm != nil && arm.Rerank != nil {
+		return *arm.Rerank
+	}
+	return r.cfg.RerankEnabled
+}
+
+// rerankCandidates reorders the head of the ranked candidate list by the cross-encoder's
+// relevance to the query. Reranked candidates stay ahead of the rest, and their scores are
+// replaced so later stages (summary bucketing re-sorts by score) keep the new order.
+// On a reranker error the hybrid ranking is returned unchanged.
+func (r *RAG) rerankCandidates(ctx context.Context, sessionID, query string, ranked []*hybridCandidate) []*hybridCandidate {
+	topK := min(r.cfg.RerankTopK, len(ranked))
+	if !r.rerankEnabled(sessionID) || topK < 2 {
+		return ranked
+	}
+	head := make([]*hybridCandidate, 0, topK)
+	documents := make([]string, 0, topK)
+	for _, cand := range ranked[:topK] {
+		// The head must be contiguous to stay ahead of the rest: stop at a candidate without text
+		if cand.Content == "" {
+			break
+		}
+		head = append(head, cand)
+		documents = append(documents, cand.Content)
+	}
+	if len(head) < 2 {
+		return ranked
+	}
+
+	scores, err := r.reranker.Rerank(ctx, r.cfg.RerankHost, r.cfg.RerankModel, query, documents)
+	if err != nil {
+		r.logger.Warn("Reranking failed; keeping hybrid ranking", zap.Error(err))
+		return ranked
+	}
+
+	// Candidates past the head keep their scores; the head is lifted above them
+	floor := 0.0
+	if len(head) < len(ranked) {
+		floor = ranked[len(head)].Score
+	}
+	for i, cand := range head {
+		cand.Score = floor + 1 + sigmoid(scores[i])
+	}
+	reranked := append([]*hybridCandidate(nil), head...)
+	sort.Slice(reranked, func(i, j int) bool {
+		if reranked[i].Score == reranked[j].Score {
+			return reranked[i].DocumentID < reranked[j].DocumentID
+		}
+		return reranked[i].Score > reranked[j].Score
+	})
+	r.logger.Debug("Reranked hybrid candidates", zap.Int("candidates", len(head)))
+	return append(reranked, ranked[len(head):]...)
+}
+
+// sigmoid maps a cross-encoder logit to (0, 1); scores already in that range keep their order.
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
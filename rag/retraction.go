@@ -0,0 +1,64 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+
+	"stats-agent/database"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// RetractMessages deletes messages and everything session memory derived from them. The
+// session's queued background writes of those messages are dropped first, so they can't
+// land after the retraction; the store then removes the messages and their documents in
+// one transaction. A batch already being written is not interrupted.
+func (r *RAG) RetractMessages(ctx context.Context, sessionID uuid.UUID, messages []types.ChatMessage) (database.RetractionResult, error) {
+	refs := database.MessageArtifactRefs{}
+	hashes := make(map[string]bool, len(messages))
+	for _, m := range messages {
+		id, err := uuid.Parse(m.ID)
+		if err != nil {
+			return database.RetractionResult{}, fmt.Errorf("invalid message ID %q: %w", m.ID, err)
+		}
+		refs.MessageIDs = append(refs.MessageIDs, id)
+		if m.ContentHash != "" && !hashes[m.ContentHash] {
+			hashes[m.ContentHash] = true
+			refs.ContentHashes = append(refs.ContentHashes, m.ContentHash)
+		}
+	}
+
+	if dropped := r.dropPendingMessages(sessionID.String(), hashes); dropped > 0 {
+		r.logger.Info("Dropped queued RAG writes of retracted messages",
+			zap.String("session_id", sessionID.String()),
+			zap.Int("dropped", dropped))
+	}
+
+	result, err := r.store.RetractMessageArtifacts(ctx, sessionID, refs)
+	if err != nil {
+		return result, fmt.Errorf("failed to retract message artifacts: %w", err)
+	}
+	return result, nil
+}
+
+// dropPendingMessages removes queued writes whose content hash is in hashes and returns
+// how many were removed.
+func (r *RAG) dropPendingMessages(sessionID string, hashes map[string]bool) int {
+	r.ingestMu.Lock()
+	defer r.ingestMu.Unlock()
+	q := r.ingestQueues[sessionID]
+	if q == nil || len(hashes) == 0 {
+		return 0
+	}
+	kept := q.pending[:0]
+	for _, m := range q.pending {
+		if !hashes[m.ContentHash] {
+			kept = append(kept, m)
+		}
+	}
+	dropped := len(q.pending) - len(kept)
+	q.pending = kept
+	return dropped
+}
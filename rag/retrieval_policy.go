@@ -0,0 +1,37 @@
+package rag
+
+import "stats-agent/config"
+
+// SetSessionRetrievalPolicy selects the named retrieval policy for a session's queries.
+// "" (or a name no longer configured) uses the policy of the query's mode.
+func (r *RAG) SetSessionRetrievalPolicy(sessionID, name string) {
+	if sessionID == "" {
+		return
+	}
+	r.policyMu.Lock()
+	defer r.policyMu.Unlock()
+	if name == "" || !r.cfg.HasRetrievalPolicy(name) {
+		delete(r.sessionPolicies, sessionID)
+		return
+	}
+	r.sessionPolicies[sessionID] = name
+}
+
+// retrievalPolicy returns the policy a session's query in mode runs with. Sessions in a
+// retrieval experiment arm get the arm's weight overrides on top of it.
+func (r *RAG) retrievalPolicy(sessionID, mode string) config.RetrievalPolicy {
+	r.policyMu.RLock()
+	name := r.sessionPolicies[sessionID]
+	r.policyMu.RUnlock()
+
+	policy := r.cfg.RetrievalPolicy(mode, name)
+	if arm := r.experimentArm(sessionID); arm != nil {
+		policy.SemanticWeight = armWeight(arm.SemanticWeight, policy.SemanticWeight)
+		policy.BM25Weight = armWeight(arm.BM25Weight, policy.BM25Weight)
+		policy.FactBoost = armWeight(arm.FactBoost, policy.FactBoost)
+		policy.SummaryBoost = armWeight(arm.SummaryBoost, policy.SummaryBoost)
+		policy.DocumentBoost = armWeight(arm.DocumentBoost, policy.DocumentBoost)
+		policy.StateBoost = armWeight(arm.StateBoost, policy.StateBoost)
+	}
+	return policy
+}
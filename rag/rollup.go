@@ -0,0 +1,433 @@
+package rag
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"stats-agent/database"
+	"stats-agent/web/format"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// rollupAlpha is the significance level applied to the Holm-adjusted p-values.
+const rollupAlpha = 0.05
+
+// maxSessionRollups bounds the rollups listed for the results panel.
+const maxSessionRollups = 50
+
+// correctionPattern detects a multiple-comparison correction applied by the analysis code.
+var correctionPattern = regexp.MustCompile(`(?i)multipletests|fdrcorrection|fdr_bh|bonferroni|holm|benjamini|p\.adjust`)
+
+// screeningMember is one per-variable fact that belongs to a screening loop.
+type screeningMember struct {
+	docID      uuid.UUID
+	test       string
+	dataset    string
+	variables  []string
+	correction string
+	row        types.RollupRow
+}
+
+// RollupScreeningFacts collects facts that ran the same test on the same dataset across
+// different variables into one rollup document per test: a results table sorted by
+// p-value, with Holm-adjusted p-values and the correction status. Once a test reaches
+// SCREENING_ROLLUP_MIN_TESTS variables its facts are folded in and deleted; later facts
+// for the same test join the existing rollup. Returns the number of facts folded in.
+func (r *RAG) RollupScreeningFacts(ctx context.Context, sessionID string) (int, error) {
+	minTests := r.cfg.ScreeningRollupMinTests
+	if minTests <= 0 || sessionID == "" {
+		return 0, nil
+	}
+
+	facts, err := r.store.ListSessionFactEmbeddings(ctx, sessionID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list session facts: %w", err)
+	}
+
+	// Facts are ordered newest first, so a variable tested twice keeps its latest result
+	groups := make(map[string][]screeningMember)
+	var keys []string
+	for _, fact := range facts {
+		member, key, ok := screeningMemberFromFact(fact)
+		if !ok {
+			continue
+		}
+		if _, seen := groups[key]; !seen {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], member)
+	}
+
+	folded := 0
+	for _, key := range keys {
+		members := groups[key]
+		rollupID := screeningRollupID(sessionID, key)
+		existing, err := r.loadRollup(ctx, rollupID)
+		if err != nil {
+			r.logger.Warn("Failed to load screening rollup, skipping test",
+				zap.Error(err),
+				zap.String("session_id", sessionID),
+				zap.String("rollup_id", rollupID.String()))
+			continue
+		}
+		if existing == nil && len(members) < minTests {
+			continue
+		}
+
+		rollup, foldedIDs := mergeScreeningRollup(existing, members)
+		if len(rollup.Rows) < minTests {
+			continue
+		}
+		if err := r.storeRollup(ctx, sessionID, rollupID, rollup); err != nil {
+			r.logger.Warn("Failed to store screening rollup",
+				zap.Error(err),
+				zap.String("session_id", sessionID),
+				zap.String("test", rollup.Test))
+			continue
+		}
+		for _, id := range foldedIDs {
+			if err := r.store.DeleteRAGDocument(ctx, id); err != nil {
+				r.logger.Warn("Failed to delete fact folded into screening rollup",
+					zap.Error(err),
+					zap.String("document_id", id.String()))
+				continue
+			}
+			folded++
+		}
+	}
+
+	if folded > 0 {
+		r.logger.Info("Rolled up screening facts",
+			zap.String("session_id", sessionID),
+			zap.Int("facts_folded", folded))
+	}
+	return folded, nil
+}
+
+// SessionRollups returns the session's screening rollups, most recently updated first.
+func (r *RAG) SessionRollups(ctx context.Context, sessionID string) ([]types.ScreeningRollup, error) {
+	docs, err := r.store.QueryDocumentsByMetadata(ctx, map[string]string{
+		"session_id": sessionID,
+		"type":       "rollup",
+	}, maxSessionRollups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list screening rollups: %w", err)
+	}
+
+	rollups := make([]types.ScreeningRollup, 0, len(docs))
+	for _, doc := range docs {
+		var rollup types.ScreeningRollup
+		if err := json.Unmarshal([]byte(doc.Metadata["rollup"]), &rollup); err != nil {
+			r.logger.Warn("Skipping unreadable screening rollup",
+				zap.Error(err),
+				zap.String("document_id", doc.ID.String()))
+			continue
+		}
+		rollups = append(rollups, rollup)
+	}
+	sort.SliceStable(rollups, func(i, j int) bool { return rollups[i].UpdatedAt.After(rollups[j].UpdatedAt) })
+	return rollups, nil
+}
+
+// screeningMemberFromFact parses a fact's code and output into a rollup row. Facts
+// without a test, a p-value or variables, and failed executions, are not screening
+// results. The key groups members by dataset and test.
+func screeningMemberFromFact(fact database.FactEmbedding) (screeningMember, string, bool) {
+	var stored factStoredContent
+	if err := json.Unmarshal([]byte(fact.Content), &stored); err != nil || stored.Assistant == "" || stored.Tool == "" {
+		return screeningMember{}, "", false
+	}
+	if strings.Contains(stored.Tool, "Error:") {
+		return screeningMember{}, "", false
+	}
+	code, ok := format.ExtractCodeContent(stored.Assistant)
+	if !ok {
+		return screeningMember{}, "", false
+	}
+
+	// Tests and variables come from the code only: output tables name unrelated columns
+	tests := extractTests(code, "")
+	variables := extractVariables(code, "")
+	if len(tests) == 0 || len(variables) == 0 {
+		return screeningMember{}, "", false
+	}
+	values := extractNumericalValues(stored.Tool)
+	p, err := strconv.ParseFloat(values["p_value"], 64)
+	if err != nil || p < 0 || p > 1 {
+		return screeningMember{}, "", false
+	}
+
+	test := tests[len(tests)-1]
+	dataset := fact.Metadata["dataset"]
+	member := screeningMember{
+		docID:     fact.DocumentID,
+		test:      test,
+		dataset:   dataset,
+		variables: variables,
+		row: types.RollupRow{
+			Statistic:  values["test_statistic"],
+			EffectSize: values["effect_size"],
+			PValue:     p,
+		},
+	}
+	if match := correctionPattern.FindString(code); match != "" {
+		member.correction = strings.ToLower(match)
+	}
+	return member, dataset + "|" + test, true
+}
+
+// mergeScreeningRollup adds members to an existing rollup (or starts one) and recomputes
+// the adjusted p-values. Variables shared by every member (typically the grouping
+// column) become GroupBy; each row is labeled by the remaining variables. It also returns
+// the facts now covered by the rollup: members without variables of their own stay.
+func mergeScreeningRollup(existing *types.ScreeningRollup, members []screeningMember) (types.ScreeningRollup, []uuid.UUID) {
+	var rollup types.ScreeningRollup
+	if existing != nil {
+		rollup = *existing
+	}
+
+	common := splitGroupBy(rollup.GroupBy)
+	if existing == nil && len(members) > 0 {
+		rollup.Test = members[0].test
+		rollup.Dataset = members[0].dataset
+		common = sharedVariables(members)
+		rollup.GroupBy = strings.Join(common, ", ")
+	}
+
+	rows := make(map[string]types.RollupRow, len(rollup.Rows)+len(members))
+	var order []string
+	for _, row := range rollup.Rows {
+		rows[row.Variable] = row
+		order = append(order, row.Variable)
+	}
+	// Members are newest first: the first member per variable wins over older members,
+	// and any member wins over the rollup's earlier row
+	updated := make(map[string]bool)
+	var folded []uuid.UUID
+	for _, m := range members {
+		label := variableLabel(m.variables, common)
+		if label == "" {
+			continue
+		}
+		folded = append(folded, m.docID)
+		if updated[label] {
+			continue
+		}
+		updated[label] = true
+		if _, ok := rows[label]; !ok {
+			order = append(order, label)
+		}
+		row := m.row
+		row.Variable = label
+		rows[label] = row
+		if rollup.Correction == "" && m.correction != "" {
+			rollup.Correction = m.correction
+		}
+	}
+
+	rollup.Rows = make([]types.RollupRow, 0, len(order))
+	for _, label := range order {
+		rollup.Rows = append(rollup.Rows, rows[label])
+	}
+	holmAdjust(rollup.Rows)
+	sort.SliceStable(rollup.Rows, func(i, j int) bool { return rollup.Rows[i].PValue < rollup.Rows[j].PValue })
+	rollup.UpdatedAt = time.Now().UTC()
+	return rollup, folded
+}
+
+// holmAdjust sets each row's Holm-Bonferroni adjusted p-value and its significance at
+// rollupAlpha.
+func holmAdjust(rows []types.RollupRow) {
+	idx := make([]int, len(rows))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return rows[idx[a]].PValue < rows[idx[b]].PValue })
+
+	m := len(rows)
+	running := 0.0
+	for rank, i := range idx {
+		running = math.Max(running, math.Min(1, float64(m-rank)*rows[i].PValue))
+		rows[i].AdjustedP = running
+		rows[i].Significant = running < rollupAlpha
+	}
+}
+
+// sharedVariables returns the variables every member uses, sorted.
+func sharedVariables(members []screeningMember) []string {
+	if len(members) == 0 {
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, m := range members {
+		for _, v := range m.variables {
+			counts[v]++
+		}
+	}
+	var shared []string
+	for v, n := range counts {
+		if n == len(members) {
+			shared = append(shared, v)
+		}
+	}
+	sort.Strings(shared)
+	return shared
+}
+
+func splitGroupBy(groupBy string) []string {
+	var vars []string
+	for _, v := range strings.Split(groupBy, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			vars = append(vars, v)
+		}
+	}
+	return vars
+}
+
+func variableLabel(variables, common []string) string {
+	var label []string
+	for _, v := range variables {
+		shared := false
+		for _, c := range common {
+			if v == c {
+				shared = true
+				break
+			}
+		}
+		if !shared {
+			label = append(label, v)
+		}
+	}
+	return strings.Join(label, ", ")
+}
+
+// screeningRollupID is deterministic per session, dataset and test so later facts
+// update the same rollup.
+func screeningRollupID(sessionID, key string) uuid.UUID {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte("rollup|"+sessionID+"|"+key))
+}
+
+// loadRollup returns the stored rollup, or nil when there is none yet.
+func (r *RAG) loadRollup(ctx context.Context, rollupID uuid.UUID) (*types.ScreeningRollup, error) {
+	doc, err := r.store.GetDocument(ctx, rollupID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rollup types.ScreeningRollup
+	if err := json.Unmarshal([]byte(doc.Metadata["rollup"]), &rollup); err != nil {
+		return nil, fmt.Errorf("failed to decode screening rollup %s: %w", rollupID, err)
+	}
+	return &rollup, nil
+}
+
+// storeRollup upserts the rollup document with the rendered table as its content and
+// embedding text, and the structured rollup in its metadata.
+func (r *RAG) storeRollup(ctx context.Context, sessionID string, rollupID uuid.UUID, rollup types.ScreeningRollup) error {
+	encoded, err := json.Marshal(rollup)
+	if err != nil {
+		return fmt.Errorf("failed to encode screening rollup: %w", err)
+	}
+	content := RenderScreeningRollup(rollup)
+	contentHash := HashContent(NormalizeForHash(content))
+	metadata := map[string]string{
+		"session_id":   sessionID,
+		"role":         "fact",
+		"type":         "rollup",
+		"document_id":  rollupID.String(),
+		"primary_test": rollup.Test,
+		"rollup_count": strconv.Itoa(len(rollup.Rows)),
+		"rollup":       string(encoded),
+		"content_hash": contentHash,
+	}
+	if rollup.Dataset != "" {
+		metadata["dataset"] = rollup.Dataset
+	}
+
+	if _, err := r.store.UpsertDocument(ctx, rollupID, content, metadata, contentHash); err != nil {
+		return fmt.Errorf("failed to upsert screening rollup: %w", err)
+	}
+	windows, err := r.createEmbeddingWindows(ctx, content)
+	if err != nil {
+		return fmt.Errorf("failed to embed screening rollup: %w", err)
+	}
+	for _, w := range windows {
+		if err := r.store.CreateEmbedding(ctx, rollupID, w.WindowIndex, w.WindowStart, w.WindowEnd, w.WindowText, w.Embedding); err != nil {
+			return fmt.Errorf("failed to store screening rollup embedding: %w", err)
+		}
+	}
+	return nil
+}
+
+// RenderScreeningRollup formats a rollup as the text stored in memory: a heading, the
+// correction status and a Markdown table sorted by p-value.
+func RenderScreeningRollup(rollup types.ScreeningRollup) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Screening rollup: %s across %d variables", rollup.Test, len(rollup.Rows))
+	if rollup.Dataset != "" {
+		fmt.Fprintf(&b, " in %s", rollup.Dataset)
+	}
+	if rollup.GroupBy != "" {
+		fmt.Fprintf(&b, " (by %s)", rollup.GroupBy)
+	}
+	b.WriteString("\n")
+
+	significant := 0
+	for _, row := range rollup.Rows {
+		if row.Significant {
+			significant++
+		}
+	}
+	fmt.Fprintf(&b, "Multiple-comparison correction: %s. %d of %d significant after Holm adjustment (alpha %.2f).\n",
+		RollupCorrectionStatus(rollup), significant, len(rollup.Rows), rollupAlpha)
+
+	b.WriteString("| variable | statistic | effect size | p | Holm p | significant |\n")
+	b.WriteString("|---|---|---|---|---|---|\n")
+	for _, row := range rollup.Rows {
+		sig := "no"
+		if row.Significant {
+			sig = "yes"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n",
+			row.Variable, dashIfEmpty(row.Statistic), dashIfEmpty(row.EffectSize),
+			FormatPValue(row.PValue), FormatPValue(row.AdjustedP), sig)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// RollupCorrectionStatus describes whether the analysis corrected for multiple comparisons.
+func RollupCorrectionStatus(rollup types.ScreeningRollup) string {
+	if rollup.Correction != "" {
+		return "applied in the analysis (" + rollup.Correction + ")"
+	}
+	return "not applied in the analysis"
+}
+
+// FormatPValue prints a p-value with 4 decimals, or "<0.0001".
+func FormatPValue(p float64) string {
+	if p < 0.0001 {
+		return "<0.0001"
+	}
+	return strconv.FormatFloat(p, 'f', 4, 64)
+}
+
+func dashIfEmpty(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,93 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// StageComplete is the state card stage recorded when the user concludes an analysis.
+const StageComplete = "complete"
+
+// MarkSessionComplete stores a state card marking the session's analysis as concluded,
+// with the head of the conclusions as its evidence. The card stays active until
+// ReopenSession supersedes it.
+func (r *RAG) MarkSessionComplete(ctx context.Context, sessionID, dataset, conclusions string) error {
+	if sessionID == "" {
+		return fmt.Errorf("session ID is required")
+	}
+
+	header := fmt.Sprintf("[dataset:%s | stage:%s]", strings.TrimSpace(dataset), StageComplete)
+	content := header + "\nAnalysis concluded at the user's request. Do not start new analyses unless asked."
+	if summary := strings.TrimSpace(conclusions); summary != "" {
+		content += "\n" + compressMiddle(summary, 800, 600, 150)
+	}
+
+	docID := uuid.New()
+	md := map[string]string{
+		"session_id":         sessionID,
+		"role":               "state",
+		"type":               "state",
+		"stage":              StageComplete,
+		"source_type":        "finish",
+		"source_captured_at": time.Now().UTC().Format(time.RFC3339),
+		"state_status":       "active",
+	}
+	if dataset != "" {
+		md["dataset"] = dataset
+	}
+
+	if _, err := r.store.UpsertDocument(ctx, docID, content, md, HashContent(NormalizeForHash(content))); err != nil {
+		return fmt.Errorf("failed to store completion state: %w", err)
+	}
+
+	windows, err := r.createEmbeddingWindows(ctx, content)
+	if err != nil {
+		r.logger.Warn("Failed to create embedding for completion state", zap.Error(err))
+		return nil
+	}
+	for _, w := range windows {
+		if e := r.store.CreateEmbedding(ctx, docID, w.WindowIndex, w.WindowStart, w.WindowEnd, w.WindowText, w.Embedding); e != nil {
+			r.logger.Warn("Failed to store embedding window for completion state", zap.Error(e))
+		}
+	}
+	return nil
+}
+
+// IsSessionComplete reports whether the session has an active completion state card.
+func (r *RAG) IsSessionComplete(ctx context.Context, sessionID string) bool {
+	docs, err := r.store.ListStateDocuments(ctx, sessionID)
+	if err != nil {
+		r.logger.Warn("Failed to list state documents", zap.Error(err), zap.String("session_id", sessionID))
+		return false
+	}
+	for _, doc := range docs {
+		if doc.Metadata["stage"] == StageComplete && doc.Metadata["state_status"] != "superseded" {
+			return true
+		}
+	}
+	return false
+}
+
+// ReopenSession supersedes the session's completion state cards so analysis can resume.
+func (r *RAG) ReopenSession(ctx context.Context, sessionID string) {
+	docs, err := r.store.ListStateDocuments(ctx, sessionID)
+	if err != nil {
+		r.logger.Warn("Failed to list state documents", zap.Error(err), zap.String("session_id", sessionID))
+		return
+	}
+	for _, doc := range docs {
+		if doc.Metadata["stage"] != StageComplete || doc.Metadata["state_status"] == "superseded" {
+			continue
+		}
+		meta := cloneStringMap(doc.Metadata)
+		meta["state_status"] = "superseded"
+		if _, err := r.store.UpsertDocument(ctx, doc.ID, doc.Content, meta, doc.ContentHash); err != nil {
+			r.logger.Warn("Failed to supersede completion state", zap.Error(err), zap.String("document_id", doc.ID.String()))
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"stats-agent/web/format"
 )
 
 // StatMetadata holds extracted statistical information from code and results
@@ -19,6 +21,8 @@ type StatMetadata struct {
 	EffectSize    string          // Cohen's d, eta^2, etc.
 	SampleSize    string          // N
 	Significance  map[string]bool // sig_at_05, sig_at_01, etc.
+	Warnings      []string        // Python warning categories raised by the code
+	Blockers      []string        // Warning categories that may invalidate the result
 }
 
 // testPattern represents a detectable statistical test
@@ -88,9 +92,12 @@ var testPatterns = []testPattern{
 
 	// === Time Series ===
 	{regexp.MustCompile(`(?i)adfuller|adf\.test`), "augmented-dickey-fuller"},
-	{regexp.MustCompile(`(?i)kpss\.test`), "kpss-test"},
+	{regexp.MustCompile(`(?i)kpss\.test|kpss\(`), "kpss-test"},
 	{regexp.MustCompile(`(?i)acf\(|pacf\(`), "autocorrelation"},
-	{regexp.MustCompile(`(?i)arima\(|ARIMA\(`), "arima"},
+	{regexp.MustCompile(`(?i)seasonal_decompose|\bSTL\(`), "seasonal-decomposition"},
+	{regexp.MustCompile(`(?i)arima\(|ARIMA\(|SARIMAX\(|auto_arima`), "arima"},
+	{regexp.MustCompile(`(?i)Prophet\(`), "prophet"},
+	{regexp.MustCompile(`(?i)acorr_ljungbox|ljung`), "ljung-box"},
 
 	// === Post-hoc Tests ===
 	{regexp.MustCompile(`(?i)tukey|TukeyHSD`), "tukey-hsd"},
@@ -130,7 +137,8 @@ var variablePatterns = []*regexp.Regexp{
 
 // Dataset detection patterns
 var datasetPatterns = []*regexp.Regexp{
-	regexp.MustCompile(`(?i)(?:read_csv|read\.csv|pd\.read_csv)\s*\(\s*['"]([^'"]+\.(?:csv|tsv))['"]`),
+	regexp.MustCompile(`(?i)(?:read_csv|read\.csv|pd\.read_csv)\s*\(\s*['"]([^'"]+\.(?:csv|tsv)(?:\.gz)?)['"]`),
+	regexp.MustCompile(`(?i)(?:read_parquet|pd\.read_parquet)\s*\(\s*['"]([^'"]+\.parquet)['"]`),
 	regexp.MustCompile(`(?i)(?:read_excel|read\.excel|pd\.read_excel)\s*\(\s*['"]([^'"]+\.(?:xlsx?|xls))['"]`),
 	regexp.MustCompile(`(?i)(?:read_table|read\.table)\s*\(\s*['"]([^'"]+)['"]`),
 }
@@ -155,6 +163,16 @@ func ExtractStatisticalMetadata(code, result string) map[string]string {
 		Significance: make(map[string]bool),
 	}
 
+	result, warnings := format.SplitWarnings(result)
+	meta.Warnings = format.WarningCategories(warnings)
+	var blockers []format.ExecutionWarning
+	for _, w := range warnings {
+		if w.IsPotentialBlocker() {
+			blockers = append(blockers, w)
+		}
+	}
+	meta.Blockers = format.WarningCategories(blockers)
+
 	// Extract all components
 	meta.TestTypes = extractTests(code, result)
 	if len(meta.TestTypes) > 0 {
@@ -222,6 +240,14 @@ func (m *StatMetadata) ToMap() map[string]string {
 		meta["sample_size"] = m.SampleSize
 	}
 
+	if len(m.Warnings) > 0 {
+		meta["warnings"] = strings.Join(m.Warnings, ",")
+	}
+
+	if len(m.Blockers) > 0 {
+		meta["assumption_blockers"] = strings.Join(m.Blockers, ",")
+	}
+
 	// Add significance flags
 	for k, v := range m.Significance {
 		meta[k] = strconv.FormatBool(v)
@@ -253,6 +279,7 @@ func inferAnalysisStage(testType string) string {
 	assumptions := map[string]bool{
 		"shapiro-wilk": true, "kolmogorov-smirnov": true, "anderson-darling": true,
 		"jarque-bera": true, "levene": true, "bartlett": true, "f-test-variance": true,
+		"augmented-dickey-fuller": true, "kpss-test": true, "ljung-box": true,
 	}
 	if assumptions[testType] {
 		return "assumption_check"
@@ -274,7 +301,7 @@ func inferAnalysisStage(testType string) string {
 		"poisson-regression": true, "negative-binomial-regression": true,
 		"linear-mixed-effects": true, "generalized-linear-mixed-effects": true,
 		"random-forest": true, "gradient-boosting": true, "support-vector-machine": true,
-		"cox-regression": true, "arima": true,
+		"cox-regression": true, "arima": true, "prophet": true,
 	}
 	if models[testType] {
 		return "modeling"
@@ -284,6 +311,7 @@ func inferAnalysisStage(testType string) string {
 	descriptive := map[string]bool{
 		"pearson-correlation": true, "spearman-correlation": true, "kendall-tau": true,
 		"cohen-d": true, "eta-squared": true,
+		"autocorrelation": true, "seasonal-decomposition": true,
 	}
 	if descriptive[testType] {
 		return "descriptive"
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"strings"
 
-	"stats-agent/llmclient"
 	"stats-agent/prompts"
 	"stats-agent/web/types"
 )
@@ -71,7 +70,7 @@ Extract relevant facts following the rules and examples above:`, latestUserMessa
     }
 
     // Non-streaming summarization (use server default temperature)
-    summary, err := llmclient.New(r.cfg, r.logger).Chat(ctx, r.cfg.SummarizationLLMHost, messages, nil)
+    summary, err := r.llm.Chat(ctx, r.cfg.SummarizationLLMHost, messages, nil)
     if err != nil {
         return "", fmt.Errorf("llm chat call failed for state summary: %w", err)
     }
@@ -114,7 +113,7 @@ func (r *RAG) generateFactSummary(ctx context.Context, code, result string, meta
 		{Role: "user", Content: userPrompt.String()},
 	}
 
-	summary, err := llmclient.New(r.cfg, r.logger).Chat(ctx, r.cfg.SummarizationLLMHost, messages, nil)
+	summary, err := r.chatSummary(ctx, summaryKindFact, messages)
 	if err != nil {
 		return "", fmt.Errorf("llm chat call failed for summary: %w", err)
 	}
@@ -140,7 +139,7 @@ func (r *RAG) generateSearchableSummary(ctx context.Context, content string) (st
 		{Role: "user", Content: userPrompt},
 	}
 
-	summary, err := llmclient.New(r.cfg, r.logger).Chat(ctx, r.cfg.SummarizationLLMHost, messages, nil)
+	summary, err := r.chatSummary(ctx, summaryKindSearchable, messages)
 	if err != nil {
 		return "", fmt.Errorf("llm chat call failed for searchable summary: %w", err)
 	}
@@ -152,13 +151,13 @@ func (r *RAG) generateSearchableSummary(ctx context.Context, content string) (st
 	return strings.TrimSpace(summary), nil
 }
 
-// SummarizePDFKeyFacts produces a short, searchable "Key Facts" summary from page 1 text.
-// It is generic across document types and avoids hallucinating missing fields.
-func (r *RAG) SummarizePDFKeyFacts(ctx context.Context, filename string, pageOneText string) (string, error) {
+// SummarizePDFKeyFacts produces a searchable "Key Facts" overview (objectives, methods,
+// key results) from the opening text of a PDF. It avoids hallucinating missing fields.
+func (r *RAG) SummarizePDFKeyFacts(ctx context.Context, filename string, leadingText string) (string, error) {
 	filename = strings.TrimSpace(filename)
-	pageOneText = strings.TrimSpace(pageOneText)
-	if pageOneText == "" {
-		return "", fmt.Errorf("page 1 text is empty")
+	leadingText = strings.TrimSpace(leadingText)
+	if leadingText == "" {
+		return "", fmt.Errorf("leading text is empty")
 	}
 
 	system := prompts.PDFKeyFacts()
@@ -169,8 +168,8 @@ func (r *RAG) SummarizePDFKeyFacts(ctx context.Context, filename string, pageOne
 		user.WriteString(filename)
 		user.WriteString("\n")
 	}
-	user.WriteString("Page 1 text (verbatim):\n")
-	user.WriteString(pageOneText)
+	user.WriteString("Opening pages (verbatim):\n")
+	user.WriteString(leadingText)
 	user.WriteString("\n\nReturn only the Key Facts.")
 
 	msgs := []types.AgentMessage{
@@ -178,7 +177,7 @@ func (r *RAG) SummarizePDFKeyFacts(ctx context.Context, filename string, pageOne
 		{Role: "user", Content: user.String()},
 	}
 
-	summary, err := llmclient.New(r.cfg, r.logger).Chat(ctx, r.cfg.SummarizationLLMHost, msgs, nil)
+	summary, err := r.llm.Chat(ctx, r.cfg.SummarizationLLMHost, msgs, nil)
 	if err != nil {
 		return "", fmt.Errorf("llm chat for pdf key facts failed: %w", err)
 	}
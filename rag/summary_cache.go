@@ -0,0 +1,77 @@
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"stats-agent/metrics"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// Summary kinds stored in the summary cache.
+const (
+	summaryKindFact       = "fact"
+	summaryKindSearchable = "searchable"
+)
+
+// memoryAddressPattern matches object addresses such as "at 0x7f3a2c1b9e50" in printed
+// Python output, which differ between otherwise identical runs.
+var memoryAddressPattern = regexp.MustCompile(`0x[0-9a-fA-F]{6,}`)
+
+// summaryCacheKey hashes a summarization prompt after normalizing it: whitespace runs
+// collapse and memory addresses are masked, so reruns of the same code on the same data
+// share a key. Case is kept, since summaries quote variable names as written. The model
+// and system prompt are part of the key, so changing either starts a fresh cache.
+func summaryCacheKey(kind, model string, messages []types.AgentMessage) string {
+	h := sha256.New()
+	h.Write([]byte(kind + "\x00" + model))
+	for _, msg := range messages {
+		content := memoryAddressPattern.ReplaceAllString(msg.Content, "0x")
+		h.Write([]byte("\x00" + msg.Role + "\x00" + strings.Join(strings.Fields(content), " ")))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// chatSummary sends a summarization prompt to the summarization LLM. With
+// SUMMARY_CACHE_ENABLED the answer is looked up in the database first and stored after a
+// successful call, so a prompt another session already summarized makes no LLM call.
+// Cache failures only cost the lookup: the LLM is called as without the cache.
+func (r *RAG) chatSummary(ctx context.Context, kind string, messages []types.AgentMessage) (string, error) {
+	if !r.cfg.SummaryCacheEnabled {
+		return r.llm.Chat(ctx, r.cfg.SummarizationLLMHost, messages, nil)
+	}
+
+	key := summaryCacheKey(kind, r.cfg.SummarizationLLMModel, messages)
+	var createdAfter time.Time
+	if r.cfg.SummaryCacheTTL > 0 {
+		createdAfter = time.Now().Add(-r.cfg.SummaryCacheTTL)
+	}
+	cached, err := r.store.GetCachedSummary(ctx, key, createdAfter)
+	if err == nil {
+		metrics.SummaryCacheLookups.Inc(kind, "hit")
+		return cached, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		r.logger.Warn("Summary cache lookup failed", zap.String("kind", kind), zap.Error(err))
+	}
+	metrics.SummaryCacheLookups.Inc(kind, "miss")
+
+	summary, err := r.llm.Chat(ctx, r.cfg.SummarizationLLMHost, messages, nil)
+	if err != nil {
+		return "", err
+	}
+	if trimmed := strings.TrimSpace(summary); trimmed != "" {
+		if err := r.store.SaveCachedSummary(ctx, key, kind, trimmed); err != nil {
+			r.logger.Warn("Failed to store summary in cache", zap.String("kind", kind), zap.Error(err))
+		}
+	}
+	return summary, nil
+}
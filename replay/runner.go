@@ -0,0 +1,160 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"stats-agent/agent"
+	"stats-agent/config"
+	"stats-agent/database"
+	"stats-agent/llmclient"
+	"stats-agent/prompts"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var codeBlockPattern = regexp.MustCompile("(?s)```python\\s*\\n?(.*?)```")
+
+// TurnResult compares one recorded turn with its replay.
+type TurnResult struct {
+	Turn int
+	// PromptChanged is true when the turn was recorded under a different system prompt
+	PromptChanged  bool
+	CodeDiff       []string
+	ConclusionDiff []string
+	Duration       time.Duration
+	Err            error
+}
+
+// Changed reports whether the replay produced different code or conclusions.
+func (t TurnResult) Changed() bool {
+	return len(t.CodeDiff) > 0 || len(t.ConclusionDiff) > 0
+}
+
+// Report collects the results of replaying one run.
+type Report struct {
+	SessionID     string
+	RunID         string
+	Host          string
+	PromptVersion string
+	Results       []TurnResult
+}
+
+// Print writes a human-readable summary of the report with the diff of every changed turn.
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Replay of run %s (session %s) against %s, prompt %s\n", r.RunID, r.SessionID, r.Host, r.PromptVersion)
+	changed := 0
+	for _, res := range r.Results {
+		status := "SAME"
+		switch {
+		case res.Err != nil:
+			status = "ERR "
+		case res.Changed():
+			status = "DIFF"
+			changed++
+		}
+		note := ""
+		if res.PromptChanged {
+			note = "  (recorded under another prompt)"
+		}
+		fmt.Fprintf(w, "%s  turn %-3d (%s)%s\n", status, res.Turn, res.Duration.Round(time.Millisecond), note)
+		if res.Err != nil {
+			fmt.Fprintf(w, "      error: %v\n", res.Err)
+			continue
+		}
+		printDiff(w, "code", res.CodeDiff)
+		printDiff(w, "conclusions", res.ConclusionDiff)
+	}
+	fmt.Fprintf(w, "%d/%d turns changed\n", changed, len(r.Results))
+}
+
+func printDiff(w io.Writer, label string, diff []string) {
+	if len(diff) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "      %s:\n", label)
+	for _, line := range diff {
+		fmt.Fprintf(w, "        %s\n", line)
+	}
+}
+
+// Runner replays recorded runs offline: each turn's recorded messages (history, retrieval
+// results, and tool outputs as they were) are re-sent with the current system prompt to
+// the replay host. No code is executed and nothing is written back to the session.
+type Runner struct {
+	cfg    *config.Config
+	store  database.Store
+	client llmclient.ChatClient
+	logger *zap.Logger
+}
+
+// NewRunner creates a replay runner that sends turns through client.
+func NewRunner(cfg *config.Config, store database.Store, client llmclient.ChatClient, logger *zap.Logger) *Runner {
+	return &Runner{cfg: cfg, store: store, client: client, logger: logger}
+}
+
+// Run replays a recorded run of the session; an empty runID selects the most recent one.
+func (r *Runner) Run(ctx context.Context, sessionID uuid.UUID, runID string) (Report, error) {
+	turns, err := r.store.GetRunTurns(ctx, sessionID, runID)
+	if err != nil {
+		return Report{}, err
+	}
+	if len(turns) == 0 {
+		return Report{}, fmt.Errorf("no recorded turns for session %s", sessionID)
+	}
+
+	host := r.cfg.ReplayLLMHost
+	if host == "" {
+		host = r.cfg.MainLLMHost
+	}
+	report := Report{
+		SessionID:     sessionID.String(),
+		RunID:         turns[0].RunID,
+		Host:          host,
+		PromptVersion: agent.SystemPromptVersion(),
+	}
+
+	systemMessage := types.AgentMessage{Role: "system", Content: prompts.AgentSystem()}
+	for _, turn := range turns {
+		r.logger.Info("Replaying turn", zap.String("run_id", turn.RunID), zap.Int("turn", turn.Turn))
+		start := time.Now()
+		res := TurnResult{Turn: turn.Turn, PromptChanged: turn.PromptVersion != report.PromptVersion}
+
+		messages := append([]types.AgentMessage{systemMessage}, turn.Messages...)
+		temperature := turn.Temperature
+		response, err := r.client.Chat(ctx, host, messages, &temperature)
+		res.Duration = time.Since(start)
+		if err != nil {
+			res.Err = err
+		} else {
+			res.CodeDiff = diffLines(extractCode(turn.Response), extractCode(response))
+			res.ConclusionDiff = diffLines(extractConclusions(turn.Response), extractConclusions(response))
+		}
+		report.Results = append(report.Results, res)
+
+		if ctx.Err() != nil {
+			return report, fmt.Errorf("replay did not finish: %w", ctx.Err())
+		}
+	}
+	return report, nil
+}
+
+// extractCode returns the response's Python code blocks, joined.
+func extractCode(response string) string {
+	var blocks []string
+	for _, match := range codeBlockPattern.FindAllStringSubmatch(response, -1) {
+		blocks = append(blocks, strings.TrimSpace(match[1]))
+	}
+	return strings.Join(blocks, "\n")
+}
+
+// extractConclusions returns the response's prose with code blocks removed.
+func extractConclusions(response string) string {
+	return strings.TrimSpace(codeBlockPattern.ReplaceAllString(response, ""))
+}
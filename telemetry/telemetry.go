@@ -0,0 +1,229 @@
+// Package telemetry reports anonymous aggregate usage to the maintainers when the operator
+// opts in with TELEMETRY_ENABLED. A report holds counts only: runs, turns, executed cells,
+// execution errors and analyses by test type. No session IDs, messages, code, outputs,
+// file names, column names or host details are collected, and nothing identifies the
+// installation. Setting DO_NOT_TRACK, or the endpoint answering 410 Gone, turns it off.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"stats-agent/config"
+	"stats-agent/web/types"
+
+	"go.uber.org/zap"
+)
+
+// SchemaVersion is bumped whenever a field is added to, renamed in or removed from Report.
+const SchemaVersion = 1
+
+// Report is the JSON payload POSTed to TELEMETRY_ENDPOINT once per TELEMETRY_INTERVAL.
+// Periods are truncated to the hour so reports cannot be matched to individual runs.
+type Report struct {
+	SchemaVersion      int            `json:"schema_version"`
+	PeriodStart        time.Time      `json:"period_start"`
+	PeriodEnd          time.Time      `json:"period_end"`
+	Runs               int            `json:"runs"`
+	AverageTurnsPerRun float64        `json:"average_turns_per_run"`
+	Executions         int            `json:"executions"`
+	ExecutionErrors    int            `json:"execution_errors"`
+	ErrorRate          float64        `json:"error_rate"`
+	AnalysesByTest     map[string]int `json:"analyses_by_test"`
+}
+
+// Reporter aggregates finished runs and sends one report per interval. A nil *Reporter
+// means telemetry is off; its methods are no-ops.
+type Reporter struct {
+	cfg        *config.Config
+	logger     *zap.Logger
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	periodStart time.Time
+	current     counts
+	stopped     bool // set by the endpoint's kill switch
+}
+
+// counts are the totals of one reporting period.
+type counts struct {
+	runs       int
+	turns      int
+	executions int
+	errors     int
+	tests      map[string]int
+}
+
+func (c *counts) add(other counts) {
+	c.runs += other.runs
+	c.turns += other.turns
+	c.executions += other.executions
+	c.errors += other.errors
+	if c.tests == nil {
+		c.tests = make(map[string]int, len(other.tests))
+	}
+	for test, count := range other.tests {
+		c.tests[test] += count
+	}
+}
+
+// New returns a reporter when TELEMETRY_ENABLED is set and DO_NOT_TRACK is not, else nil.
+func New(cfg *config.Config, logger *zap.Logger) *Reporter {
+	if cfg == nil || !cfg.TelemetryEnabled {
+		return nil
+	}
+	if doNotTrack() {
+		logger.Info("Telemetry disabled by DO_NOT_TRACK")
+		return nil
+	}
+	return &Reporter{
+		cfg:         cfg,
+		logger:      logger,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		periodStart: hour(time.Now()),
+	}
+}
+
+// doNotTrack honours the DO_NOT_TRACK convention: any value other than empty, 0 or false.
+func doNotTrack() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("DO_NOT_TRACK"))) {
+	case "", "0", "false":
+		return false
+	}
+	return true
+}
+
+func hour(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Hour)
+}
+
+// RecordRun adds a finished run to the current period.
+func (r *Reporter) RecordRun(usage types.RunUsage) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current.add(counts{
+		runs:       1,
+		turns:      usage.Turns,
+		executions: usage.Executions,
+		errors:     usage.ExecutionErrors,
+		tests:      usage.Tests,
+	})
+}
+
+func buildReport(c counts, start, end time.Time) Report {
+	report := Report{
+		SchemaVersion:   SchemaVersion,
+		PeriodStart:     start,
+		PeriodEnd:       end,
+		Runs:            c.runs,
+		Executions:      c.executions,
+		ExecutionErrors: c.errors,
+		AnalysesByTest:  make(map[string]int, len(c.tests)),
+	}
+	if c.runs > 0 {
+		report.AverageTurnsPerRun = float64(c.turns) / float64(c.runs)
+	}
+	if c.executions > 0 {
+		report.ErrorRate = float64(c.errors) / float64(c.executions)
+	}
+	for test, count := range c.tests {
+		report.AnalysesByTest[test] = count
+	}
+	return report
+}
+
+// Start sends a report every TELEMETRY_INTERVAL until ctx is done. Periods without runs
+// are not reported; a failed send keeps the counts for the next attempt.
+func (r *Reporter) Start(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	r.logger.Info("Anonymous usage telemetry enabled",
+		zap.String("endpoint", r.cfg.TelemetryEndpoint),
+		zap.Duration("interval", r.cfg.TelemetryInterval))
+
+	ticker := time.NewTicker(r.cfg.TelemetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.flush(ctx) {
+				return
+			}
+		}
+	}
+}
+
+// flush sends the current period's report and starts a new period. It returns false once
+// the endpoint has switched telemetry off.
+func (r *Reporter) flush(ctx context.Context) bool {
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return false
+	}
+	if r.current.runs == 0 {
+		r.mu.Unlock()
+		return true
+	}
+	taken, start, end := r.current, r.periodStart, hour(time.Now())
+	r.current, r.periodStart = counts{}, end
+	r.mu.Unlock()
+
+	gone, err := r.send(ctx, buildReport(taken, start, end))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case gone:
+		r.stopped = true
+		r.logger.Info("Telemetry endpoint asked to stop reporting; telemetry disabled")
+		return false
+	case err != nil:
+		// Keep the counts: the next report covers both periods
+		r.logger.Warn("Failed to send usage telemetry", zap.Error(err))
+		r.current.add(taken)
+		r.periodStart = start
+	}
+	return true
+}
+
+// send POSTs the report. gone reports the kill switch: the endpoint answered 410 Gone.
+func (r *Reporter) send(ctx context.Context, report Report) (gone bool, err error) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode telemetry report: %w", err)
+	}
+	// Logged in full so operators can see exactly what leaves the server
+	r.logger.Info("Sending usage telemetry", zap.ByteString("report", body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.TelemetryEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create telemetry request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to send telemetry report: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode == http.StatusGone {
+		return true, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, fmt.Errorf("telemetry endpoint returned %s", resp.Status)
+	}
+	return false, nil
+}
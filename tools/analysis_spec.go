@@ -0,0 +1,349 @@
+package tools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"stats-agent/web/types"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// ErrInvalidAnalysisSpec is returned for analysis specs that cannot be compiled.
+var ErrInvalidAnalysisSpec = errors.New("invalid analysis spec")
+
+// analysisTest describes one test an analysis spec can name: how many predictors it
+// takes, whether it accepts a one-sided alternative, and its code template.
+type analysisTest struct {
+	minPredictors int
+	maxPredictors int // 0 = no limit
+	alternative   bool
+	code          func(spec types.AnalysisSpec) string
+}
+
+var analysisTests = map[string]analysisTest{
+	"t_test":        {minPredictors: 1, maxPredictors: 1, alternative: true, code: twoGroupCode},
+	"mann_whitney":  {minPredictors: 1, maxPredictors: 1, alternative: true, code: twoGroupCode},
+	"paired_t_test": {minPredictors: 1, maxPredictors: 1, alternative: true, code: pairedCode},
+	"wilcoxon":      {minPredictors: 1, maxPredictors: 1, alternative: true, code: pairedCode},
+	"anova":         {minPredictors: 1, maxPredictors: 1, code: multiGroupCode},
+	"kruskal":       {minPredictors: 1, maxPredictors: 1, code: multiGroupCode},
+	"chi_square":    {minPredictors: 1, maxPredictors: 1, code: chiSquareCode},
+	"pearson":       {minPredictors: 1, maxPredictors: 1, alternative: true, code: correlationCode},
+	"spearman":      {minPredictors: 1, maxPredictors: 1, alternative: true, code: correlationCode},
+	"ols":           {minPredictors: 1, code: regressionCode},
+	"logit":         {minPredictors: 1, code: regressionCode},
+}
+
+// AnalysisSpecTests lists the test names an analysis spec accepts.
+func AnalysisSpecTests() []string {
+	names := make([]string, 0, len(analysisTests))
+	for name := range analysisTests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseAnalysisSpec decodes a YAML or JSON analysis spec (JSON is valid YAML), normalizes
+// it and validates it. Unknown fields are rejected so typos don't silently fall back to
+// defaults.
+func ParseAnalysisSpec(data []byte) (types.AnalysisSpec, error) {
+	var spec types.AnalysisSpec
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil {
+		return spec, fmt.Errorf("%w: %v", ErrInvalidAnalysisSpec, err)
+	}
+
+	spec.Dataset = strings.TrimSpace(spec.Dataset)
+	spec.Outcome = strings.TrimSpace(spec.Outcome)
+	spec.Test = strings.ToLower(strings.TrimSpace(spec.Test))
+	spec.Options.Alternative = strings.ToLower(strings.TrimSpace(spec.Options.Alternative))
+	for i, p := range spec.Predictors {
+		spec.Predictors[i] = strings.TrimSpace(p)
+	}
+	return spec, ValidateAnalysisSpec(spec)
+}
+
+// ValidateAnalysisSpec checks that a spec names a known test with the predictors it needs
+// and a dataset file in the session workspace.
+func ValidateAnalysisSpec(spec types.AnalysisSpec) error {
+	test, ok := analysisTests[spec.Test]
+	if !ok {
+		return fmt.Errorf("%w: unknown test %q (one of %s)", ErrInvalidAnalysisSpec, spec.Test, strings.Join(AnalysisSpecTests(), ", "))
+	}
+	if spec.Dataset == "" {
+		return fmt.Errorf("%w: dataset is required", ErrInvalidAnalysisSpec)
+	}
+	if filepath.Base(spec.Dataset) != spec.Dataset || strings.ContainsAny(spec.Dataset, `/\`) || strings.HasPrefix(spec.Dataset, ".") {
+		return fmt.Errorf("%w: dataset must be a file name in the session workspace", ErrInvalidAnalysisSpec)
+	}
+	if !IsDatasetFile(spec.Dataset) {
+		return fmt.Errorf("%w: dataset must be a CSV, Excel or Parquet file", ErrInvalidAnalysisSpec)
+	}
+	if spec.Outcome == "" {
+		return fmt.Errorf("%w: outcome is required", ErrInvalidAnalysisSpec)
+	}
+	// The names are emitted as Python string literals, which must stay on one line
+	for _, name := range append([]string{spec.Dataset, spec.Outcome}, spec.Predictors...) {
+		if strings.ContainsFunc(name, unicode.IsControl) {
+			return fmt.Errorf("%w: names must not contain control characters", ErrInvalidAnalysisSpec)
+		}
+	}
+	if len(spec.Predictors) < test.minPredictors || (test.maxPredictors > 0 && len(spec.Predictors) > test.maxPredictors) {
+		if test.minPredictors == test.maxPredictors {
+			return fmt.Errorf("%w: %s takes exactly %d predictor", ErrInvalidAnalysisSpec, spec.Test, test.minPredictors)
+		}
+		return fmt.Errorf("%w: %s needs at least %d predictor", ErrInvalidAnalysisSpec, spec.Test, test.minPredictors)
+	}
+	seen := map[string]bool{spec.Outcome: true}
+	for _, p := range spec.Predictors {
+		if p == "" {
+			return fmt.Errorf("%w: empty predictor name", ErrInvalidAnalysisSpec)
+		}
+		if seen[p] {
+			return fmt.Errorf("%w: column %q is used twice", ErrInvalidAnalysisSpec, p)
+		}
+		seen[p] = true
+	}
+	if alpha := spec.Options.Alpha; alpha < 0 || alpha >= 1 {
+		return fmt.Errorf("%w: alpha must be between 0 and 1", ErrInvalidAnalysisSpec)
+	}
+	switch spec.Options.Alternative {
+	case "", "two-sided":
+	case "less", "greater":
+		if !test.alternative {
+			return fmt.Errorf("%w: %s only supports a two-sided alternative", ErrInvalidAnalysisSpec, spec.Test)
+		}
+	default:
+		return fmt.Errorf("%w: alternative must be two-sided, less or greater", ErrInvalidAnalysisSpec)
+	}
+	if spec.Options.EqualVar && spec.Test != "t_test" {
+		return fmt.Errorf("%w: equal_var only applies to t_test", ErrInvalidAnalysisSpec)
+	}
+	if spec.Options.Robust && spec.Test != "ols" && spec.Test != "logit" {
+		return fmt.Errorf("%w: robust only applies to ols and logit", ErrInvalidAnalysisSpec)
+	}
+	return nil
+}
+
+// AnalysisSpecCode compiles a spec to the Python it runs. The code is deterministic: it
+// loads the dataset into df, drops rows missing any used column, runs the templated test
+// and prints the statistic, p-value and decision at alpha, with an effect size where the
+// test has a standard one.
+func AnalysisSpecCode(spec types.AnalysisSpec) (string, error) {
+	if err := ValidateAnalysisSpec(spec); err != nil {
+		return "", err
+	}
+	alpha := spec.Options.Alpha
+	if alpha == 0 {
+		alpha = 0.05
+	}
+
+	if spec.Test == "chi_square" {
+		return chiSquareCode(spec), nil
+	}
+
+	var b strings.Builder
+	// Names from the spec only appear in Python string literals, never in comments
+	fmt.Fprintf(&b, "# Analysis spec: %s\n", spec.Test)
+	b.WriteString(loadDatasetCode(spec.Dataset))
+	columns := append([]string{spec.Outcome}, spec.Predictors...)
+	fmt.Fprintf(&b, `spec_data = df[%s].dropna().copy()
+alpha = %g
+print(f"Rows used: {len(spec_data)} of {len(df)} ({len(df) - len(spec_data)} dropped for missing values)")
+`, pyStringList(columns), alpha)
+	b.WriteString(analysisTests[spec.Test].code(spec))
+	return b.String(), nil
+}
+
+// loadDatasetCode reads a workspace dataset file into df.
+func loadDatasetCode(dataset string) string {
+	return "df = " + PandasReadCall(dataset) + "\n"
+}
+
+// pyStringList formats names as a Python list literal.
+func pyStringList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = fmt.Sprintf("%q", n)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func pyBool(v bool) string {
+	if v {
+		return "True"
+	}
+	return "False"
+}
+
+func alternativeOf(spec types.AnalysisSpec) string {
+	if spec.Options.Alternative == "" {
+		return "two-sided"
+	}
+	return spec.Options.Alternative
+}
+
+const decisionCode = `print(f"Decision at alpha={alpha}: {'reject' if p_value < alpha else 'fail to reject'} H0")
+`
+
+// twoGroupCode compares the outcome between the two levels of the grouping predictor,
+// with Cohen's d (t-test) or the rank-biserial correlation (Mann-Whitney).
+func twoGroupCode(spec types.AnalysisSpec) string {
+	var test string
+	if spec.Test == "t_test" {
+		name := "Welch's t-test"
+		if spec.Options.EqualVar {
+			name = "Student's t-test"
+		}
+		test = fmt.Sprintf(`    res = stats.ttest_ind(a, b, equal_var=%s, alternative=%q)
+    p_value = res.pvalue
+    pooled_sd = (((len(a) - 1) * a.var() + (len(b) - 1) * b.var()) / (len(a) + len(b) - 2)) ** 0.5
+    cohens_d = (a.mean() - b.mean()) / pooled_sd
+    print(f"%s: t={res.statistic:.3f}, df={getattr(res, 'df', float('nan')):.1f}, p={p_value:.4g}")
+    print(f"Cohen's d={cohens_d:.3f}")
+`, pyBool(spec.Options.EqualVar), alternativeOf(spec), name)
+	} else {
+		test = fmt.Sprintf(`    res = stats.mannwhitneyu(a, b, alternative=%q)
+    p_value = res.pvalue
+    rank_biserial = 1 - 2 * res.statistic / (len(a) * len(b))
+    print(f"Mann-Whitney U: U={res.statistic:.1f}, p={p_value:.4g}")
+    print(f"Rank-biserial correlation={rank_biserial:.3f}")
+`, alternativeOf(spec))
+	}
+	return fmt.Sprintf(`outcome, group = %[3]q, %[2]q
+groups = sorted(spec_data[group].unique(), key=str)
+if len(groups) != 2:
+    print(f"Error: %[1]s needs exactly 2 groups in {group!r}, found {len(groups)}")
+else:
+    a = spec_data.loc[spec_data[group] == groups[0], outcome]
+    b = spec_data.loc[spec_data[group] == groups[1], outcome]
+    for label, values in ((groups[0], a), (groups[1], b)):
+        print(f"{label}: n={len(values)}, mean={values.mean():.3f}, sd={values.std():.3f}, median={values.median():.3f}")
+%[4]s    %[5]s`, spec.Test, spec.Predictors[0], spec.Outcome, test, decisionCode)
+}
+
+// pairedCode compares two measures taken on the same rows (outcome vs the predictor).
+func pairedCode(spec types.AnalysisSpec) string {
+	var test string
+	if spec.Test == "paired_t_test" {
+		test = fmt.Sprintf(`res = stats.ttest_rel(a, b, alternative=%q)
+p_value = res.pvalue
+diff = a - b
+print(f"Paired t-test: t={res.statistic:.3f}, df={len(diff) - 1}, p={p_value:.4g}")
+print(f"Mean difference={diff.mean():.3f}, Cohen's d_z={diff.mean() / diff.std():.3f}")
+`, alternativeOf(spec))
+	} else {
+		test = fmt.Sprintf(`res = stats.wilcoxon(a, b, alternative=%q)
+p_value = res.pvalue
+print(f"Wilcoxon signed-rank: W={res.statistic:.1f}, p={p_value:.4g}")
+print(f"Median difference={(a - b).median():.3f}")
+`, alternativeOf(spec))
+	}
+	return fmt.Sprintf(`a = spec_data[%q]
+b = spec_data[%q]
+print(f"{a.name}: mean={a.mean():.3f}, sd={a.std():.3f}; {b.name}: mean={b.mean():.3f}, sd={b.std():.3f}")
+`, spec.Outcome, spec.Predictors[0]) + test + decisionCode
+}
+
+// multiGroupCode compares the outcome across every level of the grouping predictor,
+// with eta squared (ANOVA) or epsilon squared (Kruskal-Wallis).
+func multiGroupCode(spec types.AnalysisSpec) string {
+	var test string
+	if spec.Test == "anova" {
+		test = `    res = stats.f_oneway(*samples)
+    p_value = res.pvalue
+    grand_mean = spec_data[outcome].mean()
+    ss_between = sum(len(s) * (s.mean() - grand_mean) ** 2 for s in samples)
+    ss_total = ((spec_data[outcome] - grand_mean) ** 2).sum()
+    print(f"One-way ANOVA: F={res.statistic:.3f}, df=({len(samples) - 1}, {len(spec_data) - len(samples)}), p={p_value:.4g}")
+    print(f"Eta squared={ss_between / ss_total:.3f}")
+`
+	} else {
+		test = `    res = stats.kruskal(*samples)
+    p_value = res.pvalue
+    print(f"Kruskal-Wallis: H={res.statistic:.3f}, df={len(samples) - 1}, p={p_value:.4g}")
+    print(f"Epsilon squared={res.statistic / (len(spec_data) - 1):.3f}")
+`
+	}
+	return fmt.Sprintf(`outcome, group = %[3]q, %[2]q
+groups = sorted(spec_data[group].unique(), key=str)
+if len(groups) < 2:
+    print(f"Error: %[1]s needs at least 2 groups in {group!r}, found {len(groups)}")
+else:
+    samples = [spec_data.loc[spec_data[group] == g, outcome] for g in groups]
+    for label, values in zip(groups, samples):
+        print(f"{label}: n={len(values)}, mean={values.mean():.3f}, sd={values.std():.3f}, median={values.median():.3f}")
+%[4]s    %[5]s`, spec.Test, spec.Predictors[0], spec.Outcome, test, decisionCode)
+}
+
+// chiSquareCode reuses the crosstab template with the predictor as rows.
+func chiSquareCode(spec types.AnalysisSpec) string {
+	return "# Analysis spec: chi_square\n" +
+		CrosstabCode(spec.Dataset, spec.Predictors[0], spec.Outcome)
+}
+
+// correlationCode correlates the outcome with the predictor.
+func correlationCode(spec types.AnalysisSpec) string {
+	fn, name, stat := "pearsonr", "Pearson", "r"
+	if spec.Test == "spearman" {
+		fn, name, stat = "spearmanr", "Spearman", "rho"
+	}
+	return fmt.Sprintf(`res = stats.%s(spec_data[%q], spec_data[%q], alternative=%q)
+p_value = res.pvalue
+print(f"%s correlation: %s={res.statistic:.3f}, n={len(spec_data)}, p={p_value:.4g}")
+`, fn, spec.Outcome, spec.Predictors[0], alternativeOf(spec), name, stat) + decisionCode
+}
+
+// regressionCode fits an OLS or logistic regression of the outcome on the predictors with
+// statsmodels formulas. Non-numeric predictors are treated as categorical; a logit
+// outcome must have exactly two levels and the later one (in sorted order) is coded 1.
+func regressionCode(spec types.AnalysisSpec) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `import statsmodels.formula.api as smf
+outcome = %q
+predictors = %s
+formula = f"Q({outcome!r}) ~ " + " + ".join(f"Q({c!r})" for c in predictors)
+`, spec.Outcome, pyStringList(spec.Predictors))
+	fit := "fit()"
+	if spec.Test == "ols" {
+		if spec.Options.Robust {
+			fit = `fit(cov_type="HC3")`
+		}
+		fmt.Fprintf(&b, `model = smf.ols(formula, data=spec_data).%s
+print(model.summary())
+p_value = model.f_pvalue
+print(f"R-squared={model.rsquared:.3f}, adjusted R-squared={model.rsquared_adj:.3f}")
+print(f"Overall model: F={model.fvalue:.3f}, p={p_value:.4g}")
+%s`, fit, decisionCode)
+		return b.String()
+	}
+
+	fit = "fit(disp=0)"
+	if spec.Options.Robust {
+		fit = `fit(disp=0, cov_type="HC3")`
+	}
+	fmt.Fprintf(&b, `levels = sorted(spec_data[outcome].unique(), key=str)
+if len(levels) != 2:
+    print(f"Error: logit needs a binary outcome, {outcome!r} has {len(levels)} levels")
+else:
+    spec_data[outcome] = (spec_data[outcome] == levels[1]).astype(int)
+    print(f"Outcome coded 1 = {levels[1]!r}, 0 = {levels[0]!r}")
+    model = smf.logit(formula, data=spec_data).%s
+    print(model.summary())
+    odds = pd.concat([np.exp(model.params), np.exp(model.conf_int())], axis=1)
+    odds.columns = ["odds_ratio", "ci_lower", "ci_upper"]
+    print("\nOdds ratios:")
+    print(odds.round(3))
+    p_value = model.llr_pvalue
+    print(f"Pseudo R-squared={model.prsquared:.3f}, likelihood ratio p={p_value:.4g}")
+    %s`, fit, decisionCode)
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// CompareDatasets profiles two datasets in the session workspace and reports a schema diff,
+// per-column distribution shift tests, and common-key join feasibility.
+// When fileA or fileB is empty, the two most recently modified dataset files are used.
+func (t *StatefulPythonTool) CompareDatasets(ctx context.Context, sessionID, fileA, fileB string) (string, error) {
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", "\\'") + "'"
+	}
+
+	compareCode := fmt.Sprintf(`
+import os
+import pandas as pd
+import numpy as np
+from scipy import stats as _cmp_stats
+
+def _cmp_load(name):
+    if name.lower().endswith(('.xlsx', '.xls')):
+        return pd.read_excel(name)
+    if name.lower().endswith('.parquet'):
+        return pd.read_parquet(name)
+    return pd.read_csv(name)
+
+_cmp_files = [f for f in (%s, %s) if f]
+if len(_cmp_files) < 2:
+    _cmp_candidates = [f for f in os.listdir(os.getcwd()) if f.lower().endswith(('.csv', '.csv.gz', '.xlsx', '.xls', '.parquet'))]
+    _cmp_candidates.sort(key=lambda f: os.path.getmtime(f))
+    _cmp_picked = [f for f in reversed(_cmp_candidates) if f not in _cmp_files][:2 - len(_cmp_files)]
+    # A named file stays A; picked files follow it, the older one first
+    _cmp_files += sorted(_cmp_picked, key=lambda f: os.path.getmtime(f))
+
+if len(_cmp_files) < 2:
+    print("Error: dataset comparison needs two uploaded dataset files")
+else:
+    _a_name, _b_name = _cmp_files[0], _cmp_files[1]
+    _cmp_a, _cmp_b = _cmp_load(_a_name), _cmp_load(_b_name)
+    print("DATASET COMPARISON")
+    print(f"A: {_a_name} shape={_cmp_a.shape}")
+    print(f"B: {_b_name} shape={_cmp_b.shape}")
+
+    _cols_a, _cols_b = set(_cmp_a.columns), set(_cmp_b.columns)
+    _common = [c for c in _cmp_a.columns if c in _cols_b]
+    print("\nSCHEMA DIFF")
+    print(f"common_columns={len(_common)}")
+    print(f"only_in_A={sorted(map(str, _cols_a - _cols_b))}")
+    print(f"only_in_B={sorted(map(str, _cols_b - _cols_a))}")
+    for c in _common:
+        if str(_cmp_a[c].dtype) != str(_cmp_b[c].dtype):
+            print(f"dtype_mismatch: {c} A={_cmp_a[c].dtype} B={_cmp_b[c].dtype}")
+
+    print("\nDISTRIBUTION SHIFT")
+    for c in _common:
+        _xa, _xb = _cmp_a[c].dropna(), _cmp_b[c].dropna()
+        if len(_xa) < 2 or len(_xb) < 2:
+            continue
+        _miss = f"missing A={_cmp_a[c].isna().mean():.1%%} B={_cmp_b[c].isna().mean():.1%%}"
+        if pd.api.types.is_numeric_dtype(_xa) and pd.api.types.is_numeric_dtype(_xb):
+            _ks = _cmp_stats.ks_2samp(_xa, _xb)
+            print(f"{c}: numeric mean A={_xa.mean():.3f} B={_xb.mean():.3f} KS D={_ks.statistic:.3f}, p={_ks.pvalue:.4f}; {_miss}")
+        else:
+            _levels = sorted(set(_xa.astype(str)) | set(_xb.astype(str)))
+            if len(_levels) > 50:
+                print(f"{c}: categorical with {len(_levels)} levels (skipped chi2); {_miss}")
+                continue
+            _table = np.array([[(_xa.astype(str) == l).sum() for l in _levels],
+                               [(_xb.astype(str) == l).sum() for l in _levels]])
+            _table = _table[:, _table.sum(axis=0) > 0]
+            if _table.shape[1] < 2:
+                print(f"{c}: single level in both datasets; {_miss}")
+                continue
+            _chi2, _p, _dof, _ = _cmp_stats.chi2_contingency(_table)
+            print(f"{c}: categorical chi2={_chi2:.3f}, dof={_dof}, p={_p:.4f}; {_miss}")
+
+    print("\nJOIN FEASIBILITY")
+    _keys = []
+    for c in _common:
+        _ua, _ub = _cmp_a[c].dropna(), _cmp_b[c].dropna()
+        if len(_ua) == 0 or len(_ub) == 0:
+            continue
+        if _ua.is_unique or _ub.is_unique:
+            _overlap = len(set(_ua.astype(str)) & set(_ub.astype(str)))
+            _coverage = _overlap / max(1, min(_ua.nunique(), _ub.nunique()))
+            _keys.append((c, _ua.is_unique, _ub.is_unique, _overlap, _coverage))
+    if not _keys:
+        print("No candidate key columns (no common column is unique in either dataset)")
+    for c, _uniq_a, _uniq_b, _overlap, _coverage in sorted(_keys, key=lambda k: -k[4]):
+        _card = "1:1" if _uniq_a and _uniq_b else ("1:many" if _uniq_a else "many:1")
+        print(f"{c}: {_card} overlapping_keys={_overlap} coverage={_coverage:.1%%}")
+    # The kernel is the session's; do not leave the loaded frames behind
+    del _cmp_a, _cmp_b
+`, quote(fileA), quote(fileB))
+
+	return t.Call(ctx, compareCode, sessionID)
+}
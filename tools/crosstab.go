@@ -0,0 +1,116 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// crosstabMarker prefixes the JSON line printed by the crosstab column probe.
+const crosstabMarker = "<<CROSSTAB_COLUMNS>>"
+
+// CrosstabFrame describes the data a crosstab would run on: the session's loaded df, or
+// the primary dataset when no df is loaded yet.
+type CrosstabFrame struct {
+	// Dataset is the file to load into df first; "" when df is already loaded
+	Dataset string           `json:"dataset"`
+	Columns []CrosstabColumn `json:"columns"`
+}
+
+// CrosstabColumn is one column of the frame with its number of distinct values.
+type CrosstabColumn struct {
+	Name   string `json:"name"`
+	Levels int    `json:"levels"`
+}
+
+// CrosstabFrame reports the columns a crosstab question can refer to. It does not
+// modify the session namespace.
+func (t *StatefulPythonTool) CrosstabFrame(ctx context.Context, sessionID string) (CrosstabFrame, error) {
+	code := fmt.Sprintf(`
+def _report_crosstab_frame():
+    import json, os
+    import pandas as pd
+    dataset = ""
+    frame = globals().get("df")
+    if not isinstance(frame, pd.DataFrame):
+        tabular = ('.csv', '.csv.gz', '.xlsx', '.xls', '.parquet')
+        files = [f for f in globals().get("uploaded_files", []) if f.lower().endswith(tabular)]
+        if not files:
+            files = sorted((f for f in os.listdir(os.getcwd()) if f.lower().endswith(tabular)), key=os.path.getmtime, reverse=True)
+        if not files:
+            print("Error: no dataset loaded or uploaded")
+            return
+        dataset = files[0]
+        if dataset.lower().endswith(('.xlsx', '.xls')):
+            frame = pd.read_excel(dataset)
+        elif dataset.lower().endswith('.parquet'):
+            frame = pd.read_parquet(dataset)
+        else:
+            frame = pd.read_csv(dataset)
+    columns = [{"name": c, "levels": int(frame[c].nunique(dropna=True))} for c in frame.columns if isinstance(c, str)]
+    print(%q + json.dumps({"dataset": dataset, "columns": columns}))
+
+_report_crosstab_frame()
+del _report_crosstab_frame
+`, crosstabMarker)
+
+	var frame CrosstabFrame
+	output, err := t.Call(ctx, code, sessionID)
+	if err != nil {
+		return frame, err
+	}
+	idx := strings.Index(output, crosstabMarker)
+	if idx < 0 {
+		return frame, fmt.Errorf("crosstab probe failed: %s", strings.TrimSpace(output))
+	}
+	line := output[idx+len(crosstabMarker):]
+	if nl := strings.IndexByte(line, '\n'); nl >= 0 {
+		line = line[:nl]
+	}
+	if err := json.Unmarshal([]byte(line), &frame); err != nil {
+		return frame, fmt.Errorf("failed to parse crosstab probe: %w", err)
+	}
+	return frame, nil
+}
+
+// CrosstabCode returns the templated contingency analysis of two categorical columns:
+// the table, expected counts, Cochran's expected-count rule, and the test it calls for
+// (chi-square when it holds, Fisher's exact test for a 2x2 table when it does not), with
+// Cramér's V. A non-empty dataset is loaded into df first.
+func CrosstabCode(dataset, rowColumn, colColumn string) string {
+	var b strings.Builder
+	if dataset != "" {
+		fmt.Fprintf(&b, "df = %s\n", PandasReadCall(dataset))
+	}
+	fmt.Fprintf(&b, `ct = pd.crosstab(df[%q], df[%q])
+print("Contingency table:")
+print(ct)
+
+chi2, p_chi2, dof, expected = stats.chi2_contingency(ct)
+expected = pd.DataFrame(expected, index=ct.index, columns=ct.columns)
+print("\nExpected counts:")
+print(expected.round(2))
+
+# Cochran's rule: no expected count below 1 and at most 20%% below 5
+min_expected = expected.to_numpy().min()
+share_below_5 = (expected.to_numpy() < 5).mean()
+print(f"\nAssumption check: min expected count={min_expected:.2f}, cells with expected < 5: {share_below_5:.0%%}")
+cochran_ok = min_expected >= 1 and share_below_5 <= 0.2
+n = ct.to_numpy().sum()
+cramers_v = (chi2 / (n * (min(ct.shape) - 1))) ** 0.5
+
+if cochran_ok:
+    print("Assumption met: expected counts are large enough for the chi-square approximation")
+    print(f"Chi-square: chi2={chi2:.3f}, df={dof}, p={p_chi2:.4g}")
+elif ct.shape == (2, 2):
+    odds_ratio, p_fisher = stats.fisher_exact(ct)
+    print("Assumption violated: expected counts too small for chi-square; using Fisher's exact test")
+    print(f"Fisher's exact test: odds ratio={odds_ratio:.3f}, p={p_fisher:.4g}")
+else:
+    print("Assumption violated: expected counts too small for chi-square; merge sparse categories or use an exact/Monte Carlo test")
+    print(f"Chi-square (unreliable): chi2={chi2:.3f}, df={dof}, p={p_chi2:.4g}")
+print(f"Cramér's V={cramers_v:.3f}")
+`, rowColumn, colColumn)
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// datasetSuffixes are the tabular file types the executor helpers read, ".csv.gz" before
+// ".csv" so it wins. pd.read_csv decompresses .csv.gz itself; Parquet needs
+// pd.read_parquet (pyarrow). The Python helpers list the same suffixes.
+var datasetSuffixes = []string{".csv.gz", ".parquet", ".xlsx", ".xls", ".csv"}
+
+// DatasetExt returns a dataset file's type suffix in lower case (".csv.gz" counts as one),
+// or "" when the file is not a CSV, gzipped CSV, Excel or Parquet file.
+func DatasetExt(filename string) string {
+	lower := strings.ToLower(filename)
+	for _, suffix := range datasetSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return suffix
+		}
+	}
+	return ""
+}
+
+// IsDatasetFile reports whether filename is a CSV, gzipped CSV, Excel or Parquet file.
+func IsDatasetFile(filename string) bool {
+	return DatasetExt(filename) != ""
+}
+
+// DatasetStem returns filename without its dataset suffix ("sales.csv.gz" → "sales").
+func DatasetStem(filename string) string {
+	ext := DatasetExt(filename)
+	return filename[:len(filename)-len(ext)]
+}
+
+// PandasReadCall returns the pandas call that loads a dataset file, such as
+// pd.read_parquet("sales.parquet").
+func PandasReadCall(filename string) string {
+	switch DatasetExt(filename) {
+	case ".xlsx", ".xls":
+		return fmt.Sprintf("pd.read_excel(%q)", filename)
+	case ".parquet":
+		return fmt.Sprintf("pd.read_parquet(%q)", filename)
+	default:
+		return fmt.Sprintf("pd.read_csv(%q)", filename)
+	}
+}
+
+// sqlTablesPython defines the Python helpers the <sql> tool and the SQL console share:
+// _sqlds_table(f) returns the DuckDB table name of a workspace file, the file name without
+// its dataset suffix (".csv.gz" counts as one) with non-identifier characters turned into
+// "_", or None when f is not a dataset; _sqlds_load(con, f, name) (re)creates the table.
+// Excel files go through pandas, since DuckDB does not read them itself.
+var sqlTablesPython = fmt.Sprintf(`
+import re as _sqlds_re
+
+def _sqlds_table(f):
+    low = f.lower()
+    for ext in %s:
+        if low.endswith(ext):
+            name = _sqlds_re.sub(r'\W+', '_', f[:-len(ext)]).strip('_') or 'data'
+            return 't_' + name if name[0].isdigit() else name
+    return None
+
+def _sqlds_load(con, f, name):
+    low = f.lower()
+    if low.endswith(('.xlsx', '.xls')):
+        import pandas as _sqlds_pd
+        con.register('_sqlds_frame', _sqlds_pd.read_excel(f))
+        con.execute(f'CREATE OR REPLACE TABLE "{name}" AS SELECT * FROM _sqlds_frame')
+        con.unregister('_sqlds_frame')
+        return
+    reader = 'read_parquet' if low.endswith('.parquet') else 'read_csv_auto'
+    path = "'" + f.replace("'", "''") + "'"
+    con.execute(f'CREATE OR REPLACE TABLE "{name}" AS SELECT * FROM {reader}({path})')
+`, pythonTuple(datasetSuffixes))
+
+// pythonTuple formats strings as a Python tuple literal.
+func pythonTuple(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return "(" + strings.Join(quoted, ", ") + ",)"
+}
@@ -0,0 +1,84 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// environmentMarker prefixes the JSON line printed by the environment probe.
+const environmentMarker = "<<ENVIRONMENT>>"
+
+// environmentPackages are the distributions the environment probe looks for, in the order
+// they are reported.
+var environmentPackages = []string{
+	"numpy", "pandas", "scipy", "statsmodels", "scikit-learn", "pingouin",
+	"matplotlib", "seaborn", "plotly", "pmdarima", "prophet", "lifelines",
+	"linearmodels", "xgboost", "lightgbm", "openpyxl", "pyarrow",
+}
+
+// ExecutionEnvironment describes the Python runtime of a session's executor.
+type ExecutionEnvironment struct {
+	Python string `json:"python"`
+	// Installed lists "name version" for the probed packages that are installed
+	Installed []string `json:"installed"`
+	// Missing lists the probed packages that are not installed
+	Missing []string `json:"missing"`
+}
+
+// Descriptor renders the environment as one compact line for the prompt.
+func (e ExecutionEnvironment) Descriptor() string {
+	parts := []string{"Python " + e.Python}
+	if len(e.Installed) > 0 {
+		parts = append(parts, "installed: "+strings.Join(e.Installed, ", "))
+	}
+	if len(e.Missing) > 0 {
+		parts = append(parts, "NOT installed: "+strings.Join(e.Missing, ", "))
+	}
+	return strings.Join(parts, " | ")
+}
+
+// DescribeEnvironment reports the Python version and which of the analysis packages are
+// installed (with versions) in the session's executor.
+func (t *StatefulPythonTool) DescribeEnvironment(ctx context.Context, sessionID string) (ExecutionEnvironment, error) {
+	packages := make([]string, len(environmentPackages))
+	for i, name := range environmentPackages {
+		packages[i] = fmt.Sprintf("%q", name)
+	}
+
+	// Wrapped in a function so nothing leaks into the session namespace
+	code := fmt.Sprintf(`
+def _report_environment():
+    import json, sys
+    from importlib import metadata
+    installed, missing = [], []
+    for name in (%s,):
+        try:
+            installed.append(f"{name} {metadata.version(name)}")
+        except metadata.PackageNotFoundError:
+            missing.append(name)
+    print(%q + json.dumps({"python": sys.version.split()[0], "installed": installed, "missing": missing}))
+
+_report_environment()
+del _report_environment
+`, strings.Join(packages, ", "), environmentMarker)
+
+	var env ExecutionEnvironment
+	output, err := t.Call(ctx, code, sessionID)
+	if err != nil {
+		return env, err
+	}
+	idx := strings.Index(output, environmentMarker)
+	if idx < 0 {
+		return env, fmt.Errorf("environment probe failed: %s", strings.TrimSpace(output))
+	}
+	line := output[idx+len(environmentMarker):]
+	if nl := strings.IndexByte(line, '\n'); nl >= 0 {
+		line = line[:nl]
+	}
+	if err := json.Unmarshal([]byte(line), &env); err != nil {
+		return env, fmt.Errorf("failed to parse environment probe: %w", err)
+	}
+	return env, nil
+}
@@ -0,0 +1,40 @@
+package tools
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ErrExecutorUnavailable is returned by Executor.Call when no Python executor could take
+// the call (none configured, all in cooldown or unreachable, or the local process died),
+// as opposed to the code itself failing.
+var ErrExecutorUnavailable = errors.New("python executor unavailable")
+
+// Python executor transports selected by PYTHON_EXECUTOR_MODE.
+const (
+	ExecutorModeTCP   = "tcp"
+	ExecutorModeLocal = "local"
+)
+
+// Executor runs Python code in a per-session stateful namespace. Both transports speak
+// the executor protocol: "<session_id>|<code><|EOM|>" in, "<output><|EOM|>" out. While
+// tracing, the session field is "<session_id>;<traceparent>" and the executor logs the
+// trace with the call.
+type Executor interface {
+	Call(ctx context.Context, input string, sessionID string) (string, error)
+	CleanupSession(sessionID string)
+	Close()
+}
+
+// executorRequest frames one call in the executor protocol.
+func executorRequest(ctx context.Context, input string, sessionID string) string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if traceParent := carrier.Get("traceparent"); traceParent != "" {
+		sessionID += ";" + traceParent
+	}
+	return sessionID + "|" + input + EOM_TOKEN
+}
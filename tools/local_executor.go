@@ -0,0 +1,228 @@
+package tools
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"stats-agent/config"
+
+	"go.uber.org/zap"
+)
+
+// localExecutor runs executor.py as a subprocess per session, speaking the executor
+// protocol over stdin/stdout. It needs no executor service, so it suits local
+// development (including Windows and macOS) and small single-host deployments; the
+// code runs unsandboxed with the server's permissions.
+type localExecutor struct {
+	python        string
+	script        string
+	workspaceRoot string
+	ioTimeout     time.Duration
+	logger        *zap.Logger
+
+	mu    sync.Mutex
+	procs map[string]*localProcess
+}
+
+// localProcess is one session's interpreter. Calls are serialized, as on an executor connection.
+type localProcess struct {
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func newLocalExecutor(cfg *config.Config, logger *zap.Logger) (*localExecutor, error) {
+	python := cfg.PythonExecutorLocalPython
+	if python == "" {
+		python = "python3"
+		if runtime.GOOS == "windows" {
+			python = "python"
+		}
+	}
+	pythonPath, err := exec.LookPath(python)
+	if err != nil {
+		return nil, fmt.Errorf("python interpreter %q not found for local executor: %w", python, err)
+	}
+	script, err := filepath.Abs(cfg.PythonExecutorLocalScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local executor script: %w", err)
+	}
+	if _, err := os.Stat(script); err != nil {
+		return nil, fmt.Errorf("local executor script not found: %w", err)
+	}
+	workspaceRoot, err := filepath.Abs("workspaces")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve workspaces directory: %w", err)
+	}
+	if err := os.MkdirAll(workspaceRoot, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workspaces directory: %w", err)
+	}
+
+	if logger != nil {
+		logger.Info("Python tool initialized with local executor",
+			zap.String("python", pythonPath),
+			zap.String("script", script),
+			zap.String("workspaces", workspaceRoot))
+	}
+	return &localExecutor{
+		python:        pythonPath,
+		script:        script,
+		workspaceRoot: workspaceRoot,
+		ioTimeout:     cfg.PythonExecutorIOTimeoutSeconds,
+		logger:        logger,
+		procs:         make(map[string]*localProcess),
+	}, nil
+}
+
+func (e *localExecutor) Call(ctx context.Context, input string, sessionID string) (string, error) {
+	proc, err := e.process(sessionID)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrExecutorUnavailable, err)
+	}
+
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+
+	if _, err := io.WriteString(proc.stdin, executorRequest(ctx, input, sessionID)); err != nil {
+		e.discard(sessionID, proc)
+		return "", fmt.Errorf("%w: send code to local executor: %w", ErrExecutorUnavailable, err)
+	}
+
+	type readResult struct {
+		out string
+		err error
+	}
+	done := make(chan readResult, 1)
+	go func() {
+		out, err := readUntilEOM(proc.stdout)
+		done <- readResult{out, err}
+	}()
+
+	// The interpreter times out code itself; this guards against a hung or crashed process
+	timer := time.NewTimer(e.ioTimeout + 5*time.Second)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			e.discard(sessionID, proc)
+			return "", fmt.Errorf("%w: read result from local executor: %w", ErrExecutorUnavailable, res.err)
+		}
+		return res.out, nil
+	case <-timer.C:
+		e.discard(sessionID, proc)
+		return "", errors.New("local executor timed out; the session's Python state was reset")
+	case <-ctx.Done():
+		e.discard(sessionID, proc)
+		return "", ctx.Err()
+	}
+}
+
+// process returns the session's interpreter, starting it on first use.
+func (e *localExecutor) process(sessionID string) (*localProcess, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if proc, ok := e.procs[sessionID]; ok {
+		return proc, nil
+	}
+
+	timeoutSeconds := int(e.ioTimeout / time.Second)
+	if timeoutSeconds <= 0 {
+		timeoutSeconds = 60
+	}
+	cmd := exec.Command(e.python, "-u", e.script,
+		"--stdio",
+		"--workspaces", e.workspaceRoot,
+		"--timeout", strconv.Itoa(timeoutSeconds))
+	cmd.Dir = e.workspaceRoot
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "PYTHONIOENCODING=utf-8", "MPLBACKEND=Agg")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local executor stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local executor stdout: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start local executor: %w", err)
+	}
+
+	proc := &localProcess{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}
+	e.procs[sessionID] = proc
+	if e.logger != nil {
+		e.logger.Info("Started local Python executor", zap.String("session_id", sessionID), zap.Int("pid", cmd.Process.Pid))
+	}
+	return proc, nil
+}
+
+// discard kills the session's interpreter; the next call starts a fresh one.
+func (e *localExecutor) discard(sessionID string, proc *localProcess) {
+	e.mu.Lock()
+	if e.procs[sessionID] == proc {
+		delete(e.procs, sessionID)
+	}
+	e.mu.Unlock()
+	proc.kill()
+}
+
+func (p *localProcess) kill() {
+	_ = p.stdin.Close()
+	if p.cmd.Process != nil {
+		_ = p.cmd.Process.Kill()
+	}
+	_ = p.cmd.Wait()
+}
+
+// readUntilEOM reads executor output up to the end-of-message token.
+func readUntilEOM(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			b.Write(buf[:n])
+			if s := b.String(); strings.Contains(s, EOM_TOKEN) {
+				return strings.TrimSpace(strings.ReplaceAll(s, EOM_TOKEN, "")), nil
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+func (e *localExecutor) CleanupSession(sessionID string) {
+	e.mu.Lock()
+	proc, ok := e.procs[sessionID]
+	delete(e.procs, sessionID)
+	e.mu.Unlock()
+	if ok {
+		proc.kill()
+	}
+	if e.logger != nil {
+		e.logger.Info("Python session cleaned up", zap.String("session_id", sessionID))
+	}
+}
+
+func (e *localExecutor) Close() {
+	e.mu.Lock()
+	procs := e.procs
+	e.procs = make(map[string]*localProcess)
+	e.mu.Unlock()
+	for _, proc := range procs {
+		proc.kill()
+	}
+}
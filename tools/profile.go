@@ -0,0 +1,76 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"stats-agent/web/types"
+)
+
+// profileMarker prefixes the JSON line printed by the dataset profile probe.
+const profileMarker = "<<DATASET_PROFILE>>"
+
+// ProfileDataset reads a dataset from the session workspace and summarizes it: row and
+// duplicate row counts, and per column the InferColumnTypes schema plus missing values
+// and, for numeric columns, mean, standard deviation, quartiles and skewness.
+func (t *StatefulPythonTool) ProfileDataset(ctx context.Context, sessionID, filename string) (*types.DatasetProfile, error) {
+	profileCode := columnSchemaPython + fmt.Sprintf(`
+import math as _prof_math
+
+def _prof_num(_x):
+    _x = float(_x)
+    return _x if _prof_math.isfinite(_x) else None
+
+def _prof_dataset(name):
+    _df = _sch_read(name)
+    _n = len(_df)
+    _cols = []
+    for _col, _c in zip(_sch_columns(_df), _df.columns):
+        _s = _df[_c]
+        _miss = int(_s.isna().sum())
+        _col["missing"] = _miss
+        _col["missing_pct"] = round(100.0 * _miss / _n, 2) if _n else 0.0
+        if _col["inferred"] == "numeric":
+            _nn = pd.to_numeric(_s, errors="coerce").dropna().astype(float)
+            if len(_nn) > 0:
+                _q = _nn.quantile([0.25, 0.5, 0.75]).tolist()
+                _col["summary"] = {
+                    "mean": _prof_num(_nn.mean()),
+                    "std": _prof_num(_nn.std()) if len(_nn) > 1 else None,
+                    "min": _prof_num(_nn.min()),
+                    "q1": _prof_num(_q[0]),
+                    "median": _prof_num(_q[1]),
+                    "q3": _prof_num(_q[2]),
+                    "max": _prof_num(_nn.max()),
+                    "skew": _prof_num(_nn.skew()) if len(_nn) > 2 else None,
+                }
+        _cols.append(_col)
+    return {
+        "dataset": name,
+        "rows": _n,
+        "duplicate_rows": int(_df.duplicated().sum()),
+        "columns": _cols,
+    }
+
+try:
+    print(%q + _sch_json.dumps(_prof_dataset(%s)))
+except Exception as _prof_err:
+    print(f"Error: could not profile dataset: {_prof_err}")
+`, profileMarker, "'"+strings.ReplaceAll(filename, "'", "\\'")+"'")
+
+	output, err := t.Call(ctx, profileCode, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	line, err := markedLine(output, profileMarker)
+	if err != nil {
+		return nil, fmt.Errorf("dataset profile probe failed: %w", err)
+	}
+	var profile types.DatasetProfile
+	if err := json.Unmarshal([]byte(line), &profile); err != nil {
+		return nil, fmt.Errorf("failed to parse dataset profile: %w", err)
+	}
+	return &profile, nil
+}
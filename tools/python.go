@@ -1,336 +1,47 @@
 package tools
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"net"
 	"strings"
 	"sync"
 	"time"
 
 	"stats-agent/config"
+	"stats-agent/metrics"
+	"stats-agent/web/format"
 
 	"go.uber.org/zap"
 )
 
-const (
-    EOM_TOKEN = "<|EOM|>"
-)
-
-type executorNode struct {
-	address    string
-	retryAfter time.Time
-}
-
-type executorPool struct {
-	nodes    []*executorNode
-	mu       sync.Mutex
-	next     int
-	cooldown time.Duration
-}
-
-type connPool struct {
-	address string
-	idle    chan net.Conn
-	sem     chan struct{}
-	dial    func(context.Context) (net.Conn, error)
-}
-
-func newConnPool(address string, maxSize int, dial func(context.Context) (net.Conn, error)) *connPool {
-	if maxSize <= 0 {
-		maxSize = 1
-	}
-	return &connPool{
-		address: address,
-		idle:    make(chan net.Conn, maxSize),
-		sem:     make(chan struct{}, maxSize),
-		dial:    dial,
-	}
-}
-
-func (p *connPool) Get(ctx context.Context) (net.Conn, error) {
-	for {
-		select {
-		case conn := <-p.idle:
-			if conn != nil {
-				return conn, nil
-			}
-		default:
-		}
-
-		select {
-		case p.sem <- struct{}{}:
-			conn, err := p.dial(ctx)
-			if err != nil {
-				select {
-				case <-p.sem:
-				default:
-				}
-				return nil, err
-			}
-			return conn, nil
-		case conn := <-p.idle:
-			if conn != nil {
-				return conn, nil
-			}
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		}
-	}
-}
-
-func (p *connPool) Put(conn net.Conn) {
-	if conn == nil {
-		return
-	}
-	select {
-	case p.idle <- conn:
-	default:
-		_ = conn.Close()
-		select {
-		case <-p.sem:
-		default:
-		}
-	}
-}
-
-func (p *connPool) Discard(conn net.Conn) {
-	if conn != nil {
-		_ = conn.Close()
-	}
-	select {
-	case <-p.sem:
-	default:
-	}
-}
-
-func (p *connPool) Close() {
-	for {
-		select {
-		case conn := <-p.idle:
-			if conn != nil {
-				_ = conn.Close()
-				select {
-				case <-p.sem:
-				default:
-				}
-			}
-		default:
-			return
-		}
-	}
-}
-
-func newExecutorPool(addresses []string, cooldown time.Duration) (*executorPool, error) {
-	unique := make(map[string]struct{}, len(addresses))
-	nodes := make([]*executorNode, 0, len(addresses))
-	for _, addr := range addresses {
-		addr = strings.TrimSpace(addr)
-		if addr == "" {
-			continue
-		}
-		if _, exists := unique[addr]; exists {
-			continue
-		}
-		unique[addr] = struct{}{}
-		nodes = append(nodes, &executorNode{address: addr})
-	}
-	if len(nodes) == 0 {
-		return nil, errors.New("no valid python executor addresses provided")
-	}
-	return &executorPool{
-		nodes:    nodes,
-		cooldown: cooldown,
-	}, nil
-}
-
-func (p *executorPool) Next() (string, error) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	if len(p.nodes) == 0 {
-		return "", errors.New("no python executors configured")
-	}
-	now := time.Now()
-	checked := 0
-	for checked < len(p.nodes) {
-		idx := p.next
-		p.next = (p.next + 1) % len(p.nodes)
-		node := p.nodes[idx]
-		checked++
-		if now.After(node.retryAfter) {
-			return node.address, nil
-		}
-	}
-	return "", errors.New("no healthy python executors available")
-}
-
-func (p *executorPool) MarkFailure(address string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	now := time.Now().Add(p.cooldown)
-	for _, node := range p.nodes {
-		if node.address == address {
-			node.retryAfter = now
-			return
-		}
-	}
-}
-
-func (p *executorPool) MarkSuccess(address string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	for _, node := range p.nodes {
-		if node.address == address {
-			node.retryAfter = time.Time{}
-			return
-		}
-	}
-}
-
-func (p *executorPool) Size() int {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	return len(p.nodes)
-}
-
-func (p *executorPool) Addresses() []string {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	addrs := make([]string, 0, len(p.nodes))
-	for _, node := range p.nodes {
-		addrs = append(addrs, node.address)
-	}
-	return addrs
-}
-
+// StatefulPythonTool runs Python code in per-session stateful namespaces through an
+// Executor transport: the TCP executor pool (Docker) or local subprocesses.
 type StatefulPythonTool struct {
-	pool                      *executorPool
-	logger                    *zap.Logger
-	dialTimeout               time.Duration
-	ioTimeout                 time.Duration
-	sessionMu                 sync.RWMutex
-	sessionAddr               map[string]string
-	connPoolsMu               sync.RWMutex
-	connPools                 map[string]*connPool
-	maxConnectionsPerExecutor int
+	executor Executor
+	logger   *zap.Logger
+	// Pinned random seeds by session
+	seedsMu sync.RWMutex
+	seeds   map[string]int64
 }
 
-// NewStatefulPythonTool no longer creates a session ID.
+// NewStatefulPythonTool creates the Python tool with the transport selected by PYTHON_EXECUTOR_MODE.
 func NewStatefulPythonTool(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*StatefulPythonTool, error) {
 	if cfg == nil {
 		return nil, errors.New("config is required")
 	}
-	addresses := cfg.PythonExecutorAddresses
-    cooldown := cfg.PythonExecutorCooldownSeconds
-	pool, err := newExecutorPool(addresses, cooldown)
-	if err != nil {
-		return nil, err
-	}
-    dialTimeout := cfg.PythonExecutorDialTimeoutSeconds
-    ioTimeout := cfg.PythonExecutorIOTimeoutSeconds
-    maxConnections := cfg.PythonExecutorMaxConnections
-	tool := &StatefulPythonTool{
-		pool:                      pool,
-		logger:                    logger,
-		dialTimeout:               dialTimeout,
-		ioTimeout:                 ioTimeout,
-		sessionAddr:               make(map[string]string),
-		connPools:                 make(map[string]*connPool),
-		maxConnectionsPerExecutor: maxConnections,
+	var executor Executor
+	var err error
+	if cfg.PythonExecutorMode == ExecutorModeLocal {
+		executor, err = newLocalExecutor(cfg, logger)
+	} else {
+		executor, err = newTCPExecutor(ctx, cfg, logger)
 	}
-	if err := tool.ensureInitialConnectivity(ctx); err != nil {
+	if err != nil {
 		return nil, err
 	}
-	if tool.logger != nil {
-		tool.logger.Info("Python tool initialized", zap.Strings("addresses", tool.pool.Addresses()))
-	}
-	return tool, nil
-}
-
-func (t *StatefulPythonTool) getConnPool(address string) *connPool {
-	t.connPoolsMu.RLock()
-	pool := t.connPools[address]
-	t.connPoolsMu.RUnlock()
-	if pool != nil {
-		return pool
-	}
-
-	t.connPoolsMu.Lock()
-	defer t.connPoolsMu.Unlock()
-	if pool = t.connPools[address]; pool == nil {
-		pool = newConnPool(address, t.maxConnectionsPerExecutor, func(ctx context.Context) (net.Conn, error) {
-			return t.dial(ctx, address)
-		})
-		t.connPools[address] = pool
-	}
-	return pool
-}
-
-func (t *StatefulPythonTool) ensureInitialConnectivity(ctx context.Context) error {
-	addresses := t.pool.Addresses()
-	var lastErr error
-	for _, addr := range addresses {
-		cp := t.getConnPool(addr)
-		conn, err := cp.Get(ctx)
-		if err != nil {
-			t.pool.MarkFailure(addr)
-			lastErr = err
-			if t.logger != nil {
-				t.logger.Warn("Initial executor health check failed", zap.String("address", addr), zap.Error(err))
-			}
-			continue
-		}
-		cp.Put(conn)
-		t.pool.MarkSuccess(addr)
-		return nil
-	}
-	if lastErr != nil {
-		return fmt.Errorf("unable to reach any python executor: %w", lastErr)
-	}
-	return errors.New("no python executors available")
-}
-
-func (t *StatefulPythonTool) dial(ctx context.Context, address string) (net.Conn, error) {
-	d := &net.Dialer{Timeout: t.dialTimeout}
-	return d.DialContext(ctx, "tcp", address)
-}
-
-func (t *StatefulPythonTool) execute(conn net.Conn, input string, sessionID string) (string, error) {
-	deadline := time.Now().Add(t.ioTimeout)
-	_ = conn.SetDeadline(deadline)
-	payload := sessionID + "|" + input + EOM_TOKEN
-	if _, err := conn.Write([]byte(payload)); err != nil {
-		return "", fmt.Errorf("send code: %w", err)
-	}
-
-	reader := bufio.NewReader(conn)
-	var b strings.Builder
-	buf := make([]byte, 4096)
-
-	for {
-		n, err := reader.Read(buf)
-		if n > 0 {
-			b.Write(buf[:n])
-			s := b.String()
-			if strings.Contains(s, EOM_TOKEN) {
-				out := strings.ReplaceAll(s, EOM_TOKEN, "")
-				return strings.TrimSpace(out), nil
-			}
-		}
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				s := b.String()
-				if strings.Contains(s, EOM_TOKEN) {
-					out := strings.ReplaceAll(s, EOM_TOKEN, "")
-					return strings.TrimSpace(out), nil
-				}
-			}
-			return "", fmt.Errorf("read result: %w", err)
-		}
-	}
+	return &StatefulPythonTool{executor: executor, logger: logger, seeds: make(map[string]int64)}, nil
 }
 
 func (t *StatefulPythonTool) InitializeSession(ctx context.Context, sessionID string, uploadedFiles []string) (string, error) {
@@ -365,6 +76,75 @@ print("=" * 50)
 print("POCKET STATISTICIAN SESSION INITIALIZED")
 print("=" * 50)
 
+# Datasets by name (same naming rule as agent.DatasetNames); rescanned on every call so
+# files uploaded later are found too
+import re as _sa_re
+
+_sa_dataset_suffixes = ('.csv.gz', '.parquet', '.xlsx', '.xls', '.csv')
+
+def _sa_dataset_name(s):
+    name = _sa_re.sub(r'[^0-9a-zA-Z]+', '_', s).strip('_').lower() or 'dataset'
+    return 'd_' + name if name[0].isdigit() else name
+
+def _sa_stem(f):
+    for suffix in _sa_dataset_suffixes:
+        if f.lower().endswith(suffix):
+            return f[:-len(suffix)]
+    return f
+
+def dataset_files():
+    """Map dataset names to the CSV (plain or gzipped), Excel and Parquet files in the workspace."""
+    files = sorted(f for f in os.listdir(workspace_path)
+                   if os.path.isfile(os.path.join(workspace_path, f)) and f.lower().endswith(_sa_dataset_suffixes))
+    by_stem = {}
+    for f in files:
+        by_stem.setdefault(_sa_dataset_name(_sa_stem(f)), []).append(f)
+    names = {}
+    for stem, fs in by_stem.items():
+        if len(fs) == 1:
+            names[stem] = fs[0]
+        else:
+            names.update({_sa_dataset_name(f): f for f in fs})
+    return names
+
+def _sa_dataset_path(name):
+    files = dataset_files()
+    if name not in files:
+        by_file = {f: n for n, f in files.items()}
+        if name not in by_file:
+            raise KeyError(f"Unknown dataset {name!r}; available: {', '.join(files) or 'none'}")
+        name = by_file[name]
+    return os.path.join(workspace_path, files[name])
+
+def excel_sheets(name):
+    """List the sheet names of an Excel dataset, in workbook order."""
+    return pd.ExcelFile(_sa_dataset_path(name)).sheet_names
+
+def load_dataset(name, sheet=None):
+    """Read a dataset by name (or file name) into a new DataFrame. For Excel files, sheet
+    selects a sheet by name or position (default: the first sheet)."""
+    path = _sa_dataset_path(name)
+    if path.lower().endswith(('.xlsx', '.xls')):
+        return pd.read_excel(path, sheet_name=0 if sheet is None else sheet)
+    if sheet is not None:
+        raise ValueError(f"{os.path.basename(path)} is not an Excel file; it has no sheets")
+    if path.lower().endswith('.parquet'):
+        return pd.read_parquet(path)
+    return pd.read_csv(path)
+
+def join_datasets(left, right, on, how='inner'):
+    """Join two datasets (names or DataFrames) on key column(s) and report the match counts."""
+    ldf = load_dataset(left) if isinstance(left, str) else left
+    rdf = load_dataset(right) if isinstance(right, str) else right
+    suffix = '_' + right if isinstance(right, str) else '_right'
+    merged = ldf.merge(rdf, on=on, how='outer', suffixes=('', suffix), indicator='_sa_match')
+    counts = merged['_sa_match'].value_counts()
+    print(f"Join on {on}: {counts.get('both', 0)} matched rows, "
+          f"{counts.get('left_only', 0)} only in left, {counts.get('right_only', 0)} only in right")
+    keep = {'inner': ['both'], 'left': ['both', 'left_only'], 'right': ['both', 'right_only'],
+            'outer': ['both', 'left_only', 'right_only']}[how]
+    return merged[merged['_sa_match'].isin(keep)].drop(columns='_sa_match').reset_index(drop=True)
+
 if uploaded_files:
     print(f"Uploaded files detected: {len(uploaded_files)}")
     for f in uploaded_files:
@@ -372,14 +152,42 @@ if uploaded_files:
         if os.path.exists(file_path):
             size = os.path.getsize(file_path) / 1024  # Size in KB
             print(f"  \u2713 {f} ({size:.1f} KB)")
+            if f.lower().endswith(('.xlsx', '.xls')):
+                try:
+                    sheets = pd.ExcelFile(file_path).sheet_names
+                except Exception:
+                    sheets = []
+                if len(sheets) > 1:
+                    print(f"    sheets: {', '.join(sheets)} (load one with load_dataset({f!r}, sheet=name))")
         else:
             print(f"  \u2717 {f} (not found)")
     print("=" * 50)
     print(f"Primary file for analysis: {uploaded_files[0]}")
+    if len(uploaded_files) > 1:
+        print("Datasets by name: " + ", ".join(f"{n} ({f})" for n, f in dataset_files().items()))
+        print("Use load_dataset(name) and join_datasets(left, right, on=...) to work with several files.")
 else:
-    print("No uploaded files detected yet. You can upload CSV or Excel files at any time.")
+    print("No uploaded files detected yet. You can upload CSV, Excel or Parquet files at any time.")
     print("=" * 50)
 
+# Time-series toolkit availability (stationarity, ACF/PACF, ARIMA, Prophet, decomposition)
+import importlib.util
+ts_packages = {
+    "statsmodels.tsa": "statsmodels.tsa",
+    "pmdarima": "pmdarima",
+    "prophet": "prophet",
+}
+ts_status = []
+for label, module in ts_packages.items():
+    try:
+        ok = importlib.util.find_spec(module) is not None
+    except (ImportError, ValueError):
+        ok = False
+    mark = "\u2713" if ok else "\u2717"
+    ts_status.append(f"{label} {mark}")
+print("Time-series toolkit: " + ", ".join(ts_status))
+print("=" * 50)
+
 print("Ready for statistical analysis!")
 print("=" * 50)
 `, filesLiteral)
@@ -387,6 +195,47 @@ print("=" * 50)
 	return t.Call(ctx, initCode, sessionID)
 }
 
+// PackageVersions reports the Python version and installed versions of the analysis
+// packages in the session's executor, one "name==version" per line.
+func (t *StatefulPythonTool) PackageVersions(ctx context.Context, sessionID string) (string, error) {
+	// Wrapped in a function so nothing leaks into the session namespace
+	code := `
+def _report_package_versions():
+    import sys
+    from importlib import metadata
+    print(f"python=={sys.version.split()[0]}")
+    for name in ("numpy", "pandas", "scipy", "statsmodels", "scikit-learn", "pingouin",
+                 "matplotlib", "seaborn", "pmdarima", "prophet", "openpyxl"):
+        try:
+            print(f"{name}=={metadata.version(name)}")
+        except metadata.PackageNotFoundError:
+            pass
+
+_report_package_versions()
+del _report_package_versions
+`
+	return t.Call(ctx, code, sessionID)
+}
+
+// ResetNamespace deletes every variable, import and function from the session's
+// namespace, leaving the workspace files alone. Run InitializeSession afterwards.
+func (t *StatefulPythonTool) ResetNamespace(ctx context.Context, sessionID string) error {
+	code := `
+for _sa_name in [n for n in globals() if not n.startswith('__')]:
+    del globals()[_sa_name]
+globals().pop('_sa_name', None)
+print("Session namespace cleared.")
+`
+	result, err := t.Call(ctx, code, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to reset python namespace: %w", err)
+	}
+	if strings.HasPrefix(result, "Error") {
+		return fmt.Errorf("failed to reset python namespace: %s", result)
+	}
+	return nil
+}
+
 func (t *StatefulPythonTool) Name() string {
 	return "Stateful Python Environment"
 }
@@ -395,108 +244,40 @@ func (t *StatefulPythonTool) Description() string {
 	return "Executes Python code in a persistent, sandboxed session."
 }
 
+// Call executes code in the session's namespace and returns its printed output. Warnings
+// the code raised are dropped; ExecuteCell keeps them for analysis cells.
 func (t *StatefulPythonTool) Call(ctx context.Context, input string, sessionID string) (string, error) {
-	total := t.pool.Size()
-	if total == 0 {
-		return "", errors.New("no python executors configured")
-	}
-
-	tried := make(map[string]struct{})
-
-	// Try the previously assigned executor first, if any.
-	if sessionID != "" {
-		t.sessionMu.RLock()
-		boundAddr, ok := t.sessionAddr[sessionID]
-		t.sessionMu.RUnlock()
-		if ok {
-			if result, err := t.callExecutor(ctx, boundAddr, input, sessionID); err == nil {
-				return result, nil
-			}
-			tried[boundAddr] = struct{}{}
-			t.sessionMu.Lock()
-			delete(t.sessionAddr, sessionID)
-			t.sessionMu.Unlock()
-		}
-	}
-
-	var lastErr error
-	for attempts := 0; attempts < total; attempts++ {
-		addr, err := t.pool.Next()
-		if err != nil {
-			if lastErr != nil {
-				return "", fmt.Errorf("no healthy python executors available: %w", lastErr)
-			}
-			return "", err
-		}
-		if _, seen := tried[addr]; seen {
-			continue
-		}
-		tried[addr] = struct{}{}
-
-		result, execErr := t.callExecutor(ctx, addr, input, sessionID)
-		if execErr == nil {
-			t.sessionMu.Lock()
-			t.sessionAddr[sessionID] = addr
-			t.sessionMu.Unlock()
-			return result, nil
-		}
-		lastErr = execErr
-	}
-
-	if lastErr != nil {
-		return "", fmt.Errorf("all python executors failed: %w", lastErr)
-	}
-	return "", errors.New("no healthy python executors available")
-}
-
-func (t *StatefulPythonTool) callExecutor(ctx context.Context, addr, input, sessionID string) (string, error) {
-	cp := t.getConnPool(addr)
-	conn, err := cp.Get(ctx)
+	output, err := t.execute(ctx, input, sessionID)
 	if err != nil {
-		t.pool.MarkFailure(addr)
-		if t.logger != nil {
-			t.logger.Warn("Failed to connect to python executor", zap.String("address", addr), zap.Error(err))
-		}
-		return "", fmt.Errorf("dial python server %s: %w", addr, err)
+		return output, err
 	}
+	output, _ = format.SplitWarnings(output)
+	return output, nil
+}
 
-	result, execErr := t.execute(conn, input, sessionID)
-	if execErr != nil {
-		cp.Discard(conn)
-		t.pool.MarkFailure(addr)
-		if t.logger != nil {
-			t.logger.Warn("Python executor call failed", zap.String("address", addr), zap.Error(execErr))
-		}
-		return "", fmt.Errorf("executor %s: %w", addr, execErr)
-	}
+// execute runs code on the executor and records how long it took.
+func (t *StatefulPythonTool) execute(ctx context.Context, code string, sessionID string) (string, error) {
+	start := time.Now()
+	output, err := t.executor.Call(ctx, code, sessionID)
+	metrics.PythonExecutionDuration.ObserveSince(start, metrics.Outcome(err))
+	return output, err
+}
 
-	cp.Put(conn)
-	t.pool.MarkSuccess(addr)
-	if t.logger != nil {
-		t.logger.Debug("Python code executed", zap.String("address", addr), zap.String("session_id", sessionID))
-	}
-	return result, nil
+// WrapExecutor decorates the executor transport (e.g. with failure injection). Call it
+// before the tool is used.
+func (t *StatefulPythonTool) WrapExecutor(wrap func(Executor) Executor) {
+	t.executor = wrap(t.executor)
 }
 
+// Close releases executor connections or processes.
 func (t *StatefulPythonTool) Close() {
-	t.connPoolsMu.Lock()
-	defer t.connPoolsMu.Unlock()
-	for addr, pool := range t.connPools {
-		if pool != nil {
-			pool.Close()
-		}
-		delete(t.connPools, addr)
-	}
+	t.executor.Close()
 }
 
-// CleanupSession removes the session binding from the executor pool
+// CleanupSession drops the session's executor binding (and its process in local mode).
 func (t *StatefulPythonTool) CleanupSession(sessionID string) {
-	t.sessionMu.Lock()
-	defer t.sessionMu.Unlock()
-	delete(t.sessionAddr, sessionID)
-	if t.logger != nil {
-		t.logger.Info("Python session cleaned up", zap.String("session_id", sessionID))
-	}
+	t.executor.CleanupSession(sessionID)
+	t.SetSessionSeed(sessionID, nil)
 }
 
 // ExecutePythonCode now requires a sessionID to be passed.
@@ -507,17 +288,21 @@ func (t *StatefulPythonTool) ExecutePythonCode(ctx context.Context, text string,
 		return "", "", false
 	}
 
-	t.logger.Info("Executing Python code", zap.String("code", pythonCode), zap.String("session_id", sessionID))
+	return pythonCode, t.RunCode(ctx, pythonCode, sessionID), true
+}
+
+// RunCode executes one analysis cell given as code, such as the arguments of a structured
+// tool call. Executor failures come back as "Error: ..." output.
+func (t *StatefulPythonTool) RunCode(ctx context.Context, code string, sessionID string) string {
+	t.logger.Info("Executing Python code", zap.String("code", code), zap.String("session_id", sessionID))
 
-	execResult, err := t.Call(ctx, pythonCode, sessionID)
+	execResult, err := t.ExecuteCell(ctx, code, sessionID)
 	if err != nil {
 		t.logger.Error("Error executing Python code", zap.Error(err))
-		execResult = "Error: " + err.Error()
-	} else {
-		t.logger.Debug("Python code executed successfully", zap.String("result_preview", execResult[:min(100, len(execResult))]))
+		return "Error: " + err.Error()
 	}
-
-	return pythonCode, execResult, true
+	t.logger.Debug("Python code executed successfully", zap.String("result_preview", execResult[:min(100, len(execResult))]))
+	return execResult
 }
 
 // extractMarkdownCode extracts Python code from markdown code blocks (```python ... ```)
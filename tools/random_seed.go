@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+)
+
+// MaxRandomSeed is the largest seed numpy's legacy global generator accepts.
+const MaxRandomSeed = 1<<32 - 1
+
+// SetSessionSeed pins the random seed applied before each cell the session executes,
+// or clears it when seed is nil.
+func (t *StatefulPythonTool) SetSessionSeed(sessionID string, seed *int64) {
+	if sessionID == "" {
+		return
+	}
+	t.seedsMu.Lock()
+	defer t.seedsMu.Unlock()
+	if seed == nil {
+		delete(t.seeds, sessionID)
+		return
+	}
+	t.seeds[sessionID] = *seed
+}
+
+// SessionSeed returns the session's pinned seed.
+func (t *StatefulPythonTool) SessionSeed(sessionID string) (int64, bool) {
+	t.seedsMu.RLock()
+	defer t.seedsMu.RUnlock()
+	seed, ok := t.seeds[sessionID]
+	return seed, ok
+}
+
+// ExecuteCell runs one analysis cell. With a pinned seed, Python's random module and
+// numpy's global generator are reseeded first, so bootstrap and permutation results
+// repeat when the cell is re-run. Warnings the cell raised stay in the output as a
+// <warnings> block (see format.SplitWarnings).
+func (t *StatefulPythonTool) ExecuteCell(ctx context.Context, code string, sessionID string) (string, error) {
+	if seed, ok := t.SessionSeed(sessionID); ok {
+		code = seedPreamble(seed) + code
+	}
+	return t.execute(ctx, code, sessionID)
+}
+
+// seedPreamble is kept to one line so traceback line numbers shift by one at most.
+func seedPreamble(seed int64) string {
+	return fmt.Sprintf("import random as _sa_random, numpy as _sa_np; _sa_random.seed(%d); _sa_np.random.seed(%d); del _sa_random, _sa_np\n", seed, seed)
+}
@@ -0,0 +1,103 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"stats-agent/web/types"
+)
+
+// schemaMarker prefixes the JSON line printed by the column type probe.
+const schemaMarker = "<<COLUMN_SCHEMA>>"
+
+// columnSchemaPython defines _sch_read(name), which loads a workspace dataset, and
+// _sch_columns(df), which classifies its columns. Shared by the column type probe and
+// the dataset profile.
+const columnSchemaPython = `
+import json as _sch_json
+import pandas as pd
+
+def _sch_read(name):
+    if name.lower().endswith(('.xlsx', '.xls')):
+        return pd.read_excel(name)
+    if name.lower().endswith('.parquet'):
+        return pd.read_parquet(name)
+    return pd.read_csv(name)
+
+def _sch_columns(_df):
+    _cols = []
+    for _c in _df.columns:
+        _s = _df[_c]
+        _nn = _s.dropna()
+        _nu = int(_nn.nunique())
+        _hint = ""
+        if pd.api.types.is_bool_dtype(_s):
+            _kind = "boolean"
+        elif pd.api.types.is_datetime64_any_dtype(_s):
+            _kind = "datetime"
+        elif pd.api.types.is_numeric_dtype(_s):
+            _kind = "numeric"
+            if 2 <= _nu <= 10 and len(_nn) > 0 and bool((_nn % 1 == 0).all()):
+                _hint = "few distinct integer values: may be a coded categorical"
+        else:
+            _kind = "categorical" if _nu <= 20 else "text"
+            _sample = _nn.astype(str).head(50)
+            if len(_sample) > 0 and pd.to_datetime(_sample, errors="coerce").notna().mean() >= 0.9:
+                _hint = "values look like dates stored as text"
+        _levels = []
+        if _kind in ("categorical", "boolean") or _hint.startswith("few distinct"):
+            _levels = [{"value": str(_v), "count": int(_n)} for _v, _n in _nn.astype(str).value_counts().head(50).items()]
+        _cols.append({
+            "name": str(_c),
+            "dtype": str(_s.dtype),
+            "inferred": _kind,
+            "unique": _nu,
+            "examples": [str(_v) for _v in _nn.unique()[:3]],
+            "hint": _hint,
+            "levels": _levels,
+        })
+    return _cols
+`
+
+// InferColumnTypes reads a dataset from the session workspace and reports each column's
+// dtype, inferred type, distinct count, and example values. Numeric columns with a few
+// integer codes and text columns holding dates are flagged with a hint. Categorical,
+// boolean and coded columns also report their most frequent levels with row counts.
+func (t *StatefulPythonTool) InferColumnTypes(ctx context.Context, sessionID, filename string) ([]types.ColumnSchema, error) {
+	schemaCode := columnSchemaPython + fmt.Sprintf(`
+try:
+    print(%q + _sch_json.dumps(_sch_columns(_sch_read(%s))))
+except Exception as _sch_err:
+    print(f"Error: could not read dataset: {_sch_err}")
+`, schemaMarker, "'"+strings.ReplaceAll(filename, "'", "\\'")+"'")
+
+	output, err := t.Call(ctx, schemaCode, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	line, err := markedLine(output, schemaMarker)
+	if err != nil {
+		return nil, fmt.Errorf("column type probe failed: %w", err)
+	}
+	var columns []types.ColumnSchema
+	if err := json.Unmarshal([]byte(line), &columns); err != nil {
+		return nil, fmt.Errorf("failed to parse column types: %w", err)
+	}
+	return columns, nil
+}
+
+// markedLine returns the rest of the output line following marker, or the trimmed output
+// as an error when the probe printed no marker.
+func markedLine(output, marker string) (string, error) {
+	idx := strings.Index(output, marker)
+	if idx < 0 {
+		return "", fmt.Errorf("%s", strings.TrimSpace(output))
+	}
+	line := output[idx+len(marker):]
+	if nl := strings.IndexByte(line, '\n'); nl >= 0 {
+		line = line[:nl]
+	}
+	return line, nil
+}
@@ -0,0 +1,115 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"stats-agent/web/types"
+)
+
+// sqlMarker prefixes the JSON line printed by the SQL console probe.
+const sqlMarker = "<<SQL_RESULT>>"
+
+// ErrSQLNotReadOnly is returned for console queries that are not a single read-only statement.
+var ErrSQLNotReadOnly = errors.New("only a single SELECT, WITH, DESCRIBE, SHOW, SUMMARIZE or EXPLAIN statement is allowed")
+
+// ErrSQLQueryFailed wraps errors reported by DuckDB for a console query.
+var ErrSQLQueryFailed = errors.New("SQL query failed")
+
+// readOnlySQLKeywords are the statements a console query may start with. DuckDB also
+// accepts FROM-first queries ("FROM t LIMIT 5").
+var readOnlySQLKeywords = []string{"SELECT", "WITH", "FROM", "DESCRIBE", "SHOW", "SUMMARIZE", "EXPLAIN", "VALUES", "TABLE"}
+
+// NormalizeReadOnlySQL trims a console query and checks that it is one read-only
+// statement. A single trailing semicolon is dropped; any other semicolon is rejected, even
+// inside a string literal.
+func NormalizeReadOnlySQL(query string) (string, error) {
+	query = strings.TrimSpace(query)
+	query = strings.TrimSpace(strings.TrimSuffix(query, ";"))
+	if query == "" || strings.Contains(query, ";") {
+		return "", ErrSQLNotReadOnly
+	}
+	first := strings.ToUpper(strings.Fields(query)[0])
+	for _, kw := range readOnlySQLKeywords {
+		if first == kw || strings.HasPrefix(first, kw+"(") {
+			return query, nil
+		}
+	}
+	return "", ErrSQLNotReadOnly
+}
+
+// QueryWorkspaceSQL runs a read-only SQL query over the session workspace with DuckDB.
+// Each top-level dataset file is loaded into a throwaway in-memory database as a table
+// named by sqlTablesPython, the same names the agent's <sql> tool uses. External access
+// is then disabled and the configuration locked, so the query cannot read or write
+// files. At most maxRows rows are returned. The probe runs in its own connection and
+// does not touch the session's Python variables.
+func (t *StatefulPythonTool) QueryWorkspaceSQL(ctx context.Context, sessionID, query string, maxRows int) (*types.SQLResult, error) {
+	query, err := NormalizeReadOnlySQL(query)
+	if err != nil {
+		return nil, err
+	}
+	// A JSON string is also a valid Python string literal
+	literal, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode query: %w", err)
+	}
+
+	sqlCode := sqlTablesPython + fmt.Sprintf(`
+import json as _sql_json
+import os as _sql_os
+
+def _sql_run(query, limit):
+    import duckdb as _sql_duckdb
+    _con = _sql_duckdb.connect(':memory:')
+    try:
+        _tables = []
+        for _f in sorted(_sql_os.listdir('.')):
+            _name = _sqlds_table(_f)
+            if _f.startswith('.') or not _sql_os.path.isfile(_f) or _name is None or _name in _tables:
+                continue
+            _sqlds_load(_con, _f, _name)
+            _tables.append(_name)
+        _con.execute("SET enable_external_access = false")
+        _con.execute("SET lock_configuration = true")
+        _cur = _con.execute(query)
+        _cols = [str(_d[0]) for _d in (_cur.description or [])]
+        _rows = _cur.fetchmany(limit + 1) if _cols else []
+        return {
+            "tables": _tables,
+            "columns": _cols,
+            "rows": [["NULL" if _v is None else str(_v) for _v in _r] for _r in _rows[:limit]],
+            "truncated": len(_rows) > limit,
+        }
+    finally:
+        _con.close()
+
+try:
+    print(%q + _sql_json.dumps(_sql_run(%s, %d)))
+except ImportError:
+    print("Error: duckdb is not installed in the executor")
+except Exception as _sql_err:
+    print(f"Error: {_sql_err}")
+`, sqlMarker, literal, maxRows)
+
+	output, err := t.Call(ctx, sqlCode, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	idx := strings.Index(output, sqlMarker)
+	if idx < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrSQLQueryFailed, strings.TrimPrefix(strings.TrimSpace(output), "Error: "))
+	}
+	line := output[idx+len(sqlMarker):]
+	if nl := strings.IndexByte(line, '\n'); nl >= 0 {
+		line = line[:nl]
+	}
+	result := &types.SQLResult{Query: query}
+	if err := json.Unmarshal([]byte(line), result); err != nil {
+		return nil, fmt.Errorf("failed to parse SQL result: %w", err)
+	}
+	return result, nil
+}
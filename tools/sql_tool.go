@@ -0,0 +1,113 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	sqlOpenTag  = "<sql>"
+	sqlCloseTag = "</sql>"
+)
+
+// SQLTool runs the agent's <sql> blocks against the session's datasets with DuckDB, which
+// answers aggregation-heavy questions faster than a round trip through pandas. Each
+// session keeps one in-memory DuckDB connection in its executor namespace. Top-level
+// dataset files in the workspace are loaded as tables named by sqlTablesPython and
+// reloaded when they change. The full
+// result is also left in the session as the dataframe sql_result.
+type SQLTool struct {
+	python  *StatefulPythonTool
+	maxRows int
+}
+
+// NewSQLTool creates the SQL tool. maxRows caps the rows printed into the tool output.
+func NewSQLTool(python *StatefulPythonTool, maxRows int) *SQLTool {
+	return &SQLTool{python: python, maxRows: maxRows}
+}
+
+// extractSQLBlock returns the content of the first complete <sql> block.
+func extractSQLBlock(text string) (string, bool) {
+	start := strings.Index(text, sqlOpenTag)
+	if start < 0 {
+		return "", false
+	}
+	end := strings.Index(text[start:], sqlCloseTag)
+	if end < 0 {
+		return "", false
+	}
+	query := strings.TrimSpace(text[start+len(sqlOpenTag) : start+end])
+	return query, query != ""
+}
+
+// ExecuteSQLBlock runs the first <sql> block in the LLM response. It returns the query,
+// the printed result, and whether a block was found. Queries that are not a single
+// read-only statement and DuckDB errors come back as "Error: ..." output so the model
+// can correct them like a failed cell.
+func (s *SQLTool) ExecuteSQLBlock(ctx context.Context, response, sessionID string) (string, string, bool) {
+	query, ok := extractSQLBlock(response)
+	if !ok {
+		return "", "", false
+	}
+	return query, s.ExecuteQuery(ctx, query, sessionID), true
+}
+
+// ExecuteQuery runs one query, such as the arguments of a structured tool call, with the
+// same checks and output as ExecuteSQLBlock.
+func (s *SQLTool) ExecuteQuery(ctx context.Context, query, sessionID string) string {
+	normalized, err := NormalizeReadOnlySQL(query)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	// A JSON string is also a valid Python string literal
+	literal, err := json.Marshal(normalized)
+	if err != nil {
+		return fmt.Sprintf("Error: failed to encode query: %v", err)
+	}
+
+	sqlCode := sqlTablesPython + fmt.Sprintf(`
+import os as _sqlt_os
+
+def _sqlt_sync():
+    import duckdb as _sqlt_duckdb
+    _g = globals()
+    if _g.get('_sqlt_con') is None:
+        _g['_sqlt_con'] = _sqlt_duckdb.connect(':memory:')
+        _g['_sqlt_loaded'] = {}
+    _con, _loaded = _g['_sqlt_con'], _g['_sqlt_loaded']
+    for _f in sorted(_sqlt_os.listdir('.')):
+        _name = _sqlds_table(_f)
+        if _f.startswith('.') or not _sqlt_os.path.isfile(_f) or _name is None:
+            continue
+        _stamp = (_f, _sqlt_os.path.getmtime(_f))
+        if _loaded.get(_name) == _stamp:
+            continue
+        _sqlds_load(_con, _f, _name)
+        _loaded[_name] = _stamp
+    return _con
+
+try:
+    sql_result = _sqlt_sync().execute(%s).fetchdf()
+    if len(sql_result) > %d:
+        print(sql_result.head(%d).to_string())
+        print(f"... {len(sql_result)} rows in total (full result in sql_result)")
+    else:
+        print(sql_result.to_string())
+except ImportError:
+    print("Error: duckdb is not installed in the executor; use pandas instead")
+except Exception as _sqlt_err:
+    print(f"Error: {_sqlt_err}")
+`, literal, s.maxRows, s.maxRows)
+
+	s.python.logger.Info("Executing SQL query", zap.String("query", normalized), zap.String("session_id", sessionID))
+	output, err := s.python.Call(ctx, sqlCode, sessionID)
+	if err != nil {
+		s.python.logger.Error("Error executing SQL query", zap.Error(err))
+		return "Error: " + err.Error()
+	}
+	return strings.TrimSpace(output)
+}
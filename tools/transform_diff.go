@@ -0,0 +1,150 @@
+package tools
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"stats-agent/web/types"
+)
+
+// DistributionFigureDir is the workspace subdirectory holding before/after figures. It is
+// kept out of the top level so the figures are not picked up as cell outputs.
+const DistributionFigureDir = "lineage"
+
+// distributionDiffMarker prefixes the JSON line printed by the diff code.
+const distributionDiffMarker = "DISTRIBUTION_DIFF_JSON:"
+
+// DistributionTarget is a column whose distribution is compared around a cell: Column of
+// the dataframe Frame after the cell against Source before it.
+type DistributionTarget struct {
+	Frame  string `json:"frame"`
+	Column string `json:"column"`
+	Source string `json:"source"`
+	Kind   string `json:"kind"`
+}
+
+// SnapshotDistributions copies the targets' numeric source columns in the session
+// namespace before a transforming cell runs. Missing or non-numeric columns are skipped.
+func (t *StatefulPythonTool) SnapshotDistributions(ctx context.Context, sessionID string, targets []DistributionTarget) error {
+	encoded, err := encodeDistributionTargets(targets)
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf(`
+import base64 as _dd_b64, json as _dd_json
+import pandas as _dd_pd
+_sa_dist_before = {}
+for _dd_t in _dd_json.loads(_dd_b64.b64decode('%s')):
+    try:
+        _dd_v = globals()[_dd_t['frame']][_dd_t['source']]
+    except Exception:
+        continue
+    if _dd_pd.api.types.is_numeric_dtype(_dd_v) and not _dd_pd.api.types.is_bool_dtype(_dd_v):
+        _sa_dist_before[(_dd_t['frame'], _dd_t['column'])] = _dd_v.copy()
+del _dd_b64, _dd_json, _dd_pd
+`, encoded)
+	output, err := t.Call(ctx, code, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot distributions: %w", err)
+	}
+	if strings.HasPrefix(output, "Error") {
+		return fmt.Errorf("failed to snapshot distributions: %s", strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// DistributionDiffs compares the snapshot taken by SnapshotDistributions with the columns
+// after the cell ran. Each compared column gets summary statistics for both sides and a
+// figure with the two histograms, saved as <DistributionFigureDir>/<figurePrefix>_<n>.png.
+// The snapshot is dropped afterwards.
+func (t *StatefulPythonTool) DistributionDiffs(ctx context.Context, sessionID string, targets []DistributionTarget, figurePrefix string) ([]types.DistributionDiff, error) {
+	encoded, err := encodeDistributionTargets(targets)
+	if err != nil {
+		return nil, err
+	}
+	code := fmt.Sprintf(`
+import base64 as _dd_b64, json as _dd_json, os as _dd_os
+import numpy as _dd_np
+import pandas as _dd_pd
+
+def _dd_num(x):
+    x = float(x)
+    return x if _dd_np.isfinite(x) else 0.0
+
+def _dd_stats(v):
+    x = _dd_pd.to_numeric(v, errors='coerce')
+    d = x.dropna()
+    if len(d) == 0:
+        return {'n': 0, 'missing': int(x.isna().sum()), 'mean': 0.0, 'sd': 0.0, 'median': 0.0, 'skew': 0.0, 'min': 0.0, 'max': 0.0}
+    return {'n': int(len(d)), 'missing': int(x.isna().sum()), 'mean': _dd_num(d.mean()), 'sd': _dd_num(d.std()),
+            'median': _dd_num(d.median()), 'skew': _dd_num(d.skew()) if len(d) > 2 else 0.0,
+            'min': _dd_num(d.min()), 'max': _dd_num(d.max())}
+
+_dd_results = []
+_dd_before = globals().get('_sa_dist_before', {})
+for _dd_i, _dd_t in enumerate(_dd_json.loads(_dd_b64.b64decode('%s')), start=1):
+    _dd_key = (_dd_t['frame'], _dd_t['column'])
+    if _dd_key not in _dd_before:
+        continue
+    try:
+        _dd_after = globals()[_dd_t['frame']][_dd_t['column']]
+    except Exception:
+        continue
+    if not _dd_pd.api.types.is_numeric_dtype(_dd_after) or _dd_pd.api.types.is_bool_dtype(_dd_after):
+        continue
+    _dd_res = dict(_dd_t)
+    _dd_res['before'] = _dd_stats(_dd_before[_dd_key])
+    _dd_res['after'] = _dd_stats(_dd_after)
+    try:
+        import matplotlib
+        matplotlib.use('Agg')
+        import matplotlib.pyplot as _dd_plt
+        _dd_os.makedirs('%s', exist_ok=True)
+        _dd_fig, _dd_ax = _dd_plt.subplots(1, 2, figsize=(9, 3.2))
+        _dd_ax[0].hist(_dd_before[_dd_key].dropna(), bins=30, color='#94a3b8')
+        _dd_ax[0].set_title('Before: ' + str(_dd_t['source']), fontsize=10)
+        _dd_ax[1].hist(_dd_after.dropna(), bins=30, color='#0ea5e9')
+        _dd_ax[1].set_title('After: ' + str(_dd_t['column']) + ' (' + _dd_t['kind'] + ')', fontsize=10)
+        _dd_fig.tight_layout()
+        _dd_res['figure'] = '%s/%s_%%d.png' %% _dd_i
+        _dd_fig.savefig(_dd_res['figure'], dpi=90)
+        _dd_plt.close(_dd_fig)
+    except Exception:
+        pass
+    _dd_results.append(_dd_res)
+
+print('%s' + _dd_json.dumps(_dd_results))
+globals().pop('_sa_dist_before', None)
+`, encoded, DistributionFigureDir, DistributionFigureDir, figurePrefix, distributionDiffMarker)
+
+	output, err := t.Call(ctx, code, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare distributions: %w", err)
+	}
+	idx := strings.LastIndex(output, distributionDiffMarker)
+	if idx == -1 {
+		return nil, fmt.Errorf("failed to compare distributions: %s", strings.TrimSpace(output))
+	}
+	payload := strings.TrimSpace(output[idx+len(distributionDiffMarker):])
+	if nl := strings.IndexByte(payload, '\n'); nl != -1 {
+		payload = payload[:nl]
+	}
+	var diffs []types.DistributionDiff
+	if err := json.Unmarshal([]byte(payload), &diffs); err != nil {
+		return nil, fmt.Errorf("failed to parse distribution diffs: %w", err)
+	}
+	return diffs, nil
+}
+
+// encodeDistributionTargets passes targets to Python as base64 JSON, so column names need
+// no quoting.
+func encodeDistributionTargets(targets []DistributionTarget) (string, error) {
+	data, err := json.Marshal(targets)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode distribution targets: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,85 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// ProtectedFilesManifest names the workspace file listing user-uploaded source files.
+// Executed code may read them but executor.py blocks deleting, overwriting, renaming, or
+// truncating them (and the manifest itself); the rest of the workspace stays writable.
+const ProtectedFilesManifest = ".protected_files"
+
+var manifestMu sync.Mutex
+
+// ProtectWorkspaceFiles adds filenames to the workspace's protected files manifest.
+func ProtectWorkspaceFiles(workspaceDir string, filenames ...string) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	path := filepath.Join(workspaceDir, ProtectedFilesManifest)
+	var protected []string
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &protected); err != nil {
+			return fmt.Errorf("failed to parse protected files manifest: %w", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("failed to read protected files manifest: %w", err)
+	}
+
+	for _, name := range filenames {
+		if name != "" && !slices.Contains(protected, name) {
+			protected = append(protected, name)
+		}
+	}
+	data, err = json.Marshal(protected)
+	if err != nil {
+		return fmt.Errorf("failed to marshal protected files manifest: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write protected files manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace protected files manifest: %w", err)
+	}
+	return nil
+}
+
+// ProtectedFileViolation is a blocked attempt by executed code to modify a protected upload.
+type ProtectedFileViolation struct {
+	Operation string // delete, overwrite, rename, truncate, chmod, chown
+	File      string
+}
+
+var protectedFileErrorPattern = regexp.MustCompile(`(?m)ProtectedFileError: operation=(\w+) file=(.+)$`)
+
+// ParseProtectedFileViolation extracts the structured violation from executor output.
+func ParseProtectedFileViolation(output string) (*ProtectedFileViolation, bool) {
+	match := protectedFileErrorPattern.FindStringSubmatch(output)
+	if match == nil {
+		return nil, false
+	}
+	return &ProtectedFileViolation{Operation: match[1], File: match[2]}, true
+}
+
+// RecoveryNote tells the model how to continue without touching the upload.
+func (v ProtectedFileViolation) RecoveryNote() string {
+	stem := v.File
+	if ext := filepath.Ext(stem); ext != "" {
+		stem = stem[:len(stem)-len(ext)]
+	}
+	return fmt.Sprintf("The %s of uploaded file %s was blocked: uploaded source files are read-only. "+
+		"Keep changes in the DataFrame, or save them to a new file (e.g. %s_clean%s) and use that from now on.",
+		v.Operation, v.File, stem, filepath.Ext(v.File))
+}
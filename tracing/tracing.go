@@ -0,0 +1,143 @@
+// Package tracing exports OpenTelemetry traces of agent runs over OTLP/HTTP. Each turn is
+// one trace: the request that sent the message, the stream request that runs the agent,
+// and under the run its RAG query stages, LLM calls, Python executions and database calls,
+// carrying the session and run IDs, so a slow turn shows where its time went. The trace
+// context is passed on to LLM servers and the Python executor. Without TRACING_ENABLED
+// the global tracer is a no-op and Start costs almost nothing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"stats-agent/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const tracerName = "stats-agent"
+
+// Span attribute keys shared across packages.
+const (
+	AttrSessionID  = attribute.Key("stats_agent.session_id")
+	AttrRunID      = attribute.Key("stats_agent.run_id")
+	AttrMode       = attribute.Key("stats_agent.mode")
+	AttrTurn       = attribute.Key("stats_agent.turn")
+	AttrHTTPStatus = attribute.Key("http.response.status_code")
+)
+
+// Provider owns the exporting tracer provider. A nil *Provider means tracing is off, and
+// the Wrap functions return their argument unchanged for it.
+type Provider struct {
+	tp *sdktrace.TracerProvider
+}
+
+// Setup installs the global tracer provider and W3C trace-context propagator when
+// TRACING_ENABLED is set; otherwise it returns nil and tracing stays a no-op.
+func Setup(ctx context.Context, cfg *config.Config, logger *zap.Logger) (*Provider, error) {
+	if cfg == nil || !cfg.TracingEnabled {
+		return nil, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.TracingOTLPEndpoint)}
+	if cfg.TracingOTLPInsecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	res := resource.NewSchemaless(attribute.String("service.name", cfg.TracingServiceName))
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	logger.Info("OpenTelemetry tracing enabled",
+		zap.String("endpoint", cfg.TracingOTLPEndpoint),
+		zap.Float64("sample_ratio", cfg.TracingSampleRatio))
+	return &Provider{tp: tp}, nil
+}
+
+// Shutdown flushes buffered spans and stops the exporter.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	return p.tp.Shutdown(ctx)
+}
+
+// Start begins a span as a child of any span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartHTTP begins the server span of a request, continuing the caller's trace from its
+// traceparent header. EventSource and WebSocket clients cannot set headers, so a
+// traceparent query parameter is accepted in its place.
+func StartHTTP(r *http.Request, route string) (context.Context, trace.Span) {
+	var carrier propagation.TextMapCarrier = propagation.HeaderCarrier(r.Header)
+	if r.Header.Get("traceparent") == "" {
+		if traceParent := r.URL.Query().Get("traceparent"); traceParent != "" {
+			carrier = propagation.MapCarrier{"traceparent": traceParent}
+		}
+	}
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), carrier)
+	return otel.Tracer(tracerName).Start(ctx, r.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+		))
+}
+
+// TraceParent returns the W3C traceparent of the span in ctx, or "" without one. Pages
+// pass it to the requests they trigger so those join the same trace.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Detach returns a context carrying the span in ctx but not its cancellation, for work
+// such as an agent run that outlives the request that started it.
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+}
+
+// SetAttributes adds attributes to the span in ctx.
+func SetAttributes(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
+
+// End records err on the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// MarkTurn records the start of an agent loop turn on the span in ctx.
+func MarkTurn(ctx context.Context, turn int) {
+	trace.SpanFromContext(ctx).AddEvent("turn", trace.WithAttributes(AttrTurn.Int(turn)))
+}
+
+// Session returns the session ID attribute.
+func Session(sessionID string) attribute.KeyValue {
+	return AttrSessionID.String(sessionID)
+}
@@ -0,0 +1,204 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"stats-agent/database"
+	"stats-agent/llmclient"
+	"stats-agent/tools"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WrapLLM adds a span to every chat, embedding, rerank and tokenize call. Streaming spans end when
+// the stream closes and mark the first token with an event.
+func WrapLLM(llm llmclient.LLM, p *Provider) llmclient.LLM {
+	if p == nil {
+		return llm
+	}
+	return &tracedLLM{LLM: llm}
+}
+
+type tracedLLM struct {
+	llmclient.LLM
+}
+
+func (t *tracedLLM) Chat(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (string, error) {
+	ctx, span := Start(ctx, "llm.chat", attribute.String("llm.host", host), attribute.Int("llm.messages", len(messages)))
+	response, err := t.LLM.Chat(ctx, host, messages, temperature)
+	span.SetAttributes(attribute.Int("llm.response_chars", len(response)))
+	End(span, err)
+	return response, err
+}
+
+func (t *tracedLLM) ChatStream(ctx context.Context, host string, messages []types.AgentMessage, temperature *float64) (<-chan string, error) {
+	ctx, span := Start(ctx, "llm.chat_stream", attribute.String("llm.host", host), attribute.Int("llm.messages", len(messages)))
+	in, err := t.LLM.ChatStream(ctx, host, messages, temperature)
+	if err != nil {
+		End(span, err)
+		return nil, err
+	}
+
+	// Forward like the client does (consumers always drain the stream), ending the span
+	// when the stream closes
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		start := time.Now()
+		chars := 0
+		for chunk := range in {
+			if chars == 0 && chunk != "" {
+				span.AddEvent("first_token", trace.WithAttributes(attribute.Int64("llm.first_token_ms", time.Since(start).Milliseconds())))
+			}
+			chars += len(chunk)
+			out <- chunk
+		}
+		span.SetAttributes(attribute.Int("llm.response_chars", chars))
+		End(span, ctx.Err())
+	}()
+	return out, nil
+}
+
+func (t *tracedLLM) Embed(ctx context.Context, host string, doc string) ([]float32, error) {
+	ctx, span := Start(ctx, "llm.embed", attribute.String("llm.host", host), attribute.Int("llm.input_chars", len(doc)))
+	embedding, err := t.LLM.Embed(ctx, host, doc)
+	End(span, err)
+	return embedding, err
+}
+
+func (t *tracedLLM) EmbedBatch(ctx context.Context, host string, docs []string) ([][]float32, error) {
+	ctx, span := Start(ctx, "llm.embed_batch", attribute.String("llm.host", host), attribute.Int("llm.inputs", len(docs)))
+	embeddings, err := t.LLM.EmbedBatch(ctx, host, docs)
+	End(span, err)
+	return embeddings, err
+}
+
+func (t *tracedLLM) Tokenize(ctx context.Context, host string, text string) (int, error) {
+	ctx, span := Start(ctx, "llm.tokenize", attribute.String("llm.host", host), attribute.Int("llm.input_chars", len(text)))
+	tokens, err := t.LLM.Tokenize(ctx, host, text)
+	End(span, err)
+	return tokens, err
+}
+
+func (t *tracedLLM) Rerank(ctx context.Context, host, model, query string, documents []string) ([]float64, error) {
+	reranker, ok := t.LLM.(llmclient.Reranker)
+	if !ok {
+		return nil, errors.New("tracing: wrapped LLM does not rerank")
+	}
+	ctx, span := Start(ctx, "llm.rerank", attribute.String("llm.host", host), attribute.Int("llm.documents", len(documents)))
+	scores, err := reranker.Rerank(ctx, host, model, query, documents)
+	End(span, err)
+	return scores, err
+}
+
+// WrapExecutor adds a span to every Python executor call.
+func WrapExecutor(executor tools.Executor, p *Provider) tools.Executor {
+	if p == nil {
+		return executor
+	}
+	return &tracedExecutor{Executor: executor}
+}
+
+type tracedExecutor struct {
+	tools.Executor
+}
+
+func (t *tracedExecutor) Call(ctx context.Context, input string, sessionID string) (string, error) {
+	ctx, span := Start(ctx, "python.execute", Session(sessionID), attribute.Int("python.code_chars", len(input)))
+	output, err := t.Executor.Call(ctx, input, sessionID)
+	span.SetAttributes(attribute.Int("python.output_chars", len(output)))
+	End(span, err)
+	return output, err
+}
+
+// WrapStore adds spans to the store calls on a run's hot path: message history, RAG
+// writes, searches and content fetches, and run recording. Other calls pass through.
+func WrapStore(store database.Store, driver string, p *Provider) database.Store {
+	if p == nil {
+		return store
+	}
+	return &tracedStore{Store: store, system: attribute.String("db.system", driver)}
+}
+
+type tracedStore struct {
+	database.Store
+	system attribute.KeyValue
+}
+
+func (s *tracedStore) CreateMessage(ctx context.Context, msg types.ChatMessage) error {
+	ctx, span := Start(ctx, "db.CreateMessage", s.system, Session(msg.SessionID))
+	err := s.Store.CreateMessage(ctx, msg)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) GetMessagesBySession(ctx context.Context, sessionID uuid.UUID) ([]types.ChatMessage, error) {
+	ctx, span := Start(ctx, "db.GetMessagesBySession", s.system, Session(sessionID.String()))
+	messages, err := s.Store.GetMessagesBySession(ctx, sessionID)
+	span.SetAttributes(attribute.Int("db.rows", len(messages)))
+	End(span, err)
+	return messages, err
+}
+
+func (s *tracedStore) GetSessionByID(ctx context.Context, sessionID uuid.UUID) (types.Session, error) {
+	ctx, span := Start(ctx, "db.GetSessionByID", s.system, Session(sessionID.String()))
+	session, err := s.Store.GetSessionByID(ctx, sessionID)
+	End(span, err)
+	return session, err
+}
+
+func (s *tracedStore) UpsertDocument(ctx context.Context, documentID uuid.UUID, content string, metadata map[string]string, contentHash string) (uuid.UUID, error) {
+	ctx, span := Start(ctx, "db.UpsertDocument", s.system, Session(metadata["session_id"]))
+	id, err := s.Store.UpsertDocument(ctx, documentID, content, metadata, contentHash)
+	End(span, err)
+	return id, err
+}
+
+func (s *tracedStore) CreateEmbedding(ctx context.Context, documentID uuid.UUID, windowIndex, windowStart, windowEnd int, windowText string, embedding []float32) error {
+	ctx, span := Start(ctx, "db.CreateEmbedding", s.system)
+	err := s.Store.CreateEmbedding(ctx, documentID, windowIndex, windowStart, windowEnd, windowText, embedding)
+	End(span, err)
+	return err
+}
+
+func (s *tracedStore) GetDocumentsBatch(ctx context.Context, ids []uuid.UUID) (map[string]string, error) {
+	ctx, span := Start(ctx, "db.GetDocumentsBatch", s.system, attribute.Int("db.ids", len(ids)))
+	contents, err := s.Store.GetDocumentsBatch(ctx, ids)
+	End(span, err)
+	return contents, err
+}
+
+func (s *tracedStore) FindDocumentIDsByContentHash(ctx context.Context, sessionID string, contentHashes []string) (map[string]string, error) {
+	ctx, span := Start(ctx, "db.FindDocumentIDsByContentHash", s.system, Session(sessionID), attribute.Int("db.hashes", len(contentHashes)))
+	ids, err := s.Store.FindDocumentIDsByContentHash(ctx, sessionID, contentHashes)
+	End(span, err)
+	return ids, err
+}
+
+func (s *tracedStore) SearchRAGDocumentsBM25Language(ctx context.Context, query string, limit int, sessionID string, excludeHashes []string, language string, dataset string) ([]database.BM25SearchResult, error) {
+	ctx, span := Start(ctx, "db.SearchRAGDocumentsBM25", s.system, Session(sessionID), attribute.String("rag.language", language))
+	results, err := s.Store.SearchRAGDocumentsBM25Language(ctx, query, limit, sessionID, excludeHashes, language, dataset)
+	span.SetAttributes(attribute.Int("db.rows", len(results)))
+	End(span, err)
+	return results, err
+}
+
+func (s *tracedStore) VectorSearchRAGDocumentsForModel(ctx context.Context, queryVector []float32, limit int, sessionID string, excludeHashes []string, embeddingModel string, dataset string) ([]database.VectorSearchResult, error) {
+	ctx, span := Start(ctx, "db.VectorSearchRAGDocuments", s.system, Session(sessionID))
+	results, err := s.Store.VectorSearchRAGDocumentsForModel(ctx, queryVector, limit, sessionID, excludeHashes, embeddingModel, dataset)
+	span.SetAttributes(attribute.Int("db.rows", len(results)))
+	End(span, err)
+	return results, err
+}
+
+func (s *tracedStore) SaveRunTurn(ctx context.Context, turn types.RunTurn) error {
+	ctx, span := Start(ctx, "db.SaveRunTurn", s.system, Session(turn.SessionID), AttrRunID.String(turn.RunID), AttrTurn.Int(turn.Turn))
+	err := s.Store.SaveRunTurn(ctx, turn)
+	End(span, err)
+	return err
+}
@@ -0,0 +1,196 @@
+package format
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ArtifactType classifies a tool output so the UI can pick a renderer for it and
+// retrieval can favour outputs of the kind a query asks about.
+type ArtifactType string
+
+const (
+	ArtifactText              ArtifactType = "text"
+	ArtifactRegressionSummary ArtifactType = "regression_summary"
+	ArtifactContingencyTable  ArtifactType = "contingency_table"
+	ArtifactModelDiagnostics  ArtifactType = "model_diagnostics"
+)
+
+// Artifact is a classified tool output. Table holds parsed rows for tabular artifacts
+// (the first row is the header); Stats holds labelled summary values in output order.
+type Artifact struct {
+	Type  ArtifactType
+	Table [][]string
+	Stats [][2]string
+}
+
+// artifactParser recognizes one artifact type; ok is false when the output is not of that type.
+type artifactParser func(output string) (artifact Artifact, ok bool)
+
+// artifactParsers run in order and the first match wins, so the more specific types come first
+// (a regression summary also carries model diagnostics).
+var artifactParsers = []artifactParser{
+	parseRegressionSummary,
+	parseContingencyTable,
+	parseModelDiagnostics,
+}
+
+// ClassifyArtifact runs the lightweight output parsers over a tool output and returns the
+// first match, or a plain text artifact when none applies.
+func ClassifyArtifact(output string) Artifact {
+	for _, parse := range artifactParsers {
+		if artifact, ok := parse(output); ok {
+			return artifact
+		}
+	}
+	return Artifact{Type: ArtifactText}
+}
+
+var (
+	// statsmodels coefficient header: "coef  std err  t  P>|t|  [0.025  0.975]"
+	coefHeaderPattern = regexp.MustCompile(`^\s*coef\s+std err\s+([tz])\s+P>\|[tz]\|\s+\[[0-9.]+\s+[0-9.]+\]\s*$`)
+
+	regressionStatKeys = []string{
+		"Dep. Variable", "No. Observations", "R-squared", "Adj. R-squared", "Pseudo R-squ.",
+		"F-statistic", "Prob (F-statistic)", "Log-Likelihood", "LLR p-value", "AIC", "BIC",
+	}
+	diagnosticStatKeys = []string{
+		"Omnibus", "Prob(Omnibus)", "Durbin-Watson", "Jarque-Bera (JB)", "Prob(JB)",
+		"Skew", "Kurtosis", "Cond. No.", "Breusch-Pagan", "Lagrange multiplier statistic",
+	}
+
+	contingencyHintPattern = regexp.MustCompile(`(?i)chi2|chi-square|chi square|contingency|crosstab|fisher|mcnemar|observed|expected`)
+)
+
+// parseRegressionSummary recognizes a statsmodels summary() and extracts its coefficient table.
+func parseRegressionSummary(output string) (Artifact, bool) {
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		match := coefHeaderPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		table := [][]string{{"Term", "Coef", "Std err", match[1], "P>|" + match[1] + "|", "CI low", "CI high"}}
+		for _, row := range lines[i+1:] {
+			trimmed := strings.TrimSpace(row)
+			if strings.HasPrefix(trimmed, "---") {
+				if len(table) > 1 {
+					break
+				}
+				continue
+			}
+			if trimmed == "" || strings.HasPrefix(trimmed, "===") {
+				break
+			}
+			fields := strings.Fields(trimmed)
+			if len(fields) < 7 || !allNumeric(fields[len(fields)-6:]) {
+				break
+			}
+			term := strings.Join(fields[:len(fields)-6], " ")
+			table = append(table, append([]string{term}, fields[len(fields)-6:]...))
+		}
+		if len(table) < 2 {
+			return Artifact{}, false
+		}
+		stats := extractStats(output, regressionStatKeys)
+		stats = append(stats, extractStats(output, diagnosticStatKeys)...)
+		return Artifact{Type: ArtifactRegressionSummary, Table: table, Stats: stats}, true
+	}
+	return Artifact{}, false
+}
+
+// parseContingencyTable recognizes a printed crosstab: a header line followed by rows of a label
+// and integer counts. The output must mention a contingency analysis or carry an "All" margin.
+func parseContingencyTable(output string) (Artifact, bool) {
+	lines := strings.Split(output, "\n")
+	for i := 0; i < len(lines); i++ {
+		header := strings.Fields(lines[i])
+		if len(header) < 2 {
+			continue
+		}
+		indexName := ""
+		j := i + 1
+		if j < len(lines) {
+			if fields := strings.Fields(lines[j]); len(fields) == 1 && !allNumeric(fields) {
+				indexName = fields[0]
+				j++
+			}
+		}
+
+		var rows [][]string
+		hasMargin := false
+		for ; j < len(lines); j++ {
+			fields := strings.Fields(lines[j])
+			if len(fields) < 3 || !allIntegers(fields[1:]) {
+				break
+			}
+			if len(rows) > 0 && len(fields) != len(rows[0]) {
+				break
+			}
+			hasMargin = hasMargin || fields[0] == "All"
+			rows = append(rows, fields)
+		}
+		if len(rows) < 2 {
+			continue
+		}
+
+		width := len(rows[0])
+		switch len(header) {
+		case width - 1:
+			header = append([]string{indexName}, header...)
+		case width:
+			if indexName != "" {
+				header[0] = indexName + " \\ " + header[0]
+			}
+		default:
+			continue
+		}
+		if !hasMargin && !contingencyHintPattern.MatchString(output) {
+			return Artifact{}, false
+		}
+
+		table := append([][]string{header}, rows...)
+		return Artifact{Type: ArtifactContingencyTable, Table: table, Stats: extractStats(output, []string{"chi2", "Chi-square", "p-value", "dof"})}, true
+	}
+	return Artifact{}, false
+}
+
+// parseModelDiagnostics recognizes residual diagnostics printed without a coefficient table.
+func parseModelDiagnostics(output string) (Artifact, bool) {
+	stats := extractStats(output, diagnosticStatKeys)
+	if len(stats) < 2 {
+		return Artifact{}, false
+	}
+	return Artifact{Type: ArtifactModelDiagnostics, Stats: stats}, true
+}
+
+// extractStats finds "Key: value" (or "Key = value") pairs for the given keys.
+func extractStats(output string, keys []string) [][2]string {
+	var stats [][2]string
+	for _, key := range keys {
+		pattern := regexp.MustCompile(`(?:^|\s)` + regexp.QuoteMeta(key) + `\s*[:=]\s*(\S+)`)
+		if match := pattern.FindStringSubmatch(output); match != nil {
+			stats = append(stats, [2]string{key, strings.TrimRight(match[1], ",;")})
+		}
+	}
+	return stats
+}
+
+func allNumeric(fields []string) bool {
+	for _, f := range fields {
+		if _, err := strconv.ParseFloat(strings.Trim(f, "[]"), 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func allIntegers(fields []string) bool {
+	for _, f := range fields {
+		if _, err := strconv.Atoi(f); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package format
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"stats-agent/web/templates/components"
+	"strings"
+)
+
+// ArtifactRenderer renders a classified tool output. raw is the full output text; renderers
+// should keep it reachable next to any structured view.
+type ArtifactRenderer func(ctx context.Context, artifact Artifact, raw string, w io.Writer) error
+
+// artifactRenderers maps artifact types to their renderers. Types without one fall back to
+// the plain execution output block.
+var artifactRenderers = map[ArtifactType]ArtifactRenderer{
+	ArtifactRegressionSummary: renderArtifactTable("Regression coefficients"),
+	ArtifactContingencyTable:  renderArtifactTable("Contingency table"),
+	ArtifactModelDiagnostics:  renderArtifactTable("Model diagnostics"),
+}
+
+// RegisterArtifactRenderer sets the renderer for an artifact type, replacing any existing one.
+// It is not safe for concurrent use and should be called during initialization.
+func RegisterArtifactRenderer(t ArtifactType, renderer ArtifactRenderer) {
+	artifactRenderers[t] = renderer
+}
+
+// RenderToolOutput classifies a tool output and renders it with the renderer registered for its type.
+// Warnings raised by the cell are split off and shown as a collapsed caution below the output.
+func RenderToolOutput(ctx context.Context, raw string, w io.Writer) error {
+	raw, warnings := SplitWarnings(raw)
+	if err := renderToolArtifact(ctx, raw, w); err != nil {
+		return err
+	}
+	if len(warnings) == 0 {
+		return nil
+	}
+	lines := make([]string, len(warnings))
+	for i, warning := range warnings {
+		lines[i] = warning.String()
+	}
+	if err := components.WarningsBlock(lines).Render(ctx, w); err != nil {
+		return fmt.Errorf("failed to render warnings: %w", err)
+	}
+	return nil
+}
+
+func renderToolArtifact(ctx context.Context, raw string, w io.Writer) error {
+	artifact := ClassifyArtifact(raw)
+	if renderer, ok := artifactRenderers[artifact.Type]; ok {
+		return renderer(ctx, artifact, raw, w)
+	}
+	return components.ExecutionResultBlock(raw).Render(ctx, w)
+}
+
+func renderArtifactTable(title string) ArtifactRenderer {
+	return func(ctx context.Context, artifact Artifact, raw string, w io.Writer) error {
+		if err := components.ArtifactTableBlock(title, string(artifact.Type), artifact.Table, artifact.Stats, strings.TrimSpace(raw)).Render(ctx, w); err != nil {
+			return fmt.Errorf("failed to render %s artifact: %w", artifact.Type, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+package format
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// maxAskUserOptions bounds the quick-reply buttons shown for a clarification question.
+const maxAskUserOptions = 6
+
+// AskUser is a clarification question the agent asks instead of guessing,
+// e.g. which column is the outcome or the grouping factor.
+type AskUser struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+}
+
+// ParseAskUser extracts the clarification question from an <ask_user> block. The block
+// holds JSON ({"question": ..., "options": [...]}); a plain-text fallback takes the first
+// line as the question and "- " lines as options. Returns false when no question is found.
+func ParseAskUser(text string) (AskUser, bool) {
+	content, found := ExtractTagContent(text, AskUserTag)
+	if !found || content == "" {
+		return AskUser{}, false
+	}
+
+	var q AskUser
+	if err := json.Unmarshal([]byte(content), &q); err != nil {
+		q = AskUser{}
+		for _, line := range strings.Split(content, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			if option, ok := strings.CutPrefix(line, "- "); ok {
+				q.Options = append(q.Options, option)
+			} else if q.Question == "" {
+				q.Question = line
+			}
+		}
+	}
+
+	q.Question = strings.TrimSpace(q.Question)
+	if q.Question == "" {
+		return AskUser{}, false
+	}
+	options := make([]string, 0, len(q.Options))
+	seen := make(map[string]bool, len(q.Options))
+	for _, option := range q.Options {
+		option = strings.TrimSpace(option)
+		if option == "" || seen[option] {
+			continue
+		}
+		seen[option] = true
+		options = append(options, option)
+		if len(options) == maxAskUserOptions {
+			break
+		}
+	}
+	q.Options = options
+	return q, true
+}
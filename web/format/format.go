@@ -8,6 +8,9 @@ import (
 const (
 	TagTool        = "tool"
 	TagAgentStatus = "agent_status"
+	TagAskUser     = "ask_user"
+	TagSQL         = "sql"
+	TagWarnings    = "warnings"
 )
 
 // Tag represents a custom XML-like tag used in the application.
@@ -32,8 +35,27 @@ var (
 		CloseTag: "</agent_status>",
 	}
 
+	AskUserTag = Tag{
+		Name:     TagAskUser,
+		OpenTag:  "<ask_user>",
+		CloseTag: "</ask_user>",
+	}
+
+	SQLTag = Tag{
+		Name:     TagSQL,
+		OpenTag:  "<sql>",
+		CloseTag: "</sql>",
+	}
+
+	// WarningsTag wraps the Python warnings the executor appends to a cell's output
+	WarningsTag = Tag{
+		Name:     TagWarnings,
+		OpenTag:  "<warnings>",
+		CloseTag: "</warnings>",
+	}
+
 	// AllTags contains all tags for iteration
-	AllTags = []Tag{ToolTag, AgentStatusTag}
+	AllTags = []Tag{ToolTag, AgentStatusTag, AskUserTag, SQLTag, WarningsTag}
 )
 
 // HasTag checks if text contains a specific tag (opening or closing).
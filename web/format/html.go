@@ -15,8 +15,8 @@ import (
 // It processes markdown text and renders custom XML tags / code blocks as HTML components.
 // This function is ONLY called when saving to the database, NOT during streaming.
 func ConvertToHTML(ctx context.Context, rawContent string) (string, error) {
-	// Combined regex to find markdown code blocks and agent status tags
-	tagPattern := `(?s)(` + "```python.*?```" + `|<agent_status>.*?</agent_status>)`
+	// Combined regex to find markdown code blocks, SQL blocks and agent status tags
+	tagPattern := `(?s)(` + "```python.*?```" + `|<agent_status>.*?</agent_status>|<ask_user>.*?</ask_user>|<sql>.*?</sql>)`
 	re := regexp.MustCompile(tagPattern)
 
 	// Step 1: Find all custom tags and their positions
@@ -138,7 +138,7 @@ func renderTagComponent(ctx context.Context, taggedContent string) (string, erro
 		}
 	} else if after, ok := strings.CutPrefix(taggedContent, ToolTag.OpenTag); ok {
 		result := strings.TrimSuffix(after, ToolTag.CloseTag)
-		if err := components.ExecutionResultBlock(result).Render(ctx, &buf); err != nil {
+		if err := RenderToolOutput(ctx, result, &buf); err != nil {
 			return "", fmt.Errorf("failed to render tool block: %w", err)
 		}
 	} else if after, ok := strings.CutPrefix(taggedContent, AgentStatusTag.OpenTag); ok {
@@ -146,6 +146,17 @@ func renderTagComponent(ctx context.Context, taggedContent string) (string, erro
 		if err := components.AgentStatus(status).Render(ctx, &buf); err != nil {
 			return "", fmt.Errorf("failed to render agent status: %w", err)
 		}
+	} else if after, ok := strings.CutPrefix(taggedContent, SQLTag.OpenTag); ok {
+		query := strings.TrimSpace(strings.TrimSuffix(after, SQLTag.CloseTag))
+		if err := components.SQLCodeBlock(query).Render(ctx, &buf); err != nil {
+			return "", fmt.Errorf("failed to render sql block: %w", err)
+		}
+	} else if strings.HasPrefix(taggedContent, AskUserTag.OpenTag) {
+		if q, ok := ParseAskUser(taggedContent); ok {
+			if err := components.AskUser(q.Question, q.Options).Render(ctx, &buf); err != nil {
+				return "", fmt.Errorf("failed to render clarification question: %w", err)
+			}
+		}
 	}
 
 	return buf.String(), nil
@@ -0,0 +1,104 @@
+package format
+
+import (
+	"strings"
+)
+
+// ExecutionWarning is a Python warning raised while a cell ran, e.g. a ConvergenceWarning
+// from scikit-learn or a RuntimeWarning from numpy.
+type ExecutionWarning struct {
+	Category string
+	Message  string
+}
+
+// String formats the warning as the executor reports it: "Category: message".
+func (w ExecutionWarning) String() string {
+	if w.Category == "" {
+		return w.Message
+	}
+	return w.Category + ": " + w.Message
+}
+
+// blockerCategories are warning categories that can invalidate a result: a model that did
+// not converge, a degenerate fit, or numeric trouble (overflow, division by zero, NaN).
+// Deprecation and future-behaviour notices are shown but never block.
+var blockerCategories = map[string]bool{
+	"ConvergenceWarning":       true,
+	"RuntimeWarning":           true,
+	"PerfectSeparationWarning": true,
+	"HessianInversionWarning":  true,
+	"IterationLimitWarning":    true,
+	"ConstantInputWarning":     true,
+	"NearConstantInputWarning": true,
+	"SmallSampleWarning":       true,
+	"LinAlgWarning":            true,
+}
+
+// IsPotentialBlocker reports whether the warning may invalidate the result it accompanies.
+func (w ExecutionWarning) IsPotentialBlocker() bool {
+	return blockerCategories[w.Category]
+}
+
+// SplitWarnings separates the <warnings> block the executor appends to a cell's output
+// from the printed output. The block holds one "Category: message" line per warning.
+// Output without a block is returned unchanged with no warnings.
+func SplitWarnings(output string) (string, []ExecutionWarning) {
+	start := strings.LastIndex(output, WarningsTag.OpenTag)
+	if start == -1 {
+		return output, nil
+	}
+	body := output[start+len(WarningsTag.OpenTag):]
+	rest := ""
+	if end := strings.Index(body, WarningsTag.CloseTag); end != -1 {
+		rest = body[end+len(WarningsTag.CloseTag):]
+		body = body[:end]
+	}
+
+	var warnings []ExecutionWarning
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		category, message, ok := strings.Cut(line, ": ")
+		if !ok || strings.ContainsAny(category, " \t") {
+			category, message = "", line
+		}
+		warnings = append(warnings, ExecutionWarning{Category: category, Message: message})
+	}
+	stdout := strings.TrimRight(output[:start], "\n") + rest
+	return stdout, warnings
+}
+
+// AppendWarnings adds the warnings back to an output as a <warnings> block, the form tool
+// messages are stored and streamed in.
+func AppendWarnings(output string, warnings []ExecutionWarning) string {
+	if len(warnings) == 0 {
+		return output
+	}
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(output, "\n"))
+	b.WriteString("\n" + WarningsTag.OpenTag + "\n")
+	for _, w := range warnings {
+		b.WriteString(w.String() + "\n")
+	}
+	b.WriteString(WarningsTag.CloseTag)
+	return b.String()
+}
+
+// WarningCategories returns the distinct warning categories in order of first appearance.
+func WarningCategories(warnings []ExecutionWarning) []string {
+	var categories []string
+	seen := make(map[string]bool, len(warnings))
+	for _, w := range warnings {
+		category := w.Category
+		if category == "" {
+			category = "Warning"
+		}
+		if !seen[category] {
+			seen[category] = true
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"stats-agent/web/problem"
+	"stats-agent/web/services"
+	"stats-agent/web/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// AdminHandler serves the bulk admin job API under /admin/.
+type AdminHandler struct {
+	jobs   *services.AdminJobService
+	logger *zap.Logger
+}
+
+// NewAdminHandler creates an admin handler.
+func NewAdminHandler(jobs *services.AdminJobService, logger *zap.Logger) *AdminHandler {
+	return &AdminHandler{jobs: jobs, logger: logger}
+}
+
+// AdminJobRequest submits a bulk admin job. Before accepts RFC 3339 or a date (YYYY-MM-DD).
+type AdminJobRequest struct {
+	Kind       string   `json:"kind"`
+	UserID     string   `json:"user_id"`
+	Before     string   `json:"before"`
+	SessionIDs []string `json:"session_ids"`
+}
+
+// SubmitJob starts a bulk admin job and returns it with 202 Accepted.
+func (h *AdminHandler) SubmitJob(c *gin.Context) {
+	var req AdminJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid request body")
+		return
+	}
+
+	var params types.AdminJobParams
+	if req.UserID != "" {
+		userID, err := uuid.Parse(req.UserID)
+		if err != nil {
+			problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid user_id")
+			return
+		}
+		params.UserID = &userID
+	}
+	if req.Before != "" {
+		before, err := parseAdminTime(req.Before)
+		if err != nil {
+			problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "before must be RFC 3339 or YYYY-MM-DD")
+			return
+		}
+		params.Before = &before
+	}
+	for _, raw := range req.SessionIDs {
+		id, err := uuid.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			problem.Write(c, http.StatusBadRequest, problem.InvalidSession, "invalid session ID "+raw)
+			return
+		}
+		params.SessionIDs = append(params.SessionIDs, id)
+	}
+
+	job, err := h.jobs.Submit(c.Request.Context(), req.Kind, params)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidAdminJob):
+			problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		case errors.Is(err, services.ErrMemoryDisabled):
+			problem.Write(c, http.StatusServiceUnavailable, problem.FeatureDisabled, "Session memory is not enabled")
+		default:
+			h.logger.Error("Failed to submit admin job", zap.Error(err), zap.String("kind", req.Kind))
+			writeInternalError(c, err, "Failed to submit job")
+		}
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ListJobs returns the most recent admin jobs.
+func (h *AdminHandler) ListJobs(c *gin.Context) {
+	jobs, err := h.jobs.Jobs(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list admin jobs", zap.Error(err))
+		writeInternalError(c, err, "Failed to list jobs")
+		return
+	}
+	if jobs == nil {
+		jobs = []types.AdminJob{}
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// GetJob returns one admin job with its progress.
+func (h *AdminHandler) GetJob(c *gin.Context) {
+	job, ok := h.loadJob(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob stops a pending or running job.
+func (h *AdminHandler) CancelJob(c *gin.Context) {
+	jobID, err := uuid.Parse(c.Param("jobID"))
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid job ID")
+		return
+	}
+	if err := h.jobs.Cancel(c.Request.Context(), jobID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			problem.Write(c, http.StatusConflict, problem.Conflict, "job is not pending or running")
+			return
+		}
+		h.logger.Error("Failed to cancel admin job", zap.Error(err), zap.String("job_id", jobID.String()))
+		writeInternalError(c, err, "Failed to cancel job")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": types.AdminJobCancelled})
+}
+
+// DownloadExport sends the CSV written by a finished usage export job.
+func (h *AdminHandler) DownloadExport(c *gin.Context) {
+	job, ok := h.loadJob(c)
+	if !ok {
+		return
+	}
+	path, ok := h.jobs.ExportPath(job)
+	if !ok {
+		problem.Write(c, http.StatusConflict, problem.Conflict, "job has no finished export")
+		return
+	}
+	c.FileAttachment(path, filepath.Base(path))
+}
+
+func (h *AdminHandler) loadJob(c *gin.Context) (types.AdminJob, bool) {
+	jobID, err := uuid.Parse(c.Param("jobID"))
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid job ID")
+		return types.AdminJob{}, false
+	}
+	job, err := h.jobs.Job(c.Request.Context(), jobID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			problem.Write(c, http.StatusNotFound, problem.NotFound, "job not found")
+			return types.AdminJob{}, false
+		}
+		h.logger.Error("Failed to load admin job", zap.Error(err), zap.String("job_id", jobID.String()))
+		writeInternalError(c, err, "Failed to load job")
+		return types.AdminJob{}, false
+	}
+	return job, true
+}
+
+func parseAdminTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
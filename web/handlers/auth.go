@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"stats-agent/database"
+	"stats-agent/web/middleware"
+	"stats-agent/web/problem"
+	"stats-agent/web/services"
+	"stats-agent/web/templates/components"
+	"stats-agent/web/templates/pages"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// oauthNotices are the messages the sign-in page shows for an ?error= code set by a
+// failed OAuth callback. Only these fixed texts are shown, never text from the URL.
+var oauthNotices = map[string]string{
+	"oauth_denied":     "Sign-in was cancelled at the provider.",
+	"oauth_failed":     "Signing in with the provider failed. Try again.",
+	"oauth_unverified": "The provider did not return a verified email address.",
+	"oauth_password":   "An account with this email already exists. Sign in with your password, then sign in with the provider to link it.",
+}
+
+// AuthHandler serves the sign-in page, email/password and OAuth sign-in, and sign-out.
+type AuthHandler struct {
+	auth   *services.AuthService
+	logger *zap.Logger
+}
+
+// NewAuthHandler creates an auth handler.
+func NewAuthHandler(auth *services.AuthService, logger *zap.Logger) *AuthHandler {
+	return &AuthHandler{auth: auth, logger: logger}
+}
+
+// LoginPage renders the sign-in and registration forms. Signed-in users go straight to next.
+func (h *AuthHandler) LoginPage(c *gin.Context) {
+	next := safeNextPath(c.Query("next"))
+	if middleware.Authenticated(c) {
+		c.Redirect(http.StatusFound, next)
+		return
+	}
+	page := pages.LoginPage(next, c.GetString("csrfToken"), oauthNotices[c.Query("error")], h.auth.RegistrationEnabled(), h.auth.OAuthProviders())
+	page.Render(c.Request.Context(), c.Writer)
+}
+
+// Login signs in with an email and password.
+func (h *AuthHandler) Login(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		problem.Write(c, http.StatusUnauthorized, problem.InvalidSession, "No user session")
+		return
+	}
+	account, err := h.auth.Login(c.Request.Context(), userID, c.PostForm("email"), c.PostForm("password"))
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	h.signIn(c, account.ID, c.PostForm("next"))
+}
+
+// Register turns the current anonymous user into an account and signs it in.
+func (h *AuthHandler) Register(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		problem.Write(c, http.StatusUnauthorized, problem.InvalidSession, "No user session")
+		return
+	}
+	account, err := h.auth.Register(c.Request.Context(), userID, c.PostForm("email"), c.PostForm("password"), c.PostForm("display_name"))
+	if err != nil {
+		h.writeAuthError(c, err)
+		return
+	}
+	h.signIn(c, account.ID, c.PostForm("next"))
+}
+
+// Logout clears the account cookies; the browser continues as a new anonymous user.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	middleware.SignOut(c)
+	redirectAfterAuth(c, "/")
+}
+
+// Account renders the sidebar's account menu.
+func (h *AuthHandler) Account(c *gin.Context) {
+	components.AccountMenu(middleware.UserEmail(c)).Render(c.Request.Context(), c.Writer)
+}
+
+// OAuthStart redirects to the provider's consent page.
+func (h *AuthHandler) OAuthStart(c *gin.Context) {
+	provider := c.Param("provider")
+	if !slices.Contains(h.auth.OAuthProviders(), provider) {
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "Unknown sign-in provider")
+		return
+	}
+	state, err := middleware.NewOAuthState(c, provider, safeNextPath(c.Query("next")))
+	if err != nil {
+		h.logger.Error("Failed to start OAuth sign-in", zap.Error(err))
+		problem.Write(c, http.StatusInternalServerError, problem.Internal, "Failed to start sign-in")
+		return
+	}
+	consentURL, err := h.auth.OAuthURL(provider, state)
+	if err != nil {
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "Unknown sign-in provider")
+		return
+	}
+	c.Redirect(http.StatusFound, consentURL)
+}
+
+// OAuthCallback completes an OAuth sign-in. This is a browser navigation, so failures
+// return to the sign-in page with a notice instead of a problem body.
+func (h *AuthHandler) OAuthCallback(c *gin.Context) {
+	provider := c.Param("provider")
+	next, ok := middleware.ConsumeOAuthState(c, provider, c.Query("state"))
+	if !ok {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "Sign-in expired or was not started from this browser")
+		return
+	}
+	next = safeNextPath(next)
+	if c.Query("error") != "" || c.Query("code") == "" {
+		h.oauthFailed(c, next, "oauth_denied")
+		return
+	}
+
+	userID, ok := currentUserID(c)
+	if !ok {
+		problem.Write(c, http.StatusUnauthorized, problem.InvalidSession, "No user session")
+		return
+	}
+	account, err := h.auth.CompleteOAuth(c.Request.Context(), userID, provider, c.Query("code"))
+	if err != nil {
+		h.logger.Warn("OAuth sign-in failed", zap.String("provider", provider), zap.Error(err))
+		switch {
+		case errors.Is(err, services.ErrOAuthEmailUnverified):
+			h.oauthFailed(c, next, "oauth_unverified")
+		case errors.Is(err, services.ErrOAuthPasswordAccount):
+			h.oauthFailed(c, next, "oauth_password")
+		default:
+			h.oauthFailed(c, next, "oauth_failed")
+		}
+		return
+	}
+	if err := middleware.SignIn(c, account.ID); err != nil {
+		h.logger.Error("Failed to issue account cookies", zap.Error(err))
+		problem.Write(c, http.StatusInternalServerError, problem.Internal, "Failed to sign in")
+		return
+	}
+	c.Redirect(http.StatusFound, next)
+}
+
+func (h *AuthHandler) oauthFailed(c *gin.Context, next, code string) {
+	c.Redirect(http.StatusFound, middleware.LoginPath+"?next="+url.QueryEscape(next)+"&error="+code)
+}
+
+// signIn issues the account's cookies and sends the browser on to next.
+func (h *AuthHandler) signIn(c *gin.Context, accountID uuid.UUID, next string) {
+	if err := middleware.SignIn(c, accountID); err != nil {
+		h.logger.Error("Failed to issue account cookies", zap.Error(err))
+		problem.Write(c, http.StatusInternalServerError, problem.Internal, "Failed to sign in")
+		return
+	}
+	redirectAfterAuth(c, safeNextPath(next))
+}
+
+func (h *AuthHandler) writeAuthError(c *gin.Context, err error) {
+	var inputErr *services.AccountInputError
+	switch {
+	case errors.As(err, &inputErr):
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, inputErr.Reason)
+	case errors.Is(err, services.ErrInvalidCredentials):
+		problem.Write(c, http.StatusUnauthorized, problem.InvalidCredentials, "Invalid email or password")
+	case errors.Is(err, database.ErrEmailTaken):
+		problem.Write(c, http.StatusConflict, problem.Conflict, "An account with this email already exists")
+	case errors.Is(err, database.ErrUserHasAccount):
+		problem.Write(c, http.StatusConflict, problem.Conflict, "Already signed in; sign out to create another account")
+	case errors.Is(err, services.ErrRegistrationDisabled):
+		problem.Write(c, http.StatusNotFound, problem.FeatureDisabled, "Registration is disabled")
+	default:
+		h.logger.Error("Account request failed", zap.Error(err))
+		problem.Write(c, http.StatusInternalServerError, problem.Internal, "Account request failed")
+	}
+}
+
+// redirectAfterAuth sends the browser to target: HTMX form posts get an HX-Redirect,
+// plain form posts a 303.
+func redirectAfterAuth(c *gin.Context, target string) {
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", target)
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.Redirect(http.StatusSeeOther, target)
+}
+
+// safeNextPath keeps post-sign-in redirects on this site: only absolute paths are
+// accepted, not scheme-relative ("//host") ones.
+func safeNextPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, `/\`) {
+		return "/"
+	}
+	return next
+}
+
+func currentUserID(c *gin.Context) (uuid.UUID, bool) {
+	value, ok := c.Get("userID")
+	if !ok {
+		return uuid.Nil, false
+	}
+	userID, ok := value.(uuid.UUID)
+	return userID, ok
+}
@@ -2,145 +2,1411 @@ package handlers
 
 import (
 	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"stats-agent/agent"
 	"stats-agent/config"
 	"stats-agent/database"
+	"stats-agent/metrics"
 	"stats-agent/rag"
+	"stats-agent/tools"
+	"stats-agent/tracing"
 	"stats-agent/web/middleware"
+	"stats-agent/web/problem"
 	"stats-agent/web/services"
 	"stats-agent/web/templates/components"
 	"stats-agent/web/templates/pages"
 	"stats-agent/web/types"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// writeInternalError sends a problem response for an unexpected error: 503
+// executor_unavailable when the Python executor pool could not take the call, otherwise
+// a 500 with detail.
+func writeInternalError(c *gin.Context, err error, detail string) {
+	if errors.Is(err, tools.ErrExecutorUnavailable) {
+		problem.Write(c, http.StatusServiceUnavailable, problem.ExecutorUnavailable, "The Python executor is unavailable; try again shortly")
+		return
+	}
+	problem.Write(c, http.StatusInternalServerError, problem.Internal, detail)
+}
+
+// maxAnalysisSpecBytes caps the size of a posted analysis spec.
+const maxAnalysisSpecBytes = 64 << 10
+
+// messageSearchLimit caps the results of a message search across sessions.
+const messageSearchLimit = 20
+
 type ChatHandler struct {
 	chatService    *services.ChatService
 	streamService  *services.StreamService
 	sessionService *services.SessionService
 	uploadService  *services.UploadService
+	reportService  *services.ReportService
+	jobService     *services.JobService
 	agent          AgentInterface
 	cfg            *config.Config
 	logger         *zap.Logger
-	store          *database.PostgresStore
+	store          database.Store
+}
+
+// AgentInterface defines the subset of agent methods we need
+type AgentInterface interface {
+	GetMemoryManager() *agent.MemoryManager
+	GetRAG() *rag.RAG
+}
+
+type ChatRequest struct {
+	Message   string `json:"message" form:"message"`
+	SessionID string `json:"session_id" form:"session_id"`
+	// Background runs the turn as a background job (see JobService)
+	Background bool `json:"background" form:"background"`
+}
+
+func NewChatHandler(
+	chatService *services.ChatService,
+	streamService *services.StreamService,
+	sessionService *services.SessionService,
+	uploadService *services.UploadService,
+	reportService *services.ReportService,
+	jobService *services.JobService,
+	agent AgentInterface,
+	cfg *config.Config,
+	logger *zap.Logger,
+	store database.Store,
+) *ChatHandler {
+	return &ChatHandler{
+		chatService:    chatService,
+		streamService:  streamService,
+		sessionService: sessionService,
+		uploadService:  uploadService,
+		reportService:  reportService,
+		jobService:     jobService,
+		agent:          agent,
+		cfg:            cfg,
+		logger:         logger,
+		store:          store,
+	}
+}
+
+func (h *ChatHandler) NewChat(c *gin.Context) {
+	c.Header("Cache-Control", "no-cache, no-store, must-revalidate") // Add this line
+	// Delete the session cookie.
+	middleware.ClearSessionCookie(c)
+	// Redirect to the home page. The session middleware will now see no cookie and create a new session.
+	c.Redirect(http.StatusFound, "/")
+}
+
+func (h *ChatHandler) DeleteSession(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	// Get session info before deleting (to get workspace path)
+	session, err := h.store.GetSessionByID(c.Request.Context(), sessionID)
+	if err != nil {
+		h.logger.Error("Failed to get session for deletion", zap.Error(err), zap.String("session_id", sessionIDStr))
+		problem.Write(c, http.StatusNotFound, problem.InvalidSession, "Session not found")
+		return
+	}
+
+	if deleted, err := h.store.DeleteRAGDocumentsBySession(c.Request.Context(), sessionID); err != nil {
+		h.logger.Warn("Failed to delete RAG documents for session",
+			zap.Error(err),
+			zap.String("session_id", sessionIDStr))
+	} else if deleted > 0 {
+		h.logger.Debug("Deleted RAG documents for session",
+			zap.String("session_id", sessionIDStr),
+			zap.Int64("documents_deleted", deleted))
+	}
+
+	// Delete from database (this cascades to messages)
+	if err := h.store.DeleteSession(c.Request.Context(), sessionID); err != nil {
+		h.logger.Error("Failed to delete session from database", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to delete session")
+		return
+	}
+
+	// Cleanup Python executor session binding
+	h.chatService.CleanupSession(sessionIDStr)
+
+	// Delete workspace directory
+	workspaceDir := session.WorkspacePath
+	if workspaceDir != "" {
+		if err := os.RemoveAll(workspaceDir); err != nil {
+			h.logger.Warn("Failed to delete workspace directory", zap.Error(err), zap.String("path", workspaceDir))
+		} else {
+			h.logger.Info("Workspace directory deleted", zap.String("path", workspaceDir))
+		}
+	}
+
+	h.logger.Info("Session deleted successfully", zap.String("session_id", sessionIDStr))
+
+	// Check if this was the current session
+	currentSessionID, exists := c.Get("sessionID")
+	if exists && currentSessionID.(uuid.UUID) == sessionID {
+		// Deleting the current session - clear cookie and redirect to create new session
+		middleware.ClearSessionCookie(c)
+	}
+
+	// Always redirect to home page to refresh the UI
+	// The HX-Redirect header tells HTMX to perform a full page redirect
+	c.Header("HX-Redirect", "/")
+	c.Status(http.StatusOK)
+}
+
+// MergeSession folds the session in the "source" form field into this one: histories,
+// files and session memory are combined and the source ID redirects here. Both sessions
+// must belong to the requesting user.
+func (h *ChatHandler) MergeSession(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+	sourceID, err := uuid.Parse(c.PostForm("source"))
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidSession, "invalid source session ID")
+		return
+	}
+
+	var userUUIDPtr *uuid.UUID
+	if userID, ok := c.Get("userID"); ok {
+		userUUID := userID.(uuid.UUID)
+		userUUIDPtr = &userUUID
+	}
+	// The route checked this session; the source must belong to the same user
+	source, missing, err := h.sessionService.ValidateAndGetSession(c.Request.Context(), sourceID, userUUIDPtr)
+	if err != nil || missing || source == nil {
+		problem.Write(c, http.StatusNotFound, problem.InvalidSession, "Session not found")
+		return
+	}
+
+	result, err := h.chatService.MergeSessions(c.Request.Context(), sourceID, sessionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrRunInProgress):
+			problem.Write(c, http.StatusConflict, problem.RunInProgress, "Wait for the agent to finish before merging sessions")
+		case errors.Is(err, services.ErrMergeIntoSelf):
+			problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "A session cannot be merged into itself")
+		default:
+			h.logger.Error("Failed to merge sessions", zap.Error(err),
+				zap.String("session_id", sessionIDStr),
+				zap.String("source_session_id", sourceID.String()))
+			writeInternalError(c, err, "Failed to merge sessions")
+		}
+		return
+	}
+
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, gin.H{
+			"session_id":          sessionIDStr,
+			"messages":            result.Messages,
+			"duplicate_messages":  result.DuplicateMessages,
+			"documents":           result.Documents,
+			"duplicate_documents": result.DuplicateDocuments,
+			"files":               result.Files,
+			"duplicate_files":     result.DuplicateFiles,
+		})
+		return
+	}
+	c.Header("HX-Redirect", "/chat/"+sessionIDStr)
+	c.Status(http.StatusOK)
+}
+
+// SetVerbosity updates the session's response verbosity (terse, standard, or teaching).
+// The new level applies from the next agent run.
+func (h *ChatHandler) SetVerbosity(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	var req struct {
+		Verbosity string `json:"verbosity" form:"verbosity"`
+	}
+	if err := c.ShouldBind(&req); err != nil || !types.IsValidVerbosity(req.Verbosity) {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "verbosity must be 'terse', 'standard', or 'teaching'")
+		return
+	}
+
+	if err := h.store.UpdateSessionVerbosity(c.Request.Context(), sessionID, req.Verbosity); err != nil {
+		h.logger.Error("Failed to update session verbosity", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to update verbosity")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"verbosity": req.Verbosity})
+}
+
+// SetEffectSizeCheck updates how the session handles hypothesis tests reported
+// without an effect size (off, note, or auto). Applies from the next agent run.
+func (h *ChatHandler) SetEffectSizeCheck(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	var req struct {
+		Mode string `json:"mode" form:"mode"`
+	}
+	if err := c.ShouldBind(&req); err != nil || !types.IsValidEffectSizeCheck(req.Mode) {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "mode must be 'off', 'note', or 'auto'")
+		return
+	}
+
+	if err := h.store.UpdateSessionEffectSizeCheck(c.Request.Context(), sessionID, req.Mode); err != nil {
+		h.logger.Error("Failed to update session effect size check", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to update effect size check")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"effect_size_check": req.Mode})
+}
+
+// SetRandomSeed pins the session's random seed, or clears it when seed is null.
+// The seed applies from the next executed cell and is recorded in the methods pack.
+func (h *ChatHandler) SetRandomSeed(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	var req struct {
+		Seed *int64 `json:"seed" form:"seed"`
+	}
+	if err := c.ShouldBind(&req); err != nil || (req.Seed != nil && (*req.Seed < 0 || *req.Seed > tools.MaxRandomSeed)) {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, fmt.Sprintf("seed must be an integer between 0 and %d, or null", int64(tools.MaxRandomSeed)))
+		return
+	}
+
+	if err := h.store.UpdateSessionRandomSeed(c.Request.Context(), sessionID, req.Seed); err != nil {
+		h.logger.Error("Failed to update session random seed", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to update random seed")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"seed": req.Seed})
+}
+
+// SetLLMModel selects one of the configured LLM_MODELS for the session ("" for the
+// default model). The model serves the session's analysis calls from the next run.
+func (h *ChatHandler) SetLLMModel(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	var req struct {
+		Model string `json:"model" form:"model"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "Invalid request")
+		return
+	}
+	if _, ok := h.cfg.LLMModel(req.Model); req.Model != "" && !ok {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, fmt.Sprintf("unknown model %q", req.Model))
+		return
+	}
+
+	if err := h.store.UpdateSessionLLMModel(c.Request.Context(), sessionID, req.Model); err != nil {
+		h.logger.Error("Failed to update session LLM model", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to update model")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"model": req.Model})
+}
+
+// SetRetrievalPolicy selects a built-in or configured retrieval policy for the session's
+// memory queries ("" for the policy of the session mode). It applies from the next run.
+func (h *ChatHandler) SetRetrievalPolicy(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	var req struct {
+		Policy string `json:"policy" form:"policy"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "Invalid request")
+		return
+	}
+	req.Policy = strings.ToLower(strings.TrimSpace(req.Policy))
+	if req.Policy != "" && !h.cfg.HasRetrievalPolicy(req.Policy) {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest,
+			fmt.Sprintf("unknown retrieval policy %q (available: %s)", req.Policy, strings.Join(h.cfg.RetrievalPolicyNames(), ", ")))
+		return
+	}
+
+	if err := h.store.UpdateSessionRetrievalPolicy(c.Request.Context(), sessionID, req.Policy); err != nil {
+		h.logger.Error("Failed to update session retrieval policy", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to update retrieval policy")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policy": req.Policy})
+}
+
+// llmModelOptions lists the default model and the configured LLM_MODELS for the selector.
+func (h *ChatHandler) llmModelOptions() []types.LLMModelOption {
+	options := []types.LLMModelOption{{Name: "", Label: "Default"}}
+	for _, m := range h.cfg.LLMModels {
+		label := m.Name
+		var notes []string
+		for _, note := range []string{m.Cost, m.Latency} {
+			if note = strings.TrimSpace(note); note != "" {
+				notes = append(notes, note)
+			}
+		}
+		if len(notes) > 0 {
+			label += " (" + strings.Join(notes, ", ") + ")"
+		}
+		options = append(options, types.LLMModelOption{Name: m.Name, Label: label})
+	}
+	return options
+}
+
+// RerunCode executes a user-edited version of an assistant python block.
+// The output is returned as JSON and persisted as a tool message attributed to the user.
+func (h *ChatHandler) RerunCode(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	var req struct {
+		Code         string `json:"code" form:"code"`
+		OriginalCode string `json:"original_code" form:"original_code"`
+	}
+	if err := c.ShouldBind(&req); err != nil || strings.TrimSpace(req.Code) == "" {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "code cannot be empty")
+		return
+	}
+
+	result, err := h.chatService.RerunUserCode(c.Request.Context(), sessionID, req.Code, req.OriginalCode)
+	if errors.Is(err, services.ErrRunInProgress) {
+		problem.Write(c, http.StatusConflict, problem.RunInProgress, "Wait for the agent to finish before re-running code")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to re-run user-edited code", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to run code")
+		return
+	}
+
+	warnings := make([]string, len(result.Warnings))
+	for i, w := range result.Warnings {
+		warnings[i] = w.String()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"output":    result.Result,
+		"warnings":  warnings,
+		"has_error": result.HasError,
+	})
+}
+
+// RunAnalysisSpec compiles and runs a declarative analysis spec posted as YAML or JSON
+// (see tools.ParseAnalysisSpec) and returns the generated code with its output.
+func (h *ChatHandler) RunAnalysisSpec(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAnalysisSpecBytes))
+	if err != nil || strings.TrimSpace(string(body)) == "" {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "analysis spec cannot be empty")
+		return
+	}
+	spec, err := tools.ParseAnalysisSpec(body)
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		return
+	}
+
+	result, err := h.chatService.RunAnalysisSpec(c.Request.Context(), sessionID, spec)
+	if errors.Is(err, services.ErrRunInProgress) {
+		problem.Write(c, http.StatusConflict, problem.RunInProgress, "Wait for the agent to finish before running an analysis spec")
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to run analysis spec", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to run analysis spec")
+		return
+	}
+
+	warnings := make([]string, len(result.Warnings))
+	for i, w := range result.Warnings {
+		warnings[i] = w.String()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"spec":      spec,
+		"code":      result.Code,
+		"output":    result.Result,
+		"warnings":  warnings,
+		"has_error": result.HasError,
+	})
+}
+
+// MethodsPack downloads the session's executed code, outputs, and package versions
+// as a single Markdown file for supplementary materials.
+func (h *ChatHandler) MethodsPack(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	pack, err := h.chatService.BuildMethodsPack(c.Request.Context(), sessionID)
+	if err != nil {
+		h.logger.Error("Failed to build methods pack", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to build methods pack")
+		return
+	}
+
+	filename := fmt.Sprintf("methods-pack-%s.md", sessionIDStr[:8])
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "text/markdown; charset=utf-8", []byte(pack))
+}
+
+// Report downloads the session as a standalone analysis report: conversation, executed
+// code, tool outputs and figures. format=pdf renders it through REPORT_PDF_URL; the
+// default is a single HTML file.
+func (h *ChatHandler) Report(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	filename := fmt.Sprintf("analysis-report-%s", sessionIDStr[:8])
+	switch c.DefaultQuery("format", "html") {
+	case "html":
+		report, err := h.reportService.RenderHTML(c.Request.Context(), sessionID)
+		if err != nil {
+			h.logger.Error("Failed to build report", zap.Error(err), zap.String("session_id", sessionIDStr))
+			writeInternalError(c, err, "Failed to build report")
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".html"))
+		c.Data(http.StatusOK, "text/html; charset=utf-8", report)
+	case "pdf":
+		if !h.reportService.PDFEnabled() {
+			problem.Write(c, http.StatusNotFound, problem.FeatureDisabled, "PDF export is not configured")
+			return
+		}
+		report, err := h.reportService.RenderPDF(c.Request.Context(), sessionID)
+		if err != nil {
+			h.logger.Error("Failed to build PDF report", zap.Error(err), zap.String("session_id", sessionIDStr))
+			problem.Write(c, http.StatusBadGateway, problem.Internal, "Failed to build PDF report")
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".pdf"))
+		c.Data(http.StatusOK, "application/pdf", report)
+	default:
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "format must be html or pdf")
+	}
+}
+
+// ExportNotebook downloads the session as a Jupyter notebook: its executed code as code
+// cells with their outputs and figures, and the conversation as Markdown cells.
+func (h *ChatHandler) ExportNotebook(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	nb, err := h.reportService.BuildNotebook(c.Request.Context(), sessionID)
+	if err != nil {
+		h.logger.Error("Failed to build notebook", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to build notebook")
+		return
+	}
+
+	filename := fmt.Sprintf("analysis-%s.ipynb", sessionIDStr[:8])
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Data(http.StatusOK, "application/x-ipynb+json", nb)
+}
+
+// Lineage renders the session's data transformation log as the "Data lineage" panel.
+// Requests with Accept: application/json get the raw steps instead.
+func (h *ChatHandler) Lineage(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	steps, err := h.chatService.SessionLineage(c.Request.Context(), sessionID)
+	if err != nil {
+		h.logger.Error("Failed to load data lineage", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to load data lineage")
+		return
+	}
+
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, gin.H{"steps": steps})
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	components.LineagePanel(sessionIDStr, steps, len(agent.UnsavedTransformations(steps)), "").Render(c.Request.Context(), c.Writer)
+}
+
+// Rollups renders the session's screening rollups (one sortable results table per test
+// run across many variables). Requests with Accept: application/json get the rollups instead.
+func (h *ChatHandler) Rollups(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	rollups, err := h.chatService.SessionRollups(c.Request.Context(), sessionID)
+	if err != nil {
+		h.logger.Error("Failed to load screening rollups", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to load screening rollups")
+		return
+	}
+
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, gin.H{"rollups": rollups})
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	components.RollupPanel(rollups).Render(c.Request.Context(), c.Writer)
+}
+
+// Artifacts renders a page of the session's figures as the plot gallery strip. The first
+// page renders the whole panel and later pages (?page=N) only their thumbnails, which
+// replace the panel's "More" button. Requests with Accept: application/json get the page
+// as JSON.
+func (h *ChatHandler) Artifacts(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+	page := 1
+	if raw := c.Query("page"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "page must be a positive integer")
+			return
+		}
+		page = n
+	}
+
+	gallery, err := h.chatService.PlotGallery(c.Request.Context(), sessionID, page)
+	if err != nil {
+		h.logger.Error("Failed to load plot gallery", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to load plots")
+		return
+	}
+
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, gallery)
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if page > 1 {
+		components.PlotGalleryItems(sessionIDStr, gallery).Render(c.Request.Context(), c.Writer)
+		return
+	}
+	components.PlotGalleryPanel(sessionIDStr, gallery).Render(c.Request.Context(), c.Writer)
+}
+
+// SQLConsole renders the read-only SQL console over the session's workspace files.
+func (h *ChatHandler) SQLConsole(c *gin.Context) {
+	sessionIDStr := middleware.OwnedSessionID(c).String()
+	if !h.cfg.SQLConsoleEnabled {
+		problem.Write(c, http.StatusNotFound, problem.FeatureDisabled, "The SQL console is disabled")
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	components.SQLConsolePanel(sessionIDStr, "", nil, "").Render(c.Request.Context(), c.Writer)
+}
+
+// QuerySQL runs a read-only query from the SQL console (form fields query, inject and
+// format). format=csv downloads the result; requests with Accept: application/json get
+// the result as JSON; others get the console panel with the result table. With inject,
+// the query and result are also added to the conversation.
+func (h *ChatHandler) QuerySQL(c *gin.Context) {
+	// The route checks ownership (middleware.RequireSessionOwner): the console queries the
+	// session's tables
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+	if !h.cfg.SQLConsoleEnabled {
+		problem.Write(c, http.StatusNotFound, problem.FeatureDisabled, "The SQL console is disabled")
+		return
+	}
+
+	var req struct {
+		Query  string `json:"query" form:"query"`
+		Inject bool   `json:"inject" form:"inject"`
+		Format string `json:"format" form:"format"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid request")
+		return
+	}
+	wantsJSON := c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON
+
+	result, err := h.chatService.RunSQLQuery(c.Request.Context(), sessionID, req.Query, req.Inject && req.Format != "csv")
+	if err != nil {
+		var message string
+		switch {
+		case errors.Is(err, services.ErrRunInProgress):
+			problem.Write(c, http.StatusConflict, problem.RunInProgress, "The agent is running; try again when it finishes")
+			return
+		case errors.Is(err, tools.ErrSQLNotReadOnly), errors.Is(err, tools.ErrSQLQueryFailed):
+			message = err.Error()
+		default:
+			h.logger.Error("Failed to run SQL console query", zap.Error(err), zap.String("session_id", sessionIDStr))
+			writeInternalError(c, err, "Failed to run the query")
+			return
+		}
+		if wantsJSON || req.Format == "csv" {
+			problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, message)
+			return
+		}
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		components.SQLConsolePanel(sessionIDStr, req.Query, nil, message).Render(c.Request.Context(), c.Writer)
+		return
+	}
+
+	switch {
+	case req.Format == "csv":
+		var b strings.Builder
+		w := csv.NewWriter(&b)
+		w.Write(result.Columns)
+		w.WriteAll(result.Rows)
+		filename := fmt.Sprintf("query-%s.csv", sessionIDStr[:8])
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", []byte(b.String()))
+	case wantsJSON:
+		c.JSON(http.StatusOK, gin.H{"result": result})
+	default:
+		message := ""
+		if req.Inject {
+			message = "Result added to the conversation."
+		}
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		components.SQLConsolePanel(sessionIDStr, req.Query, result, message).Render(c.Request.Context(), c.Writer)
+	}
+}
+
+// PersistDataset saves the dataframe holding the session's unsaved transformations to a
+// CSV in the workspace. Requests with Accept: application/json get the filename; others
+// get the refreshed lineage panel.
+func (h *ChatHandler) PersistDataset(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	filename, err := h.chatService.PersistCleanedDataset(c.Request.Context(), sessionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrRunInProgress):
+			problem.Write(c, http.StatusConflict, problem.RunInProgress, "The agent is running; try again when it finishes")
+		case errors.Is(err, services.ErrNothingToPersist):
+			problem.Write(c, http.StatusConflict, problem.Conflict, "All recorded transformations are already saved")
+		default:
+			h.logger.Error("Failed to persist cleaned dataset", zap.Error(err), zap.String("session_id", sessionIDStr))
+			writeInternalError(c, err, "Failed to save the cleaned dataset")
+		}
+		return
+	}
+
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, gin.H{"filename": filename})
+		return
+	}
+	steps, err := h.chatService.SessionLineage(c.Request.Context(), sessionID)
+	if err != nil {
+		h.logger.Error("Failed to load data lineage", zap.Error(err), zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Failed to load data lineage")
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	components.LineagePanel(sessionIDStr, steps, len(agent.UnsavedTransformations(steps)), "Cleaned dataset saved as "+filename+".").Render(c.Request.Context(), c.Writer)
+}
+
+// ColumnTypes renders the column type editor for one of the session's datasets
+// (?dataset=, defaulting to the first uploaded one).
+func (h *ChatHandler) ColumnTypes(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	view, err := h.chatService.ColumnTypes(c.Request.Context(), sessionID, c.Query("dataset"))
+	if err != nil {
+		h.columnTypesError(c, sessionIDStr, err)
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	components.ColumnTypesPanel(sessionIDStr, view, "").Render(c.Request.Context(), c.Writer)
+}
+
+// SaveColumnTypes stores the column types submitted from the editor. Each column
+// is posted as a "type:<column>" form field.
+func (h *ChatHandler) SaveColumnTypes(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+	if err := c.Request.ParseForm(); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid form")
+		return
+	}
+
+	columnTypes := make(map[string]string)
+	for key, values := range c.Request.PostForm {
+		if col, ok := strings.CutPrefix(key, "type:"); ok && len(values) > 0 {
+			columnTypes[col] = values[0]
+		}
+	}
+
+	view, err := h.chatService.SetColumnTypes(c.Request.Context(), sessionID, c.PostForm("dataset"), columnTypes)
+	if err != nil {
+		h.columnTypesError(c, sessionIDStr, err)
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	components.ColumnTypesPanel(sessionIDStr, view, "Column types saved.").Render(c.Request.Context(), c.Writer)
+}
+
+func (h *ChatHandler) columnTypesError(c *gin.Context, sessionID string, err error) {
+	switch {
+	case errors.Is(err, services.ErrRunInProgress):
+		problem.Write(c, http.StatusConflict, problem.RunInProgress, "The agent is running; try again when it finishes")
+	case errors.Is(err, services.ErrInvalidColumnType):
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+	case errors.Is(err, services.ErrUnknownDataset):
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "Dataset not found in this session")
+	default:
+		h.logger.Error("Failed to handle column types", zap.Error(err), zap.String("session_id", sessionID))
+		writeInternalError(c, err, "Failed to load column types")
+	}
+}
+
+// Steps renders the session's executed steps with their bookmarks.
+func (h *ChatHandler) Steps(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	h.renderStepsPanel(c, sessionID, "")
+}
+
+// BookmarkStep bookmarks an executed step (message_id is the step's tool message).
+func (h *ChatHandler) BookmarkStep(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	var req struct {
+		MessageID string `json:"message_id" form:"message_id"`
+		Label     string `json:"label" form:"label"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid request")
+		return
+	}
+
+	if err := h.chatService.BookmarkStep(c.Request.Context(), sessionID, req.MessageID, req.Label); err != nil {
+		h.bookmarkError(c, sessionIDStr, err)
+		return
+	}
+	h.renderStepsPanel(c, sessionID, "Bookmark saved.")
+}
+
+// DeleteBookmark removes a step bookmark.
+func (h *ChatHandler) DeleteBookmark(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+	bookmarkID, err := uuid.Parse(c.Param("bookmarkID"))
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid bookmark ID")
+		return
+	}
+
+	if err := h.chatService.DeleteStepBookmark(c.Request.Context(), sessionID, bookmarkID); err != nil {
+		h.bookmarkError(c, sessionIDStr, err)
+		return
+	}
+	h.renderStepsPanel(c, sessionID, "Bookmark removed.")
+}
+
+// JumpToBookmark restores the Python session to a bookmarked step by replaying the
+// executed code up to it.
+func (h *ChatHandler) JumpToBookmark(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+	bookmarkID, err := uuid.Parse(c.Param("bookmarkID"))
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid bookmark ID")
+		return
+	}
+
+	step, result, err := h.chatService.JumpToBookmark(c.Request.Context(), sessionID, bookmarkID)
+	if err != nil {
+		h.bookmarkError(c, sessionIDStr, err)
+		return
+	}
+
+	message := fmt.Sprintf("Python state restored to step %d (%d steps replayed).", step.Number, result.Replayed)
+	if len(result.Failed) > 0 {
+		message += fmt.Sprintf(" %d replayed step(s) raised an error; the state may differ from the original run.", len(result.Failed))
+	}
+	h.renderStepsPanel(c, sessionID, message)
+}
+
+func (h *ChatHandler) renderStepsPanel(c *gin.Context, sessionID uuid.UUID, message string) {
+	steps, err := h.chatService.ExecutedSteps(c.Request.Context(), sessionID)
+	if err != nil {
+		h.bookmarkError(c, sessionID.String(), err)
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	components.StepsPanel(sessionID.String(), steps, message).Render(c.Request.Context(), c.Writer)
+}
+
+func (h *ChatHandler) bookmarkError(c *gin.Context, sessionID string, err error) {
+	switch {
+	case errors.Is(err, services.ErrRunInProgress):
+		problem.Write(c, http.StatusConflict, problem.RunInProgress, "Wait for the agent to finish before jumping back")
+	case errors.Is(err, services.ErrUnknownStep):
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "Step not found in this session")
+	case errors.Is(err, services.ErrUnknownBookmark):
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "Bookmark not found in this session")
+	default:
+		h.logger.Error("Failed to handle step bookmarks", zap.Error(err), zap.String("session_id", sessionID))
+		writeInternalError(c, err, "Failed to handle step bookmarks")
+	}
+}
+
+// AnnotateMessage saves a private note on a message and re-renders the message's notes.
+func (h *ChatHandler) AnnotateMessage(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	var req struct {
+		MessageID string `json:"message_id" form:"message_id"`
+		Note      string `json:"note" form:"note"`
+		Remember  bool   `json:"remember" form:"remember"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid request")
+		return
+	}
+
+	if _, err := h.chatService.AnnotateMessage(c.Request.Context(), sessionID, req.MessageID, req.Note, req.Remember); err != nil {
+		h.annotationError(c, sessionIDStr, err)
+		return
+	}
+	h.renderAnnotations(c, sessionID, req.MessageID)
+}
+
+// DeleteAnnotation removes a note and re-renders the notes of the message it was on.
+func (h *ChatHandler) DeleteAnnotation(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+	annotationID, err := uuid.Parse(c.Param("annotationID"))
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid annotation ID")
+		return
+	}
+
+	messageID, err := h.chatService.DeleteAnnotation(c.Request.Context(), sessionID, annotationID)
+	if err != nil {
+		h.annotationError(c, sessionIDStr, err)
+		return
+	}
+	h.renderAnnotations(c, sessionID, messageID)
+}
+
+func (h *ChatHandler) renderAnnotations(c *gin.Context, sessionID uuid.UUID, messageID string) {
+	notes, err := h.chatService.MessageAnnotations(c.Request.Context(), sessionID, messageID)
+	if err != nil {
+		h.annotationError(c, sessionID.String(), err)
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	components.MessageAnnotations(sessionID.String(), messageID, notes).Render(c.Request.Context(), c.Writer)
+}
+
+// RetractMessage deletes a message and the RAG artifacts derived from it, then has the
+// page reload so the conversation shows without it. JSON clients get the counts instead.
+func (h *ChatHandler) RetractMessage(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	result, err := h.chatService.RetractMessage(c.Request.Context(), sessionID, c.Param("messageID"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrRunInProgress):
+			problem.Write(c, http.StatusConflict, problem.RunInProgress, "Wait for the agent to finish before retracting messages")
+		case errors.Is(err, services.ErrUnknownMessage):
+			problem.Write(c, http.StatusNotFound, problem.NotFound, "Message not found in this session")
+		default:
+			h.logger.Error("Failed to retract message", zap.Error(err), zap.String("session_id", sessionIDStr))
+			writeInternalError(c, err, "Failed to retract message")
+		}
+		return
+	}
+
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, gin.H{"messages": result.Messages, "documents": result.Documents})
+		return
+	}
+	c.Header("HX-Refresh", "true")
+	c.Status(http.StatusOK)
+}
+
+func (h *ChatHandler) annotationError(c *gin.Context, sessionID string, err error) {
+	switch {
+	case errors.Is(err, services.ErrEmptyAnnotation):
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "Note cannot be empty")
+	case errors.Is(err, services.ErrUnknownMessage):
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "Message not found in this session")
+	case errors.Is(err, services.ErrUnknownAnnotation):
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "Note not found in this session")
+	default:
+		h.logger.Error("Failed to handle message annotations", zap.Error(err), zap.String("session_id", sessionID))
+		writeInternalError(c, err, "Failed to handle notes")
+	}
+}
+
+// RetrievalFeedback records a user's helpful/not helpful rating of an answer
+// as an outcome signal for the retrieval experiment.
+func (h *ChatHandler) RetrievalFeedback(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+
+	var req struct {
+		Helpful *bool `json:"helpful" form:"helpful"`
+	}
+	if err := c.ShouldBind(&req); err != nil || req.Helpful == nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "helpful must be true or false")
+		return
+	}
+
+	h.chatService.RecordRetrievalFeedback(sessionID, *req.Helpful)
+	c.JSON(http.StatusOK, gin.H{"recorded": true})
+}
+
+// RetrievalExperimentSummary returns per-arm outcome aggregates for the last `days` days
+// (default 7). Served under the admin API, since the aggregates cover every session.
+func (h *ChatHandler) RetrievalExperimentSummary(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days <= 0 {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "days must be a positive integer")
+		return
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	summary, err := h.store.GetRetrievalExperimentSummary(c.Request.Context(), since)
+	if err != nil {
+		h.logger.Error("Failed to load retrieval experiment summary", zap.Error(err))
+		writeInternalError(c, err, "Failed to load experiment summary")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": h.cfg.RetrievalExperimentEnabled,
+		"since":   since.UTC().Format(time.RFC3339),
+		"arms":    summary,
+	})
+}
+
+// IngestionStats returns the background RAG write counters and queue depth. Served under
+// the admin API, since the counters cover every session.
+func (h *ChatHandler) IngestionStats(c *gin.Context) {
+	ragInstance := h.agent.GetRAG()
+	if ragInstance == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	stats := ragInstance.IngestionStats()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":         true,
+		"enqueued":        stats.Enqueued,
+		"merged":          stats.Merged,
+		"dropped":         stats.Dropped,
+		"batches":         stats.Batches,
+		"retried":         stats.Retried,
+		"failed":          stats.Failed,
+		"queue_depth":     stats.QueueDepth,
+		"queued_sessions": stats.QueuedSessions,
+		"active_workers":  stats.ActiveWorkers,
+		"workers":         stats.Workers,
+	})
+}
+
+// MemoryCheckpoints lists the session's named memory checkpoints and the one retrieval
+// is scoped to.
+func (h *ChatHandler) MemoryCheckpoints(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	checkpoints, scope, err := h.chatService.MemoryCheckpoints(c.Request.Context(), sessionID)
+	if err != nil {
+		h.checkpointError(c, sessionIDStr, err)
+		return
+	}
+	if checkpoints == nil {
+		checkpoints = []types.MemoryCheckpoint{}
+	}
+	c.JSON(http.StatusOK, gin.H{"checkpoints": checkpoints, "scope": scope})
+}
+
+// CreateMemoryCheckpoint snapshots the session's memory under a name.
+func (h *ChatHandler) CreateMemoryCheckpoint(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	var req struct {
+		Name string `json:"name" form:"name"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid request")
+		return
+	}
+
+	checkpoint, err := h.chatService.CreateMemoryCheckpoint(c.Request.Context(), sessionID, req.Name)
+	if err != nil {
+		h.checkpointError(c, sessionIDStr, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"checkpoint": checkpoint})
+}
+
+// DeleteMemoryCheckpoint removes a memory checkpoint.
+func (h *ChatHandler) DeleteMemoryCheckpoint(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+	checkpointID, err := uuid.Parse(c.Param("checkpointID"))
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid checkpoint ID")
+		return
+	}
+
+	if err := h.chatService.DeleteMemoryCheckpoint(c.Request.Context(), sessionID, checkpointID); err != nil {
+		h.checkpointError(c, sessionIDStr, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
+
+// ScopeMemoryCheckpoint scopes the session's retrieval to a checkpoint; an empty
+// checkpoint_id returns it to the current memory.
+func (h *ChatHandler) ScopeMemoryCheckpoint(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	var req struct {
+		CheckpointID string `json:"checkpoint_id" form:"checkpoint_id"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid request")
+		return
+	}
+	checkpointID := uuid.Nil
+	if id := strings.TrimSpace(req.CheckpointID); id != "" {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid checkpoint ID")
+			return
+		}
+		checkpointID = parsed
+	}
+
+	scope, err := h.chatService.ScopeRetrievalToCheckpoint(c.Request.Context(), sessionID, checkpointID)
+	if err != nil {
+		h.checkpointError(c, sessionIDStr, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"scope": scope})
 }
 
-// AgentInterface defines the subset of agent methods we need
-type AgentInterface interface {
-	GetMemoryManager() *agent.MemoryManager
-	GetRAG() *rag.RAG
+// DiffMemoryCheckpoints reports what the session learned between checkpoint `from` and
+// checkpoint `to`, or the current memory when `to` is omitted.
+func (h *ChatHandler) DiffMemoryCheckpoints(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+	fromID, err := uuid.Parse(c.Query("from"))
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "from must be a checkpoint ID")
+		return
+	}
+	toID := uuid.Nil
+	if to := c.Query("to"); to != "" && to != "current" {
+		if toID, err = uuid.Parse(to); err != nil {
+			problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "to must be a checkpoint ID or \"current\"")
+			return
+		}
+	}
+
+	diff, err := h.chatService.DiffMemoryCheckpoints(c.Request.Context(), sessionID, fromID, toID)
+	if err != nil {
+		h.checkpointError(c, sessionIDStr, err)
+		return
+	}
+	c.JSON(http.StatusOK, diff)
 }
 
-type ChatRequest struct {
-	Message   string `json:"message" form:"message"`
-	SessionID string `json:"session_id" form:"session_id"`
+func (h *ChatHandler) checkpointError(c *gin.Context, sessionID string, err error) {
+	switch {
+	case errors.Is(err, services.ErrMemoryDisabled):
+		problem.Write(c, http.StatusServiceUnavailable, problem.FeatureDisabled, "Session memory is not enabled")
+	case errors.Is(err, services.ErrUnknownCheckpoint):
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "Checkpoint not found in this session")
+	case errors.Is(err, database.ErrCheckpointNameTaken):
+		problem.Write(c, http.StatusConflict, problem.Conflict, "A checkpoint with this name already exists")
+	default:
+		h.logger.Error("Failed to handle memory checkpoints", zap.Error(err), zap.String("session_id", sessionID))
+		writeInternalError(c, err, "Failed to handle memory checkpoints")
+	}
 }
 
-func NewChatHandler(
-	chatService *services.ChatService,
-	streamService *services.StreamService,
-	sessionService *services.SessionService,
-	uploadService *services.UploadService,
-	agent AgentInterface,
-	cfg *config.Config,
-	logger *zap.Logger,
-	store *database.PostgresStore,
-) *ChatHandler {
-	return &ChatHandler{
-		chatService:    chatService,
-		streamService:  streamService,
-		sessionService: sessionService,
-		uploadService:  uploadService,
-		agent:          agent,
-		cfg:            cfg,
-		logger:         logger,
-		store:          store,
+// Pins lists the facts pinned to the session's memory.
+func (h *ChatHandler) Pins(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	h.renderPins(c, sessionID, http.StatusOK)
+}
+
+// AddPin pins a fact to the session's memory; the agent sees it on every turn.
+func (h *ChatHandler) AddPin(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+
+	var req struct {
+		Text string `json:"text" form:"text"`
 	}
+	if err := c.ShouldBind(&req); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid request")
+		return
+	}
+
+	if _, err := h.chatService.AddPin(c.Request.Context(), sessionID, req.Text); err != nil {
+		h.pinError(c, sessionIDStr, err)
+		return
+	}
+	h.renderPins(c, sessionID, http.StatusCreated)
 }
 
-func (h *ChatHandler) NewChat(c *gin.Context) {
-	c.Header("Cache-Control", "no-cache, no-store, must-revalidate") // Add this line
-	// By setting the cookie's max age to -1, we tell the browser to delete it.
-	c.SetCookie(middleware.SessionCookieName, "", -1, "/", "", false, true)
-	// Redirect to the home page. The session middleware will now see no cookie and create a new session.
-	c.Redirect(http.StatusFound, "/")
+// DeletePin unpins a fact.
+func (h *ChatHandler) DeletePin(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+	pinID, err := uuid.Parse(c.Param("pinID"))
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid pin ID")
+		return
+	}
+
+	if err := h.chatService.DeletePin(c.Request.Context(), sessionID, pinID); err != nil {
+		h.pinError(c, sessionIDStr, err)
+		return
+	}
+	h.renderPins(c, sessionID, http.StatusOK)
 }
 
-func (h *ChatHandler) DeleteSession(c *gin.Context) {
-	sessionIDStr := c.Param("sessionID")
-	sessionID, err := uuid.Parse(sessionIDStr)
+// renderPins answers with the session's pins: JSON for API clients, otherwise the pins panel.
+func (h *ChatHandler) renderPins(c *gin.Context, sessionID uuid.UUID, status int) {
+	pins, err := h.chatService.Pins(c.Request.Context(), sessionID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+		h.pinError(c, sessionID.String(), err)
+		return
+	}
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(status, gin.H{"pins": pins})
 		return
 	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	components.PinsPanel(sessionID.String(), pins).Render(c.Request.Context(), c.Writer)
+}
 
-	// Get session info before deleting (to get workspace path)
-	session, err := h.store.GetSessionByID(c.Request.Context(), sessionID)
+func (h *ChatHandler) pinError(c *gin.Context, sessionID string, err error) {
+	switch {
+	case errors.Is(err, services.ErrMemoryDisabled):
+		problem.Write(c, http.StatusServiceUnavailable, problem.FeatureDisabled, "Session memory is not enabled")
+	case errors.Is(err, services.ErrEmptyPin):
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "Pinned fact cannot be empty")
+	case errors.Is(err, services.ErrTooManyPins):
+		problem.Write(c, http.StatusConflict, problem.Conflict, fmt.Sprintf("A session can have at most %d pins", rag.MaxPins))
+	case errors.Is(err, services.ErrDuplicatePin):
+		problem.Write(c, http.StatusConflict, problem.Conflict, "This fact is already pinned")
+	case errors.Is(err, services.ErrUnknownPin):
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "Pin not found in this session")
+	default:
+		h.logger.Error("Failed to handle pins", zap.Error(err), zap.String("session_id", sessionID))
+		writeInternalError(c, err, "Failed to handle pins")
+	}
+}
+
+// WorkspaceFiles lists the files in the session's workspace: JSON for API clients,
+// otherwise the sidebar's file panel.
+func (h *ChatHandler) WorkspaceFiles(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	h.renderWorkspaceFiles(c, sessionID, "")
+}
+
+// DownloadWorkspaceFile sends one of the session's workspace files as an attachment.
+func (h *ChatHandler) DownloadWorkspaceFile(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	name := c.Param("filename")
+	fullPath, err := h.chatService.WorkspaceFile(sessionID, name)
 	if err != nil {
-		h.logger.Error("Failed to get session for deletion", zap.Error(err), zap.String("session_id", sessionIDStr))
-		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		h.workspaceFileError(c, sessionID.String(), err)
 		return
 	}
+	c.FileAttachment(fullPath, name)
+}
 
-	if deleted, err := h.store.DeleteRAGDocumentsBySession(c.Request.Context(), sessionID); err != nil {
-		h.logger.Warn("Failed to delete RAG documents for session",
-			zap.Error(err),
-			zap.String("session_id", sessionIDStr))
-	} else if deleted > 0 {
-		h.logger.Debug("Deleted RAG documents for session",
-			zap.String("session_id", sessionIDStr),
-			zap.Int64("documents_deleted", deleted))
+// RenameWorkspaceFile renames a workspace file to the "name" field.
+func (h *ChatHandler) RenameWorkspaceFile(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+
+	var req struct {
+		Name string `json:"name" form:"name"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid request")
+		return
+	}
+	if req.Name == "" {
+		// The file panel's Rename button asks for the name with hx-prompt
+		req.Name = c.GetHeader("HX-Prompt")
 	}
 
-	// Delete from database (this cascades to messages)
-	if err := h.store.DeleteSession(c.Request.Context(), sessionID); err != nil {
-		h.logger.Error("Failed to delete session from database", zap.Error(err), zap.String("session_id", sessionIDStr))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete session"})
+	newName, err := h.chatService.RenameWorkspaceFile(c.Request.Context(), sessionID, c.Param("filename"), req.Name)
+	if err != nil {
+		h.workspaceFileError(c, sessionID.String(), err)
+		return
+	}
+	h.renderWorkspaceFiles(c, sessionID, fmt.Sprintf("Renamed to %s.", newName))
+}
+
+// DeleteWorkspaceFile deletes a workspace file.
+func (h *ChatHandler) DeleteWorkspaceFile(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	name := c.Param("filename")
+	if err := h.chatService.DeleteWorkspaceFile(c.Request.Context(), sessionID, name); err != nil {
+		h.workspaceFileError(c, sessionID.String(), err)
 		return
 	}
+	h.renderWorkspaceFiles(c, sessionID, fmt.Sprintf("Deleted %s.", name))
+}
 
-	// Cleanup Python executor session binding
-	h.chatService.CleanupSession(sessionIDStr)
+func (h *ChatHandler) renderWorkspaceFiles(c *gin.Context, sessionID uuid.UUID, message string) {
+	files, err := h.chatService.WorkspaceFiles(sessionID)
+	if err != nil {
+		h.workspaceFileError(c, sessionID.String(), err)
+		return
+	}
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, gin.H{"files": files})
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	components.WorkspaceFilesPanel(sessionID.String(), files, message).Render(c.Request.Context(), c.Writer)
+}
 
-	// Delete workspace directory
-	workspaceDir := session.WorkspacePath
-	if workspaceDir != "" {
-		if err := os.RemoveAll(workspaceDir); err != nil {
-			h.logger.Warn("Failed to delete workspace directory", zap.Error(err), zap.String("path", workspaceDir))
-		} else {
-			h.logger.Info("Workspace directory deleted", zap.String("path", workspaceDir))
-		}
+func (h *ChatHandler) workspaceFileError(c *gin.Context, sessionID string, err error) {
+	switch {
+	case errors.Is(err, services.ErrRunInProgress):
+		problem.Write(c, http.StatusConflict, problem.RunInProgress, "Wait for the agent to finish before changing workspace files")
+	case errors.Is(err, services.ErrUnknownFile):
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "File not found in this workspace")
+	case errors.Is(err, services.ErrInvalidFilename):
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "The new name must be a plain file name with the same extension")
+	case errors.Is(err, services.ErrFileExists):
+		problem.Write(c, http.StatusConflict, problem.Conflict, "A file with this name already exists")
+	default:
+		h.logger.Error("Failed to handle workspace files", zap.Error(err), zap.String("session_id", sessionID))
+		writeInternalError(c, err, "Failed to handle workspace files")
 	}
+}
 
-	h.logger.Info("Session deleted successfully", zap.String("session_id", sessionIDStr))
+// StartJob runs the agent for a saved user message ("user_message_id") as a background
+// job and returns the job. Its output is followed at JobStream.
+func (h *ChatHandler) StartJob(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
 
-	// Check if this was the current session
-	currentSessionID, exists := c.Get("sessionID")
-	if exists && currentSessionID.(uuid.UUID) == sessionID {
-		// Deleting the current session - clear cookie and redirect to create new session
-		c.SetCookie(middleware.SessionCookieName, "", -1, "/", "", false, true)
+	var req struct {
+		UserMessageID string `json:"user_message_id" form:"user_message_id"`
+	}
+	if err := c.ShouldBind(&req); err != nil || req.UserMessageID == "" {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "user_message_id is required")
+		return
 	}
 
-	// Always redirect to home page to refresh the UI
-	// The HX-Redirect header tells HTMX to perform a full page redirect
-	c.Header("HX-Redirect", "/")
-	c.Status(http.StatusOK)
+	job, err := h.startJob(c.Request.Context(), sessionID, req.UserMessageID)
+	if err != nil {
+		h.jobError(c, sessionID.String(), err)
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+// startJob runs streamTurn for the message as a background job.
+func (h *ChatHandler) startJob(ctx context.Context, sessionID uuid.UUID, userMessageID string) (types.AnalysisJob, error) {
+	return h.jobService.Start(ctx, sessionID, userMessageID, func(ctx context.Context, conn services.StreamConn) {
+		h.streamTurn(ctx, conn, sessionID, userMessageID)
+	})
+}
+
+// Jobs lists the session's recent background jobs.
+func (h *ChatHandler) Jobs(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	jobs, err := h.jobService.Jobs(c.Request.Context(), sessionID)
+	if err != nil {
+		h.jobError(c, sessionID.String(), err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// Job returns a background job's status.
+func (h *ChatHandler) Job(c *gin.Context) {
+	sessionID, jobID, ok := h.jobParams(c)
+	if !ok {
+		return
+	}
+	job, err := h.jobService.Job(c.Request.Context(), sessionID, jobID)
+	if err != nil {
+		h.jobError(c, sessionID.String(), err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// JobStream streams a background job's events over SSE: everything so far, then new
+// events until the job finishes. Closing the stream leaves the job running.
+func (h *ChatHandler) JobStream(c *gin.Context) {
+	sessionID, jobID, ok := h.jobParams(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	if _, err := h.jobService.Job(ctx, sessionID, jobID); err != nil {
+		h.jobError(c, sessionID.String(), err)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	conn := h.streamService.NewSSEConn(ctx, c.Writer)
+	defer conn.Close()
+	metrics.StreamConnections.Inc("sse")
+	defer metrics.StreamConnections.Dec("sse")
+
+	if err := h.jobService.Follow(ctx, sessionID, jobID, conn); err != nil {
+		h.logger.Warn("Failed to stream analysis job", zap.Error(err), zap.String("job_id", jobID.String()))
+		conn.Write(services.ErrorEvent(http.StatusInternalServerError, problem.Internal, "Failed to stream the job"))
+	}
+}
+
+func (h *ChatHandler) jobParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	sessionID := middleware.OwnedSessionID(c)
+	jobID, err := uuid.Parse(c.Param("jobID"))
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid job ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+	return sessionID, jobID, true
+}
+
+func (h *ChatHandler) jobError(c *gin.Context, sessionID string, err error) {
+	switch {
+	case errors.Is(err, services.ErrRunInProgress):
+		problem.Write(c, http.StatusConflict, problem.RunInProgress, "The agent is already running in this session")
+	case errors.Is(err, services.ErrUnknownJob):
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "Job not found in this session")
+	default:
+		h.logger.Error("Failed to handle analysis jobs", zap.Error(err), zap.String("session_id", sessionID))
+		writeInternalError(c, err, "Failed to handle analysis jobs")
+	}
 }
 
 func (h *ChatHandler) Index(c *gin.Context) {
 	sessionID, exists := c.Get("sessionID")
 	if !exists {
 		h.logger.Error("Session ID not found in context")
-		c.String(http.StatusInternalServerError, "Session not found")
+		problem.Write(c, http.StatusNotFound, problem.InvalidSession, "Session not found")
 		return
 	}
 	sessionUUID := sessionID.(uuid.UUID)
@@ -154,32 +1420,31 @@ func (h *ChatHandler) Index(c *gin.Context) {
 
 	// Create workspace using service
 	if err := h.sessionService.CreateWorkspace(sessionUUID); err != nil {
-		c.String(http.StatusInternalServerError, "Could not create workspace")
+		problem.Write(c, http.StatusInternalServerError, problem.Internal, "Could not create workspace")
 		return
 	}
 
 	// Get sessions for sidebar using service
 	sessions := h.sessionService.GetSessionsForSidebar(c.Request.Context(), userUUIDPtr)
 
-	// Get messages - critical for page render
-	messages, err := h.store.GetMessagesBySession(c.Request.Context(), sessionUUID)
+	// Get the latest page of messages - critical for page render
+	page, err := h.messagePage(c.Request.Context(), sessionUUID, "")
 	if err != nil {
 		h.logger.Error("Failed to get messages for session",
 			zap.Error(err),
 			zap.String("session_id", sessionUUID.String()))
-		c.String(http.StatusInternalServerError, "Could not load conversation history")
+		problem.Write(c, http.StatusInternalServerError, problem.Internal, "Could not load conversation history")
 		return
 	}
 
-	messageGroups := groupMessages(messages)
-	component := pages.ChatPage(sessionUUID, sessions, messageGroups)
+	component := pages.ChatPage(sessionUUID, sessions, page, h.llmModelOptions(), "")
 	component.Render(c.Request.Context(), c.Writer)
 }
 
 func (h *ChatHandler) LoadSession(c *gin.Context) {
 	sessionID, err := uuid.Parse(c.Param("sessionID"))
 	if err != nil {
-		c.String(http.StatusBadRequest, "invalid session ID")
+		problem.Write(c, http.StatusBadRequest, problem.InvalidSession, "invalid session ID")
 		return
 	}
 
@@ -200,6 +1465,14 @@ func (h *ChatHandler) LoadSession(c *gin.Context) {
 		return
 	}
 
+	// A merged session's ID redirects to the session it was merged into
+	if shouldCreate {
+		if targetID, err := h.store.ResolveSessionRedirect(c.Request.Context(), sessionID); err == nil {
+			c.Redirect(http.StatusFound, fmt.Sprintf("/chat/%s", targetID.String()))
+			return
+		}
+	}
+
 	// If session not found, create new one
 	if shouldCreate {
 		h.logger.Info("Requested session not found, creating new one",
@@ -208,10 +1481,16 @@ func (h *ChatHandler) LoadSession(c *gin.Context) {
 		if createErr != nil {
 			h.logger.Error("Failed to create replacement session",
 				zap.Error(createErr))
-			c.String(http.StatusInternalServerError, "Could not create new session")
+			problem.Write(c, http.StatusInternalServerError, problem.Internal, "Could not create new session")
+			return
+		}
+		// The cookies now grant access to a different session: rotate them (and the CSRF token)
+		c.Set("sessionID", newSessionID)
+		if err := middleware.RotateCookies(c); err != nil {
+			h.logger.Error("Failed to rotate cookies for replacement session", zap.Error(err))
+			problem.Write(c, http.StatusInternalServerError, problem.Internal, "Could not create new session")
 			return
 		}
-		c.SetCookie(middleware.SessionCookieName, newSessionID.String(), middleware.CookieMaxAge, "/", "", false, true)
 		c.Redirect(http.StatusFound, fmt.Sprintf("/chat/%s", newSessionID.String()))
 		return
 	}
@@ -219,40 +1498,113 @@ func (h *ChatHandler) LoadSession(c *gin.Context) {
 	// Get sessions for sidebar using service
 	sessions := h.sessionService.GetSessionsForSidebar(c.Request.Context(), userUUIDPtr)
 
-	// Get messages - critical for page render
-	messages, err := h.store.GetMessagesBySession(c.Request.Context(), sessionID)
+	// Get the latest page of messages - critical for page render
+	page, err := h.messagePage(c.Request.Context(), sessionID, "")
 	if err != nil {
 		h.logger.Error("Failed to get messages for session",
 			zap.Error(err),
 			zap.String("session_id", sessionID.String()))
-		c.String(http.StatusInternalServerError, "Could not load conversation history")
+		problem.Write(c, http.StatusInternalServerError, problem.Internal, "Could not load conversation history")
+		return
+	}
+
+	pages.ChatPage(sessionID, sessions, page, h.llmModelOptions(), session.LLMModel).Render(c.Request.Context(), c.Writer)
+}
+
+// OlderMessages renders the page of history before the message in the "before" query
+// parameter; the chat view requests it when the user scrolls to the top.
+func (h *ChatHandler) OlderMessages(c *gin.Context) {
+	sessionID := middleware.OwnedSessionID(c)
+	sessionIDStr := sessionID.String()
+	before := c.Query("before")
+	if _, err := uuid.Parse(before); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid cursor")
+		return
+	}
+
+	page, err := h.messagePage(c.Request.Context(), sessionID, before)
+	if err != nil {
+		h.logger.Error("Failed to get older messages for session",
+			zap.Error(err),
+			zap.String("session_id", sessionIDStr))
+		writeInternalError(c, err, "Could not load conversation history")
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	components.MessageHistoryPage(sessionIDStr, page).Render(c.Request.Context(), c.Writer)
+}
+
+// SearchMessages searches the messages of all the user's sessions for the "q" query
+// parameter: JSON for API clients, otherwise the sidebar's result list.
+func (h *ChatHandler) SearchMessages(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		problem.Write(c, http.StatusUnauthorized, problem.Unauthorized, "Sign in required")
+		return
+	}
+	query := strings.TrimSpace(c.Query("q"))
+
+	results, err := h.store.SearchMessages(c.Request.Context(), userID, query, messageSearchLimit)
+	if err != nil {
+		h.logger.Error("Failed to search messages",
+			zap.Error(err),
+			zap.String("user_id", userID.String()))
+		writeInternalError(c, err, "Could not search messages")
+		return
+	}
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.JSON(http.StatusOK, gin.H{"query": query, "results": results})
 		return
 	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	components.MessageSearchResults(query, results).Render(c.Request.Context(), c.Writer)
+}
 
-	messageGroups := groupMessages(messages)
-	pages.ChatPage(sessionID, sessions, messageGroups).Render(c.Request.Context(), c.Writer)
-	_ = session // Mark as used
+// messagePage loads CHAT_PAGE_TURNS user turns of history before the cursor message
+// ("" for the latest turns) and groups them for rendering.
+func (h *ChatHandler) messagePage(ctx context.Context, sessionID uuid.UUID, before string) (types.MessagePage, error) {
+	messages, hasMore, err := h.store.GetMessagesPageBySession(ctx, sessionID, before, h.cfg.ChatPageTurns)
+	if err != nil {
+		return types.MessagePage{}, err
+	}
+	h.chatService.AttachAnnotations(ctx, sessionID, messages)
+	page := types.MessagePage{Groups: groupMessages(messages)}
+	if hasMore && len(messages) > 0 {
+		page.Before = messages[0].ID
+	}
+	return page, nil
 }
 
 func (h *ChatHandler) SendMessage(c *gin.Context) {
 	var req ChatRequest
 	if err := c.ShouldBind(&req); err != nil {
 		h.logger.Error("Failed to bind chat request", zap.Error(err))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "Invalid request")
 		return
 	}
 
 	if req.Message == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Message cannot be empty"})
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "Message cannot be empty")
 		return
 	}
 
 	sessionID, err := uuid.Parse(req.SessionID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		problem.Write(c, http.StatusBadRequest, problem.InvalidSession, "Invalid session ID")
 		return
 	}
 
+	// Screen the typed message (not uploaded file contents) before anything is stored
+	if verdict := h.chatService.ScreenMessage(c.Request.Context(), req.SessionID, req.Message); verdict.Flagged {
+		switch verdict.Action {
+		case services.ContentFilterBlock:
+			problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, services.ErrContentBlocked.Error())
+			return
+		case services.ContentFilterWarn:
+			c.Header("HX-Trigger-After-Swap", `{"contentFilterWarning": "The content filter flagged this message."}`)
+		}
+	}
+
 	// Handle potential file upload using upload service
 	var displayMessage string // Track what to display to the user
 	file, err := c.FormFile("file")
@@ -274,10 +1626,21 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 				zap.Error(err),
 				zap.String("filename", file.Filename),
 				zap.String("session_id", req.SessionID))
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			if errors.Is(err, services.ErrScannerUnavailable) {
+				problem.Write(c, http.StatusServiceUnavailable, problem.ScannerUnavailable, err.Error())
+				return
+			}
+			problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, err.Error())
 			return
 		}
 
+		// Profile datasets up front so the agent's first turn starts from their shape
+		if uploadResult.FileType == "csv" && h.cfg.DatasetProfileEnabled {
+			profileCtx, cancel := context.WithTimeout(c.Request.Context(), h.cfg.DatasetProfileTimeout)
+			h.chatService.ProfileUpload(profileCtx, sessionID, uploadResult)
+			cancel()
+		}
+
 		// Use the formatted messages from upload service
 		req.Message = uploadResult.ContentMessage
 		displayMessage = uploadResult.DisplayMessage
@@ -297,7 +1660,7 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 		h.logger.Error("Failed to save user message",
 			zap.Error(err),
 			zap.String("session_id", req.SessionID))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save message"})
+		writeInternalError(c, err, "Could not save message")
 		return
 	}
 
@@ -305,7 +1668,18 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 
 	// This is the crucial change. When a new message is sent, we now render a component
 	// that includes the SSE loader. This ensures only new messages trigger the agent.
-	component := components.UserMessageWithLoader(userMessage)
+	// The loader carries this request's trace context, so the run joins its trace.
+	tracing.SetAttributes(c.Request.Context(), tracing.Session(req.SessionID))
+	var jobID string
+	if req.Background {
+		job, err := h.startJob(c.Request.Context(), sessionID, userMessage.ID)
+		if err != nil {
+			h.jobError(c, req.SessionID, err)
+			return
+		}
+		jobID = job.ID.String()
+	}
+	component := components.UserMessageWithLoader(userMessage, tracing.TraceParent(c.Request.Context()), jobID)
 	c.Header("Content-Type", "text/html")
 	component.Render(c.Request.Context(), c.Writer)
 }
@@ -313,38 +1687,31 @@ func (h *ChatHandler) SendMessage(c *gin.Context) {
 func (h *ChatHandler) UploadFile(c *gin.Context) {
 	sessionIDStr := c.PostForm("session_id")
 	if sessionIDStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Session ID is required"})
+		problem.Write(c, http.StatusBadRequest, problem.InvalidSession, "Session ID is required")
 		return
 	}
 
 	sessionID, err := uuid.Parse(sessionIDStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+		problem.Write(c, http.StatusBadRequest, problem.InvalidSession, "invalid session ID")
 		return
 	}
 
 	file, err := c.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File upload error"})
-		return
-	}
-
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	if ext != ".csv" && ext != ".xlsx" && ext != ".xls" && ext != ".pdf" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file type. Please upload CSV, Excel, or PDF files."})
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "File upload error")
 		return
 	}
 
-	// Limit PDF size to 10MB
-	if ext == ".pdf" && file.Size > 10*1024*1024 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "PDF file too large. Maximum size is 10MB."})
+	if _, _, err := h.uploadService.ValidateFile(file); err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, err.Error())
 		return
 	}
 
 	workspaceDir := filepath.Join("workspaces", sessionID.String())
 	dst := filepath.Join(workspaceDir, file.Filename)
 	if err := c.SaveUploadedFile(file, dst); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save file"})
+		problem.Write(c, http.StatusInternalServerError, problem.Internal, "Could not save file")
 		return
 	}
 
@@ -357,7 +1724,7 @@ func (h *ChatHandler) UploadFile(c *gin.Context) {
 
 	if err := h.store.CreateMessage(c.Request.Context(), systemMessage); err != nil {
 		h.logger.Error("Failed to save system message", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save message"})
+		writeInternalError(c, err, "Could not save message")
 		return
 	}
 
@@ -367,7 +1734,7 @@ func (h *ChatHandler) UploadFile(c *gin.Context) {
 func (h *ChatHandler) StopAgent(c *gin.Context) {
 	sessionIDStr := c.Query("session_id")
 	if sessionIDStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Session ID required"})
+		problem.Write(c, http.StatusBadRequest, problem.InvalidSession, "Session ID required")
 		return
 	}
 
@@ -377,32 +1744,34 @@ func (h *ChatHandler) StopAgent(c *gin.Context) {
 }
 
 // Status returns whether the session currently has an active agent run,
-// and if so, the user message ID that initiated it (to allow SSE reattach).
+// and if so, the user message ID that initiated it (to allow SSE reattach)
+// and the background job running it, if any.
 func (h *ChatHandler) Status(c *gin.Context) {
 	sessionIDStr := c.Query("session_id")
 	if sessionIDStr == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Session ID required"})
+		problem.Write(c, http.StatusBadRequest, problem.InvalidSession, "Session ID required")
 		return
 	}
 
 	running, userMsgID := h.chatService.GetActiveRun(sessionIDStr)
+	var jobID string
+	if sessionID, err := uuid.Parse(sessionIDStr); err == nil {
+		if job, ok := h.jobService.RunningJob(c.Request.Context(), sessionID); ok {
+			// Reattach to the job's stream rather than restarting the turn
+			running, userMsgID, jobID = true, job.UserMessageID, job.ID.String()
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"running":         running,
 		"user_message_id": userMsgID,
+		"job_id":          jobID,
 	})
 }
 
+// StreamResponse runs the agent for a user message and streams its output over SSE.
 func (h *ChatHandler) StreamResponse(c *gin.Context) {
-	sessionIDStr := c.Query("session_id")
-	userMessageID := c.Query("user_message_id")
-
-	if sessionIDStr == "" || userMessageID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Session ID and user message ID required"})
-		return
-	}
-	sessionID, err := uuid.Parse(sessionIDStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+	sessionID, userMessageID, ok := streamParams(c)
+	if !ok {
 		return
 	}
 
@@ -411,17 +1780,75 @@ func (h *ChatHandler) StreamResponse(c *gin.Context) {
 	c.Header("Connection", "keep-alive")
 	c.Header("Access-Control-Allow-Origin", "*")
 
-	// Use a single mutex for all SSE writes in this request
-	var mu sync.Mutex
-
 	ctx := c.Request.Context()
 
-	// Use the service layer method
-	h.streamService.WriteSSEData(ctx, c.Writer, services.StreamData{Type: "connection_established"}, &mu)
+	// All SSE writes in this request go through one connection, which serializes them,
+	// sends heartbeats, and stops writing once the client is gone
+	conn := h.streamService.NewSSEConn(ctx, c.Writer)
+	defer conn.Close()
+	metrics.StreamConnections.Inc("sse")
+	defer metrics.StreamConnections.Dec("sse")
+
+	h.streamTurn(ctx, conn, sessionID, userMessageID)
+}
+
+// StreamResponseWS is StreamResponse over a WebSocket: the same StreamData frames, one
+// JSON text message each. Clients try it first and fall back to SSE when the upgrade
+// fails (e.g. a proxy that does not pass WebSockets).
+func (h *ChatHandler) StreamResponseWS(c *gin.Context) {
+	if !h.cfg.StreamWebSocketEnabled {
+		problem.Write(c, http.StatusNotFound, problem.FeatureDisabled, "The WebSocket transport is disabled")
+		return
+	}
+	sessionID, userMessageID, ok := streamParams(c)
+	if !ok {
+		return
+	}
+
+	conn, err := h.streamService.UpgradeWebSocket(c.Writer, c.Request)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrWebSocketOrigin):
+			problem.Write(c, http.StatusForbidden, problem.InvalidRequest, err.Error())
+		case errors.Is(err, services.ErrNotWebSocket):
+			problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, err.Error())
+		default:
+			h.logger.Warn("WebSocket upgrade failed", zap.Error(err), zap.String("session_id", sessionID.String()))
+		}
+		return
+	}
+	defer conn.Close()
+	metrics.StreamConnections.Inc("websocket")
+	defer metrics.StreamConnections.Dec("websocket")
+
+	h.streamTurn(c.Request.Context(), conn, sessionID, userMessageID)
+}
+
+// streamParams reads the session and user message of a stream request, writing the
+// problem response when they are missing or invalid.
+func streamParams(c *gin.Context) (uuid.UUID, string, bool) {
+	sessionIDStr := c.Query("session_id")
+	userMessageID := c.Query("user_message_id")
+
+	if sessionIDStr == "" || userMessageID == "" {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidSession, "Session ID and user message ID required")
+		return uuid.Nil, "", false
+	}
+	sessionID, err := uuid.Parse(sessionIDStr)
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidSession, "invalid session ID")
+		return uuid.Nil, "", false
+	}
+	return sessionID, userMessageID, true
+}
+
+// streamTurn runs the agent for a user message over conn, whichever transport it is.
+func (h *ChatHandler) streamTurn(ctx context.Context, conn services.StreamConn, sessionID uuid.UUID, userMessageID string) {
+	conn.Write(services.StreamData{Type: "connection_established"})
 
 	messages, err := h.store.GetMessagesBySession(ctx, sessionID)
 	if err != nil {
-		h.streamService.WriteSSEData(ctx, c.Writer, services.StreamData{Type: "error", Content: "Error fetching messages"}, &mu)
+		conn.Write(services.ErrorEvent(http.StatusInternalServerError, problem.Internal, "Error fetching messages"))
 		return
 	}
 
@@ -434,16 +1861,14 @@ func (h *ChatHandler) StreamResponse(c *gin.Context) {
 	}
 
 	if userMessage == nil {
-		h.streamService.WriteSSEData(ctx, c.Writer, services.StreamData{Type: "error", Content: "User message not found"}, &mu)
+		conn.Write(services.ErrorEvent(http.StatusNotFound, problem.NotFound, "User message not found"))
 		return
 	}
 
 	// Check if this is the first message in the session to trigger initialization and title generation
 	if len(messages) == 1 {
 		// Pass the service method to the goroutine
-		go h.chatService.GenerateAndSetTitle(context.Background(), sessionID, userMessage.Content, func(data services.StreamData) error {
-			return h.streamService.WriteSSEData(context.Background(), c.Writer, data, &mu)
-		})
+		go h.chatService.GenerateAndSetTitle(tracing.Detach(ctx), sessionID, userMessage.Content, conn.Write)
 
 		if err := h.chatService.InitializeSession(ctx, sessionID.String()); err != nil {
 			h.logger.Error("Failed to initialize session", zap.Error(err))
@@ -451,7 +1876,7 @@ func (h *ChatHandler) StreamResponse(c *gin.Context) {
 		// Re-fetch messages to include the initialization message for the agent's context
 		messages, err = h.store.GetMessagesBySession(ctx, sessionID)
 		if err != nil {
-			h.streamService.WriteSSEData(ctx, c.Writer, services.StreamData{Type: "error", Content: "Error fetching messages after initialization"}, &mu)
+			conn.Write(services.ErrorEvent(http.StatusInternalServerError, problem.Internal, "Error fetching messages after initialization"))
 			return
 		}
 	}
@@ -486,10 +1911,11 @@ func (h *ChatHandler) StreamResponse(c *gin.Context) {
 				h.logger.Warn("Failed to persist gating assistant message", zap.Error(err))
 			}
 			// Stream minimal response to replace loader and show message
-			h.streamService.WriteSSEData(ctx, c.Writer, services.StreamData{Type: "remove_loader", Content: "loading-" + userMessageID}, &mu)
-			h.streamService.WriteSSEData(ctx, c.Writer, services.StreamData{Type: "create_container", Content: assistantID}, &mu)
-			h.streamService.WriteSSEData(ctx, c.Writer, services.StreamData{Type: "chunk", Content: content}, &mu)
-			h.streamService.WriteSSEData(ctx, c.Writer, services.StreamData{Type: "end"}, &mu)
+			conn.Write(services.StreamData{Type: "remove_loader", Content: "loading-" + userMessageID})
+			conn.Write(services.StreamData{Type: "create_container", Content: assistantID})
+			conn.Write(services.StreamData{Type: "chunk", Content: content})
+			conn.Write(services.ErrorEvent(http.StatusConflict, problem.IngestionPending, "The uploaded PDF is still being indexed"))
+			conn.Write(services.StreamData{Type: "end"})
 			return
 		}
 	}
@@ -504,10 +1930,19 @@ func (h *ChatHandler) StreamResponse(c *gin.Context) {
 		}
 		filtered = append(filtered, m)
 	}
-	agentHistory := toAgentMessages(filtered)
+	// Bookmarked steps are pinned so the context packer keeps them when the history is trimmed
+	pinned := make(map[string]bool)
+	if bookmarks, err := h.store.GetStepBookmarks(ctx, sessionID); err != nil {
+		h.logger.Warn("Failed to load step bookmarks for pinning", zap.Error(err), zap.String("session_id", sessionID.String()))
+	} else {
+		for _, bookmark := range bookmarks {
+			pinned[bookmark.MessageID.String()] = true
+		}
+	}
+	agentHistory := toAgentMessages(filtered, pinned)
 
 	// Stream agent response using ChatService
-	h.chatService.StreamAgentResponse(ctx, c.Writer, userMessage.Content, userMessageID, sessionID.String(), agentHistory)
+	h.chatService.StreamAgentResponse(ctx, conn, userMessage.Content, userMessageID, sessionID.String(), agentHistory)
 }
 
 // isDocumentQuestion heuristically detects questions about PDF documents (not datasets).
@@ -519,7 +1954,8 @@ func isDocumentQuestion(s string) bool {
 
 	// If the message is an upload notice for a non-PDF, do not treat as document question
 	if strings.Contains(ls, "[📎 file uploaded:") {
-		if strings.Contains(ls, ".csv]") || strings.Contains(ls, ".xlsx]") || strings.Contains(ls, ".xls]") {
+		if strings.Contains(ls, ".csv]") || strings.Contains(ls, ".xlsx]") || strings.Contains(ls, ".xls]") ||
+			strings.Contains(ls, ".csv.gz]") || strings.Contains(ls, ".parquet]") {
 			return false
 		}
 	}
@@ -578,15 +2014,22 @@ func groupMessages(messages []types.ChatMessage) []types.MessageGroup {
 	return groups
 }
 
-func toAgentMessages(messages []types.ChatMessage) []types.AgentMessage {
+// toAgentMessages converts stored messages to agent history, marking the messages whose IDs
+// are in pinned with agent.PinnedMetadataKey.
+func toAgentMessages(messages []types.ChatMessage, pinned map[string]bool) []types.AgentMessage {
 	var agentMessages []types.AgentMessage
 	for _, message := range messages {
 		if message.Role == "user" || message.Role == "assistant" || message.Role == "tool" {
-			agentMessages = append(agentMessages, types.AgentMessage{
+			// A past run's [mN] citation tags would be misread against this run's memory
+			agentMessage := types.AgentMessage{
 				Role:        message.Role,
-				Content:     message.Content,
+				Content:     rag.StripMemoryCitations(message.Content),
 				ContentHash: message.ContentHash,
-			})
+			}
+			if pinned[message.ID] {
+				agentMessage.Metadata = map[string]string{agent.PinnedMetadataKey: "true"}
+			}
+			agentMessages = append(agentMessages, agentMessage)
 		}
 	}
 	return agentMessages
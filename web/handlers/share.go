@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"errors"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"stats-agent/database"
+	"stats-agent/web/middleware"
+	"stats-agent/web/problem"
+	"stats-agent/web/services"
+	"stats-agent/web/templates/components"
+	"stats-agent/web/templates/pages"
+	"stats-agent/web/types"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ShareHandler lets session owners manage read-only share links and serves the viewer
+// page those links open.
+type ShareHandler struct {
+	shares         *services.ShareService
+	sessionService *services.SessionService
+	store          database.Store
+	logger         *zap.Logger
+}
+
+// NewShareHandler creates a share handler.
+func NewShareHandler(shares *services.ShareService, sessionService *services.SessionService, store database.Store, logger *zap.Logger) *ShareHandler {
+	return &ShareHandler{shares: shares, sessionService: sessionService, store: store, logger: logger}
+}
+
+// SharePanel lists the session's active share links.
+func (h *ShareHandler) SharePanel(c *gin.Context) {
+	if !h.sharingEnabled(c) {
+		return
+	}
+	sessionID := middleware.OwnedSessionID(c)
+	h.renderSharePanel(c, sessionID, "")
+}
+
+// CreateShare creates a read-only link to the session.
+func (h *ShareHandler) CreateShare(c *gin.Context) {
+	if !h.sharingEnabled(c) {
+		return
+	}
+	sessionID := middleware.OwnedSessionID(c)
+	userID, _ := currentUserID(c)
+	if _, err := h.shares.CreateLink(c.Request.Context(), sessionID, userID); err != nil {
+		h.logger.Error("Failed to create share link", zap.Error(err), zap.String("session_id", sessionID.String()))
+		problem.Write(c, http.StatusInternalServerError, problem.Internal, "Failed to create share link")
+		return
+	}
+	h.renderSharePanel(c, sessionID, "Share link created.")
+}
+
+// RevokeShare revokes one of the session's share links. Pages already open keep their
+// content, but the link and its files stop working.
+func (h *ShareHandler) RevokeShare(c *gin.Context) {
+	if !h.sharingEnabled(c) {
+		return
+	}
+	sessionID := middleware.OwnedSessionID(c)
+	shareID, err := uuid.Parse(c.Param("shareID"))
+	if err != nil {
+		problem.Write(c, http.StatusBadRequest, problem.InvalidRequest, "invalid share ID")
+		return
+	}
+	if err := h.shares.RevokeLink(c.Request.Context(), sessionID, shareID); err != nil {
+		if errors.Is(err, services.ErrShareNotFound) {
+			problem.Write(c, http.StatusNotFound, problem.NotFound, "Share link not found")
+			return
+		}
+		h.logger.Error("Failed to revoke share link", zap.Error(err), zap.String("session_id", sessionID.String()))
+		problem.Write(c, http.StatusInternalServerError, problem.Internal, "Failed to revoke share link")
+		return
+	}
+	h.renderSharePanel(c, sessionID, "Share link revoked.")
+}
+
+// SharedSession renders the read-only transcript a share link grants: messages and plots,
+// without the compose box, annotations or session controls.
+func (h *ShareHandler) SharedSession(c *gin.Context) {
+	token := c.Param("token")
+	permission, ok := h.resolve(c, token)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	session, err := h.store.GetSessionByID(ctx, permission.SessionID)
+	if err != nil {
+		h.logger.Error("Failed to load shared session", zap.Error(err), zap.String("session_id", permission.SessionID.String()))
+		writeInternalError(c, err, "Failed to load shared session")
+		return
+	}
+	messages, err := h.store.GetMessagesBySession(ctx, permission.SessionID)
+	if err != nil {
+		h.logger.Error("Failed to load shared messages", zap.Error(err), zap.String("session_id", permission.SessionID.String()))
+		writeInternalError(c, err, "Failed to load shared session")
+		return
+	}
+
+	// Workspace files are served through the link, so viewers need no access to /workspaces
+	workspacePrefix := "/workspaces/" + permission.SessionID.String() + "/"
+	sharedPrefix := services.SharedPathPrefix + token + "/files/"
+	for i := range messages {
+		messages[i].Rendered = strings.ReplaceAll(messages[i].Rendered, workspacePrefix, sharedPrefix)
+	}
+
+	title := session.Title
+	if title == "" {
+		title = "Shared analysis"
+	}
+	c.Header("Referrer-Policy", "no-referrer")
+	pages.SharedSessionPage(title, groupMessages(messages)).Render(ctx, c.Writer)
+}
+
+// SharedFile serves a file from the shared session's workspace. Only the plots and
+// artifacts the transcript shows are served, never other files in the workspace.
+func (h *ShareHandler) SharedFile(c *gin.Context) {
+	permission, ok := h.resolve(c, c.Param("token"))
+	if !ok {
+		return
+	}
+	name := c.Param("filename")
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "File not found")
+		return
+	}
+	messages, err := h.store.GetMessagesBySession(c.Request.Context(), permission.SessionID)
+	if err != nil {
+		h.logger.Error("Failed to load shared messages", zap.Error(err), zap.String("session_id", permission.SessionID.String()))
+		writeInternalError(c, err, "Failed to load shared file")
+		return
+	}
+	if !referencedFiles(messages, permission.SessionID)[name] {
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "File not found")
+		return
+	}
+	fullPath := filepath.Join("workspaces", permission.SessionID.String(), name)
+	if info, err := os.Stat(fullPath); err != nil || !info.Mode().IsRegular() {
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "File not found")
+		return
+	}
+	c.File(fullPath)
+}
+
+// referencedFiles returns the names of the workspace files the rendered messages embed
+// or link: plots, interactive figures and artifact downloads.
+func referencedFiles(messages []types.ChatMessage, sessionID uuid.UUID) map[string]bool {
+	prefix := `="/workspaces/` + sessionID.String() + "/"
+	files := make(map[string]bool)
+	for _, message := range messages {
+		rest := message.Rendered
+		for {
+			start := strings.Index(rest, prefix)
+			if start < 0 {
+				break
+			}
+			rest = rest[start+len(prefix):]
+			end := strings.IndexByte(rest, '"')
+			if end < 0 {
+				break
+			}
+			name := html.UnescapeString(rest[:end])
+			if unescaped, err := url.PathUnescape(name); err == nil {
+				name = unescaped
+			}
+			files[name] = true
+			rest = rest[end:]
+		}
+	}
+	return files
+}
+
+// resolve returns the permission behind a share token, writing a 404 for disabled
+// sharing and for unknown, revoked or expired links.
+func (h *ShareHandler) resolve(c *gin.Context, token string) (types.SessionPermission, bool) {
+	if !h.shares.Enabled() {
+		problem.Write(c, http.StatusNotFound, problem.FeatureDisabled, "Session sharing is disabled")
+		return types.SessionPermission{}, false
+	}
+	permission, err := h.shares.Resolve(c.Request.Context(), token)
+	if errors.Is(err, services.ErrShareNotFound) {
+		problem.Write(c, http.StatusNotFound, problem.NotFound, "Share link not found or expired")
+		return types.SessionPermission{}, false
+	}
+	if err != nil {
+		h.logger.Error("Failed to resolve share link", zap.Error(err))
+		writeInternalError(c, err, "Failed to open share link")
+		return types.SessionPermission{}, false
+	}
+	return permission, true
+}
+
+// sharingEnabled answers 404 when share links are disabled. The routes that manage links
+// sit behind middleware.RequireSessionOwner: only owners manage a session's links.
+func (h *ShareHandler) sharingEnabled(c *gin.Context) bool {
+	if !h.shares.Enabled() {
+		problem.Write(c, http.StatusNotFound, problem.FeatureDisabled, "Session sharing is disabled")
+		return false
+	}
+	return true
+}
+
+func (h *ShareHandler) renderSharePanel(c *gin.Context, sessionID uuid.UUID, message string) {
+	links, err := h.shares.ListLinks(c.Request.Context(), sessionID)
+	if err != nil {
+		h.logger.Error("Failed to list share links", zap.Error(err), zap.String("session_id", sessionID.String()))
+		writeInternalError(c, err, "Failed to load share links")
+		return
+	}
+	components.SharePanel(sessionID.String(), links, message).Render(c.Request.Context(), c.Writer)
+}
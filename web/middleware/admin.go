@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"stats-agent/web/problem"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminPathPrefix is the path under which the admin API is served.
+const AdminPathPrefix = "/admin/"
+
+// MetricsPath is where Prometheus scrapes metrics.
+const MetricsPath = "/metrics"
+
+// AdminAuth requires the admin token as a bearer token. Without a configured token the
+// admin API does not exist and every request gets 404.
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if !bearerTokenValid(c, token) {
+			problem.Abort(c, http.StatusUnauthorized, problem.Unauthorized, "invalid admin token")
+			return
+		}
+		c.Next()
+	}
+}
+
+// MetricsAuth requires the metrics token as a bearer token when one is configured.
+func MetricsAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token != "" && !bearerTokenValid(c, token) {
+			problem.Abort(c, http.StatusUnauthorized, problem.Unauthorized, "invalid metrics token")
+			return
+		}
+		c.Next()
+	}
+}
+
+func bearerTokenValid(c *gin.Context, token string) bool {
+	provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(provided)) == 1
+}
+
+// ExceptAdmin skips handler for admin API and metrics requests. Both authenticate with a
+// bearer token rather than cookies, so they need neither browser sessions nor CSRF tokens.
+func ExceptAdmin(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, AdminPathPrefix) || c.Request.URL.Path == MetricsPath {
+			c.Next()
+			return
+		}
+		handler(c)
+	}
+}
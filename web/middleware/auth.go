@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"stats-agent/web/problem"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// LoginPath is the sign-in page anonymous users are sent to when AUTH_REQUIRED is set.
+const LoginPath = "/login"
+
+// OAuthStateCookieName holds the state of a pending OAuth sign-in. It is scoped to the
+// OAuth routes and expires after oauthStateMaxAge seconds.
+const OAuthStateCookieName = "stats_agent_oauth_state"
+
+const (
+	oauthStatePath      = "/auth/oauth/"
+	oauthStateMaxAge    = 10 * 60
+	userEmailContextKey = "userEmail"
+)
+
+// publicPathPrefixes stay reachable without an account: the sign-in page, the auth
+// endpoints, the assets the sign-in page loads, and read-only share links (which carry
+// their own signed grant).
+var publicPathPrefixes = []string{LoginPath + "/", "/auth/", "/static/", "/shared/"}
+
+// Authenticated reports whether the request's user signed in to an account rather than
+// browsing with an anonymous cookie.
+func Authenticated(c *gin.Context) bool {
+	return c.GetString(userEmailContextKey) != ""
+}
+
+// UserEmail returns the signed-in account's email, or "" for anonymous users.
+func UserEmail(c *gin.Context) string {
+	return c.GetString(userEmailContextKey)
+}
+
+// RequireAuth sends anonymous users to the sign-in page when required is set: page loads
+// are redirected, HTMX requests get an HX-Redirect, and other requests get a 401 problem.
+// It runs after SessionMiddleware, which resolves the user.
+func RequireAuth(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !required || Authenticated(c) || isPublicPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		if c.GetHeader("HX-Request") == "true" {
+			c.Header("HX-Redirect", LoginPath)
+		} else if c.Request.Method == http.MethodGet && strings.Contains(c.GetHeader("Accept"), "text/html") {
+			c.Redirect(http.StatusFound, LoginPath+"?next="+url.QueryEscape(c.Request.URL.RequestURI()))
+			c.Abort()
+			return
+		}
+		problem.Abort(c, http.StatusUnauthorized, problem.Unauthorized, "Sign in required")
+	}
+}
+
+func isPublicPath(path string) bool {
+	for _, prefix := range publicPathPrefixes {
+		if path == strings.TrimSuffix(prefix, "/") || strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignIn binds the browser to userID. The user cookie is re-issued for it, which rotates
+// the CSRF token too. The session cookie is kept; if the session belongs to another user,
+// SessionMiddleware starts a new one on the next request.
+func SignIn(c *gin.Context, userID uuid.UUID) error {
+	c.Set("userID", userID)
+	return RotateCookies(c)
+}
+
+// SignOut removes the user and session cookies. The next request starts over as a new
+// anonymous user.
+func SignOut(c *gin.Context) {
+	secure := false
+	if signer := signerFromContext(c); signer != nil {
+		secure = signer.secure
+	}
+	c.SetCookie(UserCookieName, "", -1, "/", "", secure, true)
+	c.SetCookie(SessionCookieName, "", -1, "/", "", secure, true)
+}
+
+// NewOAuthState starts an OAuth sign-in: it returns a random state for the provider's
+// consent URL and stores it, signed, in a short-lived cookie for the callback together
+// with the path to return to afterwards.
+func NewOAuthState(c *gin.Context, provider, next string) (string, error) {
+	signer := signerFromContext(c)
+	if signer == nil {
+		return "", errors.New("cookie signer not configured")
+	}
+	state, err := newNonce()
+	if err != nil {
+		return "", err
+	}
+	// Signed values cannot contain dots, so the path is base64url-encoded
+	value := provider + ":" + state + ":" + base64.RawURLEncoding.EncodeToString([]byte(next))
+	signed, _, err := signer.Sign(OAuthStateCookieName, value)
+	if err != nil {
+		return "", err
+	}
+	// Lax cookies are sent on the provider's top-level redirect back to the callback
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(OAuthStateCookieName, signed, oauthStateMaxAge, oauthStatePath, "", signer.secure, true)
+	return state, nil
+}
+
+// ConsumeOAuthState checks a callback's state against the cookie set by NewOAuthState and
+// clears the cookie, so each state is accepted once. Returns the path to return to.
+func ConsumeOAuthState(c *gin.Context, provider, state string) (string, bool) {
+	signer := signerFromContext(c)
+	cookie, err := c.Cookie(OAuthStateCookieName)
+	if signer == nil || err != nil || state == "" {
+		return "", false
+	}
+	c.SetCookie(OAuthStateCookieName, "", -1, oauthStatePath, "", signer.secure, true)
+
+	value, _, err := signer.Verify(OAuthStateCookieName, cookie)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.SplitN(value, ":", 3)
+	if len(parts) != 3 || subtle.ConstantTimeCompare([]byte(parts[0]+":"+parts[1]), []byte(provider+":"+state)) != 1 {
+		return "", false
+	}
+	next, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", false
+	}
+	return string(next), true
+}
@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"stats-agent/web/problem"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/argon2"
+)
+
+const CSRFCookieName = "stats_agent_csrf"
+const CSRFHeaderName = "X-CSRF-Token"
+const CSRFFormField = "csrf_token"
+
+const cookieSignerContextKey = "cookieSigner"
+
+// Argon2id parameters for deriving the signing key from the server secret.
+// The derivation runs once at startup, so the cost is not paid per request.
+const (
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2Salt    = "stats-agent/cookie-signing/v1"
+)
+
+var errInvalidSignedCookie = errors.New("invalid signed cookie")
+
+// CookieSigner signs cookie values with an HMAC key derived from the server secret.
+// Signed values have the form "<value>.<nonce>.<signature>"; the nonce changes on
+// every issue, so re-issuing a cookie rotates it and any CSRF token bound to it.
+type CookieSigner struct {
+	key    []byte
+	secure bool
+}
+
+// NewCookieSigner derives the signing key from secret with Argon2id. An empty secret
+// yields a random key, which invalidates all cookies on restart.
+func NewCookieSigner(secret string, secure bool) (*CookieSigner, error) {
+	material := []byte(secret)
+	if secret == "" {
+		material = make([]byte, 32)
+		if _, err := rand.Read(material); err != nil {
+			return nil, err
+		}
+	}
+	key := argon2.IDKey(material, []byte(argon2Salt), argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return &CookieSigner{key: key, secure: secure}, nil
+}
+
+func (s *CookieSigner) mac(parts ...string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(strings.Join(parts, "|")))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Sign returns the signed form of value for the named cookie and the nonce used.
+// The cookie name is part of the MAC so a user cookie cannot be replayed as a session cookie.
+func (s *CookieSigner) Sign(name, value string) (string, string, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return "", "", err
+	}
+	return value + "." + nonce + "." + s.mac(name, value, nonce), nonce, nil
+}
+
+// Verify checks a signed cookie value and returns the original value and its nonce.
+func (s *CookieSigner) Verify(name, signed string) (string, string, error) {
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", errInvalidSignedCookie
+	}
+	expected := s.mac(name, parts[0], parts[1])
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(parts[2])) != 1 {
+		return "", "", errInvalidSignedCookie
+	}
+	return parts[0], parts[1], nil
+}
+
+// CSRFToken derives the CSRF token bound to a user cookie nonce.
+func (s *CookieSigner) CSRFToken(userNonce string) string {
+	return s.mac("csrf", userNonce)
+}
+
+// setSigned issues a signed cookie and returns its nonce.
+func (s *CookieSigner) setSigned(c *gin.Context, name, value string) (string, error) {
+	signed, nonce, err := s.Sign(name, value)
+	if err != nil {
+		return "", err
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, signed, CookieMaxAge, "/", "", s.secure, true)
+	return nonce, nil
+}
+
+// setCSRF issues the CSRF cookie for a user nonce. It is readable by scripts so the
+// client can echo it back in the X-CSRF-Token header (double-submit).
+func (s *CookieSigner) setCSRF(c *gin.Context, userNonce string) string {
+	token := s.CSRFToken(userNonce)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(CSRFCookieName, token, CookieMaxAge, "/", "", s.secure, false)
+	c.Set("csrfToken", token)
+	return token
+}
+
+func signerFromContext(c *gin.Context) *CookieSigner {
+	v, ok := c.Get(cookieSignerContextKey)
+	if !ok {
+		return nil
+	}
+	signer, _ := v.(*CookieSigner)
+	return signer
+}
+
+// SetSessionCookie issues a signed session cookie for sessionID.
+func SetSessionCookie(c *gin.Context, sessionID uuid.UUID) error {
+	signer := signerFromContext(c)
+	if signer == nil {
+		return errors.New("cookie signer not configured")
+	}
+	_, err := signer.setSigned(c, SessionCookieName, sessionID.String())
+	return err
+}
+
+// ClearSessionCookie removes the session cookie so the next request starts a new session.
+func ClearSessionCookie(c *gin.Context) {
+	secure := false
+	if signer := signerFromContext(c); signer != nil {
+		secure = signer.secure
+	}
+	c.SetCookie(SessionCookieName, "", -1, "/", "", secure, true)
+}
+
+// RotateCookies re-issues the user and session cookies with fresh nonces and a new
+// CSRF token. Call it whenever the privileges attached to the cookies change.
+func RotateCookies(c *gin.Context) error {
+	signer := signerFromContext(c)
+	if signer == nil {
+		return errors.New("cookie signer not configured")
+	}
+	userID, ok := c.Get("userID")
+	if !ok {
+		return errors.New("no user in context")
+	}
+	userNonce, err := signer.setSigned(c, UserCookieName, userID.(uuid.UUID).String())
+	if err != nil {
+		return err
+	}
+	if sessionID, ok := c.Get("sessionID"); ok {
+		if _, err := signer.setSigned(c, SessionCookieName, sessionID.(uuid.UUID).String()); err != nil {
+			return err
+		}
+	}
+	signer.setCSRF(c, userNonce)
+	return nil
+}
+
+// CSRFMiddleware rejects state-changing requests whose X-CSRF-Token header (or
+// csrf_token form field) does not match the token bound to the user cookie.
+func CSRFMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		expected := c.GetString("csrfToken")
+		provided := c.GetHeader(CSRFHeaderName)
+		if provided == "" {
+			provided = c.PostForm(CSRFFormField)
+		}
+		if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(provided)) != 1 {
+			problem.Abort(c, http.StatusForbidden, problem.CSRFInvalid, "Invalid or missing CSRF token")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"stats-agent/web/problem"
+	"stats-agent/web/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ownedSessionContextKey holds the path's session ID once RequireSessionOwner checked it.
+const ownedSessionContextKey = "ownedSessionID"
+
+// RequireSessionOwner guards the routes with a :sessionID path parameter: the session must
+// exist and belong to the current user. Sessions of other users get the same 404 as
+// missing ones, so session IDs cannot be probed. Handlers read the checked ID with
+// OwnedSessionID.
+func RequireSessionOwner(sessions *services.SessionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID, err := uuid.Parse(c.Param("sessionID"))
+		if err != nil {
+			problem.Abort(c, http.StatusBadRequest, problem.InvalidSession, "invalid session ID")
+			return
+		}
+		value, ok := c.Get("userID")
+		userID, isUUID := value.(uuid.UUID)
+		if !ok || !isUUID {
+			problem.Abort(c, http.StatusUnauthorized, problem.InvalidSession, "No user session")
+			return
+		}
+		session, missing, err := sessions.ValidateAndGetSession(c.Request.Context(), sessionID, &userID)
+		if err != nil || missing || session == nil {
+			problem.Abort(c, http.StatusNotFound, problem.InvalidSession, "Session not found")
+			return
+		}
+		c.Set(ownedSessionContextKey, sessionID)
+		c.Next()
+	}
+}
+
+// OwnedSessionID returns the session ID RequireSessionOwner checked for this request.
+func OwnedSessionID(c *gin.Context) uuid.UUID {
+	sessionID, _ := c.Get(ownedSessionContextKey)
+	id, _ := sessionID.(uuid.UUID)
+	return id
+}
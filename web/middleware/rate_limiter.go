@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"net/http"
+	"stats-agent/web/problem"
 	"strconv"
 	"sync"
 	"time"
@@ -177,7 +178,7 @@ func RateLimitMiddleware(limiter *SessionRateLimiter, limitType string) gin.Hand
 		sessionIDValue, exists := c.Get("sessionID")
 		if !exists {
 			// Session middleware should run before this
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "session not initialized"})
+			problem.Abort(c, http.StatusInternalServerError, problem.InvalidSession, "session not initialized")
 			return
 		}
 
@@ -204,7 +205,7 @@ func RateLimitMiddleware(limiter *SessionRateLimiter, limitType string) gin.Hand
 			// For files, we don't expose remaining (too complex with hourly buckets)
 			remaining, limit = limiter.config.FilesPerHour, limiter.config.FilesPerHour
 		default:
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unknown limit type"})
+			problem.Abort(c, http.StatusInternalServerError, problem.Internal, "unknown limit type")
 			return
 		}
 
@@ -224,12 +225,11 @@ func RateLimitMiddleware(limiter *SessionRateLimiter, limitType string) gin.Hand
 			}
 
 			c.Header("Retry-After", "60") // Suggest retry after 60 seconds
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error":     "rate limit exceeded",
-				"limit":     limit,
-				"remaining": remaining,
-				"retry_after": 60,
-			})
+			c.Abort()
+			problem.Render(c, problem.New(http.StatusTooManyRequests, problem.RateLimited, "rate limit exceeded").
+				With("limit", limit).
+				With("remaining", remaining).
+				With("retry_after", 60))
 			return
 		}
 
@@ -2,8 +2,10 @@ package middleware
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 	"stats-agent/database"
+	"stats-agent/web/problem"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -14,44 +16,53 @@ const SessionCookieName = "stats_agent_session"
 const UserCookieName = "stats_agent_user"
 const CookieMaxAge = 30 * 24 * 60 * 60 // 30 days
 
-func SessionMiddleware(store *database.PostgresStore) gin.HandlerFunc {
+// SessionMiddleware resolves the user and session from signed cookies, creating
+// new ones when cookies are missing, tampered with, or stale, and exposes the
+// CSRF token for the user cookie. New users are anonymous until they register or
+// sign in (see SignIn); a session is only honored for the user that owns it.
+func SessionMiddleware(store database.Store, signer *CookieSigner) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get logger from context (set by server)
 		logger, _ := c.Get("logger")
 		zapLogger, _ := logger.(*zap.Logger)
+		c.Set(cookieSignerContextKey, signer)
 
 		// First, handle user authentication
 		userCookie, err := c.Cookie(UserCookieName)
 		var userID uuid.UUID
+		var userNonce string
+		var userEmail string // empty for anonymous users
 		createNewUser := false
 
 		if err == http.ErrNoCookie {
 			createNewUser = true
 		} else if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse user cookie"})
+			problem.Abort(c, http.StatusInternalServerError, problem.InvalidSession, "Failed to parse user cookie")
 			return
 		} else {
-			parsedUserID, parseErr := uuid.Parse(userCookie)
-			if parseErr != nil {
-				// Invalid UUID format in cookie - treat as corrupted and create new user
+			userValue, nonce, verifyErr := signer.Verify(UserCookieName, userCookie)
+			parsedUserID, parseErr := uuid.Parse(userValue)
+			if verifyErr != nil || parseErr != nil {
+				// Unsigned, tampered, or malformed cookie - never trust it, create new user
 				if zapLogger != nil {
-					zapLogger.Warn("Corrupted user UUID in cookie, creating new user",
-						zap.String("cookie_value", userCookie),
-						zap.Error(parseErr))
+					zapLogger.Warn("Invalid user cookie signature or UUID, creating new user",
+						zap.Error(errors.Join(verifyErr, parseErr)))
 				}
 				createNewUser = true
 			} else {
 				// Verify the user exists in the database
-				dbErr := store.GetUserByID(c.Request.Context(), parsedUserID)
+				account, dbErr := store.GetUserAccount(c.Request.Context(), parsedUserID)
 				if dbErr != nil {
 					if dbErr == sql.ErrNoRows {
 						createNewUser = true
 					} else {
-						c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify user"})
+						problem.Abort(c, http.StatusInternalServerError, problem.InvalidSession, "Failed to verify user")
 						return
 					}
 				} else {
 					userID = parsedUserID
+					userNonce = nonce
+					userEmail = account.Email
 				}
 			}
 		}
@@ -60,11 +71,16 @@ func SessionMiddleware(store *database.PostgresStore) gin.HandlerFunc {
 			var creationErr error
 			userID, creationErr = store.CreateUser(c.Request.Context())
 			if creationErr != nil {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+				problem.Abort(c, http.StatusInternalServerError, problem.Internal, "Failed to create user")
 				return
 			}
-			// Set the user cookie with a long expiration
-			c.SetCookie(UserCookieName, userID.String(), CookieMaxAge, "/", "", false, true)
+			// Set the signed user cookie with a long expiration
+			nonce, signErr := signer.setSigned(c, UserCookieName, userID.String())
+			if signErr != nil {
+				problem.Abort(c, http.StatusInternalServerError, problem.Internal, "Failed to issue user cookie")
+				return
+			}
+			userNonce = nonce
 		}
 
 		// Now handle session
@@ -75,21 +91,30 @@ func SessionMiddleware(store *database.PostgresStore) gin.HandlerFunc {
 		if err == http.ErrNoCookie {
 			createNewSession = true
 		} else if err != nil {
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse session cookie"})
+			problem.Abort(c, http.StatusInternalServerError, problem.InvalidSession, "Failed to parse session cookie")
 			return
 		} else {
-			parsedID, parseErr := uuid.Parse(sessionCookie)
-			if parseErr != nil {
-				// Invalid UUID format in cookie - treat as corrupted and create new session
+			sessionValue, _, verifyErr := signer.Verify(SessionCookieName, sessionCookie)
+			parsedID, parseErr := uuid.Parse(sessionValue)
+			if verifyErr != nil || parseErr != nil {
+				// Unsigned, tampered, or malformed cookie - never trust it, create new session
 				if zapLogger != nil {
-					zapLogger.Warn("Corrupted session UUID in cookie, creating new session",
-						zap.String("cookie_value", sessionCookie),
-						zap.Error(parseErr))
+					zapLogger.Warn("Invalid session cookie signature or UUID, creating new session",
+						zap.Error(errors.Join(verifyErr, parseErr)))
 				}
 				createNewSession = true
 			} else {
 				// Check if the session from the cookie exists in the database
 				session, dbErr := store.GetSessionByID(c.Request.Context(), parsedID)
+				redirected := false
+				if dbErr == sql.ErrNoRows {
+					// The cookie's session may have been merged into another one
+					if targetID, redirectErr := store.ResolveSessionRedirect(c.Request.Context(), parsedID); redirectErr == nil {
+						parsedID = targetID
+						session, dbErr = store.GetSessionByID(c.Request.Context(), targetID)
+						redirected = dbErr == nil
+					}
+				}
 				if dbErr != nil {
 					if dbErr == sql.ErrNoRows {
 						createNewSession = true
@@ -106,6 +131,12 @@ func SessionMiddleware(store *database.PostgresStore) gin.HandlerFunc {
 					// Verify session belongs to this user
 					if session.UserID != nil && *session.UserID == userID {
 						sessionID = parsedID
+						if redirected {
+							if _, signErr := signer.setSigned(c, SessionCookieName, sessionID.String()); signErr != nil {
+								problem.Abort(c, http.StatusInternalServerError, problem.Internal, "Failed to issue session cookie")
+								return
+							}
+						}
 					} else {
 						// Session exists but doesn't belong to this user, create new session
 						createNewSession = true
@@ -118,13 +149,24 @@ func SessionMiddleware(store *database.PostgresStore) gin.HandlerFunc {
 			var creationErr error
 			sessionID, creationErr = store.CreateSession(c.Request.Context(), &userID)
 			if creationErr != nil {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+				problem.Abort(c, http.StatusInternalServerError, problem.Internal, "Failed to create session")
 				return
 			}
-			c.SetCookie(SessionCookieName, sessionID.String(), CookieMaxAge, "/", "", false, true)
+			if _, signErr := signer.setSigned(c, SessionCookieName, sessionID.String()); signErr != nil {
+				problem.Abort(c, http.StatusInternalServerError, problem.Internal, "Failed to issue session cookie")
+				return
+			}
+		}
+
+		// CSRF token is bound to the user cookie nonce; re-issue the cookie if it drifted
+		if csrfCookie, _ := c.Cookie(CSRFCookieName); csrfCookie != signer.CSRFToken(userNonce) {
+			signer.setCSRF(c, userNonce)
+		} else {
+			c.Set("csrfToken", csrfCookie)
 		}
 
 		c.Set("userID", userID)
+		c.Set(userEmailContextKey, userEmail)
 		c.Set("sessionID", sessionID)
 		c.Next()
 	}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"stats-agent/tracing"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tracing starts a server span for each request when enabled, so everything the request
+// does (session lookup, the agent run it starts) nests under it. Static assets and
+// metrics scrapes are not traced.
+func Tracing(enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if !enabled || strings.HasPrefix(path, "/static/") || path == MetricsPath {
+			c.Next()
+			return
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracing.StartHTTP(c.Request, route)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		tracing.SetAttributes(ctx, tracing.AttrHTTPStatus.Int(status))
+		var err error
+		if status >= http.StatusInternalServerError {
+			err = fmt.Errorf("HTTP %d", status)
+		}
+		tracing.End(span, err)
+	}
+}
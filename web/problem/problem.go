@@ -0,0 +1,119 @@
+// Package problem writes RFC 7807 problem+json error responses. Every error carries a
+// machine-readable Code, so the client and API consumers can branch on the kind of
+// failure instead of parsing the English Detail.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem responses.
+const ContentType = "application/problem+json"
+
+// Code identifies the kind of failure. Codes are stable; details and titles are not.
+type Code string
+
+const (
+	InvalidRequest      Code = "invalid_request"
+	InvalidSession      Code = "invalid_session"
+	NotFound            Code = "not_found"
+	FeatureDisabled     Code = "feature_disabled"
+	RunInProgress       Code = "run_in_progress"
+	Conflict            Code = "conflict"
+	RateLimited         Code = "rate_limited"
+	IngestionPending    Code = "ingestion_pending"
+	ExecutorUnavailable Code = "executor_unavailable"
+	ScannerUnavailable  Code = "scanner_unavailable"
+	Unauthorized        Code = "unauthorized"
+	InvalidCredentials  Code = "invalid_credentials"
+	CSRFInvalid         Code = "csrf_invalid"
+	Internal            Code = "internal_error"
+)
+
+// Details is a problem+json body. Type is a relative URI derived from the code; Extensions
+// are extra members serialized next to the standard ones (e.g. retry_after).
+type Details struct {
+	Type       string         `json:"type"`
+	Title      string         `json:"title"`
+	Status     int            `json:"status"`
+	Detail     string         `json:"detail,omitempty"`
+	Instance   string         `json:"instance,omitempty"`
+	Code       Code           `json:"code"`
+	Extensions map[string]any `json:"-"`
+}
+
+// New builds a problem with the standard title for status.
+func New(status int, code Code, detail string) Details {
+	return Details{
+		Type:   "/problems/" + string(code),
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// With returns a copy of p with an extension member set.
+func (p Details) With(key string, value any) Details {
+	ext := make(map[string]any, len(p.Extensions)+1)
+	for k, v := range p.Extensions {
+		ext[k] = v
+	}
+	ext[key] = value
+	p.Extensions = ext
+	return p
+}
+
+// MarshalJSON flattens Extensions into the problem object. Standard members win over
+// extensions with the same name.
+func (p Details) MarshalJSON() ([]byte, error) {
+	type standard Details
+	base, err := json.Marshal(standard(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return base, err
+	}
+	merged := make(map[string]any, len(p.Extensions)+6)
+	for k, v := range p.Extensions {
+		merged[k] = v
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(base, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return json.Marshal(merged)
+}
+
+// JSON encodes p for an SSE error event.
+func (p Details) JSON() string {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return `{"type":"/problems/internal_error","title":"Internal Server Error","status":500,"code":"internal_error"}`
+	}
+	return string(payload)
+}
+
+// Write sends a problem response with the request path as its instance.
+func Write(c *gin.Context, status int, code Code, detail string) {
+	Render(c, New(status, code, detail))
+}
+
+// Abort is Write for middleware: it also stops the handler chain.
+func Abort(c *gin.Context, status int, code Code, detail string) {
+	c.Abort()
+	Write(c, status, code, detail)
+}
+
+// Render sends a prepared problem response.
+func Render(c *gin.Context, p Details) {
+	if p.Instance == "" && c.Request != nil {
+		p.Instance = c.Request.URL.Path
+	}
+	c.Header("Content-Type", ContentType)
+	c.JSON(p.Status, p)
+}
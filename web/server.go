@@ -7,6 +7,7 @@ import (
     "stats-agent/agent"
     "stats-agent/config"
     "stats-agent/database"
+    "stats-agent/metrics"
     "stats-agent/web/handlers"
     "stats-agent/web/middleware"
     "stats-agent/web/services"
@@ -16,6 +17,7 @@ import (
     "go.uber.org/zap"
     neturl "net/url"
     "strconv"
+    "strings"
 )
 
 type Server struct {
@@ -23,10 +25,11 @@ type Server struct {
 	agent  *agent.Agent
 	logger *zap.Logger
 	config *config.Config
-	store  *database.PostgresStore
+	store  database.Store
+	signer *middleware.CookieSigner
 }
 
-func NewServer(agent *agent.Agent, logger *zap.Logger, config *config.Config, store *database.PostgresStore) *Server {
+func NewServer(agent *agent.Agent, logger *zap.Logger, config *config.Config, store database.Store) *Server {
 	gin.SetMode(gin.ReleaseMode)
 
 	if err := os.MkdirAll("workspaces", 0755); err != nil {
@@ -40,9 +43,23 @@ func NewServer(agent *agent.Agent, logger *zap.Logger, config *config.Config, st
 		c.Set("logger", logger)
 		c.Next()
 	})
+	// With TRACING_ENABLED, every request gets a server span; runs started by a request nest under it
+	router.Use(middleware.Tracing(config.TracingEnabled))
 
-	// Apply the session middleware to all routes
-	router.Use(middleware.SessionMiddleware(store))
+	// Sign cookies with a key derived from the server secret
+	if config.SessionSecret == "" {
+		logger.Warn("SESSION_SECRET not set; using a random per-process key (cookies reset on restart)")
+	}
+	cookieSigner, err := middleware.NewCookieSigner(config.SessionSecret, config.CookieSecure)
+	if err != nil {
+		logger.Fatal("Failed to initialize cookie signer", zap.Error(err))
+	}
+
+	// Apply the session middleware to all routes, then require CSRF tokens on state-changing requests
+	router.Use(middleware.ExceptAdmin(middleware.SessionMiddleware(store, cookieSigner)))
+	router.Use(middleware.ExceptAdmin(middleware.CSRFMiddleware()))
+	// With AUTH_REQUIRED, anonymous users only reach the sign-in page
+	router.Use(middleware.ExceptAdmin(middleware.RequireAuth(config.AuthRequired)))
 
 	server := &Server{
 		router: router,
@@ -50,6 +67,7 @@ func NewServer(agent *agent.Agent, logger *zap.Logger, config *config.Config, st
 		logger: logger,
 		config: config,
 		store:  store,
+		signer: cookieSigner,
 	}
 
 	server.setupRoutes()
@@ -63,7 +81,7 @@ func (s *Server) setupRoutes() {
 	// Initialize services
 	fileService := services.NewFileService(s.store, s.logger)
 	messageService := services.NewMessageService(s.store, s.logger)
-	streamService := services.NewStreamService(s.logger)
+	streamService := services.NewStreamService(s.logger, s.config)
     pdfConfig := &services.PDFConfig{
         TokenThreshold:           s.config.PDFTokenThreshold,
         FirstPagesPriority:       s.config.PDFFirstPagesPriority,
@@ -72,6 +90,8 @@ func (s *Server) setupRoutes() {
         HeaderFooterRepeatThreshold: s.config.PDFHeaderFooterRepeatThreshold,
         ReferencesTrimEnabled:       s.config.PDFReferencesTrimEnabled,
         ReferencesCitationDensity:   s.config.PDFReferencesCitationDensity,
+        QualityThreshold:            s.config.PDFQualityThreshold,
+        QualityRetry:                s.config.PDFQualityRetryEnabled,
     }
 
     // Initialize PDF extractor client (pdfplumber microservice)
@@ -98,12 +118,50 @@ func (s *Server) setupRoutes() {
 		}
 	}
 
-	pdfService := services.NewPDFService(s.logger, pdfConfig, pdfExtractorClient)
-	chatService := services.NewChatService(s.agent, s.store, s.logger, fileService, messageService, streamService)
+	// On-disk cache of extractor output; the extractor URL (with tuning params) is the
+	// settings fingerprint, so changing the extractor setup invalidates the cache
+	var pdfCache *services.PDFPageCache
+	if s.config.PDFCacheEnabled && pdfExtractorClient.IsEnabled() {
+		cache, err := services.NewPDFPageCache(s.config.PDFCacheDir, int64(s.config.PDFCacheMaxMB)*1024*1024, extractorURL, s.logger)
+		if err != nil {
+			s.logger.Warn("PDF cache unavailable, extracting without cache", zap.Error(err))
+		} else {
+			pdfCache = cache
+		}
+	}
+
+	pdfService := services.NewPDFService(s.logger, pdfConfig, pdfExtractorClient, pdfCache)
+	notificationService := services.NewNotificationService(s.config, s.store, s.logger)
+	figureService := services.NewFigureService(s.config, s.store, s.agent, s.logger)
+	var contentClassifier services.ContentClassifier
+	if s.config.ContentFilterClassifierURL != "" {
+		contentClassifier = services.NewHTTPContentClassifier(s.config.ContentFilterClassifierURL)
+	}
+	contentFilter := services.NewContentFilter(s.config, contentClassifier, s.logger)
+	var runRegistry *services.RunRegistry
+	if s.config.MultiReplicaEnabled {
+		runRegistry = services.NewRunRegistry(s.store, s.config, s.logger)
+	}
+	chatService := services.NewChatService(s.agent, s.store, s.logger, fileService, figureService, messageService, streamService, notificationService, contentFilter, runRegistry)
 
 	// Initialize new refactored services
 	sessionService := services.NewSessionService(s.store, s.logger)
-	uploadService := services.NewUploadService(s.store, pdfService, s.agent, s.logger)
+	var uploadScanner *services.UploadScanner
+	if s.config.UploadScanEnabled {
+		clamd, err := services.NewClamdScanner(s.config.ClamdAddress)
+		if err != nil {
+			s.logger.Fatal("Invalid clamd address", zap.Error(err))
+		}
+		uploadScanner = services.NewUploadScanner(s.config, clamd, s.logger)
+	}
+	uploadService := services.NewUploadService(s.store, pdfService, uploadScanner, s.agent, s.logger)
+	reportService := services.NewReportService(s.config, s.store, s.logger)
+	jobService := services.NewJobService(s.store, chatService, notificationService, s.config, s.logger)
+	// Jobs left running by a previous process cannot resume; mark them failed
+	jobService.FailInterrupted(context.Background())
+	if s.config.MultiReplicaEnabled {
+		go jobService.WatchInterrupted(context.Background())
+	}
 
 	// Initialize rate limiter
 	rateLimiterConfig := middleware.RateLimiterConfig{
@@ -115,16 +173,105 @@ func (s *Server) setupRoutes() {
 	rateLimiter := middleware.NewSessionRateLimiter(rateLimiterConfig, s.logger)
 
 	// Initialize handlers with services
-	chatHandler := handlers.NewChatHandler(chatService, streamService, sessionService, uploadService, s.agent, s.config, s.logger, s.store)
+	chatHandler := handlers.NewChatHandler(chatService, streamService, sessionService, uploadService, reportService, jobService, s.agent, s.config, s.logger, s.store)
 
 	s.router.GET("/", chatHandler.Index)
 	s.router.POST("/chat", middleware.RateLimitMiddleware(rateLimiter, "message"), chatHandler.SendMessage)
 	s.router.GET("/chat/new", chatHandler.NewChat)
 	s.router.GET("/chat/stream", chatHandler.StreamResponse)
+	s.router.GET("/chat/ws", chatHandler.StreamResponseWS)
 	s.router.POST("/chat/stop", chatHandler.StopAgent)
 	s.router.GET("/chat/status", chatHandler.Status)
 	s.router.GET("/chat/:sessionID", chatHandler.LoadSession)
-	s.router.DELETE("/chat/:sessionID", chatHandler.DeleteSession)
+
+	// Every other route on a session requires the caller to own it
+	owned := s.router.Group("", middleware.RequireSessionOwner(sessionService))
+	owned.DELETE("/chat/:sessionID", chatHandler.DeleteSession)
+	owned.POST("/chat/:sessionID/merge", chatHandler.MergeSession)
+	owned.POST("/chat/:sessionID/verbosity", chatHandler.SetVerbosity)
+	owned.POST("/chat/:sessionID/effect-size", chatHandler.SetEffectSizeCheck)
+	owned.POST("/chat/:sessionID/random-seed", chatHandler.SetRandomSeed)
+	owned.POST("/chat/:sessionID/model", chatHandler.SetLLMModel)
+	owned.POST("/chat/:sessionID/retrieval-policy", chatHandler.SetRetrievalPolicy)
+	owned.POST("/chat/:sessionID/rerun", chatHandler.RerunCode)
+	owned.POST("/chat/:sessionID/analyses", chatHandler.RunAnalysisSpec)
+	owned.GET("/chat/:sessionID/methods-pack", chatHandler.MethodsPack)
+	owned.GET("/chat/:sessionID/report", chatHandler.Report)
+	owned.GET("/chat/:sessionID/lineage", chatHandler.Lineage)
+	owned.POST("/chat/:sessionID/lineage/persist", chatHandler.PersistDataset)
+	owned.GET("/chat/:sessionID/rollups", chatHandler.Rollups)
+	owned.GET("/chat/:sessionID/sql", chatHandler.SQLConsole)
+	owned.POST("/chat/:sessionID/sql", chatHandler.QuerySQL)
+	owned.GET("/chat/:sessionID/messages", chatHandler.OlderMessages)
+	owned.GET("/chat/:sessionID/columns", chatHandler.ColumnTypes)
+	owned.POST("/chat/:sessionID/columns", chatHandler.SaveColumnTypes)
+	owned.POST("/chat/:sessionID/feedback", chatHandler.RetrievalFeedback)
+	owned.GET("/chat/:sessionID/steps", chatHandler.Steps)
+	owned.POST("/chat/:sessionID/bookmarks", chatHandler.BookmarkStep)
+	owned.DELETE("/chat/:sessionID/bookmarks/:bookmarkID", chatHandler.DeleteBookmark)
+	owned.POST("/chat/:sessionID/bookmarks/:bookmarkID/jump", chatHandler.JumpToBookmark)
+	owned.POST("/chat/:sessionID/annotations", chatHandler.AnnotateMessage)
+	owned.DELETE("/chat/:sessionID/annotations/:annotationID", chatHandler.DeleteAnnotation)
+	owned.DELETE("/chat/:sessionID/messages/:messageID", chatHandler.RetractMessage)
+	owned.GET("/chat/:sessionID/checkpoints", chatHandler.MemoryCheckpoints)
+	owned.POST("/chat/:sessionID/checkpoints", chatHandler.CreateMemoryCheckpoint)
+	owned.POST("/chat/:sessionID/checkpoints/scope", chatHandler.ScopeMemoryCheckpoint)
+	owned.GET("/chat/:sessionID/checkpoints/diff", chatHandler.DiffMemoryCheckpoints)
+	owned.DELETE("/chat/:sessionID/checkpoints/:checkpointID", chatHandler.DeleteMemoryCheckpoint)
+	owned.GET("/session/:sessionID/export/notebook", chatHandler.ExportNotebook)
+	owned.GET("/session/:sessionID/artifacts", chatHandler.Artifacts)
+	owned.GET("/session/:sessionID/pins", chatHandler.Pins)
+	owned.POST("/session/:sessionID/pins", chatHandler.AddPin)
+	owned.DELETE("/session/:sessionID/pins/:pinID", chatHandler.DeletePin)
+	owned.GET("/session/:sessionID/files", chatHandler.WorkspaceFiles)
+	owned.GET("/session/:sessionID/files/:filename", chatHandler.DownloadWorkspaceFile)
+	owned.POST("/session/:sessionID/files/:filename/rename", chatHandler.RenameWorkspaceFile)
+	owned.DELETE("/session/:sessionID/files/:filename", chatHandler.DeleteWorkspaceFile)
+	owned.POST("/session/:sessionID/jobs", middleware.RateLimitMiddleware(rateLimiter, "message"), chatHandler.StartJob)
+	owned.GET("/session/:sessionID/jobs", chatHandler.Jobs)
+	owned.GET("/session/:sessionID/jobs/:jobID", chatHandler.Job)
+	owned.GET("/session/:sessionID/jobs/:jobID/stream", chatHandler.JobStream)
+	s.router.GET("/search/messages", chatHandler.SearchMessages)
+
+	// Accounts: email/password and OAuth sign-in; anonymous sessions move into the account
+	authService := services.NewAuthService(s.store, s.config, s.logger)
+	authHandler := handlers.NewAuthHandler(authService, s.logger)
+	s.router.GET(middleware.LoginPath, authHandler.LoginPage)
+	s.router.POST("/auth/login", middleware.RateLimitMiddleware(rateLimiter, "message"), authHandler.Login)
+	s.router.POST("/auth/register", middleware.RateLimitMiddleware(rateLimiter, "message"), authHandler.Register)
+	s.router.POST("/auth/logout", authHandler.Logout)
+	s.router.GET("/auth/account", authHandler.Account)
+	s.router.GET("/auth/oauth/:provider", authHandler.OAuthStart)
+	s.router.GET("/auth/oauth/:provider/callback", authHandler.OAuthCallback)
+
+	// Read-only share links: owners manage them per session; links open without an account
+	shareService := services.NewShareService(s.store, s.signer, s.config, s.logger)
+	shareHandler := handlers.NewShareHandler(shareService, sessionService, s.store, s.logger)
+	owned.GET("/chat/:sessionID/shares", shareHandler.SharePanel)
+	owned.POST("/chat/:sessionID/shares", shareHandler.CreateShare)
+	owned.DELETE("/chat/:sessionID/shares/:shareID", shareHandler.RevokeShare)
+	s.router.GET("/shared/:token", shareHandler.SharedSession)
+	s.router.GET("/shared/:token/files/:filename", shareHandler.SharedFile)
+
+	// Bulk admin jobs, authenticated with ADMIN_TOKEN; interrupted jobs resume at startup
+	cleanupService := services.NewCleanupService(s.store, s.agent, s.logger)
+	adminJobs := services.NewAdminJobService(s.store, chatService, cleanupService, s.config, s.logger)
+	adminHandler := handlers.NewAdminHandler(adminJobs, s.logger)
+	admin := s.router.Group(strings.TrimSuffix(middleware.AdminPathPrefix, "/"), middleware.AdminAuth(s.config.AdminToken))
+	admin.POST("/jobs", adminHandler.SubmitJob)
+	admin.GET("/jobs", adminHandler.ListJobs)
+	admin.GET("/jobs/:jobID", adminHandler.GetJob)
+	admin.POST("/jobs/:jobID/cancel", adminHandler.CancelJob)
+	admin.GET("/jobs/:jobID/export", adminHandler.DownloadExport)
+	// Ingestion counters and experiment outcomes span every user's sessions
+	admin.GET("/rag/ingestion", chatHandler.IngestionStats)
+	admin.GET("/experiments/retrieval", chatHandler.RetrievalExperimentSummary)
+	go adminJobs.Resume(context.Background())
+
+	// Prometheus scrape endpoint, optionally behind METRICS_TOKEN
+	if s.config.MetricsEnabled {
+		s.router.GET(middleware.MetricsPath, middleware.MetricsAuth(s.config.MetricsToken), gin.WrapH(metrics.Handler()))
+	}
 }
 
 // buildPDFExtractorURL appends configured tuning params as query args.
@@ -152,6 +299,12 @@ func buildPDFExtractorURL(base string, cfg *config.Config) string {
 
 func trimFloat(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
 
+// AddDebugRoute registers a GET endpoint for test tooling, such as the failure injection
+// counters.
+func (s *Server) AddDebugRoute(path string, handler gin.HandlerFunc) {
+	s.router.GET(path, handler)
+}
+
 func (s *Server) Start(ctx context.Context, addr string) error {
 	s.logger.Info("Starting web server", zap.String("address", addr))
 
@@ -214,3 +367,87 @@ func runCleanup(cleanupService *services.CleanupService, cfg *config.Config, log
 			zap.Duration("retention_age", cfg.SessionRetentionAge))
 	}
 }
+
+// StartDatabaseMaintenance runs a background goroutine that periodically analyzes,
+// vacuums, and (after bulk ingests) reindexes the database
+func StartDatabaseMaintenance(cfg *config.Config, maintenanceService *services.MaintenanceService, logger *zap.Logger) {
+	if !cfg.DBMaintenanceEnabled {
+		logger.Info("Database maintenance disabled by configuration")
+		return
+	}
+
+	logger.Info("Starting database maintenance routine",
+		zap.Duration("interval", cfg.DBMaintenanceInterval),
+		zap.Float64("reindex_growth_ratio", cfg.DBReindexGrowthRatio))
+
+	ticker := time.NewTicker(cfg.DBMaintenanceInterval)
+	defer ticker.Stop()
+
+	// Run once on startup to establish the reindex baseline
+	runMaintenance(maintenanceService)
+
+	for range ticker.C {
+		runMaintenance(maintenanceService)
+	}
+}
+
+// runMaintenance executes a single maintenance cycle with timeout
+func runMaintenance(maintenanceService *services.MaintenanceService) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	maintenanceService.RunOnce(ctx)
+}
+
+// StartFactConsolidation runs a background goroutine that periodically merges
+// near-duplicate facts in recently active sessions
+func StartFactConsolidation(cfg *config.Config, maintenanceService *services.MaintenanceService, agent *agent.Agent, logger *zap.Logger) {
+	if !cfg.FactConsolidationEnabled {
+		logger.Info("Fact consolidation disabled by configuration")
+		return
+	}
+
+	logger.Info("Starting fact consolidation routine",
+		zap.Duration("interval", cfg.FactConsolidationInterval),
+		zap.Float64("similarity", cfg.FactConsolidationSimilarity))
+
+	ticker := time.NewTicker(cfg.FactConsolidationInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.FactConsolidationInterval)
+		// Cover sessions touched since the previous pass (with one interval of overlap)
+		maintenanceService.ConsolidateRecentFacts(ctx, agent.GetRAG(), 2*cfg.FactConsolidationInterval)
+		cancel()
+	}
+}
+
+// StartRAGArchival runs a background goroutine that periodically moves old conversation
+// chunks to the archived retrieval tier and expires archived chunks past their TTL
+func StartRAGArchival(cfg *config.Config, maintenanceService *services.MaintenanceService, logger *zap.Logger) {
+	if !cfg.RAGArchiveEnabled {
+		logger.Info("RAG archival disabled by configuration")
+		return
+	}
+
+	logger.Info("Starting RAG archival routine",
+		zap.Duration("interval", cfg.RAGArchiveInterval),
+		zap.Duration("archive_after", cfg.RAGArchiveAfter),
+		zap.Duration("ttl", cfg.RAGArchiveTTL))
+
+	ticker := time.NewTicker(cfg.RAGArchiveInterval)
+	defer ticker.Stop()
+
+	// Run once on startup so a long-stopped server catches up immediately
+	runRAGArchival(maintenanceService)
+
+	for range ticker.C {
+		runRAGArchival(maintenanceService)
+	}
+}
+
+// runRAGArchival executes a single archival pass with timeout
+func runRAGArchival(maintenanceService *services.MaintenanceService) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	maintenanceService.ArchiveOldDocuments(ctx)
+}
@@ -0,0 +1,431 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"stats-agent/config"
+	"stats-agent/database"
+	"stats-agent/rag"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// adminJobPageSize is how many sessions an admin job lists at a time.
+const adminJobPageSize = 50
+
+// ErrInvalidAdminJob is returned for an admin job with an unknown kind or missing parameters.
+var ErrInvalidAdminJob = errors.New("invalid admin job")
+
+// usageColumns is the header of the usage export.
+var usageColumns = []string{"session_id", "user_id", "mode", "created_at", "last_active", "active",
+	"user_messages", "assistant_messages", "tool_messages", "files", "rag_documents"}
+
+// AdminJobService runs bulk admin operations in the background, one job at a time. Each
+// job works through its sessions in ID order and saves its cursor after every session,
+// so jobs interrupted by a restart are resumed where they stopped.
+type AdminJobService struct {
+	store       database.Store
+	chatService *ChatService
+	cleanup     *CleanupService
+	cfg         *config.Config
+	logger      *zap.Logger
+
+	// slot serializes the jobs
+	slot chan struct{}
+
+	mu      sync.Mutex
+	cancels map[uuid.UUID]context.CancelFunc
+}
+
+// NewAdminJobService creates an admin job service.
+func NewAdminJobService(store database.Store, chatService *ChatService, cleanup *CleanupService, cfg *config.Config, logger *zap.Logger) *AdminJobService {
+	return &AdminJobService{
+		store:       store,
+		chatService: chatService,
+		cleanup:     cleanup,
+		cfg:         cfg,
+		logger:      logger,
+		slot:        make(chan struct{}, 1),
+		cancels:     make(map[uuid.UUID]context.CancelFunc),
+	}
+}
+
+// Resume restarts the jobs that were pending or running when the server stopped.
+func (s *AdminJobService) Resume(ctx context.Context) {
+	jobs, err := s.store.ListResumableAdminJobs(ctx)
+	if err != nil {
+		s.logger.Error("Failed to load admin jobs to resume", zap.Error(err))
+		return
+	}
+	for _, job := range jobs {
+		s.logger.Info("Resuming admin job",
+			zap.String("job_id", job.ID.String()),
+			zap.String("kind", job.Kind),
+			zap.Int("processed", job.Processed))
+		s.start(job)
+	}
+}
+
+// Submit validates and records a job, then starts it in the background.
+func (s *AdminJobService) Submit(ctx context.Context, kind string, params types.AdminJobParams) (types.AdminJob, error) {
+	switch kind {
+	case types.AdminJobArchiveSessions, types.AdminJobDeleteSessions:
+		if params.UserID == nil || params.Before == nil {
+			return types.AdminJob{}, fmt.Errorf("%w: %s needs user_id and before", ErrInvalidAdminJob, kind)
+		}
+	case types.AdminJobReingestSessions:
+		if len(params.SessionIDs) == 0 {
+			return types.AdminJob{}, fmt.Errorf("%w: %s needs session_ids", ErrInvalidAdminJob, kind)
+		}
+		if s.chatService.agent.GetRAG() == nil {
+			return types.AdminJob{}, ErrMemoryDisabled
+		}
+	case types.AdminJobRehashMessages, types.AdminJobExportUsage:
+	default:
+		return types.AdminJob{}, fmt.Errorf("%w: unknown kind %q", ErrInvalidAdminJob, kind)
+	}
+
+	job, err := s.store.CreateAdminJob(ctx, kind, params)
+	if err != nil {
+		return types.AdminJob{}, err
+	}
+	s.logger.Info("Submitted admin job", zap.String("job_id", job.ID.String()), zap.String("kind", kind))
+	s.start(job)
+	return job, nil
+}
+
+// Cancel stops a pending or running job after the session it is working on.
+func (s *AdminJobService) Cancel(ctx context.Context, jobID uuid.UUID) error {
+	if err := s.store.CancelAdminJob(ctx, jobID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if cancel, ok := s.cancels[jobID]; ok {
+		cancel()
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Job returns an admin job.
+func (s *AdminJobService) Job(ctx context.Context, jobID uuid.UUID) (types.AdminJob, error) {
+	return s.store.GetAdminJob(ctx, jobID)
+}
+
+// Jobs returns the most recent admin jobs, newest first.
+func (s *AdminJobService) Jobs(ctx context.Context) ([]types.AdminJob, error) {
+	return s.store.ListAdminJobs(ctx, 100)
+}
+
+// ExportPath returns the file written by a finished export job.
+func (s *AdminJobService) ExportPath(job types.AdminJob) (string, bool) {
+	if job.Kind != types.AdminJobExportUsage || job.Status != types.AdminJobDone || job.Result == "" {
+		return "", false
+	}
+	return job.Result, true
+}
+
+func (s *AdminJobService) start(job types.AdminJob) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.cancels[job.ID] = cancel
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			cancel()
+			s.mu.Lock()
+			delete(s.cancels, job.ID)
+			s.mu.Unlock()
+		}()
+		select {
+		case s.slot <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		defer func() { <-s.slot }()
+		s.run(ctx, job)
+	}()
+}
+
+// run works through the job's sessions from its cursor and records the outcome.
+func (s *AdminJobService) run(ctx context.Context, job types.AdminJob) {
+	job.Status = types.AdminJobRunning
+	if ok, err := s.store.SaveAdminJobProgress(ctx, job); err != nil || !ok {
+		return
+	}
+
+	var err error
+	if job.Kind == types.AdminJobExportUsage {
+		err = s.exportUsage(ctx, &job)
+	} else {
+		err = s.eachSession(ctx, &job, func(sessionID uuid.UUID) error {
+			return s.processSession(ctx, job, sessionID)
+		})
+	}
+
+	switch {
+	case ctx.Err() != nil || errors.Is(err, context.Canceled):
+		// Cancelled: the store already records it
+		return
+	case err != nil:
+		job.Status = types.AdminJobFailed
+		job.Error = err.Error()
+		s.logger.Error("Admin job failed", zap.String("job_id", job.ID.String()), zap.Error(err))
+	default:
+		job.Status = types.AdminJobDone
+		s.logger.Info("Admin job finished",
+			zap.String("job_id", job.ID.String()),
+			zap.String("kind", job.Kind),
+			zap.Int("processed", job.Processed),
+			zap.Int("failed", job.Failed))
+	}
+	if _, err := s.store.SaveAdminJobProgress(context.Background(), job); err != nil {
+		s.logger.Error("Failed to save admin job outcome", zap.String("job_id", job.ID.String()), zap.Error(err))
+	}
+}
+
+// eachSession calls fn for each of the job's sessions after its cursor, saving progress
+// after every session. A session that fails is counted and logged, and the job goes on.
+func (s *AdminJobService) eachSession(ctx context.Context, job *types.AdminJob, fn func(uuid.UUID) error) error {
+	cursor, _ := uuid.Parse(job.Cursor)
+	for {
+		ids, err := s.nextSessions(ctx, *job, cursor)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err := fn(id); err != nil {
+				job.Failed++
+				s.logger.Warn("Admin job failed on session",
+					zap.String("job_id", job.ID.String()),
+					zap.String("session_id", id.String()),
+					zap.Error(err))
+			} else {
+				job.Processed++
+			}
+			cursor = id
+			job.Cursor = id.String()
+			ok, err := s.store.SaveAdminJobProgress(ctx, *job)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return context.Canceled
+			}
+		}
+	}
+}
+
+// nextSessions returns the job's next page of sessions after cursor, in ID order.
+func (s *AdminJobService) nextSessions(ctx context.Context, job types.AdminJob, cursor uuid.UUID) ([]uuid.UUID, error) {
+	if len(job.Params.SessionIDs) > 0 {
+		ids := append([]uuid.UUID(nil), job.Params.SessionIDs...)
+		sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+		start := sort.Search(len(ids), func(i int) bool { return ids[i].String() > cursor.String() })
+		end := min(start+adminJobPageSize, len(ids))
+		return ids[start:end], nil
+	}
+	switch job.Kind {
+	case types.AdminJobArchiveSessions, types.AdminJobDeleteSessions:
+		return s.store.ListSessionIDsAfter(ctx, cursor, job.Params.UserID, job.Params.Before, adminJobPageSize)
+	default:
+		return s.store.ListSessionIDsAfter(ctx, cursor, nil, nil, adminJobPageSize)
+	}
+}
+
+func (s *AdminJobService) processSession(ctx context.Context, job types.AdminJob, sessionID uuid.UUID) error {
+	id := sessionID.String()
+	switch job.Kind {
+	case types.AdminJobArchiveSessions:
+		return s.store.ArchiveSession(ctx, sessionID)
+	case types.AdminJobDeleteSessions:
+		if running, _ := s.chatService.GetActiveRun(id); running {
+			return ErrRunInProgress
+		}
+		return s.cleanup.DeleteSessionAndWorkspace(ctx, sessionID)
+	case types.AdminJobReingestSessions:
+		if running, _ := s.chatService.GetActiveRun(id); running {
+			return ErrRunInProgress
+		}
+		return s.reingestSession(ctx, sessionID)
+	case types.AdminJobRehashMessages:
+		return s.rehashSession(ctx, sessionID)
+	}
+	return fmt.Errorf("%w: unknown kind %q", ErrInvalidAdminJob, job.Kind)
+}
+
+// reingestSession rebuilds the session's message-derived memory from its stored messages.
+func (s *AdminJobService) reingestSession(ctx context.Context, sessionID uuid.UUID) error {
+	ragInstance := s.chatService.agent.GetRAG()
+	if ragInstance == nil {
+		return ErrMemoryDisabled
+	}
+	messages, err := s.store.GetMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to load messages: %w", err)
+	}
+	history := make([]types.AgentMessage, 0, len(messages))
+	for _, m := range messages {
+		if m.Role == "user" || m.Role == "assistant" || m.Role == "tool" {
+			history = append(history, types.AgentMessage{Role: m.Role, Content: m.Content, ContentHash: m.ContentHash})
+		}
+	}
+	_, err = ragInstance.ReingestSession(ctx, sessionID, history)
+	return err
+}
+
+// rehashSession recomputes the session's message hashes with the current normalization
+// and stores those that changed, along with the RAG references to them.
+func (s *AdminJobService) rehashSession(ctx context.Context, sessionID uuid.UUID) error {
+	messages, err := s.store.GetMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to load messages: %w", err)
+	}
+	var updates []types.MessageHashUpdate
+	for _, m := range messages {
+		if hash := rag.ComputeMessageContentHash(m.Role, m.Content); hash != m.ContentHash {
+			updates = append(updates, types.MessageHashUpdate{MessageID: m.ID, OldHash: m.ContentHash, NewHash: hash})
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	updated, documents, err := s.store.UpdateMessageContentHashes(ctx, sessionID, updates)
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Recomputed message content hashes",
+		zap.String("session_id", sessionID.String()),
+		zap.Int64("messages", updated),
+		zap.Int64("documents", documents))
+	return nil
+}
+
+// exportUsage writes one CSV row per session to ADMIN_EXPORT_DIR. On resume, rows past
+// the saved cursor (written before an interruption) are dropped before appending.
+func (s *AdminJobService) exportUsage(ctx context.Context, job *types.AdminJob) error {
+	if err := os.MkdirAll(s.cfg.AdminExportDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+	path := filepath.Join(s.cfg.AdminExportDir, "usage-"+job.ID.String()+".csv")
+	if err := truncateUsageExport(path, job.Cursor); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open export file: %w", err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	if job.Cursor == "" {
+		if err := w.Write(usageColumns); err != nil {
+			return fmt.Errorf("failed to write export header: %w", err)
+		}
+	}
+	job.Result = path
+
+	cursor, _ := uuid.Parse(job.Cursor)
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rows, err := s.store.GetSessionUsage(ctx, cursor, adminJobPageSize)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			w.Flush()
+			return w.Error()
+		}
+		for _, u := range rows {
+			if err := w.Write(usageRecord(u)); err != nil {
+				return fmt.Errorf("failed to write export row: %w", err)
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return fmt.Errorf("failed to write export rows: %w", err)
+		}
+		cursor = rows[len(rows)-1].SessionID
+		job.Cursor = cursor.String()
+		job.Processed += len(rows)
+		ok, err := s.store.SaveAdminJobProgress(ctx, *job)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return context.Canceled
+		}
+	}
+}
+
+func usageRecord(u types.SessionUsage) []string {
+	userID := ""
+	if u.UserID != nil {
+		userID = u.UserID.String()
+	}
+	return []string{
+		u.SessionID.String(), userID, u.Mode,
+		u.CreatedAt.UTC().Format(time.RFC3339), u.LastActive.UTC().Format(time.RFC3339),
+		strconv.FormatBool(u.IsActive),
+		strconv.Itoa(u.UserMessages), strconv.Itoa(u.AssistantMessages), strconv.Itoa(u.ToolMessages),
+		strconv.Itoa(u.Files), strconv.Itoa(u.Documents),
+	}
+}
+
+// truncateUsageExport keeps the header and the rows up to cursor of an interrupted
+// export. A fresh export (empty cursor) starts from an empty file.
+func truncateUsageExport(path, cursor string) error {
+	if cursor == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to reset export file: %w", err)
+		}
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("export file of interrupted job is missing: %w", err)
+		}
+		return fmt.Errorf("failed to read export file: %w", err)
+	}
+	var kept bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for first := true; scanner.Scan(); first = false {
+		line := scanner.Text()
+		sessionID, _, _ := strings.Cut(line, ",")
+		if first || sessionID <= cursor {
+			kept.WriteString(line)
+			kept.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read export file: %w", err)
+	}
+	if err := os.WriteFile(path, kept.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to truncate export file: %w", err)
+	}
+	return nil
+}
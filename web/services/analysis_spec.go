@@ -0,0 +1,41 @@
+package services
+
+import (
+	"context"
+
+	"stats-agent/agent"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// RunAnalysisSpec compiles a declarative analysis spec to templated Python, executes it
+// in the session's workspace and persists the spec, code and output as a user message
+// plus tool message. Specs are rejected while an agent run is active, like re-runs, and
+// hold the session's run slot until they finish.
+func (cs *ChatService) RunAnalysisSpec(ctx context.Context, sessionID uuid.UUID, spec types.AnalysisSpec) (*agent.ExecutionResult, error) {
+	id := sessionID.String()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	token, ok := cs.claimIdleRun(id, cancel)
+	if !ok {
+		return nil, ErrRunInProgress
+	}
+	defer cs.deregisterRun(id, token)
+
+	history, err := cs.prepareUserRun(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := cs.agent.RunAnalysisSpec(ctx, id, spec, history)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := cs.messageService.SaveUserRerun(ctx, id, agent.AnalysisSpecMessage(spec, result.Code), result.ToolContent()); err != nil {
+		cs.logger.Warn("Failed to persist analysis spec messages", zap.Error(err), zap.String("session_id", id))
+	}
+	return result, nil
+}
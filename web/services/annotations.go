@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrUnknownMessage is returned when annotating a message that is not part of the session.
+var ErrUnknownMessage = errors.New("unknown message")
+
+// ErrUnknownAnnotation is returned for an annotation that does not belong to the session.
+var ErrUnknownAnnotation = errors.New("unknown annotation")
+
+// ErrEmptyAnnotation is returned when a note is blank.
+var ErrEmptyAnnotation = errors.New("empty annotation")
+
+// maxAnnotationLength bounds annotation notes.
+const maxAnnotationLength = 2000
+
+// AnnotateMessage attaches a private note to one of the session's messages. With remember,
+// the note is also added to session memory so retrieval can surface it to the agent;
+// failing to index it is logged and the note is still saved.
+func (cs *ChatService) AnnotateMessage(ctx context.Context, sessionID uuid.UUID, messageID, note string, remember bool) (types.MessageAnnotation, error) {
+	messageUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		return types.MessageAnnotation{}, ErrUnknownMessage
+	}
+	note = strings.TrimSpace(note)
+	if note == "" {
+		return types.MessageAnnotation{}, ErrEmptyAnnotation
+	}
+	if len(note) > maxAnnotationLength {
+		note = note[:maxAnnotationLength]
+	}
+
+	messages, err := cs.store.GetMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return types.MessageAnnotation{}, fmt.Errorf("failed to load session messages: %w", err)
+	}
+	var target *types.ChatMessage
+	for i := range messages {
+		if messages[i].ID == messageID {
+			target = &messages[i]
+			break
+		}
+	}
+	if target == nil {
+		return types.MessageAnnotation{}, ErrUnknownMessage
+	}
+
+	annotation := types.MessageAnnotation{
+		ID:        uuid.New(),
+		SessionID: sessionID,
+		MessageID: messageUUID,
+		Note:      note,
+	}
+	if remember {
+		if ragInstance := cs.agent.GetRAG(); ragInstance != nil {
+			subject := annotationSubject(*target, collectMethodsSteps(messages))
+			docID, err := ragInstance.StoreAnnotation(ctx, sessionID.String(), messageID, subject, note)
+			if err != nil {
+				cs.logger.Warn("Failed to add annotation to session memory",
+					zap.Error(err),
+					zap.String("session_id", sessionID.String()))
+			} else {
+				annotation.RAGDocumentID = &docID
+			}
+		}
+	}
+	return cs.store.CreateMessageAnnotation(ctx, annotation)
+}
+
+// DeleteAnnotation removes one of the session's annotations, and its session memory entry
+// if it has one. Returns the annotated message's ID.
+func (cs *ChatService) DeleteAnnotation(ctx context.Context, sessionID, annotationID uuid.UUID) (string, error) {
+	annotation, err := cs.store.GetMessageAnnotation(ctx, sessionID, annotationID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrUnknownAnnotation
+		}
+		return "", err
+	}
+	if annotation.RAGDocumentID != nil {
+		if ragInstance := cs.agent.GetRAG(); ragInstance != nil {
+			if err := ragInstance.DeleteAnnotation(ctx, *annotation.RAGDocumentID); err != nil {
+				cs.logger.Warn("Failed to remove annotation from session memory",
+					zap.Error(err),
+					zap.String("session_id", sessionID.String()))
+			}
+		}
+	}
+	if err := cs.store.DeleteMessageAnnotation(ctx, sessionID, annotationID); err != nil {
+		return "", err
+	}
+	return annotation.MessageID.String(), nil
+}
+
+// MessageAnnotations returns the annotations on one of the session's messages.
+func (cs *ChatService) MessageAnnotations(ctx context.Context, sessionID uuid.UUID, messageID string) ([]types.MessageAnnotation, error) {
+	annotations, err := cs.store.GetMessageAnnotations(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var notes []types.MessageAnnotation
+	for _, a := range annotations {
+		if a.MessageID.String() == messageID {
+			notes = append(notes, a)
+		}
+	}
+	return notes, nil
+}
+
+// AttachAnnotations fills the Annotations of the messages about to be rendered. Failing
+// to load them is logged and the messages render without notes.
+func (cs *ChatService) AttachAnnotations(ctx context.Context, sessionID uuid.UUID, messages []types.ChatMessage) {
+	annotations, err := cs.store.GetMessageAnnotations(ctx, sessionID)
+	if err != nil {
+		cs.logger.Warn("Failed to load message annotations",
+			zap.Error(err),
+			zap.String("session_id", sessionID.String()))
+		return
+	}
+	attachAnnotations(messages, annotations)
+}
+
+func attachAnnotations(messages []types.ChatMessage, annotations []types.MessageAnnotation) {
+	if len(annotations) == 0 {
+		return
+	}
+	byMessage := make(map[string][]types.MessageAnnotation)
+	for _, a := range annotations {
+		id := a.MessageID.String()
+		byMessage[id] = append(byMessage[id], a)
+	}
+	for i := range messages {
+		messages[i].Annotations = byMessage[messages[i].ID]
+	}
+}
+
+// annotationSubject names what a note is about for its session memory entry: the step
+// number for an executed step's output, otherwise the message's role and an excerpt.
+func annotationSubject(message types.ChatMessage, steps []methodsStep) string {
+	for i, s := range steps {
+		if s.MessageID == message.ID {
+			return fmt.Sprintf("step %d", i+1)
+		}
+	}
+	excerpt := strings.Join(strings.Fields(message.Content), " ")
+	if runes := []rune(excerpt); len(runes) > 80 {
+		excerpt = string(runes[:80]) + "…"
+	}
+	switch message.Role {
+	case "user":
+		return fmt.Sprintf("the question %q", excerpt)
+	case "tool":
+		return fmt.Sprintf("the output %q", excerpt)
+	default:
+		return fmt.Sprintf("the answer %q", excerpt)
+	}
+}
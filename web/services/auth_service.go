@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"sort"
+	"stats-agent/config"
+	"stats-agent/database"
+	"stats-agent/web/types"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Passwords are bcrypt-hashed. bcrypt ignores input past 72 bytes, so longer passwords
+// are rejected rather than silently truncated.
+const (
+	minPasswordLength = 8
+	maxPasswordBytes  = 72
+)
+
+var (
+	// ErrInvalidCredentials is returned for an unknown email or a wrong password.
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrRegistrationDisabled is returned when AUTH_REGISTRATION_ENABLED is false.
+	ErrRegistrationDisabled = errors.New("registration is disabled")
+	// ErrUnknownOAuthProvider is returned for providers that are not configured.
+	ErrUnknownOAuthProvider = errors.New("unknown sign-in provider")
+	// ErrOAuthEmailUnverified is returned when the provider has no verified email for a
+	// new account.
+	ErrOAuthEmailUnverified = errors.New("the provider did not return a verified email")
+	// ErrOAuthPasswordAccount is returned when the identity's email belongs to a password
+	// account: its owner has to sign in with the password before linking the identity.
+	ErrOAuthPasswordAccount = errors.New("email belongs to a password account")
+)
+
+// AccountInputError describes invalid registration input.
+type AccountInputError struct{ Reason string }
+
+func (e *AccountInputError) Error() string { return e.Reason }
+
+// AuthService registers and signs in accounts. Every browser starts as an anonymous user
+// (see middleware.SessionMiddleware); registering turns that user into an account, and
+// signing in to an existing account claims the anonymous user's sessions, so work done
+// before signing in is kept.
+type AuthService struct {
+	store      database.Store
+	cfg        *config.Config
+	logger     *zap.Logger
+	httpClient *http.Client
+	providers  map[string]*oauthProvider
+	// dummyHash is compared against for unknown emails so they take as long as wrong passwords
+	dummyHash []byte
+}
+
+// NewAuthService creates an auth service with the configured OAuth providers.
+func NewAuthService(store database.Store, cfg *config.Config, logger *zap.Logger) *AuthService {
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte("stats-agent/dummy-password"), bcrypt.DefaultCost)
+	return &AuthService{
+		store:      store,
+		cfg:        cfg,
+		logger:     logger,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		providers:  configuredOAuthProviders(cfg),
+		dummyHash:  dummyHash,
+	}
+}
+
+// RegistrationEnabled reports whether email/password registration is open.
+func (as *AuthService) RegistrationEnabled() bool {
+	return as.cfg.AuthRegistrationEnabled
+}
+
+// OAuthProviders returns the names of the configured OAuth providers, sorted.
+func (as *AuthService) OAuthProviders() []string {
+	names := make([]string, 0, len(as.providers))
+	for name := range as.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Account returns the user's account details. Anonymous users have an empty email.
+func (as *AuthService) Account(ctx context.Context, userID uuid.UUID) (types.UserAccount, error) {
+	return as.store.GetUserAccount(ctx, userID)
+}
+
+// Register turns the anonymous user into an account with an email and password. The
+// user's sessions stay with it.
+func (as *AuthService) Register(ctx context.Context, userID uuid.UUID, email, password, displayName string) (types.UserAccount, error) {
+	if !as.cfg.AuthRegistrationEnabled {
+		return types.UserAccount{}, ErrRegistrationDisabled
+	}
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return types.UserAccount{}, err
+	}
+	if len(password) < minPasswordLength {
+		return types.UserAccount{}, &AccountInputError{Reason: fmt.Sprintf("password must be at least %d characters", minPasswordLength)}
+	}
+	if len(password) > maxPasswordBytes {
+		return types.UserAccount{}, &AccountInputError{Reason: fmt.Sprintf("password must be at most %d bytes", maxPasswordBytes)}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return types.UserAccount{}, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	account := types.UserAccount{
+		ID:           userID,
+		Email:        email,
+		DisplayName:  strings.TrimSpace(displayName),
+		PasswordHash: string(hash),
+	}
+	if err := as.store.SetUserAccount(ctx, account); err != nil {
+		return types.UserAccount{}, err
+	}
+	as.logger.Info("Account registered", zap.String("user_id", userID.String()))
+	return account, nil
+}
+
+// Login checks an email and password and claims the current anonymous user's sessions for
+// the account. Returns ErrInvalidCredentials without saying which part was wrong.
+func (as *AuthService) Login(ctx context.Context, currentUserID uuid.UUID, email, password string) (types.UserAccount, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return types.UserAccount{}, ErrInvalidCredentials
+	}
+	account, err := as.store.GetUserAccountByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && account.PasswordHash == "") {
+		_ = bcrypt.CompareHashAndPassword(as.dummyHash, []byte(password))
+		return types.UserAccount{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return types.UserAccount{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) != nil {
+		return types.UserAccount{}, ErrInvalidCredentials
+	}
+
+	as.claimSessions(ctx, currentUserID, account.ID)
+	return account, nil
+}
+
+// OAuthURL returns the provider's consent page URL for a sign-in with state.
+func (as *AuthService) OAuthURL(provider, state string) (string, error) {
+	p, ok := as.providers[provider]
+	if !ok {
+		return "", ErrUnknownOAuthProvider
+	}
+	return p.authCodeURL(as.oauthRedirectURI(provider), state), nil
+}
+
+// CompleteOAuth exchanges the callback code and signs in the identity's user:
+//   - a known identity signs in to its account;
+//   - a signed-in user links the identity to their account;
+//   - a verified email of an existing OAuth-only account links the identity to that
+//     account (a password account must sign in with its password first);
+//   - otherwise the anonymous user becomes a new account with the verified email.
+//
+// The current anonymous user's sessions are claimed for the account.
+func (as *AuthService) CompleteOAuth(ctx context.Context, currentUserID uuid.UUID, provider, code string) (types.UserAccount, error) {
+	p, ok := as.providers[provider]
+	if !ok {
+		return types.UserAccount{}, ErrUnknownOAuthProvider
+	}
+	accessToken, err := p.exchange(ctx, as.httpClient, as.oauthRedirectURI(provider), code)
+	if err != nil {
+		return types.UserAccount{}, err
+	}
+	profile, err := p.profile(ctx, as.httpClient, accessToken)
+	if err != nil {
+		return types.UserAccount{}, err
+	}
+	if profile.Subject == "" {
+		return types.UserAccount{}, fmt.Errorf("%s returned no user ID", provider)
+	}
+	email := ""
+	if profile.EmailVerified {
+		email, _ = normalizeEmail(profile.Email)
+	}
+
+	accountID, err := as.store.GetUserByIdentity(ctx, provider, profile.Subject)
+	switch {
+	case err == nil:
+	case !errors.Is(err, sql.ErrNoRows):
+		return types.UserAccount{}, err
+	default:
+		accountID, err = as.accountForNewIdentity(ctx, currentUserID, email, profile.Name)
+		if err != nil {
+			return types.UserAccount{}, err
+		}
+		if err := as.store.LinkUserIdentity(ctx, accountID, provider, profile.Subject, email); err != nil {
+			return types.UserAccount{}, err
+		}
+		as.logger.Info("OAuth identity linked",
+			zap.String("provider", provider),
+			zap.String("user_id", accountID.String()))
+	}
+
+	as.claimSessions(ctx, currentUserID, accountID)
+	return as.store.GetUserAccount(ctx, accountID)
+}
+
+// accountForNewIdentity picks the account a first-time OAuth identity belongs to.
+func (as *AuthService) accountForNewIdentity(ctx context.Context, currentUserID uuid.UUID, email, name string) (uuid.UUID, error) {
+	current, err := as.store.GetUserAccount(ctx, currentUserID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if current.Email != "" {
+		return current.ID, nil
+	}
+	if email == "" {
+		return uuid.Nil, ErrOAuthEmailUnverified
+	}
+	if existing, err := as.store.GetUserAccountByEmail(ctx, email); err == nil {
+		// Registration never verifies email, so anyone could have registered this address
+		// with a password; only accounts whose email came from a provider are linked
+		if existing.PasswordHash != "" {
+			return uuid.Nil, ErrOAuthPasswordAccount
+		}
+		return existing.ID, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, err
+	}
+	if err := as.store.SetUserAccount(ctx, types.UserAccount{ID: currentUserID, Email: email, DisplayName: name}); err != nil {
+		return uuid.Nil, err
+	}
+	as.logger.Info("Account registered through OAuth", zap.String("user_id", currentUserID.String()))
+	return currentUserID, nil
+}
+
+// claimSessions moves an anonymous user's sessions to the account it signed in to. A
+// failure only loses the link to those sessions, so it is logged rather than failing the
+// sign-in.
+func (as *AuthService) claimSessions(ctx context.Context, fromUserID, toUserID uuid.UUID) {
+	if fromUserID == uuid.Nil || fromUserID == toUserID {
+		return
+	}
+	current, err := as.store.GetUserAccount(ctx, fromUserID)
+	if err != nil || current.Email != "" {
+		// Switching between accounts never moves sessions
+		return
+	}
+	moved, err := as.store.ClaimUserSessions(ctx, fromUserID, toUserID)
+	if err != nil {
+		as.logger.Warn("Failed to claim anonymous sessions",
+			zap.Error(err),
+			zap.String("user_id", toUserID.String()))
+		return
+	}
+	if moved > 0 {
+		as.logger.Info("Claimed anonymous sessions",
+			zap.Int64("sessions", moved),
+			zap.String("user_id", toUserID.String()))
+	}
+}
+
+func (as *AuthService) oauthRedirectURI(provider string) string {
+	return strings.TrimRight(as.cfg.OAuthRedirectBaseURL, "/") + "/auth/oauth/" + provider + "/callback"
+}
+
+// normalizeEmail validates a bare address and lower-cases it, so lookups are exact matches.
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", &AccountInputError{Reason: "enter a valid email address"}
+	}
+	return strings.ToLower(email), nil
+}
@@ -3,6 +3,8 @@ package services
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,6 +13,10 @@ import (
 	"stats-agent/agent"
 	"stats-agent/database"
 	"stats-agent/rag"
+	"stats-agent/tools"
+	"stats-agent/tracing"
+	"stats-agent/web/format"
+	"stats-agent/web/problem"
 	"stats-agent/web/templates/components"
 	"stats-agent/web/types"
 	"strings"
@@ -26,65 +32,128 @@ type sessionRun struct {
 	cancel        context.CancelFunc
 	token         string
 	userMessageID string
+	// stopHeartbeat ends the run's registry heartbeat; nil without a run registry
+	stopHeartbeat func()
 }
 
 type ChatService struct {
 	agent          *agent.Agent
-	store          *database.PostgresStore
+	store          database.Store
 	logger         *zap.Logger
 	fileService    *FileService
+	figureService  *FigureService
 	messageService *MessageService
 	streamService  *StreamService
+	notifier       *NotificationService
+	contentFilter  *ContentFilter // nil when content filtering is disabled
+	runRegistry    *RunRegistry   // nil unless MULTI_REPLICA_ENABLED
 	activeRunsMu   sync.Mutex
 	activeRuns     map[string]sessionRun
 }
 
 func NewChatService(
 	agent *agent.Agent,
-	store *database.PostgresStore,
+	store database.Store,
 	logger *zap.Logger,
 	fileService *FileService,
+	figureService *FigureService,
 	messageService *MessageService,
 	streamService *StreamService,
+	notifier *NotificationService,
+	contentFilter *ContentFilter,
+	runRegistry *RunRegistry,
 ) *ChatService {
 	return &ChatService{
 		agent:          agent,
 		store:          store,
 		logger:         logger,
 		fileService:    fileService,
+		figureService:  figureService,
 		messageService: messageService,
 		streamService:  streamService,
+		notifier:       notifier,
+		contentFilter:  contentFilter,
+		runRegistry:    runRegistry,
 		activeRuns:     make(map[string]sessionRun),
 	}
 }
 
 func (cs *ChatService) registerRun(sessionID string, cancel context.CancelFunc, userMessageID string) string {
 	token := uuid.New().String()
-	var previous context.CancelFunc
 
-	cs.activeRunsMu.Lock()
-	if existing, ok := cs.activeRuns[sessionID]; ok {
-		previous = existing.cancel
+	// Claiming the session in the registry replaces a run on another replica as well
+	var stopHeartbeat func()
+	if cs.runRegistry != nil {
+		stopHeartbeat = cs.runRegistry.Claim(sessionID, userMessageID, token, cancel)
 	}
-	cs.activeRuns[sessionID] = sessionRun{cancel: cancel, token: token, userMessageID: userMessageID}
+
+	cs.activeRunsMu.Lock()
+	previous, replaced := cs.activeRuns[sessionID]
+	cs.activeRuns[sessionID] = sessionRun{cancel: cancel, token: token, userMessageID: userMessageID, stopHeartbeat: stopHeartbeat}
 	cs.activeRunsMu.Unlock()
 
-	if previous != nil {
+	if replaced {
 		cs.logger.Info("Cancelling previous active run for session", zap.String("session_id", sessionID))
-		previous()
+		if previous.stopHeartbeat != nil {
+			previous.stopHeartbeat()
+		}
+		previous.cancel()
 	}
 
 	return token
 }
 
+// claimIdleRun registers work outside an agent turn, such as a bookmark jump, as the
+// session's run unless a run is already active. The check and the claim happen under one
+// lock, so two such actions cannot both pass the check. An agent run started meanwhile
+// cancels the work like it cancels a previous run. Release it with deregisterRun.
+func (cs *ChatService) claimIdleRun(sessionID string, cancel context.CancelFunc) (string, bool) {
+	if cs.runRegistry != nil {
+		if running, _ := cs.runRegistry.Active(sessionID); running {
+			return "", false
+		}
+	}
+
+	cs.activeRunsMu.Lock()
+	if _, running := cs.activeRuns[sessionID]; running {
+		cs.activeRunsMu.Unlock()
+		return "", false
+	}
+	token := uuid.New().String()
+	cs.activeRuns[sessionID] = sessionRun{cancel: cancel, token: token}
+	cs.activeRunsMu.Unlock()
+
+	if cs.runRegistry != nil {
+		stopHeartbeat := cs.runRegistry.Claim(sessionID, "", token, cancel)
+		cs.activeRunsMu.Lock()
+		if run, ok := cs.activeRuns[sessionID]; ok && run.token == token {
+			run.stopHeartbeat = stopHeartbeat
+			cs.activeRuns[sessionID] = run
+		} else {
+			stopHeartbeat()
+		}
+		cs.activeRunsMu.Unlock()
+	}
+	return token, true
+}
+
 func (cs *ChatService) deregisterRun(sessionID, token string) {
 	cs.activeRunsMu.Lock()
-	defer cs.activeRunsMu.Unlock()
-	if existing, ok := cs.activeRuns[sessionID]; ok && existing.token == token {
+	existing, ok := cs.activeRuns[sessionID]
+	ok = ok && existing.token == token
+	if ok {
 		delete(cs.activeRuns, sessionID)
 	}
+	cs.activeRunsMu.Unlock()
+
+	if ok && cs.runRegistry != nil {
+		existing.stopHeartbeat()
+		cs.runRegistry.Release(sessionID, token)
+	}
 }
 
+// StopSessionRun cancels the session's run. With a run registry it also stops a run on
+// another replica, which notices at its next heartbeat.
 func (cs *ChatService) StopSessionRun(sessionID string) {
 	cs.activeRunsMu.Lock()
 	run, ok := cs.activeRuns[sessionID]
@@ -95,18 +164,29 @@ func (cs *ChatService) StopSessionRun(sessionID string) {
 
 	if ok {
 		cs.logger.Info("Cancelling active run for session", zap.String("session_id", sessionID))
+		if run.stopHeartbeat != nil {
+			run.stopHeartbeat()
+		}
 		run.cancel()
 	}
+	if cs.runRegistry != nil {
+		cs.runRegistry.Stop(sessionID)
+	}
 }
 
 // GetActiveRun returns whether a run is active for the session and, if so,
-// the user message ID that initiated it (used to reattach SSE).
+// the user message ID that initiated it (used to reattach SSE). With a run
+// registry, runs on other replicas count too.
 func (cs *ChatService) GetActiveRun(sessionID string) (bool, string) {
 	cs.activeRunsMu.Lock()
-	defer cs.activeRunsMu.Unlock()
-	if run, ok := cs.activeRuns[sessionID]; ok {
+	run, ok := cs.activeRuns[sessionID]
+	cs.activeRunsMu.Unlock()
+	if ok {
 		return true, run.userMessageID
 	}
+	if cs.runRegistry != nil {
+		return cs.runRegistry.Active(sessionID)
+	}
 	return false, ""
 }
 
@@ -126,23 +206,9 @@ func (cs *ChatService) InitializeSession(ctx context.Context, sessionID string)
 	initCtx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
 	defer cancel()
 
-	workspaceDir := filepath.Join("workspaces", sessionID)
-	files, err := os.ReadDir(workspaceDir)
+	uploadedFiles, err := workspaceDataFiles(sessionID)
 	if err != nil {
-		return fmt.Errorf("could not read workspace directory: %w", err)
-	}
-
-	var uploadedFiles []string
-	for _, file := range files {
-		if !file.IsDir() {
-			// Only report CSV and Excel files that the agent can directly process
-			// Other files (PDFs, images) are tracked in the database but not auto-loaded
-			filename := file.Name()
-			ext := filepath.Ext(strings.ToLower(filename))
-			if ext == ".csv" || ext == ".xlsx" || ext == ".xls" {
-				uploadedFiles = append(uploadedFiles, filename)
-			}
-		}
+		return err
 	}
 
 	initResult, err := cs.agent.InitializeSession(initCtx, sessionID, uploadedFiles)
@@ -163,6 +229,28 @@ func (cs *ChatService) InitializeSession(ctx context.Context, sessionID string)
 	return cs.store.CreateMessage(initCtx, initMessage)
 }
 
+// workspaceDataFiles lists the dataset files (CSV, Excel, Parquet) in the session's workspace, the files
+// the init code announces to the agent. Other files (PDFs, images) are tracked in the
+// database but not auto-loaded.
+func workspaceDataFiles(sessionID string) ([]string, error) {
+	files, err := os.ReadDir(filepath.Join("workspaces", sessionID))
+	if err != nil {
+		return nil, fmt.Errorf("could not read workspace directory: %w", err)
+	}
+
+	var dataFiles []string
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		filename := file.Name()
+		if tools.IsDatasetFile(filename) {
+			dataFiles = append(dataFiles, filename)
+		}
+	}
+	return dataFiles, nil
+}
+
 func (cs *ChatService) GenerateAndSetTitle(ctx context.Context, sessionID uuid.UUID, firstMessage string, writeFunc func(StreamData) error) {
 	session, err := cs.store.GetSessionByID(ctx, sessionID)
 	if err != nil {
@@ -170,7 +258,7 @@ func (cs *ChatService) GenerateAndSetTitle(ctx context.Context, sessionID uuid.U
 		return
 	}
 
-	title, err := cs.agent.GenerateTitle(ctx, firstMessage)
+	title, err := cs.agent.GenerateTitle(ctx, firstMessage, "")
 	if err != nil {
 		cs.logger.Warn("Failed to generate title", zap.Error(err), zap.String("session_id", sessionID.String()))
 		return
@@ -198,18 +286,279 @@ func (cs *ChatService) GenerateAndSetTitle(ctx context.Context, sessionID uuid.U
 	}
 }
 
+// updateResultTags refreshes the session's tags from the results recorded so far and,
+// when new tags appeared, regenerates a descriptive title from them. Tags only grow:
+// stored tags are kept, so results from before a restart (when the action cache was
+// empty) are not lost. Failures are logged and leave the session unchanged.
+func (cs *ChatService) updateResultTags(ctx context.Context, sessionID string, write func(StreamData)) {
+	tags := cs.agent.ResultTags(sessionID)
+	if len(tags) == 0 {
+		return
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return
+	}
+	session, err := cs.store.GetSessionByID(ctx, sessionUUID)
+	if err != nil {
+		cs.logger.Warn("Failed to get session for tag update", zap.Error(err), zap.String("session_id", sessionID))
+		return
+	}
+
+	merged := append([]string{}, session.Tags...)
+	known := make(map[string]bool, len(merged))
+	for _, t := range merged {
+		known[t] = true
+	}
+	for _, t := range tags {
+		if !known[t] {
+			known[t] = true
+			merged = append(merged, t)
+		}
+	}
+	if len(merged) == len(session.Tags) {
+		return
+	}
+
+	if err := cs.store.UpdateSessionTags(ctx, sessionUUID, merged); err != nil {
+		cs.logger.Warn("Failed to update session tags", zap.Error(err), zap.String("session_id", sessionID))
+		return
+	}
+	session.Tags = merged
+
+	title, err := cs.agent.GenerateResultsTitle(ctx, sessionID, merged)
+	if err != nil {
+		cs.logger.Warn("Failed to generate results title", zap.Error(err), zap.String("session_id", sessionID))
+	} else if title != "" {
+		if err := cs.store.UpdateSessionTitle(ctx, sessionUUID, title); err != nil {
+			cs.logger.Warn("Failed to update session title", zap.Error(err), zap.String("session_id", sessionID))
+		} else {
+			session.Title = title
+		}
+	}
+
+	cs.logger.Info("Updated session result tags",
+		zap.String("session_id", sessionID),
+		zap.Strings("tags", merged),
+		zap.String("title", session.Title))
+
+	var buf bytes.Buffer
+	if err := components.SessionLinkOOB(session).Render(ctx, &buf); err != nil {
+		cs.logger.Error("Failed to render SessionLinkOOB component", zap.Error(err))
+		return
+	}
+	write(StreamData{Type: "sidebar_update", Content: buf.String()})
+}
+
+// updateSessionAbstract folds a completed run into the session's rolling abstract and
+// refreshes its sidebar link. Until result tags give the session a results title
+// (updateResultTags), the title is regenerated from the abstract, which describes the
+// whole conversation rather than its first message. Failures are logged and leave the
+// session unchanged.
+func (cs *ChatService) updateSessionAbstract(ctx context.Context, sessionID, input, answer string, write func(StreamData)) {
+	if strings.TrimSpace(answer) == "" {
+		return
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return
+	}
+	session, err := cs.store.GetSessionByID(ctx, sessionUUID)
+	if err != nil {
+		cs.logger.Warn("Failed to get session for abstract update", zap.Error(err), zap.String("session_id", sessionID))
+		return
+	}
+
+	abstract, err := cs.agent.GenerateSessionAbstract(ctx, sessionID, session.Abstract, input, answer)
+	if err != nil {
+		cs.logger.Warn("Failed to generate session abstract", zap.Error(err), zap.String("session_id", sessionID))
+		return
+	}
+	if abstract == "" || abstract == session.Abstract {
+		return
+	}
+	if err := cs.store.UpdateSessionAbstract(ctx, sessionUUID, abstract); err != nil {
+		cs.logger.Warn("Failed to update session abstract", zap.Error(err), zap.String("session_id", sessionID))
+		return
+	}
+	session.Abstract = abstract
+
+	if len(session.Tags) == 0 {
+		title, err := cs.agent.GenerateTitle(ctx, input, abstract)
+		if err != nil {
+			cs.logger.Warn("Failed to generate title from abstract", zap.Error(err), zap.String("session_id", sessionID))
+		} else if title != "" && title != session.Title {
+			if err := cs.store.UpdateSessionTitle(ctx, sessionUUID, title); err != nil {
+				cs.logger.Warn("Failed to update session title", zap.Error(err), zap.String("session_id", sessionID))
+			} else {
+				session.Title = title
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := components.SessionLinkOOB(session).Render(ctx, &buf); err != nil {
+		cs.logger.Error("Failed to render SessionLinkOOB component", zap.Error(err))
+		return
+	}
+	write(StreamData{Type: "sidebar_update", Content: buf.String()})
+}
+
+// streamFollowUps sends suggested follow-up questions for the run's final answer as a
+// JSON array; the client shows them as chips. Nothing is sent when generation fails.
+func (cs *ChatService) streamFollowUps(ctx context.Context, sessionID, input, answer string, write func(StreamData)) {
+	if strings.TrimSpace(answer) == "" {
+		return
+	}
+	suggestions, err := cs.agent.SuggestFollowUps(ctx, sessionID, input, answer)
+	if err != nil {
+		cs.logger.Warn("Failed to generate follow-up suggestions", zap.Error(err), zap.String("session_id", sessionID))
+		return
+	}
+	if len(suggestions) == 0 {
+		return
+	}
+	payload, err := json.Marshal(suggestions)
+	if err != nil {
+		cs.logger.Warn("Failed to marshal follow-up suggestions", zap.Error(err))
+		return
+	}
+	write(StreamData{Type: "followup_suggestions", Content: string(payload)})
+}
+
+// memoryCitations returns the memory entries an assistant message cites, if RAG is enabled.
+func (cs *ChatService) memoryCitations(sessionID, assistant string) []types.MemoryCitation {
+	ragInstance := cs.agent.GetRAG()
+	if ragInstance == nil || assistant == "" {
+		return nil
+	}
+	return ragInstance.CiteMemory(sessionID, assistant)
+}
+
+// streamMemoryFootnotes sends the footnotes of a saved assistant message; the client lists
+// them under the answer and links the message's [mN] tags to them.
+func (cs *ChatService) streamMemoryFootnotes(ctx context.Context, messageID string, citations []types.MemoryCitation, write func(StreamData)) {
+	if len(citations) == 0 || messageID == "" {
+		return
+	}
+	footnotes, err := cs.messageService.RenderMemoryFootnotes(ctx, messageID, citations)
+	if err != nil {
+		cs.logger.Warn("Failed to render memory footnotes", zap.Error(err), zap.String("message_id", messageID))
+		return
+	}
+	write(StreamData{Type: "memory_footnotes", Content: footnotes})
+}
+
+// ErrRunInProgress is returned when an action conflicts with an active agent run.
+var ErrRunInProgress = errors.New("agent run in progress")
+
+// RecordRetrievalFeedback records whether the user found the latest answer helpful.
+// The rating is attributed to the session's retrieval experiment arm.
+func (cs *ChatService) RecordRetrievalFeedback(sessionID uuid.UUID, helpful bool) {
+	cs.agent.RecordRetrievalFeedback(sessionID.String(), helpful)
+}
+
+// RerunUserCode executes code the user edited from an assistant python block and
+// persists it as a user message plus tool message. Edits are rejected while an
+// agent run is active, and the rerun holds the session's run slot until it finishes,
+// so the two don't race on the workspace.
+func (cs *ChatService) RerunUserCode(ctx context.Context, sessionID uuid.UUID, code, originalCode string) (*agent.ExecutionResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	token, ok := cs.claimIdleRun(sessionID.String(), cancel)
+	if !ok {
+		return nil, ErrRunInProgress
+	}
+	defer cs.deregisterRun(sessionID.String(), token)
+
+	history, err := cs.prepareUserRun(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := cs.agent.RunUserEditedCode(ctx, sessionID.String(), code, originalCode, history)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := cs.messageService.SaveUserRerun(ctx, sessionID.String(), agent.UserEditedCodeMessage(code), result.ToolContent()); err != nil {
+		cs.logger.Warn("Failed to persist user rerun messages", zap.Error(err), zap.String("session_id", sessionID.String()))
+	}
+	return result, nil
+}
+
+// prepareUserRun loads the conversation history for code the user runs outside an agent
+// turn and applies the session's pinned random seed, like agent runs do.
+func (cs *ChatService) prepareUserRun(ctx context.Context, sessionID uuid.UUID) ([]types.AgentMessage, error) {
+	messages, err := cs.store.GetMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session messages: %w", err)
+	}
+	history := make([]types.AgentMessage, 0, len(messages))
+	for _, m := range messages {
+		if m.Role == "user" || m.Role == "assistant" || m.Role == "tool" {
+			history = append(history, types.AgentMessage{Role: m.Role, Content: m.Content, ContentHash: m.ContentHash})
+		}
+	}
+
+	if session, err := cs.store.GetSessionByID(ctx, sessionID); err == nil {
+		cs.agent.SetSessionRandomSeed(sessionID.String(), session.RandomSeed)
+	} else {
+		cs.logger.Warn("Failed to load session random seed", zap.Error(err), zap.String("session_id", sessionID.String()))
+	}
+	return history, nil
+}
+
+// notifyRunFinished sends a long-run notification: over the run's SSE stream, which app.js
+// shows as a browser notification if the app's tab is still open in the background, and to
+// the webhook/email channels.
+func (cs *ChatService) notifyRunFinished(ctx context.Context, sessionID, outcome string, elapsed time.Duration, write func(StreamData)) {
+	title := "Untitled analysis"
+	if sessionUUID, err := uuid.Parse(sessionID); err == nil {
+		if session, err := cs.store.GetSessionByID(ctx, sessionUUID); err == nil && session.Title != "" {
+			title = session.Title
+		}
+	}
+	n := cs.notifier.NewRunNotification(sessionID, title, outcome, elapsed)
+
+	if payload, err := json.Marshal(map[string]string{"title": "Pocket Statistician", "body": n.Message(), "outcome": outcome}); err == nil {
+		write(StreamData{Type: "run_notification", Content: string(payload)})
+	}
+
+	go func() {
+		notifyCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		cs.notifier.Notify(notifyCtx, n)
+	}()
+}
+
 // CleanupSession cleans up agent session bindings (e.g., Python executor bindings).
 func (cs *ChatService) CleanupSession(sessionID string) {
 	cs.StopSessionRun(sessionID)
 	cs.agent.CleanupSession(sessionID)
 }
 
-// StreamAgentResponse orchestrates the agent's response streaming via SSE.
+// ScreenMessage runs an inbound user message through the content filter.
+func (cs *ChatService) ScreenMessage(ctx context.Context, sessionID, message string) ContentVerdict {
+	return cs.contentFilter.Screen(ctx, sessionID, "input", message)
+}
+
+// contentFilterEvent tells the client that the content filter flagged the response.
+func contentFilterEvent(verdict ContentVerdict) StreamData {
+	message := "The content filter flagged this response."
+	if verdict.Action == ContentFilterBlock {
+		message = "This response was blocked by the content filter."
+	}
+	payload, _ := json.Marshal(map[string]string{"action": verdict.Action, "message": message})
+	return StreamData{Type: "content_filter", Content: string(payload)}
+}
+
+// StreamAgentResponse orchestrates the agent's response streaming over SSE or a WebSocket.
 // It captures stdout, streams word-by-word, tracks new files, and saves messages to DB.
 // Routes to either dataset mode (with code execution) or document mode (Q&A only) based on session.
 func (cs *ChatService) StreamAgentResponse(
 	ctx context.Context,
-	w http.ResponseWriter,
+	conn StreamConn,
 	input string,
 	userMessageID string,
 	sessionID string,
@@ -229,31 +578,52 @@ func (cs *ChatService) StreamAgentResponse(
 		session.Mode = types.ModeDataset
 	}
 
+	// Apply the session's response verbosity (empty on lookup failure resets to standard)
+	cs.agent.SetSessionVerbosity(sessionID, session.Verbosity)
+	cs.agent.SetSessionEffectSizeCheck(sessionID, session.EffectSizeCheck)
+	cs.agent.SetSessionRandomSeed(sessionID, session.RandomSeed)
+	cs.agent.SetSessionLLMModel(sessionID, session.LLMModel)
+	cs.agent.SetSessionRetrievalPolicy(sessionID, session.RetrievalPolicy)
+
+	// Load the data transformation log so the cohort definition survives restarts
+	if session.Mode != types.ModeDocument {
+		if _, err := cs.SessionLineage(ctx, sessionUUID); err != nil {
+			cs.logger.Warn("Failed to load data lineage", zap.Error(err), zap.String("session_id", sessionID))
+		}
+		if err := cs.SessionColumnTypes(ctx, sessionUUID); err != nil {
+			cs.logger.Warn("Failed to load column type overrides", zap.Error(err), zap.String("session_id", sessionID))
+		}
+		if err := cs.SessionColumnLevels(ctx, sessionUUID); err != nil {
+			cs.logger.Warn("Failed to load column levels", zap.Error(err), zap.String("session_id", sessionID))
+		}
+	}
+
 	// Route based on mode
 	if session.Mode == types.ModeDocument {
-		cs.streamDocumentResponse(ctx, w, input, userMessageID, sessionID, history)
+		cs.streamDocumentResponse(ctx, conn, input, userMessageID, sessionID, history)
 	} else {
-		cs.streamDatasetResponse(ctx, w, input, userMessageID, sessionID, history)
+		cs.streamDatasetResponse(ctx, conn, input, userMessageID, sessionID, history)
 	}
 }
 
 // streamDatasetResponse handles the original agentic workflow with Python code execution
 func (cs *ChatService) streamDatasetResponse(
 	ctx context.Context,
-	w http.ResponseWriter,
+	conn StreamConn,
 	input string,
 	userMessageID string,
 	sessionID string,
 	history []types.AgentMessage,
 ) {
 	agentMessageID := uuid.New().String()
-	var writeMu sync.Mutex
-	runCtx, cancelRun := context.WithCancel(context.Background())
+	runStart := time.Now()
+	// The run outlives the stream request but stays in its trace
+	runCtx, cancelRun := context.WithCancel(tracing.Detach(ctx))
 	token := cs.registerRun(sessionID, cancelRun, userMessageID)
-	defer func() {
+	finishRun := func() {
 		cancelRun()
 		cs.deregisterRun(sessionID, token)
-	}()
+	}
 	var sseActive atomic.Bool
 	sseActive.Store(true)
 
@@ -265,7 +635,7 @@ func (cs *ChatService) streamDatasetResponse(
 		if !sseActive.Load() {
 			return
 		}
-		if err := cs.streamService.WriteSSEData(ctx, w, data, &writeMu); err != nil {
+		if err := conn.Write(data); err != nil {
 			if sseActive.CompareAndSwap(true, false) {
 				cs.logger.Info("SSE stream closed, continuing agent in background",
 					zap.Error(err),
@@ -275,17 +645,29 @@ func (cs *ChatService) streamDatasetResponse(
 		}
 	}
 
+	// Screen streamed output; a blocked response ends the run
+	guard := cs.contentFilter.NewStreamGuard(sessionID, func(verdict ContentVerdict) {
+		safeWrite(contentFilterEvent(verdict))
+		if verdict.Action == ContentFilterBlock {
+			cancelRun()
+		}
+	})
+
 	// Send initial SSE messages - best effort for active clients
 	safeWrite(StreamData{Type: "remove_loader", Content: "loading-" + userMessageID})
 	safeWrite(StreamData{Type: "create_container", Content: agentMessageID})
 
 	pipeReader, pipeWriter := io.Pipe()
-	defer pipeReader.Close()
 
 	var captureBuffer bytes.Buffer
 
 	var lastAssistantMu sync.Mutex
 	var lastAssistantID string
+	var lastAssistantText string
+
+	// Executed code and its output, used to describe the figures the run saved
+	var stepsMu sync.Mutex
+	var steps []ExecutedStep
 
 	persist := func(assistant string, tool *string) {
 		assistant = strings.TrimSpace(assistant)
@@ -300,31 +682,52 @@ func (cs *ChatService) streamDatasetResponse(
 		ctxPersist, cancelPersist := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancelPersist()
 
+		assistant = guard.Message(ctxPersist, assistant)
+
 		var toolPtr *string
 		if toolStr != "" {
 			toolPtr = &toolStr
+			if strings.Contains(toolStr, tools.ErrExecutorUnavailable.Error()) {
+				safeWrite(ErrorEvent(http.StatusServiceUnavailable, problem.ExecutorUnavailable, "The Python executor is unavailable; code could not run"))
+			}
+			if code, ok := format.ExtractCodeContent(assistant); ok {
+				stepsMu.Lock()
+				steps = append(steps, ExecutedStep{Code: code, Output: toolStr})
+				stepsMu.Unlock()
+			}
 		}
 
-		id, err := cs.messageService.SaveAssistantAndTool(ctxPersist, sessionID, assistant, toolPtr, "")
+		citations := cs.memoryCitations(sessionID, assistant)
+		id, err := cs.messageService.SaveCitedAssistantAndTool(ctxPersist, sessionID, assistant, toolPtr, "", citations)
 		if err != nil {
 			cs.logger.Error("Incremental message persistence failed",
 				zap.Error(err),
 				zap.String("session_id", sessionID))
 			return
 		}
+		cs.streamMemoryFootnotes(ctxPersist, id, citations, safeWrite)
+		lastAssistantMu.Lock()
 		if id != "" {
-			lastAssistantMu.Lock()
 			lastAssistantID = id
-			lastAssistantMu.Unlock()
 		}
+		if assistant != "" {
+			lastAssistantText = assistant
+		}
+		lastAssistantMu.Unlock()
 	}
 
 	agentStream := agent.NewStream(&captureBuffer, pipeWriter, persist)
+	agentStream.SetEventHandler(func(eventType, payload string) {
+		safeWrite(StreamData{Type: eventType, Content: payload})
+	})
 
 	streamDone := make(chan struct{})
 	go func() {
 		defer close(streamDone)
 		cs.streamService.ProcessStreamByWord(runCtx, pipeReader, func(data StreamData) error {
+			if data.Type == "chunk" && !guard.Chunk(data.Content) {
+				return nil
+			}
 			safeWrite(data)
 			return nil
 		})
@@ -342,81 +745,169 @@ func (cs *ChatService) streamDatasetResponse(
 		pipeWriter.CloseWithError(runCtx.Err())
 	}()
 
-	<-agentDone
-	<-streamDone
+	// Finish the run in the background: the handler returns as soon as the SSE
+	// connection closes, while persistence and file discovery still complete.
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer finishRun()
+		defer pipeReader.Close()
 
-	agentStream.Finalize()
+		<-agentDone
+		<-streamDone
 
-	// Use background context for DB operations after request context might be cancelled
-	backgroundCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+		agentStream.Finalize()
 
-	// Discover and mark new files - non-critical, continue if fails
-	newFilePaths, err := cs.fileService.GetAndMarkNewFiles(backgroundCtx, sessionID)
-	if err != nil {
-		cs.logger.Error("Failed to get and mark new file paths",
-			zap.Error(err),
-			zap.String("session_id", sessionID))
-		// Continue - files won't be displayed this time but can be discovered later
-	}
+		// Use background context for DB operations after request context might be cancelled
+		backgroundCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
 
-	// Stream new files as OOB updates - non-critical
-	if len(newFilePaths) > 0 {
-		fileContainerID := fmt.Sprintf("file-container-agent-msg-%s", agentMessageID)
-		oobHTML, err := cs.fileService.RenderFileOOBWrapper(backgroundCtx, fileContainerID, newFilePaths)
+		// Discover and mark new files - non-critical, continue if fails
+		newFilePaths, err := cs.fileService.GetAndMarkNewFiles(backgroundCtx, sessionID)
 		if err != nil {
-			cs.logger.Error("Failed to render file OOB wrapper",
+			cs.logger.Error("Failed to get and mark new file paths",
 				zap.Error(err),
-				zap.Int("file_count", len(newFilePaths)))
-		} else {
+				zap.String("session_id", sessionID))
+			// Continue - files won't be displayed this time but can be discovered later
+		}
+
+		// Stream new files as OOB updates - non-critical. They render without alt text for
+		// now; the figure descriptions come after end.
+		fileContainerID := fmt.Sprintf("file-container-agent-msg-%s", agentMessageID)
+		streamFiles := func(ctx context.Context, altTexts map[string]string) {
+			oobHTML, err := cs.fileService.RenderFileOOBWrapper(ctx, fileContainerID, newFilePaths, altTexts)
+			if err != nil {
+				cs.logger.Error("Failed to render file OOB wrapper",
+					zap.Error(err),
+					zap.Int("file_count", len(newFilePaths)))
+				return
+			}
 			safeWrite(StreamData{Type: "file_append_html", Content: oobHTML})
 		}
-	}
+		if len(newFilePaths) > 0 {
+			streamFiles(backgroundCtx, nil)
+		}
 
-	// Send end signal - best effort
-	safeWrite(StreamData{Type: "end"})
+		// Tag the session and retitle it once results are recorded - non-critical
+		cs.updateResultTags(backgroundCtx, sessionID, safeWrite)
 
-	// Render file blocks for DB storage - non-critical
-	dbFilesHTML, err := cs.fileService.RenderFileBlocksForDB(backgroundCtx, newFilePaths)
-	if err != nil {
-		cs.logger.Error("Failed to render file blocks for DB",
-			zap.Error(err),
-			zap.Int("file_count", len(newFilePaths)))
-		// Continue without file HTML in DB
-		dbFilesHTML = ""
-	}
+		// Warn when the run's results rest on transformations never saved to disk - non-critical
+		stepsMu.Lock()
+		runSteps := steps
+		stepsMu.Unlock()
+		if runCtx.Err() == nil && len(runSteps) > 0 {
+			cs.warnUnsavedTransformations(sessionID, safeWrite)
+		}
+
+		// Notify about long runs that ended on their own (not stopped or replaced by a new message)
+		if elapsed := time.Since(runStart); runCtx.Err() == nil && cs.notifier.ShouldNotify(elapsed) {
+			outcome := RunOutcomeCompleted
+			if agentStream.Failure() != "" {
+				outcome = RunOutcomeFailed
+			}
+			cs.notifyRunFinished(backgroundCtx, sessionID, outcome, elapsed, safeWrite)
+		}
+
+		lastAssistantMu.Lock()
+		answer := lastAssistantText
+		lastAssistantMu.Unlock()
+		endedOnItsOwn := runCtx.Err() == nil
+
+		// Send end signal - best effort. The client unlocks the input on it but keeps the
+		// stream open for the events sent below. The session is released at the same time,
+		// so a new message neither waits for nor cancels the LLM calls that follow.
+		safeWrite(StreamData{Type: "end"})
+		cs.deregisterRun(sessionID, token)
+
+		// Fold a run that ended on its own into the session abstract, alongside the
+		// follow-up suggestions - non-critical
+		abstractDone := make(chan struct{})
+		go func() {
+			defer close(abstractDone)
+			if endedOnItsOwn {
+				cs.updateSessionAbstract(context.WithoutCancel(backgroundCtx), sessionID, input, answer, safeWrite)
+			}
+		}()
+
+		// Suggest next questions after a run that finished cleanly - non-critical
+		if endedOnItsOwn && agentStream.Failure() == "" {
+			// Like figure descriptions, the LLM call is bounded by its own timeout
+			cs.streamFollowUps(context.WithoutCancel(backgroundCtx), sessionID, input, answer, safeWrite)
+		}
+
+		// Describe new figures for alt text - non-critical, figures without one render without alt
+		var altTexts map[string]string
+		if len(newFilePaths) > 0 {
+			// Each description has its own LLM timeout; keep them off the 30s persistence deadline
+			altTexts = cs.figureService.DescribeFigures(context.WithoutCancel(backgroundCtx), sessionID, newFilePaths, runSteps)
+		}
+		<-abstractDone
+
+		// The LLM calls above may have outlasted backgroundCtx
+		persistCtx, cancelPersist := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancelPersist()
+		// Swap the figures streamed before end for ones with alt text - non-critical
+		if len(altTexts) > 0 {
+			streamFiles(persistCtx, altTexts)
+		}
+
+		// Render file blocks for DB storage - non-critical
+		dbFilesHTML, err := cs.fileService.RenderFileBlocksForDB(persistCtx, newFilePaths, altTexts)
+		if err != nil {
+			cs.logger.Error("Failed to render file blocks for DB",
+				zap.Error(err),
+				zap.Int("file_count", len(newFilePaths)))
+			// Continue without file HTML in DB
+			dbFilesHTML = ""
+		}
 
-	if dbFilesHTML != "" {
 		lastAssistantMu.Lock()
 		assistantID := lastAssistantID
 		lastAssistantMu.Unlock()
-		if assistantID != "" {
-			if err := cs.messageService.AppendFilesToMessage(backgroundCtx, assistantID, dbFilesHTML); err != nil {
+		if dbFilesHTML != "" && assistantID != "" {
+			if err := cs.messageService.AppendFilesToMessage(persistCtx, assistantID, dbFilesHTML); err != nil {
 				cs.logger.Error("Failed to append files HTML to assistant message",
 					zap.Error(err),
 					zap.String("message_id", assistantID))
 			}
 		}
+
+		// Link the run's files to the answer that shows them, for the plot gallery - non-critical
+		if len(newFilePaths) > 0 && assistantID != "" {
+			if err := cs.fileService.LinkFilesToMessage(persistCtx, sessionID, newFilePaths, assistantID); err != nil {
+				cs.logger.Warn("Failed to link files to assistant message",
+					zap.Error(err),
+					zap.String("message_id", assistantID))
+			}
+		}
+	}()
+
+	select {
+	case <-finished:
+	case <-conn.Done():
+		cs.logger.Info("SSE connection closed before run finished, releasing handler",
+			zap.String("session_id", sessionID),
+			zap.String("user_message_id", userMessageID))
 	}
 }
 
 // streamDocumentResponse handles document Q&A mode without code execution
 func (cs *ChatService) streamDocumentResponse(
 	ctx context.Context,
-	w http.ResponseWriter,
+	conn StreamConn,
 	input string,
 	userMessageID string,
 	sessionID string,
 	history []types.AgentMessage,
 ) {
 	agentMessageID := uuid.New().String()
-	var writeMu sync.Mutex
-	runCtx, cancelRun := context.WithCancel(context.Background())
+	// The run outlives the stream request but stays in its trace
+	runCtx, cancelRun := context.WithCancel(tracing.Detach(ctx))
 	token := cs.registerRun(sessionID, cancelRun, userMessageID)
-	defer func() {
+	finishRun := func() {
 		cancelRun()
 		cs.deregisterRun(sessionID, token)
-	}()
+	}
 	var sseActive atomic.Bool
 	sseActive.Store(true)
 
@@ -428,7 +919,7 @@ func (cs *ChatService) streamDocumentResponse(
 		if !sseActive.Load() {
 			return
 		}
-		if err := cs.streamService.WriteSSEData(ctx, w, data, &writeMu); err != nil {
+		if err := conn.Write(data); err != nil {
 			if sseActive.CompareAndSwap(true, false) {
 				cs.logger.Info("SSE stream closed, continuing document response in background",
 					zap.Error(err),
@@ -438,15 +929,25 @@ func (cs *ChatService) streamDocumentResponse(
 		}
 	}
 
+	// Screen streamed output; a blocked response ends the run
+	guard := cs.contentFilter.NewStreamGuard(sessionID, func(verdict ContentVerdict) {
+		safeWrite(contentFilterEvent(verdict))
+		if verdict.Action == ContentFilterBlock {
+			cancelRun()
+		}
+	})
+
 	// Send initial SSE messages
 	safeWrite(StreamData{Type: "remove_loader", Content: "loading-" + userMessageID})
 	safeWrite(StreamData{Type: "create_container", Content: agentMessageID})
 
 	pipeReader, pipeWriter := io.Pipe()
-	defer pipeReader.Close()
 
 	var captureBuffer bytes.Buffer
 
+	var lastAnswerMu sync.Mutex
+	var lastAnswer string
+
 	// Document mode uses simpler persistence (no tool messages)
 	persist := func(assistant string, tool *string) {
 		assistant = strings.TrimSpace(assistant)
@@ -457,21 +958,35 @@ func (cs *ChatService) streamDocumentResponse(
 		ctxPersist, cancelPersist := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancelPersist()
 
+		assistant = guard.Message(ctxPersist, assistant)
+
 		// Document mode: only save assistant messages (no tools)
-		_, err := cs.messageService.SaveAssistantAndTool(ctxPersist, sessionID, assistant, nil, "")
+		citations := cs.memoryCitations(sessionID, assistant)
+		id, err := cs.messageService.SaveCitedAssistantAndTool(ctxPersist, sessionID, assistant, nil, "", citations)
 		if err != nil {
 			cs.logger.Error("Document mode message persistence failed",
 				zap.Error(err),
 				zap.String("session_id", sessionID))
+			return
 		}
+		cs.streamMemoryFootnotes(ctxPersist, id, citations, safeWrite)
+		lastAnswerMu.Lock()
+		lastAnswer = assistant
+		lastAnswerMu.Unlock()
 	}
 
 	agentStream := agent.NewStream(&captureBuffer, pipeWriter, persist)
+	agentStream.SetEventHandler(func(eventType, payload string) {
+		safeWrite(StreamData{Type: eventType, Content: payload})
+	})
 
 	streamDone := make(chan struct{})
 	go func() {
 		defer close(streamDone)
 		cs.streamService.ProcessStreamByWord(runCtx, pipeReader, func(data StreamData) error {
+			if data.Type == "chunk" && !guard.Chunk(data.Content) {
+				return nil
+			}
 			safeWrite(data)
 			return nil
 		})
@@ -490,11 +1005,34 @@ func (cs *ChatService) streamDocumentResponse(
 		pipeWriter.CloseWithError(runCtx.Err())
 	}()
 
-	<-agentDone
-	<-streamDone
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer finishRun()
+		defer pipeReader.Close()
+
+		<-agentDone
+		<-streamDone
+
+		agentStream.Finalize()
 
-	agentStream.Finalize()
+		endedOnItsOwn := runCtx.Err() == nil
 
-	// Send end signal
-	safeWrite(StreamData{Type: "end"})
+		// Send end signal and release the session; the abstract follows on the open stream
+		safeWrite(StreamData{Type: "end"})
+		cs.deregisterRun(sessionID, token)
+
+		// Fold an answer that completed into the session abstract - non-critical
+		if endedOnItsOwn {
+			lastAnswerMu.Lock()
+			answer := lastAnswer
+			lastAnswerMu.Unlock()
+			cs.updateSessionAbstract(context.WithoutCancel(runCtx), sessionID, input, answer, safeWrite)
+		}
+	}()
+
+	select {
+	case <-finished:
+	case <-conn.Done():
+	}
 }
@@ -14,13 +14,13 @@ import (
 
 // CleanupService handles session and workspace cleanup operations
 type CleanupService struct {
-	store  *database.PostgresStore
+	store  database.Store
 	agent  *agent.Agent
 	logger *zap.Logger
 }
 
 // NewCleanupService creates a new cleanup service instance
-func NewCleanupService(store *database.PostgresStore, agent *agent.Agent, logger *zap.Logger) *CleanupService {
+func NewCleanupService(store database.Store, agent *agent.Agent, logger *zap.Logger) *CleanupService {
 	return &CleanupService{
 		store:  store,
 		agent:  agent,
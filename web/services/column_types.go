@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"stats-agent/tools"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrUnknownDataset is returned when a dataset is not one of the session's files.
+var ErrUnknownDataset = errors.New("unknown dataset")
+
+// ErrInvalidColumnType is returned when a submitted column type is not one of types.ColumnTypes.
+var ErrInvalidColumnType = errors.New("invalid column type")
+
+// SessionColumnTypes loads the session's column type overrides into the agent once per
+// process, so the prompt carries them after a restart.
+func (cs *ChatService) SessionColumnTypes(ctx context.Context, sessionID uuid.UUID) error {
+	id := sessionID.String()
+	if cs.agent.HasSessionColumnTypes(id) {
+		return nil
+	}
+	overrides, err := cs.store.GetColumnTypeOverrides(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("failed to load column type overrides: %w", err)
+	}
+	cs.agent.SetSessionColumnTypes(id, overrides)
+	return nil
+}
+
+// SessionColumnLevels profiles the session's datasets that aren't in the agent's level
+// dictionary yet, so levels mentioned by the user resolve to their columns and every
+// dataset is in the agent's dataset registry. A dataset that fails to profile is
+// recorded empty rather than retried on every message.
+func (cs *ChatService) SessionColumnLevels(ctx context.Context, sessionID uuid.UUID) error {
+	id := sessionID.String()
+	datasets, err := cs.sessionDatasets(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	for _, dataset := range datasets {
+		if cs.agent.HasColumnLevels(id, dataset) {
+			continue
+		}
+		if _, err := cs.agent.DatasetColumns(ctx, id, dataset); err != nil {
+			cs.logger.Warn("Failed to profile dataset levels",
+				zap.Error(err),
+				zap.String("session_id", id),
+				zap.String("dataset", dataset))
+			cs.agent.RegisterColumnLevels(id, dataset, nil)
+			cs.agent.RegisterDataset(id, dataset, nil)
+		}
+	}
+	return nil
+}
+
+// sessionDatasets lists the session's uploaded tabular files.
+func (cs *ChatService) sessionDatasets(ctx context.Context, sessionID uuid.UUID) ([]string, error) {
+	files, err := cs.store.GetFilesBySession(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session files: %w", err)
+	}
+	var datasets []string
+	for _, f := range files {
+		if tools.IsDatasetFile(f.Filename) {
+			datasets = append(datasets, f.Filename)
+		}
+	}
+	return datasets, nil
+}
+
+// ColumnTypes returns the inferred columns and overrides of one of the session's datasets
+// (the first uploaded one when dataset is empty). Inference runs in the session's Python
+// executor, so it is refused while the agent is running.
+func (cs *ChatService) ColumnTypes(ctx context.Context, sessionID uuid.UUID, dataset string) (*types.DatasetColumnTypes, error) {
+	view := &types.DatasetColumnTypes{}
+	datasets, err := cs.sessionDatasets(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	view.Datasets = datasets
+	if len(view.Datasets) == 0 {
+		return view, nil
+	}
+
+	view.Dataset = view.Datasets[0]
+	if dataset != "" {
+		found := false
+		for _, d := range view.Datasets {
+			found = found || d == dataset
+		}
+		if !found {
+			return nil, ErrUnknownDataset
+		}
+		view.Dataset = dataset
+	}
+
+	if running, _ := cs.GetActiveRun(sessionID.String()); running {
+		return nil, ErrRunInProgress
+	}
+	view.Columns, err = cs.agent.DatasetColumns(ctx, sessionID.String(), view.Dataset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to infer column types: %w", err)
+	}
+
+	overrides, err := cs.store.GetColumnTypeOverrides(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load column type overrides: %w", err)
+	}
+	view.Overrides = overrides[view.Dataset]
+	return view, nil
+}
+
+// SetColumnTypes saves the user's column types for a dataset. Only types that differ
+// from the inferred ones are stored as overrides. The agent's prompt and the dataset's
+// profiling state card are updated right away.
+func (cs *ChatService) SetColumnTypes(ctx context.Context, sessionID uuid.UUID, dataset string, columnTypes map[string]string) (*types.DatasetColumnTypes, error) {
+	for col, t := range columnTypes {
+		if !types.IsValidColumnType(t) {
+			return nil, fmt.Errorf("%w %q for column %s", ErrInvalidColumnType, t, col)
+		}
+	}
+
+	view, err := cs.ColumnTypes(ctx, sessionID, dataset)
+	if err != nil {
+		return nil, err
+	}
+	if view.Dataset == "" {
+		return nil, ErrUnknownDataset
+	}
+
+	overrides := make(map[string]string)
+	for _, col := range view.Columns {
+		if t, ok := columnTypes[col.Name]; ok && t != col.Inferred {
+			overrides[col.Name] = t
+		}
+	}
+	if err := cs.store.SetColumnTypeOverrides(ctx, sessionID, view.Dataset, overrides); err != nil {
+		return nil, err
+	}
+	view.Overrides = overrides
+
+	all, err := cs.store.GetColumnTypeOverrides(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reload column type overrides: %w", err)
+	}
+	cs.agent.SetSessionColumnTypes(sessionID.String(), all)
+	cs.agent.RefreshColumnProfile(ctx, sessionID.String(), view.Dataset, view.Columns, overrides)
+	return view, nil
+}
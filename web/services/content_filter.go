@@ -0,0 +1,281 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"stats-agent/config"
+
+	"go.uber.org/zap"
+)
+
+// Content filter actions (CONTENT_FILTER_ACTION).
+const (
+	ContentFilterBlock = "block"
+	ContentFilterWarn  = "warn"
+	ContentFilterLog   = "log"
+)
+
+// contentFilterWindow is how much already-streamed output is kept so a pattern that spans
+// chunk boundaries still matches.
+const contentFilterWindow = 512
+
+// contentWithheldNotice replaces blocked assistant output in the stored conversation.
+const contentWithheldNotice = "_This response was withheld by the content filter._"
+
+// ErrContentBlocked is returned for user messages the content filter blocked.
+var ErrContentBlocked = errors.New("message blocked by the content filter")
+
+// ContentClassifier scores text for unsafe content. Implementations other than
+// HTTPContentClassifier can be passed to NewContentFilter.
+type ContentClassifier interface {
+	// Classify returns a score in [0, 1] and the category it refers to ("" when the
+	// classifier does not name one).
+	Classify(ctx context.Context, text string) (float64, string, error)
+}
+
+// HTTPContentClassifier calls a classifier service that accepts {"text": "..."} and
+// answers {"score": 0.93, "label": "toxicity"}.
+type HTTPContentClassifier struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewHTTPContentClassifier returns a classifier for the service at url. Requests are
+// bounded by the caller's context.
+func NewHTTPContentClassifier(url string) *HTTPContentClassifier {
+	return &HTTPContentClassifier{url: url, httpClient: &http.Client{}}
+}
+
+// Classify sends text to the classifier service.
+func (c *HTTPContentClassifier) Classify(ctx context.Context, text string) (float64, string, error) {
+	payload, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to encode classifier request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create classifier request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("classifier request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return 0, "", fmt.Errorf("classifier returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Score float64 `json:"score"`
+		Label string  `json:"label"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, "", fmt.Errorf("failed to decode classifier response: %w", err)
+	}
+	return result.Score, result.Label, nil
+}
+
+// ContentVerdict is the outcome of a content filter check.
+type ContentVerdict struct {
+	Flagged bool
+	// Action is the configured action for flagged content
+	Action string
+	// Reason names the matched pattern or the classifier label, for logs
+	Reason string
+}
+
+// ContentFilter screens user messages and model output against the CONTENT_FILTER_*
+// settings: regex patterns first, then the optional classifier.
+type ContentFilter struct {
+	action     string
+	patterns   []*regexp.Regexp
+	classifier ContentClassifier
+	threshold  float64
+	timeout    time.Duration
+	logger     *zap.Logger
+}
+
+// NewContentFilter builds the filter. Returns nil when filtering is disabled; a nil
+// *ContentFilter passes all content.
+func NewContentFilter(cfg *config.Config, classifier ContentClassifier, logger *zap.Logger) *ContentFilter {
+	if !cfg.ContentFilterEnabled {
+		return nil
+	}
+	f := &ContentFilter{
+		action:     cfg.ContentFilterAction,
+		classifier: classifier,
+		threshold:  cfg.ContentFilterThreshold,
+		timeout:    cfg.ContentFilterTimeout,
+		logger:     logger,
+	}
+	for _, pattern := range cfg.ContentFilterPatterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			// Rejected by config validation; kept for filters built from other configs
+			logger.Warn("Skipping invalid content filter pattern", zap.String("pattern", pattern), zap.Error(err))
+			continue
+		}
+		f.patterns = append(f.patterns, re)
+	}
+	return f
+}
+
+// Screen checks text from source ("input" or "output") and logs hits. Classifier
+// failures are logged and the text is judged by the patterns alone.
+func (f *ContentFilter) Screen(ctx context.Context, sessionID, source, text string) ContentVerdict {
+	if f == nil || strings.TrimSpace(text) == "" {
+		return ContentVerdict{}
+	}
+	if reason, ok := f.matchPatterns(text); ok {
+		return f.flag(sessionID, source, reason)
+	}
+	if f.classifier == nil {
+		return ContentVerdict{}
+	}
+
+	classifyCtx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	score, label, err := f.classifier.Classify(classifyCtx, text)
+	if err != nil {
+		f.logger.Warn("Content classifier failed, using patterns only",
+			zap.Error(err),
+			zap.String("session_id", sessionID),
+			zap.String("source", source))
+		return ContentVerdict{}
+	}
+	if score < f.threshold {
+		return ContentVerdict{}
+	}
+	if label == "" {
+		label = "classifier"
+	}
+	return f.flag(sessionID, source, fmt.Sprintf("%s (score %.2f)", label, score))
+}
+
+func (f *ContentFilter) matchPatterns(text string) (string, bool) {
+	for _, re := range f.patterns {
+		if re.MatchString(text) {
+			return re.String(), true
+		}
+	}
+	return "", false
+}
+
+func (f *ContentFilter) flag(sessionID, source, reason string) ContentVerdict {
+	f.logger.Warn("Content filter flagged text",
+		zap.String("session_id", sessionID),
+		zap.String("source", source),
+		zap.String("reason", reason),
+		zap.String("action", f.action))
+	return ContentVerdict{Flagged: true, Action: f.action, Reason: reason}
+}
+
+// ContentStreamGuard screens one run's output. Streamed chunks are matched against the
+// patterns as they arrive; complete assistant messages also go through the classifier
+// before they are stored. notify is called once, on the first hit that should be shown
+// to the user (block or warn).
+type ContentStreamGuard struct {
+	filter    *ContentFilter
+	sessionID string
+	notify    func(ContentVerdict)
+
+	mu       sync.Mutex
+	tail     string
+	blocked  bool
+	notified bool
+}
+
+// NewStreamGuard returns a guard for one run. Returns nil for a nil filter; a nil guard
+// passes all output.
+func (f *ContentFilter) NewStreamGuard(sessionID string, notify func(ContentVerdict)) *ContentStreamGuard {
+	if f == nil {
+		return nil
+	}
+	return &ContentStreamGuard{filter: f, sessionID: sessionID, notify: notify}
+}
+
+// Chunk screens a streamed chunk and reports whether it may be forwarded to the client.
+// Once output is blocked no further chunks are forwarded.
+func (g *ContentStreamGuard) Chunk(content string) bool {
+	if g == nil {
+		return true
+	}
+	g.mu.Lock()
+	if g.blocked {
+		g.mu.Unlock()
+		return false
+	}
+	window := g.tail + content
+	var verdict ContentVerdict
+	for _, re := range g.filter.patterns {
+		if loc := re.FindStringIndex(window); loc != nil {
+			verdict = g.filter.flag(g.sessionID, "output", re.String())
+			// Drop the match so the next chunk doesn't report it again
+			window = window[loc[1]:]
+			break
+		}
+	}
+	if len(window) > contentFilterWindow {
+		window = window[len(window)-contentFilterWindow:]
+	}
+	g.tail = window
+	g.mu.Unlock()
+
+	if verdict.Flagged {
+		g.report(verdict)
+		return verdict.Action != ContentFilterBlock
+	}
+	return true
+}
+
+// Message screens a complete assistant message before it is stored and returns the text
+// to store: the withheld notice once output is blocked, otherwise the message itself.
+func (g *ContentStreamGuard) Message(ctx context.Context, text string) string {
+	if g == nil || strings.TrimSpace(text) == "" {
+		return text
+	}
+	g.mu.Lock()
+	blocked := g.blocked
+	g.mu.Unlock()
+	if !blocked {
+		if verdict := g.filter.Screen(ctx, g.sessionID, "output", text); verdict.Flagged {
+			g.report(verdict)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.blocked {
+		return contentWithheldNotice
+	}
+	return text
+}
+
+func (g *ContentStreamGuard) report(verdict ContentVerdict) {
+	g.mu.Lock()
+	if verdict.Action == ContentFilterBlock {
+		g.blocked = true
+	}
+	first := !g.notified && verdict.Action != ContentFilterLog
+	if first {
+		g.notified = true
+	}
+	g.mu.Unlock()
+
+	if first && g.notify != nil {
+		g.notify(verdict)
+	}
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"strings"
+
+	"stats-agent/agent"
+	"stats-agent/web/templates/components"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ProfileUpload profiles a dataset the user just uploaded and adds the profile to the
+// upload's messages: as a <dataset_profile> block for the agent, so its first turn starts
+// from the shape of the data, and as a collapsed card for the user. Failures are logged
+// and leave the messages unchanged.
+func (cs *ChatService) ProfileUpload(ctx context.Context, sessionID uuid.UUID, upload *UploadResult) {
+	id := sessionID.String()
+	if running, _ := cs.GetActiveRun(id); running {
+		return
+	}
+	profile, err := cs.agent.ProfileDataset(ctx, id, upload.Filename)
+	if err != nil {
+		cs.logger.Warn("Failed to profile uploaded dataset",
+			zap.Error(err),
+			zap.String("session_id", id),
+			zap.String("dataset", upload.Filename))
+		return
+	}
+
+	var card bytes.Buffer
+	if err := components.DatasetProfileCard(profile).Render(ctx, &card); err != nil {
+		cs.logger.Warn("Failed to render dataset profile card", zap.Error(err), zap.String("session_id", id))
+		return
+	}
+	if upload.DisplayMessage == "" {
+		upload.DisplayMessage = strings.ReplaceAll(html.EscapeString(upload.ContentMessage), "\n", "<br>")
+	}
+	upload.DisplayMessage += card.String()
+	upload.ContentMessage += "\n\n<dataset_profile>\n" + agent.FormatDatasetProfile(profile) +
+		"\n</dataset_profile>\nThis profile was computed on upload; start from it instead of re-running df.info() or df.describe()."
+}
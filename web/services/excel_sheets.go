@@ -0,0 +1,279 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"stats-agent/web/types"
+)
+
+const (
+	// maxSheetPreviewRows is how many rows after the header each sheet preview shows.
+	maxSheetPreviewRows = 3
+	// maxListedSheets caps how many sheets of a workbook the upload message describes.
+	maxListedSheets = 20
+	// maxPreviewCellChars truncates long cells in sheet previews (not in the header).
+	maxPreviewCellChars = 40
+)
+
+// ReadExcelSheets lists the worksheets of an .xlsx workbook in workbook order, with each
+// sheet's header row, data row count and first rows. The file is read as the Office Open
+// XML zip it is, so no spreadsheet library is needed; legacy .xls files are not supported.
+// Cells are shown as stored: dates are Excel serial numbers and formulas their cached value.
+func ReadExcelSheets(filePath string) ([]types.ExcelSheet, error) {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open workbook: %w", err)
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var workbook struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"id,attr"` // r:id, resolved through the workbook relationships
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodeZipXML(files, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodeZipXML(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		// Targets are relative to xl/ unless absolute within the package
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+
+	shared, err := readSharedStrings(files)
+	if err != nil {
+		return nil, err
+	}
+
+	sheets := make([]types.ExcelSheet, 0, len(workbook.Sheets))
+	for _, s := range workbook.Sheets {
+		if len(sheets) == maxListedSheets {
+			break
+		}
+		f, ok := files[targets[s.RID]]
+		if !ok {
+			return nil, fmt.Errorf("sheet %q not found in workbook", s.Name)
+		}
+		sheet, err := readSheet(f, shared)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sheet %q: %w", s.Name, err)
+		}
+		sheet.Name = s.Name
+		sheets = append(sheets, sheet)
+	}
+	return sheets, nil
+}
+
+// FormatExcelSheets renders a workbook's sheets as plain text for the agent: one line per
+// sheet with its size and columns, followed by its first rows.
+func FormatExcelSheets(filename string, sheets []types.ExcelSheet) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s has %d sheets:\n", filename, len(sheets))
+	for _, s := range sheets {
+		fmt.Fprintf(&b, "- %q: %d rows × %d columns", s.Name, s.Rows, len(s.Header))
+		if len(s.Header) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(s.Header, ", "))
+		}
+		b.WriteString("\n")
+		for _, row := range s.Preview {
+			fmt.Fprintf(&b, "    %s\n", strings.Join(row, " | "))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func decodeZipXML(files map[string]*zip.File, name string, v any) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("workbook is missing %s", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// readSharedStrings returns the workbook's shared string table; workbooks with inline
+// strings only have none.
+func readSharedStrings(files map[string]*zip.File) ([]string, error) {
+	if _, ok := files["xl/sharedStrings.xml"]; !ok {
+		return nil, nil
+	}
+	var sst struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := decodeZipXML(files, "xl/sharedStrings.xml", &sst); err != nil {
+		return nil, err
+	}
+	strs := make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		if len(item.Runs) == 0 {
+			strs[i] = item.Text
+			continue
+		}
+		// Rich text is split into formatting runs
+		var b strings.Builder
+		for _, r := range item.Runs {
+			b.WriteString(r.Text)
+		}
+		strs[i] = b.String()
+	}
+	return strs, nil
+}
+
+// sheetCell is a <c> element of a worksheet.
+type sheetCell struct {
+	Ref    string `xml:"r,attr"`
+	Type   string `xml:"t,attr"`
+	Value  string `xml:"v"`
+	Inline struct {
+		Text string `xml:"t"`
+	} `xml:"is"`
+}
+
+// readSheet streams a worksheet, keeping the header and preview rows and counting the
+// rest. The first row with a value is the header; empty rows are not counted.
+func readSheet(f *zip.File, shared []string) (types.ExcelSheet, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return types.ExcelSheet{}, err
+	}
+	defer rc.Close()
+
+	var sheet types.ExcelSheet
+	dec := xml.NewDecoder(rc)
+	var row []string
+	inRow := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return types.ExcelSheet{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				inRow, row = true, nil
+			case "c":
+				if !inRow {
+					continue
+				}
+				var c sheetCell
+				if err := dec.DecodeElement(&c, &t); err != nil {
+					return types.ExcelSheet{}, err
+				}
+				value := cellValue(c, shared)
+				if value == "" {
+					continue
+				}
+				col := len(row)
+				if idx, ok := cellColumn(c.Ref); ok {
+					col = idx
+				}
+				for len(row) <= col {
+					row = append(row, "")
+				}
+				row[col] = value
+			}
+		case xml.EndElement:
+			if t.Name.Local != "row" || !inRow {
+				continue
+			}
+			inRow = false
+			if len(row) == 0 {
+				continue
+			}
+			if sheet.Header == nil {
+				sheet.Header = row
+				continue
+			}
+			sheet.Rows++
+			if len(sheet.Preview) < maxSheetPreviewRows {
+				for i, v := range row {
+					if runes := []rune(v); len(runes) > maxPreviewCellChars {
+						row[i] = string(runes[:maxPreviewCellChars-1]) + "…"
+					}
+				}
+				sheet.Preview = append(sheet.Preview, row)
+			}
+		}
+	}
+	return sheet, nil
+}
+
+func cellValue(c sheetCell, shared []string) string {
+	var value string
+	switch c.Type {
+	case "s":
+		idx, err := strconv.Atoi(strings.TrimSpace(c.Value))
+		if err != nil || idx < 0 || idx >= len(shared) {
+			return ""
+		}
+		value = shared[idx]
+	case "inlineStr":
+		value = c.Inline.Text
+	case "b":
+		value = "FALSE"
+		if c.Value == "1" {
+			value = "TRUE"
+		}
+	default:
+		value = c.Value
+	}
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// cellColumn returns the zero-based column of a cell reference such as "AB12".
+func cellColumn(ref string) (int, bool) {
+	col := 0
+	n := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return col - 1, true
+}
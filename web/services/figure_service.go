@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"stats-agent/config"
+	"stats-agent/database"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ExecutedStep is one code cell of a run and the output it printed.
+type ExecutedStep struct {
+	Code   string
+	Output string
+}
+
+// FigureService generates alt text for figures captured during a run.
+type FigureService struct {
+	cfg       *config.Config
+	store     database.Store
+	ragGetter RAGGetter
+	logger    *zap.Logger
+}
+
+func NewFigureService(cfg *config.Config, store database.Store, ragGetter RAGGetter, logger *zap.Logger) *FigureService {
+	return &FigureService{
+		cfg:       cfg,
+		store:     store,
+		ragGetter: ragGetter,
+		logger:    logger,
+	}
+}
+
+// DescribeFigures generates, stores and indexes alt text for the images among filePaths,
+// using the run step that most likely produced each one. Returns alt text keyed by web
+// path; figures that could not be described are omitted.
+func (fs *FigureService) DescribeFigures(ctx context.Context, sessionID string, filePaths []string, steps []ExecutedStep) map[string]string {
+	altTexts := make(map[string]string)
+	if !fs.cfg.FigureAltTextEnabled || len(steps) == 0 {
+		return altTexts
+	}
+	ragInstance := fs.ragGetter.GetRAG()
+	if ragInstance == nil {
+		return altTexts
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return altTexts
+	}
+
+	for _, filePath := range filePaths {
+		if !isImagePath(filePath) {
+			continue
+		}
+		filename := path.Base(filePath)
+		step := generatingStep(filename, steps)
+
+		describeCtx, cancel := context.WithTimeout(ctx, fs.cfg.LLMRequestTimeout)
+		altText, err := ragInstance.DescribeFigure(describeCtx, filename, step.Code, step.Output)
+		cancel()
+		if err != nil {
+			fs.logger.Warn("Failed to generate figure alt text",
+				zap.Error(err),
+				zap.String("session_id", sessionID),
+				zap.String("filename", filename))
+			continue
+		}
+		altTexts[filePath] = altText
+
+		if err := fs.store.SetFileAltText(ctx, sessionUUID, filename, altText); err != nil {
+			fs.logger.Warn("Failed to store figure alt text",
+				zap.Error(err),
+				zap.String("filename", filename))
+		}
+		if err := ragInstance.StoreFigureDescription(ctx, sessionID, filename, altText); err != nil {
+			fs.logger.Warn("Failed to index figure description",
+				zap.Error(err),
+				zap.String("filename", filename))
+		}
+	}
+	return altTexts
+}
+
+// generatingStep picks the latest step whose code names the figure's file (without
+// extension, since it is often built from a variable), falling back to the latest step.
+func generatingStep(filename string, steps []ExecutedStep) ExecutedStep {
+	stem := strings.TrimSuffix(filename, path.Ext(filename))
+	for i := len(steps) - 1; i >= 0; i-- {
+		if stem != "" && strings.Contains(steps[i].Code, stem) {
+			return steps[i]
+		}
+	}
+	return steps[len(steps)-1]
+}
+
+// isPlotlyPath reports whether p is a Plotly figure saved by the executor's fig.show().
+func isPlotlyPath(p string) bool {
+	return strings.HasSuffix(strings.ToLower(p), ".plotly.json")
+}
+
+func isImagePath(p string) bool {
+	switch strings.ToLower(path.Ext(p)) {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return true
+	default:
+		return false
+	}
+}
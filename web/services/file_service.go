@@ -5,8 +5,10 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"stats-agent/database"
+	"stats-agent/tools"
 	"stats-agent/web/templates/components"
 	"strings"
 	"time"
@@ -17,11 +19,11 @@ import (
 )
 
 type FileService struct {
-	store  *database.PostgresStore
+	store  database.Store
 	logger *zap.Logger
 }
 
-func NewFileService(store *database.PostgresStore, logger *zap.Logger) *FileService {
+func NewFileService(store database.Store, logger *zap.Logger) *FileService {
 	return &FileService{
 		store:  store,
 		logger: logger,
@@ -96,17 +98,7 @@ func (fs *FileService) GetAndMarkNewFiles(ctx context.Context, sessionID string)
 				continue
 			}
 
-			// Determine file type
-			ext := strings.ToLower(filepath.Ext(sanitizedFileName))
-			fileType := "other"
-			switch ext {
-			case ".png", ".jpg", ".jpeg", ".gif":
-				fileType = "image"
-			case ".csv", ".xls", ".xlsx":
-				fileType = "csv"
-			case ".pdf":
-				fileType = "pdf"
-			}
+			fileType := workspaceFileType(sanitizedFileName)
 
 			// Create file record in database with sanitized name
 			webPath := filepath.ToSlash(filepath.Join("/workspaces", sessionID, sanitizedFileName))
@@ -134,6 +126,41 @@ func (fs *FileService) GetAndMarkNewFiles(ctx context.Context, sessionID string)
 	return newFilePaths, nil
 }
 
+// LinkFilesToMessage records the assistant message that displays a run's new files, so
+// the plot gallery can point back to the answer a figure belongs to.
+func (fs *FileService) LinkFilesToMessage(ctx context.Context, sessionID string, filePaths []string, messageID string) error {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return fmt.Errorf("invalid session ID: %w", err)
+	}
+	messageUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		return fmt.Errorf("invalid message ID: %w", err)
+	}
+	filenames := make([]string, 0, len(filePaths))
+	for _, p := range filePaths {
+		filenames = append(filenames, path.Base(p))
+	}
+	return fs.store.LinkFilesToMessage(ctx, sessionUUID, filenames, messageUUID)
+}
+
+// workspaceFileType classifies a workspace file by name as recorded in the files table:
+// plot, image, csv (tabular data: Excel, Parquet and gzipped CSV included), pdf or other.
+func workspaceFileType(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch {
+	case isPlotlyPath(filename):
+		return "plot"
+	case ext == ".png", ext == ".jpg", ext == ".jpeg", ext == ".gif":
+		return "image"
+	case tools.IsDatasetFile(filename):
+		return "csv"
+	case ext == ".pdf":
+		return "pdf"
+	}
+	return "other"
+}
+
 // sanitizeOutputFilename sanitizes filenames created by Python to be web-safe.
 // Replaces special characters with safe alternatives instead of URL encoding.
 func sanitizeOutputFilename(filename string) string {
@@ -196,7 +223,8 @@ func replaceSpecialCharsForOutput(s string) string {
 }
 
 // RenderFileBlocksForDB renders file blocks to a raw HTML string for database persistence.
-func (fs *FileService) RenderFileBlocksForDB(ctx context.Context, filePaths []string) (string, error) {
+// altTexts holds generated alt text for images, keyed by path.
+func (fs *FileService) RenderFileBlocksForDB(ctx context.Context, filePaths []string, altTexts map[string]string) (string, error) {
 	if len(filePaths) == 0 {
 		return "", nil
 	}
@@ -206,10 +234,15 @@ func (fs *FileService) RenderFileBlocksForDB(ctx context.Context, filePaths []st
 		var buf bytes.Buffer
 		var component templ.Component
 		ext := strings.ToLower(filepath.Ext(path))
-		switch ext {
-		case ".png", ".jpg", ".jpeg", ".gif":
-			component = components.ImageBlock(path)
-		case ".csv", ".xls", ".xlsx", ".pdf":
+		switch {
+		case isPlotlyPath(path):
+			fallback := components.PlotlyFallback(path, filePaths)
+			component = components.PlotlyBlock(path, fallback, altTexts[fallback])
+		case components.IsPlotlyFallback(path, filePaths):
+			component = nil // Rendered inside its figure's block
+		case ext == ".png", ext == ".jpg", ext == ".jpeg", ext == ".gif":
+			component = components.ImageBlock(path, altTexts[path])
+		case tools.IsDatasetFile(path), ext == ".pdf":
 			component = components.FileBlock(path)
 		default:
 			component = nil // Ignore other file types
@@ -226,13 +259,13 @@ func (fs *FileService) RenderFileBlocksForDB(ctx context.Context, filePaths []st
 }
 
 // RenderFileOOBWrapper renders the out-of-band file wrapper for SSE streaming.
-func (fs *FileService) RenderFileOOBWrapper(ctx context.Context, fileContainerID string, filePaths []string) (string, error) {
+func (fs *FileService) RenderFileOOBWrapper(ctx context.Context, fileContainerID string, filePaths []string, altTexts map[string]string) (string, error) {
 	if len(filePaths) == 0 {
 		return "", nil
 	}
 
 	var buf bytes.Buffer
-	if err := components.FileOOBWrapper(fileContainerID, filePaths).Render(ctx, &buf); err != nil {
+	if err := components.FileOOBWrapper(fileContainerID, filePaths, altTexts).Render(ctx, &buf); err != nil {
 		return "", fmt.Errorf("failed to render file OOB wrapper: %w", err)
 	}
 	return buf.String(), nil
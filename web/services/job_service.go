@@ -0,0 +1,492 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"stats-agent/config"
+	"stats-agent/database"
+	"stats-agent/tracing"
+	"stats-agent/web/problem"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrUnknownJob is returned for a job that is not in the session.
+var ErrUnknownJob = errors.New("unknown job")
+
+// jobSaveInterval is how often a running job's events are saved, so a restart loses at
+// most this much of its output.
+const jobSaveInterval = 5 * time.Second
+
+// maxListedJobs caps the session's job list.
+const maxListedJobs = 20
+
+// jobStaleAfter is how long a running job can go unsaved before it is treated as
+// interrupted: its replica died (MULTI_REPLICA_ENABLED).
+const jobStaleAfter = 3 * jobSaveInterval
+
+// jobSweepInterval is how often replicas look for jobs interrupted on another replica.
+const jobSweepInterval = time.Minute
+
+// JobService runs agent turns in the background, detached from the request that started
+// them. Each job records the turn's stream events and saves them as it goes, so clients
+// can reattach to a running job, replay a finished one, and get the usual webhook/email
+// notification when it completes. With MULTI_REPLICA_ENABLED, a job running on another
+// replica is followed through its saved events.
+type JobService struct {
+	store        database.Store
+	chat         *ChatService
+	notifier     *NotificationService
+	multiReplica bool
+	logger       *zap.Logger
+
+	mu   sync.Mutex
+	jobs map[uuid.UUID]*jobRun
+}
+
+// NewJobService creates a job service.
+func NewJobService(store database.Store, chat *ChatService, notifier *NotificationService, cfg *config.Config, logger *zap.Logger) *JobService {
+	return &JobService{
+		store:        store,
+		chat:         chat,
+		notifier:     notifier,
+		multiReplica: cfg.MultiReplicaEnabled,
+		logger:       logger,
+		jobs:         make(map[uuid.UUID]*jobRun),
+	}
+}
+
+// jobRun is the StreamConn a job's turn writes to. It keeps every event so followers can
+// replay from the start, and wakes them by closing changed on each write. Done is only
+// closed once the turn has returned, so the run never gives up on its connection.
+type jobRun struct {
+	mu       sync.Mutex
+	job      types.AnalysisJob
+	events   []StreamData
+	changed  chan struct{}
+	done     chan struct{}
+	finished bool
+	sawEnd   bool
+	problems []string
+}
+
+func newJobRun(job types.AnalysisJob) *jobRun {
+	return &jobRun{job: job, changed: make(chan struct{}), done: make(chan struct{})}
+}
+
+// Write records an event and wakes the followers. Writes after the job finished (a late
+// title update) are dropped.
+func (r *jobRun) Write(data StreamData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		return nil
+	}
+	r.events = append(r.events, data)
+	switch data.Type {
+	case "end":
+		r.sawEnd = true
+	case "error":
+		r.problems = append(r.problems, problemDetail(data.Content))
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+	return nil
+}
+
+// Done is closed when the job has finished.
+func (r *jobRun) Done() <-chan struct{} {
+	return r.done
+}
+
+// Close is a no-op: the job, not a client, owns the connection.
+func (r *jobRun) Close() {}
+
+// current returns the job without its events.
+func (r *jobRun) current() types.AnalysisJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.job
+}
+
+// snapshot returns the job with its events encoded so far.
+func (r *jobRun) snapshot() types.AnalysisJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job := r.job
+	job.Events = encodeJobEvents(r.events)
+	return job
+}
+
+// finish sets the job's final status from the events the turn wrote: done once it sent
+// end, failed when it reported an error without ending, and cancelled otherwise (stopped,
+// or replaced by a newer message).
+func (r *jobRun) finish() types.AnalysisJob {
+	r.mu.Lock()
+	now := time.Now().UTC()
+	r.job.FinishedAt = &now
+	switch {
+	case r.sawEnd:
+		r.job.Status = types.AnalysisJobDone
+	case len(r.problems) > 0:
+		r.job.Status = types.AnalysisJobFailed
+	default:
+		r.job.Status = types.AnalysisJobCancelled
+	}
+	if len(r.problems) > 0 {
+		r.job.Error = r.problems[len(r.problems)-1]
+	}
+	r.finished = true
+	close(r.changed)
+	close(r.done)
+	r.mu.Unlock()
+	return r.snapshot()
+}
+
+// problemDetail returns the detail of an error event's problem JSON.
+func problemDetail(content string) string {
+	var details problem.Details
+	if err := json.Unmarshal([]byte(content), &details); err != nil || details.Detail == "" {
+		return "The analysis failed"
+	}
+	return details.Detail
+}
+
+// encodeJobEvents writes one JSON event per line. Consecutive chunks are merged, since a
+// turn streams its answer a word at a time.
+func encodeJobEvents(events []StreamData) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	for i := 0; i < len(events); i++ {
+		event := events[i]
+		if event.Type == "chunk" {
+			var text strings.Builder
+			for ; i < len(events) && events[i].Type == "chunk"; i++ {
+				text.WriteString(events[i].Content)
+			}
+			i--
+			event.Content = text.String()
+		}
+		_ = enc.Encode(event)
+	}
+	return b.String()
+}
+
+// decodeJobEvents reads the events saved by encodeJobEvents, skipping malformed lines.
+func decodeJobEvents(encoded string) []StreamData {
+	var events []StreamData
+	for _, line := range strings.Split(encoded, "\n") {
+		if line == "" {
+			continue
+		}
+		var event StreamData
+		if err := json.Unmarshal([]byte(line), &event); err == nil {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Start runs turn for a user message as a background job and returns the job. turn
+// receives a context detached from ctx's cancellation. A session runs one turn at a
+// time, so Start returns ErrRunInProgress while one is active.
+func (s *JobService) Start(ctx context.Context, sessionID uuid.UUID, userMessageID string, turn func(ctx context.Context, conn StreamConn)) (types.AnalysisJob, error) {
+	if running, _ := s.chat.GetActiveRun(sessionID.String()); running {
+		return types.AnalysisJob{}, ErrRunInProgress
+	}
+	if _, ok := s.RunningJob(ctx, sessionID); ok {
+		return types.AnalysisJob{}, ErrRunInProgress
+	}
+
+	job, err := s.store.CreateAnalysisJob(ctx, sessionID, userMessageID)
+	if err != nil {
+		return types.AnalysisJob{}, err
+	}
+	run := newJobRun(job)
+	s.mu.Lock()
+	s.jobs[job.ID] = run
+	s.mu.Unlock()
+
+	s.logger.Info("Starting background analysis job",
+		zap.String("job_id", job.ID.String()),
+		zap.String("session_id", sessionID.String()),
+		zap.String("user_message_id", userMessageID))
+
+	// The job outlives the request but stays in its trace
+	jobCtx := tracing.Detach(ctx)
+	go s.run(jobCtx, run, turn)
+	return job, nil
+}
+
+func (s *JobService) run(ctx context.Context, run *jobRun, turn func(ctx context.Context, conn StreamConn)) {
+	start := time.Now()
+	turnDone := make(chan struct{})
+	go func() {
+		defer close(turnDone)
+		turn(ctx, run)
+	}()
+
+	ticker := time.NewTicker(jobSaveInterval)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-turnDone:
+			running = false
+		case <-ticker.C:
+			s.save(run.snapshot())
+		}
+	}
+
+	job := run.finish()
+	s.save(job)
+	s.mu.Lock()
+	delete(s.jobs, job.ID)
+	s.mu.Unlock()
+
+	s.logger.Info("Background analysis job finished",
+		zap.String("job_id", job.ID.String()),
+		zap.String("session_id", job.SessionID.String()),
+		zap.String("status", job.Status),
+		zap.Duration("elapsed", time.Since(start)))
+	s.notify(job, time.Since(start))
+}
+
+func (s *JobService) save(job types.AnalysisJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.store.SaveAnalysisJob(ctx, job); err != nil {
+		s.logger.Warn("Failed to save analysis job", zap.Error(err), zap.String("job_id", job.ID.String()))
+	}
+}
+
+// notify sends the webhook/email notification for a job that completed or failed. Runs
+// past the long-run threshold were already notified by the turn itself; stopped jobs
+// are not notified.
+func (s *JobService) notify(job types.AnalysisJob, elapsed time.Duration) {
+	if job.Status == types.AnalysisJobCancelled || s.notifier.ShouldNotify(elapsed) {
+		return
+	}
+	outcome := RunOutcomeCompleted
+	if job.Status == types.AnalysisJobFailed {
+		outcome = RunOutcomeFailed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	title := "Untitled analysis"
+	if session, err := s.store.GetSessionByID(ctx, job.SessionID); err == nil && session.Title != "" {
+		title = session.Title
+	}
+	s.notifier.Notify(ctx, s.notifier.NewRunNotification(job.SessionID.String(), title, outcome, elapsed))
+}
+
+// RunningJob returns the session's running job, if any. With MULTI_REPLICA_ENABLED this
+// includes a job on another replica that saved its events recently.
+func (s *JobService) RunningJob(ctx context.Context, sessionID uuid.UUID) (types.AnalysisJob, bool) {
+	s.mu.Lock()
+	for _, run := range s.jobs {
+		if job := run.current(); job.SessionID == sessionID {
+			s.mu.Unlock()
+			return job, true
+		}
+	}
+	s.mu.Unlock()
+
+	if !s.multiReplica {
+		return types.AnalysisJob{}, false
+	}
+	jobs, err := s.store.ListAnalysisJobs(ctx, sessionID, 1)
+	if err != nil {
+		s.logger.Warn("Failed to look up running analysis job", zap.Error(err), zap.String("session_id", sessionID.String()))
+		return types.AnalysisJob{}, false
+	}
+	if len(jobs) == 0 || !savedJobRunning(jobs[0]) {
+		return types.AnalysisJob{}, false
+	}
+	return jobs[0], true
+}
+
+// savedJobRunning reports whether a job read from the database is still running
+// somewhere: marked running and saved within jobStaleAfter.
+func savedJobRunning(job types.AnalysisJob) bool {
+	return job.Status == types.AnalysisJobRunning && time.Since(job.UpdatedAt) < jobStaleAfter
+}
+
+// Job returns one of the session's jobs.
+func (s *JobService) Job(ctx context.Context, sessionID, jobID uuid.UUID) (types.AnalysisJob, error) {
+	if run := s.liveJob(sessionID, jobID); run != nil {
+		return run.current(), nil
+	}
+	job, err := s.store.GetAnalysisJob(ctx, sessionID, jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.AnalysisJob{}, ErrUnknownJob
+	}
+	return job, err
+}
+
+// Jobs returns the session's most recent jobs, newest first.
+func (s *JobService) Jobs(ctx context.Context, sessionID uuid.UUID) ([]types.AnalysisJob, error) {
+	return s.store.ListAnalysisJobs(ctx, sessionID, maxListedJobs)
+}
+
+func (s *JobService) liveJob(sessionID, jobID uuid.UUID) *jobRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if run, ok := s.jobs[jobID]; ok && run.job.SessionID == sessionID {
+		return run
+	}
+	return nil
+}
+
+// Follow writes a job's events to conn from the start, then its new events until the
+// job finishes or conn closes. A job that is not running in this process is followed
+// through its saved events. The stream always ends with an end event, after an error
+// event for a job that failed without reporting one (interrupted by a restart).
+func (s *JobService) Follow(ctx context.Context, sessionID, jobID uuid.UUID, conn StreamConn) error {
+	run := s.liveJob(sessionID, jobID)
+	if run == nil {
+		return s.followSaved(ctx, sessionID, jobID, conn)
+	}
+
+	next := 0
+	for {
+		run.mu.Lock()
+		pending := run.events[next:]
+		next = len(run.events)
+		events := run.events
+		changed := run.changed
+		finished := run.finished
+		job := run.job
+		run.mu.Unlock()
+
+		for _, event := range pending {
+			if err := conn.Write(event); err != nil {
+				return nil
+			}
+		}
+		if finished {
+			settleJob(conn, job, events)
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-conn.Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// followSaved replays a job from its saved events. A job still running on another
+// replica is read again every jobSaveInterval for the events it saved since.
+func (s *JobService) followSaved(ctx context.Context, sessionID, jobID uuid.UUID, conn StreamConn) error {
+	var written []StreamData
+	for {
+		job, err := s.store.GetAnalysisJob(ctx, sessionID, jobID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUnknownJob
+		}
+		if err != nil {
+			return err
+		}
+		events := decodeJobEvents(job.Events)
+		for _, event := range newSavedEvents(written, events) {
+			if err := conn.Write(event); err != nil {
+				return nil
+			}
+		}
+		written = events
+		if !savedJobRunning(job) {
+			settleJob(conn, job, events)
+			return nil
+		}
+
+		select {
+		case <-time.After(jobSaveInterval):
+		case <-conn.Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// newSavedEvents returns the saved events not written yet. Saved events extend the ones
+// written before, except that the last written chunk may have grown as later chunks were
+// merged into it; its new text comes back as a chunk of its own.
+func newSavedEvents(written, saved []StreamData) []StreamData {
+	n := len(written)
+	if n == 0 || len(saved) < n {
+		return saved[min(n, len(saved)):]
+	}
+	var fresh []StreamData
+	if last := written[n-1]; last.Type == "chunk" && saved[n-1].Type == "chunk" {
+		if grown := saved[n-1].Content; len(grown) > len(last.Content) && strings.HasPrefix(grown, last.Content) {
+			fresh = append(fresh, StreamData{Type: "chunk", Content: grown[len(last.Content):]})
+		}
+	}
+	return append(fresh, saved[n:]...)
+}
+
+// settleJob writes what a client needs to settle after a job that did not end on its own:
+// an error event for a job that failed without reporting one (interrupted by a restart),
+// then end. events are the job's events already written.
+func settleJob(conn StreamConn, job types.AnalysisJob, events []StreamData) {
+	sawError := false
+	for _, event := range events {
+		switch event.Type {
+		case "end":
+			return
+		case "error":
+			sawError = true
+		}
+	}
+	if job.Status == types.AnalysisJobFailed && job.Error != "" && !sawError {
+		conn.Write(ErrorEvent(http.StatusInternalServerError, problem.Internal, job.Error))
+	}
+	conn.Write(StreamData{Type: "end"})
+}
+
+// FailInterrupted marks jobs left running by a process that is gone failed. Called at
+// startup; a lone process fails every running job, while with MULTI_REPLICA_ENABLED only
+// jobs unsaved for jobStaleAfter are failed, since other replicas may be running the rest.
+func (s *JobService) FailInterrupted(ctx context.Context) {
+	staleBefore := time.Now()
+	if s.multiReplica {
+		staleBefore = staleBefore.Add(-jobStaleAfter)
+	}
+	n, err := s.store.FailInterruptedAnalysisJobs(ctx, "Interrupted by a server restart", staleBefore)
+	if err != nil {
+		s.logger.Warn("Failed to mark interrupted analysis jobs", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		s.logger.Info("Marked interrupted analysis jobs failed", zap.Int64("count", n))
+	}
+}
+
+// WatchInterrupted runs FailInterrupted every jobSweepInterval until ctx is done, so jobs
+// of a replica that died are failed while the others keep running.
+func (s *JobService) WatchInterrupted(ctx context.Context) {
+	ticker := time.NewTicker(jobSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.FailInterrupted(ctx)
+		}
+	}
+}
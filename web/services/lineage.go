@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"stats-agent/agent"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrNothingToPersist is returned when every recorded transformation is already saved to a file.
+var ErrNothingToPersist = errors.New("no unsaved transformations")
+
+// SessionLineage returns the session's data transformation log. After a restart the
+// log is rebuilt once from the stored code/output messages; afterwards the agent keeps
+// it current as code runs.
+func (cs *ChatService) SessionLineage(ctx context.Context, sessionID uuid.UUID) ([]types.TransformationStep, error) {
+	id := sessionID.String()
+	if !cs.agent.HasSessionLineage(id) {
+		messages, err := cs.store.GetMessagesBySession(ctx, sessionID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load session messages: %w", err)
+		}
+		cs.agent.SetSessionLineage(id, lineageFromMessages(messages))
+	}
+	return cs.agent.SessionLineage(id), nil
+}
+
+// lineageFromMessages parses transformations from every executed code block that ran without error.
+func lineageFromMessages(messages []types.ChatMessage) []types.TransformationStep {
+	var steps []types.TransformationStep
+	for _, s := range collectMethodsSteps(messages) {
+		if stepFailed(s.Output) {
+			continue
+		}
+		step := agent.ParseTransformationStep(s.Code, s.Output)
+		if step == nil {
+			continue
+		}
+		step.UserEdited = s.UserEdited
+		step.ExecutedAt = s.ExecutedAt
+		steps = append(steps, *step)
+	}
+	return steps
+}
+
+// PersistCleanedDataset saves the dataframe holding the session's unsaved transformations
+// as "<dataset>_cleaned.csv", registers the file, and records the save as an executed step
+// so it shows in the methods pack and the lineage survives a restart. Returns the filename.
+func (cs *ChatService) PersistCleanedDataset(ctx context.Context, sessionID uuid.UUID) (string, error) {
+	id := sessionID.String()
+	if running, _ := cs.GetActiveRun(id); running {
+		return "", ErrRunInProgress
+	}
+	steps, err := cs.SessionLineage(ctx, sessionID)
+	if err != nil {
+		return "", err
+	}
+	unsaved := agent.UnsavedTransformations(steps)
+	if len(unsaved) == 0 {
+		return "", ErrNothingToPersist
+	}
+	frame := unsaved[len(unsaved)-1].Frame
+	if frame == "" {
+		frame = "df"
+	}
+
+	source := "dataset.csv"
+	if datasets, err := cs.sessionDatasets(ctx, sessionID); err == nil && len(datasets) > 0 {
+		source = datasets[0]
+	}
+	filename := cleanedDatasetName(filepath.Join("workspaces", id), source)
+
+	code, output, err := cs.agent.PersistDataset(ctx, id, frame, filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := cs.fileService.GetAndMarkNewFiles(ctx, id); err != nil {
+		cs.logger.Warn("Failed to register cleaned dataset", zap.Error(err), zap.String("session_id", id))
+	}
+	assistant := fmt.Sprintf("Saved the cleaned dataset (%d transformation(s) applied to `%s`) as %s.\n```python\n%s\n```", len(unsaved), frame, filename, code)
+	if _, err := cs.messageService.SaveAssistantAndTool(ctx, id, assistant, &output, ""); err != nil {
+		cs.logger.Warn("Failed to record dataset save in the conversation", zap.Error(err), zap.String("session_id", id))
+	}
+	return filename, nil
+}
+
+// cleanedDatasetName returns "<stem>_cleaned.csv" for source, numbered when the workspace
+// already holds a file of that name.
+func cleanedDatasetName(workspaceDir, source string) string {
+	stem := strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+	name := stem + "_cleaned.csv"
+	for n := 2; ; n++ {
+		if _, err := os.Stat(filepath.Join(workspaceDir, name)); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s_cleaned_%d.csv", stem, n)
+	}
+}
+
+// warnUnsavedTransformations tells the client that the run's results rest on
+// transformations that exist only in memory, offering to persist the cleaned dataset.
+func (cs *ChatService) warnUnsavedTransformations(sessionID string, write func(StreamData)) {
+	unsaved := len(agent.UnsavedTransformations(cs.agent.SessionLineage(sessionID)))
+	if unsaved == 0 {
+		return
+	}
+	message := fmt.Sprintf("These results rely on %d data transformation(s) that exist only in memory and will be lost if the session restarts.", unsaved)
+	payload, err := json.Marshal(map[string]any{"count": unsaved, "message": message})
+	if err != nil {
+		return
+	}
+	write(StreamData{Type: "unsaved_transformations", Content: string(payload)})
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"stats-agent/config"
+	"stats-agent/database"
+	"stats-agent/metrics"
+	"stats-agent/rag"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MaintenanceStats records the outcome and duration of the most recent maintenance run.
+type MaintenanceStats struct {
+	StartedAt       time.Time
+	AnalyzeDuration time.Duration
+	VacuumDuration  time.Duration
+	ReindexDuration time.Duration
+	Reindexed       bool
+	EmbeddingRows   int64
+	// SummariesPruned counts summary cache entries older than SUMMARY_CACHE_TTL deleted
+	SummariesPruned int64
+	Errors          int
+}
+
+// MaintenanceService runs ANALYZE, VACUUM, and vector reindexing on the database.
+type MaintenanceService struct {
+	store  database.Store
+	cfg    *config.Config
+	logger *zap.Logger
+
+	mu              sync.Mutex
+	lastIndexedRows int64
+}
+
+// NewMaintenanceService creates a new maintenance service instance
+func NewMaintenanceService(store database.Store, cfg *config.Config, logger *zap.Logger) *MaintenanceService {
+	return &MaintenanceService{
+		store:  store,
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+// RunOnce executes a single maintenance cycle. Individual task failures are logged
+// and counted; the remaining tasks still run.
+func (ms *MaintenanceService) RunOnce(ctx context.Context) MaintenanceStats {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stats := MaintenanceStats{StartedAt: time.Now()}
+
+	start := time.Now()
+	if err := ms.store.AnalyzeTables(ctx); err != nil {
+		ms.logger.Warn("Database ANALYZE failed", zap.Error(err))
+		stats.Errors++
+	}
+	stats.AnalyzeDuration = time.Since(start)
+	metrics.DBMaintenanceDuration.Observe(stats.AnalyzeDuration.Seconds(), "analyze")
+
+	start = time.Now()
+	if err := ms.store.VacuumTables(ctx); err != nil {
+		ms.logger.Warn("Database VACUUM failed", zap.Error(err))
+		stats.Errors++
+	}
+	stats.VacuumDuration = time.Since(start)
+	metrics.DBMaintenanceDuration.Observe(stats.VacuumDuration.Seconds(), "vacuum")
+
+	// Reindex the vector index only after the embedding table grew past the configured ratio
+	rows, err := ms.store.CountRAGEmbeddings(ctx)
+	if err != nil {
+		ms.logger.Warn("Failed to count embeddings for reindex check", zap.Error(err))
+		stats.Errors++
+	} else {
+		stats.EmbeddingRows = rows
+		if ms.lastIndexedRows == 0 {
+			// First run: take the current size as the baseline
+			ms.lastIndexedRows = rows
+		} else if float64(rows-ms.lastIndexedRows) >= float64(ms.lastIndexedRows)*ms.cfg.DBReindexGrowthRatio {
+			start = time.Now()
+			if err := ms.store.ReindexVectorIndex(ctx); err != nil {
+				ms.logger.Warn("Vector index reindex failed", zap.Error(err))
+				stats.Errors++
+			} else {
+				stats.Reindexed = true
+				ms.lastIndexedRows = rows
+			}
+			stats.ReindexDuration = time.Since(start)
+			metrics.DBMaintenanceDuration.Observe(stats.ReindexDuration.Seconds(), "reindex")
+		}
+	}
+
+	if ms.cfg.SummaryCacheTTL > 0 {
+		pruned, err := ms.store.PruneSummaryCache(ctx, time.Now().Add(-ms.cfg.SummaryCacheTTL))
+		if err != nil {
+			ms.logger.Warn("Summary cache pruning failed", zap.Error(err))
+			stats.Errors++
+		}
+		stats.SummariesPruned = pruned
+	}
+
+	ms.logger.Info("Database maintenance completed",
+		zap.Duration("analyze_duration", stats.AnalyzeDuration),
+		zap.Duration("vacuum_duration", stats.VacuumDuration),
+		zap.Bool("reindexed", stats.Reindexed),
+		zap.Duration("reindex_duration", stats.ReindexDuration),
+		zap.Int64("embedding_rows", stats.EmbeddingRows),
+		zap.Int64("summaries_pruned", stats.SummariesPruned),
+		zap.Int("errors", stats.Errors))
+
+	return stats
+}
+
+// ConsolidateRecentFacts merges near-duplicate facts for sessions active within
+// the given window. Returns the total number of facts removed.
+func (ms *MaintenanceService) ConsolidateRecentFacts(ctx context.Context, r *rag.RAG, window time.Duration) int {
+	sessionIDs, err := ms.store.GetRecentlyActiveSessions(ctx, time.Now().Add(-window))
+	if err != nil {
+		ms.logger.Warn("Failed to list active sessions for fact consolidation", zap.Error(err))
+		return 0
+	}
+
+	start := time.Now()
+	total := 0
+	for _, sessionID := range sessionIDs {
+		removed, err := r.ConsolidateSessionFacts(ctx, sessionID.String())
+		if err != nil {
+			ms.logger.Warn("Fact consolidation failed for session",
+				zap.Error(err),
+				zap.String("session_id", sessionID.String()))
+			continue
+		}
+		total += removed
+	}
+
+	ms.logger.Info("Fact consolidation completed",
+		zap.Int("sessions", len(sessionIDs)),
+		zap.Int("facts_removed", total),
+		zap.Duration("duration", time.Since(start)))
+	return total
+}
+
+// ArchiveOldDocuments moves conversation chunks older than RAG_ARCHIVE_AFTER to the
+// archived tier and, when RAG_ARCHIVE_TTL is set, deletes chunks archived longer ago than
+// that. Returns the number archived and deleted.
+func (ms *MaintenanceService) ArchiveOldDocuments(ctx context.Context) (archived, deleted int64) {
+	start := time.Now()
+	archived, err := ms.store.ArchiveRAGDocuments(ctx, start.Add(-ms.cfg.RAGArchiveAfter), ms.cfg.RAGArchiveRoles)
+	if err != nil {
+		ms.logger.Warn("Failed to archive old RAG documents", zap.Error(err))
+	}
+
+	if ms.cfg.RAGArchiveTTL > 0 {
+		deleted, err = ms.store.DeleteArchivedRAGDocuments(ctx, start.Add(-ms.cfg.RAGArchiveTTL))
+		if err != nil {
+			ms.logger.Warn("Failed to delete expired archived RAG documents", zap.Error(err))
+		}
+	}
+
+	ms.logger.Info("RAG archival completed",
+		zap.Int64("archived", archived),
+		zap.Int64("deleted", deleted),
+		zap.Duration("duration", time.Since(start)))
+	return archived, deleted
+}
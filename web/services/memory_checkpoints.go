@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// ErrUnknownCheckpoint is returned for a memory checkpoint that does not belong to the session.
+var ErrUnknownCheckpoint = errors.New("unknown checkpoint")
+
+// ErrMemoryDisabled is returned for checkpoint operations when RAG memory is not configured.
+var ErrMemoryDisabled = errors.New("session memory is disabled")
+
+// maxCheckpointNameLength bounds checkpoint names.
+const maxCheckpointNameLength = 120
+
+// MemoryCheckpoints returns the session's memory checkpoints, oldest first, and the one
+// retrieval is currently scoped to, if any.
+func (cs *ChatService) MemoryCheckpoints(ctx context.Context, sessionID uuid.UUID) ([]types.MemoryCheckpoint, *types.MemoryCheckpoint, error) {
+	ragInstance := cs.agent.GetRAG()
+	if ragInstance == nil {
+		return nil, nil, nil
+	}
+	checkpoints, err := cs.store.GetMemoryCheckpoints(ctx, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if scope, ok := ragInstance.RetrievalCheckpoint(sessionID.String()); ok {
+		return checkpoints, &scope, nil
+	}
+	return checkpoints, nil, nil
+}
+
+// CreateMemoryCheckpoint snapshots the session's memory under name; an empty name is
+// replaced by a numbered default.
+func (cs *ChatService) CreateMemoryCheckpoint(ctx context.Context, sessionID uuid.UUID, name string) (types.MemoryCheckpoint, error) {
+	ragInstance := cs.agent.GetRAG()
+	if ragInstance == nil {
+		return types.MemoryCheckpoint{}, ErrMemoryDisabled
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		existing, err := cs.store.GetMemoryCheckpoints(ctx, sessionID)
+		if err != nil {
+			return types.MemoryCheckpoint{}, err
+		}
+		name = "Checkpoint " + strconv.Itoa(len(existing)+1)
+	}
+	if len(name) > maxCheckpointNameLength {
+		name = name[:maxCheckpointNameLength]
+	}
+	return ragInstance.CreateCheckpoint(ctx, sessionID, name)
+}
+
+// DeleteMemoryCheckpoint removes one of the session's checkpoints.
+func (cs *ChatService) DeleteMemoryCheckpoint(ctx context.Context, sessionID, checkpointID uuid.UUID) error {
+	ragInstance := cs.agent.GetRAG()
+	if ragInstance == nil {
+		return ErrMemoryDisabled
+	}
+	if err := ragInstance.DeleteCheckpoint(ctx, sessionID, checkpointID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUnknownCheckpoint
+		}
+		return err
+	}
+	return nil
+}
+
+// ScopeRetrievalToCheckpoint answers the session's retrieval "as of" the checkpoint until
+// it is cleared with uuid.Nil. The scope is held in memory and ends on restart.
+func (cs *ChatService) ScopeRetrievalToCheckpoint(ctx context.Context, sessionID, checkpointID uuid.UUID) (*types.MemoryCheckpoint, error) {
+	ragInstance := cs.agent.GetRAG()
+	if ragInstance == nil {
+		return nil, ErrMemoryDisabled
+	}
+	if checkpointID == uuid.Nil {
+		return nil, ragInstance.ScopeToCheckpoint(ctx, sessionID, nil)
+	}
+	checkpoint, err := cs.findMemoryCheckpoint(ctx, sessionID, checkpointID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ragInstance.ScopeToCheckpoint(ctx, sessionID, &checkpoint); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// DiffMemoryCheckpoints reports what the session learned between two checkpoints, or
+// between a checkpoint and the current memory when toID is uuid.Nil.
+func (cs *ChatService) DiffMemoryCheckpoints(ctx context.Context, sessionID, fromID, toID uuid.UUID) (types.MemoryCheckpointDiff, error) {
+	ragInstance := cs.agent.GetRAG()
+	if ragInstance == nil {
+		return types.MemoryCheckpointDiff{}, ErrMemoryDisabled
+	}
+	from, err := cs.findMemoryCheckpoint(ctx, sessionID, fromID)
+	if err != nil {
+		return types.MemoryCheckpointDiff{}, err
+	}
+	var to *types.MemoryCheckpoint
+	if toID != uuid.Nil {
+		checkpoint, err := cs.findMemoryCheckpoint(ctx, sessionID, toID)
+		if err != nil {
+			return types.MemoryCheckpointDiff{}, err
+		}
+		to = &checkpoint
+	}
+	return ragInstance.DiffCheckpoints(ctx, sessionID, from, to)
+}
+
+func (cs *ChatService) findMemoryCheckpoint(ctx context.Context, sessionID, checkpointID uuid.UUID) (types.MemoryCheckpoint, error) {
+	checkpoints, err := cs.store.GetMemoryCheckpoints(ctx, sessionID)
+	if err != nil {
+		return types.MemoryCheckpoint{}, err
+	}
+	for _, c := range checkpoints {
+		if c.ID == checkpointID {
+			return c, nil
+		}
+	}
+	return types.MemoryCheckpoint{}, ErrUnknownCheckpoint
+}
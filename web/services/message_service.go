@@ -6,27 +6,39 @@ import (
 	"fmt"
 	"stats-agent/database"
 	"stats-agent/rag"
+	"regexp"
 	"stats-agent/web/format"
 	"stats-agent/web/templates/components"
 	"stats-agent/web/types"
+	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// memoryTagPattern matches the [mN] tags assistant messages use to cite memory entries.
+var memoryTagPattern = regexp.MustCompile(`\[m(\d+)\]`)
+
 type MessageService struct {
-	store  *database.PostgresStore
+	store  database.Store
 	logger *zap.Logger
 }
 
-func NewMessageService(store *database.PostgresStore, logger *zap.Logger) *MessageService {
+func NewMessageService(store database.Store, logger *zap.Logger) *MessageService {
 	return &MessageService{store: store, logger: logger}
 }
 
 // SaveAssistantAndTool persists an assistant message and an optional tool message in order.
 // filesHTML is appended only to the assistant message if provided (typically on the final flush).
 func (ms *MessageService) SaveAssistantAndTool(ctx context.Context, sessionID string, assistant string, tool *string, filesHTML string) (string, error) {
+	return ms.SaveCitedAssistantAndTool(ctx, sessionID, assistant, tool, filesHTML, nil)
+}
+
+// SaveCitedAssistantAndTool is SaveAssistantAndTool for an assistant message citing memory
+// entries: its [mN] tags render as footnote references and the footnotes are listed under
+// it. The stored content keeps the tags so its hash matches what the agent saw.
+func (ms *MessageService) SaveCitedAssistantAndTool(ctx context.Context, sessionID string, assistant string, tool *string, filesHTML string, citations []types.MemoryCitation) (string, error) {
     assistant = strings.TrimSpace(assistant)
     var assistantID string
 
@@ -36,11 +48,19 @@ func (ms *MessageService) SaveAssistantAndTool(ctx context.Context, sessionID st
         if err != nil {
             return "", fmt.Errorf("process assistant content: %w", err)
         }
+        assistantID = generateMessageID()
+        rendered = linkMemoryCitations(rendered, assistantID, citations)
+        if len(citations) > 0 {
+            footnotes, err := ms.RenderMemoryFootnotes(ctx, assistantID, citations)
+            if err != nil {
+                return "", err
+            }
+            rendered += footnotes
+        }
         if filesHTML != "" {
             rendered += filesHTML
         }
 
-        assistantID = generateMessageID()
         assistantMsg := types.ChatMessage{
             ID:          assistantID,
             SessionID:   sessionID,
@@ -83,6 +103,33 @@ func (ms *MessageService) SaveAssistantAndTool(ctx context.Context, sessionID st
 	return assistantID, nil
 }
 
+// SaveUserRerun persists a user-edited code re-run: a user message carrying the edited
+// code, followed by the tool message with its output.
+func (ms *MessageService) SaveUserRerun(ctx context.Context, sessionID string, content string, result string) (string, error) {
+	rendered, err := ms.processContentForDB(ctx, content)
+	if err != nil {
+		return "", fmt.Errorf("process user rerun content: %w", err)
+	}
+
+	userMsg := types.ChatMessage{
+		ID:          generateMessageID(),
+		SessionID:   sessionID,
+		Role:        "user",
+		Content:     content,
+		Rendered:    rendered,
+		ContentHash: rag.ComputeMessageContentHash("user", content),
+	}
+	if err := ms.store.CreateMessage(ctx, userMsg); err != nil {
+		ms.logger.Error("Failed to save user rerun message", zap.Error(err))
+		return "", fmt.Errorf("save user rerun message: %w", err)
+	}
+
+	if _, err := ms.SaveAssistantAndTool(ctx, sessionID, "", &result, ""); err != nil {
+		return userMsg.ID, err
+	}
+	return userMsg.ID, nil
+}
+
 // AppendFilesToMessage appends HTML (e.g., uploaded files) to an existing assistant message.
 func (ms *MessageService) AppendFilesToMessage(ctx context.Context, messageID string, filesHTML string) error {
 	filesHTML = strings.TrimSpace(filesHTML)
@@ -96,6 +143,34 @@ func (ms *MessageService) AppendFilesToMessage(ctx context.Context, messageID st
 	return nil
 }
 
+// RenderMemoryFootnotes renders the footnotes listing the memory entries a message cited.
+func (ms *MessageService) RenderMemoryFootnotes(ctx context.Context, messageID string, citations []types.MemoryCitation) (string, error) {
+	var buf bytes.Buffer
+	if err := components.MemoryFootnotes(messageID, citations).Render(ctx, &buf); err != nil {
+		return "", fmt.Errorf("render memory footnotes: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// linkMemoryCitations replaces the [mN] tags in rendered HTML with references to the
+// message's footnotes. Tags citing no known entry are dropped.
+func linkMemoryCitations(rendered, messageID string, citations []types.MemoryCitation) string {
+	if !strings.Contains(rendered, "[m") {
+		return rendered
+	}
+	footnotes := make(map[string]int, len(citations))
+	for _, c := range citations {
+		footnotes[strconv.Itoa(c.Tag)] = c.Footnote
+	}
+	return memoryTagPattern.ReplaceAllStringFunc(rendered, func(tag string) string {
+		footnote, ok := footnotes[memoryTagPattern.FindStringSubmatch(tag)[1]]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprintf(`<sup class="memory-ref"><a href="#%s">[%d]</a></sup>`, components.MemoryFootnoteID(messageID, footnote), footnote)
+	})
+}
+
 func (ms *MessageService) processContentForDB(ctx context.Context, rawContent string) (string, error) {
     // Normalize common LLM quirks (e.g., python> prompts, ```python, curly quotes)
     preprocessed := format.PreprocessAssistantText(rawContent)
@@ -106,7 +181,7 @@ func (ms *MessageService) processContentForDB(ctx context.Context, rawContent st
 
 func (ms *MessageService) renderToolContent(ctx context.Context, result string) (string, error) {
 	var buf bytes.Buffer
-	if err := components.ExecutionResultBlock(result).Render(ctx, &buf); err != nil {
+	if err := format.RenderToolOutput(ctx, result, &buf); err != nil {
 		return "", fmt.Errorf("render execution result block: %w", err)
 	}
 	return buf.String(), nil
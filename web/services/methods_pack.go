@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"stats-agent/agent"
+	"stats-agent/web/types"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// methodsStep is one executed code block and the output it produced. MessageID is the
+// tool message holding the output.
+type methodsStep struct {
+	MessageID  string
+	Code       string
+	Output     string
+	UserEdited bool
+	// FromSpec marks code compiled from a declarative analysis spec
+	FromSpec   bool
+	ExecutedAt time.Time
+}
+
+// BuildMethodsPack assembles a Markdown "methods pack" for a session: the executed
+// code blocks in chronological order with their outputs, followed by the package
+// versions of the session's Python environment, and the user's notes on steps and
+// messages. It is meant for a manuscript's
+// supplementary materials and converts to PDF with standard Markdown tooling.
+func (cs *ChatService) BuildMethodsPack(ctx context.Context, sessionID uuid.UUID) (string, error) {
+	session, err := cs.store.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load session: %w", err)
+	}
+
+	messages, err := cs.store.GetMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return "", fmt.Errorf("failed to load session messages: %w", err)
+	}
+	steps := collectMethodsSteps(messages)
+
+	// Notes are best effort like package versions
+	annotations, err := cs.store.GetMessageAnnotations(ctx, sessionID)
+	if err != nil {
+		cs.logger.Warn("Failed to load annotations for methods pack",
+			zap.Error(err),
+			zap.String("session_id", sessionID.String()))
+	}
+	notes := make(map[string][]types.MessageAnnotation)
+	for _, a := range annotations {
+		id := a.MessageID.String()
+		notes[id] = append(notes[id], a)
+	}
+
+	// Package versions are best effort: the executor may be unavailable
+	versions, err := cs.agent.PackageVersions(ctx, sessionID.String())
+	if err != nil {
+		cs.logger.Warn("Failed to collect package versions for methods pack",
+			zap.Error(err),
+			zap.String("session_id", sessionID.String()))
+		versions = ""
+	}
+
+	title := strings.TrimSpace(session.Title)
+	if title == "" {
+		title = "Untitled analysis"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Methods pack: %s\n\n", title)
+	fmt.Fprintf(&b, "- Session: `%s`\n", sessionID)
+	fmt.Fprintf(&b, "- Generated: %s\n", time.Now().UTC().Format(time.RFC3339))
+	if session.RandomSeed != nil {
+		fmt.Fprintf(&b, "- Random seed: `%d` (Python `random` and NumPy's global generator are reseeded before every executed block)\n", *session.RandomSeed)
+	} else {
+		b.WriteString("- Random seed: not pinned (randomized results may differ on re-run)\n")
+	}
+	if session.LLMModel != "" {
+		fmt.Fprintf(&b, "- LLM model: `%s`\n", session.LLMModel)
+	}
+	fmt.Fprintf(&b, "- Executed code blocks: %d\n\n", len(steps))
+
+	b.WriteString("## Software environment\n\n")
+	if strings.TrimSpace(versions) != "" {
+		b.WriteString("```text\n")
+		b.WriteString(strings.TrimSpace(versions))
+		b.WriteString("\n```\n\n")
+	} else {
+		b.WriteString("_Package versions unavailable (Python executor not reachable)._\n\n")
+	}
+
+	b.WriteString("## Executed code\n\n")
+	if len(steps) == 0 {
+		b.WriteString("_No code was executed in this session._\n")
+	}
+	for i, step := range steps {
+		fmt.Fprintf(&b, "### Step %d", i+1)
+		if step.FromSpec {
+			b.WriteString(" (from analysis spec)")
+		} else if step.UserEdited {
+			b.WriteString(" (edited by user)")
+		}
+		if !step.ExecutedAt.IsZero() {
+			fmt.Fprintf(&b, " — %s", step.ExecutedAt.UTC().Format(time.RFC3339))
+		}
+		b.WriteString("\n\n```python\n")
+		b.WriteString(step.Code)
+		b.WriteString("\n```\n\n")
+		if step.Output != "" {
+			b.WriteString("Output:\n\n```text\n")
+			b.WriteString(step.Output)
+			b.WriteString("\n```\n\n")
+		}
+		writeMethodsNotes(&b, notes[step.MessageID])
+		delete(notes, step.MessageID)
+	}
+
+	// Notes on messages other than step outputs, in conversation order
+	var annotated []types.ChatMessage
+	for _, m := range messages {
+		if len(notes[m.ID]) > 0 {
+			annotated = append(annotated, m)
+		}
+	}
+	if len(annotated) > 0 {
+		b.WriteString("## Analyst notes\n\n")
+		for _, m := range annotated {
+			fmt.Fprintf(&b, "On the %s message of %s:\n\n", methodsNoteRole(m.Role), m.CreatedAt.UTC().Format(time.RFC3339))
+			writeMethodsNotes(&b, notes[m.ID])
+		}
+	}
+
+	return b.String(), nil
+}
+
+// writeMethodsNotes writes a message's annotations as a Markdown quote block.
+func writeMethodsNotes(b *strings.Builder, notes []types.MessageAnnotation) {
+	for _, n := range notes {
+		b.WriteString("> **Note")
+		if !n.CreatedAt.IsZero() {
+			fmt.Fprintf(b, " (%s)", n.CreatedAt.UTC().Format(time.RFC3339))
+		}
+		b.WriteString(":** ")
+		b.WriteString(strings.ReplaceAll(n.Note, "\n", "\n> "))
+		b.WriteString("\n\n")
+	}
+}
+
+func methodsNoteRole(role string) string {
+	switch role {
+	case "user":
+		return "user"
+	case "tool":
+		return "tool output"
+	default:
+		return "assistant"
+	}
+}
+
+// collectMethodsSteps pairs each code-bearing assistant (or user re-run) message with
+// the tool output that follows it. Code without a tool message was never executed and
+// is skipped.
+func collectMethodsSteps(messages []types.ChatMessage) []methodsStep {
+	var steps []methodsStep
+	var pending *methodsStep
+
+	for _, m := range messages {
+		switch m.Role {
+		case "assistant", "user":
+			userEdited := m.Role == "user"
+			fromSpec := userEdited && strings.HasPrefix(m.Content, agent.AnalysisSpecPrefix)
+			if userEdited && !fromSpec && !strings.HasPrefix(m.Content, agent.UserEditedCodePrefix) {
+				continue
+			}
+			blocks := extractPythonBlocks(m.Content)
+			if len(blocks) == 0 {
+				continue
+			}
+			pending = &methodsStep{
+				Code:       blocks[len(blocks)-1],
+				UserEdited: userEdited && !fromSpec,
+				FromSpec:   fromSpec,
+				ExecutedAt: m.CreatedAt,
+			}
+		case "tool":
+			if pending == nil {
+				continue
+			}
+			pending.MessageID = m.ID
+			pending.Output = strings.TrimSpace(m.Content)
+			steps = append(steps, *pending)
+			pending = nil
+		}
+	}
+	return steps
+}
+
+// stepFailed reports whether a step's output is an execution error.
+func stepFailed(output string) bool {
+	return strings.HasPrefix(output, "Error") || strings.Contains(output, "Traceback (most recent call last)")
+}
+
+// extractPythonBlocks returns the contents of every ```python fence in text.
+func extractPythonBlocks(text string) []string {
+	const startMarker = "```python"
+	var blocks []string
+	for {
+		startIdx := strings.Index(text, startMarker)
+		if startIdx == -1 {
+			return blocks
+		}
+		rest := text[startIdx+len(startMarker):]
+		endIdx := strings.Index(rest, "```")
+		if endIdx == -1 {
+			return blocks
+		}
+		if code := strings.TrimSpace(rest[:endIdx]); code != "" {
+			blocks = append(blocks, code)
+		}
+		text = rest[endIdx+3:]
+	}
+}
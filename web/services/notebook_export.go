@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"stats-agent/agent"
+	"stats-agent/web/format"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// notebookPythonPattern matches the ```python fences removed from an assistant's prose;
+// the code becomes a code cell of its own.
+var notebookPythonPattern = regexp.MustCompile("(?s)```python\\s*\\n.*?```")
+
+// notebook is an nbformat 4 notebook. Cell IDs (nbformat 4.5) are left out, so the file
+// is written as 4.4.
+type notebook struct {
+	Cells         []any          `json:"cells"`
+	Metadata      map[string]any `json:"metadata"`
+	NBFormat      int            `json:"nbformat"`
+	NBFormatMinor int            `json:"nbformat_minor"`
+}
+
+type notebookMarkdownCell struct {
+	CellType string         `json:"cell_type"`
+	Metadata map[string]any `json:"metadata"`
+	Source   []string       `json:"source"`
+}
+
+// notebookCodeCell always carries outputs and execution_count, which nbformat requires.
+type notebookCodeCell struct {
+	CellType       string           `json:"cell_type"`
+	Metadata       map[string]any   `json:"metadata"`
+	Source         []string         `json:"source"`
+	ExecutionCount int              `json:"execution_count"`
+	Outputs        []notebookOutput `json:"outputs"`
+}
+
+type notebookOutput struct {
+	OutputType string            `json:"output_type"`
+	Name       string            `json:"name,omitempty"`
+	Text       []string          `json:"text,omitempty"`
+	Data       map[string]string `json:"data,omitempty"`
+	// Metadata is required on display_data and not allowed on stream outputs
+	Metadata any `json:"metadata,omitempty"`
+}
+
+// BuildNotebook converts a session into a Jupyter notebook: user and assistant text as
+// Markdown cells, each executed Python block as a code cell with its printed output,
+// warnings (stderr) and figures, in the order they ran. The user's notes on a message follow
+// its cells as quoted Markdown. A pinned seed is applied in a
+// setup cell. Cells that failed in the session are tagged raises-exception so the
+// notebook still runs end to end; it must be started from the session's workspace
+// directory, where the code finds its data files.
+func (rs *ReportService) BuildNotebook(ctx context.Context, sessionID uuid.UUID) ([]byte, error) {
+	session, err := rs.store.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	messages, err := rs.store.GetMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session messages: %w", err)
+	}
+
+	title := strings.TrimSpace(session.Title)
+	if title == "" {
+		title = "Untitled analysis"
+	}
+	nb := notebook{
+		Metadata: map[string]any{
+			"kernelspec":    map[string]any{"name": "python3", "display_name": "Python 3", "language": "python"},
+			"language_info": map[string]any{"name": "python"},
+		},
+		NBFormat:      4,
+		NBFormatMinor: 4,
+	}
+	nb.Cells = append(nb.Cells, markdownCell(fmt.Sprintf("# %s\n\nExported from session `%s`. Run this notebook from the session's workspace directory so the code finds its data files.", title, sessionID)))
+
+	executionCount := 0
+	if session.RandomSeed != nil {
+		executionCount++
+		seed := *session.RandomSeed
+		nb.Cells = append(nb.Cells, codeCell(executionCount, fmt.Sprintf("# Random seed pinned in the session\nimport random\nimport numpy as np\nrandom.seed(%d)\nnp.random.seed(%d)", seed, seed)))
+	}
+
+	notes := rs.sessionNotes(ctx, sessionID)
+	var pending *notebookCodeCell
+	var pendingFigures []notebookOutput
+	var pendingNotes []types.MessageAnnotation
+	pendingSQL := false
+	for _, m := range messages {
+		// The previous message's cells are out, except a code cell still waiting for its output
+		nb.Cells = appendNotesCell(nb.Cells, pendingNotes)
+		pendingNotes = notes[m.ID]
+		switch m.Role {
+		case "user":
+			if code, ok := strings.CutPrefix(m.Content, agent.UserEditedCodePrefix); ok {
+				blocks := extractPythonBlocks(code)
+				if len(blocks) == 0 {
+					continue
+				}
+				nb.Cells = append(nb.Cells, markdownCell("*The user edited the previous code and re-ran it.*"))
+				executionCount++
+				cell := codeCell(executionCount, blocks[len(blocks)-1])
+				pending, pendingFigures = &cell, nil
+				continue
+			}
+			if spec, ok := strings.CutPrefix(m.Content, agent.AnalysisSpecPrefix); ok {
+				blocks := extractPythonBlocks(spec)
+				if len(blocks) == 0 {
+					continue
+				}
+				nb.Cells = append(nb.Cells, markdownCell("*The user ran an analysis spec; the code below was generated from it.*"))
+				executionCount++
+				cell := codeCell(executionCount, blocks[len(blocks)-1])
+				pending, pendingFigures = &cell, nil
+				continue
+			}
+			if text := strings.TrimSpace(m.Content); text != "" {
+				nb.Cells = append(nb.Cells, markdownCell("**User:** "+text))
+			}
+		case "assistant":
+			blocks := extractPythonBlocks(m.Content)
+			prose := reportAssistantText(notebookPythonPattern.ReplaceAllString(m.Content, ""))
+			if prose != "" {
+				nb.Cells = append(nb.Cells, markdownCell(prose))
+			}
+			figures, files := rs.notebookFigures(sessionID, m.Rendered)
+			if len(files) > 0 {
+				nb.Cells = append(nb.Cells, markdownCell("Generated files: `"+strings.Join(files, "`, `")+"`"))
+			}
+			// <sql> blocks ran through the SQL tool; the query is in the prose above
+			pendingSQL = len(blocks) == 0 && reportSQLPattern.MatchString(m.Content)
+			if len(blocks) == 0 {
+				continue
+			}
+			executionCount++
+			cell := codeCell(executionCount, blocks[len(blocks)-1])
+			pending, pendingFigures = &cell, figures
+		case "tool":
+			if pendingSQL {
+				output, _ := format.SplitWarnings(m.Content)
+				nb.Cells = append(nb.Cells, markdownCell("```text\n"+strings.TrimSpace(output)+"\n```"))
+				pendingSQL = false
+				continue
+			}
+			if pending == nil {
+				continue
+			}
+			output, warnings := format.SplitWarnings(m.Content)
+			output = strings.TrimSpace(output)
+			if output != "" {
+				pending.Outputs = append(pending.Outputs, notebookOutput{OutputType: "stream", Name: "stdout", Text: notebookLines(output + "\n")})
+			}
+			if len(warnings) > 0 {
+				var b strings.Builder
+				for _, w := range warnings {
+					b.WriteString(w.String() + "\n")
+				}
+				pending.Outputs = append(pending.Outputs, notebookOutput{OutputType: "stream", Name: "stderr", Text: notebookLines(b.String())})
+			}
+			pending.Outputs = append(pending.Outputs, pendingFigures...)
+			if stepFailed(output) {
+				pending.Metadata["tags"] = []string{"raises-exception"}
+			}
+			nb.Cells = append(nb.Cells, *pending)
+			pending, pendingFigures = nil, nil
+		}
+	}
+	// A code block whose output was never stored (e.g. the run was stopped)
+	if pending != nil {
+		nb.Cells = append(nb.Cells, *pending)
+	}
+	nb.Cells = appendNotesCell(nb.Cells, pendingNotes)
+
+	data, err := json.MarshalIndent(nb, "", " ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode notebook: %w", err)
+	}
+	return data, nil
+}
+
+// notebookFigures reads the PNG and JPEG figures attached to a stored assistant message as
+// display_data outputs. Other files, and figures that cannot be read, are returned by name.
+func (rs *ReportService) notebookFigures(sessionID uuid.UUID, rendered string) ([]notebookOutput, []string) {
+	var outputs []notebookOutput
+	var files []string
+	seen := make(map[string]bool)
+
+	for _, img := range reportImagePattern.FindAllStringSubmatch(rendered, -1) {
+		webPath := img[1]
+		if seen[webPath] {
+			continue
+		}
+		seen[webPath] = true
+		mimeType, data, err := readWorkspaceImage(sessionID, webPath)
+		if err == nil && mimeType != "image/png" && mimeType != "image/jpeg" {
+			err = fmt.Errorf("unsupported notebook image type %q", mimeType)
+		}
+		if err != nil {
+			rs.logger.Warn("Failed to embed figure in notebook",
+				zap.Error(err),
+				zap.String("session_id", sessionID.String()),
+				zap.String("path", webPath))
+			files = append(files, path.Base(webPath))
+			continue
+		}
+		outputs = append(outputs, notebookOutput{
+			OutputType: "display_data",
+			Data:       map[string]string{mimeType: base64.StdEncoding.EncodeToString(data)},
+			Metadata:   map[string]any{},
+		})
+	}
+
+	for _, link := range reportLinkPattern.FindAllStringSubmatch(rendered, -1) {
+		webPath := link[1]
+		if seen[webPath] || strings.HasSuffix(strings.ToLower(webPath), ".plotly.json") {
+			continue
+		}
+		seen[webPath] = true
+		files = append(files, path.Base(webPath))
+	}
+	return outputs, files
+}
+
+// appendNotesCell appends a message's annotations as one Markdown cell, in the methods
+// pack's quote format.
+func appendNotesCell(cells []any, notes []types.MessageAnnotation) []any {
+	if len(notes) == 0 {
+		return cells
+	}
+	var b strings.Builder
+	writeMethodsNotes(&b, notes)
+	return append(cells, markdownCell(strings.TrimSpace(b.String())))
+}
+
+func markdownCell(text string) notebookMarkdownCell {
+	return notebookMarkdownCell{CellType: "markdown", Metadata: map[string]any{}, Source: notebookLines(text)}
+}
+
+func codeCell(executionCount int, code string) notebookCodeCell {
+	return notebookCodeCell{
+		CellType:       "code",
+		Metadata:       map[string]any{},
+		Source:         notebookLines(code),
+		ExecutionCount: executionCount,
+		Outputs:        []notebookOutput{},
+	}
+}
+
+// notebookLines splits text into the line list nbformat stores, each line keeping its "\n".
+func notebookLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
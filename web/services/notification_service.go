@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"stats-agent/config"
+	"stats-agent/database"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Run outcomes reported in long-run notifications.
+const (
+	RunOutcomeCompleted = "completed"
+	RunOutcomeFailed    = "failed"
+)
+
+// RunNotification describes a finished long-running analysis.
+type RunNotification struct {
+	SessionID       string  `json:"session_id"`
+	Title           string  `json:"title"`
+	Outcome         string  `json:"outcome"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	URL             string  `json:"url,omitempty"`
+}
+
+// Message returns a one-line description of the run for notification bodies.
+func (n RunNotification) Message() string {
+	duration := (time.Duration(n.DurationSeconds) * time.Second).Round(time.Second)
+	if n.Outcome == RunOutcomeFailed {
+		return fmt.Sprintf("Analysis \"%s\" failed after %s.", n.Title, duration)
+	}
+	return fmt.Sprintf("Analysis \"%s\" finished after %s.", n.Title, duration)
+}
+
+// NotificationService notifies users when analyses that ran longer than the configured
+// threshold complete or fail: a webhook POST and/or an email to the session owner. The
+// caller also sends a run_notification event over the run's SSE stream, which the open
+// app tab shows as a browser notification; there is no Web Push.
+type NotificationService struct {
+	cfg        *config.Config
+	store      database.Store
+	logger     *zap.Logger
+	httpClient *http.Client
+}
+
+// NewNotificationService creates a notification service.
+func NewNotificationService(cfg *config.Config, store database.Store, logger *zap.Logger) *NotificationService {
+	return &NotificationService{
+		cfg:        cfg,
+		store:      store,
+		logger:     logger,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ShouldNotify reports whether a run of the given duration warrants a notification.
+func (ns *NotificationService) ShouldNotify(duration time.Duration) bool {
+	return ns != nil && ns.cfg.NotifyLongRunMinutes > 0 && duration >= ns.cfg.NotifyLongRunMinutes
+}
+
+// NewRunNotification builds the notification for a session's finished run.
+func (ns *NotificationService) NewRunNotification(sessionID, title, outcome string, duration time.Duration) RunNotification {
+	n := RunNotification{
+		SessionID:       sessionID,
+		Title:           title,
+		Outcome:         outcome,
+		DurationSeconds: duration.Seconds(),
+	}
+	if base := strings.TrimRight(ns.cfg.NotifyBaseURL, "/"); base != "" {
+		n.URL = base + "/chat/" + sessionID
+	}
+	return n
+}
+
+// Notify delivers the notification through every configured channel. Failures are
+// logged and never affect the run.
+func (ns *NotificationService) Notify(ctx context.Context, n RunNotification) {
+	if ns.cfg.NotifyWebhookURL != "" {
+		if err := ns.sendWebhook(ctx, n); err != nil {
+			ns.logger.Warn("Failed to send run notification webhook", zap.Error(err), zap.String("session_id", n.SessionID))
+		}
+	}
+	if ns.cfg.NotifyEmailEnabled {
+		to, err := ns.ownerEmail(ctx, n.SessionID)
+		if err != nil {
+			ns.logger.Warn("Failed to look up run notification recipient", zap.Error(err), zap.String("session_id", n.SessionID))
+		} else if to != "" {
+			if err := ns.sendEmail(to, n); err != nil {
+				ns.logger.Warn("Failed to send run notification email", zap.Error(err), zap.String("session_id", n.SessionID))
+			}
+		}
+	}
+}
+
+// ownerEmail returns the email address of the session owner's account, "" for sessions
+// of anonymous users.
+func (ns *NotificationService) ownerEmail(ctx context.Context, sessionID string) (string, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return "", fmt.Errorf("invalid session ID: %w", err)
+	}
+	session, err := ns.store.GetSessionByID(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to load session: %w", err)
+	}
+	if session.UserID == nil {
+		return "", nil
+	}
+	account, err := ns.store.GetUserAccount(ctx, *session.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to load user account: %w", err)
+	}
+	return account.Email, nil
+}
+
+func (ns *NotificationService) sendWebhook(ctx context.Context, n RunNotification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ns.cfg.NotifyWebhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ns.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (ns *NotificationService) sendEmail(to string, n RunNotification) error {
+	subject := "Pocket Statistician: analysis " + n.Outcome
+	body := n.Message()
+	if n.URL != "" {
+		body += "\r\n\r\nOpen the session: " + n.URL
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", ns.cfg.SMTPFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(body + "\r\n")
+
+	var auth smtp.Auth
+	if ns.cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", ns.cfg.SMTPUsername, ns.cfg.SMTPPassword, ns.cfg.SMTPHost)
+	}
+	addr := fmt.Sprintf("%s:%d", ns.cfg.SMTPHost, ns.cfg.SMTPPort)
+	if err := smtp.SendMail(addr, auth, ns.cfg.SMTPFrom, []string{to}, []byte(msg.String())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"stats-agent/config"
+	"strconv"
+	"strings"
+)
+
+// maxOAuthResponseBytes caps token and profile responses from providers.
+const maxOAuthResponseBytes = 1 << 20
+
+// oauthProfile is the identity a provider returned for the signed-in user.
+type oauthProfile struct {
+	Subject       string // stable provider user ID
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// oauthProvider is an OAuth 2.0 authorization code provider. The flow is small enough
+// to run on net/http: redirect to authURL, exchange the code at tokenURL, then read the
+// profile with the access token.
+type oauthProvider struct {
+	name         string
+	clientID     string
+	clientSecret string
+	authURL      string
+	tokenURL     string
+	scopes       []string
+	profile      func(ctx context.Context, client *http.Client, accessToken string) (oauthProfile, error)
+}
+
+// configuredOAuthProviders returns the providers with a client ID, keyed by name.
+func configuredOAuthProviders(cfg *config.Config) map[string]*oauthProvider {
+	providers := make(map[string]*oauthProvider)
+	if cfg.OAuthGoogleClientID != "" {
+		providers["google"] = &oauthProvider{
+			name:         "google",
+			clientID:     cfg.OAuthGoogleClientID,
+			clientSecret: cfg.OAuthGoogleClientSecret,
+			authURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+			tokenURL:     "https://oauth2.googleapis.com/token",
+			scopes:       []string{"openid", "email", "profile"},
+			profile:      googleProfile,
+		}
+	}
+	if cfg.OAuthGitHubClientID != "" {
+		providers["github"] = &oauthProvider{
+			name:         "github",
+			clientID:     cfg.OAuthGitHubClientID,
+			clientSecret: cfg.OAuthGitHubClientSecret,
+			authURL:      "https://github.com/login/oauth/authorize",
+			tokenURL:     "https://github.com/login/oauth/access_token",
+			scopes:       []string{"read:user", "user:email"},
+			profile:      githubProfile,
+		}
+	}
+	return providers
+}
+
+// authCodeURL returns the provider's consent page URL for state.
+func (p *oauthProvider) authCodeURL(redirectURI, state string) string {
+	q := url.Values{}
+	q.Set("client_id", p.clientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("response_type", "code")
+	q.Set("scope", strings.Join(p.scopes, " "))
+	q.Set("state", state)
+	return p.authURL + "?" + q.Encode()
+}
+
+// exchange trades an authorization code for an access token.
+func (p *oauthProvider) exchange(ctx context.Context, client *http.Client, redirectURI, code string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", p.clientID)
+	form.Set("client_secret", p.clientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := doOAuthJSON(client, req, &token); err != nil {
+		return "", fmt.Errorf("%s token exchange: %w", p.name, err)
+	}
+	if token.AccessToken == "" {
+		// GitHub reports a bad code with 200 and an error member
+		return "", fmt.Errorf("%s token exchange: %s %s", p.name, token.Error, token.Description)
+	}
+	return token.AccessToken, nil
+}
+
+func googleProfile(ctx context.Context, client *http.Client, accessToken string) (oauthProfile, error) {
+	var info struct {
+		Sub           string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := getOAuthJSON(ctx, client, "https://openidconnect.googleapis.com/v1/userinfo", accessToken, &info); err != nil {
+		return oauthProfile{}, fmt.Errorf("google userinfo: %w", err)
+	}
+	return oauthProfile{Subject: info.Sub, Email: info.Email, EmailVerified: info.EmailVerified, Name: info.Name}, nil
+}
+
+// githubProfile reads the user and, because the public email may be hidden or
+// unverified, the primary verified address from /user/emails.
+func githubProfile(ctx context.Context, client *http.Client, accessToken string) (oauthProfile, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getOAuthJSON(ctx, client, "https://api.github.com/user", accessToken, &user); err != nil {
+		return oauthProfile{}, fmt.Errorf("github user: %w", err)
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getOAuthJSON(ctx, client, "https://api.github.com/user/emails", accessToken, &emails); err != nil {
+		return oauthProfile{}, fmt.Errorf("github emails: %w", err)
+	}
+
+	profile := oauthProfile{Subject: strconv.FormatInt(user.ID, 10), Name: user.Name}
+	if profile.Name == "" {
+		profile.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			profile.Email, profile.EmailVerified = e.Email, e.Verified
+			break
+		}
+	}
+	return profile, nil
+}
+
+func getOAuthJSON(ctx context.Context, client *http.Client, endpoint, accessToken string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	return doOAuthJSON(client, req, out)
+}
+
+func doOAuthJSON(client *http.Client, req *http.Request, out any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOAuthResponseBytes))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return errors.New("malformed response")
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pdfTypes "stats-agent/pdf"
+
+	"go.uber.org/zap"
+)
+
+// pdfCacheSettingsFile records the extractor settings the cached entries were produced with.
+const pdfCacheSettingsFile = "settings"
+
+// PDFPageCache is a size-bounded on-disk LRU cache of extracted PDF pages, keyed by the
+// file's content hash. Entries are raw extractor output (before header/footer and
+// reference cleanup), so cleanup tuning changes apply to cached PDFs too. When the
+// extractor settings change the whole cache is dropped.
+type PDFPageCache struct {
+	dir      string
+	maxBytes int64
+	logger   *zap.Logger
+	mu       sync.Mutex
+}
+
+// NewPDFPageCache opens (or creates) the cache directory. settings fingerprints the
+// extractor configuration (service URL and tuning parameters); entries written under
+// different settings are removed.
+func NewPDFPageCache(dir string, maxBytes int64, settings string, logger *zap.Logger) (*PDFPageCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create PDF cache directory: %w", err)
+	}
+	c := &PDFPageCache{dir: dir, maxBytes: maxBytes, logger: logger}
+
+	sum := sha256.Sum256([]byte(settings))
+	fingerprint := hex.EncodeToString(sum[:])
+	settingsPath := filepath.Join(dir, pdfCacheSettingsFile)
+	if stored, err := os.ReadFile(settingsPath); err != nil || strings.TrimSpace(string(stored)) != fingerprint {
+		removed := c.clear()
+		if removed > 0 {
+			logger.Info("PDF extractor settings changed, cleared PDF cache", zap.Int("entries", removed))
+		}
+		if err := os.WriteFile(settingsPath, []byte(fingerprint+"\n"), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write PDF cache settings: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// Key returns the cache key for a PDF: the SHA-256 of its contents.
+func (c *PDFPageCache) Key(pdfPath string) (string, error) {
+	f, err := os.Open(pdfPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open PDF for hashing: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash PDF: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Get returns the cached pages for key and marks the entry as recently used.
+func (c *PDFPageCache) Get(key string) ([]pdfTypes.Page, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.entryPath(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var pages []pdfTypes.Page
+	if err := json.Unmarshal(data, &pages); err != nil {
+		c.logger.Warn("Dropping unreadable PDF cache entry", zap.String("key", key), zap.Error(err))
+		_ = os.Remove(path)
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return pages, true
+}
+
+// Put stores pages under key, then evicts least recently used entries over the size bound.
+func (c *PDFPageCache) Put(key string, pages []pdfTypes.Page) error {
+	data, err := json.Marshal(pages)
+	if err != nil {
+		return fmt.Errorf("failed to encode PDF cache entry: %w", err)
+	}
+	if int64(len(data)) > c.maxBytes {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tmp, err := os.CreateTemp(c.dir, "entry-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create PDF cache entry: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write PDF cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write PDF cache entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.entryPath(key)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store PDF cache entry: %w", err)
+	}
+
+	c.evict()
+	return nil
+}
+
+func (c *PDFPageCache) entryPath(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// evict removes the least recently used entries until the cache fits in maxBytes.
+// Callers must hold c.mu.
+func (c *PDFPageCache) evict() {
+	entries := c.entries()
+	var total int64
+	for _, e := range entries {
+		total += e.Size()
+	}
+	if total <= c.maxBytes {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ModTime().Before(entries[j].ModTime()) })
+	for _, e := range entries {
+		if total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, e.Name())); err != nil {
+			c.logger.Warn("Failed to evict PDF cache entry", zap.String("file", e.Name()), zap.Error(err))
+			continue
+		}
+		total -= e.Size()
+		c.logger.Debug("Evicted PDF cache entry", zap.String("file", e.Name()))
+	}
+}
+
+// clear removes every cache entry and returns how many were removed.
+func (c *PDFPageCache) clear() int {
+	removed := 0
+	for _, e := range c.entries() {
+		if os.Remove(filepath.Join(c.dir, e.Name())) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// entries lists the cache entry files.
+func (c *PDFPageCache) entries() []os.FileInfo {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil
+	}
+	var infos []os.FileInfo
+	for _, de := range dirEntries {
+		if de.IsDir() || !strings.HasSuffix(de.Name(), ".json") {
+			continue
+		}
+		if info, err := de.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
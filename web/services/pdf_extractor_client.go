@@ -153,6 +153,12 @@ func (c *PDFExtractorClient) ExtractText(ctx context.Context, pdfPath string) (s
 
 // ExtractPages extracts text from each page individually using the pdfplumber service
 func (c *PDFExtractorClient) ExtractPages(ctx context.Context, pdfPath string) ([]pdfTypes.Page, error) {
+	return c.ExtractPagesWithParams(ctx, pdfPath, nil)
+}
+
+// ExtractPagesWithParams is ExtractPages with tuning parameters (mode, wm, flow, ...)
+// overriding those configured in the service URL.
+func (c *PDFExtractorClient) ExtractPagesWithParams(ctx context.Context, pdfPath string, params map[string]string) ([]pdfTypes.Page, error) {
 	if !c.enabled {
 		return nil, fmt.Errorf("PDF extractor is disabled")
 	}
@@ -182,7 +188,7 @@ func (c *PDFExtractorClient) ExtractPages(ctx context.Context, pdfPath string) (
 	}
 
 	// Create HTTP request
-    req, err := http.NewRequestWithContext(ctx, "POST", c.endpointURL("/extract"), body)
+    req, err := http.NewRequestWithContext(ctx, "POST", c.endpointURLWithParams("/extract", params), body)
     if err != nil {
         return nil, fmt.Errorf("failed to create request: %w", err)
     }
@@ -222,6 +228,24 @@ func (c *PDFExtractorClient) ExtractPages(ctx context.Context, pdfPath string) (
     return pages, nil
 }
 
+// endpointURLWithParams is endpointURL with params set on the query, replacing configured values.
+func (c *PDFExtractorClient) endpointURLWithParams(endpoint string, params map[string]string) string {
+    target := c.endpointURL(endpoint)
+    if len(params) == 0 {
+        return target
+    }
+    u, err := neturl.Parse(target)
+    if err != nil {
+        return target
+    }
+    q := u.Query()
+    for key, value := range params {
+        q.Set(key, value)
+    }
+    u.RawQuery = q.Encode()
+    return u.String()
+}
+
 // endpointURL safely appends endpoint path to baseURL, preserving any query parameters.
 func (c *PDFExtractorClient) endpointURL(endpoint string) string {
     base := strings.TrimSpace(c.baseURL)
@@ -17,6 +17,7 @@ type PDFService struct {
     logger          *zap.Logger
     config          *PDFConfig
     extractorClient *PDFExtractorClient // Optional pdfplumber client
+    cache           *PDFPageCache       // Optional on-disk cache of extractor output
 }
 
 // PDFConfig holds PDF-specific configuration
@@ -29,6 +30,17 @@ type PDFConfig struct {
     HeaderFooterRepeatThreshold float64
     ReferencesTrimEnabled       bool
     ReferencesCitationDensity   float64
+    // Extraction quality
+    QualityThreshold float64 // Score below which extraction is retried or flagged
+    QualityRetry     bool    // Retry low-quality extractions with alternative extractor parameters
+}
+
+// pdfQualityRetryParams are the extractor parameter sets tried, in order, when the
+// configured extraction scores below the quality threshold.
+var pdfQualityRetryParams = []map[string]string{
+    {"mode": "pdfminer", "wm": "0.1"},           // Tighter word margin splits glued words
+    {"mode": "words", "xt": "1", "flow": "1"},   // Word grouping with a smaller x tolerance
+    {"mode": "words", "xt": "3"},                // Word grouping in layout order
 }
 
 // TokenCounter interface abstracts token counting for PDF truncation
@@ -45,14 +57,23 @@ type TruncationConfig struct {
 	SentenceBoundaryTruncate bool    // Truncate at sentence boundaries
 }
 
-func NewPDFService(logger *zap.Logger, config *PDFConfig, extractorClient *PDFExtractorClient) *PDFService {
+func NewPDFService(logger *zap.Logger, config *PDFConfig, extractorClient *PDFExtractorClient, cache *PDFPageCache) *PDFService {
 	return &PDFService{
 		logger:          logger,
 		config:          config,
 		extractorClient: extractorClient,
+		cache:           cache,
 	}
 }
 
+// QualityThreshold returns the extraction score below which a PDF's text is considered unreliable.
+func (ps *PDFService) QualityThreshold() float64 {
+    if ps.config == nil {
+        return 0
+    }
+    return ps.config.QualityThreshold
+}
+
 // ExtractText extracts all text content from a PDF file
 // Returns the full text with page markers for context
 // Tries pdfplumber first (if enabled), falls back to ledongthuc/pdf
@@ -80,23 +101,28 @@ func (ps *PDFService) ExtractText(pdfPath string) (string, error) {
 // Returns a slice of pdf.Page structs, one per page
 // Tries pdfplumber first (if enabled), falls back to ledongthuc/pdf
 func (ps *PDFService) ExtractPages(pdfPath string) ([]pdfTypes.Page, error) {
+    pages, _, err := ps.ExtractPagesScored(pdfPath)
+    return pages, err
+}
+
+// ExtractPagesScored is ExtractPages that also scores the extraction. Extractions from
+// the pdfplumber service that score below the quality threshold are retried with
+// alternative parameters and the best one is kept. A low final score, or NeedsOCR,
+// means the text is unreliable.
+func (ps *PDFService) ExtractPagesScored(pdfPath string) ([]pdfTypes.Page, pdfTypes.Quality, error) {
     // Try pdfplumber extraction first if available
     if ps.extractorClient != nil && ps.extractorClient.IsEnabled() {
-        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-        defer cancel()
-
-        pages, err := ps.extractorClient.ExtractPages(ctx, pdfPath)
+        pages, err := ps.extractPagesCached(pdfPath)
         if err == nil {
-            ps.logger.Info("PDF page extraction successful via pdfplumber",
-                zap.String("path", pdfPath),
-                zap.Int("pages", len(pages)))
+            quality := pdfTypes.ScorePages(pages)
             // Strip repeated headers/footers across pages
             pages = ps.stripRepeatedHeaderFooterWithConfig(pages)
             // Optionally trim trailing references
             if ps.config != nil && ps.config.ReferencesTrimEnabled {
                 pages = ps.trimTrailingReferences(pages)
             }
-            return pages, nil
+            ps.logQuality(pdfPath, quality)
+            return pages, quality, nil
         }
 
 		ps.logger.Warn("pdfplumber page extraction failed, falling back to ledongthuc/pdf",
@@ -107,7 +133,7 @@ func (ps *PDFService) ExtractPages(pdfPath string) ([]pdfTypes.Page, error) {
 	// Fallback to ledongthuc/pdf extraction
 	f, r, err := pdf.Open(pdfPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open PDF: %w", err)
+		return nil, pdfTypes.Quality{}, fmt.Errorf("failed to open PDF: %w", err)
 	}
 	defer f.Close()
 
@@ -141,6 +167,9 @@ func (ps *PDFService) ExtractPages(pdfPath string) ([]pdfTypes.Page, error) {
         })
     }
 
+    // The fallback has no parameters to retry with, so a low score is only reported
+    quality := pdfTypes.ScorePages(pages)
+
     // Strip repeated headers/footers across pages (fallback path)
     pages = ps.stripRepeatedHeaderFooterWithConfig(pages)
     // Optionally trim trailing references
@@ -152,10 +181,99 @@ func (ps *PDFService) ExtractPages(pdfPath string) ([]pdfTypes.Page, error) {
         zap.String("path", pdfPath),
         zap.Int("pages_extracted", len(pages)),
         zap.Int("total_pages", totalPages))
+    ps.logQuality(pdfPath, quality)
+
+    return pages, quality, nil
+}
+
+// extractPagesCached returns the extractor's pages for a PDF, serving re-opened or
+// re-uploaded files from the page cache instead of the extractor service. Low-quality
+// extractions are retried before caching, so a cached entry is the best one found.
+func (ps *PDFService) extractPagesCached(pdfPath string) ([]pdfTypes.Page, error) {
+    key := ""
+    if ps.cache != nil {
+        var err error
+        if key, err = ps.cache.Key(pdfPath); err != nil {
+            ps.logger.Warn("Failed to compute PDF cache key, extracting without cache", zap.Error(err), zap.String("path", pdfPath))
+        } else if pages, ok := ps.cache.Get(key); ok {
+            ps.logger.Info("PDF pages served from cache",
+                zap.String("path", pdfPath),
+                zap.Int("pages", len(pages)))
+            return pages, nil
+        }
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    pages, err := ps.extractorClient.ExtractPages(ctx, pdfPath)
+    if err != nil {
+        return nil, err
+    }
+    ps.logger.Info("PDF page extraction successful via pdfplumber",
+        zap.String("path", pdfPath),
+        zap.Int("pages", len(pages)))
+    pages = ps.retryLowQuality(pdfPath, pages)
 
+    if key != "" {
+        if err := ps.cache.Put(key, pages); err != nil {
+            ps.logger.Warn("Failed to cache extracted PDF pages", zap.Error(err), zap.String("path", pdfPath))
+        }
+    }
     return pages, nil
 }
 
+// retryLowQuality re-extracts a PDF whose pages score below the quality threshold with
+// each of pdfQualityRetryParams and returns the best-scoring pages. Scanned documents
+// are not retried, since no parameters recover a missing text layer.
+func (ps *PDFService) retryLowQuality(pdfPath string, pages []pdfTypes.Page) []pdfTypes.Page {
+    if ps.config == nil || !ps.config.QualityRetry {
+        return pages
+    }
+    best := pdfTypes.ScorePages(pages)
+    if best.Score >= ps.config.QualityThreshold || best.NeedsOCR {
+        return pages
+    }
+
+    initial := best.Score
+    for _, params := range pdfQualityRetryParams {
+        ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+        retried, err := ps.extractorClient.ExtractPagesWithParams(ctx, pdfPath, params)
+        cancel()
+        if err != nil {
+            ps.logger.Warn("PDF re-extraction failed", zap.Error(err), zap.String("path", pdfPath), zap.Any("params", params))
+            continue
+        }
+        quality := pdfTypes.ScorePages(retried)
+        if quality.Score > best.Score {
+            pages, best = retried, quality
+        }
+        if best.Score >= ps.config.QualityThreshold {
+            break
+        }
+    }
+    ps.logger.Info("Retried low-quality PDF extraction",
+        zap.String("path", pdfPath),
+        zap.Float64("initial_score", initial),
+        zap.Float64("best_score", best.Score))
+    return pages
+}
+
+// logQuality warns about extractions that scored below the quality threshold.
+func (ps *PDFService) logQuality(pdfPath string, quality pdfTypes.Quality) {
+    if ps.config == nil || (quality.Score >= ps.config.QualityThreshold && !quality.NeedsOCR) {
+        return
+    }
+    ps.logger.Warn("Low PDF extraction quality",
+        zap.String("path", pdfPath),
+        zap.Float64("score", quality.Score),
+        zap.Int("median_page_chars", quality.MedianPageChars),
+        zap.Float64("text_page_ratio", quality.TextPageRatio),
+        zap.Float64("word_ratio", quality.WordRatio),
+        zap.Float64("garbled_ratio", quality.GarbledRatio),
+        zap.Bool("needs_ocr", quality.NeedsOCR))
+}
+
 // ExtractTextSmart extracts PDF text with intelligent truncation for large documents
 // Uses token counting to stay within context window limits, prioritizing first pages
 func (ps *PDFService) ExtractTextSmart(ctx context.Context, pdfPath string, config TruncationConfig, tokenCounter TokenCounter) (string, error) {
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"stats-agent/rag"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// ErrUnknownPin is returned for a pin that does not belong to the session.
+var ErrUnknownPin = errors.New("unknown pin")
+
+// ErrEmptyPin is returned when a pinned fact is blank.
+var ErrEmptyPin = errors.New("empty pin")
+
+// ErrTooManyPins is returned when the session already has rag.MaxPins pins.
+var ErrTooManyPins = errors.New("too many pins")
+
+// ErrDuplicatePin is returned when the session already pinned the same text.
+var ErrDuplicatePin = errors.New("duplicate pin")
+
+// maxPinLength bounds pinned facts; they are sent with every memory block.
+const maxPinLength = 500
+
+// Pins returns the session's pinned facts, oldest first.
+func (cs *ChatService) Pins(ctx context.Context, sessionID uuid.UUID) ([]types.MemoryPin, error) {
+	ragInstance := cs.agent.GetRAG()
+	if ragInstance == nil {
+		return nil, ErrMemoryDisabled
+	}
+	return ragInstance.ListPins(ctx, sessionID)
+}
+
+// AddPin pins a fact to the session's memory, so the agent sees it on every turn.
+func (cs *ChatService) AddPin(ctx context.Context, sessionID uuid.UUID, text string) (types.MemoryPin, error) {
+	ragInstance := cs.agent.GetRAG()
+	if ragInstance == nil {
+		return types.MemoryPin{}, ErrMemoryDisabled
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return types.MemoryPin{}, ErrEmptyPin
+	}
+	if runes := []rune(text); len(runes) > maxPinLength {
+		text = string(runes[:maxPinLength])
+	}
+
+	pins, err := ragInstance.ListPins(ctx, sessionID)
+	if err != nil {
+		return types.MemoryPin{}, err
+	}
+	if len(pins) >= rag.MaxPins {
+		return types.MemoryPin{}, ErrTooManyPins
+	}
+	// Pins are unique per session by normalized content hash, like other RAG documents
+	hash := rag.HashContent(rag.NormalizeForHash(text))
+	for _, pin := range pins {
+		if rag.HashContent(rag.NormalizeForHash(pin.Text)) == hash {
+			return types.MemoryPin{}, ErrDuplicatePin
+		}
+	}
+	return ragInstance.StorePin(ctx, sessionID, text)
+}
+
+// DeletePin unpins one of the session's facts.
+func (cs *ChatService) DeletePin(ctx context.Context, sessionID, pinID uuid.UUID) error {
+	ragInstance := cs.agent.GetRAG()
+	if ragInstance == nil {
+		return ErrMemoryDisabled
+	}
+	deleted, err := ragInstance.DeletePin(ctx, sessionID, pinID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrUnknownPin
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ArtifactPageSize is the number of figures per plot gallery page.
+const ArtifactPageSize = 24
+
+// PlotGallery returns a page (from 1) of the session's figures, newest first. Plotly
+// figures use the PNG saved beside them as their thumbnail.
+func (fs *FileService) PlotGallery(ctx context.Context, sessionID uuid.UUID, page int) (types.PlotGallery, error) {
+	page = max(page, 1)
+	files, total, err := fs.store.GetArtifactFiles(ctx, sessionID, ArtifactPageSize, (page-1)*ArtifactPageSize)
+	if err != nil {
+		return types.PlotGallery{}, err
+	}
+	altTexts, err := fs.store.GetFileAltTexts(ctx, sessionID)
+	if err != nil {
+		// Thumbnails still render without alt text
+		fs.logger.Warn("Failed to load figure alt text", zap.Error(err), zap.String("session_id", sessionID.String()))
+	}
+	var tracked map[string]bool
+	for _, file := range files {
+		if file.FileType == "plot" {
+			if tracked, err = fs.store.GetTrackedFilenames(ctx, sessionID); err != nil {
+				return types.PlotGallery{}, err
+			}
+			break
+		}
+	}
+
+	gallery := types.PlotGallery{
+		Artifacts: make([]types.PlotArtifact, 0, len(files)),
+		Page:      page,
+		PageSize:  ArtifactPageSize,
+		Total:     total,
+		HasMore:   page*ArtifactPageSize < total,
+	}
+	for _, file := range files {
+		artifact := types.PlotArtifact{
+			Filename:     file.Filename,
+			URL:          file.FilePath,
+			ThumbnailURL: file.FilePath,
+			Kind:         file.FileType,
+			AltText:      altTexts[file.Filename],
+			CreatedAt:    file.CreatedAt,
+		}
+		if file.MessageID != nil {
+			artifact.MessageID = file.MessageID.String()
+		}
+		if file.FileType == "plot" {
+			// The alt text of a Plotly figure is stored on its PNG fallback
+			fallback := strings.TrimSuffix(file.Filename, ".plotly.json") + ".png"
+			artifact.ThumbnailURL = ""
+			if tracked[fallback] {
+				artifact.ThumbnailURL = path.Join(path.Dir(file.FilePath), fallback)
+				artifact.AltText = altTexts[fallback]
+			}
+		}
+		gallery.Artifacts = append(gallery.Artifacts, artifact)
+	}
+	return gallery, nil
+}
+
+// PlotGallery returns a page of the session's figures for the gallery strip.
+func (cs *ChatService) PlotGallery(ctx context.Context, sessionID uuid.UUID, page int) (types.PlotGallery, error) {
+	return cs.fileService.PlotGallery(ctx, sessionID, page)
+}
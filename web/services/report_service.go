@@ -0,0 +1,308 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"stats-agent/config"
+	"stats-agent/database"
+	"stats-agent/web/format"
+	"stats-agent/web/templates/pages"
+	"stats-agent/web/types"
+
+	"github.com/gomarkdown/markdown"
+	mdhtml "github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// maxReportImageBytes bounds a figure embedded in a report; larger images are listed by name.
+const maxReportImageBytes = 10 << 20
+
+var (
+	reportAgentStatusPattern = regexp.MustCompile(`(?s)<agent_status>.*?</agent_status>`)
+	reportAskUserPattern     = regexp.MustCompile(`(?s)<ask_user>.*?</ask_user>`)
+	reportSQLPattern         = regexp.MustCompile(`(?s)<sql>(.*?)</sql>`)
+	// Figures and download links appended to a stored assistant message by RenderFileBlocksForDB
+	reportImagePattern = regexp.MustCompile(`<img[^>]*\ssrc="(/workspaces/[^"]+)"[^>]*>`)
+	reportAltPattern   = regexp.MustCompile(`\salt="([^"]*)"`)
+	reportLinkPattern  = regexp.MustCompile(`\shref="(/workspaces/[^"]+)"`)
+)
+
+// ReportService exports a session as a standalone analysis report for collaborators:
+// a single HTML file with figures embedded, or a PDF rendered from it.
+type ReportService struct {
+	cfg        *config.Config
+	store      database.Store
+	logger     *zap.Logger
+	httpClient *http.Client
+}
+
+func NewReportService(cfg *config.Config, store database.Store, logger *zap.Logger) *ReportService {
+	return &ReportService{
+		cfg:        cfg,
+		store:      store,
+		logger:     logger,
+		httpClient: &http.Client{Timeout: cfg.ReportPDFTimeout},
+	}
+}
+
+// PDFEnabled reports whether a PDF converter is configured (REPORT_PDF_URL).
+func (rs *ReportService) PDFEnabled() bool {
+	return rs.cfg.ReportPDFURL != ""
+}
+
+// BuildReport assembles the session's conversation with its executed code, tool outputs,
+// figures and the user's notes.
+func (rs *ReportService) BuildReport(ctx context.Context, sessionID uuid.UUID) (types.SessionReport, error) {
+	session, err := rs.store.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		return types.SessionReport{}, fmt.Errorf("failed to load session: %w", err)
+	}
+	messages, err := rs.store.GetMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return types.SessionReport{}, fmt.Errorf("failed to load session messages: %w", err)
+	}
+
+	title := strings.TrimSpace(session.Title)
+	if title == "" {
+		title = "Untitled analysis"
+	}
+	report := types.SessionReport{
+		SessionID:   sessionID.String(),
+		Title:       title,
+		GeneratedAt: time.Now().UTC(),
+		LLMModel:    session.LLMModel,
+		RandomSeed:  session.RandomSeed,
+	}
+
+	notes := rs.sessionNotes(ctx, sessionID)
+	for _, m := range messages {
+		entry := types.ReportEntry{Role: m.Role, CreatedAt: m.CreatedAt, Notes: notes[m.ID]}
+		switch m.Role {
+		case "user":
+			entry.HTML = reportMarkdown(m.Content)
+		case "assistant":
+			entry.HTML = reportMarkdown(reportAssistantText(m.Content))
+			entry.Figures, entry.Files = rs.reportFiles(sessionID, m.Rendered)
+			if strings.TrimSpace(entry.HTML) == "" && len(entry.Figures) == 0 && len(entry.Files) == 0 && len(entry.Notes) == 0 {
+				continue
+			}
+		case "tool":
+			output, warnings := format.SplitWarnings(m.Content)
+			entry.Output = strings.TrimSpace(output)
+			for _, w := range warnings {
+				entry.Warnings = append(entry.Warnings, w.String())
+			}
+		default:
+			continue
+		}
+		report.Entries = append(report.Entries, entry)
+	}
+	return report, nil
+}
+
+// sessionNotes returns the session's annotations by message ID. Notes are best effort: a
+// failure is logged and the export goes out without them.
+func (rs *ReportService) sessionNotes(ctx context.Context, sessionID uuid.UUID) map[string][]types.MessageAnnotation {
+	annotations, err := rs.store.GetMessageAnnotations(ctx, sessionID)
+	if err != nil {
+		rs.logger.Warn("Failed to load annotations for export",
+			zap.Error(err),
+			zap.String("session_id", sessionID.String()))
+		return nil
+	}
+	notes := make(map[string][]types.MessageAnnotation)
+	for _, a := range annotations {
+		id := a.MessageID.String()
+		notes[id] = append(notes[id], a)
+	}
+	return notes
+}
+
+// RenderHTML renders the session report as a self-contained HTML document.
+func (rs *ReportService) RenderHTML(ctx context.Context, sessionID uuid.UUID) ([]byte, error) {
+	report, err := rs.BuildReport(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := pages.SessionReport(report).Render(ctx, &buf); err != nil {
+		return nil, fmt.Errorf("failed to render report: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderPDF renders the HTML report to PDF with the converter at REPORT_PDF_URL, which
+// must accept Gotenberg's Chromium HTML route (POST /forms/chromium/convert/html).
+func (rs *ReportService) RenderPDF(ctx context.Context, sessionID uuid.UUID) ([]byte, error) {
+	if !rs.PDFEnabled() {
+		return nil, fmt.Errorf("PDF export is not configured")
+	}
+	reportHTML, err := rs.RenderHTML(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("files", "index.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to build PDF request: %w", err)
+	}
+	if _, err := part.Write(reportHTML); err != nil {
+		return nil, fmt.Errorf("failed to build PDF request: %w", err)
+	}
+	if err := form.WriteField("printBackground", "true"); err != nil {
+		return nil, fmt.Errorf("failed to build PDF request: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build PDF request: %w", err)
+	}
+
+	url := strings.TrimRight(rs.cfg.ReportPDFURL, "/") + "/forms/chromium/convert/html"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PDF request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := rs.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach PDF converter: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("PDF converter returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	pdf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF: %w", err)
+	}
+	return pdf, nil
+}
+
+// reportAssistantText turns an assistant message into plain Markdown: status lines are
+// dropped, clarification questions become text and SQL blocks become fenced code.
+func reportAssistantText(content string) string {
+	text := format.PreprocessAssistantText(content)
+	text = reportAgentStatusPattern.ReplaceAllString(text, "")
+	text = reportAskUserPattern.ReplaceAllStringFunc(text, func(block string) string {
+		q, ok := format.ParseAskUser(block)
+		if !ok {
+			return ""
+		}
+		var b strings.Builder
+		b.WriteString("\n\n**Question:** " + q.Question + "\n\n")
+		for _, option := range q.Options {
+			b.WriteString("- " + option + "\n")
+		}
+		return b.String()
+	})
+	text = reportSQLPattern.ReplaceAllString(text, "\n\n```sql\n$1\n```\n\n")
+	return strings.TrimSpace(format.StripAllTags(text))
+}
+
+// reportMarkdown renders Markdown for the report. Raw HTML in messages is dropped and
+// only links with safe schemes become links, marked nofollow: a report is opened outside
+// the app, by people who did not write the conversation.
+func reportMarkdown(text string) string {
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	renderer := mdhtml.NewRenderer(mdhtml.RendererOptions{Flags: mdhtml.CommonFlags | mdhtml.SkipHTML | mdhtml.Safelink | mdhtml.NofollowLinks})
+	p := parser.NewWithExtensions(parser.CommonExtensions)
+	return string(markdown.ToHTML([]byte(text), p, renderer))
+}
+
+// reportFiles collects the figures and files appended to a stored assistant message.
+// Figures are read from the session workspace and embedded; files are listed by name.
+func (rs *ReportService) reportFiles(sessionID uuid.UUID, rendered string) ([]types.ReportFigure, []string) {
+	var figures []types.ReportFigure
+	var files []string
+	seen := make(map[string]bool)
+
+	for _, img := range reportImagePattern.FindAllStringSubmatch(rendered, -1) {
+		webPath := img[1]
+		if seen[webPath] {
+			continue
+		}
+		seen[webPath] = true
+		alt := ""
+		if m := reportAltPattern.FindStringSubmatch(img[0]); m != nil {
+			alt = html.UnescapeString(m[1])
+		}
+		dataURI, err := rs.embedWorkspaceImage(sessionID, webPath)
+		if err != nil {
+			rs.logger.Warn("Failed to embed figure in report",
+				zap.Error(err),
+				zap.String("session_id", sessionID.String()),
+				zap.String("path", webPath))
+			files = append(files, path.Base(webPath))
+			continue
+		}
+		figures = append(figures, types.ReportFigure{Name: path.Base(webPath), Alt: alt, DataURI: dataURI})
+	}
+
+	for _, link := range reportLinkPattern.FindAllStringSubmatch(rendered, -1) {
+		webPath := link[1]
+		// Interactive Plotly figures are represented by their static PNG
+		if seen[webPath] || strings.HasSuffix(strings.ToLower(webPath), ".plotly.json") {
+			continue
+		}
+		seen[webPath] = true
+		files = append(files, path.Base(webPath))
+	}
+	return figures, files
+}
+
+// embedWorkspaceImage reads an image from the session's workspace as a data URI.
+func (rs *ReportService) embedWorkspaceImage(sessionID uuid.UUID, webPath string) (string, error) {
+	mimeType, data, err := readWorkspaceImage(sessionID, webPath)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// readWorkspaceImage reads an image referenced by its /workspaces web path. Only files
+// directly in that session's workspace are read.
+func readWorkspaceImage(sessionID uuid.UUID, webPath string) (string, []byte, error) {
+	prefix := "/workspaces/" + sessionID.String() + "/"
+	name, ok := strings.CutPrefix(webPath, prefix)
+	if !ok || name == "" || strings.Contains(name, "/") || name == ".." {
+		return "", nil, fmt.Errorf("figure is outside the session workspace")
+	}
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(name)))
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", nil, fmt.Errorf("unsupported figure type %q", filepath.Ext(name))
+	}
+
+	fullPath := filepath.Join("workspaces", sessionID.String(), name)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to stat figure: %w", err)
+	}
+	if info.Size() > maxReportImageBytes {
+		return "", nil, fmt.Errorf("figure is larger than %d MB", maxReportImageBytes>>20)
+	}
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read figure: %w", err)
+	}
+	return mimeType, data, nil
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"stats-agent/database"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// RetractMessage deletes a message from the conversation together with everything session
+// memory derived from it (facts, summaries, chunks, state cards, annotations). An executed
+// step is retracted whole: its assistant message and tool output go together. Rejected
+// while the agent is running.
+func (cs *ChatService) RetractMessage(ctx context.Context, sessionID uuid.UUID, messageID string) (database.RetractionResult, error) {
+	id := sessionID.String()
+	if running, _ := cs.GetActiveRun(id); running {
+		return database.RetractionResult{}, ErrRunInProgress
+	}
+	messages, err := cs.store.GetMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return database.RetractionResult{}, fmt.Errorf("failed to load session messages: %w", err)
+	}
+	retracted := retractionUnit(messages, messageID)
+	if len(retracted) == 0 {
+		return database.RetractionResult{}, ErrUnknownMessage
+	}
+
+	var result database.RetractionResult
+	if ragInstance := cs.agent.GetRAG(); ragInstance != nil {
+		result, err = ragInstance.RetractMessages(ctx, sessionID, retracted)
+	} else {
+		refs := database.MessageArtifactRefs{}
+		for _, m := range retracted {
+			if msgID, err := uuid.Parse(m.ID); err == nil {
+				refs.MessageIDs = append(refs.MessageIDs, msgID)
+			}
+		}
+		result, err = cs.store.RetractMessageArtifacts(ctx, sessionID, refs)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	// The lineage and action cache must not remember steps that no longer exist
+	remaining := make([]types.ChatMessage, 0, len(messages))
+	for _, m := range messages {
+		if !containsMessage(retracted, m.ID) {
+			remaining = append(remaining, m)
+		}
+	}
+	cs.agent.ForgetRetractedSteps(id, lineageFromMessages(remaining))
+
+	cs.logger.Info("Retracted message",
+		zap.String("session_id", id),
+		zap.String("message_id", messageID),
+		zap.Int64("messages", result.Messages),
+		zap.Int64("documents", result.Documents))
+	return result, nil
+}
+
+// retractionUnit returns the message with its step partner: the tool output following an
+// assistant message, or the assistant message preceding a tool output.
+func retractionUnit(messages []types.ChatMessage, messageID string) []types.ChatMessage {
+	for i, m := range messages {
+		if m.ID != messageID {
+			continue
+		}
+		switch {
+		case m.Role == "assistant" && i+1 < len(messages) && messages[i+1].Role == "tool":
+			return []types.ChatMessage{m, messages[i+1]}
+		case m.Role == "tool" && i > 0 && messages[i-1].Role == "assistant":
+			return []types.ChatMessage{messages[i-1], m}
+		}
+		return []types.ChatMessage{m}
+	}
+	return nil
+}
+
+func containsMessage(messages []types.ChatMessage, messageID string) bool {
+	for _, m := range messages {
+		if m.ID == messageID {
+			return true
+		}
+	}
+	return false
+}
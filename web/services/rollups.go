@@ -0,0 +1,19 @@
+package services
+
+import (
+	"context"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+)
+
+// SessionRollups returns the session's screening rollups: tests run across many variables,
+// collected into one results table each.
+func (cs *ChatService) SessionRollups(ctx context.Context, sessionID uuid.UUID) ([]types.ScreeningRollup, error) {
+	ragInstance := cs.agent.GetRAG()
+	if ragInstance == nil {
+		return nil, nil
+	}
+	return ragInstance.SessionRollups(ctx, sessionID.String())
+}
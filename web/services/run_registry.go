@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"time"
+
+	"stats-agent/config"
+	"stats-agent/database"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// runStaleBeats is how many heartbeats a run can miss before other replicas treat it as
+// gone (its replica died).
+const runStaleBeats = 3
+
+// RunRegistry shares the active runs of every replica through the database, so any
+// replica can report, stop or replace a session's run (MULTI_REPLICA_ENABLED). The replica
+// running a turn refreshes its row every RUN_HEARTBEAT_INTERVAL. When the row is deleted
+// (stopped elsewhere) or claimed by a newer run (replaced elsewhere), the run is cancelled
+// at its next heartbeat.
+type RunRegistry struct {
+	store      database.Store
+	instanceID string
+	interval   time.Duration
+	logger     *zap.Logger
+}
+
+// NewRunRegistry creates the registry for this process.
+func NewRunRegistry(store database.Store, cfg *config.Config, logger *zap.Logger) *RunRegistry {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "replica"
+	}
+	return &RunRegistry{
+		store:      store,
+		instanceID: host + "-" + uuid.New().String()[:8],
+		interval:   cfg.RunHeartbeatInterval,
+		logger:     logger,
+	}
+}
+
+// staleBefore is the heartbeat time before which a run is treated as gone.
+func (rr *RunRegistry) staleBefore() time.Time {
+	return time.Now().Add(-runStaleBeats * rr.interval)
+}
+
+// Claim registers the run as the session's active run and starts its heartbeat. cancel is
+// called when another replica stops or replaces the run. The returned func stops the
+// heartbeat; call it before Release.
+func (rr *RunRegistry) Claim(sessionID, userMessageID, token string, cancel context.CancelFunc) func() {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return func() {}
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	claimCtx, claimCancel := context.WithTimeout(ctx, rr.interval)
+	err = rr.store.ClaimActiveRun(claimCtx, types.ActiveRun{
+		SessionID:     sessionUUID,
+		UserMessageID: userMessageID,
+		Token:         token,
+		InstanceID:    rr.instanceID,
+	})
+	claimCancel()
+	if err != nil {
+		// The run goes ahead; other replicas just cannot see it
+		rr.logger.Warn("Failed to register active run", zap.Error(err), zap.String("session_id", sessionID))
+	}
+
+	go func() {
+		ticker := time.NewTicker(rr.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			touchCtx, touchCancel := context.WithTimeout(ctx, rr.interval)
+			current, err := rr.store.TouchActiveRun(touchCtx, sessionUUID, token)
+			touchCancel()
+			if err != nil {
+				if ctx.Err() == nil {
+					rr.logger.Warn("Failed to refresh active run", zap.Error(err), zap.String("session_id", sessionID))
+				}
+				continue
+			}
+			if !current {
+				rr.logger.Info("Run stopped or replaced on another replica, cancelling",
+					zap.String("session_id", sessionID),
+					zap.String("user_message_id", userMessageID))
+				cancel()
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+// Release removes the run's row unless a newer run has claimed the session. An empty
+// token removes whatever run the session has.
+func (rr *RunRegistry) Release(sessionID, token string) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := rr.store.ReleaseActiveRun(ctx, sessionUUID, token); err != nil {
+		rr.logger.Warn("Failed to release active run", zap.Error(err), zap.String("session_id", sessionID))
+	}
+}
+
+// Stop removes the session's run whichever replica holds it; that replica cancels it at
+// its next heartbeat.
+func (rr *RunRegistry) Stop(sessionID string) {
+	rr.Release(sessionID, "")
+}
+
+// Active reports whether a replica is running the session's turn and, if so, the user
+// message that started it.
+func (rr *RunRegistry) Active(sessionID string) (bool, string) {
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		return false, ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	run, err := rr.store.GetActiveRun(ctx, sessionUUID, rr.staleBefore())
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			rr.logger.Warn("Failed to look up active run", zap.Error(err), zap.String("session_id", sessionID))
+		}
+		return false, ""
+	}
+	return true, run.UserMessageID
+}
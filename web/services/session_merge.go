@@ -0,0 +1,205 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"stats-agent/agent"
+	"stats-agent/database"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrMergeIntoSelf is returned when a session is merged into itself.
+var ErrMergeIntoSelf = errors.New("cannot merge a session into itself")
+
+// workspaceMove is a workspace entry moved from the source into the target workspace.
+type workspaceMove struct {
+	from, to string
+}
+
+// MergeSessions folds the source session into the target, for an analysis accidentally
+// split across two sessions. Rejected while the agent is running in either session.
+func (cs *ChatService) MergeSessions(ctx context.Context, sourceID, targetID uuid.UUID) (database.SessionMergeResult, error) {
+	for _, id := range []uuid.UUID{sourceID, targetID} {
+		if running, _ := cs.GetActiveRun(id.String()); running {
+			return database.SessionMergeResult{}, ErrRunInProgress
+		}
+	}
+	return mergeSessions(ctx, cs.store, cs.agent, cs.logger, sourceID, targetID)
+}
+
+// MergeSessions folds the source session into the target; it backs the merge-sessions
+// admin command.
+func (cs *CleanupService) MergeSessions(ctx context.Context, sourceID, targetID uuid.UUID) (database.SessionMergeResult, error) {
+	return mergeSessions(ctx, cs.store, cs.agent, cs.logger, sourceID, targetID)
+}
+
+// mergeSessions moves the source workspace into the target's, then merges the database
+// rows (Store.MergeSessions), which deletes the source session and leaves a redirect from
+// its ID. Files moved on disk are moved back if the database merge fails. Afterwards the
+// source's executor state is dropped and the target's lineage is rebuilt from the merged
+// history; its stored action results were moved with the rows.
+func mergeSessions(ctx context.Context, store database.Store, agent *agent.Agent, logger *zap.Logger, sourceID, targetID uuid.UUID) (database.SessionMergeResult, error) {
+	if sourceID == targetID {
+		return database.SessionMergeResult{}, ErrMergeIntoSelf
+	}
+	source, err := store.GetSessionByID(ctx, sourceID)
+	if err != nil {
+		return database.SessionMergeResult{}, fmt.Errorf("failed to get source session: %w", err)
+	}
+	target, err := store.GetSessionByID(ctx, targetID)
+	if err != nil {
+		return database.SessionMergeResult{}, fmt.Errorf("failed to get target session: %w", err)
+	}
+
+	fileNames, moves, err := mergeWorkspace(source.WorkspacePath, target.WorkspacePath, sourceID)
+	if err != nil {
+		undoWorkspaceMoves(moves, logger)
+		return database.SessionMergeResult{}, err
+	}
+	result, err := store.MergeSessions(ctx, sourceID, targetID, fileNames)
+	if err != nil {
+		undoWorkspaceMoves(moves, logger)
+		return result, err
+	}
+
+	agent.CleanupSession(sourceID.String())
+	if source.WorkspacePath != "" {
+		if err := os.RemoveAll(source.WorkspacePath); err != nil {
+			logger.Warn("Failed to delete merged workspace directory",
+				zap.Error(err),
+				zap.String("path", source.WorkspacePath))
+		}
+	}
+	if messages, err := store.GetMessagesBySession(ctx, targetID); err != nil {
+		logger.Warn("Failed to rebuild lineage of merged session", zap.Error(err), zap.String("session_id", targetID.String()))
+	} else {
+		agent.SetSessionLineage(targetID.String(), lineageFromMessages(messages))
+	}
+
+	logger.Info("Merged sessions",
+		zap.String("source_session_id", sourceID.String()),
+		zap.String("target_session_id", targetID.String()),
+		zap.Int64("messages", result.Messages),
+		zap.Int64("duplicate_messages", result.DuplicateMessages),
+		zap.Int64("documents", result.Documents),
+		zap.Int64("duplicate_documents", result.DuplicateDocuments),
+		zap.Int64("files", result.Files),
+		zap.Int64("duplicate_files", result.DuplicateFiles))
+	return result, nil
+}
+
+// mergeWorkspace moves the top-level entries of the source workspace into the target
+// workspace. An entry the target already has is left behind when both are files with
+// the same content, and otherwise moved under a name suffixed with the source session's
+// short ID. It returns the name each entry has in the target and the moves made.
+func mergeWorkspace(sourceDir, targetDir string, sourceID uuid.UUID) (map[string]string, []workspaceMove, error) {
+	fileNames := make(map[string]string)
+	if sourceDir == "" || targetDir == "" {
+		return fileNames, nil, nil
+	}
+	entries, err := os.ReadDir(sourceDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return fileNames, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read source workspace: %w", err)
+	}
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("could not create workspace: %w", err)
+	}
+
+	var moves []workspaceMove
+	for _, entry := range entries {
+		name := entry.Name()
+		from := filepath.Join(sourceDir, name)
+		newName := name
+		if _, err := os.Lstat(filepath.Join(targetDir, name)); err == nil {
+			if same, _ := sameFileContent(from, filepath.Join(targetDir, name)); same {
+				fileNames[name] = name
+				continue
+			}
+			newName = mergedFileName(targetDir, name, sourceID)
+		}
+		to := filepath.Join(targetDir, newName)
+		if err := os.Rename(from, to); err != nil {
+			return nil, moves, fmt.Errorf("failed to move %s into the merged workspace: %w", name, err)
+		}
+		moves = append(moves, workspaceMove{from: from, to: to})
+		fileNames[name] = newName
+	}
+	return fileNames, moves, nil
+}
+
+// mergedFileName returns a free name in dir for a conflicting entry, e.g. data.csv from
+// session 1a2b3c4d-... becomes data_1a2b3c4d.csv.
+func mergedFileName(dir, name string, sourceID uuid.UUID) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	suffix := sourceID.String()[:8]
+	candidate := stem + "_" + suffix + ext
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(filepath.Join(dir, candidate)); errors.Is(err, os.ErrNotExist) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_%s_%d%s", stem, suffix, i, ext)
+	}
+}
+
+// sameFileContent reports whether a and b are regular files with identical contents.
+func sameFileContent(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if !infoA.Mode().IsRegular() || !infoB.Mode().IsRegular() || infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+	hashA, err := fileSHA256(a)
+	if err != nil {
+		return false, err
+	}
+	hashB, err := fileSHA256(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(hashA, hashB), nil
+}
+
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// undoWorkspaceMoves moves entries back into the source workspace after a failed merge.
+func undoWorkspaceMoves(moves []workspaceMove, logger *zap.Logger) {
+	for i := len(moves) - 1; i >= 0; i-- {
+		if err := os.Rename(moves[i].to, moves[i].from); err != nil {
+			logger.Error("Failed to move file back after a failed session merge",
+				zap.Error(err),
+				zap.String("from", moves[i].to),
+				zap.String("to", moves[i].from))
+		}
+	}
+}
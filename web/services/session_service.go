@@ -15,11 +15,11 @@ import (
 )
 
 type SessionService struct {
-	store  *database.PostgresStore
+	store  database.Store
 	logger *zap.Logger
 }
 
-func NewSessionService(store *database.PostgresStore, logger *zap.Logger) *SessionService {
+func NewSessionService(store database.Store, logger *zap.Logger) *SessionService {
 	return &SessionService{
 		store:  store,
 		logger: logger,
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"stats-agent/config"
+	"stats-agent/database"
+	"stats-agent/web/types"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// shareTokenName scopes share link signatures, so a signed cookie value cannot be
+// replayed as a link token or the other way around.
+const shareTokenName = "share"
+
+// SharedPathPrefix is where share links and their workspace files are served.
+const SharedPathPrefix = "/shared/"
+
+// ErrShareNotFound is returned for share links that are malformed, unsigned, revoked or
+// expired. Callers cannot tell which, so a guessed link learns nothing.
+var ErrShareNotFound = errors.New("share link not found")
+
+// LinkSigner signs share link tokens with the server secret. *middleware.CookieSigner
+// implements it.
+type LinkSigner interface {
+	Sign(name, value string) (string, string, error)
+	Verify(name, signed string) (string, string, error)
+}
+
+// ShareService creates and resolves read-only share links. A link is a viewer
+// permission row whose ID is signed into the link token: the signature keeps unsigned
+// guesses away from the database, and the row lets the owner revoke the link.
+type ShareService struct {
+	store  database.Store
+	signer LinkSigner
+	cfg    *config.Config
+	logger *zap.Logger
+}
+
+// NewShareService creates a share service.
+func NewShareService(store database.Store, signer LinkSigner, cfg *config.Config, logger *zap.Logger) *ShareService {
+	return &ShareService{store: store, signer: signer, cfg: cfg, logger: logger}
+}
+
+// Enabled reports whether share links are turned on.
+func (ss *ShareService) Enabled() bool {
+	return ss.cfg.ShareLinksEnabled
+}
+
+// CreateLink grants a viewer permission on the session, expiring after SHARE_LINK_TTL.
+func (ss *ShareService) CreateLink(ctx context.Context, sessionID, createdBy uuid.UUID) (types.SessionShareLink, error) {
+	permission := types.SessionPermission{
+		SessionID: sessionID,
+		Role:      types.SessionRoleViewer,
+		CreatedBy: &createdBy,
+	}
+	if ss.cfg.ShareLinkTTL > 0 {
+		expiresAt := time.Now().Add(ss.cfg.ShareLinkTTL).UTC()
+		permission.ExpiresAt = &expiresAt
+	}
+	permission, err := ss.store.CreateSessionPermission(ctx, permission)
+	if err != nil {
+		return types.SessionShareLink{}, err
+	}
+	ss.logger.Info("Share link created",
+		zap.String("session_id", sessionID.String()),
+		zap.String("permission_id", permission.ID.String()))
+	return ss.link(permission)
+}
+
+// ListLinks returns the session's active links, newest first.
+func (ss *ShareService) ListLinks(ctx context.Context, sessionID uuid.UUID) ([]types.SessionShareLink, error) {
+	permissions, err := ss.store.ListSessionPermissions(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var links []types.SessionShareLink
+	for _, permission := range permissions {
+		if !permissionActive(permission, now) {
+			continue
+		}
+		link, err := ss.link(permission)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// RevokeLink revokes one of the session's links. Returns ErrShareNotFound if it has none
+// with that ID.
+func (ss *ShareService) RevokeLink(ctx context.Context, sessionID, permissionID uuid.UUID) error {
+	err := ss.store.RevokeSessionPermission(ctx, sessionID, permissionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrShareNotFound
+	}
+	return err
+}
+
+// Resolve returns the active permission a link token grants, or ErrShareNotFound.
+func (ss *ShareService) Resolve(ctx context.Context, token string) (types.SessionPermission, error) {
+	value, _, err := ss.signer.Verify(shareTokenName, token)
+	if err != nil {
+		return types.SessionPermission{}, ErrShareNotFound
+	}
+	permissionID, err := uuid.Parse(value)
+	if err != nil {
+		return types.SessionPermission{}, ErrShareNotFound
+	}
+	permission, err := ss.store.GetSessionPermission(ctx, permissionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return types.SessionPermission{}, ErrShareNotFound
+	}
+	if err != nil {
+		return types.SessionPermission{}, err
+	}
+	if !permissionActive(permission, time.Now()) {
+		return types.SessionPermission{}, ErrShareNotFound
+	}
+	return permission, nil
+}
+
+// link signs the permission ID into a link path. Tokens carry a fresh nonce, so listing
+// links again yields different but equally valid paths.
+func (ss *ShareService) link(permission types.SessionPermission) (types.SessionShareLink, error) {
+	token, _, err := ss.signer.Sign(shareTokenName, permission.ID.String())
+	if err != nil {
+		return types.SessionShareLink{}, fmt.Errorf("failed to sign share link: %w", err)
+	}
+	return types.SessionShareLink{Permission: permission, Path: SharedPathPrefix + token}, nil
+}
+
+// permissionActive reports whether a permission is neither revoked nor expired at now.
+func permissionActive(permission types.SessionPermission, now time.Time) bool {
+	if permission.RevokedAt != nil {
+		return false
+	}
+	return permission.ExpiresAt == nil || now.Before(*permission.ExpiresAt)
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"stats-agent/agent"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// sqlInjectMaxRows caps the rows of a console result added to the conversation, so a
+// large result doesn't flood the context.
+const sqlInjectMaxRows = 50
+
+// RunSQLQuery runs a read-only SQL console query over the session workspace. The query
+// runs in the session's Python executor, so it is refused while the agent is running and
+// holds the session's run slot until it finishes.
+// With inject, the query and its result (up to sqlInjectMaxRows rows) are saved to the
+// conversation and session memory, so the agent can build on them.
+func (cs *ChatService) RunSQLQuery(ctx context.Context, sessionID uuid.UUID, query string, inject bool) (*types.SQLResult, error) {
+	id := sessionID.String()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	token, ok := cs.claimIdleRun(id, cancel)
+	if !ok {
+		return nil, ErrRunInProgress
+	}
+	defer cs.deregisterRun(id, token)
+	result, err := cs.agent.QueryWorkspaceSQL(ctx, id, query)
+	if err != nil {
+		return nil, err
+	}
+	if !inject {
+		return result, nil
+	}
+
+	assistant := fmt.Sprintf("Ran a SQL query against the workspace files:\n```sql\n%s\n```", result.Query)
+	output := agent.FormatSQLResult(result, sqlInjectMaxRows)
+	if _, err := cs.messageService.SaveAssistantAndTool(ctx, id, assistant, &output, ""); err != nil {
+		cs.logger.Warn("Failed to add SQL result to the conversation", zap.Error(err), zap.String("session_id", id))
+		return result, nil
+	}
+	if ragInstance := cs.agent.GetRAG(); ragInstance != nil {
+		ragInstance.AddMessagesAsync(id, []types.AgentMessage{
+			{Role: "assistant", Content: assistant},
+			{Role: "tool", Content: output},
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"stats-agent/agent"
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrUnknownStep is returned when bookmarking a message that is not an executed step of the session.
+var ErrUnknownStep = errors.New("unknown step")
+
+// ErrUnknownBookmark is returned for a bookmark that does not belong to the session.
+var ErrUnknownBookmark = errors.New("unknown bookmark")
+
+// maxBookmarkLabelLength bounds bookmark labels.
+const maxBookmarkLabelLength = 120
+
+// ExecutedSteps returns the session's executed code blocks, numbered like the methods
+// pack, with their bookmarks.
+func (cs *ChatService) ExecutedSteps(ctx context.Context, sessionID uuid.UUID) ([]types.ExecutedStep, error) {
+	messages, err := cs.store.GetMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session messages: %w", err)
+	}
+	bookmarks, err := cs.store.GetStepBookmarks(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return executedSteps(collectMethodsSteps(messages), bookmarks), nil
+}
+
+// BookmarkStep bookmarks the executed step whose output is the tool message messageID.
+// Bookmarking a step again replaces its label.
+func (cs *ChatService) BookmarkStep(ctx context.Context, sessionID uuid.UUID, messageID, label string) error {
+	messageUUID, err := uuid.Parse(messageID)
+	if err != nil {
+		return ErrUnknownStep
+	}
+	steps, err := cs.ExecutedSteps(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	step := findExecutedStep(steps, messageID)
+	if step == nil {
+		return ErrUnknownStep
+	}
+
+	label = strings.TrimSpace(label)
+	if label == "" {
+		label = fmt.Sprintf("Step %d", step.Number)
+	}
+	if len(label) > maxBookmarkLabelLength {
+		label = label[:maxBookmarkLabelLength]
+	}
+	_, err = cs.store.CreateStepBookmark(ctx, types.StepBookmark{
+		SessionID: sessionID,
+		MessageID: messageUUID,
+		Label:     label,
+	})
+	return err
+}
+
+// DeleteStepBookmark removes one of the session's bookmarks.
+func (cs *ChatService) DeleteStepBookmark(ctx context.Context, sessionID, bookmarkID uuid.UUID) error {
+	return cs.store.DeleteStepBookmark(ctx, sessionID, bookmarkID)
+}
+
+// JumpToBookmark restores the session's Python state to a bookmarked step by replaying
+// the executed steps up to and including it (steps that failed originally are skipped).
+// Later steps stay in the conversation, and a tool message records the jump so the agent
+// knows their variables are gone. Rejected while an agent run is active; the jump holds
+// the session's run slot until it finishes.
+func (cs *ChatService) JumpToBookmark(ctx context.Context, sessionID, bookmarkID uuid.UUID) (*types.ExecutedStep, *agent.ReplayResult, error) {
+	id := sessionID.String()
+	// Held until the replay is done, so no agent turn starts between the check and the reset
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	token, ok := cs.claimIdleRun(id, cancel)
+	if !ok {
+		return nil, nil, ErrRunInProgress
+	}
+	defer cs.deregisterRun(id, token)
+
+	bookmarks, err := cs.store.GetStepBookmarks(ctx, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var bookmark *types.StepBookmark
+	for i := range bookmarks {
+		if bookmarks[i].ID == bookmarkID {
+			bookmark = &bookmarks[i]
+			break
+		}
+	}
+	if bookmark == nil {
+		return nil, nil, ErrUnknownBookmark
+	}
+
+	messages, err := cs.store.GetMessagesBySession(ctx, sessionID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load session messages: %w", err)
+	}
+	methodsSteps := collectMethodsSteps(messages)
+	steps := executedSteps(methodsSteps, bookmarks)
+	target := findExecutedStep(steps, bookmark.MessageID.String())
+	if target == nil {
+		// The step's messages were deleted since it was bookmarked
+		return nil, nil, ErrUnknownStep
+	}
+
+	var replay []agent.ReplayStep
+	for i, s := range methodsSteps[:target.Number] {
+		if stepFailed(s.Output) {
+			continue
+		}
+		replay = append(replay, agent.ReplayStep{Number: i + 1, Code: s.Code})
+	}
+
+	uploadedFiles, err := workspaceDataFiles(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if session, err := cs.store.GetSessionByID(ctx, sessionID); err == nil {
+		cs.agent.SetSessionRandomSeed(id, session.RandomSeed)
+	} else {
+		cs.logger.Warn("Failed to load session random seed", zap.Error(err), zap.String("session_id", id))
+	}
+
+	result, err := cs.agent.ReplaySteps(ctx, id, uploadedFiles, replay)
+	if err != nil {
+		return nil, nil, err
+	}
+	cs.agent.SetSessionLineage(id, lineageFromMessages(messagesThrough(messages, target.MessageID)))
+
+	note := jumpBackNote(bookmark.Label, target.Number, len(steps), result)
+	if _, err := cs.messageService.SaveAssistantAndTool(ctx, id, "", &note, ""); err != nil {
+		cs.logger.Warn("Failed to record jump back in the conversation", zap.Error(err), zap.String("session_id", id))
+	}
+	return target, result, nil
+}
+
+// executedSteps numbers the steps and attaches their bookmarks.
+func executedSteps(methodsSteps []methodsStep, bookmarks []types.StepBookmark) []types.ExecutedStep {
+	byMessage := make(map[string]*types.StepBookmark, len(bookmarks))
+	for i := range bookmarks {
+		byMessage[bookmarks[i].MessageID.String()] = &bookmarks[i]
+	}
+	steps := make([]types.ExecutedStep, 0, len(methodsSteps))
+	for i, s := range methodsSteps {
+		steps = append(steps, types.ExecutedStep{
+			Number:     i + 1,
+			MessageID:  s.MessageID,
+			Code:       s.Code,
+			Failed:     stepFailed(s.Output),
+			UserEdited: s.UserEdited,
+			ExecutedAt: s.ExecutedAt,
+			Bookmark:   byMessage[s.MessageID],
+		})
+	}
+	return steps
+}
+
+func findExecutedStep(steps []types.ExecutedStep, messageID string) *types.ExecutedStep {
+	for i := range steps {
+		if steps[i].MessageID == messageID {
+			return &steps[i]
+		}
+	}
+	return nil
+}
+
+// messagesThrough returns the messages up to and including messageID.
+func messagesThrough(messages []types.ChatMessage, messageID string) []types.ChatMessage {
+	for i, m := range messages {
+		if m.ID == messageID {
+			return messages[:i+1]
+		}
+	}
+	return messages
+}
+
+// jumpBackNote is the tool message recording a jump back, worded for the agent.
+func jumpBackNote(label string, stepNumber, totalSteps int, result *agent.ReplayResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Python state restored to bookmark %q (step %d of %d): the session was reset and %d executed step(s) up to it were re-run.", label, stepNumber, totalSteps, result.Replayed)
+	if stepNumber < totalSteps {
+		fmt.Fprintf(&b, " Variables and models created by steps %d-%d no longer exist; files they saved remain in the workspace.", stepNumber+1, totalSteps)
+	}
+	if len(result.Failed) > 0 {
+		failed := make([]string, len(result.Failed))
+		for i, n := range result.Failed {
+			failed[i] = fmt.Sprint(n)
+		}
+		fmt.Fprintf(&b, " Warning: step(s) %s raised an error on replay, so the state may differ from the original run.", strings.Join(failed, ", "))
+	}
+	return b.String()
+}
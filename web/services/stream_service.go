@@ -4,11 +4,15 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"stats-agent/config"
+	"stats-agent/web/problem"
 	"strings"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -18,43 +22,161 @@ type StreamData struct {
 	Content string `json:"content,omitempty"`
 }
 
+// ErrorEvent is an SSE "error" frame. Its content is a problem+json body, so clients branch
+// on the problem code like they do for HTTP error responses.
+func ErrorEvent(status int, code problem.Code, detail string) StreamData {
+	return StreamData{Type: "error", Content: problem.New(status, code, detail).JSON()}
+}
+
 type StreamService struct {
 	logger *zap.Logger
+	cfg    *config.Config
 }
 
-func NewStreamService(logger *zap.Logger) *StreamService {
+func NewStreamService(logger *zap.Logger, cfg *config.Config) *StreamService {
 	return &StreamService{
 		logger: logger,
+		cfg:    cfg,
 	}
 }
 
-// WriteSSEData is a helper to write SSE formatted data safely.
-func (ss *StreamService) WriteSSEData(ctx context.Context, w http.ResponseWriter, data StreamData, mu *sync.Mutex) error {
-	mu.Lock()
-	defer mu.Unlock()
+// ErrStreamClosed is returned by StreamConn writes after the connection was closed.
+var ErrStreamClosed = errors.New("stream connection closed")
+
+// StreamConn carries StreamData frames to one client, over SSE (SSEConn) or a
+// WebSocket (WSConn). Writes are serialized; once Done is closed they fail, so a run
+// can carry on in the background after the client left.
+type StreamConn interface {
+	Write(data StreamData) error
+	Done() <-chan struct{}
+	Close()
+}
+
+// SSEConn wraps one SSE response. It serializes writes, sends heartbeat comments
+// during quiet periods so reverse proxies keep the stream open, and closes itself
+// when the client disconnects, a write stalls, or no data was sent for the idle
+// timeout. After Done is closed no further writes reach the ResponseWriter, so the
+// handler can return while background work continues.
+type SSEConn struct {
+	ss  *StreamService
+	ctx context.Context
+	w   http.ResponseWriter
+	rc  *http.ResponseController
 
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
+	mu       sync.Mutex
+	closed   bool
+	lastData time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewSSEConn starts heartbeats for an SSE response. Callers must Close it before
+// the handler returns.
+func (ss *StreamService) NewSSEConn(ctx context.Context, w http.ResponseWriter) *SSEConn {
+	conn := &SSEConn{
+		ss:       ss,
+		ctx:      ctx,
+		w:        w,
+		rc:       http.NewResponseController(w),
+		lastData: time.Now(),
+		done:     make(chan struct{}),
 	}
+	go conn.heartbeat()
+	return conn
+}
 
+// Write sends an SSE data event. Failed or timed-out writes close the connection.
+func (c *SSEConn) Write(data StreamData) error {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
+	if err := c.write(fmt.Sprintf("data: %s\n\n", jsonData)); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.lastData = time.Now()
+	c.mu.Unlock()
+	return nil
+}
 
-	_, err = fmt.Fprintf(w, "data: %s\n\n", jsonData)
-	if err != nil {
+// Done is closed once the connection no longer accepts writes.
+func (c *SSEConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close stops heartbeats and detaches from the ResponseWriter. Safe to call repeatedly.
+func (c *SSEConn) Close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+func (c *SSEConn) write(payload string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrStreamClosed
+	}
+	if err := c.ctx.Err(); err != nil {
 		return err
 	}
 
-	if flusher, ok := w.(http.Flusher); ok {
-		flusher.Flush()
+	// A write deadline turns a client that stopped reading into an error instead of a
+	// goroutine blocked forever. Writers that don't support deadlines are written as-is.
+	if err := c.rc.SetWriteDeadline(time.Now().Add(c.ss.cfg.SSEWriteTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		c.ss.logger.Debug("Failed to set SSE write deadline", zap.Error(err))
+	}
+
+	_, err := io.WriteString(c.w, payload)
+	if err == nil {
+		err = c.rc.Flush()
+		if errors.Is(err, http.ErrNotSupported) {
+			err = nil
+		}
+	}
+	if err != nil {
+		c.ss.logger.Info("SSE write failed, closing stream", zap.Error(err))
+		c.closed = true
+		c.closeOnce.Do(func() { close(c.done) })
+		return err
 	}
 	return nil
 }
 
+func (c *SSEConn) heartbeat() {
+	ticker := time.NewTicker(c.ss.cfg.SSEHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.ctx.Done():
+			c.Close()
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			idle := time.Since(c.lastData)
+			c.mu.Unlock()
+
+			if c.ss.cfg.SSEIdleTimeout > 0 && idle >= c.ss.cfg.SSEIdleTimeout {
+				c.ss.logger.Info("Closing idle SSE stream", zap.Duration("idle", idle))
+				_ = c.Write(StreamData{Type: "idle_timeout"})
+				c.Close()
+				return
+			}
+			// SSE comment lines are ignored by EventSource but count as traffic for proxies
+			if err := c.write(": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
 // ProcessStreamByWord reads from an io.Reader and processes output word-by-word for SSE streaming.
 // Simplified version that just passes through content with minimal processing.
 func (ss *StreamService) ProcessStreamByWord(ctx context.Context, r io.Reader, writeFunc func(StreamData) error) {
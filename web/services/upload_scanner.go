@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"stats-agent/config"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// FileScanner checks an upload's contents for malware. Implementations other than
+// ClamdScanner can be passed to NewUploadScanner.
+type FileScanner interface {
+	// Scan returns the detected signature, or "" when the content is clean. An error means
+	// the content could not be scanned.
+	Scan(ctx context.Context, r io.Reader) (string, error)
+}
+
+// InfectedFileError is returned for uploads the scanner flagged.
+type InfectedFileError struct {
+	Filename  string
+	Signature string
+}
+
+func (e *InfectedFileError) Error() string {
+	return fmt.Sprintf("upload rejected: %s failed the virus scan (%s) and was quarantined", e.Filename, e.Signature)
+}
+
+// ErrScannerUnavailable is returned when an upload cannot be scanned and fail-open is off.
+var ErrScannerUnavailable = errors.New("upload rejected: the virus scanner is unavailable, please try again later")
+
+// UploadScanner scans uploads before they are written to the workspace and quarantines
+// infected files.
+type UploadScanner struct {
+	scanner       FileScanner
+	timeout       time.Duration
+	failOpen      bool
+	quarantineDir string
+	logger        *zap.Logger
+}
+
+// NewUploadScanner wraps scanner with the UPLOAD_SCAN_* settings. Returns nil when
+// scanning is disabled; a nil *UploadScanner accepts every upload.
+func NewUploadScanner(cfg *config.Config, scanner FileScanner, logger *zap.Logger) *UploadScanner {
+	if !cfg.UploadScanEnabled || scanner == nil {
+		return nil
+	}
+	return &UploadScanner{
+		scanner:       scanner,
+		timeout:       cfg.UploadScanTimeout,
+		failOpen:      cfg.UploadScanFailOpen,
+		quarantineDir: cfg.UploadQuarantineDir,
+		logger:        logger,
+	}
+}
+
+// ScanUpload scans the uploaded file. Infected files are copied to the quarantine
+// directory and reported as *InfectedFileError; scanner failures return
+// ErrScannerUnavailable unless fail-open is configured.
+func (s *UploadScanner) ScanUpload(ctx context.Context, file *multipart.FileHeader, sessionID uuid.UUID, sanitizedFilename string) error {
+	if s == nil {
+		return nil
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open uploaded file: %w", err)
+	}
+	defer src.Close()
+
+	scanCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	start := time.Now()
+	signature, err := s.scanner.Scan(scanCtx, src)
+	if err != nil {
+		if s.failOpen {
+			s.logger.Warn("Virus scan failed; accepting upload (fail-open)",
+				zap.Error(err),
+				zap.String("filename", sanitizedFilename),
+				zap.String("session_id", sessionID.String()))
+			return nil
+		}
+		s.logger.Error("Virus scan failed; rejecting upload",
+			zap.Error(err),
+			zap.String("filename", sanitizedFilename),
+			zap.String("session_id", sessionID.String()))
+		return ErrScannerUnavailable
+	}
+	if signature == "" {
+		s.logger.Debug("Upload passed virus scan",
+			zap.String("filename", sanitizedFilename),
+			zap.Duration("elapsed", time.Since(start)))
+		return nil
+	}
+
+	s.logger.Warn("Upload failed virus scan",
+		zap.String("filename", sanitizedFilename),
+		zap.String("signature", signature),
+		zap.String("session_id", sessionID.String()))
+	if err := s.quarantine(src, sessionID, sanitizedFilename); err != nil {
+		s.logger.Error("Failed to quarantine infected upload",
+			zap.Error(err),
+			zap.String("filename", sanitizedFilename))
+	}
+	return &InfectedFileError{Filename: sanitizedFilename, Signature: signature}
+}
+
+// quarantine copies the upload out of the request into the quarantine directory, named
+// by time and session so reviewers can trace it. The file is never readable by others.
+func (s *UploadScanner) quarantine(src multipart.File, sessionID uuid.UUID, filename string) error {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind upload: %w", err)
+	}
+	if err := os.MkdirAll(s.quarantineDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	name := fmt.Sprintf("%s_%s_%s.quarantined", time.Now().UTC().Format("20060102T150405Z"), sessionID, filename)
+	out, err := os.OpenFile(filepath.Join(s.quarantineDir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create quarantine file: %w", err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, src); err != nil {
+		return fmt.Errorf("failed to write quarantine file: %w", err)
+	}
+	return nil
+}
+
+// ClamdScanner scans content with a ClamAV daemon using the INSTREAM command.
+type ClamdScanner struct {
+	network string
+	address string
+}
+
+// clamdChunkSize is the size of each INSTREAM chunk.
+const clamdChunkSize = 64 * 1024
+
+// NewClamdScanner creates a scanner for a clamd address of the form tcp://host:port or
+// unix:///path/to/socket.
+func NewClamdScanner(address string) (*ClamdScanner, error) {
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		return &ClamdScanner{network: "tcp", address: strings.TrimPrefix(address, "tcp://")}, nil
+	case strings.HasPrefix(address, "unix://"):
+		return &ClamdScanner{network: "unix", address: strings.TrimPrefix(address, "unix://")}, nil
+	default:
+		return nil, fmt.Errorf("unsupported clamd address %q", address)
+	}
+}
+
+// Scan streams r to clamd and parses its verdict ("stream: OK" or "stream: <sig> FOUND").
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return "", fmt.Errorf("failed to set clamd deadline: %w", err)
+		}
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", fmt.Errorf("failed to send clamd command: %w", err)
+	}
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			if _, err := conn.Write(size[:]); err != nil {
+				return "", fmt.Errorf("failed to stream to clamd: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return "", fmt.Errorf("failed to stream to clamd: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return "", fmt.Errorf("failed to read upload: %w", readErr)
+		}
+	}
+	// A zero-length chunk ends the stream
+	binary.BigEndian.PutUint32(size[:], 0)
+	if _, err := conn.Write(size[:]); err != nil {
+		return "", fmt.Errorf("failed to finish clamd stream: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\x00')
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamdReply(reply)
+}
+
+func parseClamdReply(reply string) (string, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	verdict := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case verdict == "OK":
+		return "", nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return strings.TrimSpace(strings.TrimSuffix(verdict, " FOUND")), nil
+	default:
+		// e.g. "INSTREAM size limit exceeded. ERROR"
+		return "", fmt.Errorf("clamd error: %s", reply)
+	}
+}
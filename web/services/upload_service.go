@@ -1,13 +1,18 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"html"
 	"mime/multipart"
 	"os"
 	"path/filepath"
 	"stats-agent/database"
+	pdfTypes "stats-agent/pdf"
 	"stats-agent/rag"
+	"stats-agent/tools"
+	"stats-agent/web/templates/components"
 	"strings"
 	"time"
 
@@ -17,12 +22,15 @@ import (
 
 const (
 	MaxPDFSize = 10 * 1024 * 1024 // 10MB
+	// Parquet and gzipped CSV expand several times over when loaded, so they are capped
+	MaxCompressedDatasetSize = 50 * 1024 * 1024 // 50MB
 )
 
 type UploadService struct {
-	store      *database.PostgresStore
+	store      database.Store
 	pdfService *PDFService
-	ragGetter  RAGGetter // Interface to get RAG instance
+	scanner    *UploadScanner // nil when virus scanning is disabled
+	ragGetter  RAGGetter      // Interface to get RAG instance
 	logger     *zap.Logger
 }
 
@@ -41,14 +49,16 @@ type UploadResult struct {
 }
 
 func NewUploadService(
-	store *database.PostgresStore,
+	store database.Store,
 	pdfService *PDFService,
+	scanner *UploadScanner,
 	ragGetter RAGGetter,
 	logger *zap.Logger,
 ) *UploadService {
 	return &UploadService{
 		store:      store,
 		pdfService: pdfService,
+		scanner:    scanner,
 		ragGetter:  ragGetter,
 		logger:     logger,
 	}
@@ -63,20 +73,32 @@ func (us *UploadService) ValidateFile(file *multipart.FileHeader) (string, strin
 		return "", "", fmt.Errorf("invalid or unsafe filename")
 	}
 
-	// Check file type
-	ext := strings.ToLower(filepath.Ext(file.Filename))
-	if ext != ".csv" && ext != ".xlsx" && ext != ".xls" && ext != ".pdf" {
-		return "", "", fmt.Errorf("invalid file type. Please upload CSV, Excel, or PDF files")
+	// Check file type (".csv.gz" is one type)
+	ext := UploadExt(file.Filename)
+	if ext == "" {
+		return "", "", fmt.Errorf("invalid file type. Please upload CSV (optionally gzipped), Excel, Parquet, or PDF files")
 	}
 
-	// Check PDF size limit
+	// Check size limits
 	if ext == ".pdf" && file.Size > MaxPDFSize {
 		return "", "", fmt.Errorf("PDF file too large. Maximum size is 10MB")
 	}
+	if (ext == ".parquet" || ext == ".csv.gz") && file.Size > MaxCompressedDatasetSize {
+		return "", "", fmt.Errorf("%s file too large. Maximum size is 50MB", strings.TrimPrefix(ext, "."))
+	}
 
 	return sanitizedFilename, ext, nil
 }
 
+// UploadExt returns the type suffix of an uploadable file in lower case: ".pdf" or a
+// dataset suffix such as ".csv.gz" (see tools.DatasetExt). It is "" for other files.
+func UploadExt(filename string) string {
+	if strings.ToLower(filepath.Ext(filename)) == ".pdf" {
+		return ".pdf"
+	}
+	return tools.DatasetExt(filename)
+}
+
 // SaveFile saves the uploaded file to the workspace directory.
 // Returns the web path of the saved file.
 func (us *UploadService) SaveFile(
@@ -111,6 +133,14 @@ func (us *UploadService) SaveFile(
 		return "", fmt.Errorf("file verification failed after upload")
 	}
 
+	// Uploads are source data: executed code may read but not modify them
+	if err := tools.ProtectWorkspaceFiles(workspaceDir, sanitizedFilename); err != nil {
+		us.logger.Warn("Failed to protect uploaded file",
+			zap.Error(err),
+			zap.String("filename", sanitizedFilename),
+			zap.String("session_id", sessionID.String()))
+	}
+
 	webPath := filepath.ToSlash(filepath.Join("/workspaces", sessionID.String(), sanitizedFilename))
 	return webPath, nil
 }
@@ -129,6 +159,11 @@ func (us *UploadService) ProcessUpload(
 		return nil, err
 	}
 
+	// Scan before anything reaches the workspace
+	if err := us.scanner.ScanUpload(ctx, file, sessionID, sanitizedFilename); err != nil {
+		return nil, err
+	}
+
 	// Save file
 	webPath, err := us.SaveFile(file, sessionID, sanitizedFilename)
 	if err != nil {
@@ -174,8 +209,17 @@ func (us *UploadService) ProcessUpload(
 		return us.processPDFUpload(ctx, sanitizedFilename, webPath, file.Filename, sessionID, userMessage)
 	}
 
-	// Handle dataset files (CSV, Excel)
-	return us.processDatasetUpload(file.Filename, userMessage), nil
+	// Handle dataset files (CSV, Excel, Parquet)
+	result := us.processDatasetUpload(sanitizedFilename, file.Filename, userMessage)
+	switch ext {
+	case ".xlsx":
+		us.describeExcelSheets(ctx, sessionID, result)
+	case ".parquet", ".csv.gz":
+		// The system prompt's examples read CSV; point the agent at the right reader
+		result.ContentMessage += fmt.Sprintf("\n\nRead this file with df = %s (or load_dataset(%q)).",
+			tools.PandasReadCall(sanitizedFilename), sanitizedFilename)
+	}
+	return result, nil
 }
 
 // processPDFUpload extracts pages and stores them in RAG.
@@ -205,7 +249,14 @@ func (us *UploadService) processPDFUpload(
 	workspaceDir := filepath.Join("workspaces", sessionID.String())
 	dst := filepath.Join(workspaceDir, sanitizedFilename)
 
-	pages, err := us.pdfService.ExtractPages(dst)
+	pages, quality, err := us.pdfService.ExtractPagesScored(dst)
+	if err == nil {
+		// Tell the agent (and user) when the extracted text can't be relied on
+		if note := pdfQualityNote(quality, us.pdfService.QualityThreshold()); note != "" {
+			contentMessage += "\n\n" + note
+			displayMessage += "<br><br><em>" + note + "</em>"
+		}
+	}
 	if err != nil {
 		us.logger.Error("Failed to extract PDF pages for RAG",
 			zap.Error(err),
@@ -240,8 +291,20 @@ func (us *UploadService) processPDFUpload(
 	}, nil
 }
 
-// processDatasetUpload formats messages for CSV/Excel uploads.
-func (us *UploadService) processDatasetUpload(originalFilename string, userMessage string) *UploadResult {
+// pdfQualityNote describes a PDF extraction that needs OCR or scored below threshold;
+// empty for usable text.
+func pdfQualityNote(quality pdfTypes.Quality, threshold float64) string {
+	switch {
+	case quality.NeedsOCR:
+		return "Note: little text could be extracted from this PDF. It looks scanned and needs OCR before its content can be searched."
+	case quality.Score < threshold:
+		return fmt.Sprintf("Note: the text extracted from this PDF looks partly garbled (quality %.2f); passages quoted from it may be inaccurate.", quality.Score)
+	}
+	return ""
+}
+
+// processDatasetUpload formats messages for CSV, Excel and Parquet uploads.
+func (us *UploadService) processDatasetUpload(sanitizedFilename, originalFilename string, userMessage string) *UploadResult {
 	var contentMessage string
 	if strings.TrimSpace(userMessage) == "" {
 		contentMessage = fmt.Sprintf("I've uploaded %s. Please analyze this dataset and provide statistical insights.", originalFilename)
@@ -250,7 +313,7 @@ func (us *UploadService) processDatasetUpload(originalFilename string, userMessa
 	}
 
 	return &UploadResult{
-		Filename:         originalFilename,
+		Filename:         sanitizedFilename,
 		FilePath:         "", // Not needed for display in dataset mode
 		FileType:         "csv",
 		DisplayMessage:   "", // Will use ContentMessage
@@ -259,6 +322,38 @@ func (us *UploadService) processDatasetUpload(originalFilename string, userMessa
 	}
 }
 
+// describeExcelSheets adds the sheets of a multi-sheet workbook to the upload's messages:
+// as an <excel_sheets> block telling the agent how to load each one, and as a card with a
+// preview per sheet for the user. Single-sheet workbooks and unreadable files are left as
+// they are.
+func (us *UploadService) describeExcelSheets(ctx context.Context, sessionID uuid.UUID, upload *UploadResult) {
+	path := filepath.Join("workspaces", sessionID.String(), upload.Filename)
+	sheets, err := ReadExcelSheets(path)
+	if err != nil {
+		us.logger.Warn("Failed to read Excel sheets",
+			zap.Error(err),
+			zap.String("filename", upload.Filename),
+			zap.String("session_id", sessionID.String()))
+		return
+	}
+	if len(sheets) < 2 {
+		return
+	}
+
+	var card bytes.Buffer
+	if err := components.ExcelSheetsCard(upload.Filename, sheets).Render(ctx, &card); err != nil {
+		us.logger.Warn("Failed to render Excel sheets card", zap.Error(err), zap.String("session_id", sessionID.String()))
+		return
+	}
+	if upload.DisplayMessage == "" {
+		upload.DisplayMessage = strings.ReplaceAll(html.EscapeString(upload.ContentMessage), "\n", "<br>")
+	}
+	upload.DisplayMessage += card.String()
+	upload.ContentMessage += "\n\n<excel_sheets>\n" + FormatExcelSheets(upload.Filename, sheets) +
+		fmt.Sprintf("\n</excel_sheets>\nLoad a sheet by name with load_dataset(%q, sheet=\"<sheet name>\"); without sheet= only the first sheet is read. "+
+			"Ask which sheet to analyze when the request does not make it clear.", upload.Filename)
+}
+
 // sanitizeFilename sanitizes user-provided filenames for safe storage.
 func sanitizeFilename(filename string) string {
 	// Trim leading/trailing spaces and dots
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"stats-agent/web/types"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrUnknownFile is returned for a file that is not in the session's workspace.
+var ErrUnknownFile = errors.New("unknown file")
+
+// ErrInvalidFilename is returned for a new filename that is not a plain file name or that
+// changes the file's extension.
+var ErrInvalidFilename = errors.New("invalid filename")
+
+// ErrFileExists is returned when renaming onto a file that already exists.
+var ErrFileExists = errors.New("file already exists")
+
+// workspaceFilePath returns the path of a file directly in the session's workspace. Names
+// with path separators, "." and ".." and hidden files are rejected, so a request cannot
+// reach outside the workspace.
+func workspaceFilePath(sessionID uuid.UUID, name string) (string, bool) {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\\x00") {
+		return "", false
+	}
+	workspaceDir := filepath.Join("workspaces", sessionID.String())
+	fullPath := filepath.Join(workspaceDir, name)
+	if filepath.Dir(fullPath) != workspaceDir {
+		return "", false
+	}
+	return fullPath, true
+}
+
+// WorkspaceFiles lists the regular files directly in the session's workspace, newest first.
+func (fs *FileService) WorkspaceFiles(sessionID uuid.UUID) ([]types.WorkspaceFile, error) {
+	entries, err := os.ReadDir(filepath.Join("workspaces", sessionID.String()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read workspace directory: %w", err)
+	}
+
+	files := make([]types.WorkspaceFile, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if _, ok := workspaceFilePath(sessionID, entry.Name()); !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+		files = append(files, types.WorkspaceFile{
+			Name:       entry.Name(),
+			URL:        workspaceFileURL(sessionID, entry.Name()),
+			Kind:       workspaceFileType(entry.Name()),
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime(),
+		})
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].ModifiedAt.After(files[j].ModifiedAt) })
+	return files, nil
+}
+
+// workspaceFileURL is the download URL of a workspace file.
+func workspaceFileURL(sessionID uuid.UUID, name string) string {
+	return "/session/" + sessionID.String() + "/files/" + url.PathEscape(name)
+}
+
+// WorkspaceFile returns the path of one of the session's workspace files for download.
+func (fs *FileService) WorkspaceFile(sessionID uuid.UUID, name string) (string, error) {
+	fullPath, ok := workspaceFilePath(sessionID, name)
+	if !ok {
+		return "", ErrUnknownFile
+	}
+	if info, err := os.Lstat(fullPath); err != nil || !info.Mode().IsRegular() {
+		return "", ErrUnknownFile
+	}
+	return fullPath, nil
+}
+
+// RenameWorkspaceFile renames one of the session's workspace files and its files record.
+// The new name is sanitized like the agent's output files and must keep the extension.
+// Returns the new name.
+func (fs *FileService) RenameWorkspaceFile(ctx context.Context, sessionID uuid.UUID, name, newName string) (string, error) {
+	oldPath, err := fs.WorkspaceFile(sessionID, name)
+	if err != nil {
+		return "", err
+	}
+	newName = sanitizeOutputFilename(strings.TrimSpace(newName))
+	newPath, ok := workspaceFilePath(sessionID, newName)
+	if !ok || !strings.EqualFold(filepath.Ext(newName), filepath.Ext(name)) || strings.TrimSuffix(newName, filepath.Ext(newName)) == "" {
+		return "", ErrInvalidFilename
+	}
+	if newName == name {
+		return name, nil
+	}
+	if _, err := os.Lstat(newPath); err == nil {
+		return "", ErrFileExists
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return "", fmt.Errorf("failed to rename workspace file: %w", err)
+	}
+	webPath := filepath.ToSlash(filepath.Join("/workspaces", sessionID.String(), newName))
+	if err := fs.store.RenameFile(ctx, sessionID, name, newName, webPath); err != nil {
+		// The file on disk is renamed; the next run records it under its new name
+		fs.logger.Warn("Failed to rename file record",
+			zap.Error(err),
+			zap.String("session_id", sessionID.String()),
+			zap.String("filename", name))
+	}
+	return newName, nil
+}
+
+// DeleteWorkspaceFile deletes one of the session's workspace files and its files record.
+func (fs *FileService) DeleteWorkspaceFile(ctx context.Context, sessionID uuid.UUID, name string) error {
+	fullPath, err := fs.WorkspaceFile(sessionID, name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(fullPath); err != nil {
+		return fmt.Errorf("failed to delete workspace file: %w", err)
+	}
+	// Uploads have no record until a run picks them up
+	if record, err := fs.store.GetFileBySessionAndName(ctx, sessionID, name); err == nil {
+		if err := fs.store.DeleteFile(ctx, record.ID); err != nil {
+			fs.logger.Warn("Failed to delete file record",
+				zap.Error(err),
+				zap.String("session_id", sessionID.String()),
+				zap.String("filename", name))
+		}
+	}
+	return nil
+}
+
+// WorkspaceFiles lists the session's workspace files, newest first.
+func (cs *ChatService) WorkspaceFiles(sessionID uuid.UUID) ([]types.WorkspaceFile, error) {
+	return cs.fileService.WorkspaceFiles(sessionID)
+}
+
+// WorkspaceFile returns the path of one of the session's workspace files for download.
+func (cs *ChatService) WorkspaceFile(sessionID uuid.UUID, name string) (string, error) {
+	return cs.fileService.WorkspaceFile(sessionID, name)
+}
+
+// RenameWorkspaceFile renames a workspace file, and the dataset it holds in the agent's
+// registry. Rejected while the agent is running, since the run may be reading the file.
+// Earlier messages keep linking to the old name.
+func (cs *ChatService) RenameWorkspaceFile(ctx context.Context, sessionID uuid.UUID, name, newName string) (string, error) {
+	if running, _ := cs.GetActiveRun(sessionID.String()); running {
+		return "", ErrRunInProgress
+	}
+	newName, err := cs.fileService.RenameWorkspaceFile(ctx, sessionID, name, newName)
+	if err != nil {
+		return "", err
+	}
+	cs.agent.RenameDataset(sessionID.String(), name, newName)
+	return newName, nil
+}
+
+// DeleteWorkspaceFile deletes a workspace file and forgets the dataset it held. Rejected
+// while the agent is running.
+func (cs *ChatService) DeleteWorkspaceFile(ctx context.Context, sessionID uuid.UUID, name string) error {
+	if running, _ := cs.GetActiveRun(sessionID.String()); running {
+		return ErrRunInProgress
+	}
+	if err := cs.fileService.DeleteWorkspaceFile(ctx, sessionID, name); err != nil {
+		return err
+	}
+	cs.agent.RemoveDataset(sessionID.String(), name)
+	return nil
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// websocketGUID is appended to the client key to derive Sec-WebSocket-Accept (RFC 6455 4.2.2).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWSClientFrame caps client frames; the client only sends control frames.
+const maxWSClientFrame = 64 << 10
+
+// WebSocket opcodes used by the stream transport.
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+	wsOpPing  = 0x9
+	wsOpPong  = 0xA
+)
+
+var (
+	// ErrNotWebSocket is returned for requests that are not a version 13 WebSocket handshake.
+	ErrNotWebSocket = errors.New("not a websocket handshake")
+	// ErrWebSocketOrigin is returned for handshakes from another origin. Browsers send
+	// cookies with cross-site WebSocket handshakes, so they must be refused.
+	ErrWebSocketOrigin = errors.New("websocket origin not allowed")
+)
+
+// WSConn carries StreamData frames over a WebSocket, one JSON text message per frame, the
+// same payload SSEConn sends as a data event. Like SSEConn it serializes writes, pings
+// during quiet periods, closes after the idle timeout, and stops accepting writes when the
+// client disconnects (detected by its read loop) or a write stalls.
+type WSConn struct {
+	ss   *StreamService
+	conn net.Conn
+	bw   *bufio.Writer
+
+	mu       sync.Mutex
+	closed   bool
+	lastData time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// UpgradeWebSocket completes the WebSocket handshake and takes over the connection.
+// On error nothing was written, so the caller can still send an HTTP error response.
+// Callers must Close the connection before the handler returns.
+func (ss *StreamService) UpgradeWebSocket(w http.ResponseWriter, r *http.Request) (*WSConn, error) {
+	if r.Method != http.MethodGet ||
+		!headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, ErrNotWebSocket
+	}
+	key := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, ErrNotWebSocket
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return nil, ErrWebSocketOrigin
+		}
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("websocket upgrade: %w", http.ErrNotSupported)
+	}
+	netConn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket upgrade: %w", err)
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	_ = netConn.SetWriteDeadline(time.Now().Add(ss.cfg.SSEWriteTimeout))
+	if _, err := fmt.Fprintf(rw.Writer, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("websocket handshake: %w", err)
+	}
+	if err := rw.Writer.Flush(); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("websocket handshake: %w", err)
+	}
+
+	conn := &WSConn{
+		ss:       ss,
+		conn:     netConn,
+		bw:       rw.Writer,
+		lastData: time.Now(),
+		done:     make(chan struct{}),
+	}
+	go conn.readLoop(rw.Reader)
+	go conn.heartbeat()
+	return conn, nil
+}
+
+// Write sends one StreamData frame as a text message. Failed or timed-out writes close
+// the connection.
+func (c *WSConn) Write(data StreamData) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := c.writeFrame(wsOpText, payload); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.lastData = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// Done is closed once the connection no longer accepts writes.
+func (c *WSConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close sends a normal closure frame (best effort) and closes the connection. Safe to
+// call repeatedly.
+func (c *WSConn) Close() {
+	_ = c.writeFrame(wsOpClose, []byte{0x03, 0xE8}) // 1000 normal closure
+	c.shutdown()
+}
+
+func (c *WSConn) shutdown() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *WSConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrStreamClosed
+	}
+
+	// Server frames are never masked (RFC 6455 5.1)
+	header := make([]byte, 0, 10)
+	header = append(header, 0x80|opcode)
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.ss.cfg.SSEWriteTimeout))
+	_, err := c.bw.Write(header)
+	if err == nil {
+		_, err = c.bw.Write(payload)
+	}
+	if err == nil {
+		err = c.bw.Flush()
+	}
+	if err != nil {
+		c.ss.logger.Info("WebSocket write failed, closing stream", zap.Error(err))
+		c.closed = true
+		c.closeOnce.Do(func() {
+			close(c.done)
+			c.conn.Close()
+		})
+		return err
+	}
+	return nil
+}
+
+// readLoop answers pings and closes the connection when the client sends a close frame
+// or goes away. Data messages from the client are ignored.
+func (c *WSConn) readLoop(r *bufio.Reader) {
+	defer c.shutdown()
+	for {
+		opcode, payload, err := readWSFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				c.ss.logger.Debug("WebSocket read failed", zap.Error(err))
+			}
+			return
+		}
+		switch opcode {
+		case wsOpClose:
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			_ = c.writeFrame(wsOpClose, payload)
+			return
+		case wsOpPing:
+			_ = c.writeFrame(wsOpPong, payload)
+		}
+	}
+}
+
+// readWSFrame reads one client frame. Client frames must be masked (RFC 6455 5.3).
+func readWSFrame(r *bufio.Reader) (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWSClientFrame {
+		return 0, nil, fmt.Errorf("client frame of %d bytes exceeds %d", length, maxWSClientFrame)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+func (c *WSConn) heartbeat() {
+	ticker := time.NewTicker(c.ss.cfg.SSEHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			idle := time.Since(c.lastData)
+			c.mu.Unlock()
+
+			if c.ss.cfg.SSEIdleTimeout > 0 && idle >= c.ss.cfg.SSEIdleTimeout {
+				c.ss.logger.Info("Closing idle WebSocket stream", zap.Duration("idle", idle))
+				_ = c.Write(StreamData{Type: "idle_timeout"})
+				c.Close()
+				return
+			}
+			// Pings keep proxies from timing out the connection; browsers answer them
+			if err := c.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// headerHasToken reports whether a comma-separated header contains token (case-insensitive).
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Both transports carry agent runs
+var (
+	_ StreamConn = (*SSEConn)(nil)
+	_ StreamConn = (*WSConn)(nil)
+)
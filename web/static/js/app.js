@@ -1,6 +1,32 @@
 let activeEventSource = null;
 let autoScrollEnabled = true;
 
+// Read the CSRF token issued by the server (double-submit cookie)
+function getCSRFToken() {
+    const match = document.cookie.match(/(?:^|;\s*)stats_agent_csrf=([^;]*)/);
+    return match ? decodeURIComponent(match[1]) : '';
+}
+
+// Attach the CSRF token to every HTMX request (POST /chat, DELETE /chat/:id, ...)
+document.addEventListener('htmx:configRequest', (event) => {
+    event.detail.headers['X-CSRF-Token'] = getCSRFToken();
+});
+
+// Sign-in and registration forms: show problem+json failures above the forms (success
+// responds with an HX-Redirect)
+document.addEventListener('htmx:afterRequest', (event) => {
+    const form = event.detail.elt;
+    if (event.detail.successful || !form.matches || !form.matches('form[data-auth-form]')) return;
+    const box = document.getElementById('auth-error');
+    if (!box) return;
+    let message = 'Request failed';
+    try {
+        message = problemDetail(JSON.parse(event.detail.xhr.responseText), message);
+    } catch (e) {}
+    box.textContent = message;
+    box.classList.remove('hidden');
+});
+
 // Toggle sidebar visibility on mobile
 function toggleSidebar() {
     const sidebar = document.getElementById('sidebar');
@@ -25,6 +51,38 @@ function toggleSidebar() {
     }
 }
 
+// Replace a sidebar session link's content (title, tags) from a sidebar_update
+function updateSessionLink(targetLink, newLink) {
+    targetLink.innerHTML = newLink.innerHTML;
+    targetLink.dataset.tags = newLink.dataset.tags || '';
+    const options = document.getElementById('session-tag-options');
+    if (options) {
+        const known = new Set(Array.from(options.options).map(o => o.value));
+        (targetLink.dataset.tags ? targetLink.dataset.tags.split('|') : []).forEach(tag => {
+            if (!known.has(tag)) {
+                const option = document.createElement('option');
+                option.value = tag;
+                options.appendChild(option);
+            }
+        });
+    }
+    const filter = document.getElementById('session-filter');
+    if (filter && filter.value) filterSessions(filter.value);
+}
+
+// Show only sessions whose title or tags contain every word of the query
+function filterSessions(query) {
+    const words = query.toLowerCase().split(/\s+/).filter(Boolean);
+    document.querySelectorAll('a[id^="session-link-"]').forEach(link => {
+        const item = link.closest('li');
+        if (!item) return;
+        const title = (link.querySelector('.font-medium')?.textContent || '').toLowerCase();
+        const tags = (link.dataset.tags || '').toLowerCase();
+        const haystack = title + '|' + tags;
+        item.classList.toggle('hidden', !words.every(w => haystack.includes(w)));
+    });
+}
+
 function applySyntaxHighlighting() {
     if (typeof hljs !== 'undefined') {
         document.querySelectorAll('pre code.language-python:not(.hljs)').forEach((block) => {
@@ -33,6 +91,58 @@ function applySyntaxHighlighting() {
     }
 }
 
+// Plotly figures saved by the executor are rendered client-side. The library is large, so
+// it is only loaded once a chat contains a figure.
+const PLOTLY_SRC = 'https://cdn.plot.ly/plotly-2.35.2.min.js';
+let plotlyLoader = null;
+
+function loadPlotly() {
+    if (typeof Plotly !== 'undefined') {
+        return Promise.resolve();
+    }
+    if (!plotlyLoader) {
+        plotlyLoader = new Promise((resolve, reject) => {
+            const script = document.createElement('script');
+            script.src = PLOTLY_SRC;
+            script.onload = resolve;
+            script.onerror = () => {
+                plotlyLoader = null;
+                reject(new Error('failed to load Plotly'));
+            };
+            document.head.appendChild(script);
+        });
+    }
+    return plotlyLoader;
+}
+
+// Draws every .plotly-figure not drawn yet. On failure the static fallback stays in place.
+function renderPlotlyFigures() {
+    const figures = document.querySelectorAll('.plotly-figure:not([data-plotly-state])');
+    if (figures.length === 0) return;
+    figures.forEach(el => { el.dataset.plotlyState = 'loading'; });
+
+    loadPlotly().then(() => {
+        figures.forEach(async (el) => {
+            try {
+                const response = await fetch(el.dataset.plotlySrc);
+                if (!response.ok) {
+                    throw new Error(`HTTP ${response.status}`);
+                }
+                const figure = await response.json();
+                el.innerHTML = '';
+                await Plotly.newPlot(el, figure.data || [], figure.layout || {}, { responsive: true, displaylogo: false });
+                el.dataset.plotlyState = 'rendered';
+            } catch (err) {
+                console.error('Failed to render interactive figure:', err);
+                el.dataset.plotlyState = 'failed';
+            }
+        });
+    }).catch(err => {
+        console.error(err);
+        figures.forEach(el => { el.dataset.plotlyState = 'failed'; });
+    });
+}
+
 function resetChatForm(form) {
     if (form) {
         form.reset();
@@ -81,6 +191,94 @@ function setupAutoScroll() {
     scrollToBottom();
 }
 
+// Long conversations render only their latest turns. When the #older-messages loader at
+// the top of the history scrolls into view, fetch the previous page and put it in place
+// of the loader, keeping the visible messages where they were.
+let historyObserver = null;
+
+function setupHistoryLoader() {
+    if (historyObserver) {
+        historyObserver.disconnect();
+        historyObserver = null;
+    }
+    const messagesContainer = document.getElementById('messages');
+    const loader = document.getElementById('older-messages');
+    if (!messagesContainer || !loader || loader.dataset.loading) return;
+
+    historyObserver = new IntersectionObserver((entries) => {
+        if (entries.some(entry => entry.isIntersecting) && !loader.dataset.loading) {
+            historyObserver.disconnect();
+            loadOlderMessages(messagesContainer, loader);
+        }
+    }, { root: messagesContainer, rootMargin: '200px 0px 0px 0px' });
+    historyObserver.observe(loader);
+}
+
+async function loadOlderMessages(messagesContainer, loader) {
+    loader.dataset.loading = 'true';
+    const url = `/chat/${loader.dataset.sessionId}/messages?before=${encodeURIComponent(loader.dataset.before)}`;
+    let html;
+    try {
+        const response = await fetch(url);
+        if (!response.ok) {
+            throw new Error(`HTTP ${response.status}`);
+        }
+        html = await response.text();
+    } catch (err) {
+        console.error('Failed to load earlier messages:', err);
+        loader.textContent = 'Could not load earlier messages.';
+        return;
+    }
+
+    // Prepending must not trigger the scroll-to-bottom observer
+    const wasAutoScrolling = autoScrollEnabled;
+    autoScrollEnabled = false;
+    const previousHeight = messagesContainer.scrollHeight;
+    const previousTop = messagesContainer.scrollTop;
+
+    const template = document.createElement('template');
+    template.innerHTML = html;
+    const nodes = Array.from(template.content.children);
+    loader.replaceWith(template.content);
+    messagesContainer.scrollTop = previousTop + (messagesContainer.scrollHeight - previousHeight);
+
+    nodes.forEach(node => htmx.process(node));
+    applySyntaxHighlighting();
+    renderPlotlyFigures();
+    setTimeout(() => {
+        autoScrollEnabled = wasAutoScrolling;
+    }, 0);
+
+    // The new page carries its own loader when there is more history
+    setupHistoryLoader();
+}
+
+// Message search results link to /chat/<session>#msg-<message>. The message may be on an
+// older page of the history, so pages are loaded until its anchor is rendered; then it is
+// scrolled into view and its bubble briefly highlighted.
+async function revealLinkedMessage() {
+    const match = location.hash.match(/^#(msg-[0-9a-f-]+)$/i);
+    const messagesContainer = document.getElementById('messages');
+    if (!match || !messagesContainer) return;
+
+    let anchor = document.getElementById(match[1]);
+    while (!anchor) {
+        const loader = document.getElementById('older-messages');
+        if (!loader || loader.dataset.loading) return;
+        await loadOlderMessages(messagesContainer, loader);
+        anchor = document.getElementById(match[1]);
+    }
+
+    // After loadOlderMessages restores auto-scroll, so the history stays at the message
+    setTimeout(() => {
+        autoScrollEnabled = false;
+        anchor.scrollIntoView({ block: 'center' });
+        const bubble = anchor.closest('.rounded-2xl') || anchor.parentElement;
+        bubble.classList.add('ring-2', 'ring-amber-300');
+        setTimeout(() => bubble.classList.remove('ring-2', 'ring-amber-300'), 3000);
+    }, 0);
+}
+
 function setupFormListener() {
     const form = document.getElementById('chat-form');
     const submitButton = document.getElementById('submit-button');
@@ -156,7 +354,8 @@ function setupFormListener() {
             // Call stop endpoint to cancel agent execution
             if (sessionId) {
                 fetch(`/chat/stop?session_id=${encodeURIComponent(sessionId)}`, {
-                    method: 'POST'
+                    method: 'POST',
+                    headers: { 'X-CSRF-Token': getCSRFToken() }
                 }).then(() => {
                     console.log("Agent execution stopped by user.");
                 }).catch(err => {
@@ -171,13 +370,25 @@ function setupFormListener() {
     });
 
     form.addEventListener('htmx:beforeRequest', () => {
+        requestRunNotificationPermission();
         sendIcon.classList.add('hidden');
         stopIcon.classList.remove('hidden');
         messageInput.disabled = true;
     });
 
-    form.addEventListener('htmx:afterRequest', () => {
+    form.addEventListener('htmx:afterRequest', (event) => {
         messageInput.style.height = 'auto'; // Reset textarea height
+        if (!event.detail.successful) {
+            // Rejected messages (e.g. blocked by the content filter) never start a stream
+            stopIcon.classList.add('hidden');
+            sendIcon.classList.remove('hidden');
+            messageInput.disabled = false;
+            let message = 'Message could not be sent';
+            try {
+                message = problemDetail(JSON.parse(event.detail.xhr.responseText), message);
+            } catch (e) {}
+            showContentFilterNotice(document.getElementById('messages'), message);
+        }
     });
 }
 
@@ -236,6 +447,59 @@ function copyCode(button) {
     }, 1100);
 }
 
+// Toggle inline editing of a python block. The first click makes the code editable;
+// the second click runs the edited code and appends its output below the block.
+function editCode(button) {
+    const block = button.closest('.code-block');
+    const codeEl = block ? block.querySelector('code') : null;
+    if (!codeEl) return;
+    const editText = button.querySelector('.edit-text');
+
+    if (codeEl.getAttribute('contenteditable') !== 'true') {
+        autoScrollEnabled = false;
+        if (!block.dataset.originalCode) {
+            block.dataset.originalCode = codeEl.textContent;
+        }
+        codeEl.setAttribute('contenteditable', 'true');
+        codeEl.classList.add('outline-none');
+        codeEl.focus();
+        editText.textContent = 'Run';
+        return;
+    }
+
+    codeEl.setAttribute('contenteditable', 'false');
+    editText.textContent = 'Edit';
+    rerunCode(block, codeEl.textContent, block.dataset.originalCode || '');
+}
+
+function rerunCode(block, code, originalCode) {
+    const form = document.getElementById('chat-form');
+    const sessionIdInput = form ? form.querySelector('input[name="session_id"]') : null;
+    const sessionId = sessionIdInput ? sessionIdInput.value : null;
+    if (!sessionId) return;
+
+    const body = new URLSearchParams({ code: code, original_code: originalCode });
+    fetch(`/chat/${encodeURIComponent(sessionId)}/rerun`, {
+        method: 'POST',
+        headers: { 'X-CSRF-Token': getCSRFToken() },
+        body: body
+    }).then(resp => resp.json().then(data => ({ ok: resp.ok, data }))).then(({ ok, data }) => {
+        const template = document.getElementById('execution-block-template');
+        if (!template) return;
+        const output = template.cloneNode(true);
+        output.id = '';
+        output.querySelector('.block-title').textContent = 'Output (edited)';
+        output.querySelector('code').textContent = ok ? data.output : problemDetail(data, 'Failed to run code');
+        block.after(output);
+        const warnings = ok && data.warnings && data.warnings.length ? buildWarningsBlock(data.warnings) : null;
+        if (warnings) output.after(warnings);
+    }).catch(err => {
+        console.error('Failed to re-run edited code:', err);
+    }).finally(() => {
+        autoScrollEnabled = true;
+    });
+}
+
 function toggleCodeBlock(element) {
     autoScrollEnabled = false;
     const header = element.closest('.flex.items-center.justify-between');
@@ -256,6 +520,396 @@ function toggleCodeBlock(element) {
     }, 100);
 }
 
+// Reloads the sidebar's file panel, which lists the files a run saved.
+function refreshWorkspaceFiles() {
+    document.body.dispatchEvent(new Event('workspaceFilesChanged'));
+}
+
+// Sends the /finish command: the agent stops analyzing and writes final conclusions.
+function finishAnalysis() {
+    const form = document.getElementById('chat-form');
+    const messageInput = document.getElementById('message-input');
+    if (!form || !messageInput) {
+        return;
+    }
+    const extra = messageInput.value.trim();
+    messageInput.value = extra ? '/finish ' + extra : '/finish';
+    form.requestSubmit();
+}
+
+// Long-run notifications: the server only sends run_notification when they are enabled,
+// so permission is requested (on a user gesture) after the first one arrives. These are
+// shown by this page while it is open, not Web Push; a closed tab gets nothing.
+function requestRunNotificationPermission() {
+    if (!('Notification' in window) || Notification.permission !== 'default') {
+        return;
+    }
+    if (localStorage.getItem('runNotificationsEnabled') === '1') {
+        Notification.requestPermission();
+    }
+}
+
+function showRunNotification(content) {
+    if (!('Notification' in window)) {
+        return;
+    }
+    localStorage.setItem('runNotificationsEnabled', '1');
+    let payload;
+    try {
+        payload = JSON.parse(content);
+    } catch (e) {
+        return;
+    }
+    // Only notify when the user is looking elsewhere
+    if (Notification.permission === 'granted' && document.hidden) {
+        new Notification(payload.title, { body: payload.body });
+    }
+}
+
+// Parses an <ask_user> block: JSON {question, options}, or a question line followed by "- option" lines.
+function parseClarification(text) {
+    const raw = (text || '').trim();
+    if (!raw) {
+        return null;
+    }
+    try {
+        const parsed = JSON.parse(raw);
+        if (parsed && typeof parsed.question === 'string' && parsed.question.trim()) {
+            return { question: parsed.question.trim(), options: Array.isArray(parsed.options) ? parsed.options.map(String) : [] };
+        }
+        return null;
+    } catch (e) {
+        if (raw.startsWith('{')) {
+            return null; // JSON still streaming
+        }
+    }
+    const lines = raw.split('\n').map(l => l.trim()).filter(Boolean);
+    const options = lines.filter(l => l.startsWith('- ')).map(l => l.slice(2));
+    const questionLine = lines.find(l => !l.startsWith('- '));
+    return questionLine ? { question: questionLine, options: options } : null;
+}
+
+function buildClarification(question) {
+    const wrapper = document.createElement('div');
+    wrapper.className = 'ask-user my-4 p-4 rounded-lg border border-blue-200 bg-blue-50';
+    const text = document.createElement('div');
+    text.className = 'text-sm font-medium text-gray-800 mb-3';
+    text.textContent = question.question;
+    wrapper.appendChild(text);
+    if (question.options.length > 0) {
+        const buttons = document.createElement('div');
+        buttons.className = 'flex flex-wrap gap-2';
+        question.options.slice(0, 6).forEach(option => {
+            const button = document.createElement('button');
+            button.type = 'button';
+            button.className = 'ask-user-option px-3 py-1.5 text-sm rounded-full border border-primary text-primary bg-white hover:bg-primary hover:text-white transition-colors duration-150';
+            button.dataset.answer = option;
+            button.textContent = option;
+            button.setAttribute('onclick', 'answerClarification(this)');
+            buttons.appendChild(button);
+        });
+        wrapper.appendChild(buttons);
+    }
+    return wrapper;
+}
+
+// Sends the chosen option as the next user message, resuming the analysis.
+function answerClarification(button) {
+    const form = document.getElementById('chat-form');
+    const messageInput = document.getElementById('message-input');
+    if (!form || !messageInput || activeEventSource) {
+        return;
+    }
+    messageInput.value = button.dataset.answer;
+    // Each question is answered once
+    const block = button.closest('.ask-user');
+    if (block) {
+        block.querySelectorAll('button').forEach(b => { b.disabled = true; b.classList.add('opacity-50'); });
+        button.classList.remove('opacity-50');
+    }
+    form.requestSubmit();
+}
+
+// Follow-up suggestions arrive as a JSON array of questions after a completed run and are
+// shown as chips under the answer. Only the latest answer keeps its chips.
+function showFollowUps(container, content) {
+    if (!container) {
+        return;
+    }
+    let questions;
+    try {
+        questions = JSON.parse(content);
+    } catch (e) {
+        return;
+    }
+    if (!Array.isArray(questions) || questions.length === 0) {
+        return;
+    }
+    document.querySelectorAll('.followup-suggestions').forEach(el => el.remove());
+    const wrapper = document.createElement('div');
+    wrapper.className = 'followup-suggestions mt-3 flex flex-wrap gap-2';
+    questions.slice(0, 3).forEach(question => {
+        const button = document.createElement('button');
+        button.type = 'button';
+        button.className = 'px-3 py-1.5 text-sm rounded-full border border-gray-300 text-gray-700 bg-gray-50 hover:border-primary hover:text-primary transition-colors duration-150';
+        button.dataset.question = String(question);
+        button.textContent = String(question);
+        button.setAttribute('onclick', 'sendFollowUp(this)');
+        wrapper.appendChild(button);
+    });
+    (container.firstElementChild || container).appendChild(wrapper);
+}
+
+// Memory footnotes arrive after each cited assistant message is saved and list the memory
+// entries it relied on. Their data-refs map [mN] tags to footnotes, which the streamed text
+// links to once known.
+function showMemoryFootnotes(container, content) {
+    if (!container || !content) {
+        return;
+    }
+    const template = document.createElement('template');
+    template.innerHTML = content.trim();
+    const footnotes = template.content.firstElementChild;
+    if (!footnotes) {
+        return;
+    }
+    (container.firstElementChild || container).appendChild(footnotes);
+    const contentDiv = document.getElementById('content-' + container.id);
+    if (contentDiv) {
+        linkMemoryCitations(contentDiv);
+    }
+}
+
+// Streamed [mN] tags become (initially empty) references, filled in from the footnotes
+// of the message container. Tags inside code are left alone.
+function linkMemoryCitations(contentDiv) {
+    const walker = document.createTreeWalker(contentDiv, NodeFilter.SHOW_TEXT, {
+        acceptNode: node => (/\[m\d+\]/.test(node.nodeValue) && !node.parentElement.closest('pre, code'))
+            ? NodeFilter.FILTER_ACCEPT : NodeFilter.FILTER_REJECT
+    });
+    const textNodes = [];
+    while (walker.nextNode()) {
+        textNodes.push(walker.currentNode);
+    }
+    textNodes.forEach(node => {
+        const fragment = document.createDocumentFragment();
+        node.nodeValue.split(/(\[m\d+\])/).forEach(part => {
+            const match = part.match(/^\[m(\d+)\]$/);
+            if (!match) {
+                fragment.appendChild(document.createTextNode(part));
+                return;
+            }
+            const ref = document.createElement('sup');
+            ref.className = 'memory-ref';
+            ref.dataset.tag = match[1];
+            fragment.appendChild(ref);
+        });
+        node.replaceWith(fragment);
+    });
+
+    const container = contentDiv.parentElement;
+    if (!container) {
+        return;
+    }
+    const refs = {};
+    container.querySelectorAll('.memory-footnotes[data-refs]').forEach(list => {
+        try {
+            Object.entries(JSON.parse(list.dataset.refs)).forEach(([tag, ref]) => {
+                if (!refs[tag]) { refs[tag] = ref; }
+            });
+        } catch (e) {
+            // Malformed refs only cost the links
+        }
+    });
+    contentDiv.querySelectorAll('sup.memory-ref[data-tag]').forEach(sup => {
+        const ref = refs[sup.dataset.tag];
+        if (!ref || sup.firstChild) {
+            return;
+        }
+        const link = document.createElement('a');
+        link.href = '#' + ref.id;
+        link.textContent = '[' + ref.n + ']';
+        sup.appendChild(link);
+    });
+}
+
+// Content filter notices: a warning is shown alongside the flagged message; a blocked
+// response replaces the streamed text, which is also withheld from the stored conversation.
+function showContentFilterNotice(container, message) {
+    if (!container || !message) {
+        return;
+    }
+    const notice = document.createElement('div');
+    notice.className = 'content-filter-notice mt-2 px-3 py-2 rounded-lg border border-amber-200 bg-amber-50 text-sm text-amber-800';
+    notice.textContent = message;
+    container.appendChild(notice);
+}
+
+// problemDetail returns the message of a problem+json error body (HTTP responses and SSE
+// error events), or fallback.
+function problemDetail(problem, fallback) {
+    return (problem && (problem.detail || problem.title)) || fallback;
+}
+
+function parseProblemEvent(content) {
+    try {
+        const problem = JSON.parse(content);
+        return problem && typeof problem.code === 'string' ? problem : null;
+    } catch (e) {
+        return null;
+    }
+}
+
+// showStreamProblem shows an SSE error event. ingestion_pending comes with an assistant
+// message that already explains the wait, so it is not repeated.
+function showStreamProblem(container, content) {
+    const problem = parseProblemEvent(content);
+    if (!problem || problem.code === 'ingestion_pending') {
+        return;
+    }
+    showContentFilterNotice(container || document.getElementById('messages'), problemDetail(problem, 'Something went wrong'));
+}
+
+function parseContentFilterEvent(content) {
+    try {
+        const payload = JSON.parse(content);
+        return payload && typeof payload.message === 'string' ? payload : null;
+    } catch (e) {
+        return null;
+    }
+}
+
+// Unsaved transformation warnings arrive after a run whose results rest on dataframe
+// changes never written to disk; the button saves the cleaned dataset to the workspace.
+function showUnsavedNotice(container, content) {
+    if (!container) {
+        return;
+    }
+    let payload;
+    try {
+        payload = JSON.parse(content);
+    } catch (e) {
+        return;
+    }
+    if (!payload || typeof payload.message !== 'string') {
+        return;
+    }
+    document.querySelectorAll('.unsaved-notice').forEach(el => el.remove());
+    const notice = document.createElement('div');
+    notice.className = 'unsaved-notice mt-2 px-3 py-2 flex flex-wrap items-center gap-2 rounded-lg border border-amber-200 bg-amber-50 text-sm text-amber-800';
+    const text = document.createElement('span');
+    text.textContent = payload.message;
+    const button = document.createElement('button');
+    button.type = 'button';
+    button.className = 'px-2 py-0.5 text-xs rounded bg-amber-500 text-white hover:bg-amber-600';
+    button.textContent = 'Persist cleaned dataset';
+    button.setAttribute('onclick', 'persistCleanedDataset(this)');
+    notice.appendChild(text);
+    notice.appendChild(button);
+    (container.firstElementChild || container).appendChild(notice);
+}
+
+// Explains a turn rejected because its prompt would not fit the model's context.
+function showContextOverflowNotice(container, content) {
+    let payload;
+    try {
+        payload = JSON.parse(content);
+    } catch (e) {
+        return;
+    }
+    if (!payload || typeof payload.message !== 'string') {
+        return;
+    }
+    const notice = document.createElement('div');
+    notice.className = 'context-overflow-notice mt-2 px-3 py-2 rounded-lg border border-red-200 bg-red-50 text-sm text-red-800';
+    const text = document.createElement('p');
+    text.textContent = payload.message;
+    notice.appendChild(text);
+    if (Array.isArray(payload.suggestions) && payload.suggestions.length > 0) {
+        const list = document.createElement('ul');
+        list.className = 'mt-1 list-disc list-inside';
+        payload.suggestions.forEach(s => {
+            const item = document.createElement('li');
+            item.textContent = s;
+            list.appendChild(item);
+        });
+        notice.appendChild(list);
+    }
+    const target = container ? (container.firstElementChild || container) : document.getElementById('messages');
+    if (target) { target.appendChild(notice); }
+}
+
+function persistCleanedDataset(button) {
+    const form = document.getElementById('chat-form');
+    const sessionIdInput = form ? form.querySelector('input[name="session_id"]') : null;
+    const sessionId = sessionIdInput ? sessionIdInput.value : null;
+    if (!sessionId) return;
+
+    button.disabled = true;
+    fetch(`/chat/${encodeURIComponent(sessionId)}/lineage/persist`, {
+        method: 'POST',
+        headers: { 'X-CSRF-Token': getCSRFToken(), 'Accept': 'application/json' }
+    }).then(resp => resp.json().then(data => ({ ok: resp.ok, data }))).then(({ ok, data }) => {
+        const notice = button.closest('.unsaved-notice');
+        if (!notice) return;
+        notice.querySelector('span').textContent = ok
+            ? `Cleaned dataset saved as ${data.filename}.`
+            : problemDetail(data, 'Failed to save the cleaned dataset');
+        if (ok) {
+            button.remove();
+        } else {
+            button.disabled = false;
+        }
+    }).catch(err => {
+        console.error('Failed to persist cleaned dataset:', err);
+        button.disabled = false;
+    });
+}
+
+// Sorts a rollup table by the clicked column; clicking the same column again reverses it.
+// Cells sort on their data-sort-value when present (numbers), otherwise on their text.
+function sortRollupTable(th) {
+    const table = th.closest('table');
+    const tbody = table ? table.querySelector('tbody') : null;
+    if (!tbody) return;
+
+    const column = Array.from(th.parentNode.children).indexOf(th);
+    const ascending = !(table.dataset.sortColumn === String(column) && table.dataset.sortDir === 'asc');
+    table.dataset.sortColumn = String(column);
+    table.dataset.sortDir = ascending ? 'asc' : 'desc';
+
+    const key = (row) => {
+        const cell = row.children[column];
+        if (!cell) return '';
+        const value = cell.dataset.sortValue;
+        return value !== undefined ? parseFloat(value) : cell.textContent.trim().toLowerCase();
+    };
+    const rows = Array.from(tbody.querySelectorAll('tr'));
+    rows.sort((a, b) => {
+        const ka = key(a);
+        const kb = key(b);
+        const cmp = (typeof ka === 'number' && typeof kb === 'number') ? ka - kb : String(ka).localeCompare(String(kb));
+        return ascending ? cmp : -cmp;
+    });
+    rows.forEach(row => tbody.appendChild(row));
+}
+
+document.body.addEventListener('contentFilterWarning', function(event) {
+    showContentFilterNotice(document.getElementById('messages'), event.detail.value);
+});
+
+// Sends the chosen follow-up as the next user message.
+function sendFollowUp(button) {
+    const form = document.getElementById('chat-form');
+    const messageInput = document.getElementById('message-input');
+    if (!form || !messageInput || activeEventSource) {
+        return;
+    }
+    messageInput.value = button.dataset.question;
+    document.querySelectorAll('.followup-suggestions').forEach(el => el.remove());
+    form.requestSubmit();
+}
+
 function submitOnEnter(event) {
     if (event.keyCode == 13 && !event.shiftKey) {
         event.preventDefault();
@@ -268,7 +922,10 @@ document.addEventListener('DOMContentLoaded', () => {
     initiateSSE();
     setupFormListener();
     setupAutoScroll(); // Set up the observer when the page loads
+    setupHistoryLoader(); // Lazy-load older messages on scroll
     applySyntaxHighlighting(); // Apply on initial page load
+    renderPlotlyFigures();
+    revealLinkedMessage(); // Scroll to the message a search result linked to
 
     const messageInput = document.getElementById('message-input');
     if (messageInput) {
@@ -279,12 +936,19 @@ document.addEventListener('DOMContentLoaded', () => {
     checkAndAttachToActiveRun();
 });
 
+window.addEventListener('hashchange', revealLinkedMessage);
+
 document.body.addEventListener('htmx:afterSwap', function(event) {
+    // Message search results update while the user types; keep the focus and the sidebar
+    if (event.detail.target && event.detail.target.id === 'message-search-results') return;
+
     focusInput();
     initiateSSE();
     setupFormListener(); // Re-attach form event listeners after htmx loads new content
     setupAutoScroll(); // Re-setup autoscroll for the messages container
+    setupHistoryLoader(); // Re-attach the older-messages loader
     applySyntaxHighlighting(); // Re-apply after htmx loads new content
+    renderPlotlyFigures();
 
     // Re-attach textarea auto-expand listener
     const messageInput = document.getElementById('message-input');
@@ -306,6 +970,61 @@ document.body.addEventListener('htmx:afterSwap', function(event) {
     checkAndAttachToActiveRun();
 });
 
+// openStream connects to a run's stream. The WebSocket transport is tried first; when the
+// socket cannot be opened (transport disabled, or a proxy that drops upgrades) the stream
+// falls back to SSE, and the tab keeps using SSE afterwards. Both return the EventSource
+// interface the stream handlers use. A background job's stream is always SSE: it replays
+// the job's events so far and follows it until it finishes, without restarting the turn.
+function openStream(sessionId, messageId, traceParent, jobId) {
+    if (jobId) {
+        return new EventSource('/session/' + encodeURIComponent(sessionId) + '/jobs/' + encodeURIComponent(jobId) + '/stream');
+    }
+    let query = 'session_id=' + encodeURIComponent(sessionId) + '&user_message_id=' + encodeURIComponent(messageId);
+    if (traceParent) {
+        // Streams cannot send headers; the server continues the send request's trace from this
+        query += '&traceparent=' + encodeURIComponent(traceParent);
+    }
+    if (typeof WebSocket === 'undefined' || sessionStorage.getItem('streamTransport') === 'sse') {
+        return new EventSource('/chat/stream?' + query);
+    }
+    return new SocketStream(query);
+}
+
+class SocketStream {
+    constructor(query) {
+        this.onmessage = null;
+        this.onerror = null;
+        this.closed = false;
+        this.fallback = null;
+
+        const scheme = location.protocol === 'https:' ? 'wss://' : 'ws://';
+        let opened = false;
+        this.socket = new WebSocket(scheme + location.host + '/chat/ws?' + query);
+        this.socket.onopen = () => { opened = true; };
+        this.socket.onmessage = (event) => {
+            if (this.onmessage) { this.onmessage(event); }
+        };
+        this.socket.onclose = (event) => {
+            if (this.closed) return;
+            if (!opened) {
+                // The upgrade failed before the run started, so SSE can start it instead
+                sessionStorage.setItem('streamTransport', 'sse');
+                this.fallback = new EventSource('/chat/stream?' + query);
+                this.fallback.onmessage = (e) => { if (this.onmessage) { this.onmessage(e); } };
+                this.fallback.onerror = (e) => { if (this.onerror) { this.onerror(e); } };
+                return;
+            }
+            if (this.onerror) { this.onerror(event); }
+        };
+    }
+
+    close() {
+        this.closed = true;
+        this.socket.close();
+        if (this.fallback) { this.fallback.close(); }
+    }
+}
+
 function checkAndAttachToActiveRun() {
     // If already streaming, do nothing
     if (activeEventSource) return;
@@ -328,20 +1047,22 @@ function checkAndAttachToActiveRun() {
                 stopIcon.classList.remove('hidden');
             }
             if (messageInput) { messageInput.disabled = true; }
-            // Attach SSE to the active stream
-            attachSSE(sessionId, data.user_message_id);
+            // Attach SSE to the active stream, or the background job running it
+            attachSSE(sessionId, data.user_message_id, data.job_id);
         })
         .catch(() => {});
 }
 
-function attachSSE(sessionId, messageId) {
+function attachSSE(sessionId, messageId, jobId) {
     if (activeEventSource) return;
-    const eventSource = new EventSource('/chat/stream?session_id=' + encodeURIComponent(sessionId) + '&user_message_id=' + encodeURIComponent(messageId));
+    const eventSource = openStream(sessionId, messageId, null, jobId);
     activeEventSource = eventSource;
 
     let contentBuffer = '';
     let messageContainer = null;
     let debounceTimer;
+    // Set on end; follow-up suggestions, figure alt texts and the abstract arrive after it
+    let ended = false;
 
     const cleanup = () => {
         const sendIcon = document.getElementById('send-icon');
@@ -385,7 +1106,7 @@ function attachSSE(sessionId, messageId) {
                     if (newLink) {
                         const targetId = newLink.id;
                         const targetLink = document.getElementById(targetId);
-                        if (targetLink) { targetLink.innerHTML = newLink.innerHTML; }
+                        if (targetLink) { updateSessionLink(targetLink, newLink); }
                     }
                 }
                 break;
@@ -397,7 +1118,10 @@ function attachSSE(sessionId, messageId) {
                     if (fileContainer) {
                         const targetId = fileContainer.id;
                         const targetDiv = document.getElementById(targetId);
-                        if (targetDiv) { targetDiv.innerHTML = fileContainer.innerHTML; }
+                        if (targetDiv) {
+                            targetDiv.innerHTML = fileContainer.innerHTML;
+                            renderPlotlyFigures();
+                        }
                     }
                 }
                 break;
@@ -411,13 +1135,52 @@ function attachSSE(sessionId, messageId) {
                     }, 50);
                 }
                 break;
-            case 'end':
+            case 'run_notification':
+                showRunNotification(data.content);
+                break;
+            case 'followup_suggestions':
+                showFollowUps(messageContainer, data.content);
+                break;
+            case 'memory_footnotes':
+                showMemoryFootnotes(messageContainer, data.content);
+                break;
+            case 'unsaved_transformations':
+                showUnsavedNotice(messageContainer, data.content);
+                break;
+            case 'context_overflow':
+                showContextOverflowNotice(messageContainer, data.content);
+                break;
+            case 'error':
+                showStreamProblem(messageContainer, data.content);
+                break;
+            case 'content_filter': {
+                const filtered = parseContentFilterEvent(data.content);
+                if (!filtered) { break; }
+                const contentDiv = messageContainer ? document.getElementById('content-' + messageContainer.id) : null;
+                if (filtered.action === 'block') {
+                    // The run was stopped; no end event follows
+                    clearTimeout(debounceTimer);
+                    contentBuffer = '';
+                    if (contentDiv) { contentDiv.innerHTML = ''; }
+                    eventSource.close();
+                    cleanup();
+                }
+                showContentFilterNotice(contentDiv ? contentDiv.parentElement : document.getElementById('messages'), filtered.message);
+                break;
+            }
+            case 'idle_timeout':
+                // Server closed a quiet stream; the run's messages are persisted and load on refresh
                 eventSource.close();
+                // falls through
+            case 'end':
+                // The stream stays open for the events sent after end; the server closes it
+                ended = true;
                 if (messageContainer) {
                     const contentDiv = document.getElementById('content-' + messageContainer.id);
                     if (contentDiv) { renderAndProcessContent(contentDiv, contentBuffer); }
                 }
                 cleanup();
+                refreshWorkspaceFiles();
                 break;
             default:
                 break;
@@ -425,8 +1188,9 @@ function attachSSE(sessionId, messageId) {
     };
 
     eventSource.onerror = function(event) {
-        cleanup();
         eventSource.close();
+        if (ended) { return; }
+        cleanup();
     };
 }
 
@@ -436,16 +1200,20 @@ function initiateSSE() {
         loader.setAttribute('data-sse-initialized', 'true');
         const sessionId = loader.getAttribute('data-session-id');
         const messageId = loader.getAttribute('data-message-id');
+        const traceParent = loader.getAttribute('data-traceparent');
+        const jobId = loader.getAttribute('data-job-id');
 
         if (!sessionId || !messageId) return;
 
-        const eventSource = new EventSource('/chat/stream?session_id=' + encodeURIComponent(sessionId) + '&user_message_id=' + encodeURIComponent(messageId));
+        const eventSource = openStream(sessionId, messageId, traceParent, jobId);
 
         activeEventSource = eventSource;
 
         let contentBuffer = '';
         let messageContainer = null;
         let debounceTimer;
+        // Set on end; follow-up suggestions, figure alt texts and the abstract arrive after it
+        let ended = false;
 
         const cleanup = () => {
             const sendIcon = document.getElementById('send-icon');
@@ -498,7 +1266,7 @@ function initiateSSE() {
                             const targetId = newLink.id;
                             const targetLink = document.getElementById(targetId);
                             if (targetLink) {
-                                targetLink.innerHTML = newLink.innerHTML;
+                                updateSessionLink(targetLink, newLink);
                             }
                         }
                     }
@@ -513,6 +1281,7 @@ function initiateSSE() {
                             const targetDiv = document.getElementById(targetId);
                             if (targetDiv) {
                                 targetDiv.innerHTML = fileContainer.innerHTML;
+                                renderPlotlyFigures();
                             }
                         }
                     }
@@ -530,8 +1299,50 @@ function initiateSSE() {
                         }, 50);
                     }
                     break;
-                case 'end':
+                case 'run_notification':
+                    showRunNotification(data.content);
+                    break;
+                case 'followup_suggestions':
+                    showFollowUps(messageContainer, data.content);
+                    break;
+                case 'memory_footnotes':
+                    showMemoryFootnotes(messageContainer, data.content);
+                    break;
+                case 'unsaved_transformations':
+                    showUnsavedNotice(messageContainer, data.content);
+                    break;
+                case 'context_overflow':
+                    showContextOverflowNotice(messageContainer, data.content);
+                    break;
+                case 'error':
+                    showStreamProblem(messageContainer, data.content);
+                    break;
+                case 'content_filter': {
+                    const filtered = parseContentFilterEvent(data.content);
+                    if (!filtered) {
+                        break;
+                    }
+                    const contentDiv = messageContainer ? document.getElementById('content-' + messageContainer.id) : null;
+                    if (filtered.action === 'block') {
+                        // The run was stopped; no end event follows
+                        clearTimeout(debounceTimer);
+                        contentBuffer = '';
+                        if (contentDiv) {
+                            contentDiv.innerHTML = '';
+                        }
+                        eventSource.close();
+                        cleanup();
+                    }
+                    showContentFilterNotice(contentDiv ? contentDiv.parentElement : document.getElementById('messages'), filtered.message);
+                    break;
+                }
+                case 'idle_timeout':
+                    // Server closed a quiet stream; the run's messages are persisted and load on refresh
                     eventSource.close();
+                    // falls through
+                case 'end':
+                    // The stream stays open for the events sent after end; the server closes it
+                    ended = true;
                     if (messageContainer) {
                         const contentDiv = document.getElementById('content-' + messageContainer.id);
                         if (contentDiv) {
@@ -539,6 +1350,7 @@ function initiateSSE() {
                         }
                     }
                     cleanup();
+                    refreshWorkspaceFiles();
                     break;
                 default:
                     break;
@@ -546,13 +1358,33 @@ function initiateSSE() {
         };
 
         eventSource.onerror = function(event) {
+            eventSource.close();
+            if (ended) {
+                // The server closed the stream after the events that follow end
+                return;
+            }
             console.error('SSE Error:', event);
             cleanup();
-            eventSource.close();
         };
     });
 }
 
+// Builds the caution block listing a cell's Python warnings from the hidden template.
+function buildWarningsBlock(lines) {
+    const template = document.getElementById('warnings-block-template');
+    if (!template) return null;
+    const wrapper = template.cloneNode(true);
+    wrapper.id = '';
+    wrapper.querySelector('.block-title').textContent = `Warnings (${lines.length})`;
+    const list = wrapper.querySelector('ul');
+    lines.forEach(line => {
+        const item = document.createElement('li');
+        item.textContent = line;
+        list.appendChild(item);
+    });
+    return wrapper;
+}
+
 function renderAndProcessContent(contentDiv, content) {
     // Normalize content to fix encoding issues that can break marked.js parsing
     let normalized = content || '';
@@ -599,6 +1431,46 @@ function renderAndProcessContent(contentDiv, content) {
         }
     });
 
+    // SQL queries: raw <sql> tags from streaming become code blocks (the SQL tool's queries).
+    contentDiv.querySelectorAll('sql').forEach(sqlElement => {
+        const template = document.getElementById('execution-block-template');
+        if (!template) return;
+        const wrapper = template.cloneNode(true);
+        wrapper.id = '';
+        wrapper.dataset.blockType = 'sql';
+        const title = wrapper.querySelector('.block-title');
+        if (title) title.textContent = 'SQL';
+        const codeElement = wrapper.querySelector('code');
+        if (codeElement) {
+            codeElement.textContent = sqlElement.textContent.trim();
+            codeElement.classList.add('language-sql');
+            if (typeof hljs !== 'undefined') hljs.highlightElement(codeElement);
+        }
+        sqlElement.replaceWith(wrapper);
+    });
+
+    // Python warnings: raw <warnings> tags from streaming become a collapsed caution block.
+    contentDiv.querySelectorAll('warnings').forEach(warningsElement => {
+        const lines = warningsElement.textContent.split('\n').map(line => line.trim()).filter(Boolean);
+        const wrapper = lines.length ? buildWarningsBlock(lines) : null;
+        if (wrapper) {
+            warningsElement.replaceWith(wrapper);
+        } else {
+            warningsElement.style.display = 'none';
+        }
+    });
+
+    // Clarification questions: raw <ask_user> tags from streaming become quick-reply buttons.
+    // Incomplete blocks (still streaming) stay hidden until their JSON parses.
+    contentDiv.querySelectorAll('ask_user').forEach(askElement => {
+        const question = parseClarification(askElement.textContent);
+        if (!question) {
+            askElement.style.display = 'none';
+            return;
+        }
+        askElement.replaceWith(buildClarification(question));
+    });
+
     // Handle agent status messages - both raw <agent_status> tags and .agent-status-message divs
     // First, handle raw <agent_status> tags from streaming
     contentDiv.querySelectorAll('agent_status').forEach(statusElement => {
@@ -623,4 +1495,6 @@ function renderAndProcessContent(contentDiv, content) {
             statusElement.replaceWith(statusClone);
         }
     });
+
+    linkMemoryCitations(contentDiv);
 }
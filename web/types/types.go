@@ -12,6 +12,53 @@ const (
 	ModeDocument = "document"
 )
 
+// Response verbosity levels
+const (
+	VerbosityTerse    = "terse"
+	VerbosityStandard = "standard"
+	VerbosityTeaching = "teaching"
+)
+
+// IsValidVerbosity reports whether v is a supported verbosity level.
+func IsValidVerbosity(v string) bool {
+	return v == VerbosityTerse || v == VerbosityStandard || v == VerbosityTeaching
+}
+
+// Effect size check modes: what happens when a hypothesis test is reported without an effect size
+const (
+	EffectSizeCheckOff  = "off"  // no check
+	EffectSizeCheckNote = "note" // attach an evidence note asking for the effect size
+	EffectSizeCheckAuto = "auto" // also run a follow-up turn if the model would stop without it
+)
+
+// IsValidEffectSizeCheck reports whether v is a supported effect size check mode.
+func IsValidEffectSizeCheck(v string) bool {
+	return v == EffectSizeCheckOff || v == EffectSizeCheckNote || v == EffectSizeCheckAuto
+}
+
+// Column types a user can assign to override the type inferred from the data
+const (
+	ColumnTypeNumeric     = "numeric"
+	ColumnTypeCategorical = "categorical"
+	ColumnTypeOrdinal     = "ordinal"
+	ColumnTypeBoolean     = "boolean"
+	ColumnTypeDatetime    = "datetime"
+	ColumnTypeText        = "text"
+)
+
+// ColumnTypes lists the column types in display order.
+var ColumnTypes = []string{ColumnTypeNumeric, ColumnTypeCategorical, ColumnTypeOrdinal, ColumnTypeBoolean, ColumnTypeDatetime, ColumnTypeText}
+
+// IsValidColumnType reports whether t is a supported column type.
+func IsValidColumnType(t string) bool {
+	for _, ct := range ColumnTypes {
+		if t == ct {
+			return true
+		}
+	}
+	return false
+}
+
 // AgentMessage represents a message in the format expected by the agent and LLM.
 type AgentMessage struct {
 	Role    string `json:"role"`
@@ -36,18 +83,92 @@ type ChatMessage struct {
 	Rendered    string    `json:"rendered"`     // Rendered HTML for the UI
 	ContentHash string    `json:"content_hash"` // Hash of normalized content for deduplication
 	CreatedAt   time.Time `json:"created_at"`
+	// Annotations are the user's notes on this message; filled only for rendering
+	Annotations []MessageAnnotation `json:"annotations,omitempty"`
 }
 
 // Session represents a chat session.
 type Session struct {
-	ID            uuid.UUID
-	UserID        *uuid.UUID
-	CreatedAt     time.Time
-	LastActive    time.Time
-	WorkspacePath string
-	Title         string
-	IsActive      bool
-	Mode          string // "dataset" or "document"
+	ID              uuid.UUID
+	UserID          *uuid.UUID
+	CreatedAt       time.Time
+	LastActive      time.Time
+	WorkspacePath   string
+	Title           string
+	IsActive        bool
+	Mode            string   // "dataset" or "document"
+	Verbosity       string   // "terse", "standard", or "teaching"
+	EffectSizeCheck string   // "off", "note", or "auto"
+	Tags            []string // result tags: tests used, datasets, key variables
+	RandomSeed      *int64   // pinned random seed, nil when unset
+	LLMModel        string   // named LLM_MODELS endpoint, "" for MAIN_LLM_HOST
+	RetrievalPolicy string   // named retrieval policy, "" for the mode's default
+	Abstract        string   // rolling 2-3 sentence summary of the conversation, "" before the first turn
+}
+
+// UserAccount is a user with its sign-in details. Anonymous users (a browser cookie
+// without an account) have no email.
+type UserAccount struct {
+	ID           uuid.UUID
+	Email        string
+	DisplayName  string
+	PasswordHash string // bcrypt hash, "" when the account only signs in through OAuth
+	CreatedAt    time.Time
+}
+
+// SessionRole is what a user may do with a session. The owner (sessions.user_id) may do
+// everything; other roles are granted through session_permissions.
+type SessionRole string
+
+const (
+	SessionRoleOwner  SessionRole = "owner"
+	SessionRoleViewer SessionRole = "viewer" // read the transcript and figures
+)
+
+// SessionPermission grants a role on a session to whoever holds its signed share link.
+type SessionPermission struct {
+	ID        uuid.UUID
+	SessionID uuid.UUID
+	Role      SessionRole
+	CreatedBy *uuid.UUID
+	CreatedAt time.Time
+	ExpiresAt *time.Time // nil when the link does not expire
+	RevokedAt *time.Time
+}
+
+// SessionShareLink is an active permission with its link path (/shared/<token>).
+type SessionShareLink struct {
+	Permission SessionPermission
+	Path       string
+}
+
+// PlotArtifact is a figure a run produced, as listed in the session's plot gallery.
+type PlotArtifact struct {
+	Filename     string    `json:"filename"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"` // "" for a Plotly figure saved without a PNG
+	Kind         string    `json:"kind"`          // "image" or "plot" (interactive Plotly figure)
+	AltText      string    `json:"alt_text,omitempty"`
+	MessageID    string    `json:"message_id,omitempty"` // assistant message that displays it
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// PlotGallery is one page of a session's figures, newest first.
+type PlotGallery struct {
+	Artifacts []PlotArtifact `json:"artifacts"`
+	Page      int            `json:"page"`
+	PageSize  int            `json:"page_size"`
+	Total     int            `json:"total"`
+	HasMore   bool           `json:"has_more"`
+}
+
+// WorkspaceFile is a file in a session's workspace: an upload or something the agent wrote.
+type WorkspaceFile struct {
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`  // download URL
+	Kind       string    `json:"kind"` // plot, image, csv, pdf or other
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
 }
 
 // MessageGroup is a struct for rendering grouped messages in the template.
@@ -55,3 +176,501 @@ type MessageGroup struct {
 	PrimaryRole string // "user", "agent", or "system"
 	Messages    []ChatMessage
 }
+
+// LLMModelOption is one entry of the per-session model selector.
+type LLMModelOption struct {
+	Name  string // LLM_MODELS name, "" for the default model
+	Label string // name with its cost and latency labels
+}
+
+// MessagePage is one page of grouped chat history. Before is the cursor for the page
+// preceding it (the ID of its first message), empty when it starts the conversation.
+type MessagePage struct {
+	Groups []MessageGroup
+	Before string
+}
+
+// SnippetMatchStart and SnippetMatchEnd wrap the matched words in a search snippet. They are
+// control characters so they cannot collide with message text.
+const (
+	SnippetMatchStart = "\x02"
+	SnippetMatchEnd   = "\x03"
+)
+
+// MessageSearchResult is one chat message matching a search across the user's sessions.
+// Snippet is an excerpt of the message with matches wrapped in the SnippetMatch markers.
+type MessageSearchResult struct {
+	MessageID    string    `json:"message_id"`
+	SessionID    string    `json:"session_id"`
+	SessionTitle string    `json:"session_title"`
+	Role         string    `json:"role"`
+	Snippet      string    `json:"snippet"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Transformation is one data transformation parsed from executed code (filter, drop, impute, recode).
+type Transformation struct {
+	Kind        string   `json:"kind"`
+	Description string   `json:"description"`
+	Columns     []string `json:"columns,omitempty"`
+	// Frame is the variable holding the transformed dataframe
+	Frame string `json:"frame,omitempty"`
+}
+
+// ColumnSchema describes one dataset column as inferred from the data. Hint flags
+// likely misinferences, e.g. numeric codes that are really categories.
+type ColumnSchema struct {
+	Name     string   `json:"name"`
+	Dtype    string   `json:"dtype"`
+	Inferred string   `json:"inferred"`
+	Unique   int      `json:"unique"`
+	Examples []string `json:"examples,omitempty"`
+	Hint     string   `json:"hint,omitempty"`
+	// Levels are the most frequent values of categorical and coded columns
+	Levels []ColumnLevel `json:"levels,omitempty"`
+}
+
+// ColumnLevel is one level of a categorical column and the number of rows holding it.
+type ColumnLevel struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// DatasetColumnTypes is one dataset's inferred columns and the user's type overrides,
+// as shown in the column type editor.
+type DatasetColumnTypes struct {
+	Datasets  []string          `json:"datasets"`
+	Dataset   string            `json:"dataset"`
+	Columns   []ColumnSchema    `json:"columns"`
+	Overrides map[string]string `json:"overrides"`
+}
+
+// SessionDataset is one tabular file of a session as the agent refers to it: Name is the
+// identifier passed to load_dataset in the executor. Columns is empty when the file
+// could not be profiled.
+type SessionDataset struct {
+	Name     string         `json:"name"`
+	Filename string         `json:"filename"`
+	Columns  []ColumnSchema `json:"columns"`
+}
+
+// ExcelSheet is one worksheet of an uploaded .xlsx workbook: its header row, the number of
+// non-empty rows below it and the first few of them.
+type ExcelSheet struct {
+	Name    string     `json:"name"`
+	Rows    int        `json:"rows"`
+	Header  []string   `json:"header"`
+	Preview [][]string `json:"preview"`
+}
+
+// DatasetJoinKey is a column shared by two of a session's datasets that could link them.
+type DatasetJoinKey struct {
+	Left   string `json:"left"`
+	Right  string `json:"right"`
+	Column string `json:"column"`
+}
+
+// DatasetProfile summarizes an uploaded dataset: its size and, per column, the inferred
+// type, missingness, cardinality and distribution.
+type DatasetProfile struct {
+	Dataset       string          `json:"dataset"`
+	Rows          int             `json:"rows"`
+	DuplicateRows int             `json:"duplicate_rows"`
+	Columns       []ColumnProfile `json:"columns"`
+}
+
+// ColumnProfile is one column of a DatasetProfile. Summary is set for numeric columns;
+// categorical and coded columns report their levels in the embedded schema.
+type ColumnProfile struct {
+	ColumnSchema
+	Missing    int             `json:"missing"`
+	MissingPct float64         `json:"missing_pct"`
+	Summary    *NumericSummary `json:"summary,omitempty"`
+}
+
+// NumericSummary describes the distribution of a numeric column's non-missing values.
+// Std and Skew are nil when undefined (too few values).
+type NumericSummary struct {
+	Mean   float64  `json:"mean"`
+	Std    *float64 `json:"std"`
+	Min    float64  `json:"min"`
+	Q1     float64  `json:"q1"`
+	Median float64  `json:"median"`
+	Q3     float64  `json:"q3"`
+	Max    float64  `json:"max"`
+	Skew   *float64 `json:"skew"`
+}
+
+// TransformationStep groups the transformations of one executed code block with the
+// row counts reported in its output. Row counts are 0 when the output did not report them.
+// SavedTo lists the dataset files the block wrote to the workspace.
+type TransformationStep struct {
+	Operations []Transformation `json:"operations"`
+	RowsBefore int              `json:"rows_before"`
+	RowsAfter  int              `json:"rows_after"`
+	Columns    int              `json:"columns"`
+	UserEdited bool             `json:"user_edited"`
+	SavedTo    []string         `json:"saved_to,omitempty"`
+	ExecutedAt time.Time        `json:"executed_at"`
+	// Diffs compare the distributions of columns the block imputed, recoded or rescaled
+	Diffs []DistributionDiff `json:"diffs,omitempty"`
+}
+
+// DistributionDiff compares one column before and after a transformation. Source is the
+// column the "before" values came from: the column itself, or the column a new one was
+// derived from (df['log_x'] = np.log(df['x'])). Figure is the workspace file holding the
+// before/after histograms, empty when plotting failed.
+type DistributionDiff struct {
+	Column string            `json:"column"`
+	Source string            `json:"source"`
+	Kind   string            `json:"kind"`
+	Before DistributionStats `json:"before"`
+	After  DistributionStats `json:"after"`
+	Figure string            `json:"figure,omitempty"`
+}
+
+// DistributionStats summarizes a numeric column's distribution.
+type DistributionStats struct {
+	N       int     `json:"n"`
+	Missing int     `json:"missing"`
+	Mean    float64 `json:"mean"`
+	SD      float64 `json:"sd"`
+	Median  float64 `json:"median"`
+	Skew    float64 `json:"skew"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+}
+
+// ScreeningRollup is one test run across many variables (a screening loop), collected
+// from the per-variable facts into a single results table. Correction names the
+// multiple-comparison correction the analysis code applied, or is empty when it applied
+// none; AdjustedP always holds Holm-adjusted p-values over the rows.
+type ScreeningRollup struct {
+	Test       string      `json:"test"`
+	Dataset    string      `json:"dataset,omitempty"`
+	GroupBy    string      `json:"group_by,omitempty"`
+	Correction string      `json:"correction,omitempty"`
+	Rows       []RollupRow `json:"rows"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// RollupRow is one variable's result within a ScreeningRollup.
+type RollupRow struct {
+	Variable    string  `json:"variable"`
+	Statistic   string  `json:"statistic,omitempty"`
+	EffectSize  string  `json:"effect_size,omitempty"`
+	PValue      float64 `json:"p_value"`
+	AdjustedP   float64 `json:"adjusted_p"`
+	Significant bool    `json:"significant"`
+}
+
+// SQLResult is the result of a read-only SQL console query over the session workspace.
+// Tables lists the workspace files the query could reference, by table name. Values are
+// rendered as strings (NULL for missing); Truncated is set when rows were cut at the limit.
+type SQLResult struct {
+	Query     string     `json:"query"`
+	Tables    []string   `json:"tables"`
+	Columns   []string   `json:"columns"`
+	Rows      [][]string `json:"rows"`
+	Truncated bool       `json:"truncated"`
+}
+
+// AnalysisSpec is a declarative analysis submitted as YAML or JSON. The server compiles it
+// to templated Python (tools.AnalysisSpecCode) instead of asking the LLM, so the same spec
+// always runs the same code. Test names the analysis; Predictors holds the grouping
+// variable, the second measure or the model terms, depending on the test.
+type AnalysisSpec struct {
+	Dataset    string              `json:"dataset" yaml:"dataset"`
+	Outcome    string              `json:"outcome" yaml:"outcome"`
+	Predictors []string            `json:"predictors,omitempty" yaml:"predictors,omitempty"`
+	Test       string              `json:"test" yaml:"test"`
+	Options    AnalysisSpecOptions `json:"options,omitempty" yaml:"options,omitempty"`
+}
+
+// AnalysisSpecOptions tunes a spec's test. Zero values mean the defaults: alpha 0.05,
+// a two-sided alternative, Welch's t-test and classical OLS standard errors.
+type AnalysisSpecOptions struct {
+	Alpha       float64 `json:"alpha,omitempty" yaml:"alpha,omitempty"`
+	Alternative string  `json:"alternative,omitempty" yaml:"alternative,omitempty"`
+	EqualVar    bool    `json:"equal_var,omitempty" yaml:"equal_var,omitempty"`
+	Robust      bool    `json:"robust,omitempty" yaml:"robust,omitempty"`
+}
+
+// ActionRecord is one executed action persisted for the agent's action cache, so repeat
+// detection and the done-ledger survive restarts and are shared between replicas.
+// Signature is the JSON-encoded agent.ActionSignature and SignatureHash its ComputeHash.
+type ActionRecord struct {
+	SessionID          string
+	SignatureHash      string
+	Signature          string
+	Output             string
+	Success            bool
+	Turn               int
+	Attempt            int
+	CodeNormHash       string
+	Diagnostics        string
+	UserEdited         bool
+	SupersededCodeHash string
+}
+
+// RunTurn is the recorded LLM input and output of one dataset-mode agent turn: enough
+// to replay the turn offline against another model or prompt version.
+type RunTurn struct {
+	SessionID string `json:"session_id"`
+	RunID     string `json:"run_id"`
+	Turn      int    `json:"turn"`
+	// Query and Retrieval are the RAG query text and the memory block it returned
+	Query     string `json:"query"`
+	Retrieval string `json:"retrieval"`
+	// Messages are the messages sent after the system prompt; PromptVersion identifies the system prompt
+	Messages      []AgentMessage `json:"messages"`
+	PromptVersion string         `json:"prompt_version"`
+	Host          string         `json:"host"`
+	Temperature   float64        `json:"temperature"`
+	Response      string         `json:"response"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+// RunUsage summarizes one finished dataset-mode run for usage telemetry. It holds counts
+// only: test types come from the action signature's fixed vocabulary, never from data.
+type RunUsage struct {
+	Turns           int
+	Executions      int
+	ExecutionErrors int
+	// Executed analyses by test type (e.g. "ttest_ind", "chi2"); unclassified cells are not counted
+	Tests map[string]int
+}
+
+// StepBookmark marks an executed step of a session's analysis so the Python state can
+// be restored to that point. MessageID is the tool message that recorded the step's output.
+type StepBookmark struct {
+	ID        uuid.UUID `json:"id"`
+	SessionID uuid.UUID `json:"session_id"`
+	MessageID uuid.UUID `json:"message_id"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MemoryCheckpoint is a named snapshot of a session's memory: the IDs of its RAG documents
+// and the content of its state cards at the time, which are updated in place afterwards.
+type MemoryCheckpoint struct {
+	ID             uuid.UUID `json:"id"`
+	SessionID      uuid.UUID `json:"session_id"`
+	Name           string    `json:"name"`
+	DocumentCount  int       `json:"document_count"`
+	StateCardCount int       `json:"state_card_count"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// CheckpointDocument is one RAG document as recorded in a checkpoint. Kind is the
+// document's type (state, rollup) or else its role (fact, user, tool, ...). StateContent
+// holds a state card's content at checkpoint time and is empty for other documents.
+type CheckpointDocument struct {
+	DocumentID   uuid.UUID `json:"document_id"`
+	Kind         string    `json:"kind"`
+	ContentHash  string    `json:"content_hash"`
+	StateContent string    `json:"state_content,omitempty"`
+}
+
+// MemoryCheckpointDiff is what session memory gained and lost between two checkpoints (or a
+// checkpoint and the current memory). Added and Removed count documents per kind; Learned
+// lists the added facts, rollups and annotations, and StateChanges the state cards that
+// appeared, changed or disappeared.
+type MemoryCheckpointDiff struct {
+	From         string            `json:"from"`
+	To           string            `json:"to"`
+	Added        map[string]int    `json:"added"`
+	Removed      map[string]int    `json:"removed"`
+	Learned      []MemoryDiffEntry `json:"learned"`
+	Forgotten    []MemoryDiffEntry `json:"forgotten"`
+	StateChanges []StateCardChange `json:"state_changes"`
+}
+
+// MemoryDiffEntry is one document in a checkpoint diff.
+type MemoryDiffEntry struct {
+	DocumentID uuid.UUID `json:"document_id"`
+	Kind       string    `json:"kind"`
+	Content    string    `json:"content"`
+}
+
+// StateCardChange is a state card that differs between two checkpoints. Before is empty
+// for a new card and After for a removed one.
+type StateCardChange struct {
+	DocumentID uuid.UUID `json:"document_id"`
+	Before     string    `json:"before,omitempty"`
+	After      string    `json:"after,omitempty"`
+}
+
+// Admin job kinds.
+const (
+	AdminJobArchiveSessions  = "archive_sessions"
+	AdminJobDeleteSessions   = "delete_sessions"
+	AdminJobReingestSessions = "reingest_sessions"
+	AdminJobRehashMessages   = "rehash_messages"
+	AdminJobExportUsage      = "export_usage"
+)
+
+// Admin job statuses. Pending and running jobs are resumed at startup.
+const (
+	AdminJobPending   = "pending"
+	AdminJobRunning   = "running"
+	AdminJobDone      = "done"
+	AdminJobFailed    = "failed"
+	AdminJobCancelled = "cancelled"
+)
+
+// AdminJobParams selects the sessions a bulk admin job works on. Archive and delete jobs
+// take the user's sessions last active before Before; reingest jobs take SessionIDs;
+// rehash jobs take SessionIDs or, when empty, every session.
+type AdminJobParams struct {
+	UserID     *uuid.UUID  `json:"user_id,omitempty"`
+	Before     *time.Time  `json:"before,omitempty"`
+	SessionIDs []uuid.UUID `json:"session_ids,omitempty"`
+}
+
+// AdminJob is a bulk admin operation run in the background. Cursor is the last session
+// it finished, so a job interrupted by a restart resumes after it. Result holds the
+// export file of an export job.
+type AdminJob struct {
+	ID        uuid.UUID      `json:"id"`
+	Kind      string         `json:"kind"`
+	Params    AdminJobParams `json:"params"`
+	Status    string         `json:"status"`
+	Cursor    string         `json:"cursor,omitempty"`
+	Processed int            `json:"processed"`
+	Failed    int            `json:"failed"`
+	Error     string         `json:"error,omitempty"`
+	Result    string         `json:"result,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// Analysis job statuses. Running jobs left over from a restart are marked failed.
+const (
+	AnalysisJobRunning   = "running"
+	AnalysisJobDone      = "done"
+	AnalysisJobFailed    = "failed"
+	AnalysisJobCancelled = "cancelled"
+)
+
+// AnalysisJob is an agent turn run in the background, detached from the request that
+// started it. Events holds the turn's stream events, one JSON object per line, so a
+// client can replay them after the job has finished.
+type AnalysisJob struct {
+	ID            uuid.UUID  `json:"id"`
+	SessionID     uuid.UUID  `json:"session_id"`
+	UserMessageID string     `json:"user_message_id"`
+	Status        string     `json:"status"`
+	Error         string     `json:"error,omitempty"`
+	Events        string     `json:"-"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// ActiveRun is a session's agent run as registered for every replica to see. Token
+// identifies the run; a newer run of the session replaces the row and its token.
+type ActiveRun struct {
+	SessionID     uuid.UUID
+	UserMessageID string
+	Token         string
+	InstanceID    string
+	HeartbeatAt   time.Time
+}
+
+// SessionUsage is one session's row in the usage export.
+type SessionUsage struct {
+	SessionID         uuid.UUID
+	UserID            *uuid.UUID
+	Mode              string
+	CreatedAt         time.Time
+	LastActive        time.Time
+	IsActive          bool
+	UserMessages      int
+	AssistantMessages int
+	ToolMessages      int
+	Files             int
+	Documents         int
+}
+
+// MessageHashUpdate is a message whose content hash changed under the current normalization.
+type MessageHashUpdate struct {
+	MessageID string
+	OldHash   string
+	NewHash   string
+}
+
+// MemoryCitation is a memory entry an assistant message cited, shown as a footnote.
+// Excerpt is the entry as the model saw it in the <memory> block; Context is the stored
+// document it came from.
+type MemoryCitation struct {
+	// Tag is N of the entry's [mN] tag; Footnote its number under the answer
+	Tag        int    `json:"tag"`
+	Footnote   int    `json:"footnote"`
+	DocumentID string `json:"document_id"`
+	Role       string `json:"role"`
+	Excerpt    string `json:"excerpt"`
+	Context    string `json:"context"`
+}
+
+// ExecutedStep is one executed code block of a session, numbered like the methods pack,
+// with its bookmark if it has one.
+type ExecutedStep struct {
+	Number     int           `json:"number"`
+	MessageID  string        `json:"message_id"`
+	Code       string        `json:"code"`
+	Failed     bool          `json:"failed"`
+	UserEdited bool          `json:"user_edited"`
+	ExecutedAt time.Time     `json:"executed_at"`
+	Bookmark   *StepBookmark `json:"bookmark,omitempty"`
+}
+
+// MessageAnnotation is a user's private note on a message (an executed step's output is a
+// tool message). RAGDocumentID is set when the note was also added to session memory.
+type MessageAnnotation struct {
+	ID            uuid.UUID  `json:"id"`
+	SessionID     uuid.UUID  `json:"session_id"`
+	MessageID     uuid.UUID  `json:"message_id"`
+	Note          string     `json:"note"`
+	RAGDocumentID *uuid.UUID `json:"rag_document_id,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// MemoryPin is a fact the user pinned to session memory ("always use alpha=0.01"). Pins
+// are prepended to every memory block whatever their retrieval score.
+type MemoryPin struct {
+	ID        uuid.UUID `json:"id"`
+	SessionID uuid.UUID `json:"session_id"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SessionReport is a session assembled for sharing with collaborators: the conversation
+// in order with its executed code, tool outputs and generated figures.
+type SessionReport struct {
+	SessionID   string
+	Title       string
+	GeneratedAt time.Time
+	LLMModel    string
+	RandomSeed  *int64
+	Entries     []ReportEntry
+}
+
+// ReportEntry is one message of a session report.
+type ReportEntry struct {
+	Role      string // user, assistant or tool
+	CreatedAt time.Time
+	HTML      string // user or assistant text rendered from Markdown, code blocks included
+	Output    string // tool output without its warnings
+	Warnings  []string
+	Figures   []ReportFigure
+	Files     []string // names of other generated files (tables, PDFs)
+	Notes     []MessageAnnotation
+}
+
+// ReportFigure is an image embedded in a report as a data URI, so the file stands alone.
+type ReportFigure struct {
+	Name    string
+	Alt     string
+	DataURI string
+}